@@ -96,8 +96,14 @@ build_images() {
     
     for service in "${services[@]}"; do
         log_info "Building $service..."
+        # Go services build from ./services so they can use the shared nexus-common module
+        context=./services/$service
+        if [ -f ./services/$service/go.mod ]; then
+            context=./services
+        fi
         docker build -t $DOCKER_REGISTRY/$service:$VERSION \
-            ./services/$service \
+            -f ./services/$service/Dockerfile \
+            $context \
             --quiet || {
                 log_error "Failed to build $service"
                 exit 1
@@ -51,7 +51,7 @@ kubectl apply -f k8s/configmap.yaml
 # Build and push Docker images
 echo -e "${GREEN}Building Docker images...${NC}"
 echo "Building API Gateway..."
-docker build -t $REGISTRY/api-gateway:$VERSION ./services/api-gateway
+docker build -t $REGISTRY/api-gateway:$VERSION -f ./services/api-gateway/Dockerfile ./services
 docker push $REGISTRY/api-gateway:$VERSION
 
 echo "Building Auth Service..."
@@ -63,7 +63,7 @@ docker build -t $REGISTRY/user-service:$VERSION ./services/user-service
 docker push $REGISTRY/user-service:$VERSION
 
 echo "Building Analytics Service..."
-docker build -t $REGISTRY/analytics-service:$VERSION ./services/analytics-service
+docker build -t $REGISTRY/analytics-service:$VERSION -f ./services/analytics-service/Dockerfile ./services
 docker push $REGISTRY/analytics-service:$VERSION
 
 echo "Building Scraping Service..."
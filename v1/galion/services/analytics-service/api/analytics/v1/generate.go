@@ -0,0 +1,5 @@
+package analyticsv1
+
+// query.pb.go and query_grpc.pb.go are generated from query.proto; run go generate ./api/... after
+// changing it, with protoc, protoc-gen-go v1.31.0 and protoc-gen-go-grpc v1.3.0 on the PATH
+//go:generate protoc -I ../.. --go_out=paths=source_relative:../.. --go-grpc_out=paths=source_relative:../.. analytics/v1/query.proto
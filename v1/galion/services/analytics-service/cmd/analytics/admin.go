@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"nexus-analytics-service/internal/tenantkeys"
+
+	"nexus-common/logger"
+)
+
+// adminKeyHeader carries ADMIN_API_KEY on requests to admin endpoints
+const adminKeyHeader = "X-Admin-Key"
+
+// tenantKeyHeader carries a tenant's key of TENANT_API_KEYS on requests to the analytics endpoints
+const tenantKeyHeader = "X-API-Key"
+
+// adminAuth only lets requests with the admin API key through to next
+// Without a key the endpoint isn't served at all
+func adminAuth(apiKey string, log *logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if apiKey == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(adminKeyHeader)), []byte(apiKey)) != 1 {
+			log.Warn("Rejected admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized","message":"missing or invalid admin key"}`))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tenantAuth lets requests with the admin API key, or with a tenant's key, through to next
+// A tenant's key only reads that tenant's analytics: ?tenant= is set to its tenant before next sees
+// it, and requests naming another tenant are refused; the admin key reads any tenant's
+// Without either kind of key the endpoint isn't served at all
+func tenantAuth(apiKey string, tenantKeys map[string]string, log *logger.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if apiKey == "" && len(tenantKeys) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found"}`))
+			return
+		}
+		if apiKey != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get(adminKeyHeader)), []byte(apiKey)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenantID, ok := tenantkeys.Tenant(tenantKeys, r.Header.Get(tenantKeyHeader))
+		if !ok {
+			log.Warn("Rejected analytics request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"unauthorized","message":"missing or invalid API key"}`))
+			return
+		}
+		query := r.URL.Query()
+		if named, ok := query["tenant"]; ok && (len(named) != 1 || named[0] != tenantID) {
+			log.Warn("Rejected analytics request %s %s from %s for another tenant", r.Method, r.URL.Path, r.RemoteAddr)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"forbidden","message":"the API key is for another tenant"}`))
+			return
+		}
+		query.Set("tenant", tenantID)
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"nexus-analytics-service/internal/config"
+	"nexus-analytics-service/internal/erasure"
+	"nexus-analytics-service/internal/search"
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// runErase implements the erase command, which deletes or anonymizes a user's events as the
+// DELETE /users/{user_id}/events endpoint does, printing the receipt as JSON; it returns the exit
+// code. Service settings come from the environment and config file as usual, or as flags after --
+func runErase(args []string) int {
+	fs := flag.NewFlagSet("analytics erase", flag.ContinueOnError)
+	mode := fs.String("mode", storage.ErasureDelete, "How to erase: delete, or anonymize to keep the events for counts under a pseudonym")
+	table := fs.String("table", storage.DefaultTable, "Schema-qualified events table to erase the user's events from")
+	tenant := fs.String("tenant", "", "Tenant the user belongs to; empty for events stored without a tenant")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: analytics erase USER_ID [flags] [-- service flags]\n")
+		fs.PrintDefaults()
+	}
+	var userID string
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		userID, args = args[0], args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if userID == "" {
+		fmt.Fprintf(os.Stderr, "Invalid erase: a user ID is required\n")
+		fs.Usage()
+		return 2
+	}
+	if err := storage.ValidateTable(*table); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid erase: %v\n", err)
+		return 2
+	}
+
+	cfg := loadConfig(fs.Args())
+	log := logger.New(cfg.Debug)
+	defer log.Close()
+	sink, err := openSink(cfg, *table, false)
+	if err != nil {
+		log.Error("Failed to initialize event store: %v", err)
+		return 1
+	}
+	defer sink.Close()
+	erasable, ok := sink.(storage.Erasable)
+	if !ok {
+		log.Error("Erasing users isn't supported by the %s backend", cfg.StorageBackend)
+		return 1
+	}
+
+	// The compliance log names who ran the command
+	requestedBy := "command line"
+	if current, err := user.Current(); err == nil {
+		requestedBy = "command line (" + current.Username + ")"
+	}
+	// The user's events are erased from the search indices too, as the service does
+	var indexer *search.Indexer
+	if cfg.SearchURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		indexer, err = search.New(ctx, searchConfig(cfg), log)
+		cancel()
+		if err != nil {
+			log.Error("Failed to connect to the search cluster: %v", err)
+			return 1
+		}
+		defer indexer.Close()
+	}
+	receipt, err := newEraser(cfg, erasable, indexer, log).Erase(context.Background(), *tenant, userID, *mode, requestedBy)
+	if errors.Is(err, erasure.ErrInvalid) {
+		fmt.Fprintf(os.Stderr, "Invalid erase: %v\n", err)
+		return 2
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(receipt)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// newEraser creates the eraser of users' events in erasable and, with indexer, in the search indices
+// The BigQuery table is only listed in receipts: rows still in its streaming buffer, the last half
+// hour or more, can't be deleted with DML, so the data team erases them there
+func newEraser(cfg *config.Config, erasable storage.Erasable, indexer *search.Indexer, log *logger.Logger) *erasure.Eraser {
+	eraser := erasure.New(erasable, userIDHash(cfg), log)
+	if indexer != nil {
+		eraser.AlsoErase("search:"+cfg.SearchIndexPrefix+"-*", indexer)
+	}
+	if cfg.BigQueryProject != "" {
+		eraser.NotErased("bigquery:" + cfg.BigQueryProject + "." + cfg.BigQueryDataset + "." + cfg.BigQueryTable)
+	}
+	return eraser
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// runMigrate implements the migrate command, which applies the PostgreSQL schema migrations and
+// lays the table out as configured, shows the schema version, or sets it after a failed migration
+// was dealt with by hand; it returns the exit code. Service settings come from the environment and
+// config file as usual, or as flags after --
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("analytics migrate", flag.ContinueOnError)
+	table := fs.String("table", storage.DefaultTable, "Schema-qualified events table to migrate")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: analytics migrate [up | version | force VERSION] [flags] [-- service flags]\n")
+		fs.PrintDefaults()
+	}
+	command := "up"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+	var force int
+	if command == "force" {
+		version := -1
+		if len(args) > 0 {
+			version, _ = strconv.Atoi(args[0])
+			args = args[1:]
+		}
+		if version < 0 || version > storage.SchemaVersion() {
+			fmt.Fprintf(os.Stderr, "Invalid migrate: force needs a version from 0 to %d\n", storage.SchemaVersion())
+			return 2
+		}
+		force = version
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if command != "up" && command != "version" && command != "force" {
+		fmt.Fprintf(os.Stderr, "Invalid migrate: unknown command %q\n", command)
+		fs.Usage()
+		return 2
+	}
+	if err := storage.ValidateTable(*table); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid migrate: %v\n", err)
+		return 2
+	}
+
+	cfg := loadConfig(fs.Args())
+	log := logger.New(cfg.Debug)
+	defer log.Close()
+	if cfg.StorageBackend != storage.BackendPostgres {
+		log.Error("Migrations are for PostgreSQL; %s tables are created when the service starts", cfg.StorageBackend)
+		return 1
+	}
+
+	// Partitioning and TimescaleDB are laid out as the service is configured
+	migrator, err := storage.NewMigrator(sinkConfig(cfg, *table, false))
+	if err != nil {
+		log.Error("Failed to connect to the database: %v", err)
+		return 1
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	switch command {
+	case "version":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			log.Error("%v", err)
+			return 1
+		}
+		state := ""
+		if dirty {
+			state = " (dirty: a migration failed partway)"
+		}
+		fmt.Printf("%s is at schema version %d%s; this build's is %d\n", *table, version, state, storage.SchemaVersion())
+	case "force":
+		if err := migrator.Force(ctx, force); err != nil {
+			log.Error("%v", err)
+			return 1
+		}
+		log.Info("Set the schema version of %s to %d", *table, force)
+	default:
+		applied, err := migrator.Up(ctx)
+		for _, name := range applied {
+			log.Info("Applied migration %s to %s", name, *table)
+		}
+		if err == nil {
+			err = migrator.Verify(ctx)
+		}
+		if err != nil {
+			log.Error("%v", err)
+			return 1
+		}
+		log.Info("%s is at schema version %d", *table, storage.SchemaVersion())
+	}
+	return 0
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/activeusers"
+	"nexus-analytics-service/internal/bigquery"
+	"nexus-analytics-service/internal/config"
+	"nexus-analytics-service/internal/consumer"
+	"nexus-analytics-service/internal/dedup"
+	"nexus-analytics-service/internal/enrichment"
+	"nexus-analytics-service/internal/live"
+	"nexus-analytics-service/internal/privacy"
+	"nexus-analytics-service/internal/schemaregistry"
+	"nexus-analytics-service/internal/search"
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/internal/validation"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// pipeline takes consumed events through validation, deduplication, enrichment and PII removal, then
+// buffers them for storage
+// The service and replays share it, so replayed events are stored as they would have been
+type pipeline struct {
+	validator    *validation.Validator
+	deduplicator *dedup.Deduplicator // optional
+	enrichers    *enrichment.Pipeline
+	pii          *privacy.Transform
+	writer       *storage.BatchWriter
+	search       *search.Indexer      // optional
+	warehouse    *bigquery.Exporter   // optional
+	activeUsers  *activeusers.Tracker // optional
+	live         *live.Hub            // optional
+	logger       *logger.Logger
+
+	tenantTopics   map[string]string // tenants of topics whose events all belong to one
+	tenantRequired bool
+}
+
+// newPipeline loads the configured validation, enrichment and PII steps; the caller adds the writer
+// and, optionally, a deduplicator
+func newPipeline(cfg *config.Config, log *logger.Logger) (*pipeline, error) {
+	p := &pipeline{logger: log, tenantTopics: cfg.TenantTopics, tenantRequired: cfg.TenantRequired}
+
+	// Optionally reject events that break the schemas in EVENT_SCHEMA_FILE
+	if cfg.EventSchemaFile != "" {
+		validator, err := validation.Load(cfg.EventSchemaFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load event schemas: %w", err)
+		}
+		p.validator = validator
+		log.Info("Validating events against %s", cfg.EventSchemaFile)
+	}
+
+	// Add fields derived from the IP address, user agent and referrer producers send
+	if len(cfg.Enrichers) > 0 {
+		enrichers, err := enrichment.Build(cfg.Enrichers, enrichment.Options{GeoIPDatabase: cfg.GeoIPDatabase})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize enrichment: %w", err)
+		}
+		p.enrichers = enrichers
+		log.Info("Enriching events with %s", strings.Join(cfg.Enrichers, ", "))
+	}
+
+	// Hash and strip personal data, after enrichment has used it
+	if len(cfg.PIIHashFields) > 0 || len(cfg.PIIRedactFields) > 0 {
+		p.pii = privacy.New(privacy.Config{
+			HashKey:      []byte(cfg.PIIHashKey),
+			HashFields:   cfg.PIIHashFields,
+			RedactFields: cfg.PIIRedactFields,
+		})
+		log.Info("Hashing %v and removing %v from events", cfg.PIIHashFields, cfg.PIIRedactFields)
+	}
+	return p, nil
+}
+
+// handle is the handler for user events
+func (p *pipeline) handle(event *consumer.Event, source consumer.Source, done func(error)) {
+	log := p.logger
+	log.Debug("Received event: %s from %s (user: %s)", event.EventType, event.Service, event.UserID)
+
+	tenantID, err := p.tenant(event, source.Topic)
+	if err != nil {
+		metrics.RecordProcessingError(event.EventType, "invalid_tenant")
+		done(consumer.Invalid(fmt.Errorf("invalid event: %w", err)))
+		return
+	}
+
+	if p.validator != nil {
+		if violations := p.validator.Validate(event); len(violations) > 0 {
+			messages := make([]string, len(violations))
+			for i, violation := range violations {
+				// Unknown types aren't used as labels, which would let producers add series at will
+				eventType := event.EventType
+				if violation.Rule == validation.RuleUnknownEventType {
+					eventType = "unknown"
+				}
+				metrics.RecordValidationViolation(violation.Rule, eventType)
+				messages[i] = violation.Message
+			}
+			done(consumer.Invalid(fmt.Errorf("invalid event: %s", strings.Join(messages, "; "))))
+			return
+		}
+	}
+
+	if p.deduplicator != nil && event.EventID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		duplicate, err := p.deduplicator.Duplicate(ctx, event.EventID, source.String())
+		cancel()
+		if err != nil {
+			// Storing a duplicate beats losing an event, so a Redis outage lets events through
+			log.Warn("Failed to check event %s for duplicates: %v", event.EventID, err)
+		} else if duplicate {
+			metrics.RecordDuplicateDropped(event.EventType)
+			log.Debug("Dropped duplicate event %s (%s)", event.EventID, event.EventType)
+			done(nil)
+			return
+		}
+	}
+
+	if p.enrichers != nil {
+		for _, name := range p.enrichers.Enrich(event) {
+			metrics.RecordEnrichment(name)
+		}
+	}
+	if p.pii != nil {
+		for _, change := range p.pii.Apply(event) {
+			metrics.RecordPIIField(change.Action, change.Field)
+		}
+	}
+
+	// Parse timestamp
+	timestamp, err := time.Parse(time.RFC3339, event.Timestamp)
+	if err != nil {
+		log.Warn("Failed to parse timestamp: %v", err)
+		timestamp = time.Now()
+	}
+
+	// Buffer event for the next batch
+	stored := storage.Event{
+		EventType: event.EventType,
+		UserID:    event.UserID,
+		Service:   event.Service,
+		Timestamp: timestamp,
+		Data:      event.Data,
+		Topic:     source.Topic,
+		Partition: source.Partition,
+		Offset:    source.Offset,
+		TenantID:  tenantID,
+	}
+	p.writer.Write(stored, func(err error) {
+		if err != nil {
+			metrics.RecordProcessingError(event.EventType, "storage_error")
+			if !storage.IsTransient(err) {
+				// The database rejected the event itself, so it is dead-lettered without retrying
+				err = consumer.Permanent(err)
+			}
+			done(err)
+			return
+		}
+
+		// Update metrics
+		metrics.RecordEventProcessed(event.EventType, event.Service)
+		// Only stored events are searched, exported, counted as active and shown live; none of these
+		// blocks the writer
+		if p.search != nil {
+			p.search.Index(stored)
+		}
+		if p.warehouse != nil {
+			p.warehouse.Export(stored)
+		}
+		if p.activeUsers != nil {
+			p.activeUsers.Observe(stored.TenantID, stored.UserID, stored.Timestamp)
+		}
+		if p.live != nil {
+			p.live.Publish(stored)
+		}
+
+		log.Debug("Processed event: %s (user: %s)", event.EventType, event.UserID)
+		done(nil)
+	})
+}
+
+// tenant is the tenant an event is stored under: its topic's under TENANT_BY_TOPIC, which events
+// can't claim another one on, or else the tenant_id it carries
+func (p *pipeline) tenant(event *consumer.Event, topic string) (string, error) {
+	tenantID, ok := p.tenantTopics[topic]
+	switch {
+	case ok && event.TenantID != "" && event.TenantID != tenantID:
+		return "", fmt.Errorf("tenant_id %q on topic %s of tenant %q", event.TenantID, topic, tenantID)
+	case ok:
+		return tenantID, nil
+	case event.TenantID == "" && p.tenantRequired:
+		return "", errors.New("tenant_id is required")
+	case len(event.TenantID) > storage.MaxTenantIDLength:
+		return "", fmt.Errorf("tenant_id must be at most %d characters", storage.MaxTenantIDLength)
+	}
+	return event.TenantID, nil
+}
+
+// openSink connects to the configured storage backend, storing events in table (the default if empty)
+func openSink(cfg *config.Config, table string, overwrite bool) (storage.EventSink, error) {
+	return storage.Open(cfg.StorageBackend, sinkConfig(cfg, table, overwrite))
+}
+
+// sinkConfig is the configured storage of events in table
+func sinkConfig(cfg *config.Config, table string, overwrite bool) storage.SinkConfig {
+	return storage.SinkConfig{
+		URL:       cfg.DatabaseURL,
+		Table:     table,
+		Overwrite: overwrite,
+		Method:    cfg.EventWriteMethod,
+		TTL:       cfg.ClickHouseTTL,
+		Timescale: storage.Timescale{
+			Mode:          cfg.Timescale,
+			ChunkInterval: cfg.TimescaleChunk,
+			CompressAfter: cfg.TimescaleCompress,
+		},
+		Partitioning: storage.Partitioning{
+			Enabled: cfg.PartitionByMonth,
+			Ahead:   cfg.PartitionAhead,
+			Keep:    cfg.PartitionKeep,
+			Tenants: cfg.PartitionTenants,
+		},
+		// Replay tables are created when needed, whatever the service's own table does
+		Migrate: cfg.MigrateOnStartup || table != "",
+	}
+}
+
+// userIDHash is how user IDs are hashed before they are stored, nil if they aren't
+func userIDHash(cfg *config.Config) func(string) string {
+	if !slices.Contains(cfg.PIIHashFields, privacy.UserIDField) {
+		return nil
+	}
+	return privacy.New(privacy.Config{HashKey: []byte(cfg.PIIHashKey)}).Hash
+}
+
+// searchConfig is how stored events are indexed for search
+func searchConfig(cfg *config.Config) search.Config {
+	return search.Config{
+		URL:           cfg.SearchURL,
+		Prefix:        cfg.SearchIndexPrefix,
+		Retention:     cfg.SearchRetention,
+		QueueSize:     cfg.SearchQueueSize,
+		BatchSize:     cfg.SearchBatchSize,
+		FlushInterval: cfg.SearchFlushInterval,
+	}
+}
+
+// newBatchWriter starts a writer saving to sink with the configured batching and retries
+func newBatchWriter(cfg *config.Config, sink storage.EventSink, log *logger.Logger) *storage.BatchWriter {
+	// Events are saved in multi-row batches, once enough are buffered or the interval passes
+	return storage.NewBatchWriter(sink, storage.BatchConfig{
+		Size:     cfg.EventBatchSize,
+		Interval: cfg.EventBatchInterval,
+
+		RetryAttempts: cfg.DatabaseRetryAttempts,
+		RetryBase:     cfg.DatabaseRetryBase,
+		RetryMax:      cfg.DatabaseRetryMax,
+	}, log)
+}
+
+// consumerConfig is the Kafka consumer's configuration; flush saves what the batch writer buffered
+func consumerConfig(cfg *config.Config, flush func()) consumer.Config {
+	// Avro messages are decoded with schemas from the registry; JSON messages need none
+	var schemas *schemaregistry.Client
+	if cfg.SchemaRegistryURL != "" {
+		schemas = schemaregistry.New(cfg.SchemaRegistryURL, cfg.SchemaRegistryTimeout)
+	}
+
+	return consumer.Config{
+		Client:            cfg.KafkaClient,
+		Brokers:           strings.Join(cfg.KafkaBrokers, ","),
+		GroupID:           cfg.KafkaGroupID,
+		AutoOffsetReset:   cfg.KafkaAutoOffsetReset,
+		SessionTimeout:    cfg.KafkaSessionTimeout,
+		HeartbeatInterval: cfg.KafkaHeartbeatInterval,
+		MaxPollInterval:   cfg.KafkaMaxPollInterval,
+		CommitInterval:    cfg.KafkaCommitInterval,
+		LagInterval:       cfg.KafkaLagInterval,
+		MaxEventBytes:     cfg.EventMaxBytes,
+		OversizedPolicy:   cfg.OversizedEventPolicy,
+		Workers:           cfg.ConsumerWorkers,
+		MaxAttempts:       cfg.EventMaxAttempts,
+		RetryBackoff:      cfg.EventRetryBackoff,
+		DeadLetterTopic:   cfg.DeadLetterTopic,
+		SchemaRegistry:    schemas,
+		Flush:             flush,
+		Security: consumer.Security{
+			Protocol:      cfg.KafkaSecurityProtocol,
+			SASLMechanism: cfg.KafkaSASLMechanism,
+			SASLUsername:  cfg.KafkaSASLUsername,
+			SASLPassword:  cfg.KafkaSASLPassword,
+			CAFile:        cfg.KafkaTLSCAFile,
+			CertFile:      cfg.KafkaTLSCertFile,
+			KeyFile:       cfg.KafkaTLSKeyFile,
+			KeyPassword:   cfg.KafkaTLSKeyPassword,
+		},
+	}
+}
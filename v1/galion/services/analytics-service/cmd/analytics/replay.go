@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/consumer"
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+	"nexus-common/shutdown"
+)
+
+// defaultReplayTable keeps replayed events apart from the ones the service stores unless asked otherwise
+const defaultReplayTable = "analytics.events_replay"
+
+// runReplay implements the replay command, reading a range of a topic again into a table through
+// the same validation, enrichment and PII steps as the service; it returns the exit code
+// Service settings come from the environment and config file as usual, or as flags after --
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("analytics replay", flag.ContinueOnError)
+	topic := fs.String("topic", "", "Topic to replay (required)")
+	from := fs.String("from", "", "Replay from the first message at or after this RFC 3339 time")
+	fromOffset := fs.Int64("from-offset", -1, "Replay from this offset of every partition (instead of --from)")
+	to := fs.String("to", "", "Stop before the first message at or after this RFC 3339 time; default: the end of each partition")
+	partitionList := fs.String("partitions", "", "Partitions to replay (comma-separated); default: all")
+	table := fs.String("table", defaultReplayTable, "Schema-qualified table replayed events are stored in, created if missing")
+	overwrite := fs.Bool("overwrite", false, "Replace events already in the table from the same topic, partition and offset instead of keeping them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: analytics replay --topic TOPIC (--from TIME | --from-offset N) [flags] [-- service flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	opts, err := replayOptions(*topic, *from, *fromOffset, *to, *partitionList)
+	if err == nil {
+		err = storage.ValidateTable(*table)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid replay: %v\n", err)
+		return 2
+	}
+
+	cfg := loadConfig(fs.Args())
+	log := logger.New(cfg.Debug)
+	defer log.Close()
+	for _, warning := range cfg.Warnings {
+		log.Warn("Configuration: %s", warning)
+	}
+
+	log.Info("Connecting to %s...", cfg.StorageBackend)
+	sink, err := openSink(cfg, *table, *overwrite)
+	if err != nil {
+		log.Error("Failed to initialize event store: %v", err)
+		return 1
+	}
+	defer sink.Close()
+
+	batchWriter := newBatchWriter(cfg, sink, log)
+	defer batchWriter.Close()
+
+	// Replays skip deduplication: every event they read was seen before
+	events, err := newPipeline(cfg, log)
+	if err != nil {
+		log.Error("Failed to initialize event pipeline: %v", err)
+		return 1
+	}
+	events.writer = batchWriter
+
+	// Stop reading on SIGINT or SIGTERM; what was read is still saved
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-shutdown.Notify()
+		log.Info("Stopping replay...")
+		cancel()
+	}()
+
+	log.Info("Replaying %s into %s", opts.Topic, *table)
+	started := time.Now()
+	stats, err := consumer.Replay(ctx, consumerConfig(cfg, batchWriter.Flush), opts, consumer.Typed(events.handle), log)
+	log.Info("Replay of %s read %d messages in %s: %d handled, %d failed", opts.Topic, stats.Read, time.Since(started).Round(time.Second), stats.Handled, stats.Failed)
+	if err != nil {
+		log.Error("Replay stopped: %v", err)
+		return 1
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// replayOptions checks the replay flags and turns them into consumer.ReplayOptions
+func replayOptions(topic, from string, fromOffset int64, to, partitionList string) (consumer.ReplayOptions, error) {
+	opts := consumer.ReplayOptions{Topic: topic, FromOffset: fromOffset}
+	if topic == "" {
+		return opts, errors.New("--topic is required")
+	}
+	switch {
+	case from == "" && fromOffset < 0:
+		return opts, errors.New("--from or --from-offset is required")
+	case from != "" && fromOffset >= 0:
+		return opts, errors.New("--from and --from-offset can't be used together")
+	case from != "":
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return opts, fmt.Errorf("--from: %w", err)
+		}
+		opts.From = t
+	}
+	if to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return opts, fmt.Errorf("--to: %w", err)
+		}
+		if !opts.From.IsZero() && !t.After(opts.From) {
+			return opts, errors.New("--to must be after --from")
+		}
+		opts.To = t
+	}
+	if partitionList != "" {
+		for _, s := range strings.Split(partitionList, ",") {
+			partition, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+			if err != nil || partition < 0 {
+				return opts, fmt.Errorf("--partitions: %q is not a partition number", s)
+			}
+			opts.Partitions = append(opts.Partitions, int32(partition))
+		}
+	}
+	return opts, nil
+}
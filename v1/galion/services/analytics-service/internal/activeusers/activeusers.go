@@ -0,0 +1,341 @@
+// Package activeusers counts distinct active users per hour and per day in Redis HyperLogLogs,
+// shared by every replica, and serves the counts, and the daily, weekly and monthly users they
+// add up to; each count is an estimate within about 1%
+package activeusers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// keyPrefix namespaces the HyperLogLogs
+const keyPrefix = "analytics:active:"
+
+// Periods counts are kept for
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+// How long each kind of HyperLogLog is kept; daily ones for the configured retention
+const (
+	minuteRetention = 2 * time.Hour
+	hourRetention   = 7 * 24 * time.Hour
+)
+
+// How many hours and days make up the rolling daily, weekly and monthly counts, the current one
+// included
+const (
+	dailyHours  = 24
+	weeklyHours = 7 * 24
+	monthlyDays = 30
+)
+
+// flushInterval is how often users seen are added to Redis and the gauge is updated
+const flushInterval = 10 * time.Second
+
+// Tracker counts the users of stored events: it collects them in memory, and adds them to Redis
+// every flushInterval, so events don't each wait on Redis
+// Hours and days are counted per tenant, by event time in UTC, and for every tenant together, for
+// the daily, weekly and monthly gauges; the analytics_active_users gauge counts the users of every
+// tenant over the last 60 minutes, from a HyperLogLog per minute
+type Tracker struct {
+	client    redis.UniversalClient
+	retention time.Duration // of daily counts
+	logger    *logger.Logger
+
+	mu      sync.Mutex
+	pending map[string]*pending // by key
+}
+
+// pending is the users seen of a HyperLogLog, not yet added, and how long it is kept
+type pending struct {
+	users     map[string]struct{}
+	retention time.Duration
+}
+
+// New connects to redisURL, keeping daily counts for retention; it does not check the connection,
+// the first flush does
+func New(redisURL string, retention time.Duration, log *logger.Logger) (*Tracker, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return &Tracker{
+		client:    redis.NewClient(opts),
+		retention: retention,
+		logger:    log,
+		pending:   make(map[string]*pending),
+	}, nil
+}
+
+// Observe counts userID of tenantID as active at the time given; events without a user, or too old for any
+// count still kept, aren't counted
+func (t *Tracker) Observe(tenantID, userID string, at time.Time) {
+	if userID == "" {
+		return
+	}
+	age := time.Since(at)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Each is kept for a period longer than it counts, from its last update
+	if age < time.Hour {
+		t.add(minuteKey(at), minuteRetention, userID)
+	}
+	if age < hourRetention {
+		t.add(key(PeriodHour, tenantID, at), hourRetention+time.Hour, userID)
+		t.add(totalKey(PeriodHour, at), hourRetention+time.Hour, userID)
+	}
+	if age < t.retention {
+		t.add(key(PeriodDay, tenantID, at), t.retention+24*time.Hour, userID)
+		t.add(totalKey(PeriodDay, at), t.retention+24*time.Hour, userID)
+	}
+}
+
+func (t *Tracker) add(key string, retention time.Duration, userID string) {
+	p, ok := t.pending[key]
+	if !ok {
+		p = &pending{users: make(map[string]struct{}), retention: retention}
+		t.pending[key] = p
+	}
+	p.users[userID] = struct{}{}
+}
+
+// Run adds the users seen to Redis and updates the gauge every flushInterval until ctx is done;
+// the caller flushes the last ones
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.Flush(ctx); err != nil && ctx.Err() == nil {
+				t.logger.Error("Failed to count active users: %v", err)
+			}
+		}
+	}
+}
+
+// Flush adds the users seen since the last flush to Redis, in one pipeline, and updates the
+// gauges; users of a failed flush aren't counted, so counts are low while Redis is unreachable
+func (t *Tracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	seen := t.pending
+	t.pending = make(map[string]*pending)
+	t.mu.Unlock()
+
+	pipe := t.client.Pipeline()
+	for key, p := range seen {
+		members := make([]interface{}, 0, len(p.users))
+		for user := range p.users {
+			members = append(members, user)
+		}
+		pipe.PFAdd(ctx, key, members...)
+		pipe.Expire(ctx, key, p.retention)
+	}
+	minutes := make([]string, 60)
+	now := time.Now()
+	for i := range minutes {
+		minutes[i] = minuteKey(now.Add(-time.Duration(i) * time.Minute))
+	}
+	count := pipe.PFCount(ctx, minutes...)
+	rolling := rollingCounts(ctx, pipe, totalKey, now)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	metrics.UpdateActiveUsers(count.Val())
+	metrics.UpdateRollingActiveUsers(rolling[0].Val(), rolling[1].Val(), rolling[2].Val())
+	return nil
+}
+
+// Rolling is the distinct active users of the last 24 hours, 7 days and 30 days, each counting
+// the current hour or day so far
+type Rolling struct {
+	At      time.Time `json:"at"`
+	Daily   int64     `json:"daily"`
+	Weekly  int64     `json:"weekly"`
+	Monthly int64     `json:"monthly"`
+}
+
+// Rolling returns the rolling counts of tenantID
+func (t *Tracker) Rolling(ctx context.Context, tenantID string) (Rolling, error) {
+	now := time.Now().UTC()
+	pipe := t.client.Pipeline()
+	counts := rollingCounts(ctx, pipe, func(period string, at time.Time) string { return key(period, tenantID, at) }, now)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Rolling{}, err
+	}
+	return Rolling{At: now, Daily: counts[0].Val(), Weekly: counts[1].Val(), Monthly: counts[2].Val()}, nil
+}
+
+// rollingCounts queues the daily, weekly and monthly counts up to now on pipe, of the
+// HyperLogLogs keyOf names: the last 24 and 168 hours, and the last 30 days
+func rollingCounts(ctx context.Context, pipe redis.Pipeliner, keyOf func(period string, at time.Time) string, now time.Time) [3]*redis.IntCmd {
+	hours := make([]string, weeklyHours)
+	for i := range hours {
+		hours[i] = keyOf(PeriodHour, now.Add(-time.Duration(i)*time.Hour))
+	}
+	days := make([]string, monthlyDays)
+	for i := range days {
+		days[i] = keyOf(PeriodDay, now.AddDate(0, 0, -i))
+	}
+	return [3]*redis.IntCmd{pipe.PFCount(ctx, hours[:dailyHours]...), pipe.PFCount(ctx, hours...), pipe.PFCount(ctx, days...)}
+}
+
+// Count is the estimated number of distinct active users of a period starting at Start
+type Count struct {
+	Start time.Time `json:"start"`
+	Users int64     `json:"users"`
+}
+
+// Counts returns the counts of tenantID's last n hours or days, the current one first, so far
+func (t *Tracker) Counts(ctx context.Context, tenantID, period string, n int) ([]Count, error) {
+	step := time.Hour
+	if period == PeriodDay {
+		step = 24 * time.Hour
+	}
+	start := time.Now().UTC().Truncate(step)
+	pipe := t.client.Pipeline()
+	counts := make([]Count, n)
+	results := make([]*redis.IntCmd, n)
+	for i := range counts {
+		counts[i].Start = start.Add(-time.Duration(i) * step)
+		results[i] = pipe.PFCount(ctx, key(period, tenantID, counts[i].Start))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		counts[i].Users = result.Val()
+	}
+	return counts, nil
+}
+
+// maxCount is how many hours or days the API serves, as many as are kept
+func (t *Tracker) maxCount(period string) int {
+	if period == PeriodDay {
+		return int(t.retention / (24 * time.Hour))
+	}
+	return int(hourRetention / time.Hour)
+}
+
+// response is the active users API's answer
+type response struct {
+	TenantID    string  `json:"tenant_id,omitempty"`
+	Period      string  `json:"period"`
+	ActiveUsers []Count `json:"active_users"`
+}
+
+// ServeHTTP serves GET /api/v1/active-users, returning the distinct users of each of the last
+// ?count= ?period=hour or day (the default) as JSON, for the ?tenant= given, or for events without
+// a tenant; counts are never mixed across tenants
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	query := req.URL.Query()
+	period := query.Get("period")
+	n := 30
+	switch period {
+	case PeriodHour:
+		n = 24
+	case PeriodDay, "":
+		period = PeriodDay
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"period must be hour or day"}`))
+		return
+	}
+	n = min(n, t.maxCount(period))
+	if value := query.Get("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > t.maxCount(period) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"count must be between 1 and ` + strconv.Itoa(t.maxCount(period)) + ` for a ` + period + `"}`))
+			return
+		}
+		n = parsed
+	}
+
+	tenantID := query.Get("tenant")
+	counts, err := t.Counts(req.Context(), tenantID, period, n)
+	if err != nil {
+		t.logger.Error("Failed to read active users: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to read active users"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(response{TenantID: tenantID, Period: period, ActiveUsers: counts})
+}
+
+// rollingResponse is the rolling active users API's answer
+type rollingResponse struct {
+	TenantID string `json:"tenant_id,omitempty"`
+	Rolling
+}
+
+// ServeRolling serves GET /api/v1/analytics/active-users, returning the distinct users of the
+// last 24 hours, 7 days and 30 days as JSON, for the ?tenant= given, or for events without a tenant
+func (t *Tracker) ServeRolling(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	tenantID := req.URL.Query().Get("tenant")
+	rolling, err := t.Rolling(req.Context(), tenantID)
+	if err != nil {
+		t.logger.Error("Failed to read active users: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to read active users"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(rollingResponse{TenantID: tenantID, Rolling: rolling})
+}
+
+// Close closes the Redis connection
+func (t *Tracker) Close() error {
+	return t.client.Close()
+}
+
+// key is the HyperLogLog of tenantID's hour or day containing at
+func key(period, tenantID string, at time.Time) string {
+	return keyPrefix + period + ":" + tenantID + ":" + stamp(period, at)
+}
+
+// totalKey is the HyperLogLog of every tenant's hour or day containing at
+func totalKey(period string, at time.Time) string {
+	return keyPrefix + "total:" + period + ":" + stamp(period, at)
+}
+
+// stamp names the hour or day containing at
+func stamp(period string, at time.Time) string {
+	if period == PeriodDay {
+		return at.UTC().Format("20060102")
+	}
+	return at.UTC().Format("2006010215")
+}
+
+// minuteKey is the HyperLogLog of every tenant's minute containing at
+func minuteKey(at time.Time) string {
+	return keyPrefix + "minute:" + at.UTC().Format("200601021504")
+}
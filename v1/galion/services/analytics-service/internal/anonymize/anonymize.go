@@ -0,0 +1,82 @@
+// Package anonymize hashes the user IDs of events and removes their personal data once they are
+// old enough, so they can be kept for long-term analytics without identifying anyone
+package anonymize
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"nexus-analytics-service/internal/privacy"
+	"nexus-analytics-service/internal/retention"
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// ErrRunning is returned by Anonymize while this instance is already anonymizing
+var ErrRunning = errors.New("an anonymization is already running")
+
+// Anonymizer anonymizes events past their type's period on a schedule; periods are given like
+// retention periods, with 0 never anonymizing a type
+type Anonymizer struct {
+	sink   storage.Anonymizable
+	policy retention.Policy
+	hash   func(string) string
+	fields []string
+	logger *logger.Logger
+
+	running sync.Mutex
+}
+
+// New creates an anonymizer hashing user IDs with an HMAC under key, which can't be reversed by
+// guessing IDs without it, and removing fields, data keys with nested ones joined with dots
+func New(sink storage.Anonymizable, policy retention.Policy, key []byte, fields []string, log *logger.Logger) *Anonymizer {
+	hash := privacy.New(privacy.Config{HashKey: key}).Hash
+	return &Anonymizer{sink: sink, policy: policy, hash: hash, fields: fields, logger: log}
+}
+
+// Run anonymizes every interval until ctx is done, starting at once
+func (a *Anonymizer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := a.Anonymize(ctx)
+		switch {
+		case errors.Is(err, ErrRunning), errors.Is(err, storage.ErrAnonymizeRunning):
+			a.logger.Debug("Skipped anonymization: %v", err)
+		case err != nil && ctx.Err() == nil:
+			a.logger.Error("Anonymization failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Anonymize anonymizes the events past their period, returning how many per rule, by event type or
+// storage.PurgeRuleDefault
+func (a *Anonymizer) Anonymize(ctx context.Context) (map[string]int64, error) {
+	if !a.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer a.running.Unlock()
+
+	started := time.Now()
+	anonymized, err := a.sink.Anonymize(ctx, a.policy.Cutoffs(started.UTC()), a.hash, a.fields)
+	if errors.Is(err, storage.ErrAnonymizeRunning) {
+		return anonymized, err
+	}
+	for rule, count := range anonymized {
+		metrics.RecordEventsAnonymized(rule, count)
+		if count > 0 {
+			a.logger.Info("Anonymized %d %s events", count, rule)
+		}
+	}
+	metrics.RecordAnonymization(time.Since(started), err)
+	return anonymized, err
+}
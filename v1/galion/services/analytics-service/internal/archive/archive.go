@@ -0,0 +1,162 @@
+// Package archive exports aged events to S3 as Parquet files, one directory per day and event type,
+// recording each file in a manifest so archived events can be found again
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// maxFileEvents is the most events written to one file; larger days of a type are split into parts
+const maxFileEvents = 250000
+
+// ErrRunning is returned by Export while this instance is already exporting
+var ErrRunning = errors.New("an export is already running")
+
+// Exporter archives each day's events once all of them are older than a minimum age
+// Days are exported in order, so the manifest's last day marks how far archiving has got; events
+// arriving later than the minimum age for a day already archived are not exported
+type Exporter struct {
+	sink   storage.Archivable
+	bucket *Bucket
+	prefix string
+	after  time.Duration
+	logger *logger.Logger
+
+	running sync.Mutex
+}
+
+// New creates an exporter writing under prefix in bucket the events older than after
+func New(sink storage.Archivable, bucket *Bucket, prefix string, after time.Duration, log *logger.Logger) *Exporter {
+	return &Exporter{sink: sink, bucket: bucket, prefix: prefix, after: after, logger: log}
+}
+
+// Run exports every interval until ctx is done, starting at once
+func (e *Exporter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := e.Export(ctx); err != nil && ctx.Err() == nil {
+			e.logger.Error("Archiving failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ArchivedThrough returns the start of the first day not yet archived; events before it may be purged
+func (e *Exporter) ArchivedThrough(ctx context.Context) (time.Time, error) {
+	return e.sink.ArchivedThrough(ctx)
+}
+
+// Export archives every whole day older than the minimum age that isn't archived yet
+func (e *Exporter) Export(ctx context.Context) error {
+	if !e.running.TryLock() {
+		return ErrRunning
+	}
+	defer e.running.Unlock()
+
+	day, err := e.sink.ArchivedThrough(ctx)
+	if err != nil || day.IsZero() {
+		return err
+	}
+	cutoff := time.Now().UTC().Add(-e.after)
+	for ; !day.AddDate(0, 0, 1).After(cutoff); day = day.AddDate(0, 0, 1) {
+		started := time.Now()
+		files, events, err := e.exportDay(ctx, day)
+		metrics.RecordArchive(events, err)
+		if err != nil {
+			return fmt.Errorf("failed to archive %s: %w", day.Format(time.DateOnly), err)
+		}
+		if files > 0 {
+			e.logger.Info("Archived %d events of %s in %d files in %s", events, day.Format(time.DateOnly), files, time.Since(started).Round(time.Millisecond))
+		}
+	}
+	return nil
+}
+
+// exportDay writes a day's events, a file per type or per maxFileEvents of a type, then adds the
+// files to the manifest, returning how many files and events were written
+// Keys depend only on the day, type and part, so a day exported again overwrites its files
+func (e *Exporter) exportDay(ctx context.Context, day time.Time) (int, int64, error) {
+	var files []storage.ArchivedFile
+	var events int64
+	var batch []storage.StoredEvent
+	part := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		file, err := e.exportFile(ctx, day, part, batch)
+		if err != nil {
+			return err
+		}
+		files = append(files, file)
+		events += file.Events
+		batch = batch[:0]
+		return nil
+	}
+
+	err := e.sink.ScanDay(ctx, day, func(event storage.StoredEvent) error {
+		if len(batch) > 0 && event.EventType != batch[0].EventType {
+			if err := flush(); err != nil {
+				return err
+			}
+			part = 0
+		} else if len(batch) == maxFileEvents {
+			if err := flush(); err != nil {
+				return err
+			}
+			part++
+		}
+		batch = append(batch, event)
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && len(files) > 0 {
+		err = e.sink.RecordArchive(ctx, files)
+	}
+	return len(files), events, err
+}
+
+// exportFile uploads one file of events of a single type
+func (e *Exporter) exportFile(ctx context.Context, day time.Time, part int, events []storage.StoredEvent) (storage.ArchivedFile, error) {
+	eventType := events[0].EventType
+	body, err := encodeParquet(events)
+	if err != nil {
+		return storage.ArchivedFile{}, err
+	}
+	// Hive-style keys, so query engines can skip days and types
+	key := fmt.Sprintf("date=%s/event_type=%s/part-%05d.parquet", day.Format(time.DateOnly), url.PathEscape(eventType), part)
+	if e.prefix != "" {
+		key = e.prefix + "/" + key
+	}
+	location, err := e.bucket.Put(ctx, key, body, "application/vnd.apache.parquet")
+	if err != nil {
+		return storage.ArchivedFile{}, err
+	}
+	return storage.ArchivedFile{
+		Day:       day,
+		EventType: eventType,
+		Part:      part,
+		URL:       location,
+		Events:    int64(len(events)),
+		Bytes:     int64(len(body)),
+		First:     events[0].Timestamp,
+		Last:      events[len(events)-1].Timestamp,
+	}, nil
+}
@@ -0,0 +1,343 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"nexus-analytics-service/internal/storage"
+)
+
+// Just enough of Parquet to write a flat file of events: one row group, one gzipped PLAIN data
+// page per column, and nulls for optional columns as definition levels
+// See https://github.com/apache/parquet-format
+
+// parquetMagic starts and ends every file
+const parquetMagic = "PAR1"
+
+// Parquet physical types, repetition types, converted types, encodings and codecs used here
+const (
+	typeInt32     = 1
+	typeInt64     = 2
+	typeByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+	convertedJSON            = 19
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageData = 0
+)
+
+// column is a column being written: its schema, definition levels if optional, and PLAIN values
+type column struct {
+	name       string
+	kind       int32
+	converted  int32 // -1 for none
+	optional   bool
+	levels     []bool // whether each row has a value, for optional columns
+	values     bytes.Buffer
+	numValues  int
+	byteValues func(storage.StoredEvent) ([]byte, bool)
+	intValues  func(storage.StoredEvent) (int64, bool)
+}
+
+// eventColumns are the columns archived events are written with
+func eventColumns() []*column {
+	str := func(f func(storage.StoredEvent) string) func(storage.StoredEvent) ([]byte, bool) {
+		return func(e storage.StoredEvent) ([]byte, bool) { return []byte(f(e)), true }
+	}
+	sourced := func(e storage.StoredEvent) bool { return e.Topic != "" }
+	return []*column{
+		{name: "id", kind: typeInt64, converted: -1, intValues: func(e storage.StoredEvent) (int64, bool) { return e.ID, true }},
+		{name: "event_type", kind: typeByteArray, converted: convertedUTF8, byteValues: str(func(e storage.StoredEvent) string { return e.EventType })},
+		{name: "user_id", kind: typeByteArray, converted: convertedUTF8, byteValues: str(func(e storage.StoredEvent) string { return e.UserID })},
+		{name: "service", kind: typeByteArray, converted: convertedUTF8, byteValues: str(func(e storage.StoredEvent) string { return e.Service })},
+		{name: "timestamp", kind: typeInt64, converted: convertedTimestampMillis, intValues: func(e storage.StoredEvent) (int64, bool) {
+			return e.Timestamp.UnixMilli(), true
+		}},
+		{name: "data", kind: typeByteArray, converted: convertedJSON, optional: true, byteValues: func(e storage.StoredEvent) ([]byte, bool) {
+			if e.Data == nil {
+				return nil, false
+			}
+			// Data came from JSON, so it marshals again
+			data, _ := json.Marshal(e.Data)
+			return data, true
+		}},
+		{name: "topic", kind: typeByteArray, converted: convertedUTF8, optional: true, byteValues: func(e storage.StoredEvent) ([]byte, bool) {
+			return []byte(e.Topic), sourced(e)
+		}},
+		{name: "kafka_partition", kind: typeInt32, converted: -1, optional: true, intValues: func(e storage.StoredEvent) (int64, bool) {
+			return int64(e.Partition), sourced(e)
+		}},
+		{name: "kafka_offset", kind: typeInt64, converted: -1, optional: true, intValues: func(e storage.StoredEvent) (int64, bool) {
+			return e.Offset, sourced(e)
+		}},
+		{name: "tenant_id", kind: typeByteArray, converted: convertedUTF8, byteValues: str(func(e storage.StoredEvent) string { return e.TenantID })},
+		{name: "stored_at", kind: typeInt64, converted: convertedTimestampMillis, optional: true, intValues: func(e storage.StoredEvent) (int64, bool) {
+			return e.StoredAt.UnixMilli(), !e.StoredAt.IsZero()
+		}},
+	}
+}
+
+// add appends e's value to the column
+func (c *column) add(e storage.StoredEvent) {
+	c.numValues++
+	var ok bool
+	switch c.kind {
+	case typeByteArray:
+		var b []byte
+		if b, ok = c.byteValues(e); ok {
+			binary.Write(&c.values, binary.LittleEndian, uint32(len(b)))
+			c.values.Write(b)
+		}
+	case typeInt32:
+		var n int64
+		if n, ok = c.intValues(e); ok {
+			binary.Write(&c.values, binary.LittleEndian, int32(n))
+		}
+	default:
+		var n int64
+		if n, ok = c.intValues(e); ok {
+			binary.Write(&c.values, binary.LittleEndian, n)
+		}
+	}
+	if c.optional {
+		c.levels = append(c.levels, ok)
+	}
+}
+
+// page returns the column's page data: definition levels, if optional, then the values
+func (c *column) page() []byte {
+	var page bytes.Buffer
+	if c.optional {
+		levels := rleLevels(c.levels)
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+	}
+	page.Write(c.values.Bytes())
+	return page.Bytes()
+}
+
+// rleLevels encodes 1-bit definition levels as runs of the RLE/bit-packing hybrid encoding
+func rleLevels(levels []bool) []byte {
+	var out []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		if levels[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+// encodeParquet writes events as a Parquet file
+func encodeParquet(events []storage.StoredEvent) ([]byte, error) {
+	columns := eventColumns()
+	for _, event := range events {
+		for _, c := range columns {
+			c.add(event)
+		}
+	}
+
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+	chunks := make([]*thrift, len(columns))
+	var total int64
+	for i, c := range columns {
+		page := c.page()
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write(page)
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", c.name, err)
+		}
+
+		header := &thrift{}
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(compressed.Len()))
+		header.begin(5)
+		header.i32(1, int32(c.numValues))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.end()
+		header.stop()
+
+		offset := int64(file.Len())
+		file.Write(header.Bytes())
+		file.Write(compressed.Bytes())
+		uncompressedSize := int64(header.Len() + len(page))
+		compressedSize := int64(header.Len() + compressed.Len())
+		total += uncompressedSize
+
+		chunk := &thrift{}
+		chunk.i64(2, offset)
+		chunk.begin(3)
+		chunk.i32(1, c.kind)
+		chunk.listI32(2, []int32{encodingPlain, encodingRLE})
+		chunk.listString(3, []string{c.name})
+		chunk.i32(4, codecGzip)
+		chunk.i64(5, int64(c.numValues))
+		chunk.i64(6, uncompressedSize)
+		chunk.i64(7, compressedSize)
+		chunk.i64(9, offset)
+		chunk.end()
+		chunk.stop()
+		chunks[i] = chunk
+	}
+
+	meta := &thrift{}
+	meta.i32(1, 1)
+	schema := []*thrift{{}}
+	schema[0].binary(4, "event")
+	schema[0].i32(5, int32(len(columns)))
+	schema[0].stop()
+	for _, c := range columns {
+		element := &thrift{}
+		element.i32(1, c.kind)
+		repetition := int32(repetitionRequired)
+		if c.optional {
+			repetition = repetitionOptional
+		}
+		element.i32(3, repetition)
+		element.binary(4, c.name)
+		if c.converted >= 0 {
+			element.i32(6, c.converted)
+		}
+		element.stop()
+		schema = append(schema, element)
+	}
+	meta.listStruct(2, schema)
+	meta.i64(3, int64(len(events)))
+	rowGroup := &thrift{}
+	rowGroup.listStruct(1, chunks)
+	rowGroup.i64(2, total)
+	rowGroup.i64(3, int64(len(events)))
+	rowGroup.stop()
+	meta.listStruct(4, []*thrift{rowGroup})
+	meta.binary(6, "nexus-analytics-service")
+	meta.stop()
+
+	file.Write(meta.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.Len()))
+	file.WriteString(parquetMagic)
+	return file.Bytes(), nil
+}
+
+// thrift writes a struct in the Thrift compact protocol, which Parquet metadata is encoded in
+type thrift struct {
+	bytes.Buffer
+	last  int16   // previous field ID of the struct being written
+	outer []int16 // previous field IDs of the structs it is nested in
+}
+
+// Thrift compact protocol field types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// field writes a field header, as a delta from the previous field ID when it fits
+func (t *thrift) field(id int16, kind byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+// varint writes n zigzag-encoded
+func (t *thrift) varint(n int64) {
+	t.Write(binary.AppendUvarint(nil, uint64(n<<1^n>>63)))
+}
+
+func (t *thrift) i32(id int16, n int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(n))
+}
+
+func (t *thrift) i64(id int16, n int64) {
+	t.field(id, thriftI64)
+	t.varint(n)
+}
+
+func (t *thrift) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.WriteString(s)
+}
+
+// listHeader writes the header of a list of n elements of kind
+func (t *thrift) listHeader(id int16, kind byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | kind)
+		return
+	}
+	t.WriteByte(0xf0 | kind)
+	t.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (t *thrift) listI32(id int16, values []int32) {
+	t.listHeader(id, thriftI32, len(values))
+	for _, n := range values {
+		t.varint(int64(n))
+	}
+}
+
+func (t *thrift) listString(id int16, values []string) {
+	t.listHeader(id, thriftBinary, len(values))
+	for _, s := range values {
+		t.Write(binary.AppendUvarint(nil, uint64(len(s))))
+		t.WriteString(s)
+	}
+}
+
+// listStruct writes a list of structs each written, with its stop, by its own thrift
+func (t *thrift) listStruct(id int16, values []*thrift) {
+	t.listHeader(id, thriftStruct, len(values))
+	for _, v := range values {
+		t.Write(v.Bytes())
+	}
+}
+
+// begin starts a struct field, whose fields follow until end
+func (t *thrift) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.outer = append(t.outer, t.last)
+	t.last = 0
+}
+
+func (t *thrift) end() {
+	t.stop()
+	t.last = t.outer[len(t.outer)-1]
+	t.outer = t.outer[:len(t.outer)-1]
+}
+
+// stop ends a struct
+func (t *thrift) stop() {
+	t.WriteByte(0)
+}
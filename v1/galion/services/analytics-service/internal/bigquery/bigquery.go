@@ -0,0 +1,438 @@
+// Package bigquery streams stored events into a BigQuery table, so the data team can join product
+// analytics with the rest of their warehouse; like search, it is a secondary copy that never holds up
+// storage, and it is configured apart from the primary store
+package bigquery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Timeouts and backoff of requests to BigQuery
+const (
+	requestTimeout = 30 * time.Second
+	closeTimeout   = 10 * time.Second // how long Close keeps sending queued events
+	retryBase      = time.Second
+	retryMax       = time.Minute
+)
+
+// maxRequestBytes keeps streaming inserts under BigQuery's 10 MB limit on request size
+const maxRequestBytes = 9 << 20
+
+// apiURL is the BigQuery API's root
+const apiURL = "https://bigquery.googleapis.com/bigquery/v2"
+
+// Config sets where and how events are exported
+type Config struct {
+	Project         string
+	Dataset         string
+	Table           string // created, partitioned by day, if it doesn't exist
+	CredentialsFile string // service account key; empty gets tokens from the metadata server
+	QueueSize       int    // events waiting to be exported; more are dropped
+	BatchSize       int    // rows per streaming insert
+	FlushInterval   time.Duration
+}
+
+// Exporter streams stored events into a BigQuery table from a queue of its own
+// The queue is bounded: while BigQuery is unavailable or over quota, inserts are retried with
+// backoff and events that don't fit are dropped and counted, rather than holding up consumption;
+// rows carry insert IDs, so BigQuery drops most copies of redelivered events
+type Exporter struct {
+	config      Config
+	table       string // URL of the table's resource
+	api         string
+	credentials *credentials
+	client      *http.Client
+	logger      *logger.Logger
+	events      chan storage.Event
+	stop        chan struct{} // closed by Close
+	stopped     chan struct{} // closed once queued events are sent
+}
+
+// New creates an exporter, creating the table if it doesn't exist, and starts exporting; Close
+// sends what is queued
+func New(ctx context.Context, cfg Config, log *logger.Logger) (*Exporter, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	credentials, err := loadCredentials(cfg.CredentialsFile, client)
+	if err != nil {
+		return nil, err
+	}
+	e := &Exporter{
+		config:      cfg,
+		api:         apiURL,
+		credentials: credentials,
+		client:      client,
+		logger:      log,
+		events:      make(chan storage.Event, cfg.QueueSize),
+		stop:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	e.table = "/projects/" + url.PathEscape(cfg.Project) + "/datasets/" + url.PathEscape(cfg.Dataset) + "/tables/" + url.PathEscape(cfg.Table)
+	if err := e.createTable(ctx); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export queues a stored event to be exported, without waiting; it is dropped if the queue is full
+func (e *Exporter) Export(event storage.Event) {
+	select {
+	case e.events <- event:
+	default:
+		metrics.RecordBigQueryDropped("queue_full", 1)
+	}
+}
+
+// Close sends the queued events, giving up after closeTimeout, and stops the exporter; events
+// exported afterwards are never sent
+func (e *Exporter) Close() {
+	close(e.stop)
+	<-e.stopped
+	e.client.CloseIdleConnections()
+}
+
+// insertRow is a row of a streaming insert
+type insertRow struct {
+	InsertID string          `json:"insertId,omitempty"`
+	JSON     json.RawMessage `json:"json"`
+}
+
+// row is an event as exported, in the table's columns
+type row struct {
+	EventType string `json:"event_type"`
+	UserID    string `json:"user_id"`
+	Service   string `json:"service"`
+	TenantID  string `json:"tenant_id"`
+	Timestamp string `json:"timestamp"`
+	Topic     string `json:"topic,omitempty"`
+	Partition *int32 `json:"kafka_partition,omitempty"`
+	Offset    *int64 `json:"kafka_offset,omitempty"`
+	Data      string `json:"data,omitempty"` // JSON columns are inserted as JSON text
+}
+
+// newInsertRow makes the row event is inserted as
+func newInsertRow(event storage.Event) (insertRow, error) {
+	r := row{
+		EventType: event.EventType,
+		UserID:    event.UserID,
+		Service:   event.Service,
+		TenantID:  event.TenantID,
+		Timestamp: event.Timestamp.UTC().Format("2006-01-02T15:04:05.999999Z"),
+	}
+	var insertID string
+	if event.Topic != "" {
+		r.Topic, r.Partition, r.Offset = event.Topic, &event.Partition, &event.Offset
+		insertID = fmt.Sprintf("%s-%d-%d", event.Topic, event.Partition, event.Offset)
+	}
+	if len(event.Data) > 0 {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return insertRow{}, err
+		}
+		r.Data = string(data)
+	}
+	encoded, err := json.Marshal(r)
+	return insertRow{InsertID: insertID, JSON: encoded}, err
+}
+
+func (e *Exporter) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []insertRow
+	var size int
+	for {
+		select {
+		case event := <-e.events:
+			r, err := newInsertRow(event)
+			if err != nil {
+				e.logger.Warn("Failed to encode %s event for BigQuery: %v", event.EventType, err)
+				metrics.RecordBigQueryDropped("rejected", 1)
+				continue
+			}
+			// A row that would take the request over the limit goes in the next one
+			if len(batch) > 0 && size+len(r.JSON) > maxRequestBytes {
+				e.send(context.Background(), batch)
+				batch, size = nil, 0
+			}
+			batch = append(batch, r)
+			size += len(r.JSON)
+			if len(batch) < e.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-e.stop:
+			e.drain(batch)
+			return
+		}
+		e.send(context.Background(), batch)
+		batch, size = nil, 0
+		metrics.UpdateBigQueryQueueLength(len(e.events))
+	}
+}
+
+// drain sends batch and the events still queued, each once, within closeTimeout
+func (e *Exporter) drain(batch []insertRow) {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	size := 0
+	for _, r := range batch {
+		size += len(r.JSON)
+	}
+	for {
+		select {
+		case event := <-e.events:
+			r, err := newInsertRow(event)
+			if err != nil {
+				metrics.RecordBigQueryDropped("rejected", 1)
+				continue
+			}
+			if len(batch) > 0 && (len(batch) == e.config.BatchSize || size+len(r.JSON) > maxRequestBytes) {
+				e.send(ctx, batch)
+				batch, size = nil, 0
+			}
+			batch = append(batch, r)
+			size += len(r.JSON)
+		default:
+			if len(batch) > 0 {
+				e.send(ctx, batch)
+			}
+			metrics.UpdateBigQueryQueueLength(0)
+			return
+		}
+	}
+}
+
+// send inserts rows, retrying with backoff while BigQuery is unavailable or over quota, until
+// Close; the queue fills meanwhile, so new events are dropped rather than piling up
+func (e *Exporter) send(ctx context.Context, rows []insertRow) {
+	for attempt := 1; ; attempt++ {
+		retry, err := e.insert(ctx, rows)
+		if len(retry) == 0 {
+			return
+		}
+		select {
+		case <-e.stop:
+			e.logger.Error("Dropped %d events not yet exported to BigQuery on shutdown: %v", len(retry), err)
+			metrics.RecordBigQueryDropped("shutdown", len(retry))
+			return
+		default:
+		}
+		delay := backoff(attempt)
+		e.logger.Warn("Failed to export %d events to BigQuery (attempt %d), retrying in %s: %v", len(retry), attempt, delay, err)
+		rows = retry
+		select {
+		case <-time.After(delay):
+		case <-e.stop:
+		}
+	}
+}
+
+// insertResponse is the part of a streaming insert's response saying which rows failed
+type insertResponse struct {
+	InsertErrors []struct {
+		Index  int        `json:"index"`
+		Errors []apiError `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+// rowRetryReasons are the reasons a row may fail that inserting it again can fix
+var rowRetryReasons = []string{"backendError", "internalError", "timeout"}
+
+// insert streams rows in one request, returning those to send again, with why: all of them if
+// BigQuery couldn't take the request, or those that failed on its side; rows it found invalid are
+// dropped, and the others are inserted regardless
+func (e *Exporter) insert(ctx context.Context, rows []insertRow) ([]insertRow, error) {
+	body, err := json.Marshal(map[string]interface{}{"skipInvalidRows": true, "rows": rows})
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	var response insertResponse
+	err = e.do(ctx, http.MethodPost, e.table+"/insertAll", body, &response)
+	if err != nil {
+		metrics.RecordBigQueryInsert(0, time.Since(start), err)
+		if retryable(err) {
+			return rows, err
+		}
+		e.logger.Error("BigQuery rejected %d events: %v", len(rows), err)
+		metrics.RecordBigQueryDropped("rejected", len(rows))
+		return nil, err
+	}
+
+	var retry []insertRow
+	var rejected int
+	for _, failed := range response.InsertErrors {
+		if failed.Index < 0 || failed.Index >= len(rows) || len(failed.Errors) == 0 {
+			continue
+		}
+		if slices.Contains(rowRetryReasons, failed.Errors[0].Reason) {
+			retry = append(retry, rows[failed.Index])
+			continue
+		}
+		if rejected == 0 {
+			e.logger.Warn("BigQuery rejected an event: %s: %s", failed.Errors[0].Reason, failed.Errors[0].Message)
+		}
+		rejected++
+	}
+	metrics.RecordBigQueryInsert(len(rows)-len(retry)-rejected, time.Since(start), nil)
+	metrics.RecordBigQueryDropped("rejected", rejected)
+	if len(retry) > 0 {
+		err = fmt.Errorf("BigQuery failed to insert %d of %d events", len(retry), len(rows))
+	}
+	return retry, err
+}
+
+// tableSchema is the table's columns; data is a JSON column, queried with JSON_VALUE and the like
+var tableSchema = []map[string]string{
+	{"name": "event_type", "type": "STRING", "mode": "REQUIRED"},
+	{"name": "user_id", "type": "STRING"},
+	{"name": "service", "type": "STRING"},
+	{"name": "tenant_id", "type": "STRING"},
+	{"name": "timestamp", "type": "TIMESTAMP", "mode": "REQUIRED"},
+	{"name": "topic", "type": "STRING"},
+	{"name": "kafka_partition", "type": "INTEGER"},
+	{"name": "kafka_offset", "type": "INTEGER"},
+	{"name": "data", "type": "JSON"},
+}
+
+// createTable creates the table, partitioned by the day of timestamp and clustered by tenant and
+// type, unless it exists; a table that exists is left as it is
+func (e *Exporter) createTable(ctx context.Context) error {
+	err := e.do(ctx, http.MethodGet, e.table, nil, nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"tableReference":   map[string]string{"projectId": e.config.Project, "datasetId": e.config.Dataset, "tableId": e.config.Table},
+		"schema":           map[string]interface{}{"fields": tableSchema},
+		"timePartitioning": map[string]string{"type": "DAY", "field": "timestamp"},
+		"clustering":       map[string][]string{"fields": {"tenant_id", "event_type"}},
+	})
+	if err != nil {
+		return err
+	}
+	tables := "/projects/" + url.PathEscape(e.config.Project) + "/datasets/" + url.PathEscape(e.config.Dataset) + "/tables"
+	err = e.do(ctx, http.MethodPost, tables, body, nil)
+	// Another instance may have created it first
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create table %s.%s.%s: %w", e.config.Project, e.config.Dataset, e.config.Table, err)
+	}
+	e.logger.Info("Created BigQuery table %s.%s.%s", e.config.Project, e.config.Dataset, e.config.Table)
+	return nil
+}
+
+// do sends an authorized request with a JSON body to the API, decoding the response into out
+// unless it is nil
+func (e *Exporter) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	token, err := e.credentials.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.api+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		apiErr := &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+		var parsed struct {
+			Error struct {
+				Message string     `json:"message"`
+				Errors  []apiError `json:"errors"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(message, &parsed) == nil && parsed.Error.Message != "" {
+			apiErr.Message = parsed.Error.Message
+			if len(parsed.Error.Errors) > 0 {
+				apiErr.Reason = parsed.Error.Errors[0].Reason
+			}
+		}
+		return apiErr
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiError is an error as the API describes it
+type apiError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Error is an error response from the BigQuery API
+type Error struct {
+	Status  int    // HTTP status
+	Reason  string // e.g. rateLimitExceeded
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("BigQuery returned %d (%s): %s", e.Status, e.Reason, e.Message)
+	}
+	return fmt.Sprintf("BigQuery returned %d: %s", e.Status, e.Message)
+}
+
+// retryable reports whether a failed request may succeed later: BigQuery was unreachable, timed
+// out, was unavailable, or was over a rate limit or quota, which it answers with 403 too
+func retryable(err error) bool {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		return true
+	}
+	switch {
+	case apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= 500:
+		return true
+	case apiErr.Status == http.StatusForbidden:
+		return apiErr.Reason == "rateLimitExceeded" || apiErr.Reason == "quotaExceeded"
+	}
+	return false
+}
+
+// backoff returns the delay after the given number of failed attempts: retryBase doubled per
+// attempt, capped at retryMax, with jitter so instances don't retry together
+func backoff(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	delay = delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+	return min(delay, retryMax)
+}
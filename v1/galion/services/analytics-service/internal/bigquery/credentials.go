@@ -0,0 +1,156 @@
+package bigquery
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// scope is the OAuth scope of access tokens
+const scope = "https://www.googleapis.com/auth/bigquery"
+
+// metadataTokenURL is where workloads on Google Cloud get their service account's access tokens
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// tokenMargin is how long before it expires an access token is replaced
+const tokenMargin = 5 * time.Minute
+
+// credentials gets OAuth access tokens for the Google Cloud APIs, from a service account key or, on
+// Google Cloud, from the metadata server, caching each until shortly before it expires; it isn't
+// safe for concurrent use
+type credentials struct {
+	email     string
+	key       *rsa.PrivateKey // nil uses the metadata server
+	tokenURL  string
+	client    *http.Client
+	token     string
+	expiresAt time.Time
+}
+
+// serviceAccountKey is the part of a service account's JSON key file credentials need
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// loadCredentials reads a service account key file; with no file, tokens come from the metadata server
+func loadCredentials(file string, client *http.Client) (*credentials, error) {
+	c := &credentials{tokenURL: metadataTokenURL, client: client}
+	if file == "" {
+		return c, nil
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(raw, &key); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials %s: %w", file, err)
+	}
+	if key.Type != "service_account" || key.ClientEmail == "" || key.TokenURI == "" {
+		return nil, fmt.Errorf("credentials %s aren't a service account key", file)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("credentials %s have no PEM private key", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key of %s: %w", file, err)
+	}
+	rsaKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key of %s isn't an RSA key", file)
+	}
+	c.email, c.key, c.tokenURL = key.ClientEmail, rsaKey, key.TokenURI
+	return c, nil
+}
+
+// accessToken returns a token that is valid for at least tokenMargin
+func (c *credentials) accessToken(ctx context.Context) (string, error) {
+	if c.token != "" && time.Until(c.expiresAt) > tokenMargin {
+		return c.token, nil
+	}
+	var req *http.Request
+	var err error
+	if c.key == nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, c.tokenURL, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	} else {
+		var assertion string
+		if assertion, err = c.assertion(time.Now()); err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get an access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("failed to get an access token: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf("failed to get an access token: invalid response")
+	}
+	c.token = token.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return c.token, nil
+}
+
+// assertion is the signed JWT a service account exchanges for an access token
+// See https://developers.google.com/identity/protocols/oauth2/service-account#authorizingrequests
+func (c *credentials) assertion(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   c.email,
+		"scope": scope,
+		"aud":   c.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign the token request: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,162 @@
+// Package cohorts keeps a retention table of users by the week they were first seen up to date, and
+// serves it, so product teams can see how many users come back without querying every event
+package cohorts
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// checkInterval is how often the cohorts are checked for staleness; they are computed once older
+// than the refresh interval, by whichever instance checks first
+const checkInterval = time.Minute
+
+// week is how long each cohort spans
+const week = 7 * 24 * time.Hour
+
+// Refresher computes the cohorts on a schedule and serves them
+type Refresher struct {
+	sink     storage.Cohorts
+	weeks    int
+	interval time.Duration
+	logger   *logger.Logger
+}
+
+// New creates a refresher keeping the cohorts of the last weeks weeks at most interval old
+func New(sink storage.Cohorts, weeks int, interval time.Duration, log *logger.Logger) *Refresher {
+	return &Refresher{sink: sink, weeks: weeks, interval: interval, logger: log}
+}
+
+// Run computes stale cohorts until ctx is done, starting at once
+func (r *Refresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(checkInterval, r.interval))
+	defer ticker.Stop()
+	for {
+		r.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh computes the cohorts if they are older than the refresh interval and updates their staleness
+func (r *Refresher) Refresh(ctx context.Context) {
+	computedAt, err := r.sink.ComputeCohorts(ctx, r.weeks, r.interval)
+	if err != nil && ctx.Err() == nil {
+		r.logger.Error("Cohort computation failed: %v", err)
+	}
+	if !computedAt.IsZero() {
+		metrics.UpdateCohortStaleness(time.Since(computedAt))
+	}
+}
+
+// cohort is a row of the retention triangle: the users first seen in the week starting Week, and
+// how many of them were active in it and in each week since, as counts and as a share of them
+type cohort struct {
+	Week      string    `json:"week"`
+	Users     int64     `json:"users"`
+	Active    []int64   `json:"active"`
+	Retention []float64 `json:"retention"`
+}
+
+// response is the cohorts API's answer
+type response struct {
+	TenantID   string    `json:"tenant_id,omitempty"`
+	ComputedAt time.Time `json:"computed_at"`
+	Cohorts    []cohort  `json:"cohorts"`
+}
+
+// ServeHTTP serves GET /api/v1/analytics/cohorts, returning the retention triangle of the last
+// ?weeks= weeks as JSON, for the ?tenant= given, or for events without a tenant; cohorts are never
+// mixed across tenants
+// Each cohort lists every week from its own to the one the table was computed in, the last one
+// so far, with 0 for weeks none of its users were active
+func (r *Refresher) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	weeks := r.weeks
+	if value := req.URL.Query().Get("weeks"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > r.weeks {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"weeks must be between 1 and ` + strconv.Itoa(r.weeks) + `"}`))
+			return
+		}
+		weeks = parsed
+	}
+
+	tenantID := req.URL.Query().Get("tenant")
+	table, err := r.sink.Cohorts(req.Context(), tenantID)
+	switch {
+	case err != nil:
+		r.logger.Error("Failed to read cohorts: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to read cohorts"}`))
+		return
+	case table.ComputedAt.IsZero():
+		w.Header().Set("Retry-After", strconv.Itoa(int(checkInterval.Seconds())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"the cohorts haven't been computed yet"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(response{TenantID: tenantID, ComputedAt: table.ComputedAt, Cohorts: triangle(table, weeks)})
+}
+
+// triangle lays out the cohorts of the last weeks weeks before the table was computed
+func triangle(table storage.CohortTable, weeks int) []cohort {
+	last := weekStart(table.ComputedAt)
+	first := last.Add(-time.Duration(weeks-1) * week)
+	cohorts := []cohort{}
+	index := make(map[time.Time]int)
+	for _, w := range table.Weeks {
+		if w.Cohort.Before(first) || w.Cohort.After(last) {
+			continue
+		}
+		i, ok := index[w.Cohort]
+		if !ok {
+			i = len(cohorts)
+			index[w.Cohort] = i
+			cohorts = append(cohorts, cohort{
+				Week:   w.Cohort.Format(time.DateOnly),
+				Active: make([]int64, int(last.Sub(w.Cohort)/week)+1),
+			})
+		}
+		if w.Week >= 0 && w.Week < len(cohorts[i].Active) {
+			cohorts[i].Active[w.Week] = w.Users
+		}
+	}
+	for i := range cohorts {
+		c := &cohorts[i]
+		c.Users = c.Active[0]
+		c.Retention = make([]float64, len(c.Active))
+		for j, active := range c.Active {
+			if c.Users > 0 {
+				c.Retention[j] = math.Round(float64(active)/float64(c.Users)*10000) / 10000
+			}
+		}
+	}
+	return cohorts
+}
+
+// weekStart is the start of the week containing t, on Monday in UTC, as cohorts are counted
+func weekStart(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
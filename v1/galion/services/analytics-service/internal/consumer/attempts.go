@@ -0,0 +1,72 @@
+package consumer
+
+import "sync"
+
+// maxTrackedMessages bounds the attempt counts kept; beyond it, arbitrary counts are forgotten
+const maxTrackedMessages = 10000
+
+// messageAttempts is how often a message has been handed to the handler, and its last error
+type messageAttempts struct {
+	count   int
+	lastErr error
+}
+
+// attemptTracker counts attempts per message across redeliveries, so a message redelivered after
+// its partition was held doesn't start over; counts live in memory and reset on restart
+type attemptTracker struct {
+	mu       sync.Mutex
+	messages map[Source]*messageAttempts
+}
+
+func newAttemptTracker() *attemptTracker {
+	return &attemptTracker{messages: make(map[Source]*messageAttempts)}
+}
+
+// next records another attempt at a message and returns its number, starting at 1
+func (t *attemptTracker) next(source Source) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m := t.messages[source]
+	if m == nil {
+		if len(t.messages) >= maxTrackedMessages {
+			for evict := range t.messages {
+				delete(t.messages, evict)
+				break
+			}
+		}
+		m = &messageAttempts{}
+		t.messages[source] = m
+	}
+	m.count++
+	return m.count
+}
+
+// failed records the error of a message's latest attempt
+func (t *attemptTracker) failed(source Source, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if m := t.messages[source]; m != nil {
+		m.lastErr = err
+	}
+}
+
+// lastError returns the error of a message's latest failed attempt, if known
+func (t *attemptTracker) lastError(source Source) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if m := t.messages[source]; m != nil {
+		return m.lastErr
+	}
+	return nil
+}
+
+// forget drops a message that was handled, dead-lettered or skipped
+func (t *attemptTracker) forget(source Source) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.messages, source)
+}
@@ -0,0 +1,97 @@
+package consumer
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"nexus-common/logger"
+)
+
+// Kafka client libraries the consumer can run on
+const (
+	ClientConfluent = "confluent" // confluent-kafka-go, wrapping librdkafka; needs cgo
+	ClientFranz     = "franz"     // franz-go, pure Go
+)
+
+// message is a record read from Kafka, whichever library read it
+type message struct {
+	Source
+	epoch   int32 // leader epoch the record was written in, committed with its offset; -1 if unknown
+	key     []byte
+	value   []byte
+	headers []header
+}
+
+// header is a Kafka record header
+type header struct {
+	key   string
+	value []byte
+}
+
+// partitionPosition is how far the group has consumed one of this consumer's partitions
+type partitionPosition struct {
+	topic         string
+	partition     int32
+	committed     int64 // next offset the group will read; -1 before its first commit
+	highWatermark int64 // offset the next message produced to the partition will get
+}
+
+// reader is a group consumer subscribed to the handled topics
+type reader interface {
+	// poll returns the next message, or nil if none arrived within timeout
+	poll(timeout time.Duration) (*message, error)
+	// store marks a partition consumed up to next, with epoch the leader epoch of the message before it;
+	// stored offsets are committed in the background and on close
+	store(topic string, partition int32, next int64, epoch int32) error
+	// positions reports the partitions currently assigned to this consumer; safe to call while polling
+	positions(timeout time.Duration) ([]partitionPosition, error)
+	close() error
+}
+
+// writer publishes dead letters
+type writer interface {
+	// publish sends a message and waits for the broker to acknowledge it
+	publish(topic string, key, value []byte, headers []header) error
+	close()
+}
+
+// library creates readers and writers with one Kafka client library
+type library struct {
+	// newReader subscribes to topics; revoked is called with partitions taken from this consumer
+	newReader func(cfg Config, topics []string, revoked func(topic string, partitions []int32), log *logger.Logger) (reader, error)
+	newWriter func(cfg Config, log *logger.Logger) (writer, error)
+}
+
+// libraries are the client libraries compiled in, registered by their files' init functions
+var libraries = map[string]library{}
+
+// Clients returns the client libraries this binary was built with, sorted
+func Clients() []string {
+	names := make([]string, 0, len(libraries))
+	for name := range libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultClient returns confluent when it was compiled in (builds with cgo), and franz otherwise
+func DefaultClient() string {
+	if _, ok := libraries[ClientConfluent]; ok {
+		return ClientConfluent
+	}
+	return ClientFranz
+}
+
+// libraryFor returns the named client library, or an error if it wasn't compiled in
+func libraryFor(name string) (library, error) {
+	if name == "" {
+		name = DefaultClient()
+	}
+	lib, ok := libraries[name]
+	if !ok {
+		return library{}, fmt.Errorf("Kafka client %q is not available in this build (available: %v)", name, Clients())
+	}
+	return lib, nil
+}
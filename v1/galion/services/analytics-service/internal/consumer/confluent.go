@@ -0,0 +1,228 @@
+//go:build cgo
+
+package consumer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"nexus-common/logger"
+)
+
+func init() {
+	libraries[ClientConfluent] = library{newReader: newConfluentReader, newWriter: newConfluentWriter}
+}
+
+// confluentReader reads with confluent-kafka-go
+type confluentReader struct {
+	consumer *kafka.Consumer
+	revoked  func(topic string, partitions []int32)
+}
+
+func newConfluentReader(cfg Config, topics []string, revoked func(string, []int32), _ *logger.Logger) (reader, error) {
+	config := &kafka.ConfigMap{
+		"bootstrap.servers": cfg.Brokers,
+		"group.id":          cfg.GroupID,
+		"auto.offset.reset": cfg.AutoOffsetReset,
+		// A worker blocked on a slow database stops reads, so MaxPollInterval bounds how long it may take
+		"session.timeout.ms":    int(cfg.SessionTimeout.Milliseconds()),
+		"heartbeat.interval.ms": int(cfg.HeartbeatInterval.Milliseconds()),
+		"max.poll.interval.ms":  int(cfg.MaxPollInterval.Milliseconds()),
+		// Offsets are stored once events are saved and committed in the background, every
+		// CommitInterval rather than a round trip per event; closing commits what is left
+		"enable.auto.commit":       true,
+		"enable.auto.offset.store": false,
+		"auto.commit.interval.ms":  int(cfg.CommitInterval.Milliseconds()),
+	}
+	if err := applySecurity(cfg.Security, config); err != nil {
+		return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+	}
+
+	consumer, err := kafka.NewConsumer(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	r := &confluentReader{consumer: consumer, revoked: revoked}
+	if err := consumer.SubscribeTopics(topics, r.rebalance); err != nil {
+		consumer.Close()
+		return nil, fmt.Errorf("failed to subscribe to topics: %w", err)
+	}
+	return r, nil
+}
+
+// rebalance reports revoked partitions; the assignment itself is left to the client
+func (r *confluentReader) rebalance(_ *kafka.Consumer, event kafka.Event) error {
+	if revoked, ok := event.(kafka.RevokedPartitions); ok {
+		partitions := make(map[string][]int32)
+		for _, tp := range revoked.Partitions {
+			partitions[*tp.Topic] = append(partitions[*tp.Topic], tp.Partition)
+		}
+		for topic, ids := range partitions {
+			r.revoked(topic, ids)
+		}
+	}
+	return nil
+}
+
+func (r *confluentReader) poll(timeout time.Duration) (*message, error) {
+	msg, err := r.consumer.ReadMessage(timeout)
+	if err != nil {
+		// Check if it's just a timeout (no message available)
+		if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrTimedOut {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	tp := msg.TopicPartition
+	m := &message{
+		Source: Source{Topic: *tp.Topic, Partition: tp.Partition, Offset: int64(tp.Offset)},
+		epoch:  -1,
+		key:    msg.Key,
+		value:  msg.Value,
+	}
+	if tp.LeaderEpoch != nil {
+		m.epoch = *tp.LeaderEpoch
+	}
+	for _, h := range msg.Headers {
+		m.headers = append(m.headers, header{key: h.Key, value: h.Value})
+	}
+	return m, nil
+}
+
+func (r *confluentReader) store(topic string, partition int32, next int64, epoch int32) error {
+	tp := kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(next)}
+	if epoch >= 0 {
+		tp.LeaderEpoch = &epoch
+	}
+	_, err := r.consumer.StoreOffsets([]kafka.TopicPartition{tp})
+	return err
+}
+
+func (r *confluentReader) positions(timeout time.Duration) ([]partitionPosition, error) {
+	assigned, err := r.consumer.Assignment()
+	if err != nil || len(assigned) == 0 {
+		return nil, err
+	}
+	committed, err := r.consumer.Committed(assigned, int(timeout.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make([]partitionPosition, 0, len(committed))
+	for _, tp := range committed {
+		_, high, err := r.consumer.QueryWatermarkOffsets(*tp.Topic, tp.Partition, int(timeout.Milliseconds()))
+		if err != nil {
+			return nil, err
+		}
+		position := partitionPosition{topic: *tp.Topic, partition: tp.Partition, committed: -1, highWatermark: high}
+		if tp.Offset >= 0 {
+			position.committed = int64(tp.Offset)
+		}
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+// close commits stored offsets and leaves the group
+func (r *confluentReader) close() error {
+	return r.consumer.Close()
+}
+
+// confluentWriter publishes with a confluent-kafka-go producer
+type confluentWriter struct {
+	producer *kafka.Producer
+}
+
+func newConfluentWriter(cfg Config, log *logger.Logger) (writer, error) {
+	config := &kafka.ConfigMap{
+		"bootstrap.servers": cfg.Brokers,
+		"acks":              "all",
+	}
+	if err := applySecurity(cfg.Security, config); err != nil {
+		return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+	}
+	producer, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter producer: %w", err)
+	}
+
+	// Delivery reports go to each publish's own channel; the rest are producer errors
+	go func() {
+		for event := range producer.Events() {
+			if err, ok := event.(kafka.Error); ok {
+				log.Error("Dead-letter producer error: %v", err)
+			}
+		}
+	}()
+
+	return &confluentWriter{producer: producer}, nil
+}
+
+func (w *confluentWriter) publish(topic string, key, value []byte, headers []header) error {
+	kafkaHeaders := make([]kafka.Header, len(headers))
+	for i, h := range headers {
+		kafkaHeaders[i] = kafka.Header{Key: h.key, Value: h.value}
+	}
+
+	delivery := make(chan kafka.Event, 1)
+	err := w.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            key,
+		Value:          value,
+		Headers:        kafkaHeaders,
+	}, delivery)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case event := <-delivery:
+		if report, ok := event.(*kafka.Message); ok && report.TopicPartition.Error != nil {
+			return report.TopicPartition.Error
+		}
+		return nil
+	case <-time.After(deadLetterTimeout):
+		return fmt.Errorf("no acknowledgement within %s", deadLetterTimeout)
+	}
+}
+
+func (w *confluentWriter) close() {
+	w.producer.Flush(int(deadLetterTimeout.Milliseconds()))
+	w.producer.Close()
+}
+
+// applySecurity adds the security settings to a client configuration
+func applySecurity(s Security, config *kafka.ConfigMap) error {
+	if s.Protocol == "" {
+		return nil
+	}
+	settings := kafka.ConfigMap{"security.protocol": s.Protocol}
+	if s.SASL() {
+		settings["sasl.mechanism"] = strings.ToUpper(s.SASLMechanism)
+		settings["sasl.username"] = s.SASLUsername
+		settings["sasl.password"] = s.SASLPassword
+	}
+	if s.TLS() {
+		optional := map[string]string{
+			"ssl.ca.location":          s.CAFile,
+			"ssl.certificate.location": s.CertFile,
+			"ssl.key.location":         s.KeyFile,
+			"ssl.key.password":         s.KeyPassword,
+		}
+		for key, value := range optional {
+			if value != "" {
+				settings[key] = value
+			}
+		}
+	}
+	for key, value := range settings {
+		if err := config.SetKey(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
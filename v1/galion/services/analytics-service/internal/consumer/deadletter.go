@@ -0,0 +1,59 @@
+package consumer
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"nexus-common/logger"
+)
+
+// Reasons a message is dead-lettered
+const (
+	reasonParseError      = "parse_error"      // the message isn't a valid event
+	reasonHandlerError    = "handler_error"    // the event failed every attempt, or failed permanently
+	reasonValidationError = "validation_error" // the event broke its schema
+	reasonOversized       = "oversized"        // the event is over Config.MaxEventBytes
+)
+
+// deadLetterTimeout bounds waiting for the broker to acknowledge a dead letter
+const deadLetterTimeout = 10 * time.Second
+
+// deadLetters publishes failed messages, unchanged, with headers describing the failure
+type deadLetters struct {
+	writer  writer
+	topic   string
+	pending sync.WaitGroup // publishes started from handler callbacks
+}
+
+func newDeadLetters(lib library, cfg Config, log *logger.Logger) (*deadLetters, error) {
+	w, err := lib.newWriter(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetters{writer: w, topic: cfg.DeadLetterTopic}, nil
+}
+
+// publish sends msg to the dead-letter topic and waits for the broker to acknowledge it
+func (d *deadLetters) publish(msg *message, reason string, cause error, attempts int) error {
+	headers := append([]header{}, msg.headers...)
+	headers = append(headers,
+		header{key: "dlq.reason", value: []byte(reason)},
+		header{key: "dlq.error", value: []byte(cause.Error())},
+		header{key: "dlq.attempts", value: []byte(strconv.Itoa(attempts))},
+		header{key: "dlq.original.topic", value: []byte(msg.Topic)},
+		header{key: "dlq.original.partition", value: []byte(strconv.Itoa(int(msg.Partition)))},
+		header{key: "dlq.original.offset", value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		header{key: "dlq.failed_at", value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+	return d.writer.publish(d.topic, msg.key, msg.value, headers)
+}
+
+// close waits for started publishes and closes the producer
+func (d *deadLetters) close() {
+	if d == nil {
+		return
+	}
+	d.pending.Wait()
+	d.writer.close()
+}
@@ -0,0 +1,390 @@
+package consumer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+
+	"nexus-common/logger"
+)
+
+func init() {
+	libraries[ClientFranz] = library{newReader: newFranzReader, newWriter: newFranzWriter}
+}
+
+// pollRecords bounds how many records one fetch hands over
+const pollRecords = 500
+
+// revokeCommitTimeout bounds committing a revoked partition's offsets
+const revokeCommitTimeout = 10 * time.Second
+
+// franzReader reads with franz-go
+// Rebalances wait until every record handed over has been passed to a worker, as with librdkafka,
+// so a revoked partition's records aren't added to the offsets after it is forgotten
+type franzReader struct {
+	client   *kgo.Client
+	revoked  func(topic string, partitions []int32)
+	logger   *logger.Logger
+	buffered []*kgo.Record // fetched records not yet handed over
+
+	mu       sync.Mutex
+	assigned map[string]map[int32]bool // franz-go doesn't expose the assignment, so it is tracked here
+}
+
+func newFranzReader(cfg Config, topics []string, revoked func(string, []int32), log *logger.Logger) (reader, error) {
+	opts, err := franzOptions(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var reset kgo.Offset
+	switch cfg.AutoOffsetReset {
+	case "latest":
+		reset = kgo.NewOffset().AtEnd()
+	case "none":
+		reset = kgo.NewOffset().AtCommitted()
+	default:
+		reset = kgo.NewOffset().AtStart()
+	}
+
+	r := &franzReader{revoked: revoked, logger: log, assigned: make(map[string]map[int32]bool)}
+	opts = append(opts,
+		kgo.ConsumerGroup(cfg.GroupID),
+		kgo.ConsumeTopics(topics...),
+		kgo.ConsumeResetOffset(reset),
+		kgo.SessionTimeout(cfg.SessionTimeout),
+		kgo.HeartbeatInterval(cfg.HeartbeatInterval),
+		// franz-go's equivalent of max.poll.interval.ms
+		kgo.RebalanceTimeout(cfg.MaxPollInterval),
+		// Offsets are marked once events are saved and committed in the background, every
+		// CommitInterval rather than a round trip per event; revoking partitions commits what is left
+		kgo.AutoCommitMarks(),
+		kgo.AutoCommitInterval(cfg.CommitInterval),
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsAssigned(r.onAssigned),
+		kgo.OnPartitionsRevoked(r.onRevoked),
+		kgo.OnPartitionsLost(r.onLost),
+	)
+	r.client, err = kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	return r, nil
+}
+
+// onAssigned records partitions given to this consumer
+func (r *franzReader) onAssigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for topic, partitions := range assigned {
+		if r.assigned[topic] == nil {
+			r.assigned[topic] = make(map[int32]bool)
+		}
+		for _, partition := range partitions {
+			r.assigned[topic][partition] = true
+		}
+	}
+}
+
+// onRevoked reports the partitions, which marks the offsets of what was handled, and commits them
+// before the partitions go
+func (r *franzReader) onRevoked(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+	for topic, partitions := range revoked {
+		r.revoked(topic, partitions)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, revokeCommitTimeout)
+	defer cancel()
+	if err := client.CommitMarkedOffsets(ctx); err != nil {
+		r.logger.Error("Failed to commit offsets of revoked partitions: %v", err)
+	}
+	r.unassign(revoked)
+}
+
+// onLost reports partitions taken away without a chance to commit
+func (r *franzReader) onLost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	r.unassign(lost)
+	for topic, partitions := range lost {
+		r.revoked(topic, partitions)
+	}
+}
+
+// unassign forgets partitions no longer assigned to this consumer
+func (r *franzReader) unassign(partitions map[string][]int32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for topic, ids := range partitions {
+		for _, partition := range ids {
+			delete(r.assigned[topic], partition)
+		}
+	}
+}
+
+func (r *franzReader) poll(timeout time.Duration) (*message, error) {
+	if len(r.buffered) == 0 {
+		// Everything fetched so far has been handed over, so rebalancing is safe until the next fetch
+		r.client.AllowRebalance()
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		fetches := r.client.PollRecords(ctx, pollRecords)
+		cancel()
+		if fetches.IsClientClosed() {
+			return nil, errors.New("consumer closed")
+		}
+		r.buffered = fetches.Records()
+
+		var errs []error
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				return
+			}
+			errs = append(errs, fmt.Errorf("%s [%d]: %w", topic, partition, err))
+		})
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+	}
+	if len(r.buffered) == 0 {
+		return nil, nil
+	}
+
+	record := r.buffered[0]
+	r.buffered[0] = nil
+	r.buffered = r.buffered[1:]
+	m := &message{
+		Source: Source{Topic: record.Topic, Partition: record.Partition, Offset: record.Offset},
+		epoch:  record.LeaderEpoch,
+		key:    record.Key,
+		value:  record.Value,
+	}
+	for _, h := range record.Headers {
+		m.headers = append(m.headers, header{key: h.Key, value: h.Value})
+	}
+	return m, nil
+}
+
+func (r *franzReader) store(topic string, partition int32, next int64, epoch int32) error {
+	r.client.MarkCommitOffsets(map[string]map[int32]kgo.EpochOffset{
+		topic: {partition: {Epoch: epoch, Offset: next}},
+	})
+	return nil
+}
+
+// positions takes committed offsets from the client, which learns them as it commits, and asks the
+// partition leaders for high watermarks
+func (r *franzReader) positions(timeout time.Duration) ([]partitionPosition, error) {
+	req := kmsg.NewPtrListOffsetsRequest()
+	r.mu.Lock()
+	for topic, partitions := range r.assigned {
+		reqTopic := kmsg.NewListOffsetsRequestTopic()
+		reqTopic.Topic = topic
+		for partition := range partitions {
+			reqPartition := kmsg.NewListOffsetsRequestTopicPartition()
+			reqPartition.Partition = partition
+			reqPartition.Timestamp = -1 // the latest offset
+			reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+		}
+		req.Topics = append(req.Topics, reqTopic)
+	}
+	r.mu.Unlock()
+	if len(req.Topics) == 0 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, err := req.RequestWith(ctx, r.client)
+	if err != nil {
+		return nil, err
+	}
+
+	committed := r.client.CommittedOffsets()
+	var positions []partitionPosition
+	for _, topic := range resp.Topics {
+		for _, partition := range topic.Partitions {
+			if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+				return nil, fmt.Errorf("%s [%d]: %w", topic.Topic, partition.Partition, err)
+			}
+			position := partitionPosition{topic: topic.Topic, partition: partition.Partition, committed: -1, highWatermark: partition.Offset}
+			if offset, ok := committed[topic.Topic][partition.Partition]; ok && offset.Offset >= 0 {
+				position.committed = offset.Offset
+			}
+			positions = append(positions, position)
+		}
+	}
+	return positions, nil
+}
+
+// close leaves the group, committing marked offsets as its partitions are revoked
+func (r *franzReader) close() error {
+	r.client.CloseAllowingRebalance()
+	return nil
+}
+
+// franzWriter publishes with a franz-go producer
+type franzWriter struct {
+	client *kgo.Client
+}
+
+func newFranzWriter(cfg Config, log *logger.Logger) (writer, error) {
+	opts, err := franzOptions(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts,
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		// As with librdkafka, the broker decides whether a missing dead-letter topic is created
+		kgo.AllowAutoTopicCreation(),
+	)
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter producer: %w", err)
+	}
+	return &franzWriter{client: client}, nil
+}
+
+func (w *franzWriter) publish(topic string, key, value []byte, headers []header) error {
+	record := &kgo.Record{Topic: topic, Key: key, Value: value}
+	for _, h := range headers {
+		record.Headers = append(record.Headers, kgo.RecordHeader{Key: h.key, Value: h.value})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+	err := w.client.ProduceSync(ctx, record).FirstErr()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("no acknowledgement within %s", deadLetterTimeout)
+	}
+	return err
+}
+
+func (w *franzWriter) close() {
+	w.client.Close()
+}
+
+// franzOptions are the connection options shared by the reader and the writer
+func franzOptions(cfg Config, log *logger.Logger) ([]kgo.Opt, error) {
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(strings.Split(cfg.Brokers, ",")...),
+		kgo.WithLogger(franzLogger{log}),
+	}
+
+	security := cfg.Security
+	if security.TLS() {
+		tlsConfig, err := franzTLS(security)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kafka security settings: %w", err)
+		}
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+	if security.SASL() {
+		var mechanism sasl.Mechanism
+		switch strings.ToUpper(security.SASLMechanism) {
+		case "PLAIN":
+			mechanism = plain.Auth{User: security.SASLUsername, Pass: security.SASLPassword}.AsMechanism()
+		case "SCRAM-SHA-256":
+			mechanism = scram.Auth{User: security.SASLUsername, Pass: security.SASLPassword}.AsSha256Mechanism()
+		case "SCRAM-SHA-512":
+			mechanism = scram.Auth{User: security.SASLUsername, Pass: security.SASLPassword}.AsSha512Mechanism()
+		default:
+			return nil, fmt.Errorf("invalid Kafka security settings: unsupported SASL mechanism %q", security.SASLMechanism)
+		}
+		opts = append(opts, kgo.SASL(mechanism))
+	}
+	return opts, nil
+}
+
+// franzTLS builds the TLS configuration librdkafka would from the same files
+// The server name is set per broker by the client
+func franzTLS(security Security) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if security.CAFile != "" {
+		caPEM, err := os.ReadFile(security.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in %s", security.CAFile)
+		}
+	}
+	if security.CertFile == "" {
+		return config, nil
+	}
+
+	certPEM, err := os.ReadFile(security.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(security.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if security.KeyPassword != "" {
+		if keyPEM, err = decryptKey(keyPEM, security.KeyPassword); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", security.KeyFile, err)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	config.Certificates = []tls.Certificate{cert}
+	return config, nil
+}
+
+// decryptKey decrypts a PEM key encrypted the legacy OpenSSL way (Proc-Type: 4,ENCRYPTED)
+// Go can't decrypt encrypted PKCS#8 keys; those need the confluent client or decrypting beforehand
+func decryptKey(keyPEM []byte, password string) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("no PEM key found")
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("encrypted PKCS#8 keys aren't supported with KAFKA_CLIENT=franz")
+	}
+	// Deprecated as insecure, but still the only way to read these keys
+	if !x509.IsEncryptedPEMBlock(block) {
+		return keyPEM, nil
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// franzLogger passes the client's warnings and errors to the service logger
+type franzLogger struct {
+	logger *logger.Logger
+}
+
+func (l franzLogger) Level() kgo.LogLevel {
+	return kgo.LogLevelWarn
+}
+
+func (l franzLogger) Log(level kgo.LogLevel, msg string, keyvals ...interface{}) {
+	var fields strings.Builder
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fmt.Fprintf(&fields, " %v=%v", keyvals[i], keyvals[i+1])
+	}
+	if level == kgo.LogLevelError {
+		l.logger.Error("Kafka client: %s%s", msg, fields.String())
+	} else {
+		l.logger.Warn("Kafka client: %s%s", msg, fields.String())
+	}
+}
@@ -2,111 +2,435 @@
 package consumer
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"sync"
 	"time"
 
-	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"nexus-analytics-service/internal/schemaregistry"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
 )
 
 // Event represents a user event from Kafka
 type Event struct {
+	EventID   string                 `json:"event_id"` // optional; set by producers for deduplication
 	EventType string                 `json:"event_type"`
 	UserID    string                 `json:"user_id"`
 	Timestamp string                 `json:"timestamp"`
 	Service   string                 `json:"service"`
+	TenantID  string                 `json:"tenant_id"` // optional; the customer the event belongs to
 	Data      map[string]interface{} `json:"data"`
 }
 
-// EventHandler is a function that processes events
-type EventHandler func(*Event) error
+// PermanentError marks a handler error that retrying can't fix; the event is dead-lettered at once
+type PermanentError struct {
+	Err    error
+	Reason string // dead-letter reason; handler_error if empty
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps err in a PermanentError
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// Invalid wraps err in a PermanentError for an event that failed validation
+func Invalid(err error) error {
+	return &PermanentError{Err: err, Reason: reasonValidationError}
+}
+
+// workerQueue is how many messages may wait for each worker before reading pauses
+const workerQueue = 100
+
+// handOffTimeout bounds how long revoking partitions waits for their messages to be saved
+const handOffTimeout = 30 * time.Second
+
+// Config sets how events are consumed and what happens to those that fail
+type Config struct {
+	Client            string // Kafka client library: confluent or franz; empty picks DefaultClient
+	Brokers           string
+	Security          Security // authentication and encryption, shared by the dead-letter producer
+	GroupID           string
+	AutoOffsetReset   string        // where a group without committed offsets starts: earliest, latest or none
+	SessionTimeout    time.Duration // how long the broker waits for heartbeats before rebalancing
+	HeartbeatInterval time.Duration
+	MaxPollInterval   time.Duration // longest time between reads before the consumer leaves the group
+	CommitInterval    time.Duration // how often stored offsets are committed, in the background
+	Workers           int           // goroutines handling messages
+	MaxAttempts       int           // times an event is handled before it is dead-lettered
+	RetryBackoff      time.Duration // wait before the second attempt, doubled for each further one
+	DeadLetterTopic   string        // failed messages are published here; empty skips them instead
+	LagInterval       time.Duration // how often partition offsets and lag are exported; 0 disables them
+	MaxEventBytes     int           // largest event handed to handlers, as JSON; 0 for no limit
+	OversizedPolicy   string        // OversizedDeadLetter or OversizedTruncate
+
+	// SchemaRegistry decodes Avro messages for handlers expecting JSON; without it they are dead-lettered
+	SchemaRegistry *schemaregistry.Client
+
+	// Flush saves whatever handlers have buffered; called when partitions are revoked so that their
+	// events are saved, and committed, before another consumer takes them over
+	Flush func()
+}
 
 // KafkaConsumer consumes events from Kafka
 type KafkaConsumer struct {
-	consumer *kafka.Consumer
-	topics   []string
-	handler  EventHandler
+	config      Config
+	reader      reader
+	deadLetters *deadLetters
+	handlers    *Registry
+	logger      *logger.Logger
+	workers     []chan *message
+	offsets     *offsetTracker
+	attempts    *attemptTracker
+
+	// closing is set as Start returns; retries scheduled before then hold the read lock while handing their event over
+	closingMu sync.RWMutex
+	closing   bool
 }
 
-// NewKafkaConsumer creates a new Kafka consumer
-func NewKafkaConsumer(brokers string, groupID string, topics []string, handler EventHandler) (*KafkaConsumer, error) {
-	config := &kafka.ConfigMap{
-		"bootstrap.servers": brokers,
-		"group.id":          groupID,
-		"auto.offset.reset": "earliest", // Start from beginning if no offset
+// NewKafkaConsumer creates a Kafka consumer for the topics registered in handlers
+func NewKafkaConsumer(cfg Config, handlers *Registry, log *logger.Logger) (*KafkaConsumer, error) {
+	lib, err := libraryFor(cfg.Client)
+	if err != nil {
+		return nil, err
 	}
 
-	consumer, err := kafka.NewConsumer(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer: %w", err)
+	kc := &KafkaConsumer{
+		config:   cfg,
+		handlers: handlers,
+		logger:   log,
+		workers:  make([]chan *message, cfg.Workers),
+		offsets:  newOffsetTracker(),
+		attempts: newAttemptTracker(),
+	}
+	for i := range kc.workers {
+		kc.workers[i] = make(chan *message, workerQueue)
+	}
+
+	if cfg.DeadLetterTopic != "" {
+		kc.deadLetters, err = newDeadLetters(lib, cfg, log)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Subscribe to topics
-	err = consumer.SubscribeTopics(topics, nil)
+	topics := handlers.Topics()
+	kc.reader, err = lib.newReader(cfg, topics, kc.revoke, log)
 	if err != nil {
-		consumer.Close()
-		return nil, fmt.Errorf("failed to subscribe to topics: %w", err)
+		kc.deadLetters.close()
+		return nil, err
 	}
 
-	log.Printf("Subscribed to topics: %v", topics)
+	log.Info("Subscribed to topics: %v", topics)
 
-	return &KafkaConsumer{
-		consumer: consumer,
-		topics:   topics,
-		handler:  handler,
-	}, nil
+	return kc, nil
+}
+
+// revoke hands revoked partitions over: their queued messages are dropped, and the ones already with
+// a handler are saved and their offsets stored before the client commits and releases the partitions
+// Messages that don't finish in time are read again by the partitions' next consumer
+// The client calls it from poll (or Close), so no messages are read meanwhile
+func (kc *KafkaConsumer) revoke(topic string, partitions []int32) {
+	if kc.config.Flush != nil {
+		kc.config.Flush()
+	}
+	if !kc.offsets.handOff(topic, partitions, handOffTimeout) {
+		kc.logger.Warn("Messages of %d revoked partitions of %s still unsaved after %s; they will be read again", len(partitions), topic, handOffTimeout)
+	}
+	kc.offsets.revoke(topic, partitions)
+	kc.logger.Info("Partitions revoked: %d", len(partitions))
 }
 
 // Start begins consuming events
-// This is a blocking call that runs until stopped
-func (kc *KafkaConsumer) Start() error {
-	log.Println("Starting Kafka consumer...")
+// This is a blocking call that runs until ctx is cancelled, then returns once the messages already
+// read have been handed to their handler; failed events are no longer retried afterwards, so the
+// handler isn't called once Start returns
+// Offsets are committed by Close, after the handler has finished saving what it was given
+func (kc *KafkaConsumer) Start(ctx context.Context) error {
+	kc.logger.Info("Starting Kafka consumer with %d workers...", len(kc.workers))
+
+	var wg sync.WaitGroup
+	for _, messages := range kc.workers {
+		wg.Add(1)
+		go func(messages chan *message) {
+			defer wg.Done()
+			for msg := range messages {
+				kc.handle(msg)
+			}
+		}(messages)
+	}
+	lagDone := make(chan struct{})
+	go func() {
+		defer close(lagDone)
+		kc.reportLag(ctx)
+	}()
+	// Workers finish the messages already queued before Start returns
+	defer func() {
+		<-lagDone
+		for _, messages := range kc.workers {
+			close(messages)
+		}
+		wg.Wait()
+
+		kc.closingMu.Lock()
+		kc.closing = true
+		kc.closingMu.Unlock()
+		kc.logger.Info("Kafka consumer stopped")
+	}()
 
 	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
 		// Poll for messages
-		msg, err := kc.consumer.ReadMessage(time.Second * 1)
+		msg, err := kc.reader.poll(time.Second * 1)
 		if err != nil {
-			// Check if it's just a timeout (no message available)
-			if kafkaErr, ok := err.(kafka.Error); ok && kafkaErr.Code() == kafka.ErrTimedOut {
-				continue
-			}
-			log.Printf("Error reading message: %v", err)
+			kc.logger.Error("Error reading message: %v", err)
 			continue
 		}
-
-		// Parse the event
-		var event Event
-		err = json.Unmarshal(msg.Value, &event)
-		if err != nil {
-			log.Printf("Failed to unmarshal event: %v", err)
+		if msg == nil {
 			continue
 		}
 
-		// Log the event
-		log.Printf("Received event: %s from %s (user: %s)", event.EventType, event.Service, event.UserID)
+		// A partition always goes to the same worker, which keeps its events in order
+		kc.offsets.add(msg)
+		select {
+		case kc.workers[int(msg.Partition)%len(kc.workers)] <- msg:
+		case <-ctx.Done():
+			// Never handled, so its offset isn't committed and it is redelivered after the restart
+			kc.offsets.complete(msg.Source, ctx.Err())
+			return nil
+		}
+	}
+}
 
-		// Handle the event
-		err = kc.handler(&event)
+// lagTimeout bounds the broker requests made for one lag report
+const lagTimeout = 5 * time.Second
+
+// reportLag exports the committed offset, high watermark and lag of the assigned partitions every
+// LagInterval until ctx is cancelled
+// Partitions are forgotten once they are no longer assigned, so other instances of the group report them
+func (kc *KafkaConsumer) reportLag(ctx context.Context) {
+	if kc.config.LagInterval <= 0 {
+		return
+	}
+	reported := make(map[partitionKey]bool)
+	defer func() {
+		for p := range reported {
+			metrics.ForgetPartition(p.topic, p.partition)
+		}
+	}()
+
+	ticker := time.NewTicker(kc.config.LagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		positions, err := kc.reader.positions(lagTimeout)
 		if err != nil {
-			log.Printf("Failed to handle event %s: %v", event.EventType, err)
-			// Don't commit offset if handling failed
+			kc.logger.Warn("Failed to read consumer lag: %v", err)
 			continue
 		}
+		current := make(map[partitionKey]bool, len(positions))
+		for _, p := range positions {
+			metrics.UpdatePartitionOffsets(p.topic, p.partition, p.committed, p.highWatermark)
+			current[partitionKey{p.topic, p.partition}] = true
+		}
+		for p := range reported {
+			if !current[p] {
+				metrics.ForgetPartition(p.topic, p.partition)
+			}
+		}
+		reported = current
+	}
+}
 
-		// Commit offset after successful processing
-		_, err = kc.consumer.CommitMessage(msg)
-		if err != nil {
-			log.Printf("Failed to commit offset: %v", err)
+// handle hands one message to its topic's handler, storing its offset once it and earlier ones are handled
+func (kc *KafkaConsumer) handle(msg *message) {
+	handler := kc.handlers.handler(msg.Topic)
+	if handler == nil {
+		// Only registered topics are subscribed to, so this is a bug rather than bad data
+		kc.logger.Error("No handler registered for topic %s", msg.Topic)
+		kc.complete(msg.Source, nil)
+		return
+	}
+	kc.attempt(msg, handler)
+}
+
+// attempt hands a message to its handler; failures are retried with backoff up to MaxAttempts,
+// counting attempts made before the message was redelivered, then given up on
+func (kc *KafkaConsumer) attempt(msg *message, handler TopicHandler) {
+	if !kc.offsets.start(msg.Source) {
+		// Its partition was revoked; the next consumer reads it again
+		return
+	}
+	attempt := kc.attempts.next(msg.Source)
+	if attempt > kc.config.MaxAttempts {
+		// Redelivered after its partition was held, having already used its attempts
+		cause := kc.attempts.lastError(msg.Source)
+		if cause == nil {
+			cause = fmt.Errorf("failed %d attempts", kc.config.MaxAttempts)
+		}
+		kc.giveUp(msg, reasonHandlerError, cause, attempt-1)
+		return
+	}
+
+	source := msg.Source
+	done := func(err error) {
+		if err == nil {
+			kc.attempts.forget(source)
+			kc.complete(source, nil)
+			return
 		}
+		kc.logger.Error("Failed to handle message %s (attempt %d of %d): %v", source, attempt, kc.config.MaxAttempts, err)
+		kc.attempts.failed(source, err)
+
+		var permanent *PermanentError
+		if errors.As(err, &permanent) && permanent.Reason != "" {
+			kc.giveUp(msg, permanent.Reason, err, attempt)
+			return
+		}
+		if attempt >= kc.config.MaxAttempts || permanent != nil {
+			kc.giveUp(msg, reasonHandlerError, err, attempt)
+			return
+		}
+
+		metrics.RecordEventRetry()
+		kc.offsets.retrying(source)
+		// Retried messages may be handled after later messages of their partition; commits stay in order
+		backoff := kc.config.RetryBackoff << (attempt - 1)
+		time.AfterFunc(backoff, func() {
+			kc.closingMu.RLock()
+			defer kc.closingMu.RUnlock()
+			if kc.closing {
+				// Left uncommitted, so it is redelivered after the restart
+				kc.complete(source, err)
+				return
+			}
+			kc.attempt(msg, handler)
+		})
+	}
+
+	value, err := kc.config.decode(msg.value)
+	if errors.Is(err, schemaregistry.ErrUnavailable) {
+		// The message may be fine; retry once the registry is back
+		done(err)
+		return
+	}
+	if err == nil && kc.config.MaxEventBytes > 0 && len(value) > kc.config.MaxEventBytes {
+		if value, err = kc.config.shrink(value); err != nil {
+			kc.logger.Warn("Rejecting oversized message %s: %v", source, err)
+			metrics.RecordOversizedEvent("rejected")
+			kc.giveUp(msg, reasonOversized, err, attempt)
+			return
+		}
+		kc.logger.Warn("Truncated oversized message %s to %d bytes", source, len(value))
+		metrics.RecordOversizedEvent("truncated")
+	}
+	if err == nil {
+		err = handler(value, source, done)
+	}
+	if err != nil {
+		// Retrying can't help an undecodable message, so it doesn't hold up the partition
+		kc.logger.Error("Failed to decode message %s: %v", source, err)
+		kc.giveUp(msg, reasonParseError, err, attempt)
+	}
+}
+
+// decode turns Avro messages framed for the schema registry into JSON; other values are passed on as they are
+func (cfg Config) decode(value []byte) ([]byte, error) {
+	if !schemaregistry.Framed(value) {
+		return value, nil
+	}
+	if cfg.SchemaRegistry == nil {
+		return nil, errors.New("Avro message received but SCHEMA_REGISTRY_URL is not set")
+	}
+	decoded, err := cfg.SchemaRegistry.Decode(value)
+	if err != nil {
+		return nil, err
+	}
+	metrics.RecordAvroDecoded()
+	return decoded, nil
+}
+
+// shrink applies OversizedPolicy to an event over MaxEventBytes, returning the truncated event or an error
+// if it is to be dead-lettered
+func (cfg Config) shrink(value []byte) ([]byte, error) {
+	if cfg.OversizedPolicy == OversizedTruncate {
+		return truncateEvent(value, cfg.MaxEventBytes)
 	}
+	return nil, fmt.Errorf("event is %d bytes, over the %d byte limit", len(value), cfg.MaxEventBytes)
 }
 
-// Close closes the Kafka consumer
+// giveUp dead-letters a message that won't be handled, or skips it when there is no dead-letter topic,
+// so it doesn't block its partition
+func (kc *KafkaConsumer) giveUp(msg *message, reason string, cause error, attempts int) {
+	if kc.deadLetters == nil {
+		metrics.RecordEventSkipped(reason)
+		kc.logger.Error("Skipping message %s [%d] at %d after %d attempts (%s): %v", msg.Topic, msg.Partition, msg.Offset, attempts, reason, cause)
+		kc.attempts.forget(msg.Source)
+		kc.complete(msg.Source, nil)
+		return
+	}
+
+	// giveUp may run on the handler's goroutine, which publishing must not hold up
+	kc.deadLetters.pending.Add(1)
+	go func() {
+		defer kc.deadLetters.pending.Done()
+		kc.deadLetter(msg, reason, cause, attempts)
+	}()
+}
+
+// deadLetter publishes a failed message and commits past it
+// If publishing fails its partition is held; the message keeps its attempt count, so when it is
+// redelivered it is dead-lettered again straight away
+func (kc *KafkaConsumer) deadLetter(msg *message, reason string, cause error, attempts int) {
+	if err := kc.deadLetters.publish(msg, reason, cause, attempts); err != nil {
+		metrics.RecordDeadLetterFailure()
+		kc.logger.Error("Failed to dead-letter message %s [%d] at %d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		kc.complete(msg.Source, err)
+		return
+	}
+	metrics.RecordDeadLetter(reason)
+	kc.logger.Warn("Dead-lettered message %s [%d] at %d to %s (%s)", msg.Topic, msg.Partition, msg.Offset, kc.config.DeadLetterTopic, reason)
+	kc.attempts.forget(msg.Source)
+	kc.complete(msg.Source, nil)
+}
+
+// complete records a handled message and stores the partition's offset if it advanced
+// Stored offsets are committed with the next auto-commit
+func (kc *KafkaConsumer) complete(source Source, err error) {
+	last, advanced, held := kc.offsets.complete(source, err)
+	if held {
+		kc.logger.Warn("Offsets of %s [%d] held at %d until the partition is reassigned", source.Topic, source.Partition, source.Offset)
+	}
+	if !advanced {
+		return
+	}
+	if err := kc.reader.store(source.Topic, source.Partition, last.offset+1, last.epoch); err != nil {
+		kc.logger.Error("Failed to store offset: %v", err)
+	}
+}
+
+// Close finishes dead-lettering, commits stored offsets and closes the Kafka consumer
 func (kc *KafkaConsumer) Close() error {
-	if kc.consumer != nil {
-		return kc.consumer.Close()
+	kc.deadLetters.close()
+	if kc.reader != nil {
+		return kc.reader.close()
 	}
 	return nil
 }
-
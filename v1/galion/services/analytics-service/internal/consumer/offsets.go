@@ -0,0 +1,202 @@
+package consumer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// partitionKey identifies a topic partition
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// offsetState is where a message handed to a worker has got to
+type offsetState uint8
+
+const (
+	offsetQueued   offsetState = iota // waiting for its worker
+	offsetHandling                    // with its handler, or being dead-lettered
+	offsetRetrying                    // failed, waiting for its next attempt
+	offsetDone
+)
+
+// pendingOffset is a message handed to a worker, and how far it has got
+type pendingOffset struct {
+	offset int64
+	epoch  int32 // leader epoch, committed with the offset after it
+	state  offsetState
+}
+
+// partitionOffsets holds a partition's in-flight messages, oldest first
+// After a failure the partition is held: nothing past the failed message is committed until it is reassigned
+type partitionOffsets struct {
+	pending  []pendingOffset
+	held     bool
+	revoking bool // being handed to another consumer; messages not yet started are dropped
+}
+
+// offsetTracker commits each partition's offsets in order, however handling finishes
+// A message's offset is committed only once it and every earlier message of its partition are handled
+type offsetTracker struct {
+	mu         sync.Mutex
+	changed    *sync.Cond // broadcast when a message starts, finishes or is dropped
+	partitions map[partitionKey]*partitionOffsets
+}
+
+func newOffsetTracker() *offsetTracker {
+	t := &offsetTracker{partitions: make(map[partitionKey]*partitionOffsets)}
+	t.changed = sync.NewCond(&t.mu)
+	return t
+}
+
+// find returns the partition of source and the index of its message, or nil if it is no longer pending
+func (t *offsetTracker) find(source Source) (*partitionOffsets, int) {
+	p := t.partitions[partitionKey{topic: source.Topic, partition: source.Partition}]
+	if p == nil {
+		return nil, 0
+	}
+	i := sort.Search(len(p.pending), func(i int) bool { return p.pending[i].offset >= source.Offset })
+	if i == len(p.pending) || p.pending[i].offset != source.Offset {
+		return nil, 0
+	}
+	return p, i
+}
+
+// add records a message handed to a worker; messages of a partition are added in offset order
+func (t *offsetTracker) add(msg *message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := partitionKey{topic: msg.Topic, partition: msg.Partition}
+	p := t.partitions[key]
+	if p == nil {
+		p = &partitionOffsets{}
+		t.partitions[key] = p
+	}
+	if p.held {
+		return
+	}
+	p.pending = append(p.pending, pendingOffset{offset: msg.Offset, epoch: msg.epoch})
+}
+
+// complete marks a message handled and returns the last message of the partition's handled
+// prefix when it grew, to commit past; held is true when err holds the partition's commits
+func (t *offsetTracker) complete(source Source, err error) (last pendingOffset, advanced, held bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, i := t.find(source)
+	if p == nil {
+		// Revoked since, or past a held offset
+		return last, false, false
+	}
+	defer t.changed.Broadcast()
+
+	if err != nil {
+		// Earlier messages may still commit; this one and later ones are redelivered after a restart or rebalance
+		p.pending = p.pending[:i]
+		p.held = true
+		return last, false, true
+	}
+
+	p.pending[i].state = offsetDone
+	for len(p.pending) > 0 && p.pending[0].state == offsetDone {
+		last = p.pending[0]
+		advanced = true
+		p.pending = p.pending[1:]
+	}
+	return last, advanced, false
+}
+
+// start marks a message as handed to its handler, returning false if it should be dropped instead:
+// its partition was revoked, or is being, so another consumer will read it again
+func (t *offsetTracker) start(source Source) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, i := t.find(source)
+	if p == nil {
+		return false
+	}
+	if p.revoking {
+		// Nothing past a dropped message can be committed, so later ones are no longer waited for either
+		p.pending = p.pending[:i]
+		p.held = true
+		t.changed.Broadcast()
+		return false
+	}
+	p.pending[i].state = offsetHandling
+	return true
+}
+
+// retrying marks a failed message as waiting for its next attempt
+func (t *offsetTracker) retrying(source Source) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p, i := t.find(source); p != nil {
+		p.pending[i].state = offsetRetrying
+		t.changed.Broadcast()
+	}
+}
+
+// handOff stops messages of topic's partitions from starting and waits up to timeout for those already
+// started to finish, so the offsets of what was handled are stored before another consumer takes over;
+// it returns false if the wait timed out
+func (t *offsetTracker) handOff(topic string, partitions []int32, timeout time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, partition := range partitions {
+		if p := t.partitions[partitionKey{topic: topic, partition: partition}]; p != nil {
+			p.revoking = true
+		}
+	}
+
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		expired = true
+		t.changed.Broadcast()
+		t.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	for t.busy(topic, partitions) {
+		if expired {
+			return false
+		}
+		t.changed.Wait()
+	}
+	return true
+}
+
+// busy reports whether any message of the partitions is queued or with its handler; messages waiting
+// for a retry are dropped when it comes, so they aren't waited for
+func (t *offsetTracker) busy(topic string, partitions []int32) bool {
+	for _, partition := range partitions {
+		p := t.partitions[partitionKey{topic: topic, partition: partition}]
+		if p == nil {
+			continue
+		}
+		for _, pending := range p.pending {
+			if pending.state == offsetQueued || pending.state == offsetHandling {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// revoke forgets partitions of topic no longer assigned to this consumer
+func (t *offsetTracker) revoke(topic string, partitions []int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, partition := range partitions {
+		delete(t.partitions, partitionKey{topic: topic, partition: partition})
+	}
+	t.changed.Broadcast()
+}
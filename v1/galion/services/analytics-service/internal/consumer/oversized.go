@@ -0,0 +1,63 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// What happens to events over Config.MaxEventBytes
+const (
+	OversizedDeadLetter = "dead_letter" // dead-lettered (or skipped) without reaching the handler
+	OversizedTruncate   = "truncate"    // the largest data fields are dropped until the event fits
+)
+
+// TruncatedKey is the data field listing what was dropped from a truncated event
+const TruncatedKey = "_truncated"
+
+// truncateEvent drops the largest fields of a JSON event's data until the event fits in max bytes,
+// recording them under TruncatedKey; it fails if the event still doesn't fit without any data
+func truncateEvent(value []byte, max int) ([]byte, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(value, &event); err != nil {
+		return nil, fmt.Errorf("can't truncate event: %w", err)
+	}
+	var data map[string]json.RawMessage
+	if raw, ok := event["data"]; ok {
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, fmt.Errorf("can't truncate event data: %w", err)
+		}
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(data[keys[i]]) != len(data[keys[j]]) {
+			return len(data[keys[i]]) > len(data[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	var dropped []string
+	for _, key := range keys {
+		if key == TruncatedKey {
+			continue
+		}
+		delete(data, key)
+		dropped = append(dropped, key)
+
+		marker, _ := json.Marshal(map[string]interface{}{"dropped": dropped, "original_bytes": len(value)})
+		data[TruncatedKey] = marker
+		event["data"], _ = json.Marshal(data)
+		truncated, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		if len(truncated) <= max {
+			return truncated, nil
+		}
+	}
+	return nil, fmt.Errorf("event is %d bytes, over the %d byte limit even without its data", len(value), max)
+}
@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Source says where a message was read from
+type Source struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+}
+
+// String formats the source as topic/partition/offset
+func (s Source) String() string {
+	return fmt.Sprintf("%s/%d/%d", s.Topic, s.Partition, s.Offset)
+}
+
+// TopicHandler decodes a message's value and hands it on, calling done once it is stored, or failed to be
+// Avro values arrive already converted to JSON
+// A returned error means the value couldn't be decoded: done isn't called and the message is dead-lettered
+// The message's offset is committed only after done(nil), so messages are delivered at least once
+// done may be called from another goroutine, after the handler returns
+// Handlers run concurrently on the consumer's workers, but one partition's messages are handled in order
+// A failed message is retried by calling its handler again with the same value
+type TopicHandler func(value []byte, source Source, done func(error)) error
+
+// Typed returns a TopicHandler decoding JSON (or Avro, converted to JSON) messages into T
+func Typed[T any](handle func(message *T, source Source, done func(error))) TopicHandler {
+	return func(value []byte, source Source, done func(error)) error {
+		message := new(T)
+		if err := json.Unmarshal(value, message); err != nil {
+			return err
+		}
+		handle(message, source, done)
+		return nil
+	}
+}
+
+// Registry maps each consumed topic to its handler
+type Registry struct {
+	handlers map[string]TopicHandler
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]TopicHandler)}
+}
+
+// Register sets the handler for topic, replacing any registered before
+func (r *Registry) Register(topic string, handler TopicHandler) {
+	r.handlers[topic] = handler
+}
+
+// Topics returns the registered topics, sorted
+func (r *Registry) Topics() []string {
+	topics := make([]string, 0, len(r.handlers))
+	for topic := range r.handlers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (r *Registry) handler(topic string) TopicHandler {
+	return r.handlers[topic]
+}
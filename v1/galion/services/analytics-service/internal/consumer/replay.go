@@ -0,0 +1,327 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+
+	"nexus-analytics-service/internal/schemaregistry"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// replayIdleTimeout is how long a replay waits for messages before giving up on partitions not yet
+// read to their end; transaction markers and compaction can leave offsets no message arrives for
+const replayIdleTimeout = 30 * time.Second
+
+// replayProgressInterval is how often a replay logs how far it got
+const replayProgressInterval = 10 * time.Second
+
+// ReplayOptions selects the messages Replay reads from a topic
+type ReplayOptions struct {
+	Topic      string
+	Partitions []int32   // every partition of the topic if empty
+	From       time.Time // start at each partition's first message at or after From; zero to use FromOffset
+	FromOffset int64     // offset to start at when From is zero; partitions whose messages begin later start there
+	To         time.Time // stop before the first message at or after To; zero for the end of each partition as the replay starts
+}
+
+// ReplayStats counts the messages a replay read
+type ReplayStats struct {
+	Read    int64
+	Handled int64 // handled successfully, which includes events the handler chose to drop
+	Failed  int64 // undecodable, rejected as oversized, or failed by the handler on every attempt
+}
+
+// replayRange is the offsets read from one partition: from next up to, but not including, end
+type replayRange struct {
+	next int64
+	end  int64
+}
+
+// Replay reads a range of a topic's messages again and hands them to handler, as a KafkaConsumer would
+// It reads without joining a consumer group, so the group's committed offsets are left alone, and
+// returns once every message read has been handled or failed
+// Failures are retried like the consumer's, then logged and counted; nothing is dead-lettered
+// Replays always read with franz-go, whichever client cfg names
+func Replay(ctx context.Context, cfg Config, opts ReplayOptions, handler TopicHandler, log *logger.Logger) (ReplayStats, error) {
+	clientOpts, err := franzOptions(cfg, log)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+	ranges, err := replayRanges(ctx, clientOpts, opts)
+	if err != nil {
+		return ReplayStats{}, err
+	}
+	if len(ranges) == 0 {
+		log.Info("No messages of %s in the replay range", opts.Topic)
+		return ReplayStats{}, nil
+	}
+
+	offsets := make(map[int32]kgo.Offset, len(ranges))
+	var total int64
+	for partition, r := range ranges {
+		offsets[partition] = kgo.NewOffset().At(r.next)
+		total += r.end - r.next
+	}
+	client, err := kgo.NewClient(append(clientOpts,
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{opts.Topic: offsets}),
+	)...)
+	if err != nil {
+		return ReplayStats{}, fmt.Errorf("failed to create consumer: %w", err)
+	}
+	defer client.Close()
+	log.Info("Replaying up to %d messages from %d partitions of %s", total, len(ranges), opts.Topic)
+
+	rp := &replayer{ctx: ctx, config: cfg, handler: handler, logger: log}
+	progress := time.NewTicker(replayProgressInterval)
+	defer progress.Stop()
+	lastRecord := time.Now()
+	for len(ranges) > 0 && ctx.Err() == nil {
+		select {
+		case <-progress.C:
+			log.Info("Replayed %d of up to %d messages (%d failed)", atomic.LoadInt64(&rp.read), total, atomic.LoadInt64(&rp.failed))
+		default:
+		}
+
+		pollCtx, cancel := context.WithTimeout(ctx, time.Second)
+		fetches := client.PollRecords(pollCtx, pollRecords)
+		cancel()
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+				return
+			}
+			log.Error("Error reading %s [%d]: %v", topic, partition, err)
+		})
+
+		records := fetches.Records()
+		if len(records) == 0 {
+			if time.Since(lastRecord) >= replayIdleTimeout {
+				for partition, r := range ranges {
+					log.Warn("Stopped waiting for %s [%d] at offset %d, before its end at %d", opts.Topic, partition, r.next, r.end)
+				}
+				break
+			}
+			continue
+		}
+		lastRecord = time.Now()
+
+		for _, record := range records {
+			r, ok := ranges[record.Partition]
+			if !ok || record.Offset >= r.end {
+				// Produced after the range, and fetched along with its end
+				continue
+			}
+			rp.start(Source{Topic: record.Topic, Partition: record.Partition, Offset: record.Offset}, record.Value)
+			if record.Offset+1 >= r.end {
+				delete(ranges, record.Partition)
+				client.PauseFetchPartitions(map[string][]int32{opts.Topic: {record.Partition}})
+				continue
+			}
+			ranges[record.Partition] = replayRange{next: record.Offset + 1, end: r.end}
+		}
+	}
+
+	// Save what the handler buffered rather than waiting for its next batch
+	if cfg.Flush != nil {
+		cfg.Flush()
+	}
+	rp.pending.Wait()
+
+	stats := ReplayStats{Read: rp.read, Handled: rp.handled, Failed: rp.failed}
+	if ctx.Err() != nil {
+		return stats, ctx.Err()
+	}
+	return stats, nil
+}
+
+// replayRanges looks up the offsets each partition is replayed from and to; partitions with nothing
+// in range are left out
+func replayRanges(ctx context.Context, clientOpts []kgo.Opt, opts ReplayOptions) (map[int32]replayRange, error) {
+	client, err := kgo.NewClient(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka client: %w", err)
+	}
+	defer client.Close()
+
+	partitions := opts.Partitions
+	if len(partitions) == 0 {
+		if partitions, err = topicPartitions(ctx, client, opts.Topic); err != nil {
+			return nil, err
+		}
+	}
+
+	var starts map[int32]int64
+	if opts.From.IsZero() {
+		starts, err = listOffsets(ctx, client, opts.Topic, partitions, -2) // the earliest offset
+		if err != nil {
+			return nil, err
+		}
+		for partition, earliest := range starts {
+			if opts.FromOffset > earliest {
+				starts[partition] = opts.FromOffset
+			}
+		}
+	} else if starts, err = listOffsets(ctx, client, opts.Topic, partitions, opts.From.UnixMilli()); err != nil {
+		return nil, err
+	}
+
+	ends, err := listOffsets(ctx, client, opts.Topic, partitions, -1) // the latest offset
+	if err != nil {
+		return nil, err
+	}
+	if !opts.To.IsZero() {
+		before, err := listOffsets(ctx, client, opts.Topic, partitions, opts.To.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		for partition, offset := range before {
+			if offset >= 0 {
+				ends[partition] = offset
+			}
+		}
+	}
+
+	ranges := make(map[int32]replayRange)
+	for _, partition := range partitions {
+		// A start of -1 means no message is as recent as From
+		start, end := starts[partition], ends[partition]
+		if start >= 0 && start < end {
+			ranges[partition] = replayRange{next: start, end: end}
+		}
+	}
+	return ranges, nil
+}
+
+// topicPartitions returns the partitions of topic, sorted
+func topicPartitions(ctx context.Context, client *kgo.Client, topic string) ([]int32, error) {
+	req := kmsg.NewPtrMetadataRequest()
+	reqTopic := kmsg.NewMetadataRequestTopic()
+	reqTopic.Topic = kmsg.StringPtr(topic)
+	req.Topics = append(req.Topics, reqTopic)
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var partitions []int32
+	for _, t := range resp.Topics {
+		if err := kerr.ErrorForCode(t.ErrorCode); err != nil {
+			return nil, fmt.Errorf("%s: %w", topic, err)
+		}
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.Partition)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("%s: topic has no partitions", topic)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+	return partitions, nil
+}
+
+// listOffsets asks the partition leaders for the first offset with a timestamp at or after timestamp
+// (in milliseconds), -1 if there is none; -2 asks for the earliest offset and -1 for the latest
+func listOffsets(ctx context.Context, client *kgo.Client, topic string, partitions []int32, timestamp int64) (map[int32]int64, error) {
+	req := kmsg.NewPtrListOffsetsRequest()
+	reqTopic := kmsg.NewListOffsetsRequestTopic()
+	reqTopic.Topic = topic
+	for _, partition := range partitions {
+		reqPartition := kmsg.NewListOffsetsRequestTopicPartition()
+		reqPartition.Partition = partition
+		reqPartition.Timestamp = timestamp
+		reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+	}
+	req.Topics = append(req.Topics, reqTopic)
+	resp, err := req.RequestWith(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return nil, fmt.Errorf("%s [%d]: %w", topic, p.Partition, err)
+			}
+			offsets[p.Partition] = p.Offset
+		}
+	}
+	return offsets, nil
+}
+
+// replayer hands replayed messages to the handler, retrying failures like KafkaConsumer.attempt
+type replayer struct {
+	ctx     context.Context
+	config  Config
+	handler TopicHandler
+	logger  *logger.Logger
+	pending sync.WaitGroup // messages not yet handled or failed
+
+	// Updated atomically, since done is called from the handler's goroutines
+	read    int64
+	handled int64
+	failed  int64
+}
+
+// start hands over a message read from Kafka
+func (rp *replayer) start(source Source, value []byte) {
+	atomic.AddInt64(&rp.read, 1)
+	rp.pending.Add(1)
+	rp.attempt(source, value, 1)
+}
+
+func (rp *replayer) attempt(source Source, value []byte, attempt int) {
+	done := func(err error) {
+		if err == nil {
+			atomic.AddInt64(&rp.handled, 1)
+			rp.pending.Done()
+			return
+		}
+		var permanent *PermanentError
+		if errors.As(err, &permanent) || attempt >= rp.config.MaxAttempts || rp.ctx.Err() != nil {
+			rp.fail(source, err, attempt)
+			return
+		}
+		rp.logger.Warn("Failed to replay message %s (attempt %d of %d): %v", source, attempt, rp.config.MaxAttempts, err)
+		time.AfterFunc(rp.config.RetryBackoff<<(attempt-1), func() {
+			rp.attempt(source, value, attempt+1)
+		})
+	}
+
+	decoded, err := rp.config.decode(value)
+	if errors.Is(err, schemaregistry.ErrUnavailable) {
+		done(err)
+		return
+	}
+	if err == nil && rp.config.MaxEventBytes > 0 && len(decoded) > rp.config.MaxEventBytes {
+		if decoded, err = rp.config.shrink(decoded); err != nil {
+			metrics.RecordOversizedEvent("rejected")
+			rp.fail(source, err, attempt)
+			return
+		}
+		metrics.RecordOversizedEvent("truncated")
+	}
+	if err == nil {
+		err = rp.handler(decoded, source, done)
+	}
+	if err != nil {
+		rp.fail(source, fmt.Errorf("failed to decode: %w", err), attempt)
+	}
+}
+
+// fail gives up on a message
+func (rp *replayer) fail(source Source, err error, attempts int) {
+	rp.logger.Error("Failed to replay message %s after %d attempts: %v", source, attempts, err)
+	atomic.AddInt64(&rp.failed, 1)
+	rp.pending.Done()
+}
@@ -0,0 +1,31 @@
+package consumer
+
+// Security protocols, as librdkafka names them
+const (
+	ProtocolPlaintext     = "plaintext"
+	ProtocolSSL           = "ssl"
+	ProtocolSASLPlaintext = "sasl_plaintext"
+	ProtocolSASLSSL       = "sasl_ssl"
+)
+
+// Security configures how the consumer and the dead-letter producer connect to the brokers
+type Security struct {
+	Protocol      string // plaintext, ssl, sasl_plaintext or sasl_ssl; empty is plaintext
+	SASLMechanism string // PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	SASLUsername  string
+	SASLPassword  string
+	CAFile        string // CA bundle the brokers' certificates are checked against; system roots if empty
+	CertFile      string // client certificate (PEM) for mutual TLS
+	KeyFile       string
+	KeyPassword   string // password of an encrypted KeyFile
+}
+
+// SASL reports whether the protocol authenticates with SASL
+func (s Security) SASL() bool {
+	return s.Protocol == ProtocolSASLPlaintext || s.Protocol == ProtocolSASLSSL
+}
+
+// TLS reports whether the protocol encrypts connections
+func (s Security) TLS() bool {
+	return s.Protocol == ProtocolSSL || s.Protocol == ProtocolSASLSSL
+}
@@ -0,0 +1,58 @@
+// Package dedup drops events a producer sent more than once, recognised by their event_id
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix namespaces the event IDs seen within the window
+const keyPrefix = "analytics:dedup:"
+
+// Deduplicator remembers event IDs in Redis for a window, shared by every replica
+type Deduplicator struct {
+	client redis.UniversalClient
+	window time.Duration
+}
+
+// New connects to redisURL; it does not check the connection, the first event does
+func New(redisURL string, window time.Duration) (*Deduplicator, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return &Deduplicator{client: redis.NewClient(opts), window: window}, nil
+}
+
+// Duplicate claims eventID for source, the message carrying it, and reports whether another
+// message claimed it within the window
+// A message handled again (a retry or a redelivery) matches its own claim, so it isn't a duplicate
+func (d *Deduplicator) Duplicate(ctx context.Context, eventID, source string) (bool, error) {
+	key := keyPrefix + eventID
+	claimed, err := d.client.SetNX(ctx, key, source, d.window).Result()
+	if err != nil || claimed {
+		return false, err
+	}
+	owner, err := d.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		// Expired in between; the next message with this ID claims it again
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner != source, nil
+}
+
+// Ping checks that Redis is reachable
+func (d *Deduplicator) Ping(ctx context.Context) error {
+	return d.client.Ping(ctx).Err()
+}
+
+// Close closes the Redis connection
+func (d *Deduplicator) Close() error {
+	return d.client.Close()
+}
@@ -0,0 +1,98 @@
+// Package enrichment adds fields derived from an event's data, such as where its IP address is,
+// before the event is stored
+package enrichment
+
+import (
+	"fmt"
+	"strings"
+
+	"nexus-analytics-service/internal/consumer"
+)
+
+// Key is the data field enrichers write under, one object per enricher
+const Key = "enrichment"
+
+// Enricher names
+const (
+	NameGeoIP     = "geoip"
+	NameUserAgent = "user_agent"
+	NameReferrer  = "referrer"
+)
+
+// Names lists the built-in enrichers
+var Names = []string{NameGeoIP, NameUserAgent, NameReferrer}
+
+// Enricher derives fields from an event
+type Enricher interface {
+	Name() string
+	// Enrich returns the derived fields, or nil if the event has nothing to derive them from
+	Enrich(event *consumer.Event) map[string]interface{}
+}
+
+// Pipeline runs enrichers in order
+type Pipeline struct {
+	enrichers []Enricher
+}
+
+// NewPipeline creates a pipeline running enrichers in the order given
+func NewPipeline(enrichers ...Enricher) *Pipeline {
+	return &Pipeline{enrichers: enrichers}
+}
+
+// Enrich adds each enricher's fields to event.Data under Key, replacing whatever the producer put there,
+// and returns the names of the enrichers that added any
+func (p *Pipeline) Enrich(event *consumer.Event) []string {
+	var added []string
+	enriched := make(map[string]interface{})
+	for _, enricher := range p.enrichers {
+		if fields := enricher.Enrich(event); len(fields) > 0 {
+			enriched[enricher.Name()] = fields
+			added = append(added, enricher.Name())
+		}
+	}
+	if len(enriched) == 0 {
+		return nil
+	}
+	if event.Data == nil {
+		event.Data = make(map[string]interface{})
+	}
+	event.Data[Key] = enriched
+	return added
+}
+
+// Options configures the built-in enrichers
+type Options struct {
+	GeoIPDatabase string // MaxMind DB file; needed for geoip
+}
+
+// Build creates a pipeline of the named built-in enrichers
+func Build(names []string, opts Options) (*Pipeline, error) {
+	enrichers := make([]Enricher, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case NameGeoIP:
+			geo, err := NewGeoIP(opts.GeoIPDatabase)
+			if err != nil {
+				return nil, err
+			}
+			enrichers = append(enrichers, geo)
+		case NameUserAgent:
+			enrichers = append(enrichers, UserAgent{})
+		case NameReferrer:
+			enrichers = append(enrichers, Referrer{})
+		default:
+			return nil, fmt.Errorf("unknown enricher %q (available: %s)", name, strings.Join(Names, ", "))
+		}
+	}
+	return NewPipeline(enrichers...), nil
+}
+
+// dataString returns the first of keys holding a non-empty string in the event's data
+func dataString(event *consumer.Event, keys ...string) string {
+	for _, key := range keys {
+		if s, ok := event.Data[key].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
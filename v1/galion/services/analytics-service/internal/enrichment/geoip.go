@@ -0,0 +1,92 @@
+package enrichment
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"nexus-analytics-service/internal/consumer"
+)
+
+// ipFields are the data fields producers put client IP addresses in, in order of preference
+var ipFields = []string{"ip_address", "client_ip", "ip"}
+
+// GeoIP adds the country, region and city of the event's IP address from a MaxMind DB file
+// (GeoLite2-City or GeoLite2-Country, or DB-IP's equivalents)
+type GeoIP struct {
+	db *mmdb
+}
+
+// NewGeoIP loads a MaxMind DB file into memory
+func NewGeoIP(path string) (*GeoIP, error) {
+	if path == "" {
+		return nil, fmt.Errorf("the %s enricher needs a GeoIP database", NameGeoIP)
+	}
+	db, err := openMMDB(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GeoIP database %s: %w", path, err)
+	}
+	return &GeoIP{db: db}, nil
+}
+
+// Name implements Enricher
+func (g *GeoIP) Name() string { return NameGeoIP }
+
+// Enrich implements Enricher; private and loopback addresses are skipped
+func (g *GeoIP) Enrich(event *consumer.Event) map[string]interface{} {
+	ip := parseIP(dataString(event, ipFields...))
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+		return nil
+	}
+	record, err := g.db.lookup(ip)
+	if err != nil || record == nil {
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+	if country := child(record, "country"); country != nil {
+		setString(fields, "country", country["iso_code"])
+		setString(fields, "country_name", englishName(country))
+	}
+	if subdivisions, ok := record["subdivisions"].([]interface{}); ok && len(subdivisions) > 0 {
+		if region, ok := subdivisions[0].(map[string]interface{}); ok {
+			setString(fields, "region", region["iso_code"])
+			setString(fields, "region_name", englishName(region))
+		}
+	}
+	if city := child(record, "city"); city != nil {
+		setString(fields, "city", englishName(city))
+	}
+	return fields
+}
+
+// parseIP reads an address as producers send it: bare, with a port (as in Go's RemoteAddr), or as an
+// X-Forwarded-For list, whose first entry is the client
+func parseIP(s string) net.IP {
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(s)
+}
+
+// child returns the map under key, or nil
+func child(record map[string]interface{}, key string) map[string]interface{} {
+	m, _ := record[key].(map[string]interface{})
+	return m
+}
+
+// englishName returns a place's English name
+func englishName(place map[string]interface{}) interface{} {
+	return child(place, "names")["en"]
+}
+
+// setString sets key to value if it is a non-empty string
+func setString(fields map[string]interface{}, key string, value interface{}) {
+	if s, ok := value.(string); ok && s != "" {
+		fields[key] = s
+	}
+}
@@ -0,0 +1,317 @@
+package enrichment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata at the end of a MaxMind DB file
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// dataSeparator is the zeroed gap between the search tree and the data section
+const dataSeparator = 16
+
+// mmdb reads a MaxMind DB file (GeoLite2, GeoIP2 or DB-IP), held in memory
+// See https://maxmind.github.io/MaxMind-DB/ for the format
+type mmdb struct {
+	buf        []byte
+	data       []byte // data section, which pointers are relative to
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // node reached after the 96 zero bits IPv4 addresses are looked up under in IPv6 trees
+}
+
+// openMMDB reads a MaxMind DB file
+func openMMDB(path string) (*mmdb, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind DB file: metadata not found")
+	}
+	meta := &decoder{buf: buf[start+len(metadataMarker):]}
+	value, _, err := meta.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid metadata: not a map")
+	}
+
+	db := &mmdb{buf: buf}
+	db.nodeCount = metadataUint(metadata, "node_count")
+	db.recordSize = metadataUint(metadata, "record_size")
+	db.ipVersion = metadataUint(metadata, "ip_version")
+	switch db.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+dataSeparator > uint(start) {
+		return nil, errors.New("search tree larger than the file")
+	}
+	db.data = buf[treeSize+dataSeparator : start]
+
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// metadataUint reads a numeric metadata field, 0 if it is missing
+func metadataUint(metadata map[string]interface{}, key string) uint {
+	switch v := metadata[key].(type) {
+	case uint64:
+		return uint(v)
+	case int32:
+		return uint(v)
+	}
+	return 0
+}
+
+// lookup returns the record for ip, or nil if the database has none
+func (db *mmdb) lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	bits := ip.To4()
+	if bits != nil {
+		if db.ipVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else {
+		if db.ipVersion == 4 {
+			return nil, nil
+		}
+		bits = ip.To16()
+		if bits == nil {
+			return nil, nil
+		}
+	}
+
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		bit := (bits[i/8] >> (7 - uint(i%8))) & 1
+		node = db.record(node, uint(bit))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("invalid search tree: ran out of address bits")
+	}
+
+	offset := node - db.nodeCount - dataSeparator
+	d := &decoder{buf: db.data}
+	value, _, err := d.decode(offset)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a node
+func (db *mmdb) record(node, bit uint) uint {
+	b := db.buf
+	switch db.recordSize {
+	case 24:
+		base := node*6 + bit*3
+		return uint(b[base])<<16 | uint(b[base+1])<<8 | uint(b[base+2])
+	case 28:
+		base := node * 7
+		if bit == 0 {
+			return uint(b[base+3]&0xF0)<<20 | uint(b[base])<<16 | uint(b[base+1])<<8 | uint(b[base+2])
+		}
+		return uint(b[base+3]&0x0F)<<24 | uint(b[base+4])<<16 | uint(b[base+5])<<8 | uint(b[base+6])
+	default:
+		base := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(b[base:]))
+	}
+}
+
+// MaxMind DB data types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBoolean
+	typeFloat
+)
+
+// decoder decodes values from a MaxMind DB data section
+type decoder struct {
+	buf []byte
+}
+
+var errTruncated = errors.New("invalid data: truncated value")
+
+// decode returns the value at offset and the offset after it; pointers are followed
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	kind := uint(ctrl >> 5)
+
+	if kind == typePointer {
+		target, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		if target < uint(len(d.buf)) && d.buf[target]>>5 == typePointer {
+			return nil, 0, errors.New("invalid data: pointer to a pointer")
+		}
+		value, _, err := d.decode(target)
+		return value, next, err
+	}
+	if kind == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errTruncated
+		}
+		kind = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1F)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, errTruncated
+		}
+		extra := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch kind {
+	case typeMap:
+		m := make(map[string]interface{})
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("invalid data: map key is not a string")
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		var a []interface{}
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBoolean:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errTruncated
+	}
+	raw := d.buf[offset : offset+size]
+	offset += size
+	switch kind {
+	case typeString:
+		return string(raw), offset, nil
+	case typeBytes:
+		return append([]byte(nil), raw...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid data: double not 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid data: float not 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		var v uint64
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, offset, nil
+	case typeInt32:
+		var v uint32
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		return int32(v), offset, nil
+	case typeUint128:
+		// Not used by the fields looked up here
+		return append([]byte(nil), raw...), offset, nil
+	}
+	return nil, 0, fmt.Errorf("invalid data: unknown type %d", kind)
+}
+
+// pointer returns the data offset a pointer refers to and the offset after the pointer
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint((ctrl>>3)&0x3) + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errTruncated
+	}
+	var target uint
+	if n < 4 {
+		target = uint(ctrl & 0x7)
+	}
+	for _, b := range d.buf[offset : offset+n] {
+		target = target<<8 | uint(b)
+	}
+	switch n {
+	case 2:
+		target += 2048
+	case 3:
+		target += 526336
+	}
+	return target, offset + n, nil
+}
@@ -0,0 +1,87 @@
+package enrichment
+
+import (
+	"net/url"
+	"strings"
+
+	"nexus-analytics-service/internal/consumer"
+)
+
+// Referrer mediums
+const (
+	MediumSearch   = "search"
+	MediumSocial   = "social"
+	MediumReferral = "referral"
+)
+
+// referrerSource is a site traffic arrives from; a host matches if it is, or is under, one of
+// domains, or if one of its labels is one of labels (for engines with a domain per country)
+type referrerSource struct {
+	name    string
+	medium  string
+	domains []string
+	labels  []string
+}
+
+var referrerSources = []referrerSource{
+	{name: "Google", medium: MediumSearch, labels: []string{"google"}},
+	{name: "Bing", medium: MediumSearch, domains: []string{"bing.com"}},
+	{name: "DuckDuckGo", medium: MediumSearch, domains: []string{"duckduckgo.com"}},
+	{name: "Yahoo", medium: MediumSearch, labels: []string{"yahoo"}},
+	{name: "Yandex", medium: MediumSearch, labels: []string{"yandex"}},
+	{name: "Baidu", medium: MediumSearch, domains: []string{"baidu.com"}},
+	{name: "Ecosia", medium: MediumSearch, domains: []string{"ecosia.org"}},
+	{name: "Brave Search", medium: MediumSearch, domains: []string{"search.brave.com"}},
+	{name: "Facebook", medium: MediumSocial, domains: []string{"facebook.com", "fb.com", "fb.me"}},
+	{name: "Instagram", medium: MediumSocial, domains: []string{"instagram.com"}},
+	{name: "X", medium: MediumSocial, domains: []string{"twitter.com", "x.com", "t.co"}},
+	{name: "LinkedIn", medium: MediumSocial, domains: []string{"linkedin.com", "lnkd.in"}},
+	{name: "Reddit", medium: MediumSocial, domains: []string{"reddit.com"}},
+	{name: "YouTube", medium: MediumSocial, domains: []string{"youtube.com", "youtu.be"}},
+	{name: "TikTok", medium: MediumSocial, domains: []string{"tiktok.com"}},
+	{name: "Hacker News", medium: MediumSocial, domains: []string{"news.ycombinator.com"}},
+}
+
+// Referrer adds the host the event's referrer points to and the kind of site it is
+type Referrer struct{}
+
+// Name implements Enricher
+func (Referrer) Name() string { return NameReferrer }
+
+// Enrich implements Enricher for http and https referrers; the HTTP header's spelling, referer, is accepted too
+func (Referrer) Enrich(event *consumer.Event) map[string]interface{} {
+	u, err := url.Parse(dataString(event, "referrer", "referer"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	fields := map[string]interface{}{"host": host, "medium": MediumReferral}
+	if source := sourceOf(host); source != nil {
+		fields["medium"] = source.medium
+		fields["source"] = source.name
+	}
+	return fields
+}
+
+// sourceOf returns the known site host belongs to, or nil
+func sourceOf(host string) *referrerSource {
+	labels := strings.Split(host, ".")
+	for i := range referrerSources {
+		source := &referrerSources[i]
+		for _, domain := range source.domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return source
+			}
+		}
+		// The last label is the top-level domain
+		for _, label := range labels[:len(labels)-1] {
+			for _, want := range source.labels {
+				if label == want {
+					return source
+				}
+			}
+		}
+	}
+	return nil
+}
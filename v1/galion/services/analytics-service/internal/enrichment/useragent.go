@@ -0,0 +1,126 @@
+package enrichment
+
+import (
+	"strings"
+
+	"nexus-analytics-service/internal/consumer"
+)
+
+// Device types
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// uaMatch names what a user agent containing one of its tokens runs; the major version follows the
+// version token, or the matched token if there is none (a version token of "-" never matches)
+type uaMatch struct {
+	name    string
+	tokens  []string
+	version string
+}
+
+// browsers are checked in order, since most user agents also claim to be Safari, Chrome or both
+var browsers = []uaMatch{
+	{name: "Edge", tokens: []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}},
+	{name: "Opera", tokens: []string{"OPR/", "OPiOS/"}},
+	{name: "Samsung Internet", tokens: []string{"SamsungBrowser/"}},
+	{name: "Yandex Browser", tokens: []string{"YaBrowser/"}},
+	{name: "Firefox", tokens: []string{"Firefox/", "FxiOS/"}},
+	{name: "Chrome", tokens: []string{"CriOS/", "Chrome/"}},
+	{name: "Safari", tokens: []string{"Safari/"}, version: "Version/"},
+	{name: "Internet Explorer", tokens: []string{"MSIE ", "Trident/"}, version: "rv:"},
+}
+
+// systems are checked in order, since Android and ChromeOS user agents also mention Linux
+// Windows and macOS versions are left out: browsers report Windows 11 as 10 and freeze macOS at 10.15
+var systems = []uaMatch{
+	{name: "Windows Phone", tokens: []string{"Windows Phone"}},
+	{name: "Windows", tokens: []string{"Windows"}, version: "-"},
+	{name: "iOS", tokens: []string{"iPhone", "iPad", "iPod"}, version: "OS "},
+	{name: "Android", tokens: []string{"Android"}, version: "Android "},
+	{name: "ChromeOS", tokens: []string{"CrOS"}},
+	{name: "macOS", tokens: []string{"Macintosh", "Mac OS X"}, version: "-"},
+	{name: "Linux", tokens: []string{"Linux"}},
+}
+
+// botTokens mark crawlers, monitors and scripts
+var botTokens = []string{"bot", "crawl", "spider", "slurp", "curl/", "wget/", "python-requests", "go-http-client", "headless"}
+
+// UserAgent adds the browser, operating system and device type of the event's user_agent
+type UserAgent struct{}
+
+// Name implements Enricher
+func (UserAgent) Name() string { return NameUserAgent }
+
+// Enrich implements Enricher
+func (UserAgent) Enrich(event *consumer.Event) map[string]interface{} {
+	ua := dataString(event, "user_agent")
+	if ua == "" {
+		return nil
+	}
+
+	fields := map[string]interface{}{"device": deviceType(ua)}
+	if name, version := match(ua, browsers); name != "" {
+		fields["browser"] = name
+		if version != "" {
+			fields["browser_version"] = version
+		}
+	}
+	if name, version := match(ua, systems); name != "" {
+		fields["os"] = name
+		if version != "" {
+			fields["os_version"] = version
+		}
+	}
+	return fields
+}
+
+// deviceType guesses what kind of device sent ua
+func deviceType(ua string) string {
+	lower := strings.ToLower(ua)
+	for _, token := range botTokens {
+		if strings.Contains(lower, token) {
+			return DeviceBot
+		}
+	}
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(lower, "tablet"),
+		strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod") || strings.Contains(ua, "Windows Phone"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// match returns the first entry of table matching ua, and its major version if ua has one
+func match(ua string, table []uaMatch) (name, version string) {
+	for _, m := range table {
+		for _, token := range m.tokens {
+			i := strings.Index(ua, token)
+			if i < 0 {
+				continue
+			}
+			if m.version != "" {
+				if j := strings.Index(ua, m.version); j >= 0 {
+					return m.name, majorVersion(ua[j+len(m.version):])
+				}
+				return m.name, ""
+			}
+			return m.name, majorVersion(ua[i+len(token):])
+		}
+	}
+	return "", ""
+}
+
+// majorVersion returns the leading number of s, which is the version following a token
+func majorVersion(s string) string {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	return s[:end]
+}
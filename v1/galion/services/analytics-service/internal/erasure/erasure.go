@@ -0,0 +1,183 @@
+// Package erasure erases a user's events when the user asks, as data protection law requires,
+// returning a receipt of what was erased and logging it
+package erasure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+	"nexus-common/requestid"
+)
+
+// maxUserIDLength is the longest user_id events are stored with
+const maxUserIDLength = 100
+
+// ErrInvalid is returned by Erase for a request that can't be carried out
+var ErrInvalid = errors.New("invalid erasure request")
+
+// Receipt records an erasure, for the user and for compliance
+type Receipt struct {
+	ID       string           `json:"receipt_id"`
+	TenantID string           `json:"tenant_id,omitempty"`
+	UserID   string           `json:"user_id"`
+	Mode     string           `json:"mode"`
+	ErasedAt time.Time        `json:"erased_at"`
+	Rows     map[string]int64 `json:"rows"` // deleted or anonymized, per table
+	// Copies are the documents deleted or anonymized in each secondary copy of the events
+	Copies map[string]int64 `json:"copies,omitempty"`
+	// ArchivedFiles may hold the user's events and have to be dealt with separately
+	ArchivedFiles []string `json:"archived_files,omitempty"`
+	// NotErased are the copies of the events erasing leaves alone; they may hold the user's events and
+	// have to be erased separately
+	NotErased []string `json:"not_erased,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// Copy is a secondary store of events, such as the search indices, erased along with the database
+type Copy interface {
+	// EraseUser deletes, or anonymizes under pseudonym, the copied events of userIDs of tenantID,
+	// returning how many changed
+	EraseUser(ctx context.Context, tenantID string, userIDs []string, mode, pseudonym string) (int64, error)
+}
+
+// namedCopy is a copy as receipts name it
+type namedCopy struct {
+	name string
+	copy Copy
+}
+
+// Eraser erases users' events
+type Eraser struct {
+	sink      storage.Erasable
+	copies    []namedCopy
+	notErased []string
+	hash      func(string) string
+	logger    *logger.Logger
+}
+
+// New creates an eraser; hash is how user IDs are hashed before they are stored, nil if they aren't
+func New(sink storage.Erasable, hash func(string) string, log *logger.Logger) *Eraser {
+	return &Eraser{sink: sink, hash: hash, logger: log}
+}
+
+// AlsoErase has erasures erase the user's events from c too, once they are erased from the
+// database, recording in receipts how many changed under name
+func (e *Eraser) AlsoErase(name string, c Copy) *Eraser {
+	e.copies = append(e.copies, namedCopy{name: name, copy: c})
+	return e
+}
+
+// NotErased has receipts list name as a copy of the events the user's aren't erased from
+func (e *Eraser) NotErased(name string) *Eraser {
+	e.notErased = append(e.notErased, name)
+	return e
+}
+
+// Erase deletes, or with storage.ErasureAnonymize anonymizes, the events of userID of tenantID, and
+// the rollups counting them, then the copies given to AlsoErase; requestedBy is logged with the receipt
+// Events are matched by the user ID both as given and hashed, so those stored before user_id
+// hashing was turned on are erased too; other tenants' users of the same ID are left alone
+func (e *Eraser) Erase(ctx context.Context, tenantID, userID, mode, requestedBy string) (Receipt, error) {
+	receipt := Receipt{ID: requestid.New(), TenantID: tenantID, UserID: userID, Mode: mode, Rows: map[string]int64{}, NotErased: e.notErased}
+	if userID == "" || len(userID) > maxUserIDLength {
+		return receipt, fmt.Errorf("%w: user_id must be 1 to %d characters", ErrInvalid, maxUserIDLength)
+	}
+	if len(tenantID) > storage.MaxTenantIDLength {
+		return receipt, fmt.Errorf("%w: tenant must be at most %d characters", ErrInvalid, storage.MaxTenantIDLength)
+	}
+	if mode != storage.ErasureDelete && mode != storage.ErasureAnonymize {
+		return receipt, fmt.Errorf("%w: mode must be %s or %s", ErrInvalid, storage.ErasureDelete, storage.ErasureAnonymize)
+	}
+	userIDs := []string{userID}
+	if e.hash != nil {
+		userIDs = append(userIDs, e.hash(userID))
+	}
+
+	pseudonym := "anonymized-" + receipt.ID
+	erasure, err := e.sink.EraseUser(ctx, tenantID, userIDs, mode, pseudonym)
+	metrics.RecordUserErasure(mode, err)
+	receipt.ErasedAt = time.Now().UTC()
+	if err != nil {
+		receipt.Error = err.Error()
+		e.logger.Error("Compliance: failed to %s the events of a user of tenant %q for %s (receipt %s): %v", mode, tenantID, requestedBy, receipt.ID, err)
+		return receipt, err
+	}
+	receipt.Rows = erasure.Rows
+	receipt.ArchivedFiles = erasure.ArchivedFiles
+
+	// Erasing is idempotent, so a request failing on a copy can be run again
+	for _, c := range e.copies {
+		if receipt.Copies == nil {
+			receipt.Copies = map[string]int64{}
+		}
+		changed, err := c.copy.EraseUser(ctx, tenantID, userIDs, mode, pseudonym)
+		receipt.Copies[c.name] = changed
+		if err != nil {
+			receipt.Error = err.Error()
+			e.logger.Error("Compliance: %s the events of a user of tenant %q in the database but failed to in %s, for %s (receipt %s): %v", erased(mode), tenantID, c.name, requestedBy, receipt.ID, err)
+			return receipt, err
+		}
+	}
+	// The log names the receipt, not the user: even a hash of the ID could be reversed by hashing
+	// candidate IDs, such as email addresses
+	e.logger.Info("Compliance: %s the events of a user of tenant %q for %s (receipt %s): rows %v, copies %v, %d archived files not changed, not erased from %v", erased(mode), tenantID, requestedBy, receipt.ID, erasure.Rows, receipt.Copies, len(erasure.ArchivedFiles), e.notErased)
+	return receipt, nil
+}
+
+// ServeHTTP erases a user's events on DELETE /users/{user_id}/events, returning the Receipt as
+// JSON; ?mode=anonymize keeps the events under a pseudonym instead of deleting them, and ?tenant=
+// names the user's tenant, none if not given
+func (e *Eraser) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	// The escaped path, so user IDs may contain slashes
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, "/users/") || !strings.HasSuffix(path, "/events") {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"not found"}`))
+		return
+	}
+	userID, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "/users/"), "/events"))
+	if err != nil {
+		userID = ""
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = storage.ErasureDelete
+	}
+
+	// The erasure outlives a client that disconnects, so its receipt is always logged
+	receipt, err := e.Erase(context.WithoutCancel(r.Context()), r.URL.Query().Get("tenant"), userID, mode, r.RemoteAddr)
+	switch {
+	case errors.Is(err, ErrInvalid):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// erased is how a mode is logged
+func erased(mode string) string {
+	if mode == storage.ErasureAnonymize {
+		return "anonymized"
+	}
+	return "deleted"
+}
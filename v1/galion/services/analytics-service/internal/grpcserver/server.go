@@ -0,0 +1,104 @@
+// Package grpcserver creates the gRPC server other backend services read analytics on, which checks
+// the key every call carries and records how calls end
+package grpcserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip" // accept gzip-compressed calls
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"nexus-analytics-service/internal/tenantkeys"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Keys are carried as metadata of a call: the admin API key as x-admin-key, or a tenant's as x-api-key
+const (
+	apiKeyMetadata    = "x-admin-key"
+	tenantKeyMetadata = "x-api-key"
+)
+
+// interceptor authenticates the calls of a server and records them
+type interceptor struct {
+	apiKey     string
+	tenantKeys map[string]string
+	logger     *logger.Logger
+}
+
+// New creates a server only serving calls carrying apiKey, or one of tenantKeys, which are by key;
+// calls with a tenant's key have the tenant in their context, as Tenant returns it
+// Errors that aren't a gRPC status are logged, and returned to the caller as Internal
+func New(apiKey string, tenantKeys map[string]string, log *logger.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	i := &interceptor{apiKey: apiKey, tenantKeys: tenantKeys, logger: log}
+	return grpc.NewServer(append(opts, grpc.UnaryInterceptor(i.unary))...)
+}
+
+// tenantKey is the context key of the tenant a call's key is for
+type tenantKey struct{}
+
+// Tenant is the tenant a call may only read, that of its key, or false for the admin API key
+func Tenant(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok
+}
+
+func (i *interceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	started := time.Now()
+	response, err := i.call(ctx, req, info, handler)
+	metrics.RecordGRPCRequest(info.FullMethod, status.Code(err).String(), time.Since(started))
+	return response, err
+}
+
+// call authenticates a call and runs its method
+func (i *interceptor) call(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if i.apiKey == "" || subtle.ConstantTimeCompare([]byte(first(md, apiKeyMetadata)), []byte(i.apiKey)) != 1 {
+		tenantID, ok := tenantkeys.Tenant(i.tenantKeys, first(md, tenantKeyMetadata))
+		if !ok {
+			i.logger.Warn("Rejected gRPC call %s from %s", info.FullMethod, remoteAddr(ctx))
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid x-admin-key or x-api-key")
+		}
+		ctx = context.WithValue(ctx, tenantKey{}, tenantID)
+	}
+
+	response, err := handler(ctx, req)
+	if err == nil {
+		return response, nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return nil, status.Error(codes.Canceled, "canceled")
+	}
+	i.logger.Error("gRPC call %s failed: %v", info.FullMethod, err)
+	return nil, status.Error(codes.Internal, "internal error")
+}
+
+// first is the first value of key in md, or empty
+func first(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// remoteAddr is the address a call came from, for logs
+func remoteAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return "unknown"
+}
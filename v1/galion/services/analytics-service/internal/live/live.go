@@ -0,0 +1,230 @@
+// Package live fans stored events out to dashboards as they are consumed, over server-sent events,
+// each subscriber with its own filters, sample rate and buffer
+package live
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// heartbeat is how often an idle feed sends a comment, so proxies don't close it
+const heartbeat = 15 * time.Second
+
+// writeTimeout bounds each write to a subscriber, so a client that stopped reading is let go
+const writeTimeout = 10 * time.Second
+
+// Hub broadcasts stored events to the live feeds subscribed to it
+type Hub struct {
+	maxSubscribers int
+	buffer         int
+	hash           func(string) string
+	logger         *logger.Logger
+
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// subscriber is a live feed, and the events waiting to be sent to it
+type subscriber struct {
+	tenantID  string
+	eventType string
+	service   string
+	userID    string
+	sample    float64 // fraction of matching events sent, in (0, 1]
+
+	events  chan storage.Event
+	dropped atomic.Int64 // events dropped since the last sent, while the buffer was full
+}
+
+// New creates a hub serving at most maxSubscribers feeds, each buffering up to buffer events for a
+// slow client before events are dropped; hash is how user IDs are hashed before they are stored,
+// nil if they aren't
+func New(maxSubscribers, buffer int, hash func(string) string, log *logger.Logger) *Hub {
+	return &Hub{
+		maxSubscribers: maxSubscribers,
+		buffer:         buffer,
+		hash:           hash,
+		logger:         log,
+		subscribers:    make(map[*subscriber]struct{}),
+		closed:         make(chan struct{}),
+	}
+}
+
+// Publish sends a stored event to the feeds it matches; it never blocks, dropping the event for
+// feeds whose buffer is full
+func (h *Hub) Publish(e storage.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subscribers {
+		if !s.matches(e) || (s.sample < 1 && rand.Float64() >= s.sample) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.dropped.Add(1)
+			metrics.RecordLiveDropped()
+		}
+	}
+}
+
+// Close ends every feed, so the server they are served on can shut down
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.closed) })
+}
+
+// matches reports whether e passes the feed's filters
+func (s *subscriber) matches(e storage.Event) bool {
+	return e.TenantID == s.tenantID &&
+		(s.eventType == "" || e.EventType == s.eventType) &&
+		(s.service == "" || e.Service == s.service) &&
+		(s.userID == "" || e.UserID == s.userID)
+}
+
+// subscribe adds a feed, unless there are already as many as allowed
+func (h *Hub) subscribe(s *subscriber) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.subscribers) >= h.maxSubscribers {
+		return false
+	}
+	h.subscribers[s] = struct{}{}
+	metrics.UpdateLiveSubscribers(len(h.subscribers))
+	return true
+}
+
+// unsubscribe removes a feed
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+	metrics.UpdateLiveSubscribers(len(h.subscribers))
+}
+
+// event is a stored event as the feed sends it
+type event struct {
+	EventType string                 `json:"event_type"`
+	UserID    string                 `json:"user_id"`
+	Service   string                 `json:"service"`
+	TenantID  string                 `json:"tenant_id,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Topic     string                 `json:"topic,omitempty"`
+	Partition int32                  `json:"partition,omitempty"`
+	Offset    int64                  `json:"offset,omitempty"`
+}
+
+// ServeHTTP serves GET /api/v1/analytics/live, streaming events as they are stored, as server-sent
+// events, for the ?tenant= given, or for events without a tenant; events are filtered by
+// ?event_type=, ?service= and ?user_id=, and ?sample= sends only that fraction of them, from 0.001
+// to 1 (the default)
+// Each event is an "event" message with the event as JSON; when a slow client missed events, a
+// "dropped" message with how many comes before the next one
+func (h *Hub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	params := req.URL.Query()
+	s := &subscriber{
+		tenantID:  params.Get("tenant"),
+		eventType: params.Get("event_type"),
+		service:   params.Get("service"),
+		userID:    params.Get("user_id"),
+		sample:    1,
+	}
+	// Events are stored under the hashed ID, so that is what is looked for
+	if s.userID != "" && h.hash != nil {
+		s.userID = h.hash(s.userID)
+	}
+	if value := params.Get("sample"); value != "" {
+		sample, err := strconv.ParseFloat(value, 64)
+		if err != nil || sample < 0.001 || sample > 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"sample must be a fraction between 0.001 and 1"}`))
+			return
+		}
+		s.sample = sample
+	}
+	s.events = make(chan storage.Event, h.buffer)
+	if !h.subscribe(s) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"too many live feeds"}`))
+		return
+	}
+	defer h.unsubscribe(s)
+	h.logger.Debug("Live feed opened by %s (tenant %q)", req.RemoteAddr, s.tenantID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	send := func(message string) bool {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := w.Write([]byte(message)); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send("retry: 5000\n\n") {
+		return
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-h.closed:
+			return
+		case <-ticker.C:
+			if !send(": ping\n\n") {
+				return
+			}
+		case e := <-s.events:
+			message := ""
+			if dropped := s.dropped.Swap(0); dropped > 0 {
+				message = fmt.Sprintf("event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+			}
+			raw, err := json.Marshal(event{
+				EventType: e.EventType,
+				UserID:    e.UserID,
+				Service:   e.Service,
+				TenantID:  e.TenantID,
+				Timestamp: e.Timestamp,
+				Data:      e.Data,
+				Topic:     e.Topic,
+				Partition: e.Partition,
+				Offset:    e.Offset,
+			})
+			if err != nil {
+				h.logger.Warn("Failed to encode live event: %v", err)
+				continue
+			}
+			if !send(message + "event: event\ndata: " + string(raw) + "\n\n") {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package privacy pseudonymizes and strips personal data from events before they are stored
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"nexus-analytics-service/internal/consumer"
+)
+
+// Actions taken on a field
+const (
+	ActionHashed   = "hashed"
+	ActionRedacted = "redacted"
+)
+
+// UserIDField names the top-level user_id in Config.HashFields and in Change.Field
+const UserIDField = "user_id"
+
+// Config sets which fields are hashed and which are removed
+// Fields are keys of the event's data; nested keys are joined with dots, as in profile.email
+type Config struct {
+	HashKey      []byte   // HMAC key; hashes can't be reversed by guessing inputs without it
+	HashFields   []string // replaced by their keyed hash; UserIDField hashes the event's user_id
+	RedactFields []string // removed
+}
+
+// Change is a field hashed or removed from an event
+type Change struct {
+	Action string
+	Field  string
+}
+
+// Transform applies a Config to events
+type Transform struct {
+	key    []byte
+	hash   [][]string // split on dots
+	redact [][]string
+	userID bool
+}
+
+// New creates a transform; Config is assumed valid
+func New(cfg Config) *Transform {
+	t := &Transform{key: cfg.HashKey}
+	for _, field := range cfg.HashFields {
+		if field == UserIDField {
+			t.userID = true
+			continue
+		}
+		t.hash = append(t.hash, strings.Split(field, "."))
+	}
+	for _, field := range cfg.RedactFields {
+		t.redact = append(t.redact, strings.Split(field, "."))
+	}
+	return t
+}
+
+// Apply hashes and removes fields in place, returning what it changed
+func (t *Transform) Apply(event *consumer.Event) []Change {
+	var changes []Change
+	if t.userID && event.UserID != "" {
+		event.UserID = t.Hash(event.UserID)
+		changes = append(changes, Change{Action: ActionHashed, Field: UserIDField})
+	}
+	for _, path := range t.hash {
+		parent, key := lookup(event.Data, path)
+		value, ok := parent[key]
+		if !ok || value == nil {
+			continue
+		}
+		parent[key] = t.Hash(text(value))
+		changes = append(changes, Change{Action: ActionHashed, Field: strings.Join(path, ".")})
+	}
+	for _, path := range t.redact {
+		parent, key := lookup(event.Data, path)
+		if _, ok := parent[key]; !ok {
+			continue
+		}
+		delete(parent, key)
+		changes = append(changes, Change{Action: ActionRedacted, Field: strings.Join(path, ".")})
+	}
+	return changes
+}
+
+// Hash returns the hex HMAC-SHA256 of value, the same for the same value, so hashed identifiers
+// can still be counted and joined
+func (t *Transform) Hash(value string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lookup returns the map holding the last key of path, or nil if an earlier key isn't an object
+func lookup(data map[string]interface{}, path []string) (map[string]interface{}, string) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := data[key].(map[string]interface{})
+		if !ok {
+			return nil, ""
+		}
+		data = next
+	}
+	return data, path[len(path)-1]
+}
+
+// text is what is hashed for a value: strings as they are, anything else as JSON
+func text(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
@@ -0,0 +1,194 @@
+package query
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// exportChunk is how many events an export reads at a time; the next chunk is only read once the
+// client has taken the last, so a slow client slows the export rather than filling memory
+const exportChunk = 1000
+
+// Export formats
+const (
+	formatCSV    = "csv"
+	formatNDJSON = "ndjson"
+)
+
+// exportColumns are the columns of a CSV export, in order; data is the event's data as JSON
+var exportColumns = []string{"id", "timestamp", "tenant_id", "event_type", "user_id", "service", "topic", "partition", "offset", "stored_at", "data"}
+
+// Export serves GET /api/v1/analytics/export
+type Export struct {
+	sink   storage.EventSink
+	hash   func(string) string
+	logger *logger.Logger
+}
+
+// NewExport creates the export API reading from sink; hash is how user IDs are hashed before they
+// are stored, nil if they aren't
+func NewExport(sink storage.EventSink, hash func(string) string, log *logger.Logger) *Export {
+	return &Export{sink: sink, hash: hash, logger: log}
+}
+
+// ServeHTTP serves GET /api/v1/analytics/export, streaming every event from ?from= to ?to=, oldest
+// first, as ?format=csv (the default) or ndjson, for the ?tenant= given, or for events without a
+// tenant; events are filtered by ?event_type=, ?user_id= and ?service= as by the events API
+// Events are read in chunks, each after the previous one's, so the export sees a consistent order
+// without holding a transaction; an export that fails midway is cut off rather than ended cleanly
+func (e *Export) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	params := req.URL.Query()
+	q := storage.Query{
+		TenantID:  params.Get("tenant"),
+		EventType: params.Get("event_type"),
+		UserID:    params.Get("user_id"),
+		Service:   params.Get("service"),
+		Limit:     exportChunk,
+		Ascending: true,
+	}
+	badRequest := func(message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+	if q.UserID != "" && e.hash != nil {
+		q.UserID = e.hash(q.UserID)
+	}
+	format := params.Get("format")
+	switch format {
+	case "":
+		format = formatCSV
+	case formatCSV, formatNDJSON:
+	default:
+		badRequest("format must be " + formatCSV + " or " + formatNDJSON)
+		return
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		parsed, err := time.Parse(time.RFC3339Nano, params.Get(bound.name))
+		if err != nil {
+			badRequest(bound.name + " is required, as an RFC 3339 time such as 2026-01-02T15:04:05Z")
+			return
+		}
+		*bound.t = parsed.UTC()
+	}
+	if !q.From.Before(q.To) {
+		badRequest("from must be before to")
+		return
+	}
+
+	// The first chunk is read before anything is written, so a query that can't run is still an error
+	stored, err := e.sink.Query(req.Context(), q)
+	if err != nil {
+		e.logger.Error("Failed to export events: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to export events"}`))
+		return
+	}
+	name := "events-" + q.From.Format("20060102T150405Z") + "-" + q.To.Format("20060102T150405Z") + "." + format
+	if format == formatCSV {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	flusher, _ := w.(http.Flusher)
+
+	csvWriter := csv.NewWriter(w)
+	encoder := json.NewEncoder(w)
+	if format == formatCSV {
+		csvWriter.Write(exportColumns)
+	}
+	exported := 0
+	for {
+		for _, s := range stored {
+			ev := event{
+				ID:        s.ID,
+				EventType: s.EventType,
+				UserID:    s.UserID,
+				Service:   s.Service,
+				TenantID:  s.TenantID,
+				Timestamp: s.Timestamp,
+				Data:      s.Data,
+				Topic:     s.Topic,
+				Partition: s.Partition,
+				Offset:    s.Offset,
+				StoredAt:  s.StoredAt,
+			}
+			if format == formatNDJSON {
+				err = encoder.Encode(ev)
+			} else {
+				err = csvWriter.Write(csvRecord(ev))
+			}
+			if err != nil {
+				// The client went away
+				return
+			}
+		}
+		exported += len(stored)
+		csvWriter.Flush()
+		if csvWriter.Error() != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(stored) < exportChunk {
+			e.logger.Debug("Exported %d events from %s to %s", exported, q.From.Format(time.RFC3339), q.To.Format(time.RFC3339))
+			return
+		}
+
+		q.After = &stored[len(stored)-1]
+		stored, err = e.sink.Query(req.Context(), q)
+		if err != nil {
+			if req.Context().Err() == nil {
+				e.logger.Error("Export failed after %d events: %v", exported, err)
+			}
+			// Ends the response without its terminating chunk, so the client sees it is incomplete
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// csvRecord is an event as a row of exportColumns
+func csvRecord(ev event) []string {
+	data := ""
+	if ev.Data != nil {
+		raw, _ := json.Marshal(ev.Data)
+		data = string(raw)
+	}
+	id := ""
+	if ev.ID != 0 {
+		id = strconv.FormatInt(ev.ID, 10)
+	}
+	return []string{
+		id,
+		ev.Timestamp.UTC().Format(time.RFC3339Nano),
+		ev.TenantID,
+		ev.EventType,
+		ev.UserID,
+		ev.Service,
+		ev.Topic,
+		strconv.FormatInt(int64(ev.Partition), 10),
+		strconv.FormatInt(ev.Offset, 10),
+		ev.StoredAt.UTC().Format(time.RFC3339Nano),
+		data,
+	}
+}
@@ -0,0 +1,208 @@
+package query
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	analyticsv1 "nexus-analytics-service/api/analytics/v1"
+	"nexus-analytics-service/internal/grpcserver"
+	"nexus-analytics-service/internal/storage"
+)
+
+// GRPC serves the AnalyticsQuery gRPC service, reading the same storage as the REST API
+type GRPC struct {
+	analyticsv1.UnimplementedAnalyticsQueryServer
+
+	sink   storage.EventSink
+	series storage.TimeSeries // nil if the backend can't count per bucket
+	hash   func(string) string
+}
+
+// NewGRPC creates the gRPC service reading from sink; hash is how user IDs are hashed before they
+// are stored, nil if they aren't; failures are logged by the server
+func NewGRPC(sink storage.EventSink, hash func(string) string) *GRPC {
+	g := &GRPC{sink: sink, hash: hash}
+	g.series, _ = sink.(storage.TimeSeries)
+	return g
+}
+
+// Register serves the service's methods on s
+func (g *GRPC) Register(s grpc.ServiceRegistrar) {
+	analyticsv1.RegisterAnalyticsQueryServer(s, g)
+}
+
+// QueryEvents returns a page of events, as the events API does
+func (g *GRPC) QueryEvents(ctx context.Context, req *analyticsv1.QueryEventsRequest) (*analyticsv1.QueryEventsResponse, error) {
+	q, err := g.filter(ctx, req.GetFilter(), false)
+	if err != nil {
+		return nil, err
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return nil, status.Error(codes.InvalidArgument, "from must be before to")
+	}
+	q.Ascending = req.GetOldestFirst()
+	q.Limit = defaultLimit
+	if limit := req.GetLimit(); limit != 0 {
+		if limit < 1 || limit > maxLimit {
+			return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxLimit)
+		}
+		q.Limit = int(limit)
+	}
+	if value := req.GetCursor(); value != "" {
+		after, err := decodeCursor(value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+		}
+		q.After = after
+	}
+
+	// One more than the page tells whether there is a next one
+	limit := q.Limit
+	q.Limit++
+	stored, err := g.sink.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	page := &analyticsv1.QueryEventsResponse{Events: make([]*analyticsv1.Event, 0, min(len(stored), limit))}
+	if len(stored) > limit {
+		stored = stored[:limit]
+		page.NextCursor = encodeCursor(stored[limit-1])
+	}
+	for _, e := range stored {
+		ev := &analyticsv1.Event{
+			Id:        e.ID,
+			EventType: e.EventType,
+			UserId:    e.UserID,
+			Service:   e.Service,
+			TenantId:  e.TenantID,
+			Timestamp: timestamppb.New(e.Timestamp),
+			Topic:     e.Topic,
+			Partition: e.Partition,
+			Offset:    e.Offset,
+		}
+		if !e.StoredAt.IsZero() {
+			ev.StoredAt = timestamppb.New(e.StoredAt)
+		}
+		if e.Data != nil {
+			if ev.Data, err = structpb.NewStruct(e.Data); err != nil {
+				return nil, err
+			}
+		}
+		page.Events = append(page.Events, ev)
+	}
+	return page, nil
+}
+
+// GetCounts counts the events of a time range, in total or per group, from the same daily counts
+// as the time series API
+func (g *GRPC) GetCounts(ctx context.Context, req *analyticsv1.GetCountsRequest) (*analyticsv1.GetCountsResponse, error) {
+	if g.series == nil {
+		return nil, status.Error(codes.Unimplemented, "the storage backend can't count events")
+	}
+	base, err := g.filter(ctx, req.GetFilter(), true)
+	if err != nil {
+		return nil, err
+	}
+	q := storage.SeriesQuery{Query: base, Interval: 24 * time.Hour, GroupBy: req.GetGroupBy()}
+	if err := checkSeries(&q); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	counted, err := g.series.CountSeries(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]*analyticsv1.Count)
+	for _, bucket := range counted {
+		key := groupKey(q.GroupBy, bucket.Group)
+		c, ok := totals[key]
+		if !ok {
+			c = &analyticsv1.Count{Group: bucket.Group}
+			totals[key] = c
+		}
+		c.Count += bucket.Count
+	}
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	response := &analyticsv1.GetCountsResponse{Counts: make([]*analyticsv1.Count, 0, len(keys))}
+	for _, key := range keys {
+		response.Counts = append(response.Counts, totals[key])
+	}
+	return response, nil
+}
+
+// GetTimeseries counts events per bucket, as the time series API does
+func (g *GRPC) GetTimeseries(ctx context.Context, req *analyticsv1.GetTimeseriesRequest) (*analyticsv1.GetTimeseriesResponse, error) {
+	if g.series == nil {
+		return nil, status.Error(codes.Unimplemented, "the storage backend can't count events per bucket")
+	}
+	base, err := g.filter(ctx, req.GetFilter(), true)
+	if err != nil {
+		return nil, err
+	}
+	q := storage.SeriesQuery{Query: base, Interval: req.GetInterval().AsDuration(), GroupBy: req.GetGroupBy()}
+	if err := checkSeries(&q); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	counted, err := g.series.CountSeries(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	filled := fillSeries(q, counted)
+	response := &analyticsv1.GetTimeseriesResponse{Series: make([]*analyticsv1.Series, 0, len(filled))}
+	for _, s := range filled {
+		out := &analyticsv1.Series{Group: s.Group, Points: make([]*analyticsv1.Point, 0, len(s.Points))}
+		for _, p := range s.Points {
+			out.Points = append(out.Points, &analyticsv1.Point{Start: timestamppb.New(p.Start), Count: p.Count})
+		}
+		response.Series = append(response.Series, out)
+	}
+	return response, nil
+}
+
+// filter is the query selecting the events of f; bounded requires both from and to
+// Calls with a tenant's key only select that tenant's events, and may not name another tenant
+func (g *GRPC) filter(ctx context.Context, f *analyticsv1.EventFilter, bounded bool) (storage.Query, error) {
+	q := storage.Query{
+		TenantID:  f.GetTenantId(),
+		EventType: f.GetEventType(),
+		UserID:    f.GetUserId(),
+		Service:   f.GetService(),
+	}
+	if tenantID, ok := grpcserver.Tenant(ctx); ok {
+		if q.TenantID != "" && q.TenantID != tenantID {
+			return q, status.Error(codes.PermissionDenied, "the API key is for another tenant")
+		}
+		q.TenantID = tenantID
+	}
+	// Events are stored under the hashed ID, so that is what is looked for
+	if q.UserID != "" && g.hash != nil {
+		q.UserID = g.hash(q.UserID)
+	}
+	for _, bound := range []struct {
+		name string
+		ts   *timestamppb.Timestamp
+		t    *time.Time
+	}{{"from", f.GetFrom(), &q.From}, {"to", f.GetTo(), &q.To}} {
+		if bound.ts == nil {
+			if bounded {
+				return q, status.Errorf(codes.InvalidArgument, "%s is required", bound.name)
+			}
+			continue
+		}
+		if err := bound.ts.CheckValid(); err != nil {
+			return q, status.Errorf(codes.InvalidArgument, "invalid %s: %v", bound.name, err)
+		}
+		*bound.t = bound.ts.AsTime()
+	}
+	return q, nil
+}
@@ -0,0 +1,213 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	analyticsv1 "nexus-analytics-service/api/analytics/v1"
+	"nexus-analytics-service/internal/grpcserver"
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+const (
+	testAdminKey  = "admin-key"
+	testTenantKey = "tenant-key"
+)
+
+// fakeSink returns the events of the queried tenant, and counts them one per bucket
+type fakeSink struct {
+	storage.EventSink
+	events  []storage.StoredEvent
+	queries []storage.Query
+	fail    error
+}
+
+func (s *fakeSink) Query(ctx context.Context, q storage.Query) ([]storage.StoredEvent, error) {
+	s.queries = append(s.queries, q)
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	var matched []storage.StoredEvent
+	for _, e := range s.events {
+		if e.TenantID == q.TenantID && len(matched) < q.Limit {
+			matched = append(matched, e)
+		}
+	}
+	return matched, nil
+}
+
+func (s *fakeSink) CountSeries(ctx context.Context, q storage.SeriesQuery) ([]storage.Bucket, error) {
+	var buckets []storage.Bucket
+	for _, e := range s.events {
+		if e.TenantID == q.TenantID {
+			buckets = append(buckets, storage.Bucket{Start: e.Timestamp.Truncate(q.Interval), Count: 1})
+		}
+	}
+	return buckets, nil
+}
+
+// dial serves the query service for sink in memory and returns a client of it
+func dial(t *testing.T, sink *fakeSink) analyticsv1.AnalyticsQueryClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpcserver.New(testAdminKey, map[string]string{testTenantKey: "acme"}, logger.New(false))
+	NewGRPC(sink, nil).Register(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return analyticsv1.NewAnalyticsQueryClient(conn)
+}
+
+func withKey(name, key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), name, key)
+}
+
+func testEvents() []storage.StoredEvent {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []storage.StoredEvent{
+		{ID: 3, Event: storage.Event{EventType: "page_view", UserID: "u1", Service: "web", TenantID: "acme", Timestamp: at.Add(2 * time.Hour), Data: map[string]interface{}{"path": "/"}}},
+		{ID: 2, Event: storage.Event{EventType: "click", UserID: "u2", Service: "web", TenantID: "acme", Timestamp: at.Add(time.Hour)}},
+		{ID: 1, Event: storage.Event{EventType: "page_view", UserID: "u3", Service: "web", TenantID: "other", Timestamp: at}},
+	}
+}
+
+func TestGRPCQueryEvents(t *testing.T) {
+	client := dial(t, &fakeSink{events: testEvents()})
+
+	page, err := client.QueryEvents(withKey("x-admin-key", testAdminKey), &analyticsv1.QueryEventsRequest{
+		Filter: &analyticsv1.EventFilter{TenantId: "acme"},
+		Limit:  1,
+	})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	if len(page.Events) != 1 {
+		t.Fatalf("got %d events, want 1", len(page.Events))
+	}
+	got := page.Events[0]
+	if got.Id != 3 || got.EventType != "page_view" || got.TenantId != "acme" {
+		t.Errorf("got event %v", got)
+	}
+	if got.Data.AsMap()["path"] != "/" {
+		t.Errorf("got data %v, want path /", got.Data.AsMap())
+	}
+	if page.NextCursor == "" {
+		t.Fatal("got no next cursor with more events to read")
+	}
+
+	next, err := client.QueryEvents(withKey("x-admin-key", testAdminKey), &analyticsv1.QueryEventsRequest{
+		Filter: &analyticsv1.EventFilter{TenantId: "acme"},
+		Limit:  1,
+		Cursor: page.NextCursor,
+	})
+	if err != nil {
+		t.Fatalf("QueryEvents with cursor: %v", err)
+	}
+	if len(next.Events) != 1 {
+		t.Errorf("got %d events on the next page, want 1", len(next.Events))
+	}
+}
+
+func TestGRPCTenantKey(t *testing.T) {
+	sink := &fakeSink{events: testEvents()}
+	client := dial(t, sink)
+	ctx := withKey("x-api-key", testTenantKey)
+
+	page, err := client.QueryEvents(ctx, &analyticsv1.QueryEventsRequest{})
+	if err != nil {
+		t.Fatalf("QueryEvents: %v", err)
+	}
+	for _, e := range page.Events {
+		if e.TenantId != "acme" {
+			t.Errorf("got an event of tenant %q with acme's key", e.TenantId)
+		}
+	}
+	if tenant := sink.queries[0].TenantID; tenant != "acme" {
+		t.Errorf("queried tenant %q, want acme", tenant)
+	}
+
+	_, err = client.QueryEvents(ctx, &analyticsv1.QueryEventsRequest{Filter: &analyticsv1.EventFilter{TenantId: "other"}})
+	if code := status.Code(err); code != codes.PermissionDenied {
+		t.Errorf("got %v naming another tenant, want PermissionDenied", err)
+	}
+}
+
+func TestGRPCTimeseries(t *testing.T) {
+	client := dial(t, &fakeSink{events: testEvents()})
+	from := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	response, err := client.GetTimeseries(withKey("x-admin-key", testAdminKey), &analyticsv1.GetTimeseriesRequest{
+		Filter: &analyticsv1.EventFilter{
+			TenantId: "acme",
+			From:     timestamppb.New(from),
+			To:       timestamppb.New(from.Add(4 * time.Hour)),
+		},
+		Interval: durationpb.New(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("GetTimeseries: %v", err)
+	}
+	if len(response.Series) != 1 {
+		t.Fatalf("got %d series, want 1", len(response.Series))
+	}
+	var counts []int64
+	for _, p := range response.Series[0].Points {
+		counts = append(counts, p.Count)
+	}
+	if want := []int64{0, 1, 1, 0}; !slices.Equal(counts, want) {
+		t.Errorf("got counts %v, want %v", counts, want)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		req  *analyticsv1.QueryEventsRequest
+		fail error
+		code codes.Code
+	}{
+		{"no key", context.Background(), &analyticsv1.QueryEventsRequest{}, nil, codes.Unauthenticated},
+		{"wrong key", withKey("x-admin-key", "guess"), &analyticsv1.QueryEventsRequest{}, nil, codes.Unauthenticated},
+		{"invalid limit", withKey("x-admin-key", testAdminKey), &analyticsv1.QueryEventsRequest{Limit: -1}, nil, codes.InvalidArgument},
+		{"invalid cursor", withKey("x-admin-key", testAdminKey), &analyticsv1.QueryEventsRequest{Cursor: "?"}, nil, codes.InvalidArgument},
+		{"storage failure", withKey("x-admin-key", testAdminKey), &analyticsv1.QueryEventsRequest{}, errors.New("connection refused"), codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dial(t, &fakeSink{events: testEvents(), fail: tt.fail})
+			_, err := client.QueryEvents(tt.ctx, tt.req)
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("got %v, want %v", err, tt.code)
+			}
+			if tt.code == codes.Internal && status.Convert(err).Message() != "internal error" {
+				t.Errorf("got message %q, want the storage error hidden", status.Convert(err).Message())
+			}
+		})
+	}
+}
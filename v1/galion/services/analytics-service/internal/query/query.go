@@ -0,0 +1,196 @@
+// Package query serves stored events over HTTP, filtered, sorted and paginated, so they can be read
+// without SQL against the events table
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// Page sizes of the events API
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Sort orders of the events API
+const (
+	sortNewest = "-timestamp"
+	sortOldest = "timestamp"
+)
+
+// API serves GET /api/v1/analytics/events
+type API struct {
+	sink   storage.EventSink
+	hash   func(string) string
+	logger *logger.Logger
+}
+
+// New creates the events API reading from sink; hash is how user IDs are hashed before they are
+// stored, nil if they aren't
+func New(sink storage.EventSink, hash func(string) string, log *logger.Logger) *API {
+	return &API{sink: sink, hash: hash, logger: log}
+}
+
+// event is a stored event as the API returns it
+type event struct {
+	ID        int64                  `json:"id,omitempty"`
+	EventType string                 `json:"event_type"`
+	UserID    string                 `json:"user_id"`
+	Service   string                 `json:"service"`
+	TenantID  string                 `json:"tenant_id,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Topic     string                 `json:"topic,omitempty"`
+	Partition int32                  `json:"partition,omitempty"`
+	Offset    int64                  `json:"offset,omitempty"`
+	StoredAt  time.Time              `json:"stored_at"`
+}
+
+// response is a page of events; NextCursor fetches the next one, and is left out on the last
+type response struct {
+	Events     []event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// cursor is where a page ended, as the sort key of its last event; it is opaque to clients
+type cursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        int64     `json:"i,omitempty"`
+	Topic     string    `json:"s,omitempty"`
+	Partition int32     `json:"p,omitempty"`
+	Offset    int64     `json:"o,omitempty"`
+}
+
+// ServeHTTP serves GET /api/v1/analytics/events, returning a page of events as JSON for the
+// ?tenant= given, or for events without a tenant; events are never mixed across tenants
+// Events are filtered by ?event_type=, ?user_id= and ?service=, and by time with ?from= (inclusive)
+// and ?to= (exclusive) in RFC 3339; ?sort=timestamp returns the oldest first, and -timestamp (the
+// default) the most recent; ?limit= sets the page size, and ?cursor= the next_cursor of the
+// previous page, with the same filters and sort
+func (a *API) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	params := req.URL.Query()
+	q := storage.Query{
+		TenantID:  params.Get("tenant"),
+		EventType: params.Get("event_type"),
+		UserID:    params.Get("user_id"),
+		Service:   params.Get("service"),
+		Limit:     defaultLimit,
+	}
+	badRequest := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+	// Events are stored under the hashed ID, so that is what is looked for
+	if q.UserID != "" && a.hash != nil {
+		q.UserID = a.hash(q.UserID)
+	}
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := params.Get(bound.name); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				badRequest(bound.name + " must be an RFC 3339 time, as in 2026-01-02T15:04:05Z")
+				return
+			}
+			*bound.t = t.UTC()
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		badRequest("from must be before to")
+		return
+	}
+	switch params.Get("sort") {
+	case sortNewest, "":
+	case sortOldest:
+		q.Ascending = true
+	default:
+		badRequest("sort must be " + sortOldest + " or " + sortNewest)
+		return
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			badRequest("limit must be between 1 and " + strconv.Itoa(maxLimit))
+			return
+		}
+		q.Limit = limit
+	}
+	if value := params.Get("cursor"); value != "" {
+		after, err := decodeCursor(value)
+		if err != nil {
+			badRequest("invalid cursor")
+			return
+		}
+		q.After = after
+	}
+
+	// One more than the page tells whether there is a next one
+	limit := q.Limit
+	q.Limit++
+	stored, err := a.sink.Query(req.Context(), q)
+	if err != nil {
+		a.logger.Error("Failed to query events: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to query events"}`))
+		return
+	}
+	page := response{Events: make([]event, 0, min(len(stored), limit))}
+	if len(stored) > limit {
+		stored = stored[:limit]
+		page.NextCursor = encodeCursor(stored[limit-1])
+	}
+	for _, e := range stored {
+		page.Events = append(page.Events, event{
+			ID:        e.ID,
+			EventType: e.EventType,
+			UserID:    e.UserID,
+			Service:   e.Service,
+			TenantID:  e.TenantID,
+			Timestamp: e.Timestamp,
+			Data:      e.Data,
+			Topic:     e.Topic,
+			Partition: e.Partition,
+			Offset:    e.Offset,
+			StoredAt:  e.StoredAt,
+		})
+	}
+	json.NewEncoder(w).Encode(page)
+}
+
+// encodeCursor is the cursor of the page after e
+func encodeCursor(e storage.StoredEvent) string {
+	raw, _ := json.Marshal(cursor{Timestamp: e.Timestamp, ID: e.ID, Topic: e.Topic, Partition: e.Partition, Offset: e.Offset})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor is the event a cursor continues after
+func decodeCursor(value string) (*storage.StoredEvent, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, err
+	}
+	after := &storage.StoredEvent{ID: c.ID}
+	after.Timestamp, after.Topic, after.Partition, after.Offset = c.Timestamp, c.Topic, c.Partition, c.Offset
+	return after, nil
+}
@@ -0,0 +1,122 @@
+package query
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// Sessions serves GET /api/v1/analytics/sessions
+type Sessions struct {
+	sink   storage.Sessions
+	hash   func(string) string
+	logger *logger.Logger
+}
+
+// NewSessions creates the sessions API counting in sink; hash is how user IDs are hashed before
+// they are stored, nil if they aren't
+func NewSessions(sink storage.Sessions, hash func(string) string, log *logger.Logger) *Sessions {
+	return &Sessions{sink: sink, hash: hash, logger: log}
+}
+
+// sessionBucket is the sessions that started in a bucket, with durations in seconds
+type sessionBucket struct {
+	Start          time.Time `json:"start"`
+	Sessions       int64     `json:"sessions"`
+	Users          int64     `json:"users"`
+	Events         int64     `json:"events"`
+	AvgDuration    float64   `json:"avg_duration_seconds"`
+	MedianDuration float64   `json:"median_duration_seconds"`
+}
+
+// sessionsResponse is the sessions API's answer
+type sessionsResponse struct {
+	Interval string          `json:"interval"`
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	Buckets  []sessionBucket `json:"buckets"`
+}
+
+// ServeHTTP serves GET /api/v1/analytics/sessions, returning how many sessions started in each
+// bucket of ?interval= from ?from= to ?to=, of how many users, and how long they were, as JSON, for
+// the ?tenant= given, or for events without a tenant, and only ?user_id='s if given; buckets are
+// laid out as by the time series API
+func (s *Sessions) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	params := req.URL.Query()
+	q := storage.SessionQuery{TenantID: params.Get("tenant"), UserID: params.Get("user_id")}
+	badRequest := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+	if q.UserID != "" && s.hash != nil {
+		q.UserID = s.hash(q.UserID)
+	}
+
+	interval, err := time.ParseDuration(params.Get("interval"))
+	if err != nil || interval < time.Minute || (24*time.Hour)%interval != 0 {
+		badRequest("interval must be a duration of at least 1m that divides 24h, as in 5m, 1h or 24h")
+		return
+	}
+	q.Interval = interval
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		parsed, err := time.Parse(time.RFC3339Nano, params.Get(bound.name))
+		if err != nil {
+			badRequest(bound.name + " is required, as an RFC 3339 time such as 2026-01-02T15:04:05Z")
+			return
+		}
+		*bound.t = parsed.UTC()
+	}
+	if !q.From.Before(q.To) {
+		badRequest("from must be before to")
+		return
+	}
+	first := q.From.Truncate(interval)
+	buckets := int(q.To.Sub(first) / interval)
+	if q.To.Sub(first)%interval != 0 {
+		buckets++
+	}
+	if buckets > maxBuckets {
+		badRequest("the range holds " + strconv.Itoa(buckets) + " buckets of the interval; at most " + strconv.Itoa(maxBuckets) + " are allowed")
+		return
+	}
+
+	counted, err := s.sink.SessionStats(req.Context(), q)
+	if err != nil {
+		s.logger.Error("Failed to count sessions: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to count sessions"}`))
+		return
+	}
+	response := sessionsResponse{Interval: params.Get("interval"), From: q.From, To: q.To, Buckets: make([]sessionBucket, buckets)}
+	for i := range response.Buckets {
+		response.Buckets[i].Start = first.Add(time.Duration(i) * interval)
+	}
+	for _, bucket := range counted {
+		if i := int(bucket.Start.Sub(first) / interval); i >= 0 && i < buckets {
+			response.Buckets[i] = sessionBucket{
+				Start:          response.Buckets[i].Start,
+				Sessions:       bucket.Sessions,
+				Users:          bucket.Users,
+				Events:         bucket.Events,
+				AvgDuration:    bucket.AvgDuration.Seconds(),
+				MedianDuration: bucket.MedianDuration.Seconds(),
+			}
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// maxBuckets bounds the buckets of a time series, so a small interval over a long range can't ask
+// for a chart nobody can read
+const maxBuckets = 1000
+
+// TimeSeries serves GET /api/v1/analytics/timeseries
+type TimeSeries struct {
+	sink   storage.TimeSeries
+	hash   func(string) string
+	logger *logger.Logger
+}
+
+// NewTimeSeries creates the time series API counting in sink; hash is how user IDs are hashed
+// before they are stored, nil if they aren't
+func NewTimeSeries(sink storage.TimeSeries, hash func(string) string, log *logger.Logger) *TimeSeries {
+	return &TimeSeries{sink: sink, hash: hash, logger: log}
+}
+
+// point is the count of a bucket
+type point struct {
+	Start time.Time `json:"start"`
+	Count int64     `json:"count"`
+}
+
+// series is the points of a group, or of every event if not grouped
+type series struct {
+	Group  map[string]string `json:"group,omitempty"`
+	Points []point           `json:"points"`
+}
+
+// seriesResponse is the time series API's answer
+type seriesResponse struct {
+	Interval string    `json:"interval"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Series   []series  `json:"series"`
+}
+
+// ServeHTTP serves GET /api/v1/analytics/timeseries, returning the count of events per bucket of
+// ?interval= from ?from= to ?to= as JSON, for the ?tenant= given, or for events without a tenant
+// Events are filtered as by the events API, and counted per ?group_by= service, event_type or
+// both (comma-separated) too; buckets start at multiples of the interval since midnight UTC, and
+// every bucket of the range is listed, with 0 if it has no events
+func (t *TimeSeries) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	params := req.URL.Query()
+	q := storage.SeriesQuery{Query: storage.Query{
+		TenantID:  params.Get("tenant"),
+		EventType: params.Get("event_type"),
+		UserID:    params.Get("user_id"),
+		Service:   params.Get("service"),
+	}}
+	badRequest := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+	if q.UserID != "" && t.hash != nil {
+		q.UserID = t.hash(q.UserID)
+	}
+
+	interval, err := time.ParseDuration(params.Get("interval"))
+	if err != nil {
+		badRequest(errInterval.Error())
+		return
+	}
+	q.Interval = interval
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		parsed, err := time.Parse(time.RFC3339Nano, params.Get(bound.name))
+		if err != nil {
+			badRequest(bound.name + " is required, as an RFC 3339 time such as 2026-01-02T15:04:05Z")
+			return
+		}
+		*bound.t = parsed.UTC()
+	}
+	if value := params.Get("group_by"); value != "" {
+		q.GroupBy = strings.Split(value, ",")
+	}
+	if err := checkSeries(&q); err != nil {
+		badRequest(err.Error())
+		return
+	}
+
+	counted, err := t.sink.CountSeries(req.Context(), q)
+	if err != nil {
+		t.logger.Error("Failed to count events per bucket: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to count events"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(seriesResponse{Interval: params.Get("interval"), From: q.From, To: q.To, Series: fillSeries(q, counted)})
+}
+
+// errInterval is why an interval can't be counted by
+var errInterval = errors.New("interval must be a duration of at least 1m that divides 24h, as in 5m, 1h or 24h")
+
+// checkSeries checks a time series query, and trims and deduplicates its groups
+func checkSeries(q *storage.SeriesQuery) error {
+	if q.Interval < time.Minute || (24*time.Hour)%q.Interval != 0 {
+		return errInterval
+	}
+	if !q.From.Before(q.To) {
+		return errors.New("from must be before to")
+	}
+	if buckets := bucketCount(*q); buckets > maxBuckets {
+		return fmt.Errorf("the range holds %d buckets of the interval; at most %d are allowed", buckets, maxBuckets)
+	}
+	groups, err := checkGroups(q.GroupBy)
+	q.GroupBy = groups
+	return err
+}
+
+// checkGroups checks the columns events are counted by, trimmed and each once
+func checkGroups(columns []string) ([]string, error) {
+	var groups []string
+	for _, column := range columns {
+		column = strings.TrimSpace(column)
+		if !slices.Contains(storage.SeriesGroups, column) {
+			return nil, errors.New("group_by must be from " + strings.Join(storage.SeriesGroups, ", "))
+		}
+		if !slices.Contains(groups, column) {
+			groups = append(groups, column)
+		}
+	}
+	return groups, nil
+}
+
+// bucketCount is how many buckets of its interval the range of q touches
+func bucketCount(q storage.SeriesQuery) int {
+	first := q.From.Truncate(q.Interval)
+	buckets := int(q.To.Sub(first) / q.Interval)
+	if q.To.Sub(first)%q.Interval != 0 {
+		buckets++
+	}
+	return buckets
+}
+
+// fillSeries lays out the buckets counted as a series per group, sorted by group, each with every
+// bucket of the range, counted or not
+func fillSeries(q storage.SeriesQuery, counted []storage.Bucket) []series {
+	first := q.From.Truncate(q.Interval)
+	buckets := bucketCount(q)
+	groups := make(map[string]*series)
+	if len(q.GroupBy) == 0 {
+		groups[""] = &series{}
+	}
+	for _, bucket := range counted {
+		key := groupKey(q.GroupBy, bucket.Group)
+		s, ok := groups[key]
+		if !ok {
+			s = &series{Group: bucket.Group}
+			groups[key] = s
+		}
+		s.Points = append(s.Points, point{Start: bucket.Start, Count: bucket.Count})
+	}
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	filled := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := groups[key]
+		points := make([]point, buckets)
+		for i := range points {
+			points[i].Start = first.Add(time.Duration(i) * q.Interval)
+		}
+		for _, p := range s.Points {
+			if i := int(p.Start.Sub(first) / q.Interval); i >= 0 && i < buckets {
+				points[i].Count += p.Count
+			}
+		}
+		s.Points = points
+		filled = append(filled, *s)
+	}
+	return filled
+}
+
+// groupKey identifies a group by the values of its columns
+func groupKey(columns []string, group map[string]string) string {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = group[column]
+	}
+	return strings.Join(values, "\x00")
+}
@@ -0,0 +1,120 @@
+package query
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+
+	"nexus-common/logger"
+)
+
+// Windows of the top API; longer than maxHourlyWindow, counts are read from the daily rollup
+const (
+	defaultWindow   = 24 * time.Hour
+	maxHourlyWindow = 7 * 24 * time.Hour
+	maxWindow       = 90 * 24 * time.Hour
+)
+
+// Sizes of the top API's ranking
+const (
+	defaultTop = 20
+	maxTop     = 100
+)
+
+// Top serves GET /api/v1/analytics/top
+type Top struct {
+	rollups storage.Rollups
+	logger  *logger.Logger
+}
+
+// NewTop creates the top API ranking from the rollups
+func NewTop(rollups storage.Rollups, log *logger.Logger) *Top {
+	return &Top{rollups: rollups, logger: log}
+}
+
+// ranked is a value and its events as the top API returns them
+type ranked struct {
+	Value  string `json:"value"`
+	Events int64  `json:"events"`
+}
+
+// topResponse is the top API's answer
+type topResponse struct {
+	Dimension string    `json:"dimension"`
+	Window    string    `json:"window"`
+	From      time.Time `json:"from"`
+	Top       []ranked  `json:"top"`
+}
+
+// ServeHTTP serves GET /api/v1/analytics/top, returning the ?limit= values of ?dimension=
+// event_type, user_id or service with the most events in the last ?window= as JSON, for the
+// ?tenant= given, or for events without a tenant
+// Counts come from the rollups, so only hours rolled up are counted: a window of up to 168h is
+// that many ended hours, and a longer one, in whole days, that many days up to today so far
+func (t *Top) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	params := req.URL.Query()
+	badRequest := func(message string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+
+	dimension := params.Get("dimension")
+	if !slices.Contains(storage.TopDimensions, dimension) {
+		badRequest("dimension must be " + strings.Join(storage.TopDimensions, ", "))
+		return
+	}
+	window := defaultWindow
+	if value := params.Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < time.Hour || parsed > maxWindow || parsed%time.Hour != 0 {
+			badRequest("window must be whole hours between 1h and " + strconv.Itoa(int(maxWindow/time.Hour)) + "h, as in 24h")
+			return
+		}
+		if parsed > maxHourlyWindow && parsed%(24*time.Hour) != 0 {
+			badRequest("a window longer than " + strconv.Itoa(int(maxHourlyWindow/time.Hour)) + "h must be whole days, as in 720h")
+			return
+		}
+		window = parsed
+	}
+	limit := defaultTop
+	if value := params.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxTop {
+			badRequest("limit must be between 1 and " + strconv.Itoa(maxTop))
+			return
+		}
+		limit = parsed
+	}
+
+	// The current hour isn't rolled up until it ends, but today's day is, as its hours end
+	now := time.Now().UTC()
+	daily := window > maxHourlyWindow
+	from := now.Truncate(time.Hour).Add(-window)
+	if daily {
+		from = now.Truncate(24 * time.Hour).Add(24 * time.Hour).Add(-window)
+	}
+	top, err := t.rollups.Top(req.Context(), params.Get("tenant"), dimension, from, daily, limit)
+	if err != nil {
+		t.logger.Error("Failed to rank %s: %v", dimension, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to rank events"}`))
+		return
+	}
+	response := topResponse{Dimension: dimension, Window: strconv.Itoa(int(window/time.Hour)) + "h", From: from, Top: make([]ranked, 0, len(top))}
+	for _, r := range top {
+		response.Top = append(response.Top, ranked{Value: r.Value, Events: r.Events})
+	}
+	json.NewEncoder(w).Encode(response)
+}
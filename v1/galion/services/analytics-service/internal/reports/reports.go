@@ -0,0 +1,117 @@
+// Package reports keeps the reports dashboards ask for most fresh, and serves them, so dashboards
+// read a small precomputed result instead of querying every event
+package reports
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// checkInterval is how often the reports are checked for staleness; each is refreshed once it is
+// older than the refresh interval, by whichever instance checks first
+const checkInterval = time.Minute
+
+// Row limits for the reports API
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// Refresher refreshes the reports' materialized views on a schedule and serves them
+type Refresher struct {
+	sink     storage.Reports
+	interval time.Duration
+	logger   *logger.Logger
+}
+
+// New creates a refresher keeping every report at most interval old
+func New(sink storage.Reports, interval time.Duration, log *logger.Logger) *Refresher {
+	return &Refresher{sink: sink, interval: interval, logger: log}
+}
+
+// Run refreshes stale reports until ctx is done, starting at once
+func (r *Refresher) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(checkInterval, r.interval))
+	defer ticker.Stop()
+	for {
+		r.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh refreshes the reports older than the refresh interval and updates their staleness
+func (r *Refresher) Refresh(ctx context.Context) {
+	refreshed, err := r.sink.RefreshReports(ctx, r.interval)
+	if err != nil && ctx.Err() == nil {
+		r.logger.Error("Report refresh failed: %v", err)
+	}
+	now := time.Now()
+	for name, at := range refreshed {
+		if !at.IsZero() {
+			metrics.UpdateReportStaleness(name, now.Sub(at))
+		}
+	}
+}
+
+// response is a report as served by the reports API
+type response struct {
+	Report      string                   `json:"report"`
+	RefreshedAt time.Time                `json:"refreshed_at"`
+	Rows        []map[string]interface{} `json:"rows"`
+}
+
+// ServeHTTP serves GET /api/v1/reports/{name}, returning up to ?limit= rows of the report as JSON
+// for the ?tenant= given, or for events without a tenant; rows are never mixed across tenants
+func (r *Refresher) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	name := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	if !slices.Contains(storage.ReportNames(), name) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "unknown report", "reports": storage.ReportNames()})
+		return
+	}
+	limit := defaultLimit
+	if value := req.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"limit must be between 1 and ` + strconv.Itoa(maxLimit) + `"}`))
+			return
+		}
+		limit = parsed
+	}
+
+	report, err := r.sink.Report(req.Context(), req.URL.Query().Get("tenant"), name, limit)
+	switch {
+	case err != nil:
+		r.logger.Error("Failed to read report %s: %v", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"failed to read the report"}`))
+	case report.RefreshedAt.IsZero():
+		w.Header().Set("Retry-After", strconv.Itoa(int(checkInterval.Seconds())))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"the report hasn't been refreshed yet"}`))
+	default:
+		json.NewEncoder(w).Encode(response{Report: report.Name, RefreshedAt: report.RefreshedAt, Rows: report.Rows})
+	}
+}
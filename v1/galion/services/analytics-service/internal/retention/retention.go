@@ -0,0 +1,219 @@
+// Package retention purges events older than their type's retention period
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Policy is how long events are kept; a period of 0 keeps them
+type Policy struct {
+	Default time.Duration            // types not in ByType
+	ByType  map[string]time.Duration // periods of their own, longer or shorter than Default
+}
+
+// ParseByType parses periods given per type as type=duration, such as page_view=720h
+func ParseByType(entries []string) (map[string]time.Duration, error) {
+	byType := make(map[string]time.Duration, len(entries))
+	for _, entry := range entries {
+		eventType, period, ok := strings.Cut(entry, "=")
+		if !ok || eventType == "" {
+			return nil, fmt.Errorf("%q is not type=duration", entry)
+		}
+		if _, ok := byType[eventType]; ok {
+			return nil, fmt.Errorf("%s is given twice", eventType)
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("%q: %s is not a duration such as 720h, or 0 to keep them", entry, period)
+		}
+		byType[eventType] = d
+	}
+	return byType, nil
+}
+
+// Enabled reports whether the policy purges anything
+func (p Policy) Enabled() bool {
+	if p.Default > 0 {
+		return true
+	}
+	for _, d := range p.ByType {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Cutoffs is the time before which events are purged at now, per type
+func (p Policy) Cutoffs(now time.Time) storage.Retention {
+	before := func(d time.Duration) time.Time {
+		if d == 0 {
+			return time.Time{}
+		}
+		return now.Add(-d)
+	}
+	r := storage.Retention{Default: before(p.Default), ByType: make(map[string]time.Time, len(p.ByType))}
+	for eventType, d := range p.ByType {
+		r.ByType[eventType] = before(d)
+	}
+	return r
+}
+
+// notAfter caps every time in r at t, so that nothing from t on is purged
+func notAfter(r storage.Retention, t time.Time) storage.Retention {
+	earlier := func(cutoff time.Time) time.Time {
+		if cutoff.After(t) {
+			return t
+		}
+		return cutoff
+	}
+	capped := storage.Retention{Default: earlier(r.Default), ByType: make(map[string]time.Time, len(r.ByType))}
+	for eventType, cutoff := range r.ByType {
+		capped.ByType[eventType] = earlier(cutoff)
+	}
+	return capped
+}
+
+// ErrRunning is returned by Purge while this instance is already purging
+var ErrRunning = errors.New("a purge is already running")
+
+// Result is what a purge did, or with DryRun would have done
+type Result struct {
+	DryRun   bool             `json:"dry_run"`
+	Purged   map[string]int64 `json:"purged"` // events by type, or storage.PurgeRuleDefault
+	Duration string           `json:"duration"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// Purger deletes expired events from a sink, on a schedule or when asked
+type Purger struct {
+	sink    storage.EventSink
+	policy  Policy
+	dryRun  bool
+	logger  *logger.Logger
+	running sync.Mutex
+
+	// archived returns the time before which events are archived, if they must be before purging
+	archived func(context.Context) (time.Time, error)
+}
+
+// New creates a purger; with dryRun, scheduled purges only count what they would delete
+func New(sink storage.EventSink, policy Policy, dryRun bool, log *logger.Logger) *Purger {
+	return &Purger{sink: sink, policy: policy, dryRun: dryRun, logger: log}
+}
+
+// KeepUnarchived makes purges keep events from the time archived returns on, until they are archived
+func (p *Purger) KeepUnarchived(archived func(context.Context) (time.Time, error)) {
+	p.archived = archived
+}
+
+// Run purges every interval until ctx is done, starting at once
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := p.Purge(ctx, p.dryRun)
+		if errors.Is(err, ErrRunning) || errors.Is(err, storage.ErrPurgeRunning) {
+			p.logger.Info("Skipped scheduled purge: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes the events the policy has expired, or with dryRun counts them, logging and
+// recording what it did
+func (p *Purger) Purge(ctx context.Context, dryRun bool) (Result, error) {
+	if !p.running.TryLock() {
+		return Result{DryRun: dryRun}, ErrRunning
+	}
+	defer p.running.Unlock()
+
+	started := time.Now()
+	cutoffs := p.policy.Cutoffs(started.UTC())
+	var purged map[string]int64
+	var err error
+	if p.archived != nil {
+		var through time.Time
+		if through, err = p.archived(ctx); err == nil {
+			// No events at all archive through the zero time, which keeps them
+			cutoffs = notAfter(cutoffs, through)
+		} else {
+			err = fmt.Errorf("failed to find how far events are archived: %w", err)
+		}
+	}
+	if err == nil {
+		purged, err = p.sink.Purge(ctx, cutoffs, dryRun)
+	}
+	result := Result{DryRun: dryRun, Purged: purged, Duration: time.Since(started).Round(time.Millisecond).String()}
+	if errors.Is(err, storage.ErrPurgeRunning) {
+		return result, err
+	}
+
+	for rule, count := range purged {
+		metrics.RecordEventsPurged(rule, dryRun, count)
+		switch {
+		case count == 0:
+		case dryRun:
+			p.logger.Info("Purge dry run: %d %s events are past their retention", count, rule)
+		default:
+			p.logger.Info("Purged %d %s events", count, rule)
+		}
+	}
+	metrics.RecordPurge(time.Since(started), err)
+	if err != nil {
+		result.Error = err.Error()
+		p.logger.Error("Purge failed after %s: %v", result.Duration, err)
+		return result, err
+	}
+	return result, nil
+}
+
+// ServeHTTP runs a purge when POSTed to, returning its Result as JSON; ?dry_run=true or false
+// overrides the configured mode
+func (p *Purger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error":"method not allowed"}`))
+		return
+	}
+	dryRun := p.dryRun
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"dry_run must be true or false"}`))
+			return
+		}
+		dryRun = parsed
+	}
+
+	// The purge outlives a client that disconnects, so it isn't left half done
+	result, err := p.Purge(context.WithoutCancel(r.Context()), dryRun)
+	switch {
+	case errors.Is(err, ErrRunning), errors.Is(err, storage.ErrPurgeRunning):
+		result.Error = err.Error()
+		w.WriteHeader(http.StatusConflict)
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(result)
+}
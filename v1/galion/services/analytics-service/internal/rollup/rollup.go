@@ -0,0 +1,100 @@
+// Package rollup keeps hourly and daily counts of events up to date, so dashboards read small
+// aggregates instead of scanning every event
+package rollup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// step is the most hours rolled up in one transaction, so catching up after downtime, or on the
+// first run over a table of events, proceeds a day at a time
+const step = 24 * time.Hour
+
+// ErrRunning is returned by RollUp while this instance is already rolling up
+var ErrRunning = errors.New("a rollup is already running")
+
+// Scheduler rolls up each hour once it has ended, and again while it is within the lookback, for
+// events that arrive late; hours missed while the service was down are rolled up on its next run
+type Scheduler struct {
+	sink     storage.Rollups
+	lookback time.Duration
+	logger   *logger.Logger
+
+	running sync.Mutex
+}
+
+// New creates a scheduler recomputing the hours that ended within lookback on every run
+func New(sink storage.Rollups, lookback time.Duration, log *logger.Logger) *Scheduler {
+	return &Scheduler{sink: sink, lookback: lookback, logger: log}
+}
+
+// Run rolls up every interval until ctx is done, starting at once
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.RollUp(ctx)
+		switch {
+		case errors.Is(err, storage.ErrRollupRunning):
+			s.logger.Debug("Skipped rollup: %v", err)
+		case err != nil && ctx.Err() == nil:
+			s.logger.Error("Rollup failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RollUp rolls up the hours ended since the last run, and those within the lookback
+func (s *Scheduler) RollUp(ctx context.Context) error {
+	if !s.running.TryLock() {
+		return ErrRunning
+	}
+	defer s.running.Unlock()
+
+	through, err := s.sink.RolledUpThrough(ctx)
+	if err != nil || through.IsZero() {
+		return err
+	}
+	now := time.Now().UTC()
+	end := now.Truncate(time.Hour)
+	from := through
+	if recent := now.Add(-s.lookback).Truncate(time.Hour); recent.Before(from) {
+		from = recent
+	}
+	catchingUp := end.Sub(from) > step
+
+	for from.Before(end) {
+		to := from.Add(step)
+		if to.After(end) {
+			to = end
+		}
+		started := time.Now()
+		err := s.sink.RollUp(ctx, from, to)
+		if errors.Is(err, storage.ErrRollupRunning) {
+			return err
+		}
+		metrics.RecordRollup(time.Since(started), err)
+		if err != nil {
+			return err
+		}
+		metrics.UpdateRollupLag(now.Sub(to))
+		if catchingUp {
+			s.logger.Info("Rolled up events from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+		}
+		from = to
+	}
+	metrics.UpdateRollupLag(now.Sub(end))
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package schemaregistry decodes Avro messages framed in the Confluent wire format, fetching their
+// schemas from a Schema Registry
+package schemaregistry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// magicByte starts every Confluent-framed message, followed by a 4-byte big-endian schema ID
+const magicByte = 0
+
+// headerSize is the magic byte plus the schema ID
+const headerSize = 5
+
+// ErrUnavailable wraps failures to fetch a schema; retrying may succeed, unlike a bad message
+var ErrUnavailable = errors.New("schema registry unavailable")
+
+// Framed reports whether value is in the Confluent wire format rather than, say, JSON
+func Framed(value []byte) bool {
+	return len(value) >= headerSize && value[0] == magicByte
+}
+
+// Client fetches schemas by ID and caches them; schemas never change once registered, so
+// entries are kept for the life of the process
+type Client struct {
+	baseURL string
+	http    *http.Client
+
+	mu     sync.Mutex
+	codecs map[uint32]*goavro.Codec
+}
+
+// New creates a client for the registry at baseURL; credentials in the URL are sent as basic auth
+func New(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    &http.Client{Timeout: timeout},
+		codecs:  make(map[uint32]*goavro.Codec),
+	}
+}
+
+// Decode converts a framed Avro message to plain JSON, with unions unwrapped, so it decodes
+// like a JSON message would
+func (c *Client) Decode(value []byte) ([]byte, error) {
+	if !Framed(value) {
+		return nil, errors.New("not a schema registry framed message")
+	}
+	id := binary.BigEndian.Uint32(value[1:headerSize])
+	codec, err := c.codec(id)
+	if err != nil {
+		return nil, err
+	}
+
+	native, rest, err := codec.NativeFromBinary(value[headerSize:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Avro message with schema %d: %w", id, err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d bytes left over decoding Avro message with schema %d", len(rest), id)
+	}
+	return codec.TextualFromNative(nil, native)
+}
+
+// codec returns the codec for schema id, fetching it on first use
+func (c *Client) codec(id uint32) (*goavro.Codec, error) {
+	c.mu.Lock()
+	codec := c.codecs[id]
+	c.mu.Unlock()
+	if codec != nil {
+		return codec, nil
+	}
+
+	schema, err := c.fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	codec, err = goavro.NewCodecForStandardJSONFull(schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema %d: %w", id, err)
+	}
+
+	c.mu.Lock()
+	c.codecs[id] = codec
+	c.mu.Unlock()
+	return codec, nil
+}
+
+// fetch gets the schema registered under id
+func (c *Client) fetch(id uint32) (string, error) {
+	resp, err := c.http.Get(fmt.Sprintf("%s/schemas/ids/%d", c.baseURL, id))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// Not an outage: the message refers to a schema that doesn't exist
+		return "", fmt.Errorf("schema %d not found", id)
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("%w: fetching schema %d returned %d", ErrUnavailable, id, resp.StatusCode)
+	}
+
+	var body struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: invalid response for schema %d: %v", ErrUnavailable, id, err)
+	}
+	// schemaType is omitted for Avro, the registry's default
+	if body.SchemaType != "" && body.SchemaType != "AVRO" {
+		return "", fmt.Errorf("schema %d is %s, not Avro", id, body.SchemaType)
+	}
+	return body.Schema, nil
+}
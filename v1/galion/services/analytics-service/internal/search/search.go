@@ -0,0 +1,506 @@
+// Package search indexes stored events into OpenSearch, or Elasticsearch, in daily indices, so
+// support engineers can search their payloads; it is a secondary copy that never holds up storage
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Timeouts and backoff of requests to the cluster
+const (
+	requestTimeout = 30 * time.Second
+	closeTimeout   = 10 * time.Second // how long Close keeps sending queued events
+	retryBase      = 500 * time.Millisecond
+	retryMax       = 30 * time.Second
+)
+
+// expireInterval is how often indices past the retention are looked for
+const expireInterval = time.Hour
+
+// indexDay is how the day is written in index names, as in analytics-events-2026.01.02
+const indexDay = "2006.01.02"
+
+// Config sets where and how events are indexed
+type Config struct {
+	URL           string        // cluster URL, with credentials in it for basic auth
+	Prefix        string        // indices are named Prefix-YYYY.MM.DD after each event's UTC day
+	Retention     time.Duration // indices of days ended longer ago are deleted; 0 keeps them
+	QueueSize     int           // events waiting to be indexed; more are dropped
+	BatchSize     int           // events per bulk request
+	FlushInterval time.Duration // longest time an event waits to be sent
+}
+
+// Indexer sends stored events to the cluster in bulk requests, from a queue of its own
+// The queue is bounded: while the cluster is slow, pushing back or down, requests are retried with
+// backoff and events that don't fit are dropped and counted, rather than holding up consumption,
+// since every event is in the database anyway
+type Indexer struct {
+	config         Config
+	endpoint       *url.URL
+	user, password string
+	client         *http.Client
+	logger         *logger.Logger
+	events         chan storage.Event
+	stop           chan struct{} // closed by Close
+	stopped        chan struct{} // closed once queued events are sent
+}
+
+// New creates an indexer, putting the index template its indices are created with, and starts
+// sending; Close sends what is queued
+func New(ctx context.Context, cfg Config, log *logger.Logger) (*Indexer, error) {
+	endpoint, err := url.Parse(cfg.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid search URL: must be http:// or https://")
+	}
+	ix := &Indexer{
+		config:  cfg,
+		client:  &http.Client{},
+		logger:  log,
+		events:  make(chan storage.Event, cfg.QueueSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if endpoint.User != nil {
+		ix.user = endpoint.User.Username()
+		ix.password, _ = endpoint.User.Password()
+		endpoint.User = nil
+	}
+	endpoint.Path = strings.TrimRight(endpoint.Path, "/")
+	ix.endpoint = endpoint
+
+	if err := ix.putTemplate(ctx); err != nil {
+		return nil, err
+	}
+	go ix.run()
+	return ix, nil
+}
+
+// Index queues a stored event to be indexed, without waiting; it is dropped if the queue is full,
+// or if its day's index is past the retention and would only be deleted again
+func (ix *Indexer) Index(event storage.Event) {
+	if ix.config.Retention > 0 && dayStart(event.Timestamp).AddDate(0, 0, 1).Before(time.Now().Add(-ix.config.Retention)) {
+		metrics.RecordSearchDropped("expired", 1)
+		return
+	}
+	select {
+	case ix.events <- event:
+	default:
+		metrics.RecordSearchDropped("queue_full", 1)
+	}
+}
+
+// Close sends the queued events, giving up after closeTimeout, and stops the indexer; events
+// indexed afterwards are never sent
+func (ix *Indexer) Close() {
+	close(ix.stop)
+	<-ix.stopped
+	ix.client.CloseIdleConnections()
+}
+
+func (ix *Indexer) run() {
+	defer close(ix.stopped)
+	ticker := time.NewTicker(ix.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]storage.Event, 0, ix.config.BatchSize)
+	for {
+		select {
+		case event := <-ix.events:
+			batch = append(batch, event)
+			if len(batch) < ix.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-ix.stop:
+			ix.drain(batch)
+			return
+		}
+		ix.send(context.Background(), batch)
+		batch = batch[:0]
+		metrics.UpdateSearchQueueLength(len(ix.events))
+	}
+}
+
+// drain sends batch and the events still queued, each once, within closeTimeout
+func (ix *Indexer) drain(batch []storage.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	for {
+	fill:
+		for len(batch) < ix.config.BatchSize {
+			select {
+			case event := <-ix.events:
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			metrics.UpdateSearchQueueLength(0)
+			return
+		}
+		ix.send(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// send indexes events, retrying with backoff while the cluster can't take them or pushes back, until
+// Close; the queue fills meanwhile, so new events are dropped rather than piling up
+func (ix *Indexer) send(ctx context.Context, events []storage.Event) {
+	for attempt := 1; ; attempt++ {
+		retry, err := ix.bulk(ctx, events)
+		if len(retry) == 0 {
+			return
+		}
+		select {
+		case <-ix.stop:
+			ix.logger.Error("Dropped %d events not yet indexed for search on shutdown: %v", len(retry), err)
+			metrics.RecordSearchDropped("shutdown", len(retry))
+			return
+		default:
+		}
+		delay := backoff(attempt)
+		ix.logger.Warn("Failed to index %d events for search (attempt %d), retrying in %s: %v", len(retry), attempt, delay, err)
+		events = retry
+		select {
+		case <-time.After(delay):
+		case <-ix.stop:
+		}
+	}
+}
+
+// bulkResponse is the part of a bulk response saying how each event went
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends events in one bulk request, returning those to send again, with why: all of them if
+// the cluster couldn't take the request, or those it pushed back on; those it rejected are dropped
+func (ix *Indexer) bulk(ctx context.Context, events []storage.Event) ([]storage.Event, error) {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		action := map[string]string{"_index": ix.index(event.Timestamp)}
+		// Redelivered events replace their earlier copy
+		if event.Topic != "" {
+			action["_id"] = fmt.Sprintf("%s-%d-%d", event.Topic, event.Partition, event.Offset)
+		}
+		enc.Encode(map[string]interface{}{"index": action})
+		if err := enc.Encode(newDocument(event)); err != nil {
+			metrics.RecordSearchDropped("rejected", len(events))
+			return nil, fmt.Errorf("failed to encode %s event: %w", event.EventType, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	start := time.Now()
+	var response bulkResponse
+	err := ix.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", &body, &response)
+	if err != nil {
+		metrics.RecordSearchBulk(0, time.Since(start), err)
+		if retryable(err) {
+			return events, err
+		}
+		ix.logger.Error("Search cluster rejected %d events: %v", len(events), err)
+		metrics.RecordSearchDropped("rejected", len(events))
+		return nil, err
+	}
+
+	var retry []storage.Event
+	var rejected int
+	for i, item := range response.Items {
+		if i >= len(events) {
+			break
+		}
+		for _, result := range item {
+			switch {
+			case result.Status == http.StatusTooManyRequests:
+				retry = append(retry, events[i])
+			case result.Status >= 300:
+				if rejected == 0 {
+					ix.logger.Warn("Search cluster rejected a %s event: %s: %s", events[i].EventType, result.Error.Type, result.Error.Reason)
+				}
+				rejected++
+			}
+		}
+	}
+	metrics.RecordSearchBulk(len(events)-len(retry)-rejected, time.Since(start), nil)
+	metrics.RecordSearchDropped("rejected", rejected)
+	if len(retry) > 0 {
+		err = fmt.Errorf("the search cluster pushed back on %d of %d events", len(retry), len(events))
+	}
+	return retry, err
+}
+
+// document is an event as indexed; data is kept whole in the source, and as JSON text in payload,
+// which full-text search reads
+type document struct {
+	EventType string                 `json:"event_type"`
+	UserID    string                 `json:"user_id"`
+	Service   string                 `json:"service"`
+	TenantID  string                 `json:"tenant_id,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Topic     string                 `json:"topic,omitempty"`
+	Partition *int32                 `json:"kafka_partition,omitempty"`
+	Offset    *int64                 `json:"kafka_offset,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Payload   string                 `json:"payload,omitempty"`
+}
+
+// newDocument makes the document event is indexed as
+func newDocument(event storage.Event) document {
+	doc := document{
+		EventType: event.EventType,
+		UserID:    event.UserID,
+		Service:   event.Service,
+		TenantID:  event.TenantID,
+		Timestamp: event.Timestamp.UTC(),
+		Data:      event.Data,
+	}
+	if event.Topic != "" {
+		doc.Topic, doc.Partition, doc.Offset = event.Topic, &event.Partition, &event.Offset
+	}
+	if len(event.Data) > 0 {
+		// Data came from JSON, so it marshals again
+		payload, _ := json.Marshal(event.Data)
+		doc.Payload = string(payload)
+	}
+	return doc
+}
+
+// putTemplate creates or updates the template the daily indices are created with; only the fields
+// below are indexed, so producers can't add mapped fields without limit
+func (ix *Indexer) putTemplate(ctx context.Context) error {
+	keyword := map[string]string{"type": "keyword"}
+	template := map[string]interface{}{
+		"index_patterns": []string{ix.config.Prefix + "-*"},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"dynamic": false,
+				"properties": map[string]interface{}{
+					"event_type":      keyword,
+					"user_id":         keyword,
+					"service":         keyword,
+					"tenant_id":       keyword,
+					"timestamp":       map[string]string{"type": "date"},
+					"topic":           keyword,
+					"kafka_partition": map[string]string{"type": "integer"},
+					"kafka_offset":    map[string]string{"type": "long"},
+					"data":            map[string]interface{}{"type": "object", "enabled": false},
+					"payload":         map[string]string{"type": "text"},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if err := ix.do(ctx, http.MethodPut, "/_index_template/"+url.PathEscape(ix.config.Prefix), "application/json", bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("failed to put index template %s: %w", ix.config.Prefix, err)
+	}
+	return nil
+}
+
+// Run deletes the indices past the retention every expireInterval until ctx is done, starting at
+// once; it returns at once without a retention
+func (ix *Indexer) Run(ctx context.Context) {
+	if ix.config.Retention <= 0 {
+		return
+	}
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		deleted, err := ix.Expire(ctx)
+		for _, index := range deleted {
+			ix.logger.Info("Deleted search index %s", index)
+		}
+		if err != nil && ctx.Err() == nil {
+			ix.logger.Error("Failed to delete expired search indices: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Expire deletes the daily indices of days that ended more than the retention ago, returning their
+// names; indices under the prefix not named after a day are left alone
+func (ix *Indexer) Expire(ctx context.Context) ([]string, error) {
+	var indices []struct {
+		Index string `json:"index"`
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	if err := ix.do(ctx, http.MethodGet, "/_cat/indices/"+url.PathEscape(ix.config.Prefix)+"-*?format=json&h=index", "", nil, &indices); err != nil {
+		return nil, fmt.Errorf("failed to list indices: %w", err)
+	}
+
+	cutoff := time.Now().Add(-ix.config.Retention)
+	var deleted []string
+	for _, index := range indices {
+		day, err := time.Parse(indexDay, strings.TrimPrefix(index.Index, ix.config.Prefix+"-"))
+		if err != nil || !day.AddDate(0, 0, 1).Before(cutoff) {
+			continue
+		}
+		err = ix.do(ctx, http.MethodDelete, "/"+url.PathEscape(index.Index), "", nil, nil)
+		var clusterErr *Error
+		// Another instance may have deleted it first
+		if err != nil && !(errors.As(err, &clusterErr) && clusterErr.Status == http.StatusNotFound) {
+			return deleted, fmt.Errorf("failed to delete index %s: %w", index.Index, err)
+		}
+		if err == nil {
+			deleted = append(deleted, index.Index)
+		}
+	}
+	return deleted, nil
+}
+
+// eraseTimeout bounds a delete or update by query, which walks every daily index
+const eraseTimeout = 5 * time.Minute
+
+// EraseUser deletes the indexed events of userIDs of tenantID, none for events without a tenant, in
+// every daily index, or with storage.ErasureAnonymize keeps them under pseudonym without their data,
+// as the database does; it returns how many documents changed
+// The user's events still queued to be indexed are sent afterwards, and stay until erased again
+func (ix *Indexer) EraseUser(ctx context.Context, tenantID string, userIDs []string, mode, pseudonym string) (int64, error) {
+	filter := []interface{}{map[string]interface{}{"terms": map[string]interface{}{"user_id": userIDs}}}
+	query := map[string]interface{}{"filter": filter}
+	if tenantID != "" {
+		query["filter"] = append(filter, map[string]interface{}{"term": map[string]interface{}{"tenant_id": tenantID}})
+	} else {
+		query["must_not"] = map[string]interface{}{"exists": map[string]string{"field": "tenant_id"}}
+	}
+	request := map[string]interface{}{"query": map[string]interface{}{"bool": query}}
+	endpoint := "_delete_by_query"
+	if mode == storage.ErasureAnonymize {
+		endpoint = "_update_by_query"
+		request["script"] = map[string]interface{}{
+			"lang":   "painless",
+			"source": "ctx._source.user_id = params.pseudonym; ctx._source.remove('data'); ctx._source.remove('payload')",
+			"params": map[string]string{"pseudonym": pseudonym},
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Deleted  int64             `json:"deleted"`
+		Updated  int64             `json:"updated"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	ctx, cancel := context.WithTimeout(ctx, eraseTimeout)
+	defer cancel()
+	// Refreshed, so the erased events aren't found by searches once this returns; a conflict is a
+	// document reindexed meanwhile, which is a redelivered copy of the same event
+	path := "/" + url.PathEscape(ix.config.Prefix) + "-*/" + endpoint + "?conflicts=proceed&refresh=true"
+	if err := ix.do(ctx, http.MethodPost, path, "application/json", bytes.NewReader(body), &response); err != nil {
+		return 0, fmt.Errorf("failed to erase from search indices: %w", err)
+	}
+	changed := response.Deleted + response.Updated
+	if len(response.Failures) > 0 {
+		return changed, fmt.Errorf("failed to erase %d documents from search indices: %s", len(response.Failures), response.Failures[0])
+	}
+	return changed, nil
+}
+
+// index is the index of events of t's UTC day
+func (ix *Indexer) index(t time.Time) string {
+	return ix.config.Prefix + "-" + t.UTC().Format(indexDay)
+}
+
+// do sends a request with body, decoding a JSON response into out unless it is nil
+func (ix *Indexer) do(ctx context.Context, method, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, ix.endpoint.String()+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if ix.user != "" {
+		req.SetBasicAuth(ix.user, ix.password)
+	}
+	resp, err := ix.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{Status: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Error is an error response from the search cluster
+type Error struct {
+	Status  int // HTTP status
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("search cluster returned %d: %s", e.Status, e.Message)
+}
+
+// retryable reports whether a failed request may succeed later: the cluster was unreachable,
+// timed out, was unavailable, or pushed back
+func retryable(err error) bool {
+	var clusterErr *Error
+	if !errors.As(err, &clusterErr) {
+		return true
+	}
+	return clusterErr.Status == http.StatusTooManyRequests || clusterErr.Status >= 500
+}
+
+// backoff returns the delay after the given number of failed attempts: retryBase doubled per
+// attempt, capped at retryMax, with jitter so instances don't retry together
+func backoff(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	delay = delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+	return min(delay, retryMax)
+}
+
+// dayStart is the start of t's UTC day
+func dayStart(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
@@ -0,0 +1,103 @@
+// Package sessions keeps each user's events stitched into sessions, visits with no gap longer than
+// the session gap, so session counts and lengths are read from a table instead of every event
+package sessions
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"nexus-analytics-service/internal/storage"
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// step is the most time stitched in one transaction, so catching up after downtime, or on the
+// first run over a table of events, proceeds a day at a time
+const step = 24 * time.Hour
+
+// ErrRunning is returned by Sessionize while this instance is already stitching sessions
+var ErrRunning = errors.New("sessions are already being stitched")
+
+// Scheduler stitches the events stored since its last run into sessions, and those within the
+// lookback again, for events that arrive late; sessions still going on are continued by the next run
+type Scheduler struct {
+	sink     storage.Sessions
+	gap      time.Duration
+	lookback time.Duration
+	logger   *logger.Logger
+
+	running sync.Mutex
+}
+
+// New creates a scheduler ending sessions after gap without events, and stitching the events of
+// the last lookback again on every run
+func New(sink storage.Sessions, gap, lookback time.Duration, log *logger.Logger) *Scheduler {
+	return &Scheduler{sink: sink, gap: gap, lookback: lookback, logger: log}
+}
+
+// Run stitches sessions every interval until ctx is done, starting at once
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.Sessionize(ctx)
+		switch {
+		case errors.Is(err, storage.ErrSessionizeRunning):
+			s.logger.Debug("Skipped stitching sessions: %v", err)
+		case err != nil && ctx.Err() == nil:
+			s.logger.Error("Stitching sessions failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sessionize stitches the events stored up to the current minute since the last run, and those
+// within the lookback
+func (s *Scheduler) Sessionize(ctx context.Context) error {
+	if !s.running.TryLock() {
+		return ErrRunning
+	}
+	defer s.running.Unlock()
+
+	through, err := s.sink.SessionizedThrough(ctx)
+	if err != nil || through.IsZero() {
+		return err
+	}
+	now := time.Now().UTC()
+	end := now.Truncate(time.Minute)
+	from := through
+	if recent := now.Add(-s.lookback).Truncate(time.Minute); recent.Before(from) {
+		from = recent
+	}
+	catchingUp := end.Sub(from) > step
+
+	for from.Before(end) {
+		to := from.Add(step)
+		if to.After(end) {
+			to = end
+		}
+		started := time.Now()
+		err := s.sink.Sessionize(ctx, from, to, s.gap)
+		if errors.Is(err, storage.ErrSessionizeRunning) {
+			return err
+		}
+		metrics.RecordSessionize(time.Since(started), err)
+		if err != nil {
+			return err
+		}
+		metrics.UpdateSessionizeLag(now.Sub(to))
+		if catchingUp {
+			s.logger.Info("Stitched sessions from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+		}
+		from = to
+	}
+	metrics.UpdateSessionizeLag(now.Sub(end))
+	return nil
+}
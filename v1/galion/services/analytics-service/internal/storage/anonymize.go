@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// anonymizeBatchSize is how many events, or rollup users, each statement of an anonymization
+// changes, so no lock is held for long
+const anonymizeBatchSize = 1000
+
+// Anonymize implements Anonymizable in batches of anonymizeBatchSize, hashing user IDs here rather
+// than in the database, which needs no extension; one instance anonymizes a table at a time
+func (es *EventStore) Anonymize(ctx context.Context, r Retention, hash func(string) string, fields []string) (map[string]int64, error) {
+	conn, err := es.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	lock := es.table + " anonymize"
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", lock).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to lock %s for anonymizing: %w", es.table, err)
+	}
+	if !locked {
+		return nil, ErrAnonymizeRunning
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", lock)
+
+	// Fields are removed by path, as in data #- ARRAY['profile','email']
+	data := "data"
+	for _, field := range fields {
+		keys := strings.Split(field, ".")
+		for i, key := range keys {
+			keys[i] = pq.QuoteLiteral(key)
+		}
+		data += " #- ARRAY[" + strings.Join(keys, ", ") + "]"
+	}
+
+	anonymized := make(map[string]int64)
+	for _, rule := range r.rules() {
+		n, err := es.anonymizeEvents(ctx, conn, rule, hash, data)
+		anonymized[rule.label] += n
+		if err != nil {
+			return anonymized, fmt.Errorf("failed to anonymize %s events: %w", rule.label, err)
+		}
+		for _, rollup := range []struct{ suffix, unit string }{{"rollup_hourly", "hour"}, {"rollup_daily", "day"}} {
+			if err := es.anonymizeRollup(ctx, conn, rule, hash, rollup.suffix, rollup.unit); err != nil {
+				return anonymized, fmt.Errorf("failed to anonymize %s rows of %s: %w", rule.label, rollup.suffix, err)
+			}
+		}
+	}
+	return anonymized, nil
+}
+
+// ruleWhere selects the rows of rule's event types, with its time as $1 and its types as $2
+func ruleWhere(rule purgeRule) (string, []interface{}) {
+	if rule.eventType != "" {
+		return "event_type = $2", []interface{}{rule.before.UTC(), rule.eventType}
+	}
+	return "NOT (event_type = ANY($2))", []interface{}{rule.before.UTC(), pq.Array(rule.except)}
+}
+
+// anonymizeEvents hashes the user IDs of the events rule selects and sets their data to data
+func (es *EventStore) anonymizeEvents(ctx context.Context, conn *sql.Conn, rule purgeRule, hash func(string) string, data string) (int64, error) {
+	where, args := ruleWhere(rule)
+	var total int64
+	for {
+		rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT id, timestamp, user_id FROM %s WHERE timestamp < $1 AND %s AND anonymized_at IS NULL LIMIT %d", es.table, where, anonymizeBatchSize), args...)
+		if err != nil {
+			return total, err
+		}
+		var ids []int64
+		var timestamps []time.Time
+		var hashes []string
+		hashed := make(map[string]string)
+		for rows.Next() {
+			var id int64
+			var timestamp time.Time
+			var userID string
+			if err := rows.Scan(&id, &timestamp, &userID); err != nil {
+				rows.Close()
+				return total, err
+			}
+			if _, ok := hashed[userID]; !ok {
+				hashed[userID] = hash(userID)
+			}
+			ids = append(ids, id)
+			timestamps = append(timestamps, timestamp)
+			hashes = append(hashes, hashed[userID])
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return total, err
+		}
+
+		// Matched on the time too, which partitioned tables and hypertables are keyed by
+		result, err := conn.ExecContext(ctx, `
+			UPDATE `+es.table+` AS e SET user_id = m.hashed, data = `+data+`, anonymized_at = CURRENT_TIMESTAMP
+			FROM unnest($1::bigint[], $2::timestamp[], $3::text[]) AS m(id, timestamp, hashed)
+			WHERE e.id = m.id AND e.timestamp = m.timestamp
+		`, pq.Array(ids), pq.Array(timestamps), pq.Array(hashes))
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		total += n
+		// None updated means they were all deleted meanwhile; the next run carries on
+		if err != nil || n == 0 || len(ids) < anonymizeBatchSize {
+			return total, err
+		}
+	}
+}
+
+// anonymizeRollup hashes the user IDs of a rollup's rows of rule's types in whole units before its
+// time; each user's rows move to the hashed ID, merging with rows already there
+func (es *EventStore) anonymizeRollup(ctx context.Context, conn *sql.Conn, rule purgeRule, hash func(string) string, suffix, unit string) error {
+	table := relatedTable(es.schema, es.name, suffix)
+	where, args := ruleWhere(rule)
+	where = "NOT anonymized AND bucket < date_trunc('" + unit + "', $1::timestamp) AND " + where
+	for {
+		rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT DISTINCT user_id FROM %s WHERE %s LIMIT %d", table, where, anonymizeBatchSize), args...)
+		if err != nil {
+			return err
+		}
+		var userIDs, hashes []string
+		for rows.Next() {
+			var userID string
+			if err := rows.Scan(&userID); err != nil {
+				rows.Close()
+				return err
+			}
+			userIDs = append(userIDs, userID)
+			hashes = append(hashes, hash(userID))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(userIDs) == 0 {
+			return err
+		}
+
+		_, err = conn.ExecContext(ctx, `
+			WITH m AS (SELECT * FROM unnest($3::text[], $4::text[]) AS m(user_id, hashed)),
+			moved AS (
+				DELETE FROM `+table+` AS r USING m WHERE r.user_id = m.user_id AND `+where+`
+				RETURNING r.tenant_id, r.bucket, r.event_type, r.service, m.hashed, r.events
+			)
+			INSERT INTO `+table+` AS r (tenant_id, bucket, event_type, service, user_id, events, anonymized)
+			SELECT tenant_id, bucket, event_type, service, hashed, sum(events), true FROM moved GROUP BY 1, 2, 3, 4, 5
+			ON CONFLICT (tenant_id, bucket, event_type, service, user_id) DO UPDATE SET events = r.events + EXCLUDED.events, anonymized = true
+		`, append(args, pq.Array(userIDs), pq.Array(hashes))...)
+		if err != nil {
+			return err
+		}
+		if len(userIDs) < anonymizeBatchSize {
+			return nil
+		}
+	}
+}
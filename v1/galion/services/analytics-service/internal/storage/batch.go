@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	mathrand "math/rand"
+	"time"
+
+	"nexus-analytics-service/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// flushTimeout bounds a single batch INSERT
+const flushTimeout = 30 * time.Second
+
+// BatchConfig sets when buffered events are written
+type BatchConfig struct {
+	Size     int           // write once this many events are buffered
+	Interval time.Duration // write buffered events at least this often
+
+	// Transient database errors are retried RetryAttempts times, waiting RetryBase doubled per
+	// attempt and capped at RetryMax
+	RetryAttempts int
+	RetryBase     time.Duration
+	RetryMax      time.Duration
+}
+
+// pendingEvent is a buffered event and the callback waiting for it to be written
+type pendingEvent struct {
+	event Event
+	done  func(error)
+}
+
+// BatchWriter buffers events and saves them in multi-row batches
+// Writes block while a full batch is being saved, so a slow database slows consumption instead of
+// growing the buffer
+type BatchWriter struct {
+	sink    EventSink
+	config  BatchConfig
+	logger  *logger.Logger
+	events  chan pendingEvent
+	flushes chan chan struct{} // Flush requests, closed once the buffer is saved
+	closed  chan struct{}
+}
+
+// NewBatchWriter starts a writer saving to sink; Close flushes what is buffered
+func NewBatchWriter(sink EventSink, config BatchConfig, log *logger.Logger) *BatchWriter {
+	bw := &BatchWriter{
+		sink:    sink,
+		config:  config,
+		logger:  log,
+		events:  make(chan pendingEvent, config.Size),
+		flushes: make(chan chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	go bw.run()
+	return bw
+}
+
+// Write buffers an event; done is called with the result once its batch is saved
+// done is called from the writer's goroutine and must not call Write
+func (bw *BatchWriter) Write(event Event, done func(error)) {
+	bw.events <- pendingEvent{event: event, done: done}
+}
+
+// Flush saves the events written so far without waiting for the batch to fill; it returns at once
+// after Close
+func (bw *BatchWriter) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case bw.flushes <- flushed:
+		<-flushed
+	case <-bw.closed:
+	}
+}
+
+// Close saves the buffered events and stops the writer; Write must not be called afterwards
+func (bw *BatchWriter) Close() {
+	close(bw.events)
+	<-bw.closed
+}
+
+func (bw *BatchWriter) run() {
+	defer close(bw.closed)
+	ticker := time.NewTicker(bw.config.Interval)
+	defer ticker.Stop()
+
+	batch := make([]pendingEvent, 0, bw.config.Size)
+	for {
+		select {
+		case pending, ok := <-bw.events:
+			if !ok {
+				bw.flush(batch)
+				return
+			}
+			batch = append(batch, pending)
+			if len(batch) < bw.config.Size {
+				continue
+			}
+		case flushed := <-bw.flushes:
+			batch = bw.drain(batch)
+			bw.flush(batch)
+			batch = batch[:0]
+			close(flushed)
+			continue
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		bw.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+// drain adds the events already written to batch, saving it whenever it fills
+func (bw *BatchWriter) drain(batch []pendingEvent) []pendingEvent {
+	for {
+		select {
+		case pending, ok := <-bw.events:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, pending)
+			if len(batch) == bw.config.Size {
+				bw.flush(batch)
+				batch = batch[:0]
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// flush saves a batch and reports the result to each event's callback
+func (bw *BatchWriter) flush(batch []pendingEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	events := make([]Event, len(batch))
+	for i, pending := range batch {
+		events[i] = pending.event
+	}
+
+	err := bw.save(events)
+	if err != nil && len(events) > 1 && !IsTransient(err) {
+		// One bad event fails the whole batch; saving them one by one fails only that event
+		bw.logger.Warn("Saving batch of %d events failed, saving them one by one: %v", len(events), err)
+		for i, pending := range batch {
+			pending.done(bw.save(events[i : i+1]))
+		}
+		return
+	}
+
+	for _, pending := range batch {
+		pending.done(err)
+	}
+}
+
+// save writes events, retrying transient errors with backoff
+// Retrying holds up the writer, so consumption pauses while the database is unavailable
+func (bw *BatchWriter) save(events []Event) error {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		start := time.Now()
+		saved, err := bw.sink.SaveBatch(ctx, events)
+		cancel()
+		metrics.RecordBatchFlush(bw.sink.Method(), len(events), time.Since(start), err)
+		if err == nil {
+			if duplicates := int64(len(events)) - saved; duplicates > 0 {
+				metrics.RecordDuplicateEvents(duplicates)
+				bw.logger.Debug("Dropped %d already stored events", duplicates)
+			}
+			bw.logger.Debug("Saved batch of %d events (%s) in %s", len(events), bw.sink.Method(), time.Since(start))
+			return nil
+		}
+		if !IsTransient(err) || attempt > bw.config.RetryAttempts {
+			bw.logger.Error("Failed to save batch of %d events (%s): %v", len(events), bw.sink.Method(), err)
+			return err
+		}
+
+		delay := bw.backoff(attempt)
+		bw.logger.Warn("Transient error saving batch of %d events (attempt %d of %d), retrying in %s: %v", len(events), attempt, bw.config.RetryAttempts+1, delay, err)
+		metrics.RecordDatabaseRetry()
+		time.Sleep(delay)
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts: RetryBase doubled per
+// attempt, capped at RetryMax, with jitter so replicas retrying a restarted database don't arrive together
+func (bw *BatchWriter) backoff(attempts int) time.Duration {
+	delay := bw.config.RetryBase
+	for i := 1; i < attempts && delay < bw.config.RetryMax; i++ {
+		delay *= 2
+	}
+	delay = delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+	return min(delay, bw.config.RetryMax)
+}
@@ -0,0 +1,476 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	backends[BackendClickHouse] = func(cfg SinkConfig) (EventSink, error) { return NewClickHouseStore(cfg) }
+}
+
+// MethodAsyncInsert is how ClickHouseStore writes: INSERTs buffered and merged by the server
+const MethodAsyncInsert = "async_insert"
+
+// clickHouseTime is how DateTime64(3) values are sent and read back, in UTC
+const clickHouseTime = "2006-01-02 15:04:05.000"
+
+// clickHouseTimeout bounds setting up the table; inserts and queries are bounded by their context
+const clickHouseTimeout = 30 * time.Second
+
+// clickHouseInsertSettings make inserts asynchronous, so the server merges small batches from many
+// replicas into larger parts, while still answering only once the events are written; offsets are
+// committed after that, so a failed flush redelivers the events instead of losing them
+var clickHouseInsertSettings = map[string]string{
+	"async_insert":          "1",
+	"wait_for_async_insert": "1",
+}
+
+// clickHouseReadSettings return 64-bit integers as JSON numbers rather than strings
+var clickHouseReadSettings = map[string]string{
+	"output_format_json_quote_64bit_integers": "0",
+}
+
+// ClickHouseStore stores events in ClickHouse over its HTTP interface
+// The table is a ReplacingMergeTree keyed on the event's type, time and source, so an event saved
+// twice is collapsed into the last copy as parts merge, which makes every write an overwrite; reads
+// that must not see copies not yet merged use FINAL
+type ClickHouseStore struct {
+	client   *http.Client
+	endpoint *url.URL // without credentials, which are sent as headers
+	user     string
+	password string
+	table    string // quoted, database-qualified
+}
+
+// NewClickHouseStore connects to the ClickHouse server at cfg.URL (http:// or https://, port 8123 or
+// 8443 by default), creating the table if it doesn't exist and setting its TTL if cfg.TTL is set
+func NewClickHouseStore(cfg SinkConfig) (*ClickHouseStore, error) {
+	if cfg.Table == "" {
+		cfg.Table = DefaultTable
+	}
+	parts := tableName.FindStringSubmatch(cfg.Table)
+	if parts == nil {
+		return nil, ValidateTable(cfg.Table)
+	}
+	database, name := parts[1], parts[2]
+
+	endpoint, err := url.Parse(cfg.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid ClickHouse URL: must be http:// or https://")
+	}
+	cs := &ClickHouseStore{
+		client: &http.Client{},
+		table:  "`" + database + "`.`" + name + "`",
+	}
+	if endpoint.User != nil {
+		cs.user = endpoint.User.Username()
+		cs.password, _ = endpoint.User.Password()
+		endpoint.User = nil
+	}
+	endpoint.Path = "/"
+	cs.endpoint = endpoint
+
+	ctx, cancel := context.WithTimeout(context.Background(), clickHouseTimeout)
+	defer cancel()
+	if err := cs.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping ClickHouse: %w", err)
+	}
+	if err := cs.exec(ctx, "CREATE DATABASE IF NOT EXISTS `"+database+"`", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to create %s database: %w", database, err)
+	}
+
+	// Sorted for aggregating by type over time; the source columns make every event's key unique,
+	// so only copies of the same message are collapsed
+	err = cs.exec(ctx, `
+		CREATE TABLE IF NOT EXISTS `+cs.table+` (
+			event_type LowCardinality(String),
+			user_id String,
+			service LowCardinality(String),
+			timestamp DateTime64(3, 'UTC'),
+			data String CODEC(ZSTD(3)),
+			topic LowCardinality(String),
+			kafka_partition Int32,
+			kafka_offset Int64,
+			tenant_id LowCardinality(String) DEFAULT '',
+			stored_at DateTime64(3, 'UTC') DEFAULT now64(3)
+		)
+		ENGINE = ReplacingMergeTree(stored_at)
+		PARTITION BY toYYYYMM(timestamp)
+		ORDER BY (event_type, timestamp, topic, kafka_partition, kafka_offset)
+	`, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s table: %w", cfg.Table, err)
+	}
+	// Tables created before tenants get the column, empty for the events already stored
+	if err := cs.exec(ctx, "ALTER TABLE "+cs.table+" ADD COLUMN IF NOT EXISTS tenant_id LowCardinality(String) DEFAULT '' AFTER kafka_offset", nil, nil); err != nil {
+		return nil, fmt.Errorf("failed to add tenant_id to %s: %w", cfg.Table, err)
+	}
+
+	// Expired events are dropped as parts merge; existing parts aren't rewritten for a changed TTL
+	if cfg.TTL > 0 {
+		ttl := fmt.Sprintf("ALTER TABLE %s MODIFY TTL toDateTime(timestamp) + INTERVAL %d SECOND", cs.table, int64(cfg.TTL/time.Second))
+		if err := cs.exec(ctx, ttl, map[string]string{"materialize_ttl_after_modify": "0"}, nil); err != nil {
+			return nil, fmt.Errorf("failed to set TTL of %s: %w", cfg.Table, err)
+		}
+	}
+	return cs, nil
+}
+
+// clickHouseRow is an event as sent to and read from ClickHouse in JSONEachRow format
+type clickHouseRow struct {
+	EventType string `json:"event_type"`
+	UserID    string `json:"user_id"`
+	Service   string `json:"service"`
+	Timestamp string `json:"timestamp"`
+	Data      string `json:"data"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"kafka_partition"`
+	Offset    int64  `json:"kafka_offset"`
+	TenantID  string `json:"tenant_id"`
+	StoredAt  string `json:"stored_at,omitempty"`
+}
+
+// SaveBatch implements EventSink with one asynchronous INSERT, which ClickHouse applies whole or not at all
+// Duplicates can't be told apart while inserting, so every event counts as written
+func (cs *ClickHouseStore) SaveBatch(ctx context.Context, events []Event) (int64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal data of %s event: %w", event.EventType, err)
+		}
+		err = enc.Encode(clickHouseRow{
+			EventType: event.EventType,
+			UserID:    event.UserID,
+			Service:   event.Service,
+			Timestamp: event.Timestamp.UTC().Format(clickHouseTime),
+			Data:      string(data),
+			Topic:     event.Topic,
+			Partition: event.Partition,
+			Offset:    event.Offset,
+			TenantID:  event.TenantID,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	query := "INSERT INTO " + cs.table + " (" + strings.Join(eventColumnNames, ", ") + ") FORMAT JSONEachRow"
+	if err := cs.exec(ctx, query, clickHouseInsertSettings, &body); err != nil {
+		return 0, fmt.Errorf("failed to insert %d events: %w", len(events), err)
+	}
+	return int64(len(events)), nil
+}
+
+// Method implements EventSink
+func (cs *ClickHouseStore) Method() string {
+	return MethodAsyncInsert
+}
+
+// Query implements EventSink, reading with FINAL so events saved twice are returned once
+// Values are inlined as quoted literals, since parameters over HTTP need the query's types spelled out
+func (cs *ClickHouseStore) Query(ctx context.Context, q Query) ([]StoredEvent, error) {
+	where := clickHouseWhere(q)
+	order, after := "DESC", "<"
+	if q.Ascending {
+		order, after = "ASC", ">"
+	}
+	// Events are unique by time and source, which the table is keyed by
+	key := "(timestamp, topic, kafka_partition, kafka_offset)"
+	if q.After != nil {
+		where = append(where, fmt.Sprintf("%s %s (toDateTime64(%s, 3, 'UTC'), %s, %d, %d)", key, after,
+			clickHouseString(q.After.Timestamp.UTC().Format(clickHouseTime)), clickHouseString(q.After.Topic), q.After.Partition, q.After.Offset))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+
+	query := "SELECT " + strings.Join(eventColumnNames, ", ") + ", stored_at FROM " + cs.table + " FINAL WHERE " + strings.Join(where, " AND ")
+	query += fmt.Sprintf(" ORDER BY timestamp %[1]s, topic %[1]s, kafka_partition %[1]s, kafka_offset %[1]s LIMIT %[2]d FORMAT JSONEachRow", order, limit)
+
+	var events []StoredEvent
+	err := cs.read(ctx, query, func(line []byte) error {
+		var row clickHouseRow
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		event := StoredEvent{Event: Event{
+			EventType: row.EventType,
+			UserID:    row.UserID,
+			Service:   row.Service,
+			Topic:     row.Topic,
+			Partition: row.Partition,
+			Offset:    row.Offset,
+			TenantID:  row.TenantID,
+		}}
+		event.Timestamp, _ = time.Parse(clickHouseTime, row.Timestamp)
+		event.StoredAt, _ = time.Parse(clickHouseTime, row.StoredAt)
+		if row.Data != "" {
+			if err := json.Unmarshal([]byte(row.Data), &event.Data); err != nil {
+				return fmt.Errorf("failed to unmarshal data of %s event: %w", row.EventType, err)
+			}
+		}
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	return events, nil
+}
+
+// clickHouseWhere is the conditions selecting the events q filters
+func clickHouseWhere(q Query) []string {
+	where := []string{"tenant_id = " + clickHouseString(q.TenantID)}
+	if q.EventType != "" {
+		where = append(where, "event_type = "+clickHouseString(q.EventType))
+	}
+	if q.UserID != "" {
+		where = append(where, "user_id = "+clickHouseString(q.UserID))
+	}
+	if q.Service != "" {
+		where = append(where, "service = "+clickHouseString(q.Service))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "timestamp >= toDateTime64("+clickHouseString(q.From.UTC().Format(clickHouseTime))+", 3, 'UTC')")
+	}
+	if !q.To.IsZero() {
+		where = append(where, "timestamp < toDateTime64("+clickHouseString(q.To.UTC().Format(clickHouseTime))+", 3, 'UTC')")
+	}
+	return where
+}
+
+// CountSeries implements TimeSeries; like Counts it reads without FINAL, so events saved twice and
+// not yet merged are counted twice
+func (cs *ClickHouseStore) CountSeries(ctx context.Context, q SeriesQuery) ([]Bucket, error) {
+	start := "toStartOfInterval(timestamp, INTERVAL " + strconv.FormatInt(int64(q.Interval/time.Second), 10) + " SECOND) AS bucket"
+	columns := append([]string{start}, q.GroupBy...)
+	groupBy := append([]string{"bucket"}, q.GroupBy...)
+	query := "SELECT " + strings.Join(columns, ", ") + ", count() AS events FROM " + cs.table + " WHERE " + strings.Join(clickHouseWhere(q.Query), " AND ")
+	query += " GROUP BY " + strings.Join(groupBy, ", ") + " ORDER BY bucket FORMAT JSONEachRow"
+
+	var buckets []Bucket
+	err := cs.read(ctx, query, func(line []byte) error {
+		var row struct {
+			Bucket string `json:"bucket"`
+			Events int64  `json:"events"`
+		}
+		var group map[string]interface{}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(line, &group); err != nil {
+			return err
+		}
+		bucket := Bucket{Group: make(map[string]string, len(q.GroupBy)), Count: row.Events}
+		bucket.Start, _ = time.Parse(clickHouseTime, row.Bucket)
+		for _, column := range q.GroupBy {
+			bucket.Group[column], _ = group[column].(string)
+		}
+		buckets = append(buckets, bucket)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count events per bucket: %w", err)
+	}
+	return buckets, nil
+}
+
+// Counts implements EventSink
+// Counting with FINAL would merge the whole table every time, so events saved twice and not yet
+// merged are counted twice
+func (cs *ClickHouseStore) Counts(ctx context.Context) (map[string]int64, error) {
+	result := make(map[string]int64)
+	err := cs.read(ctx, "SELECT event_type, count() AS count FROM "+cs.table+" GROUP BY event_type FORMAT JSONEachRow", func(line []byte) error {
+		var row struct {
+			EventType string `json:"event_type"`
+			Count     int64  `json:"count"`
+		}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return err
+		}
+		result[row.EventType] = row.Count
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Purge implements EventSink with lightweight DELETEs (ClickHouse 23.3 or later), which hide events
+// at once and remove them as parts merge; counts include events saved twice and not yet merged
+func (cs *ClickHouseStore) Purge(ctx context.Context, r Retention, dryRun bool) (map[string]int64, error) {
+	purged := make(map[string]int64)
+	for _, rule := range r.rules() {
+		where := "timestamp < toDateTime64(" + clickHouseString(rule.before.UTC().Format(clickHouseTime)) + ", 3, 'UTC')"
+		if rule.eventType != "" {
+			where += " AND event_type = " + clickHouseString(rule.eventType)
+		} else if len(rule.except) > 0 {
+			except := make([]string, len(rule.except))
+			for i, eventType := range rule.except {
+				except[i] = clickHouseString(eventType)
+			}
+			where += " AND event_type NOT IN (" + strings.Join(except, ", ") + ")"
+		}
+
+		err := cs.read(ctx, "SELECT count() AS count FROM "+cs.table+" WHERE "+where+" FORMAT JSONEachRow", func(line []byte) error {
+			var row struct {
+				Count int64 `json:"count"`
+			}
+			err := json.Unmarshal(line, &row)
+			purged[rule.label] = row.Count
+			return err
+		})
+		if err != nil {
+			return purged, fmt.Errorf("failed to count %s events to purge: %w", rule.label, err)
+		}
+		if dryRun || purged[rule.label] == 0 {
+			continue
+		}
+		if err := cs.exec(ctx, "DELETE FROM "+cs.table+" WHERE "+where, nil, nil); err != nil {
+			return purged, fmt.Errorf("failed to purge %s events: %w", rule.label, err)
+		}
+	}
+	return purged, nil
+}
+
+// EraseUser implements Erasable with a lightweight DELETE, or a mutation waited for to anonymize;
+// there are no rollups or archived files to change
+func (cs *ClickHouseStore) EraseUser(ctx context.Context, tenantID string, userIDs []string, mode, pseudonym string) (Erasure, error) {
+	erasure := Erasure{Rows: make(map[string]int64)}
+	ids := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		ids[i] = clickHouseString(id)
+	}
+	where := "tenant_id = " + clickHouseString(tenantID) + " AND user_id IN (" + strings.Join(ids, ", ") + ")"
+	name := strings.ReplaceAll(cs.table, "`", "")
+
+	err := cs.read(ctx, "SELECT count() AS count FROM "+cs.table+" WHERE "+where+" FORMAT JSONEachRow", func(line []byte) error {
+		var row struct {
+			Count int64 `json:"count"`
+		}
+		err := json.Unmarshal(line, &row)
+		erasure.Rows[name] = row.Count
+		return err
+	})
+	if err != nil || erasure.Rows[name] == 0 {
+		return erasure, err
+	}
+	if mode == ErasureAnonymize {
+		err = cs.exec(ctx, "ALTER TABLE "+cs.table+" UPDATE user_id = "+clickHouseString(pseudonym)+", data = '' WHERE "+where, map[string]string{"mutations_sync": "2"}, nil)
+	} else {
+		err = cs.exec(ctx, "DELETE FROM "+cs.table+" WHERE "+where, nil, nil)
+	}
+	if err != nil {
+		return erasure, fmt.Errorf("failed to erase the user's events: %w", err)
+	}
+	return erasure, nil
+}
+
+// Ping implements EventSink
+func (cs *ClickHouseStore) Ping(ctx context.Context) error {
+	return cs.exec(ctx, "SELECT 1", nil, nil)
+}
+
+// Close implements EventSink
+func (cs *ClickHouseStore) Close() error {
+	cs.client.CloseIdleConnections()
+	return nil
+}
+
+// exec runs a statement, sending body as its data
+func (cs *ClickHouseStore) exec(ctx context.Context, query string, settings map[string]string, body io.Reader) error {
+	resp, err := cs.do(ctx, query, settings, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// read runs a query, calling row with each line of its output
+func (cs *ClickHouseStore) read(ctx context.Context, query string, row func(line []byte) error) error {
+	resp, err := cs.do(ctx, query, clickHouseReadSettings, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if err := row(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// do sends a query over HTTP; the query goes in the URL so that body can carry INSERT data
+func (cs *ClickHouseStore) do(ctx context.Context, query string, settings map[string]string, body io.Reader) (*http.Response, error) {
+	params := url.Values{"query": {query}}
+	for name, value := range settings {
+		params.Set(name, value)
+	}
+	endpoint := *cs.endpoint
+	endpoint.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if cs.user != "" {
+		req.Header.Set("X-ClickHouse-User", cs.user)
+		req.Header.Set("X-ClickHouse-Key", cs.password)
+	}
+	resp, err := cs.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newClickHouseError(resp, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// clickHouseString quotes s as a ClickHouse string literal
+func clickHouseString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// ClickHouseError is an error response from ClickHouse
+type ClickHouseError struct {
+	Status  int // HTTP status
+	Code    int // ClickHouse error code, 0 if the response had none
+	Message string
+}
+
+func (e *ClickHouseError) Error() string {
+	return fmt.Sprintf("ClickHouse returned %d: %s", e.Status, e.Message)
+}
+
+// newClickHouseError reads the error code from the X-ClickHouse-Exception-Code header
+func newClickHouseError(resp *http.Response, message string) *ClickHouseError {
+	code, _ := strconv.Atoi(resp.Header.Get("X-ClickHouse-Exception-Code"))
+	return &ClickHouseError{Status: resp.StatusCode, Code: code, Message: message}
+}
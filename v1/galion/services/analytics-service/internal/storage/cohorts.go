@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"nexus-analytics-service/pkg/metrics"
+)
+
+// ComputeCohorts implements Cohorts, replacing every tenant's cohorts in one transaction, so the
+// API never reads a table half computed; weeks start on Monday, UTC
+// A user's first week is that of their oldest stored event, so users whose earlier events were
+// purged count as new, and only cohorts that started within the weeks are kept
+func (es *EventStore) ComputeCohorts(ctx context.Context, weeks int, maxAge time.Duration) (time.Time, error) {
+	cohorts := relatedTable(es.schema, es.name, "cohorts")
+	state := relatedTable(es.schema, es.name, "cohort_state")
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock(hashtext($1))", cohorts).Scan(&locked); err != nil {
+		return time.Time{}, fmt.Errorf("failed to lock cohorts: %w", err)
+	}
+	var computedAt sql.NullTime
+	if err := tx.QueryRowContext(ctx, "SELECT computed_at FROM "+state).Scan(&computedAt); err != nil && err != sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("failed to read cohort state: %w", err)
+	}
+	// Another instance is computing them, or they are fresh enough
+	if !locked || (computedAt.Valid && time.Since(computedAt.Time) < maxAge) {
+		return computedAt.Time.UTC(), nil
+	}
+
+	started := time.Now().UTC()
+	from := weekStart(started).AddDate(0, 0, -7*(weeks-1))
+	statements := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"DELETE FROM " + cohorts, nil},
+		{`INSERT INTO ` + cohorts + ` (tenant_id, cohort, week, users)
+			SELECT first.tenant_id, first.cohort, (active.week - first.cohort) / 7, count(*)
+			FROM (
+				SELECT tenant_id, user_id, date_trunc('week', min(timestamp))::date AS cohort FROM ` + es.table + `
+				WHERE user_id <> '' GROUP BY 1, 2 HAVING min(timestamp) >= $1
+			) first
+			JOIN (
+				SELECT DISTINCT tenant_id, user_id, date_trunc('week', timestamp)::date AS week FROM ` + es.table + `
+				WHERE user_id <> '' AND timestamp >= $1
+			) active ON active.tenant_id = first.tenant_id AND active.user_id = first.user_id
+			GROUP BY 1, 2, 3`, []interface{}{from}},
+		{`INSERT INTO ` + state + ` (computed_at) VALUES ($1)
+			ON CONFLICT (id) DO UPDATE SET computed_at = EXCLUDED.computed_at`, []interface{}{started}},
+	}
+	for _, statement := range statements {
+		if _, err = tx.ExecContext(ctx, statement.sql, statement.args...); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	metrics.RecordCohortComputation(time.Since(started), err)
+	if err != nil {
+		return computedAt.Time.UTC(), fmt.Errorf("failed to compute cohorts: %w", err)
+	}
+	return started, nil
+}
+
+// Cohorts implements Cohorts
+func (es *EventStore) Cohorts(ctx context.Context, tenantID string) (CohortTable, error) {
+	var table CohortTable
+	var computedAt sql.NullTime
+	err := es.db.QueryRowContext(ctx, "SELECT computed_at FROM "+relatedTable(es.schema, es.name, "cohort_state")).Scan(&computedAt)
+	if err == sql.ErrNoRows {
+		return table, nil
+	}
+	if err != nil {
+		return table, fmt.Errorf("failed to read cohort state: %w", err)
+	}
+	table.ComputedAt = computedAt.Time.UTC()
+
+	rows, err := es.db.QueryContext(ctx, "SELECT cohort, week, users FROM "+relatedTable(es.schema, es.name, "cohorts")+
+		" WHERE tenant_id = $1 ORDER BY cohort, week", tenantID)
+	if err != nil {
+		return table, fmt.Errorf("failed to read cohorts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var week CohortWeek
+		if err := rows.Scan(&week.Cohort, &week.Week, &week.Users); err != nil {
+			return table, err
+		}
+		week.Cohort = week.Cohort.UTC()
+		table.Weeks = append(table.Weeks, week)
+	}
+	return table, rows.Err()
+}
+
+// weekStart is the start of the week containing t, on Monday in UTC, as PostgreSQL's date_trunc
+func weekStart(t time.Time) time.Time {
+	day := dayStart(t)
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
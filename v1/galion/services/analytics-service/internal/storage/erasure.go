@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// EraseUser implements Erasable in one transaction, so the events, rollups and sessions are erased
+// together or not at all; report views still list the user until they are next refreshed
+func (es *EventStore) EraseUser(ctx context.Context, tenantID string, userIDs []string, mode, pseudonym string) (Erasure, error) {
+	erasure := Erasure{Rows: make(map[string]int64)}
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return erasure, err
+	}
+	defer tx.Rollback()
+
+	// Found before the events are gone
+	rows, err := tx.QueryContext(ctx, `
+		SELECT url FROM `+es.manifest()+` WHERE (day, event_type) IN (
+			SELECT DISTINCT timestamp::date, event_type FROM `+es.table+` WHERE tenant_id = $1 AND user_id = ANY($2)
+		)
+		ORDER BY day, event_type, part
+	`, tenantID, pq.Array(userIDs))
+	if err != nil {
+		return erasure, fmt.Errorf("failed to find archived files: %w", err)
+	}
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			rows.Close()
+			return erasure, err
+		}
+		erasure.ArchivedFiles = append(erasure.ArchivedFiles, url)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return erasure, err
+	}
+
+	tables := []struct {
+		name     string
+		table    string
+		events   bool
+		sessions bool
+	}{
+		{es.schema + "." + es.name, es.table, true, false},
+		{es.schema + "." + es.name + "_rollup_hourly", relatedTable(es.schema, es.name, "rollup_hourly"), false, false},
+		{es.schema + "." + es.name + "_rollup_daily", relatedTable(es.schema, es.name, "rollup_daily"), false, false},
+		{es.schema + "." + es.name + "_sessions", relatedTable(es.schema, es.name, "sessions"), false, true},
+	}
+	ids := pq.Array(userIDs)
+	for _, t := range tables {
+		var result sql.Result
+		switch {
+		case mode != ErasureAnonymize:
+			result, err = tx.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE tenant_id = $1 AND user_id = ANY($2)", tenantID, ids)
+		case t.events:
+			result, err = tx.ExecContext(ctx, "UPDATE "+t.table+" SET user_id = $3, data = NULL WHERE tenant_id = $1 AND user_id = ANY($2)", tenantID, ids, pseudonym)
+		case t.sessions:
+			// Sessions under the user's raw and hashed IDs that started together merge into one
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO `+t.table+` (tenant_id, user_id, started_at, ended_at, events)
+				SELECT tenant_id, $3, started_at, max(ended_at), sum(events) FROM `+t.table+` WHERE tenant_id = $1 AND user_id = ANY($2)
+				GROUP BY 1, 3
+			`, tenantID, ids, pseudonym)
+			if err == nil {
+				result, err = tx.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE tenant_id = $1 AND user_id = ANY($2)", tenantID, ids)
+			}
+		default:
+			// Rollup rows are keyed by user, so the user's rows under each of its IDs, raw and hashed,
+			// are merged into one per bucket under the pseudonym, then deleted
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO `+t.table+` (tenant_id, bucket, event_type, service, user_id, events)
+				SELECT tenant_id, bucket, event_type, service, $3, sum(events) FROM `+t.table+` WHERE tenant_id = $1 AND user_id = ANY($2)
+				GROUP BY 1, 2, 3, 4
+			`, tenantID, ids, pseudonym)
+			if err == nil {
+				result, err = tx.ExecContext(ctx, "DELETE FROM "+t.table+" WHERE tenant_id = $1 AND user_id = ANY($2)", tenantID, ids)
+			}
+		}
+		if err != nil {
+			return erasure, fmt.Errorf("failed to erase the user's rows in %s: %w", t.name, err)
+		}
+		erasure.Rows[t.name], _ = result.RowsAffected()
+	}
+	return erasure, tx.Commit()
+}
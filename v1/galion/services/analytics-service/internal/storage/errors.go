@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+// transientClasses are PostgreSQL error classes that may succeed when retried
+var transientClasses = map[pq.ErrorClass]bool{
+	"08": true, // connection exception
+	"40": true, // transaction rollback: serialization failure, deadlock
+	"53": true, // insufficient resources: too many connections, disk full
+	"57": true, // operator intervention: shutdown, cannot connect now
+	"58": true, // system error: I/O error
+}
+
+// transientClickHouseCodes are ClickHouse error codes that may succeed when retried
+var transientClickHouseCodes = map[int]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	203: true, // NO_FREE_CONNECTION
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	285: true, // TOO_FEW_LIVE_REPLICAS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	999: true, // KEEPER_EXCEPTION
+}
+
+// IsTransient reports whether err may go away on its own, such as a dropped connection or a
+// restarting database, rather than being caused by the events themselves
+func IsTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientClasses[pqErr.Code.Class()]
+	}
+	var chErr *ClickHouseError
+	if errors.As(err, &chErr) {
+		// Proxies and load balancers in front of the server answer with these while it is down
+		return transientClickHouseCodes[chErr.Code] || chErr.Status == http.StatusBadGateway ||
+			chErr.Status == http.StatusServiceUnavailable || chErr.Status == http.StatusGatewayTimeout
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// layout is how an events table is laid out beyond its migrations: partitioned by month or made a
+// TimescaleDB hypertable, and indexed on where its events were read from
+type layout struct {
+	partitioned bool
+	hypertable  bool
+	tenantKeyed bool // partitioned, with tenant_id in its keys, as partitions by tenant need
+	indexed     bool // the event source index exists
+}
+
+// sourceKey is the columns of the table's event source index, and the column name it is named after;
+// hypertables and partitioned tables need the time column in it
+func (l layout) sourceKey() ([]string, string) {
+	switch {
+	case l.tenantKeyed:
+		return tenantSourceColumns, "event_source_tenant"
+	case l.hypertable || l.partitioned:
+		return timedSourceColumns, "event_source_time"
+	}
+	return sourceColumns, "event_source"
+}
+
+// layOut lays the migrated table out as configured: it is replaced with a partitioned table or
+// made a hypertable, keyed by tenant, and given its event source index. Each step locks the table
+// until done, so it is only taken by the migrate command and MIGRATE_ON_STARTUP
+func (m *Migrator) layOut(ctx context.Context) error {
+	_, name, _ := strings.Cut(m.table, ".")
+	es := &EventStore{
+		db:           m.db,
+		schema:       m.schema,
+		name:         name,
+		table:        pq.QuoteIdentifier(m.schema) + "." + pq.QuoteIdentifier(name),
+		partitioning: m.partitioning,
+	}
+
+	var l layout
+	if m.partitioning.Enabled {
+		if err := es.createPartitioned(m.schema, name, m.table); err != nil {
+			return err
+		}
+		keyed, err := es.tenantKeys(len(m.partitioning.Tenants) > 0)
+		if err != nil {
+			return err
+		}
+		l.partitioned, l.tenantKeyed = true, keyed
+	}
+	hypertable, err := es.setupTimescale(m.timescale, m.schema, name, m.table)
+	if err != nil {
+		return err
+	}
+	l.hypertable = hypertable
+
+	// The unique index makes redelivered events no-ops; events without a source have NULLs, which
+	// never conflict
+	key, source := l.sourceKey()
+	_, err = m.db.ExecContext(ctx, "CREATE UNIQUE INDEX IF NOT EXISTS "+indexName(m.table, source)+" ON "+es.table+"("+strings.Join(key, ", ")+")")
+	if err != nil {
+		return fmt.Errorf("failed to create the event source index of %s: %w", m.table, err)
+	}
+	return nil
+}
+
+// inspect reads how the table is laid out, changing nothing
+func (m *Migrator) inspect(ctx context.Context) (layout, error) {
+	_, name, _ := strings.Cut(m.table, ".")
+	var l layout
+	var kind sql.NullString
+	err := m.db.QueryRowContext(ctx, "SELECT relkind::text FROM pg_class WHERE oid = to_regclass($1)", pq.QuoteIdentifier(m.schema)+"."+pq.QuoteIdentifier(name)).Scan(&kind)
+	if err != nil && err != sql.ErrNoRows {
+		return l, fmt.Errorf("failed to look up %s: %w", m.table, err)
+	}
+	l.partitioned = kind.String == "p"
+
+	var timescale bool
+	if err := m.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')").Scan(&timescale); err != nil {
+		return l, fmt.Errorf("failed to look up the timescaledb extension: %w", err)
+	}
+	if timescale {
+		err := m.db.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM timescaledb_information.hypertables
+			WHERE hypertable_schema = $1 AND hypertable_name = $2)
+		`, m.schema, name).Scan(&l.hypertable)
+		if err != nil {
+			return l, fmt.Errorf("failed to look up hypertables: %w", err)
+		}
+	}
+
+	indexed := func(column string) (bool, error) {
+		var exists bool
+		err := m.db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", pq.QuoteIdentifier(m.schema)+"."+indexName(m.table, column)).Scan(&exists)
+		if err != nil {
+			return false, fmt.Errorf("failed to look up the event source index of %s: %w", m.table, err)
+		}
+		return exists, nil
+	}
+	if l.partitioned {
+		if l.tenantKeyed, err = indexed("event_source_tenant"); err != nil {
+			return l, err
+		}
+	}
+	_, source := l.sourceKey()
+	l.indexed, err = indexed(source)
+	return l, err
+}
+
+// checkLayout checks that the table is laid out as configured, returning how it is
+// With TimescaleAuto a plain table is accepted, as the extension may not be available
+func (m *Migrator) checkLayout(ctx context.Context) (layout, error) {
+	l, err := m.inspect(ctx)
+	if err != nil {
+		return l, err
+	}
+	var problem string
+	switch {
+	case l.partitioned && !m.partitioning.Enabled:
+		problem = "is partitioned by month, but PARTITION_BY_MONTH is off"
+	case !l.partitioned && m.partitioning.Enabled:
+		problem = "isn't partitioned by month"
+	case l.hypertable && (m.timescale.Mode == "" || m.timescale.Mode == TimescaleOff):
+		problem = "is a TimescaleDB hypertable, but TIMESCALE is off"
+	case !l.hypertable && m.timescale.Mode == TimescaleOn:
+		problem = "isn't a TimescaleDB hypertable"
+	case !l.tenantKeyed && len(m.partitioning.Tenants) > 0:
+		problem = "isn't keyed by tenant, as PARTITION_TENANTS needs"
+	case !l.indexed:
+		problem = "has no event source index"
+	}
+	if problem != "" {
+		return l, fmt.Errorf("%s %s; run `analytics migrate` or set MIGRATE_ON_STARTUP", m.table, problem)
+	}
+	return l, nil
+}
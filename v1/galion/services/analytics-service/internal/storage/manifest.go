@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// manifest is the quoted table listing the files events were archived to
+func (es *EventStore) manifest() string {
+	return relatedTable(es.schema, es.name, "archive")
+}
+
+// ArchivedThrough implements Archivable
+func (es *EventStore) ArchivedThrough(ctx context.Context) (time.Time, error) {
+	var day sql.NullTime
+	if err := es.db.QueryRowContext(ctx, "SELECT max(day) FROM "+es.manifest()).Scan(&day); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read archive manifest: %w", err)
+	}
+	if day.Valid {
+		return dayStart(day.Time).AddDate(0, 0, 1), nil
+	}
+	var oldest sql.NullTime
+	if err := es.db.QueryRowContext(ctx, "SELECT min(timestamp) FROM "+es.table).Scan(&oldest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to find the oldest event: %w", err)
+	}
+	if !oldest.Valid {
+		return time.Time{}, nil
+	}
+	return dayStart(oldest.Time), nil
+}
+
+// ScanDay implements Archivable
+func (es *EventStore) ScanDay(ctx context.Context, day time.Time, fn func(StoredEvent) error) error {
+	day = dayStart(day)
+	rows, err := es.db.QueryContext(ctx, "SELECT id, "+strings.Join(eventColumnNames, ", ")+", created_at FROM "+es.table+
+		" WHERE timestamp >= $1 AND timestamp < $2 ORDER BY event_type, timestamp, id", day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return fmt.Errorf("failed to read events of %s: %w", day.Format(time.DateOnly), err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		event, err := scanStoredEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// RecordArchive implements Archivable
+func (es *EventStore) RecordArchive(ctx context.Context, files []ArchivedFile) error {
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, f := range files {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO `+es.manifest()+` (day, event_type, part, url, events, bytes, first_event, last_event)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (day, event_type, part) DO UPDATE SET url = EXCLUDED.url, events = EXCLUDED.events,
+				bytes = EXCLUDED.bytes, first_event = EXCLUDED.first_event, last_event = EXCLUDED.last_event,
+				archived_at = CURRENT_TIMESTAMP
+		`, f.Day.Format(time.DateOnly), f.EventType, f.Part, f.URL, f.Events, f.Bytes, f.First.UTC(), f.Last.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record archive of %s %s events: %w", f.Day.Format(time.DateOnly), f.EventType, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// dayStart is the start of t's UTC day
+func dayStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/lib/pq"
+)
+
+// Migrations are SQL files named as golang-migrate names them, version_title.up.sql, templates given
+// the table they apply to, since events may be stored in any table; the version table has
+// golang-migrate's layout, a single row of version and dirty
+// golang-migrate itself isn't used: it isn't among the service's vendored modules, and its sources
+// can't fill in the table names, which any events table needs
+//
+//go:embed migrations/*.up.sql
+var migrationFiles embed.FS
+
+// migrationName parses a migration's file name
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.up\.sql$`)
+
+// migration is one step of the PostgreSQL schema
+type migration struct {
+	version int
+	name    string
+	text    string
+}
+
+// migrations are the embedded migrations in order
+var migrations = loadMigrations()
+
+func loadMigrations() []migration {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		panic(err)
+	}
+	var loaded []migration
+	for _, entry := range entries {
+		parts := migrationName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			panic("migration " + entry.Name() + " isn't named version_title.up.sql")
+		}
+		version, _ := strconv.Atoi(parts[1])
+		text, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		mig := migration{version: version, name: parts[2], text: string(text)}
+		if _, err := mig.template(""); err != nil {
+			panic(err)
+		}
+		loaded = append(loaded, mig)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].version < loaded[j].version })
+	for i := 1; i < len(loaded); i++ {
+		if loaded[i].version == loaded[i-1].version {
+			panic(fmt.Sprintf("migration version %d is used twice", loaded[i].version))
+		}
+	}
+	return loaded
+}
+
+// SchemaVersion is the version of the schema this build expects, that of its latest migration
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// Migrator applies the migrations to a PostgreSQL events table, and lays it out as configured
+type Migrator struct {
+	db           *sql.DB
+	schema       string
+	table        string // as configured, schema-qualified
+	versions     string // quoted version table
+	partitioning Partitioning
+	timescale    Timescale
+	closeDB      bool
+}
+
+// NewMigrator connects to the database of cfg to migrate cfg.Table, and lay it out as cfg.Partitioning
+// and cfg.Timescale say
+func NewMigrator(cfg SinkConfig) (*Migrator, error) {
+	if cfg.Table == "" {
+		cfg.Table = DefaultTable
+	}
+	if err := ValidateTable(cfg.Table); err != nil {
+		return nil, err
+	}
+	db, err := openPostgres(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	m := newMigrator(db, cfg)
+	m.closeDB = true
+	return m, nil
+}
+
+// newMigrator migrates cfg.Table, a valid schema-qualified name, over db
+func newMigrator(db *sql.DB, cfg SinkConfig) *Migrator {
+	schema, name, _ := strings.Cut(cfg.Table, ".")
+	return &Migrator{
+		db:           db,
+		schema:       schema,
+		table:        cfg.Table,
+		versions:     pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name+"_schema_migrations"),
+		partitioning: cfg.Partitioning,
+		timescale:    cfg.Timescale,
+	}
+}
+
+// Close closes the connection NewMigrator opened
+func (m *Migrator) Close() error {
+	if m.closeDB {
+		return m.db.Close()
+	}
+	return nil
+}
+
+// Version returns the table's schema version, 0 before any migration, and whether the migration
+// to it failed partway
+func (m *Migrator) Version(ctx context.Context) (int, bool, error) {
+	return m.version(ctx, m.db)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (m *Migrator) version(ctx context.Context, q queryer) (int, bool, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", m.versions).Scan(&exists); err != nil {
+		return 0, false, fmt.Errorf("failed to look up the schema version of %s: %w", m.table, err)
+	}
+	if !exists {
+		return 0, false, nil
+	}
+	var version int
+	var dirty bool
+	err := q.QueryRowContext(ctx, "SELECT version, dirty FROM "+m.versions+" LIMIT 1").Scan(&version, &dirty)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, false, fmt.Errorf("failed to read the schema version of %s: %w", m.table, err)
+	}
+	return version, dirty, nil
+}
+
+// Up applies the migrations after the table's version, each in a transaction of its own with the
+// new version, so a failed one changes nothing, then lays the table out as configured; it returns
+// the migrations applied, as version_title
+// Instances starting together take turns, and the later ones find nothing left to do
+func (m *Migrator) Up(ctx context.Context) ([]string, error) {
+	var applied []string
+	for {
+		name, err := m.step(ctx)
+		if err != nil {
+			return applied, err
+		}
+		if name == "" {
+			return applied, m.layOut(ctx)
+		}
+		applied = append(applied, name)
+	}
+}
+
+// step applies the migration after the table's version, returning its name, or "" if there is none
+func (m *Migrator) step(ctx context.Context) (string, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if err := m.lock(ctx, tx); err != nil {
+		return "", err
+	}
+
+	version, dirty, err := m.version(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+	if dirty {
+		return "", m.dirtyError(version)
+	}
+	var next *migration
+	for i := range migrations {
+		if migrations[i].version > version {
+			next = &migrations[i]
+			break
+		}
+	}
+	if next == nil {
+		return "", nil
+	}
+
+	statements, err := m.render(*next)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%d_%s", next.version, next.name)
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return "", fmt.Errorf("failed to apply migration %s to %s: %w", name, m.table, err)
+	}
+	if err := m.setVersion(ctx, tx, next.version); err != nil {
+		return "", err
+	}
+	return name, tx.Commit()
+}
+
+// Force sets the table's version, clearing a failed migration once it has been completed or undone
+// by hand; nothing is applied
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.lock(ctx, tx); err != nil {
+		return err
+	}
+	if err := m.setVersion(ctx, tx, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lock makes instances migrate the table one at a time, until tx ends, then creates the version
+// table and the schema it is in if they don't exist
+func (m *Migrator) lock(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", m.versions); err != nil {
+		return fmt.Errorf("failed to lock %s for migrating: %w", m.table, err)
+	}
+	statements := []string{
+		"CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(m.schema),
+		"CREATE TABLE IF NOT EXISTS " + m.versions + " (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)",
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create the version table of %s: %w", m.table, err)
+		}
+	}
+	return nil
+}
+
+// setVersion replaces the version row
+func (m *Migrator) setVersion(ctx context.Context, tx *sql.Tx, version int) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+m.versions); err != nil {
+		return fmt.Errorf("failed to record the schema version of %s: %w", m.table, err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO "+m.versions+" (version, dirty) VALUES ($1, false)", version); err != nil {
+		return fmt.Errorf("failed to record the schema version of %s: %w", m.table, err)
+	}
+	return nil
+}
+
+// Verify checks that the table is at the version this build expects, and laid out as configured
+func (m *Migrator) Verify(ctx context.Context) error {
+	_, err := m.verify(ctx)
+	return err
+}
+
+// verify is Verify, returning how the table is laid out
+func (m *Migrator) verify(ctx context.Context) (layout, error) {
+	version, dirty, err := m.Version(ctx)
+	switch {
+	case err != nil:
+		return layout{}, err
+	case dirty:
+		return layout{}, m.dirtyError(version)
+	case version < SchemaVersion():
+		return layout{}, fmt.Errorf("%s is at schema version %d, but this build needs %d; run `analytics migrate` or set MIGRATE_ON_STARTUP", m.table, version, SchemaVersion())
+	case version > SchemaVersion():
+		return layout{}, fmt.Errorf("%s is at schema version %d, newer than this build's %d; deploy a build that knows it", m.table, version, SchemaVersion())
+	}
+	return m.checkLayout(ctx)
+}
+
+func (m *Migrator) dirtyError(version int) error {
+	return fmt.Errorf("migration %d of %s failed partway; finish or undo it by hand, then run `analytics migrate force %d` with the version it is at", version, m.table, version)
+}
+
+// template parses the migration's SQL for table
+func (mig migration) template(table string) (*template.Template, error) {
+	schema, name, _ := strings.Cut(table, ".")
+	return template.New(mig.name).Option("missingkey=error").Funcs(template.FuncMap{
+		"indexName": func(column string) string { return indexName(table, column) },
+		"related":   func(suffix string) string { return relatedTable(schema, name, suffix) },
+		// Constraints of related tables are named after them, as in events_rollup_hourly_pkey
+		"constraint": func(suffix string) string { return pq.QuoteIdentifier(name + "_" + suffix) },
+	}).Parse(mig.text)
+}
+
+// render fills in a migration's SQL for the table
+func (m *Migrator) render(mig migration) (string, error) {
+	_, name, _ := strings.Cut(m.table, ".")
+	tmpl, err := mig.template(m.table)
+	if err != nil {
+		return "", err
+	}
+	var statements strings.Builder
+	err = tmpl.Execute(&statements, struct{ Table, Manifest string }{
+		Table:    pq.QuoteIdentifier(m.schema) + "." + pq.QuoteIdentifier(name),
+		Manifest: relatedTable(m.schema, name, "archive"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render migration %d_%s: %w", mig.version, mig.name, err)
+	}
+	return statements.String(), nil
+}
+
+// relatedTable is the quoted table named after the events table name with suffix, in its schema
+func relatedTable(schema, name, suffix string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name+"_"+suffix)
+}
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS {{.Table}} (
+	id SERIAL PRIMARY KEY,
+	event_type VARCHAR(100) NOT NULL,
+	user_id VARCHAR(100) NOT NULL,
+	service VARCHAR(50) NOT NULL,
+	timestamp TIMESTAMP NOT NULL,
+	data JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
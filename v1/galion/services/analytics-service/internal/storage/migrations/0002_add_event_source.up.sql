@@ -0,0 +1,5 @@
+-- Where each event was read from; events stored before these columns existed have NULLs
+ALTER TABLE {{.Table}}
+	ADD COLUMN IF NOT EXISTS topic VARCHAR(255),
+	ADD COLUMN IF NOT EXISTS kafka_partition INTEGER,
+	ADD COLUMN IF NOT EXISTS kafka_offset BIGINT;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS {{indexName "event_type"}} ON {{.Table}}(event_type);
+CREATE INDEX IF NOT EXISTS {{indexName "user_id"}} ON {{.Table}}(user_id);
+CREATE INDEX IF NOT EXISTS {{indexName "timestamp"}} ON {{.Table}}(timestamp);
@@ -0,0 +1,13 @@
+-- Files events were archived to, listed a day at a time once all of a day's files are uploaded
+CREATE TABLE IF NOT EXISTS {{.Manifest}} (
+	day DATE NOT NULL,
+	event_type VARCHAR(100) NOT NULL,
+	part INTEGER NOT NULL,
+	url TEXT NOT NULL,
+	events BIGINT NOT NULL,
+	bytes BIGINT NOT NULL,
+	first_event TIMESTAMP NOT NULL,
+	last_event TIMESTAMP NOT NULL,
+	archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (day, event_type, part)
+);
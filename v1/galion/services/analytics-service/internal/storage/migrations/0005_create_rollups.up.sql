@@ -0,0 +1,24 @@
+-- Events per type, service and user in each hour and day, recomputed as hours complete
+CREATE TABLE IF NOT EXISTS {{related "rollup_hourly"}} (
+	bucket TIMESTAMP NOT NULL,
+	event_type VARCHAR(100) NOT NULL,
+	service VARCHAR(50) NOT NULL,
+	user_id VARCHAR(100) NOT NULL,
+	events BIGINT NOT NULL,
+	PRIMARY KEY (bucket, event_type, service, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS {{related "rollup_daily"}} (
+	bucket TIMESTAMP NOT NULL,
+	event_type VARCHAR(100) NOT NULL,
+	service VARCHAR(50) NOT NULL,
+	user_id VARCHAR(100) NOT NULL,
+	events BIGINT NOT NULL,
+	PRIMARY KEY (bucket, event_type, service, user_id)
+);
+
+-- The start of the first hour not yet rolled up, in a single row
+CREATE TABLE IF NOT EXISTS {{related "rollup_state"}} (
+	id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+	through TIMESTAMP NOT NULL
+);
@@ -0,0 +1,33 @@
+-- Reports dashboards ask for most, refreshed on a schedule; each has a unique index so it can be
+-- refreshed concurrently, without blocking reads, and stays empty until its first refresh
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{related "report_events_by_type_24h"}} AS
+	SELECT event_type, count(*) AS events, count(DISTINCT user_id) AS users
+	FROM {{.Table}}
+	WHERE timestamp >= (now() AT TIME ZONE 'UTC') - INTERVAL '24 hours'
+	GROUP BY event_type
+	WITH NO DATA;
+CREATE UNIQUE INDEX IF NOT EXISTS {{indexName "report_events_by_type_24h"}} ON {{related "report_events_by_type_24h"}}(event_type);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{related "report_active_users_daily"}} AS
+	SELECT date_trunc('day', timestamp)::date AS day, count(DISTINCT user_id) AS users, count(*) AS events
+	FROM {{.Table}}
+	WHERE timestamp >= date_trunc('day', now() AT TIME ZONE 'UTC') - INTERVAL '89 days'
+	GROUP BY 1
+	WITH NO DATA;
+CREATE UNIQUE INDEX IF NOT EXISTS {{indexName "report_active_users_daily"}} ON {{related "report_active_users_daily"}}(day);
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS {{related "report_top_users"}} AS
+	SELECT user_id, count(*) AS events, max(timestamp) AS last_seen
+	FROM {{.Table}}
+	WHERE timestamp >= (now() AT TIME ZONE 'UTC') - INTERVAL '30 days'
+	GROUP BY user_id
+	ORDER BY events DESC
+	LIMIT 1000
+	WITH NO DATA;
+CREATE UNIQUE INDEX IF NOT EXISTS {{indexName "report_top_users"}} ON {{related "report_top_users"}}(user_id);
+
+-- When each report was last refreshed, by any instance
+CREATE TABLE IF NOT EXISTS {{related "report_state"}} (
+	report VARCHAR(100) PRIMARY KEY,
+	refreshed_at TIMESTAMP NOT NULL
+);
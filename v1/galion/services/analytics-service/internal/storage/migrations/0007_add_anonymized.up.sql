@@ -0,0 +1,6 @@
+-- When each event's user_id was hashed and its personal data removed; NULL until then
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP;
+
+-- Whether each rollup row's user_id is hashed, so it is hashed only once
+ALTER TABLE {{related "rollup_hourly"}} ADD COLUMN IF NOT EXISTS anonymized BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE {{related "rollup_daily"}} ADD COLUMN IF NOT EXISTS anonymized BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,47 @@
+-- The customer each event belongs to; every query filters by it, so tenants never see each other's
+-- events. Events stored before tenants have none
+ALTER TABLE {{.Table}} ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(100) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS {{indexName "tenant_id"}} ON {{.Table}}(tenant_id, timestamp DESC);
+
+-- Rollups count each tenant's events apart
+ALTER TABLE {{related "rollup_hourly"}}
+	ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(100) NOT NULL DEFAULT '',
+	DROP CONSTRAINT {{constraint "rollup_hourly_pkey"}},
+	ADD PRIMARY KEY (tenant_id, bucket, event_type, service, user_id);
+ALTER TABLE {{related "rollup_daily"}}
+	ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(100) NOT NULL DEFAULT '',
+	DROP CONSTRAINT {{constraint "rollup_daily_pkey"}},
+	ADD PRIMARY KEY (tenant_id, bucket, event_type, service, user_id);
+
+-- Reports are computed per tenant, so the views are created again, empty until their next refresh
+DROP MATERIALIZED VIEW IF EXISTS {{related "report_events_by_type_24h"}};
+CREATE MATERIALIZED VIEW {{related "report_events_by_type_24h"}} AS
+	SELECT tenant_id, event_type, count(*) AS events, count(DISTINCT user_id) AS users
+	FROM {{.Table}}
+	WHERE timestamp >= (now() AT TIME ZONE 'UTC') - INTERVAL '24 hours'
+	GROUP BY tenant_id, event_type
+	WITH NO DATA;
+CREATE UNIQUE INDEX {{indexName "report_events_by_type_24h"}} ON {{related "report_events_by_type_24h"}}(tenant_id, event_type);
+
+DROP MATERIALIZED VIEW IF EXISTS {{related "report_active_users_daily"}};
+CREATE MATERIALIZED VIEW {{related "report_active_users_daily"}} AS
+	SELECT tenant_id, date_trunc('day', timestamp)::date AS day, count(DISTINCT user_id) AS users, count(*) AS events
+	FROM {{.Table}}
+	WHERE timestamp >= date_trunc('day', now() AT TIME ZONE 'UTC') - INTERVAL '89 days'
+	GROUP BY 1, 2
+	WITH NO DATA;
+CREATE UNIQUE INDEX {{indexName "report_active_users_daily"}} ON {{related "report_active_users_daily"}}(tenant_id, day);
+
+-- The 1000 users with most events of each tenant
+DROP MATERIALIZED VIEW IF EXISTS {{related "report_top_users"}};
+CREATE MATERIALIZED VIEW {{related "report_top_users"}} AS
+	SELECT tenant_id, user_id, events, last_seen FROM (
+		SELECT tenant_id, user_id, count(*) AS events, max(timestamp) AS last_seen,
+			row_number() OVER (PARTITION BY tenant_id ORDER BY count(*) DESC, user_id) AS rank
+		FROM {{.Table}}
+		WHERE timestamp >= (now() AT TIME ZONE 'UTC') - INTERVAL '30 days'
+		GROUP BY tenant_id, user_id
+	) ranked
+	WHERE rank <= 1000
+	WITH NO DATA;
+CREATE UNIQUE INDEX {{indexName "report_top_users"}} ON {{related "report_top_users"}}(tenant_id, user_id);
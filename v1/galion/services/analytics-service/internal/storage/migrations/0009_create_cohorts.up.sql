@@ -0,0 +1,15 @@
+-- Users of each tenant by the week they were first seen, and how many of them were active in each
+-- week since; week 0 is the cohort's own week, so its users are the cohort's size
+CREATE TABLE IF NOT EXISTS {{related "cohorts"}} (
+	tenant_id VARCHAR(100) NOT NULL,
+	cohort DATE NOT NULL,
+	week INTEGER NOT NULL,
+	users BIGINT NOT NULL,
+	PRIMARY KEY (tenant_id, cohort, week)
+);
+
+-- When the cohorts were last computed, by any instance, in a single row
+CREATE TABLE IF NOT EXISTS {{related "cohort_state"}} (
+	id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+	computed_at TIMESTAMP NOT NULL
+);
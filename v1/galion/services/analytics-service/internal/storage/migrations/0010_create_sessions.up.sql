@@ -0,0 +1,17 @@
+-- Each user's visits: runs of events with no gap longer than the session gap between them
+CREATE TABLE IF NOT EXISTS {{related "sessions"}} (
+	tenant_id VARCHAR(100) NOT NULL,
+	user_id VARCHAR(100) NOT NULL,
+	started_at TIMESTAMP NOT NULL,
+	ended_at TIMESTAMP NOT NULL,
+	events BIGINT NOT NULL,
+	PRIMARY KEY (tenant_id, user_id, started_at)
+);
+CREATE INDEX IF NOT EXISTS {{indexName "sessions_started_at"}} ON {{related "sessions"}}(tenant_id, started_at);
+CREATE INDEX IF NOT EXISTS {{indexName "sessions_ended_at"}} ON {{related "sessions"}}(ended_at);
+
+-- The time before which every event has been stitched into sessions, in a single row
+CREATE TABLE IF NOT EXISTS {{related "session_state"}} (
+	id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+	through TIMESTAMP NOT NULL
+);
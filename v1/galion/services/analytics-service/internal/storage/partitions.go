@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Partitioning sets whether the PostgreSQL events table is range-partitioned by month of timestamp
+type Partitioning struct {
+	Enabled bool
+	Ahead   int // months after the current one that always have a partition
+	Keep    int // partitions wholly older than this many months are detached; 0 keeps them all
+	// Tenants get a partition of their own in each month's partition created from now on, which is
+	// list-partitioned by tenant_id, with a default partition for the other tenants
+	Tenants []string
+}
+
+// partitionBound parses the bounds pg_get_expr prints for a range partition; DEFAULT doesn't match
+var partitionBound = regexp.MustCompile(`FROM \((?:'([^']*)'|MINVALUE)\) TO \((?:'([^']*)'|MAXVALUE)\)`)
+
+// partitionTime is how timestamp bounds are written and printed
+const partitionTime = "2006-01-02 15:04:05"
+
+// partition is an attached partition of the events table; zero bounds are MINVALUE and MAXVALUE
+type partition struct {
+	name     string
+	from, to time.Time
+	byTenant bool // partitioned by tenant in turn
+}
+
+// covers reports whether t falls within the partition
+func (p partition) covers(t time.Time) bool {
+	return (p.from.IsZero() || !t.Before(p.from)) && (p.to.IsZero() || t.Before(p.to))
+}
+
+// createPartitioned replaces the plain table with one partitioned by month, with a default partition
+// for events outside every month's partition, and the plain table's indexes other than unique ones;
+// a plain table with events is kept as a partition of everything up to the month after its latest
+// event, with its indexes rebuilt, which locks it until done
+func (es *EventStore) createPartitioned(schema, name, table string) error {
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := es.lockPartitions(tx); err != nil {
+		return err
+	}
+
+	var kind sql.NullString
+	err = tx.QueryRow("SELECT relkind::text FROM pg_class WHERE oid = to_regclass($1)", es.table).Scan(&kind)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up %s: %w", table, err)
+	}
+	if kind.String == "p" {
+		return nil
+	}
+
+	legacy := pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name+"_legacy")
+	var indexes, views []string
+	if kind.Valid {
+		// Indexes are named after the table, so the old ones make way for the new table's, which are
+		// created from their definitions; those still name the table, which the new one takes over
+		rows, err := tx.Query(`
+			SELECT c.relname, pg_get_indexdef(i.indexrelid) FROM pg_index i JOIN pg_class c ON c.oid = i.indexrelid
+			WHERE i.indrelid = $1::regclass AND NOT i.indisunique
+		`, es.table)
+		if err != nil {
+			return fmt.Errorf("failed to list indexes of %s: %w", table, err)
+		}
+		var statements []string
+		for rows.Next() {
+			var index, definition string
+			if err := rows.Scan(&index, &definition); err != nil {
+				rows.Close()
+				return err
+			}
+			statements = append(statements, "DROP INDEX "+pq.QuoteIdentifier(schema)+"."+pq.QuoteIdentifier(index))
+			indexes = append(indexes, definition)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		statements = append(statements, "DROP INDEX IF EXISTS "+pq.QuoteIdentifier(schema)+"."+indexName(table, "event_source"))
+
+		// Materialized views over the table, such as the reports', would stay with the old one, so
+		// they are recreated over the new table, empty until their next refresh
+		dropViews, createViews, err := dependentViews(tx, es.table)
+		if err != nil {
+			return fmt.Errorf("failed to list views of %s: %w", table, err)
+		}
+		statements = append(dropViews, statements...)
+		views = createViews
+
+		var empty bool
+		if err := tx.QueryRow("SELECT NOT EXISTS (SELECT 1 FROM " + es.table + ")").Scan(&empty); err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		if empty {
+			statements = append(dropViews, "DROP TABLE "+es.table)
+			kind.Valid = false
+		} else {
+			statements = append(statements, "ALTER TABLE "+es.table+" RENAME TO "+pq.QuoteIdentifier(name+"_legacy"))
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to move %s aside: %w", table, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		CREATE TABLE ` + es.table + ` (
+			id SERIAL,
+			event_type VARCHAR(100) NOT NULL,
+			user_id VARCHAR(100) NOT NULL,
+			service VARCHAR(50) NOT NULL,
+			timestamp TIMESTAMP NOT NULL,
+			data JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			topic VARCHAR(255),
+			kafka_partition INTEGER,
+			kafka_offset BIGINT,
+			anonymized_at TIMESTAMP,
+			tenant_id VARCHAR(100) NOT NULL DEFAULT '',
+			PRIMARY KEY (id, timestamp)
+		) PARTITION BY RANGE (timestamp)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create partitioned %s table: %w", table, err)
+	}
+	_, err = tx.Exec("CREATE TABLE " + defaultPartition(schema, name) + " PARTITION OF " + es.table + " DEFAULT")
+	if err != nil {
+		return fmt.Errorf("failed to create default partition of %s: %w", table, err)
+	}
+	for _, index := range indexes {
+		if _, err := tx.Exec(index); err != nil {
+			return fmt.Errorf("failed to index partitioned %s table: %w", table, err)
+		}
+	}
+	for _, view := range views {
+		if _, err := tx.Exec(view); err != nil {
+			return fmt.Errorf("failed to recreate a view of %s: %w", table, err)
+		}
+	}
+
+	if kind.Valid {
+		var latest sql.NullTime
+		var lastID sql.NullInt64
+		if err := tx.QueryRow("SELECT max(timestamp), max(id) FROM "+legacy).Scan(&latest, &lastID); err != nil {
+			return fmt.Errorf("failed to read %s_legacy: %w", table, err)
+		}
+		end := time.Now().UTC()
+		if latest.Valid && latest.Time.After(end) {
+			end = latest.Time
+		}
+		_, err = tx.Exec("ALTER TABLE " + es.table + " ATTACH PARTITION " + legacy + " FOR VALUES FROM (MINVALUE) TO ('" + monthStart(end).AddDate(0, 1, 0).Format(partitionTime) + "')")
+		if err != nil {
+			return fmt.Errorf("failed to attach %s_legacy: %w", table, err)
+		}
+		// New rows continue the old table's IDs
+		if lastID.Valid {
+			if _, err := tx.Exec("SELECT setval(pg_get_serial_sequence($1, 'id'), $2)", es.table, lastID.Int64); err != nil {
+				return fmt.Errorf("failed to continue IDs of %s: %w", table, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// dependentViews returns the statements dropping the materialized views that read table, and those
+// creating them again, with their indexes, once the table has been replaced
+func dependentViews(tx *sql.Tx, table string) (drop, create []string, err error) {
+	rows, err := tx.Query(`
+		SELECT DISTINCT v.oid, v.oid::regclass::text, pg_get_viewdef(v.oid)
+		FROM pg_depend d JOIN pg_rewrite r ON r.oid = d.objid JOIN pg_class v ON v.oid = r.ev_class
+		WHERE d.classid = 'pg_rewrite'::regclass AND d.refobjid = $1::regclass AND v.relkind = 'm'
+	`, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	var oids []int64
+	for rows.Next() {
+		var oid int64
+		var view, definition string
+		if err := rows.Scan(&oid, &view, &definition); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		oids = append(oids, oid)
+		drop = append(drop, "DROP MATERIALIZED VIEW "+view)
+		create = append(create, "CREATE MATERIALIZED VIEW "+view+" AS "+strings.TrimSuffix(definition, ";")+" WITH NO DATA")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	for _, oid := range oids {
+		rows, err := tx.Query("SELECT pg_get_indexdef(indexrelid) FROM pg_index WHERE indrelid = $1", oid)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var definition string
+			if err := rows.Scan(&definition); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			create = append(create, definition)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return drop, create, nil
+}
+
+// ManagePartitions creates the partitions for this month and the configured months ahead, moving
+// matching events out of the default partition, and detaches those past the retention, which are
+// kept as tables of their own to be archived or dropped; it returns the tables created and detached
+func (es *EventStore) ManagePartitions(ctx context.Context) (created, detached []string, err error) {
+	if !es.partitioning.Enabled {
+		return nil, nil, nil
+	}
+	partitions, err := es.partitions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := monthStart(time.Now().UTC())
+	for i := 0; i <= es.partitioning.Ahead; i++ {
+		from := now.AddDate(0, i, 0)
+		covered := false
+		for _, p := range partitions {
+			if !p.covers(from) {
+				continue
+			}
+			covered = true
+			// Tenants added since the month's partition was created get partitions of their own
+			if p.byTenant && len(es.partitioning.Tenants) > 0 {
+				names, err := es.addTenants(ctx, p.name)
+				created = append(created, names...)
+				if err != nil {
+					return created, detached, err
+				}
+			}
+		}
+		if covered {
+			continue
+		}
+		name, err := es.createMonth(ctx, from)
+		if err != nil {
+			return created, detached, err
+		}
+		if name != "" {
+			created = append(created, name)
+		}
+	}
+
+	if es.partitioning.Keep > 0 {
+		cutoff := now.AddDate(0, -es.partitioning.Keep, 0)
+		for _, p := range partitions {
+			if p.to.IsZero() || p.to.After(cutoff) {
+				continue
+			}
+			name, _, err := es.detach(ctx, p.name, false)
+			if err != nil {
+				return created, detached, err
+			}
+			if name != "" {
+				detached = append(detached, name)
+			}
+		}
+	}
+	return created, detached, nil
+}
+
+// createMonth creates and attaches the partition of the month starting at from, returning its name,
+// or nothing if another instance just did; with Partitioning.Tenants it is partitioned by tenant
+// Events already in the default partition for that month are moved, as attaching would otherwise fail
+func (es *EventStore) createMonth(ctx context.Context, from time.Time) (string, error) {
+	to := from.AddDate(0, 1, 0)
+	name := es.name + "_p" + from.Format("200601")
+	table := pq.QuoteIdentifier(es.schema) + "." + pq.QuoteIdentifier(name)
+
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if err := es.lockPartitions(tx); err != nil {
+		return "", err
+	}
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil || exists {
+		return "", err
+	}
+
+	type statement struct {
+		query string
+		args  []interface{}
+	}
+	statements := []statement{{query: "CREATE TABLE " + table + " (LIKE " + es.table + " INCLUDING DEFAULTS)"}}
+	if len(es.partitioning.Tenants) > 0 {
+		// Events of tenants without a partition of their own, including those moved in below
+		statements[0].query += " PARTITION BY LIST (tenant_id)"
+		statements = append(statements, statement{query: "CREATE TABLE " + defaultPartition(es.schema, name) + " PARTITION OF " + table + " DEFAULT"})
+	}
+	statements = append(statements,
+		statement{
+			query: "WITH moved AS (DELETE FROM " + defaultPartition(es.schema, es.name) + " WHERE timestamp >= $1 AND timestamp < $2 RETURNING *) INSERT INTO " + table + " SELECT * FROM moved",
+			args:  []interface{}{from, to},
+		},
+		statement{query: "ALTER TABLE " + es.table + " ATTACH PARTITION " + table + " FOR VALUES FROM ('" + from.Format(partitionTime) + "') TO ('" + to.Format(partitionTime) + "')"},
+	)
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return "", fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+	}
+	if len(es.partitioning.Tenants) > 0 {
+		if _, err := es.tenantPartitions(ctx, tx, name); err != nil {
+			return "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to create partition %s: %w", name, err)
+	}
+	return es.schema + "." + name, nil
+}
+
+// addTenants gives the configured tenants without one a partition of their own in the month
+// partition named month, returning the partitions created
+func (es *EventStore) addTenants(ctx context.Context, month string) ([]string, error) {
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if err := es.lockPartitions(tx); err != nil {
+		return nil, err
+	}
+	created, err := es.tenantPartitions(ctx, tx, month)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to partition %s by tenant: %w", month, err)
+	}
+	return created, nil
+}
+
+// tenantPartitions creates and attaches the partitions of the configured tenants missing from the
+// month partition named month, moving their events out of the month's default partition, and
+// returns their names
+func (es *EventStore) tenantPartitions(ctx context.Context, tx *sql.Tx, month string) ([]string, error) {
+	var created []string
+	for _, tenant := range es.partitioning.Tenants {
+		name := month + "_" + tenant
+		table := pq.QuoteIdentifier(es.schema) + "." + pq.QuoteIdentifier(name)
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return created, err
+		}
+		if exists {
+			continue
+		}
+		statements := []struct {
+			query string
+			args  []interface{}
+		}{
+			{query: "CREATE TABLE " + table + " (LIKE " + es.table + " INCLUDING DEFAULTS)"},
+			{
+				query: "WITH moved AS (DELETE FROM " + defaultPartition(es.schema, month) + " WHERE tenant_id = $1 RETURNING *) INSERT INTO " + table + " SELECT * FROM moved",
+				args:  []interface{}{tenant},
+			},
+			{query: "ALTER TABLE " + pq.QuoteIdentifier(es.schema) + "." + pq.QuoteIdentifier(month) + " ATTACH PARTITION " + table + " FOR VALUES IN (" + pq.QuoteLiteral(tenant) + ")"},
+		}
+		for _, statement := range statements {
+			if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+				return created, fmt.Errorf("failed to create partition %s: %w", name, err)
+			}
+		}
+		created = append(created, es.schema+"."+name)
+	}
+	return created, nil
+}
+
+// tenantKeys reports whether the partitioned table's primary key and event source index include
+// tenant_id, which partitions by tenant need; with byTenant they are rebuilt to if they don't, which
+// locks the table until done. Once they include it they keep it, as months partitioned by tenant
+// can't be indexed without it
+func (es *EventStore) tenantKeys(byTenant bool) (bool, error) {
+	source := pq.QuoteIdentifier(es.schema) + "." + indexName(es.schema+"."+es.name, "event_source_tenant")
+	var keyed bool
+	if err := es.db.QueryRow("SELECT to_regclass($1) IS NOT NULL", source).Scan(&keyed); err != nil {
+		return false, fmt.Errorf("failed to look up the event source index of %s: %w", es.table, err)
+	}
+	if keyed || !byTenant {
+		return keyed, nil
+	}
+
+	tx, err := es.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := es.lockPartitions(tx); err != nil {
+		return false, err
+	}
+	var primaryKey string
+	if err := tx.QueryRow("SELECT conname FROM pg_constraint WHERE conrelid = $1::regclass AND contype = 'p'", es.table).Scan(&primaryKey); err != nil {
+		return false, fmt.Errorf("failed to look up the primary key of %s: %w", es.table, err)
+	}
+	statements := []string{
+		"ALTER TABLE " + es.table + " DROP CONSTRAINT " + pq.QuoteIdentifier(primaryKey) + ", ADD PRIMARY KEY (id, timestamp, tenant_id)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS " + indexName(es.schema+"."+es.name, "event_source_tenant") + " ON " + es.table + "(" + strings.Join(tenantSourceColumns, ", ") + ")",
+		"DROP INDEX IF EXISTS " + pq.QuoteIdentifier(es.schema) + "." + indexName(es.schema+"."+es.name, "event_source_time"),
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return false, fmt.Errorf("failed to key %s by tenant: %w", es.table, err)
+		}
+	}
+	return true, tx.Commit()
+}
+
+// detach detaches a partition, returning its name, or nothing if another instance just did
+// With drop it is dropped as well, and the events it held are counted by type
+func (es *EventStore) detach(ctx context.Context, name string, drop bool) (string, map[string]int64, error) {
+	table := pq.QuoteIdentifier(es.schema) + "." + pq.QuoteIdentifier(name)
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+	if err := es.lockPartitions(tx); err != nil {
+		return "", nil, err
+	}
+	var attached bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_inherits WHERE inhrelid = to_regclass($1) AND inhparent = $2::regclass)", table, es.table).Scan(&attached)
+	if err != nil || !attached {
+		return "", nil, err
+	}
+
+	var counts map[string]int64
+	if drop {
+		if counts, err = countByType(ctx, tx, table); err != nil {
+			return "", nil, fmt.Errorf("failed to count events in %s: %w", name, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "ALTER TABLE "+es.table+" DETACH PARTITION "+table); err != nil {
+		return "", nil, fmt.Errorf("failed to detach %s: %w", name, err)
+	}
+	if drop {
+		if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+			return "", nil, fmt.Errorf("failed to drop %s: %w", name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("failed to detach %s: %w", name, err)
+	}
+	return es.schema + "." + name, counts, nil
+}
+
+// dropPartitions drops the partitions ending by before, adding their events to purged by rule
+func (es *EventStore) dropPartitions(ctx context.Context, r Retention, before time.Time, purged map[string]int64) error {
+	partitions, err := es.partitions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		if p.to.IsZero() || p.to.After(before) {
+			continue
+		}
+		_, counts, err := es.detach(ctx, p.name, true)
+		if err != nil {
+			return err
+		}
+		for eventType, count := range counts {
+			purged[r.label(eventType)] += count
+		}
+	}
+	return nil
+}
+
+// countByType counts the events in table by type
+func countByType(ctx context.Context, tx *sql.Tx, table string) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT event_type, COUNT(*) FROM "+table+" GROUP BY event_type")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var eventType string
+		var count int64
+		if err := rows.Scan(&eventType, &count); err != nil {
+			return nil, err
+		}
+		counts[eventType] = count
+	}
+	return counts, rows.Err()
+}
+
+// partitions lists the table's range partitions
+func (es *EventStore) partitions(ctx context.Context) ([]partition, error) {
+	rows, err := es.db.QueryContext(ctx, `
+		SELECT c.relname, pg_get_expr(c.relpartbound, c.oid), c.relkind = 'p'
+		FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass
+	`, es.table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []partition
+	for rows.Next() {
+		var p partition
+		var bound string
+		if err := rows.Scan(&p.name, &bound, &p.byTenant); err != nil {
+			return nil, err
+		}
+		m := partitionBound.FindStringSubmatch(bound)
+		if m == nil {
+			continue
+		}
+		if m[1] != "" {
+			if p.from, err = time.Parse(partitionTime, m[1]); err != nil {
+				return nil, fmt.Errorf("failed to parse bounds of partition %s: %w", p.name, err)
+			}
+		}
+		if m[2] != "" {
+			if p.to, err = time.Parse(partitionTime, m[2]); err != nil {
+				return nil, fmt.Errorf("failed to parse bounds of partition %s: %w", p.name, err)
+			}
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, rows.Err()
+}
+
+// lockPartitions makes instances change the table's partitions one at a time, until tx ends
+func (es *EventStore) lockPartitions(tx *sql.Tx) error {
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext($1))", es.table); err != nil {
+		return fmt.Errorf("failed to lock partitions of %s: %w", es.table, err)
+	}
+	return nil
+}
+
+// defaultPartition is the partition events outside every month's partition go to
+func defaultPartition(schema, name string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name+"_default")
+}
+
+// monthStart is the start of t's month
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
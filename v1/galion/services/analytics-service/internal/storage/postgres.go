@@ -2,21 +2,128 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// Ways of writing a batch of events
+const (
+	MethodInsert = "insert" // one multi-row INSERT
+	MethodCopy   = "copy"   // COPY FROM STDIN, faster for large batches
+)
+
+// eventColumnNames are the columns written per event
+var eventColumnNames = []string{"event_type", "user_id", "service", "timestamp", "data", "topic", "kafka_partition", "kafka_offset", "tenant_id"}
+
+// eventColumns is the number of values inserted per event
+const eventColumns = 9
+
+// sourceColumns identify a stored event by where it was read from
+var sourceColumns = []string{"topic", "kafka_partition", "kafka_offset"}
+
+// timedSourceColumns identify a stored event in hypertables and partitioned tables, whose unique
+// indexes must include the time column; redelivered events have the same time, so they still conflict
+var timedSourceColumns = []string{"topic", "kafka_partition", "kafka_offset", "timestamp"}
+
+// tenantSourceColumns identify a stored event in tables whose month partitions are partitioned by
+// tenant as well; an event is always stored under the same tenant, so redelivered ones still conflict
+var tenantSourceColumns = []string{"topic", "kafka_partition", "kafka_offset", "timestamp", "tenant_id"}
+
+// DefaultTable is the table the service stores events in
+const DefaultTable = "analytics.events"
+
+// tableName matches the schema-qualified tables events may be stored in
+var tableName = regexp.MustCompile(`^([a-z_][a-z0-9_]*)\.([a-z_][a-z0-9_]*)$`)
+
+// MaxBatchSize is the most events SaveEvents inserts at once, within PostgreSQL's 65535 parameters per statement
+const MaxBatchSize = 65535 / eventColumns
+
+func init() {
+	backends[BackendPostgres] = func(cfg SinkConfig) (EventSink, error) { return NewEventStore(cfg) }
+}
+
 // EventStore stores events in PostgreSQL
 type EventStore struct {
-	db *sql.DB
+	db           *sql.DB
+	schema, name string
+	table        string // quoted, schema-qualified
+	conflict     string // appended to inserts; decides what happens to events already stored
+	method       string // MethodInsert or MethodCopy
+	hypertable   bool   // the table is a TimescaleDB hypertable
+	partitioning Partitioning
+}
+
+// ValidateTable checks that name is a schema-qualified table events can be stored in
+func ValidateTable(name string) error {
+	if !tableName.MatchString(name) {
+		return fmt.Errorf("%q is not a schema-qualified table name such as %s", name, DefaultTable)
+	}
+	return nil
+}
+
+// NewEventStore creates a new event store, migrating its table to the current schema version and
+// laying it out as cfg asks with cfg.Migrate, or else checking that it is
+func NewEventStore(cfg SinkConfig) (*EventStore, error) {
+	if cfg.Table == "" {
+		cfg.Table = DefaultTable
+	}
+	parts := tableName.FindStringSubmatch(cfg.Table)
+	if parts == nil {
+		return nil, ValidateTable(cfg.Table)
+	}
+	schema, name := parts[1], parts[2]
+	if cfg.Method == "" {
+		cfg.Method = MethodInsert
+	}
+	if cfg.Partitioning.Enabled && cfg.Timescale.Mode != "" && cfg.Timescale.Mode != TimescaleOff {
+		return nil, fmt.Errorf("partitioning and TimescaleDB can't be used together")
+	}
+
+	db, err := openPostgres(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	es := &EventStore{
+		db:           db,
+		schema:       schema,
+		name:         name,
+		table:        pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name),
+		method:       cfg.Method,
+		partitioning: cfg.Partitioning,
+	}
+
+	// Without cfg.Migrate nothing is changed: the table is only checked
+	migrator := newMigrator(db, cfg)
+	if cfg.Migrate {
+		if _, err := migrator.Up(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	l, err := migrator.verify(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	es.hypertable = l.hypertable
+	key, _ := l.sourceKey()
+	es.conflict = onDuplicateSource(key, cfg.Overwrite)
+
+	if _, _, err := es.ManagePartitions(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return es, nil
 }
 
-// NewEventStore creates a new event store
-func NewEventStore(databaseURL string) (*EventStore, error) {
+// openPostgres connects to the database at databaseURL
+func openPostgres(databaseURL string) (*sql.DB, error) {
 	// Add SSL mode to connection string if not present
 	// PostgreSQL in Docker doesn't have SSL enabled by default
 	if databaseURL != "" && !contains(databaseURL, "sslmode=") {
@@ -26,7 +133,7 @@ func NewEventStore(databaseURL string) (*EventStore, error) {
 			databaseURL += "?sslmode=disable"
 		}
 	}
-	
+
 	// Connect to database
 	db, err := sql.Open("postgres", databaseURL)
 	if err != nil {
@@ -36,88 +143,310 @@ func NewEventStore(databaseURL string) (*EventStore, error) {
 	// Test connection
 	err = db.Ping()
 	if err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
+	return db, nil
+}
 
-	// Ensure analytics schema exists
-	_, err = db.Exec(`CREATE SCHEMA IF NOT EXISTS analytics`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create analytics schema: %w", err)
+// indexName names an index on table's column; the default table keeps the names its indexes were
+// created with, while other tables, which may share its schema, get names of their own
+func indexName(table, column string) string {
+	if table == DefaultTable {
+		return "idx_" + column
 	}
+	return pq.QuoteIdentifier(table[strings.Index(table, ".")+1:] + "_" + column + "_idx")
+}
 
-	// Create events table if it doesn't exist
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS analytics.events (
-			id SERIAL PRIMARY KEY,
-			event_type VARCHAR(100) NOT NULL,
-			user_id VARCHAR(100) NOT NULL,
-			service VARCHAR(50) NOT NULL,
-			timestamp TIMESTAMP NOT NULL,
-			data JSONB,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)
-	`)
+// onDuplicateSource drops events already stored with the same key columns, or replaces them with overwrite
+func onDuplicateSource(key []string, overwrite bool) string {
+	conflict := "ON CONFLICT (" + strings.Join(key, ", ") + ")"
+	if !overwrite {
+		return conflict + " DO NOTHING"
+	}
+	var set []string
+	for _, column := range eventColumnNames {
+		if !containsString(key, column) {
+			set = append(set, column+" = EXCLUDED."+column)
+		}
+	}
+	return conflict + " DO UPDATE SET " + strings.Join(set, ", ")
+}
+
+// containsString reports whether list has s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxTenantIDLength is the longest tenant_id events are stored with
+const MaxTenantIDLength = 100
+
+// Event is an event to store
+// Topic, Partition and Offset say where the event was read from; events with the same source are
+// stored once, so redelivered events aren't counted twice. Events without a Topic are always stored
+type Event struct {
+	EventType string
+	UserID    string
+	Service   string
+	Timestamp time.Time
+	Data      map[string]interface{}
+	Topic     string
+	Partition int32
+	Offset    int64
+	TenantID  string // the customer the event belongs to; empty for events without one
+}
+
+// values returns the event's column values in eventColumnNames order
+func (e Event) values() ([]interface{}, error) {
+	// Convert data map to JSON
+	dataJSON, err := json.Marshal(e.Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create events table: %w", err)
+		return nil, fmt.Errorf("failed to marshal data of %s event: %w", e.EventType, err)
+	}
+	var topic, partition, offset interface{}
+	if e.Topic != "" {
+		topic, partition, offset = e.Topic, e.Partition, e.Offset
 	}
+	// Sent as a string: COPY would encode []byte as bytea
+	return []interface{}{e.EventType, e.UserID, e.Service, e.Timestamp, string(dataJSON), topic, partition, offset, e.TenantID}, nil
+}
+
+// SaveEvent saves an event to the database
+func (es *EventStore) SaveEvent(eventType, userID, service string, timestamp time.Time, data map[string]interface{}) error {
+	_, err := es.SaveEvents(context.Background(), []Event{{
+		EventType: eventType,
+		UserID:    userID,
+		Service:   service,
+		Timestamp: timestamp,
+		Data:      data,
+	}})
+	return err
+}
 
-	// Create indexes separately (PostgreSQL doesn't support INDEX in CREATE TABLE)
-	indexes := []string{
-		"CREATE INDEX IF NOT EXISTS idx_event_type ON analytics.events(event_type)",
-		"CREATE INDEX IF NOT EXISTS idx_user_id ON analytics.events(user_id)",
-		"CREATE INDEX IF NOT EXISTS idx_timestamp ON analytics.events(timestamp)",
+// SaveBatch implements EventSink with the configured method
+func (es *EventStore) SaveBatch(ctx context.Context, events []Event) (int64, error) {
+	if es.method == MethodCopy {
+		return es.CopyEvents(ctx, events)
 	}
+	return es.SaveEvents(ctx, events)
+}
+
+// Method implements EventSink
+func (es *EventStore) Method() string {
+	return es.method
+}
 
-	for _, indexSQL := range indexes {
-		_, err = db.Exec(indexSQL)
+// SaveEvents saves events with one multi-row INSERT; either all are saved or none
+// Events already stored from the same source are left out, or replaced with TableConfig.Overwrite;
+// it returns how many rows were added or replaced
+func (es *EventStore) SaveEvents(ctx context.Context, events []Event) (int64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	var query strings.Builder
+	query.WriteString("INSERT INTO " + es.table + " (" + strings.Join(eventColumnNames, ", ") + ") VALUES ")
+	args := make([]interface{}, 0, len(events)*eventColumns)
+	for i, event := range events {
+		values, err := event.values()
 		if err != nil {
-			// Log error but don't fail - indexes are optional for functionality
-			fmt.Printf("Warning: Failed to create index: %v\n", err)
+			return 0, err
+		}
+		if i > 0 {
+			query.WriteString(", ")
 		}
+		query.WriteString("(")
+		for j := range values {
+			if j > 0 {
+				query.WriteString(", ")
+			}
+			fmt.Fprintf(&query, "$%d", len(args)+j+1)
+		}
+		query.WriteString(")")
+		args = append(args, values...)
 	}
+	query.WriteString(" " + es.conflict)
 
-	return &EventStore{db: db}, nil
+	result, err := es.db.ExecContext(ctx, query.String(), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert %d events: %w", len(events), err)
+	}
+	return result.RowsAffected()
 }
 
-// SaveEvent saves an event to the database
-func (es *EventStore) SaveEvent(eventType, userID, service string, timestamp time.Time, data map[string]interface{}) error {
-	// Convert data map to JSON
-	dataJSON, err := json.Marshal(data)
+// CopyEvents bulk-loads events with COPY in one transaction; either all are saved or none
+// Unlike SaveEvents it has no parameter limit, so batches may be larger than MaxBatchSize
+// COPY can't skip conflicting rows, so events are copied into a temporary table and inserted from there
+func (es *EventStore) CopyEvents(ctx context.Context, events []Event) (int64, error) {
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	// Marshal everything first so a bad event doesn't leave a COPY half sent
+	rows := make([][]interface{}, len(events))
+	for i, event := range events {
+		values, err := event.values()
+		if err != nil {
+			return 0, err
+		}
+		rows[i] = values
+	}
+
+	tx, err := es.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to marshal data: %w", err)
+		return 0, fmt.Errorf("failed to begin copy of %d events: %w", len(events), err)
 	}
+	defer tx.Rollback()
 
-	// Insert event into database
-	query := `
-		INSERT INTO analytics.events (event_type, user_id, service, timestamp, data)
-		VALUES ($1, $2, $3, $4, $5)
-	`
+	_, err = tx.ExecContext(ctx, `
+		CREATE TEMPORARY TABLE events_load (
+			event_type VARCHAR(100),
+			user_id VARCHAR(100),
+			service VARCHAR(50),
+			timestamp TIMESTAMP,
+			data JSONB,
+			topic VARCHAR(255),
+			kafka_partition INTEGER,
+			kafka_offset BIGINT,
+			tenant_id VARCHAR(100)
+		) ON COMMIT DROP
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create load table: %w", err)
+	}
 
-	_, err = es.db.Exec(query, eventType, userID, service, timestamp, dataJSON)
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("events_load", eventColumnNames...))
 	if err != nil {
-		return fmt.Errorf("failed to insert event: %w", err)
+		return 0, fmt.Errorf("failed to start copy of %d events: %w", len(events), err)
+	}
+	for _, values := range rows {
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			stmt.Close()
+			return 0, fmt.Errorf("failed to copy %d events: %w", len(events), err)
+		}
+	}
+	// The final Exec without arguments sends the buffered rows and ends the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return 0, fmt.Errorf("failed to copy %d events: %w", len(events), err)
+	}
+	if err := stmt.Close(); err != nil {
+		return 0, fmt.Errorf("failed to copy %d events: %w", len(events), err)
 	}
 
-	return nil
+	columns := strings.Join(eventColumnNames, ", ")
+	result, err := tx.ExecContext(ctx, "INSERT INTO "+es.table+" ("+columns+") SELECT "+columns+" FROM events_load "+es.conflict)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert %d copied events: %w", len(events), err)
+	}
+	saved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit copy of %d events: %w", len(events), err)
+	}
+	return saved, nil
 }
 
-// GetEventCount returns the total number of events
-func (es *EventStore) GetEventCount() (int64, error) {
-	var count int64
-	err := es.db.QueryRow("SELECT COUNT(*) FROM analytics.events").Scan(&count)
+// Query implements EventSink
+func (es *EventStore) Query(ctx context.Context, q Query) ([]StoredEvent, error) {
+	where, args := queryWhere(q)
+	order, after := "DESC", "<"
+	if q.Ascending {
+		order, after = "ASC", ">"
+	}
+	if q.After != nil {
+		args = append(args, q.After.Timestamp, q.After.ID)
+		where = append(where, fmt.Sprintf("(timestamp, id) %s ($%d, $%d)", after, len(args)-1, len(args)))
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultQueryLimit
+	}
+
+	query := "SELECT id, " + strings.Join(eventColumnNames, ", ") + ", created_at FROM " + es.table + " WHERE " + strings.Join(where, " AND ")
+	query += fmt.Sprintf(" ORDER BY timestamp %s, id %s LIMIT %d", order, order, limit)
+
+	rows, err := es.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("failed to query events: %w", err)
 	}
-	return count, nil
+	defer rows.Close()
+
+	var events []StoredEvent
+	for rows.Next() {
+		event, err := scanStoredEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
 }
 
-// GetEventCountByType returns event counts grouped by type
-func (es *EventStore) GetEventCountByType() (map[string]int64, error) {
-	rows, err := es.db.Query(`
+// queryWhere is the conditions selecting the events q filters, with their arguments as $1 onwards
+func queryWhere(q Query) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	filter := func(condition string, value interface{}) {
+		args = append(args, value)
+		where = append(where, fmt.Sprintf(condition, len(args)))
+	}
+	filter("tenant_id = $%d", q.TenantID)
+	if q.EventType != "" {
+		filter("event_type = $%d", q.EventType)
+	}
+	if q.UserID != "" {
+		filter("user_id = $%d", q.UserID)
+	}
+	if q.Service != "" {
+		filter("service = $%d", q.Service)
+	}
+	if !q.From.IsZero() {
+		filter("timestamp >= $%d", q.From)
+	}
+	if !q.To.IsZero() {
+		filter("timestamp < $%d", q.To)
+	}
+	return where, args
+}
+
+// scanStoredEvent reads a row of id, the eventColumnNames and created_at
+func scanStoredEvent(rows *sql.Rows) (StoredEvent, error) {
+	var event StoredEvent
+	var data []byte
+	var topic sql.NullString
+	var partition sql.NullInt32
+	var offset sql.NullInt64
+	var storedAt sql.NullTime
+	err := rows.Scan(&event.ID, &event.EventType, &event.UserID, &event.Service, &event.Timestamp, &data, &topic, &partition, &offset, &event.TenantID, &storedAt)
+	if err != nil {
+		return event, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &event.Data); err != nil {
+			return event, fmt.Errorf("failed to unmarshal data of event %d: %w", event.ID, err)
+		}
+	}
+	// Events stored before sources were recorded have none
+	event.Topic, event.Partition, event.Offset = topic.String, partition.Int32, offset.Int64
+	event.StoredAt = storedAt.Time
+	return event, nil
+}
+
+// Counts implements EventSink
+func (es *EventStore) Counts(ctx context.Context) (map[string]int64, error) {
+	rows, err := es.db.QueryContext(ctx, `
 		SELECT event_type, COUNT(*) as count
-		FROM analytics.events
+		FROM `+es.table+`
 		GROUP BY event_type
-		ORDER BY count DESC
 	`)
 	if err != nil {
 		return nil, err
@@ -135,7 +464,87 @@ func (es *EventStore) GetEventCountByType() (map[string]int64, error) {
 		result[eventType] = count
 	}
 
-	return result, nil
+	return result, rows.Err()
+}
+
+// purgeBatchSize is how many rows each DELETE of a purge removes, so no lock is held for long
+const purgeBatchSize = 10000
+
+// Purge implements EventSink, deleting in batches of purgeBatchSize; with partitioning, partitions
+// holding only events every type's retention has passed are dropped whole instead
+// One instance purges a table at a time; the others get ErrPurgeRunning
+func (es *EventStore) Purge(ctx context.Context, r Retention, dryRun bool) (map[string]int64, error) {
+	conn, err := es.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	lock := es.table + " purge"
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", lock).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to lock %s for purging: %w", es.table, err)
+	}
+	if !locked {
+		return nil, ErrPurgeRunning
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", lock)
+
+	purged := make(map[string]int64)
+	// A dry run counts partitions' events with the rest
+	if before, ok := r.oldest(); ok && es.partitioning.Enabled && !dryRun {
+		if err := es.dropPartitions(ctx, r, before, purged); err != nil {
+			return purged, err
+		}
+	}
+	for _, rule := range r.rules() {
+		n, err := es.purgeRule(ctx, conn, rule, dryRun)
+		purged[rule.label] += n
+		if err != nil {
+			return purged, fmt.Errorf("failed to purge %s events: %w", rule.label, err)
+		}
+	}
+	return purged, nil
+}
+
+// purgeRule deletes, or with dryRun counts, the events rule selects
+func (es *EventStore) purgeRule(ctx context.Context, conn *sql.Conn, rule purgeRule, dryRun bool) (int64, error) {
+	where := "timestamp < $1 AND "
+	args := []interface{}{rule.before.UTC()}
+	if rule.eventType != "" {
+		where += "event_type = $2"
+		args = append(args, rule.eventType)
+	} else {
+		where += "NOT (event_type = ANY($2))"
+		args = append(args, pq.Array(rule.except))
+	}
+
+	if dryRun {
+		var count int64
+		err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+es.table+" WHERE "+where, args...).Scan(&count)
+		return count, err
+	}
+	var total int64
+	for {
+		result, err := conn.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE (id, timestamp) IN (SELECT id, timestamp FROM %s WHERE %s LIMIT %d)", es.table, es.table, where, purgeBatchSize), args...)
+		if err != nil {
+			return total, err
+		}
+		n, err := result.RowsAffected()
+		total += n
+		if err != nil || n < purgeBatchSize {
+			return total, err
+		}
+	}
+}
+
+// Hypertable reports whether events are stored in a TimescaleDB hypertable
+func (es *EventStore) Hypertable() bool {
+	return es.hypertable
+}
+
+// Ping checks that the database is reachable
+func (es *EventStore) Ping(ctx context.Context) error {
+	return es.db.PingContext(ctx)
 }
 
 // Close closes the database connection
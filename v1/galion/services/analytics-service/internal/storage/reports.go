@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"nexus-analytics-service/pkg/metrics"
+)
+
+// Reports kept as materialized views, each refreshed on a schedule
+const (
+	ReportEventsByType = "events_by_type_24h" // events and users per type over the last 24 hours
+	ReportActiveUsers  = "active_users_daily" // users and events per day over the last 90 days
+	ReportTopUsers     = "top_users"          // the 1000 users with most events over the last 30 days, per tenant
+)
+
+// reportOrder is how each report's rows are listed
+var reportOrder = map[string]string{
+	ReportEventsByType: "events DESC, event_type",
+	ReportActiveUsers:  "day DESC",
+	ReportTopUsers:     "events DESC, user_id",
+}
+
+// ReportNames lists the reports
+func ReportNames() []string {
+	return []string{ReportEventsByType, ReportActiveUsers, ReportTopUsers}
+}
+
+// Report is a report's rows, as column names to values, and when it was last refreshed
+type Report struct {
+	Name        string
+	RefreshedAt time.Time // zero before the first refresh, when there are no rows
+	Rows        []map[string]interface{}
+}
+
+// reportView is the quoted materialized view of a report
+func (es *EventStore) reportView(name string) string {
+	return relatedTable(es.schema, es.name, "report_"+name)
+}
+
+// RefreshReports implements Reports
+// Each view is refreshed in a transaction holding a lock on it, so instances take turns and only
+// the first finds it stale; refreshes are concurrent, so reads carry on meanwhile
+func (es *EventStore) RefreshReports(ctx context.Context, maxAge time.Duration) (map[string]time.Time, error) {
+	state := relatedTable(es.schema, es.name, "report_state")
+	times := make(map[string]time.Time)
+	for _, name := range ReportNames() {
+		refreshedAt, err := es.refreshReport(ctx, name, state, maxAge)
+		if err != nil {
+			return times, err
+		}
+		times[name] = refreshedAt
+	}
+	return times, nil
+}
+
+// refreshReport refreshes a report's view if it is older than maxAge, returning when it was last refreshed
+func (es *EventStore) refreshReport(ctx context.Context, name, state string, maxAge time.Duration) (time.Time, error) {
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock(hashtext($1))", es.reportView(name)).Scan(&locked); err != nil {
+		return time.Time{}, fmt.Errorf("failed to lock report %s: %w", name, err)
+	}
+	refreshedAt, populated, err := es.reportState(ctx, tx, name, state)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !populated {
+		refreshedAt = time.Time{}
+	}
+	// Another instance is refreshing it, or it is fresh enough
+	if !locked || (populated && time.Since(refreshedAt) < maxAge) {
+		return refreshedAt, nil
+	}
+
+	started := time.Now()
+	// A view is refreshed concurrently once it has data; the first refresh can't be
+	refresh := "REFRESH MATERIALIZED VIEW CONCURRENTLY "
+	if !populated {
+		refresh = "REFRESH MATERIALIZED VIEW "
+	}
+	_, err = tx.ExecContext(ctx, refresh+es.reportView(name))
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+state+" (report, refreshed_at) VALUES ($1, $2) ON CONFLICT (report) DO UPDATE SET refreshed_at = EXCLUDED.refreshed_at", name, started.UTC())
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	metrics.RecordReportRefresh(name, time.Since(started), err)
+	if err != nil {
+		return refreshedAt, fmt.Errorf("failed to refresh report %s: %w", name, err)
+	}
+	return started.UTC(), nil
+}
+
+// reportState returns when a report was last refreshed, and whether its view has data; a view
+// recreated along with the events table has none until it is refreshed again
+func (es *EventStore) reportState(ctx context.Context, q queryer, name, state string) (time.Time, bool, error) {
+	var refreshedAt sql.NullTime
+	var populated bool
+	err := q.QueryRowContext(ctx, `
+		SELECT (SELECT refreshed_at FROM `+state+` WHERE report = $1), relispopulated FROM pg_class WHERE oid = $2::regclass
+	`, name, es.reportView(name)).Scan(&refreshedAt, &populated)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read the state of report %s: %w", name, err)
+	}
+	return refreshedAt.Time.UTC(), populated && refreshedAt.Valid, nil
+}
+
+// Report implements Reports
+func (es *EventStore) Report(ctx context.Context, tenantID, name string, limit int) (Report, error) {
+	order, ok := reportOrder[name]
+	if !ok {
+		return Report{}, fmt.Errorf("unknown report %q", name)
+	}
+	report := Report{Name: name, Rows: []map[string]interface{}{}}
+
+	refreshedAt, populated, err := es.reportState(ctx, es.db, name, relatedTable(es.schema, es.name, "report_state"))
+	if err != nil || !populated {
+		// Not refreshed since it was created, so the view can't be read yet
+		return report, err
+	}
+	report.RefreshedAt = refreshedAt
+
+	rows, err := es.db.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE tenant_id = $1 ORDER BY %s LIMIT %d", es.reportView(name), order, limit), tenantID)
+	if err != nil {
+		return report, fmt.Errorf("failed to read report %s: %w", name, err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return report, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			row[column] = values[i]
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	return report, rows.Err()
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// RolledUpThrough implements Rollups
+func (es *EventStore) RolledUpThrough(ctx context.Context) (time.Time, error) {
+	var through sql.NullTime
+	err := es.db.QueryRowContext(ctx, "SELECT through FROM "+relatedTable(es.schema, es.name, "rollup_state")).Scan(&through)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("failed to read rollup state: %w", err)
+	}
+	if through.Valid {
+		return through.Time.UTC(), nil
+	}
+	var oldest sql.NullTime
+	if err := es.db.QueryRowContext(ctx, "SELECT min(timestamp) FROM "+es.table).Scan(&oldest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to find the oldest event: %w", err)
+	}
+	if !oldest.Valid {
+		return time.Time{}, nil
+	}
+	return oldest.Time.UTC().Truncate(time.Hour), nil
+}
+
+// RollUp implements Rollups, replacing the hours' and days' rows in one transaction, so dashboards
+// never see a bucket half computed; days are summed from the hourly rollup rather than the events,
+// and rows of anonymized events stay marked anonymized
+func (es *EventStore) RollUp(ctx context.Context, from, to time.Time) error {
+	from, to = from.UTC(), to.UTC()
+	dayFrom := dayStart(from)
+	dayTo := dayStart(to)
+	if dayTo.Before(to) {
+		dayTo = dayTo.AddDate(0, 0, 1)
+	}
+
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock(hashtext($1))", es.table+" rollup").Scan(&locked); err != nil {
+		return fmt.Errorf("failed to lock %s for rolling up: %w", es.table, err)
+	}
+	if !locked {
+		return ErrRollupRunning
+	}
+
+	hourly := relatedTable(es.schema, es.name, "rollup_hourly")
+	daily := relatedTable(es.schema, es.name, "rollup_daily")
+	statements := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"DELETE FROM " + hourly + " WHERE bucket >= $1 AND bucket < $2", []interface{}{from, to}},
+		{`INSERT INTO ` + hourly + ` (tenant_id, bucket, event_type, service, user_id, events, anonymized)
+			SELECT tenant_id, date_trunc('hour', timestamp), event_type, service, user_id, count(*), bool_or(anonymized_at IS NOT NULL) FROM ` + es.table + `
+			WHERE timestamp >= $1 AND timestamp < $2
+			GROUP BY 1, 2, 3, 4, 5`, []interface{}{from, to}},
+		{"DELETE FROM " + daily + " WHERE bucket >= $1 AND bucket < $2", []interface{}{dayFrom, dayTo}},
+		{`INSERT INTO ` + daily + ` (tenant_id, bucket, event_type, service, user_id, events, anonymized)
+			SELECT tenant_id, date_trunc('day', bucket), event_type, service, user_id, sum(events), bool_or(anonymized) FROM ` + hourly + `
+			WHERE bucket >= $1 AND bucket < $2
+			GROUP BY 1, 2, 3, 4, 5`, []interface{}{dayFrom, dayTo}},
+		// Recomputing hours already rolled up, for late events, doesn't move the state back
+		{`INSERT INTO ` + relatedTable(es.schema, es.name, "rollup_state") + ` AS state (through) VALUES ($1)
+			ON CONFLICT (id) DO UPDATE SET through = GREATEST(state.through, EXCLUDED.through)`, []interface{}{to}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.sql, statement.args...); err != nil {
+			return fmt.Errorf("failed to roll up %s to %s: %w", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Top implements Rollups; dimension is checked against TopDimensions, since it names a column
+func (es *EventStore) Top(ctx context.Context, tenantID, dimension string, from time.Time, daily bool, limit int) ([]Ranked, error) {
+	if !slices.Contains(TopDimensions, dimension) {
+		return nil, fmt.Errorf("unknown dimension %q", dimension)
+	}
+	table := relatedTable(es.schema, es.name, "rollup_hourly")
+	if daily {
+		table = relatedTable(es.schema, es.name, "rollup_daily")
+	}
+	rows, err := es.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[1]s, sum(events) FROM %[2]s WHERE tenant_id = $1 AND bucket >= $2
+		GROUP BY %[1]s ORDER BY 2 DESC, 1 LIMIT %[3]d
+	`, dimension, table, limit), tenantID, from.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank %s: %w", dimension, err)
+	}
+	defer rows.Close()
+
+	var ranked []Ranked
+	for rows.Next() {
+		var r Ranked
+		if err := rows.Scan(&r.Value, &r.Events); err != nil {
+			return nil, err
+		}
+		ranked = append(ranked, r)
+	}
+	return ranked, rows.Err()
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CountSeries implements TimeSeries, bucketing by the seconds since the epoch, which works on plain
+// tables, partitions and hypertables alike
+func (es *EventStore) CountSeries(ctx context.Context, q SeriesQuery) ([]Bucket, error) {
+	where, args := queryWhere(q.Query)
+	seconds := strconv.FormatInt(int64(q.Interval/time.Second), 10)
+	columns := []string{"to_timestamp(floor(extract(epoch FROM timestamp) / " + seconds + ") * " + seconds + ") AT TIME ZONE 'UTC'"}
+	groupBy := []string{"1"}
+	for i, column := range q.GroupBy {
+		columns = append(columns, column)
+		groupBy = append(groupBy, strconv.Itoa(i+2))
+	}
+	query := "SELECT " + strings.Join(columns, ", ") + ", count(*) FROM " + es.table + " WHERE " + strings.Join(where, " AND ")
+	query += " GROUP BY " + strings.Join(groupBy, ", ") + " ORDER BY 1"
+
+	rows, err := es.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count events per bucket: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []Bucket
+	for rows.Next() {
+		var bucket Bucket
+		values := make([]string, len(q.GroupBy))
+		dest := []interface{}{&bucket.Start}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(append(dest, &bucket.Count)...); err != nil {
+			return nil, err
+		}
+		bucket.Group = make(map[string]string, len(q.GroupBy))
+		for i, column := range q.GroupBy {
+			bucket.Group[column] = values[i]
+		}
+		bucket.Start = bucket.Start.UTC()
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SessionizedThrough implements Sessions
+func (es *EventStore) SessionizedThrough(ctx context.Context) (time.Time, error) {
+	var through sql.NullTime
+	err := es.db.QueryRowContext(ctx, "SELECT through FROM "+relatedTable(es.schema, es.name, "session_state")).Scan(&through)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, fmt.Errorf("failed to read session state: %w", err)
+	}
+	if through.Valid {
+		return through.Time.UTC(), nil
+	}
+	var oldest sql.NullTime
+	if err := es.db.QueryRowContext(ctx, "SELECT min(timestamp) FROM "+es.table).Scan(&oldest); err != nil {
+		return time.Time{}, fmt.Errorf("failed to find the oldest event: %w", err)
+	}
+	if !oldest.Valid {
+		return time.Time{}, nil
+	}
+	return oldest.Time.UTC().Truncate(time.Hour), nil
+}
+
+// Sessionize implements Sessions in one transaction, so the API never sees a session half stitched
+// Sessions that ended within gap of from may go on in the range, so they are deleted and stitched
+// again from their first event, along with the range's events; events without a user have no session
+func (es *EventStore) Sessionize(ctx context.Context, from, to time.Time, gap time.Duration) error {
+	from, to = from.UTC(), to.UTC()
+	tx, err := es.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock(hashtext($1))", es.table+" sessions").Scan(&locked); err != nil {
+		return fmt.Errorf("failed to lock %s for stitching sessions: %w", es.table, err)
+	}
+	if !locked {
+		return ErrSessionizeRunning
+	}
+
+	sessions := relatedTable(es.schema, es.name, "sessions")
+	reopenedSince := from.Add(-gap)
+	statements := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"CREATE TEMPORARY TABLE reopened_sessions (tenant_id VARCHAR(100), user_id VARCHAR(100), since TIMESTAMP) ON COMMIT DROP", nil},
+		{`INSERT INTO reopened_sessions
+			SELECT tenant_id, user_id, min(started_at) FROM ` + sessions + ` WHERE ended_at >= $1 GROUP BY 1, 2`, []interface{}{reopenedSince}},
+		{"DELETE FROM " + sessions + " WHERE ended_at >= $1", []interface{}{reopenedSince}},
+		// A session starts at each event more than gap after the user's one before it
+		{`INSERT INTO ` + sessions + ` (tenant_id, user_id, started_at, ended_at, events)
+			WITH stitched AS (
+				SELECT tenant_id, user_id, timestamp FROM ` + es.table + `
+				WHERE user_id <> '' AND timestamp >= $1 AND timestamp < $2
+				UNION ALL
+				SELECT e.tenant_id, e.user_id, e.timestamp FROM ` + es.table + ` e
+				JOIN reopened_sessions r ON r.tenant_id = e.tenant_id AND r.user_id = e.user_id
+				WHERE e.timestamp >= r.since AND e.timestamp < $1
+			), starts AS (
+				SELECT tenant_id, user_id, timestamp,
+					CASE WHEN timestamp - lag(timestamp) OVER w <= $3 * INTERVAL '1 second' THEN 0 ELSE 1 END AS starts
+				FROM stitched WINDOW w AS (PARTITION BY tenant_id, user_id ORDER BY timestamp)
+			), numbered AS (
+				SELECT tenant_id, user_id, timestamp,
+					sum(starts) OVER (PARTITION BY tenant_id, user_id ORDER BY timestamp ROWS UNBOUNDED PRECEDING) AS session
+				FROM starts
+			)
+			SELECT tenant_id, user_id, min(timestamp), max(timestamp), count(*) FROM numbered
+			GROUP BY tenant_id, user_id, session`, []interface{}{from, to, gap.Seconds()}},
+		// Stitching ranges again doesn't move the state back
+		{`INSERT INTO ` + relatedTable(es.schema, es.name, "session_state") + ` AS state (through) VALUES ($1)
+			ON CONFLICT (id) DO UPDATE SET through = GREATEST(state.through, EXCLUDED.through)`, []interface{}{to}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.sql, statement.args...); err != nil {
+			return fmt.Errorf("failed to stitch sessions from %s to %s: %w", from.Format(time.RFC3339), to.Format(time.RFC3339), err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SessionStats implements Sessions, bucketing by the seconds since the epoch, as CountSeries does
+func (es *EventStore) SessionStats(ctx context.Context, q SessionQuery) ([]SessionBucket, error) {
+	seconds := strconv.FormatInt(int64(q.Interval/time.Second), 10)
+	query := `
+		SELECT to_timestamp(floor(extract(epoch FROM started_at) / ` + seconds + `) * ` + seconds + `) AT TIME ZONE 'UTC',
+			count(*), count(DISTINCT user_id), sum(events),
+			avg(extract(epoch FROM ended_at - started_at)),
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY extract(epoch FROM ended_at - started_at))
+		FROM ` + relatedTable(es.schema, es.name, "sessions") + `
+		WHERE tenant_id = $1 AND started_at >= $2 AND started_at < $3`
+	args := []interface{}{q.TenantID, q.From.UTC(), q.To.UTC()}
+	if q.UserID != "" {
+		query += " AND user_id = $4"
+		args = append(args, q.UserID)
+	}
+	rows, err := es.db.QueryContext(ctx, query+" GROUP BY 1 ORDER BY 1", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []SessionBucket
+	for rows.Next() {
+		var bucket SessionBucket
+		var avg, median float64
+		if err := rows.Scan(&bucket.Start, &bucket.Sessions, &bucket.Users, &bucket.Events, &avg, &median); err != nil {
+			return nil, err
+		}
+		bucket.Start = bucket.Start.UTC()
+		bucket.AvgDuration = time.Duration(avg * float64(time.Second)).Round(time.Millisecond)
+		bucket.MedianDuration = time.Duration(median * float64(time.Second)).Round(time.Millisecond)
+		buckets = append(buckets, bucket)
+	}
+	return buckets, rows.Err()
+}
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Storage backends events can be saved to
+const (
+	BackendPostgres   = "postgres"
+	BackendClickHouse = "clickhouse"
+)
+
+// EventSink is a storage backend: BatchWriter saves events through it and the service reads counts
+// from it, so another database plugs in by implementing it and registering itself in backends
+type EventSink interface {
+	// SaveBatch stores events in one go, all of them or none; events already stored from the same
+	// source are left out, or replaced with SinkConfig.Overwrite, either at once or as the backend
+	// deduplicates in the background. It returns how many were written
+	SaveBatch(ctx context.Context, events []Event) (int64, error)
+	// Query returns stored events matching q, most recent first unless q.Ascending
+	Query(ctx context.Context, q Query) ([]StoredEvent, error)
+	// Counts returns how many events of each type are stored
+	Counts(ctx context.Context) (map[string]int64, error)
+	// Purge deletes events older than their type's time in r, or with dryRun only counts them; it
+	// returns how many were (or would be) deleted per rule, by event type or PurgeRuleDefault
+	Purge(ctx context.Context, r Retention, dryRun bool) (map[string]int64, error)
+	// Ping checks that the backend is reachable, for the readiness probe
+	Ping(ctx context.Context) error
+	// Method names how SaveBatch writes, as reported in metrics and logs
+	Method() string
+	Close() error
+}
+
+// SinkConfig is what a backend is opened with
+type SinkConfig struct {
+	URL       string
+	Table     string        // schema-qualified, as in analytics.events; DefaultTable if empty
+	Overwrite bool          // replace events already stored from the same source instead of keeping them
+	Method    string        // how batches are written, for backends with a choice; MethodInsert if empty
+	TTL       time.Duration // events older than this are deleted, for backends that can; 0 keeps them
+	Timescale Timescale     // PostgreSQL only
+	// Partitioning is PostgreSQL only, and can't be combined with Timescale
+	Partitioning Partitioning
+	// Migrate applies pending PostgreSQL migrations; otherwise the table must already be at the
+	// current schema version
+	Migrate bool
+}
+
+// Query selects stored events; empty fields other than TenantID match every event
+type Query struct {
+	// TenantID is always matched, so a query never returns another tenant's events; empty matches
+	// the events stored without a tenant
+	TenantID  string
+	EventType string
+	UserID    string
+	Service   string
+	From      time.Time // events at or after From
+	To        time.Time // events before To
+	Limit     int       // at most this many; DefaultQueryLimit if 0
+	Ascending bool      // oldest first
+	// After continues a query from the last event of its previous page: events are ordered by time,
+	// then by row ID in PostgreSQL and by source in ClickHouse, and only those after it are returned
+	After *StoredEvent
+}
+
+// DefaultQueryLimit is how many events a Query without a Limit returns
+const DefaultQueryLimit = 100
+
+// StoredEvent is an event read back from a backend
+type StoredEvent struct {
+	Event
+	ID       int64 // 0 for backends without row IDs
+	StoredAt time.Time
+}
+
+// Archivable is a backend whose events can be exported to cold storage, with a manifest of the
+// files they were exported to
+type Archivable interface {
+	EventSink
+	// ArchivedThrough returns the day after the last one in the manifest or, before anything is
+	// archived, the day of the oldest event; zero if there are no events
+	ArchivedThrough(ctx context.Context) (time.Time, error)
+	// ScanDay calls fn with the events of the UTC day starting at day, ordered by type and time
+	ScanDay(ctx context.Context, day time.Time, fn func(StoredEvent) error) error
+	// RecordArchive adds a day's files to the manifest at once, so that a day is archived whole or
+	// not at all, replacing rows for the same parts
+	RecordArchive(ctx context.Context, files []ArchivedFile) error
+}
+
+// ArchivedFile is a manifest row: a part of a day's events of one type, exported to URL
+type ArchivedFile struct {
+	Day         time.Time
+	EventType   string
+	Part        int
+	URL         string
+	Events      int64
+	Bytes       int64
+	First, Last time.Time // timestamps of the first and last events in the file
+}
+
+// Rollups is a backend that keeps counts of events per type, service and user in each hour and
+// day, in tables of their own
+type Rollups interface {
+	EventSink
+	// RolledUpThrough returns the start of the first hour not yet rolled up or, before any rollup,
+	// the hour of the oldest event; zero if there are no events
+	RolledUpThrough(ctx context.Context) (time.Time, error)
+	// RollUp recomputes the hours from from to to, whole hours, and the days they fall in, then
+	// records that hours before to are rolled up; ErrRollupRunning while another instance is
+	RollUp(ctx context.Context, from, to time.Time) error
+	// Top returns the limit values of dimension, one of TopDimensions, with the most events of
+	// tenantID in the hours rolled up from from on or, with daily, in the days from from on
+	Top(ctx context.Context, tenantID, dimension string, from time.Time, daily bool, limit int) ([]Ranked, error)
+}
+
+// TopDimensions are the columns Rollups.Top ranks
+var TopDimensions = []string{"event_type", "user_id", "service"}
+
+// Ranked is a value of a dimension and how many events it had
+type Ranked struct {
+	Value  string
+	Events int64
+}
+
+// Reports is a backend that keeps the reports dashboards ask for most as materialized views
+type Reports interface {
+	EventSink
+	// RefreshReports refreshes the reports last refreshed more than maxAge ago, by any instance,
+	// returning when each was last refreshed
+	RefreshReports(ctx context.Context, maxAge time.Duration) (map[string]time.Time, error)
+	// Report returns up to limit rows of the named report, one of ReportNames, for tenantID
+	Report(ctx context.Context, tenantID, name string, limit int) (Report, error)
+}
+
+// Sessions is a backend that stitches each user's events into sessions, in a table of their own
+type Sessions interface {
+	EventSink
+	// SessionizedThrough returns the time before which every event is in a session or, before any
+	// run, the hour of the oldest event; zero if there are no events
+	SessionizedThrough(ctx context.Context) (time.Time, error)
+	// Sessionize stitches the events from from to to into sessions, continuing those that ended
+	// within gap of from, then records that events before to are sessionized;
+	// ErrSessionizeRunning while another instance is
+	Sessionize(ctx context.Context, from, to time.Time, gap time.Duration) error
+	// SessionStats counts the sessions q selects per bucket of their start, oldest first, with a row
+	// per bucket that has sessions
+	SessionStats(ctx context.Context, q SessionQuery) ([]SessionBucket, error)
+}
+
+// SessionQuery selects the sessions to count, and the width of the buckets, which start at
+// multiples of it since the Unix epoch
+type SessionQuery struct {
+	TenantID string
+	UserID   string // optional
+	From     time.Time
+	To       time.Time
+	Interval time.Duration
+}
+
+// SessionBucket is how many sessions started in a bucket, of how many users, and how long they were
+type SessionBucket struct {
+	Start          time.Time
+	Sessions       int64
+	Users          int64
+	Events         int64
+	AvgDuration    time.Duration
+	MedianDuration time.Duration
+}
+
+// Cohorts is a backend that keeps a retention table of users by the week they were first seen
+type Cohorts interface {
+	EventSink
+	// ComputeCohorts computes the cohorts of the last weeks weeks, if they were last computed more
+	// than maxAge ago by any instance, returning when they were last computed
+	ComputeCohorts(ctx context.Context, weeks int, maxAge time.Duration) (time.Time, error)
+	// Cohorts returns tenantID's cohorts as last computed
+	Cohorts(ctx context.Context, tenantID string) (CohortTable, error)
+}
+
+// CohortTable is every week of every cohort of a tenant, oldest first, and when they were computed
+type CohortTable struct {
+	ComputedAt time.Time // zero before the first computation, when there are no weeks
+	Weeks      []CohortWeek
+}
+
+// CohortWeek is how many users first seen in the week starting Cohort were active Week weeks later
+type CohortWeek struct {
+	Cohort time.Time
+	Week   int
+	Users  int64
+}
+
+// TimeSeries is a backend that counts events per time bucket, for charts
+type TimeSeries interface {
+	EventSink
+	// CountSeries counts the events q selects per bucket, oldest first, with a row per bucket and
+	// group that has events
+	CountSeries(ctx context.Context, q SeriesQuery) ([]Bucket, error)
+}
+
+// SeriesQuery selects the events to count, and how they are bucketed and grouped
+type SeriesQuery struct {
+	Query // filters; Limit, Ascending and After aren't used
+	// Interval is the width of the buckets, which start at multiples of it since the Unix epoch, so
+	// intervals that divide a day start at midnight UTC
+	Interval time.Duration
+	GroupBy  []string // columns of SeriesGroups each bucket is also counted by
+}
+
+// SeriesGroups are the columns a series can be grouped by
+var SeriesGroups = []string{"event_type", "service"}
+
+// Bucket is the count of events in a bucket, of a group of them if grouped
+type Bucket struct {
+	Start time.Time
+	Group map[string]string // value of each SeriesQuery.GroupBy column
+	Count int64
+}
+
+// Erasable is a backend that can erase a user's events, and the rows derived from them, on request
+type Erasable interface {
+	EventSink
+	// EraseUser deletes the events of tenantID stored under any of userIDs, or with
+	// ErasureAnonymize keeps them, without their data, under pseudonym; rollups and sessions are
+	// changed the same way, all at once
+	EraseUser(ctx context.Context, tenantID string, userIDs []string, mode, pseudonym string) (Erasure, error)
+}
+
+// Ways of erasing a user's events
+const (
+	ErasureDelete    = "delete"    // the events are deleted
+	ErasureAnonymize = "anonymize" // the events are kept for counts, under a pseudonym and without data
+)
+
+// Erasure is what erasing a user's events changed
+type Erasure struct {
+	Rows map[string]int64 // rows deleted or anonymized per table
+	// ArchivedFiles are the archived files holding events of the user's days and types, which
+	// aren't changed; they may hold the user's events
+	ArchivedFiles []string
+}
+
+// Anonymizable is a backend that can anonymize aged events in place, keeping them for counts
+type Anonymizable interface {
+	EventSink
+	// Anonymize replaces the user_id of events older than their type's time in before with its
+	// hash, and removes fields from their data, given as data keys with nested ones joined with
+	// dots; rollup rows of whole hours and days before then get hashed user IDs too. Events and
+	// rows are anonymized once. It returns how many events were anonymized per rule, by event type
+	// or PurgeRuleDefault; ErrAnonymizeRunning while another instance is anonymizing
+	Anonymize(ctx context.Context, before Retention, hash func(string) string, fields []string) (map[string]int64, error)
+}
+
+// ErrAnonymizeRunning is returned by Anonymize while another instance is anonymizing the same table
+var ErrAnonymizeRunning = errors.New("another instance is anonymizing events")
+
+// ErrRollupRunning is returned by RollUp while another instance is rolling up the same table
+var ErrRollupRunning = errors.New("another instance is rolling up events")
+
+// ErrSessionizeRunning is returned by Sessionize while another instance is stitching the same table
+var ErrSessionizeRunning = errors.New("another instance is stitching sessions")
+
+// Retention holds the time before which events are purged, per event type; a zero time keeps them
+type Retention struct {
+	Default time.Time            // types without their own
+	ByType  map[string]time.Time // types with their own
+}
+
+// ErrPurgeRunning is returned by Purge while another instance is purging the same table
+var ErrPurgeRunning = errors.New("another purge of the table is running")
+
+// PurgeRuleDefault counts events purged under Retention.Default
+const PurgeRuleDefault = "default"
+
+// purgeRule selects the events one rule of a Retention purges
+type purgeRule struct {
+	label     string
+	eventType string   // only this type, if set
+	except    []string // otherwise every type but these
+	before    time.Time
+}
+
+// rules returns the rules of r that purge anything
+func (r Retention) rules() []purgeRule {
+	var rules []purgeRule
+	except := make([]string, 0, len(r.ByType))
+	for eventType, before := range r.ByType {
+		except = append(except, eventType)
+		if !before.IsZero() {
+			rules = append(rules, purgeRule{label: eventType, eventType: eventType, before: before})
+		}
+	}
+	sort.Strings(except)
+	if !r.Default.IsZero() {
+		rules = append(rules, purgeRule{label: PurgeRuleDefault, except: except, before: r.Default})
+	}
+	return rules
+}
+
+// label returns the rule eventType is purged under
+func (r Retention) label(eventType string) string {
+	if _, ok := r.ByType[eventType]; ok {
+		return eventType
+	}
+	return PurgeRuleDefault
+}
+
+// oldest returns the earliest time before which every type is purged, and false if some type is kept
+func (r Retention) oldest() (time.Time, bool) {
+	oldest := r.Default
+	for _, before := range r.ByType {
+		if before.IsZero() {
+			return time.Time{}, false
+		}
+		if before.Before(oldest) {
+			oldest = before
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// backends open each storage backend, registered by their files' init functions
+var backends = map[string]func(SinkConfig) (EventSink, error){}
+
+// Backends returns the registered storage backends, sorted
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open connects to a storage backend, creating its table if it doesn't exist
+func Open(backend string, cfg SinkConfig) (EventSink, error) {
+	open, ok := backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q; must be one of %s", backend, strings.Join(Backends(), ", "))
+	}
+	return open(cfg)
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Whether PostgreSQL tables are made TimescaleDB hypertables
+const (
+	TimescaleOff  = "off"  // plain tables
+	TimescaleAuto = "auto" // hypertables when the extension is installed or can be created
+	TimescaleOn   = "on"   // hypertables, failing without the extension
+)
+
+// Timescale sets whether and how the events table is made a TimescaleDB hypertable
+type Timescale struct {
+	Mode          string        // TimescaleOff, TimescaleAuto or TimescaleOn; empty is TimescaleOff
+	ChunkInterval time.Duration // time range of each chunk of a new hypertable
+	CompressAfter time.Duration // chunks older than this are compressed; 0 leaves them uncompressed
+}
+
+// Continuous aggregate refresh policy: buckets up to refreshStart old are recomputed every
+// refreshSchedule, leaving the last refreshEnd, which is still filling, to be read from the table
+const (
+	refreshStart    = "3 days"
+	refreshEnd      = "1 hour"
+	refreshSchedule = "30 minutes"
+)
+
+// errNoTimescale means the timescaledb extension isn't installed and can't be created
+var errNoTimescale = errors.New("the timescaledb extension isn't available")
+
+// setupTimescale makes the table a hypertable, with compression and an hourly continuous aggregate,
+// returning whether it is one; with TimescaleAuto it is left as it is if the extension is missing
+// A plain table is converted in place, moving its rows into chunks, which locks it until done
+func (es *EventStore) setupTimescale(ts Timescale, schema, name, table string) (bool, error) {
+	if ts.Mode == "" || ts.Mode == TimescaleOff {
+		return false, nil
+	}
+	if err := es.createTimescale(); err != nil {
+		if ts.Mode == TimescaleAuto && errors.Is(err, errNoTimescale) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	var hypertable, compressed bool
+	err := es.db.QueryRow(`
+		SELECT true, compression_enabled FROM timescaledb_information.hypertables
+		WHERE hypertable_schema = $1 AND hypertable_name = $2
+	`, schema, name).Scan(&hypertable, &compressed)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("failed to look up hypertables: %w", err)
+	}
+	sourceIndex := indexName(table, "event_source_time")
+	if !hypertable {
+		if err := es.createHypertable(ts, schema, table, sourceIndex); err != nil {
+			return false, fmt.Errorf("failed to make %s a hypertable: %w", table, err)
+		}
+	}
+
+	// Segments group each partition's events, so uniqueness checks on compressed chunks stay cheap
+	if ts.CompressAfter > 0 {
+		if !compressed {
+			_, err = es.db.Exec("ALTER TABLE " + es.table + ` SET (
+				timescaledb.compress,
+				timescaledb.compress_segmentby = 'topic, kafka_partition',
+				timescaledb.compress_orderby = 'timestamp DESC, kafka_offset'
+			)`)
+			if err != nil {
+				return false, fmt.Errorf("failed to enable compression of %s: %w", table, err)
+			}
+		}
+		_, err = es.db.Exec("SELECT add_compression_policy($1::regclass, $2::interval, if_not_exists => true)", es.table, interval(ts.CompressAfter))
+		if err != nil {
+			return false, fmt.Errorf("failed to add compression policy to %s: %w", table, err)
+		}
+	}
+
+	// Hourly counts per type and service, for dashboards that would otherwise scan every event
+	view := pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name+"_hourly")
+	_, err = es.db.Exec(`
+		CREATE MATERIALIZED VIEW IF NOT EXISTS ` + view + `
+		WITH (timescaledb.continuous) AS
+		SELECT time_bucket(INTERVAL '1 hour', timestamp) AS bucket, event_type, service, count(*) AS events
+		FROM ` + es.table + `
+		GROUP BY bucket, event_type, service
+		WITH NO DATA
+	`)
+	if err != nil {
+		return false, fmt.Errorf("failed to create %s_hourly: %w", table, err)
+	}
+	_, err = es.db.Exec(`
+		SELECT add_continuous_aggregate_policy($1::regclass,
+			start_offset => INTERVAL '`+refreshStart+`',
+			end_offset => INTERVAL '`+refreshEnd+`',
+			schedule_interval => INTERVAL '`+refreshSchedule+`',
+			if_not_exists => true)
+	`, view)
+	if err != nil {
+		return false, fmt.Errorf("failed to add refresh policy to %s_hourly: %w", table, err)
+	}
+	return true, nil
+}
+
+// createTimescale creates the timescaledb extension if the server has it
+func (es *EventStore) createTimescale() error {
+	var installed, available bool
+	err := es.db.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb'),
+			EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb')
+	`).Scan(&installed, &available)
+	switch {
+	case err != nil:
+		return fmt.Errorf("failed to look up the timescaledb extension: %w", err)
+	case installed:
+		return nil
+	case !available:
+		return errNoTimescale
+	}
+	if _, err := es.db.Exec("CREATE EXTENSION IF NOT EXISTS timescaledb"); err != nil {
+		// Also the error when timescaledb isn't in shared_preload_libraries
+		return fmt.Errorf("%w: %v", errNoTimescale, err)
+	}
+	return nil
+}
+
+// createHypertable converts the table in one transaction: the primary key and the source index
+// gain the time column, as hypertables require, then its rows are moved into chunks
+func (es *EventStore) createHypertable(ts Timescale, schema, table, sourceIndex string) error {
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var primaryKey string
+	err = tx.QueryRow("SELECT conname FROM pg_constraint WHERE conrelid = $1::regclass AND contype = 'p'", es.table).Scan(&primaryKey)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if primaryKey != "" {
+		if _, err := tx.Exec("ALTER TABLE " + es.table + " DROP CONSTRAINT " + pq.QuoteIdentifier(primaryKey)); err != nil {
+			return err
+		}
+	}
+	statements := []string{
+		"ALTER TABLE " + es.table + " ADD PRIMARY KEY (id, timestamp)",
+		"DROP INDEX IF EXISTS " + pq.QuoteIdentifier(schema) + "." + indexName(table, "event_source"),
+		"CREATE UNIQUE INDEX IF NOT EXISTS " + sourceIndex + " ON " + es.table + "(" + strings.Join(timedSourceColumns, ", ") + ")",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("SELECT create_hypertable($1::regclass, 'timestamp', chunk_time_interval => $2::interval, migrate_data => true)", es.table, interval(ts.ChunkInterval))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// interval formats d as a PostgreSQL interval
+func interval(d time.Duration) string {
+	return fmt.Sprintf("%d seconds", int64(d/time.Second))
+}
@@ -0,0 +1,17 @@
+// Package tenantkeys resolves the keys of TENANT_API_KEYS to the tenants they read, for the REST
+// and gRPC APIs alike
+package tenantkeys
+
+import "crypto/subtle"
+
+// Tenant is the tenant whose key, in keys by key, is key; every key is compared, in constant time,
+// so how long it takes doesn't tell how close a guess was
+func Tenant(keys map[string]string, key string) (string, bool) {
+	tenantID, found := "", false
+	for candidate, tenant := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(candidate)) == 1 {
+			tenantID, found = tenant, true
+		}
+	}
+	return tenantID, found && key != ""
+}
@@ -0,0 +1,143 @@
+// Package validation checks events against schemas defined in a JSON file before they are stored
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"nexus-analytics-service/internal/consumer"
+)
+
+// Rules a violation can break
+const (
+	RuleRequiredField    = "required_field"     // a top-level field is missing or empty
+	RuleUnknownEventType = "unknown_event_type" // event_type isn't listed in the schemas
+	RuleRequiredData     = "required_data"      // a data field the event type requires is missing
+	RuleDataType         = "data_type"          // a data field has the wrong JSON type
+)
+
+// eventFields are the top-level fields that can be required
+var eventFields = map[string]func(*consumer.Event) string{
+	"event_id":   func(e *consumer.Event) string { return e.EventID },
+	"event_type": func(e *consumer.Event) string { return e.EventType },
+	"user_id":    func(e *consumer.Event) string { return e.UserID },
+	"service":    func(e *consumer.Event) string { return e.Service },
+	"timestamp":  func(e *consumer.Event) string { return e.Timestamp },
+	"tenant_id":  func(e *consumer.Event) string { return e.TenantID },
+}
+
+// dataTypes are the JSON types a data field can be declared as
+var dataTypes = map[string]bool{"string": true, "number": true, "boolean": true, "object": true, "array": true}
+
+// EventSchema describes the data of one event type
+type EventSchema struct {
+	// Data maps data fields to their JSON type; fields not listed are allowed with any type
+	Data map[string]string `json:"data"`
+	// RequiredData lists data fields that must be present
+	RequiredData []string `json:"required_data"`
+}
+
+// Schemas is the schema file: the fields every event needs and the allowed event types
+type Schemas struct {
+	Required []string `json:"required"`
+	// EventTypes lists the allowed event types; any type is allowed when it is empty
+	EventTypes map[string]EventSchema `json:"event_types"`
+}
+
+// Violation is one way an event broke its schema
+type Violation struct {
+	Rule    string
+	Field   string
+	Message string
+}
+
+// Validator checks events against schemas
+type Validator struct {
+	schemas Schemas
+}
+
+// Load reads and checks a schema file
+func Load(path string) (*Validator, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event schemas: %w", err)
+	}
+	var schemas Schemas
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schemas); err != nil {
+		return nil, fmt.Errorf("invalid event schemas in %s: %w", path, err)
+	}
+
+	for _, field := range schemas.Required {
+		if eventFields[field] == nil {
+			return nil, fmt.Errorf("invalid event schemas in %s: unknown required field %q", path, field)
+		}
+	}
+	for eventType, schema := range schemas.EventTypes {
+		for field, dataType := range schema.Data {
+			if !dataTypes[dataType] {
+				return nil, fmt.Errorf("invalid event schemas in %s: %s data field %s has unknown type %q", path, eventType, field, dataType)
+			}
+		}
+	}
+	return &Validator{schemas: schemas}, nil
+}
+
+// Validate returns every way event breaks the schemas, or nothing if it is valid
+func (v *Validator) Validate(event *consumer.Event) []Violation {
+	var violations []Violation
+	for _, field := range v.schemas.Required {
+		if eventFields[field](event) == "" {
+			violations = append(violations, Violation{Rule: RuleRequiredField, Field: field, Message: field + " is required"})
+		}
+	}
+
+	if len(v.schemas.EventTypes) == 0 {
+		return violations
+	}
+	schema, ok := v.schemas.EventTypes[event.EventType]
+	if !ok {
+		return append(violations, Violation{Rule: RuleUnknownEventType, Field: "event_type", Message: fmt.Sprintf("event type %q is not allowed", event.EventType)})
+	}
+	for _, field := range schema.RequiredData {
+		if _, ok := event.Data[field]; !ok {
+			violations = append(violations, Violation{Rule: RuleRequiredData, Field: "data." + field, Message: "data." + field + " is required"})
+		}
+	}
+	fields := make([]string, 0, len(schema.Data))
+	for field := range schema.Data {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value, ok := event.Data[field]
+		if !ok || value == nil {
+			continue
+		}
+		if want, got := schema.Data[field], jsonType(value); got != want {
+			violations = append(violations, Violation{Rule: RuleDataType, Field: "data." + field, Message: fmt.Sprintf("data.%s must be %s, not %s", field, want, got)})
+		}
+	}
+	return violations
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "unknown"
+}
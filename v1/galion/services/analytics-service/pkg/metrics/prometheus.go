@@ -2,6 +2,9 @@
 package metrics
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -35,11 +38,439 @@ var (
 		[]string{"event_type", "error_type"},
 	)
 
-	// ActiveUsers tracks unique active users in the last hour
+	// ActiveUsers tracks unique active users in the last hour, estimated across replicas
 	ActiveUsers = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "analytics_active_users",
-			Help: "Number of unique active users in the last hour",
+			Help: "Estimated number of unique active users in the last hour",
+		},
+	)
+
+	// DailyActiveUsers, WeeklyActiveUsers and MonthlyActiveUsers track unique active users over
+	// rolling windows, estimated across replicas
+	DailyActiveUsers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_daily_active_users",
+			Help: "Estimated number of unique active users in the last 24 hours",
+		},
+	)
+	WeeklyActiveUsers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_weekly_active_users",
+			Help: "Estimated number of unique active users in the last 7 days",
+		},
+	)
+	MonthlyActiveUsers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_monthly_active_users",
+			Help: "Estimated number of unique active users in the last 30 days",
+		},
+	)
+
+	// EventBatchSize measures how many events are saved per batch by write method
+	EventBatchSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_event_batch_size",
+			Help:    "Number of events saved per batch",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		},
+		[]string{"method"},
+	)
+
+	// EventBatchFlushDuration measures batch write time by write method and result
+	EventBatchFlushDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_event_batch_flush_duration_seconds",
+			Help:    "Time to save a batch of events in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "result"},
+	)
+
+	// EventBatchRows counts events written in batches by write method and result
+	EventBatchRows = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_event_batch_rows_total",
+			Help: "Total events written in batches",
+		},
+		[]string{"method", "result"},
+	)
+
+	// EventRetries counts failed events handed to the handler again
+	EventRetries = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_event_retries_total",
+			Help: "Total number of failed events retried",
+		},
+	)
+
+	// DuplicatesDropped counts events dropped because another with the same event_id was seen
+	DuplicatesDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_duplicates_dropped_total",
+			Help: "Total number of events dropped because their event_id was already seen",
+		},
+		[]string{"event_type"},
+	)
+
+	// DuplicateEvents counts redelivered events not stored again
+	DuplicateEvents = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_events_duplicate_total",
+			Help: "Total number of redelivered events dropped because they were already stored",
+		},
+	)
+
+	// DatabaseRetries counts batch writes retried after a transient database error
+	DatabaseRetries = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_database_retries_total",
+			Help: "Total number of batch writes retried after a transient database error",
+		},
+	)
+
+	// AvroDecoded counts Avro messages decoded with a schema from the schema registry
+	AvroDecoded = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_avro_messages_decoded_total",
+			Help: "Total number of Avro messages decoded using the schema registry",
+		},
+	)
+
+	// ValidationViolations counts schema rules broken by ingested events
+	ValidationViolations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_validation_violations_total",
+			Help: "Total number of event schema violations by rule",
+		},
+		[]string{"rule", "event_type"},
+	)
+
+	// DeadLetters counts messages published to the dead-letter topic by reason
+	DeadLetters = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_dead_letters_total",
+			Help: "Total number of messages published to the dead-letter topic",
+		},
+		[]string{"reason"},
+	)
+
+	// EventsSkipped counts messages given up on without a dead-letter topic by reason
+	EventsSkipped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_events_skipped_total",
+			Help: "Total number of messages skipped after failing processing",
+		},
+		[]string{"reason"},
+	)
+
+	// DeadLetterFailures counts messages that couldn't be published to the dead-letter topic
+	DeadLetterFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_dead_letter_failures_total",
+			Help: "Total number of messages that failed to be published to the dead-letter topic",
+		},
+	)
+
+	// EventsEnriched counts events given derived fields by enricher
+	EventsEnriched = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_events_enriched_total",
+			Help: "Total number of events given derived fields",
+		},
+		[]string{"enricher"},
+	)
+
+	// PIIFields counts fields hashed or removed from events by action and field
+	PIIFields = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_pii_fields_total",
+			Help: "Total number of personal data fields hashed or removed from events",
+		},
+		[]string{"action", "field"},
+	)
+
+	// OversizedEvents counts events over the size limit by what was done with them
+	OversizedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_events_oversized_total",
+			Help: "Total number of events over EVENT_MAX_BYTES, truncated or rejected",
+		},
+		[]string{"action"},
+	)
+
+	// ConsumerCommittedOffset tracks the group's committed offset of each partition this instance consumes
+	ConsumerCommittedOffset = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_consumer_committed_offset",
+			Help: "Next offset the consumer group will read from the partition",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	// ConsumerHighWatermark tracks the end of each partition this instance consumes
+	ConsumerHighWatermark = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_consumer_high_watermark",
+			Help: "Offset the next message produced to the partition will get",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	// ConsumerLag tracks how many messages of each partition are not yet committed
+	ConsumerLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_consumer_lag",
+			Help: "Messages in the partition past the committed offset",
+		},
+		[]string{"topic", "partition"},
+	)
+
+	// EventsPurged counts events deleted past their retention, or found by dry runs, by retention rule
+	EventsPurged = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_events_purged_total",
+			Help: "Total number of events past their retention deleted, or found by dry runs",
+		},
+		[]string{"rule", "dry_run"},
+	)
+
+	// EventsAnonymized counts aged events whose user IDs were hashed and personal data removed, by rule
+	EventsAnonymized = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_events_anonymized_total",
+			Help: "Total number of aged events anonymized",
+		},
+		[]string{"rule"},
+	)
+
+	// AnonymizeDuration measures anonymizations of aged events by result
+	AnonymizeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_anonymize_duration_seconds",
+			Help:    "Duration of anonymizing aged events in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		},
+		[]string{"result"},
+	)
+
+	// UserErasures counts requests to erase a user's events by mode and result
+	UserErasures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_user_erasures_total",
+			Help: "Total number of requests to erase a user's events",
+		},
+		[]string{"mode", "result"},
+	)
+
+	// PurgeDuration measures retention purges by result
+	PurgeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_purge_duration_seconds",
+			Help:    "Duration of retention purges in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 8),
+		},
+		[]string{"result"},
+	)
+
+	// LastPurge tracks when a retention purge last succeeded
+	LastPurge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_last_purge_timestamp_seconds",
+			Help: "Unix time of the last successful retention purge",
+		},
+	)
+
+	// EventsArchived counts events exported to cold storage
+	EventsArchived = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_events_archived_total",
+			Help: "Total number of events exported to cold storage",
+		},
+	)
+
+	// ArchiveFailures counts days that failed to be archived
+	ArchiveFailures = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_archive_failures_total",
+			Help: "Total number of days whose export to cold storage failed",
+		},
+	)
+
+	// LastArchive tracks when a day was last archived
+	LastArchive = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_last_archive_timestamp_seconds",
+			Help: "Unix time a day's events were last archived",
+		},
+	)
+
+	// RollupDuration measures each rollup step by result
+	RollupDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_rollup_duration_seconds",
+			Help:    "Duration of rolling up a range of hours in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.05, 4, 8),
+		},
+		[]string{"result"},
+	)
+
+	// RollupLag tracks how far behind the rollups are
+	RollupLag = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_rollup_lag_seconds",
+			Help: "Time since the end of the last hour rolled up",
+		},
+	)
+
+	// SessionizeDuration measures each step of stitching sessions by result
+	SessionizeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_sessionize_duration_seconds",
+			Help:    "Duration of stitching a range of events into sessions in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.05, 4, 8),
+		},
+		[]string{"result"},
+	)
+
+	// SessionizeLag tracks how far behind session stitching is
+	SessionizeLag = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_sessionize_lag_seconds",
+			Help: "Time since the end of the last range of events stitched into sessions",
+		},
+	)
+
+	// GRPCRequestDuration measures gRPC calls by method and status code
+	GRPCRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_grpc_request_duration_seconds",
+			Help:    "Duration of gRPC calls in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "code"},
+	)
+
+	// ReportRefreshDuration measures report view refreshes by report and result
+	ReportRefreshDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_report_refresh_duration_seconds",
+			Help:    "Duration of refreshing a report's materialized view in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.05, 4, 8),
+		},
+		[]string{"report", "result"},
+	)
+
+	// ReportStaleness tracks how long ago each report was refreshed
+	ReportStaleness = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "analytics_report_staleness_seconds",
+			Help: "Time since each report's materialized view was last refreshed",
+		},
+		[]string{"report"},
+	)
+
+	// CohortComputeDuration measures computing the cohort table by result
+	CohortComputeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_cohort_compute_duration_seconds",
+			Help:    "Duration of computing the retention cohorts in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.05, 4, 8),
+		},
+		[]string{"result"},
+	)
+
+	// CohortStaleness tracks how long ago the cohorts were computed
+	CohortStaleness = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_cohort_staleness_seconds",
+			Help: "Time since the retention cohorts were last computed",
+		},
+	)
+
+	// SearchIndexed counts events indexed into the search cluster
+	SearchIndexed = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_search_indexed_total",
+			Help: "Total number of events indexed for search",
+		},
+	)
+
+	// SearchDropped counts stored events that weren't indexed for search, by reason
+	SearchDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_search_dropped_total",
+			Help: "Total number of stored events not indexed for search",
+		},
+		[]string{"reason"},
+	)
+
+	// SearchBulkDuration measures bulk requests to the search cluster by result
+	SearchBulkDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_search_bulk_duration_seconds",
+			Help:    "Duration of bulk indexing requests in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+
+	// SearchQueueLength tracks events waiting to be indexed for search
+	SearchQueueLength = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_search_queue_length",
+			Help: "Events waiting to be indexed for search",
+		},
+	)
+
+	// LiveSubscribers tracks the live event feeds open on this instance
+	LiveSubscribers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_live_subscribers",
+			Help: "Live event feeds open",
+		},
+	)
+
+	// LiveDropped counts events not sent to a live feed because its buffer was full
+	LiveDropped = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_live_dropped_total",
+			Help: "Total number of events not sent to a live feed whose client was too slow",
+		},
+	)
+
+	// BigQueryExported counts events streamed into the BigQuery table
+	BigQueryExported = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "analytics_bigquery_exported_total",
+			Help: "Total number of events exported to BigQuery",
+		},
+	)
+
+	// BigQueryDropped counts stored events that weren't exported to BigQuery, by reason
+	BigQueryDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "analytics_bigquery_dropped_total",
+			Help: "Total number of stored events not exported to BigQuery",
+		},
+		[]string{"reason"},
+	)
+
+	// BigQueryInsertDuration measures streaming inserts into BigQuery by result
+	BigQueryInsertDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "analytics_bigquery_insert_duration_seconds",
+			Help:    "Duration of BigQuery streaming inserts in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"result"},
+	)
+
+	// BigQueryQueueLength tracks events waiting to be exported to BigQuery
+	BigQueryQueueLength = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "analytics_bigquery_queue_length",
+			Help: "Events waiting to be exported to BigQuery",
 		},
 	)
 
@@ -67,3 +498,267 @@ func UpdateEventsStored(count int64) {
 	EventsStored.Set(float64(count))
 }
 
+// UpdateActiveUsers updates the estimated active users in the last hour
+func UpdateActiveUsers(count int64) {
+	ActiveUsers.Set(float64(count))
+}
+
+// UpdateRollingActiveUsers updates the estimated active users in the last 24 hours, 7 days and 30 days
+func UpdateRollingActiveUsers(daily, weekly, monthly int64) {
+	DailyActiveUsers.Set(float64(daily))
+	WeeklyActiveUsers.Set(float64(weekly))
+	MonthlyActiveUsers.Set(float64(monthly))
+}
+
+// RecordBatchFlush records a saved (or failed) batch of events written with method
+func RecordBatchFlush(method string, size int, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	EventBatchSize.WithLabelValues(method).Observe(float64(size))
+	EventBatchFlushDuration.WithLabelValues(method, result).Observe(duration.Seconds())
+	EventBatchRows.WithLabelValues(method, result).Add(float64(size))
+}
+
+// RecordEventRetry records a failed event being retried
+func RecordEventRetry() {
+	EventRetries.Inc()
+}
+
+// RecordDuplicateDropped records an event dropped for a repeated event_id
+func RecordDuplicateDropped(eventType string) {
+	DuplicatesDropped.WithLabelValues(eventType).Inc()
+}
+
+// RecordDuplicateEvents records redelivered events that were already stored
+func RecordDuplicateEvents(count int64) {
+	DuplicateEvents.Add(float64(count))
+}
+
+// RecordDatabaseRetry records a batch write retried after a transient database error
+func RecordDatabaseRetry() {
+	DatabaseRetries.Inc()
+}
+
+// RecordRollup records rolling up a range of hours
+func RecordRollup(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	RollupDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// UpdateRollupLag sets how long ago the last hour rolled up ended
+func UpdateRollupLag(lag time.Duration) {
+	RollupLag.Set(lag.Seconds())
+}
+
+// RecordSessionize records stitching a range of events into sessions
+func RecordSessionize(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	SessionizeDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// UpdateSessionizeLag sets how long ago the last range stitched into sessions ended
+func UpdateSessionizeLag(lag time.Duration) {
+	SessionizeLag.Set(lag.Seconds())
+}
+
+// RecordGRPCRequest records a gRPC call to method, as in /package.Service/Method, and its status code
+func RecordGRPCRequest(method, code string, duration time.Duration) {
+	GRPCRequestDuration.WithLabelValues(method, code).Observe(duration.Seconds())
+}
+
+// RecordReportRefresh records refreshing a report's materialized view
+func RecordReportRefresh(report string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	ReportRefreshDuration.WithLabelValues(report, result).Observe(duration.Seconds())
+}
+
+// UpdateReportStaleness sets how long ago a report was refreshed
+func UpdateReportStaleness(report string, staleness time.Duration) {
+	ReportStaleness.WithLabelValues(report).Set(staleness.Seconds())
+}
+
+// RecordCohortComputation records computing the cohort table
+func RecordCohortComputation(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	CohortComputeDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// UpdateCohortStaleness sets how long ago the cohorts were computed
+func UpdateCohortStaleness(staleness time.Duration) {
+	CohortStaleness.Set(staleness.Seconds())
+}
+
+// RecordSearchBulk records a bulk request to the search cluster and the events it indexed
+func RecordSearchBulk(indexed int, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	SearchBulkDuration.WithLabelValues(result).Observe(duration.Seconds())
+	SearchIndexed.Add(float64(indexed))
+}
+
+// RecordSearchDropped records stored events not indexed for search
+func RecordSearchDropped(reason string, count int) {
+	SearchDropped.WithLabelValues(reason).Add(float64(count))
+}
+
+// UpdateSearchQueueLength sets how many events are waiting to be indexed for search
+func UpdateSearchQueueLength(n int) {
+	SearchQueueLength.Set(float64(n))
+}
+
+// UpdateLiveSubscribers sets how many live event feeds are open
+func UpdateLiveSubscribers(n int) {
+	LiveSubscribers.Set(float64(n))
+}
+
+// RecordLiveDropped records an event not sent to a live feed whose buffer was full
+func RecordLiveDropped() {
+	LiveDropped.Inc()
+}
+
+// RecordBigQueryInsert records a streaming insert into BigQuery and the events it exported
+func RecordBigQueryInsert(exported int, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	BigQueryInsertDuration.WithLabelValues(result).Observe(duration.Seconds())
+	BigQueryExported.Add(float64(exported))
+}
+
+// RecordBigQueryDropped records stored events not exported to BigQuery
+func RecordBigQueryDropped(reason string, count int) {
+	BigQueryDropped.WithLabelValues(reason).Add(float64(count))
+}
+
+// UpdateBigQueryQueueLength sets how many events are waiting to be exported to BigQuery
+func UpdateBigQueryQueueLength(n int) {
+	BigQueryQueueLength.Set(float64(n))
+}
+
+// RecordAvroDecoded records an Avro message decoded using the schema registry
+func RecordAvroDecoded() {
+	AvroDecoded.Inc()
+}
+
+// RecordValidationViolation records an event breaking a schema rule
+func RecordValidationViolation(rule, eventType string) {
+	ValidationViolations.WithLabelValues(rule, eventType).Inc()
+}
+
+// RecordEnrichment records an event given derived fields by enricher
+func RecordEnrichment(enricher string) {
+	EventsEnriched.WithLabelValues(enricher).Inc()
+}
+
+// RecordPIIField records a field hashed or removed from an event
+func RecordPIIField(action, field string) {
+	PIIFields.WithLabelValues(action, field).Inc()
+}
+
+// RecordOversizedEvent records an event over the size limit being truncated or rejected
+func RecordOversizedEvent(action string) {
+	OversizedEvents.WithLabelValues(action).Inc()
+}
+
+// RecordDeadLetter records a message published to the dead-letter topic
+func RecordDeadLetter(reason string) {
+	DeadLetters.WithLabelValues(reason).Inc()
+}
+
+// RecordDeadLetterFailure records a message that couldn't be dead-lettered
+func RecordDeadLetterFailure() {
+	DeadLetterFailures.Inc()
+}
+
+// RecordEventSkipped records a message skipped after failing processing
+func RecordEventSkipped(reason string) {
+	EventsSkipped.WithLabelValues(reason).Inc()
+}
+
+// RecordEventsPurged records events deleted under a retention rule, or found by a dry run
+func RecordEventsPurged(rule string, dryRun bool, count int64) {
+	EventsPurged.WithLabelValues(rule, strconv.FormatBool(dryRun)).Add(float64(count))
+}
+
+// RecordPurge records a finished retention purge
+func RecordPurge(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	} else {
+		LastPurge.SetToCurrentTime()
+	}
+	PurgeDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// RecordEventsAnonymized records aged events anonymized under a rule
+func RecordEventsAnonymized(rule string, count int64) {
+	EventsAnonymized.WithLabelValues(rule).Add(float64(count))
+}
+
+// RecordAnonymization records a finished anonymization of aged events
+func RecordAnonymization(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	AnonymizeDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// RecordUserErasure records a request to erase a user's events
+func RecordUserErasure(mode string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	UserErasures.WithLabelValues(mode, result).Inc()
+}
+
+// RecordArchive records a day's events exported to cold storage, or the failure to
+func RecordArchive(events int64, err error) {
+	if err != nil {
+		ArchiveFailures.Inc()
+		return
+	}
+	EventsArchived.Add(float64(events))
+	LastArchive.SetToCurrentTime()
+}
+
+// UpdatePartitionOffsets sets a partition's offsets and lag; committed is negative before the group's first commit,
+// when only the high watermark is known
+func UpdatePartitionOffsets(topic string, partition int32, committed, highWatermark int64) {
+	labels := prometheus.Labels{"topic": topic, "partition": strconv.Itoa(int(partition))}
+	ConsumerHighWatermark.With(labels).Set(float64(highWatermark))
+	if committed < 0 {
+		ConsumerCommittedOffset.Delete(labels)
+		ConsumerLag.Delete(labels)
+		return
+	}
+	ConsumerCommittedOffset.With(labels).Set(float64(committed))
+	ConsumerLag.With(labels).Set(float64(max(highWatermark-committed, 0)))
+}
+
+// ForgetPartition removes the offsets of a partition no longer consumed by this instance
+func ForgetPartition(topic string, partition int32) {
+	labels := prometheus.Labels{"topic": topic, "partition": strconv.Itoa(int(partition))}
+	ConsumerCommittedOffset.Delete(labels)
+	ConsumerHighWatermark.Delete(labels)
+	ConsumerLag.Delete(labels)
+}
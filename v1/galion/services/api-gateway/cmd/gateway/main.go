@@ -3,241 +3,370 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
-	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	"github.com/redis/go-redis/v9"
-	"github.com/rs/cors"
 
-	"nexus-api-gateway/internal/auth"
+	"nexus-api-gateway/internal/admin"
+	"nexus-api-gateway/internal/audit"
+	"nexus-api-gateway/internal/certs"
+	"nexus-api-gateway/internal/config"
+	"nexus-api-gateway/internal/gateway"
 	"nexus-api-gateway/internal/middleware"
-	"nexus-api-gateway/internal/proxy"
-	"nexus-api-gateway/pkg/logger"
-)
+	"nexus-api-gateway/internal/redisclient"
+	"nexus-api-gateway/internal/reporting"
+	"nexus-api-gateway/internal/restart"
+	"nexus-api-gateway/pkg/metrics"
 
-// Config holds application configuration
-type Config struct {
-	Port                   string
-	Environment            string
-	Debug                  bool
-	JWTSecretKey          string
-	JWTAlgorithm          string
-	AuthServiceURL        string
-	UserServiceURL        string
-	ContentServiceURL     string
-	RedisURL              string
-	RateLimitEnabled      bool
-	RateLimitPerMinute    int
-	AllowedOrigins        []string
-}
+	"nexus-common/logger"
+	commonmetrics "nexus-common/metrics"
+	"nexus-common/shutdown"
+	"nexus-common/version"
+)
 
 func main() {
 	// Load environment variables
 	godotenv.Load()
 	
-	// Load configuration
-	config := loadConfig()
+	// Load configuration (flags > environment > config file > defaults)
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.ValidateOnly {
+		for _, warning := range cfg.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+		cfg.Print(os.Stdout)
+		os.Exit(0)
+	}
 	
 	// Initialize logger
-	log := logger.New(config.Debug)
-	log.Info("Starting Nexus API Gateway")
-	log.Info("Environment: %s", config.Environment)
+	log := logger.New(cfg.Debug)
+	for _, warning := range cfg.Warnings {
+		log.Warn("Configuration: %s", warning)
+	}
+	if err := addLogOutputs(log, cfg); err != nil {
+		log.Fatal("Failed to configure log outputs: %v", err)
+	}
+	buildInfo := version.Get()
+	log.Info("Starting Nexus API Gateway %s (%s, built %s)", buildInfo.Version, buildInfo.GitSHA, buildInfo.BuildTime)
+	commonmetrics.RegisterBuildInfo("gateway")
+	metrics.SetLatencyBuckets(cfg.MetricsLatencyBuckets)
+	metrics.SetMaxPathsPerRoute(cfg.MetricsMaxPathsPerRoute)
+	log.Info("Environment: %s", cfg.Environment)
 	
-	// Initialize Redis client
-	redisOpts, err := redis.ParseURL(config.RedisURL)
+	// Initialize error reporting
+	if err := reporting.Init(reporting.Config{
+		DSN:         cfg.SentryDSN,
+		Environment: cfg.SentryEnvironment,
+		Release:     cfg.SentryRelease,
+		SampleRate:  cfg.SentrySampleRate,
+	}); err != nil {
+		log.Error("Error reporting disabled: %v", err)
+	} else if cfg.SentryDSN != "" {
+		log.Info("Error reporting enabled (sample rate %.2f)", cfg.SentrySampleRate)
+	}
+	
+	// Initialize Redis client (standalone, Sentinel or Cluster)
+	redisConfig := gateway.RedisConfig(cfg)
+	redisClient, err := redisclient.New(redisConfig)
 	if err != nil {
-		log.Fatal("Failed to parse Redis URL: %v", err)
+		log.Fatal("Failed to create Redis client: %v", err)
 	}
-	redisClient := redis.NewClient(redisOpts)
+	metrics.RegisterRedisPool(redisClient)
 	
 	// Test Redis connection
 	ctx := context.Background()
 	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Warn("Failed to connect to Redis: %v (rate limiting disabled)", err)
-		config.RateLimitEnabled = false
+		log.Warn("Failed to connect to Redis (%s): %v (rate limiting disabled)", redisclient.Describe(redisConfig), err)
+		cfg.RateLimitEnabled = false
+	} else {
+		log.Info("Connected to Redis (%s)", redisclient.Describe(redisConfig))
+	}
+	
+	// Build the router and middleware chain
+	gw, err := gateway.New(cfg, redisClient, log)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	
+	// Initialize admin audit trail (Postgres in production, in-memory otherwise)
+	var auditStore audit.Store
+	if cfg.AuditDatabaseURL != "" {
+		pgStore, err := audit.NewPostgresStore(cfg.AuditDatabaseURL)
+		if err != nil {
+			log.Fatal("Failed to initialize audit store: %v", err)
+		}
+		defer pgStore.Close()
+		auditStore = pgStore
+	} else {
+		if cfg.Environment == "production" {
+			log.Warn("AUDIT_DATABASE_URL not set, admin audit trail is kept in memory only")
+		}
+		auditStore = audit.NewMemoryStore()
+	}
+	auditRecorder := audit.NewRecorder(auditStore, log)
+	
+	// Zero-downtime restarts hand the listeners to a new process on SIGHUP
+	restarter, err := restart.New(cfg.GracefulRestartEnabled, cfg.PIDFile, cfg.RestartUpgradeTimeout, log)
+	if err != nil {
+		log.Fatal("%v", err)
+	}
+	defer restarter.Stop()
+	
+	// Admin/ops endpoints run on a separate internal listener, never the public one (requires admin API key)
+	operatorKeys, err := middleware.ParseAdminOperatorKeys(cfg.AdminOperatorKeys)
+	if err != nil {
+		log.Fatal("Invalid admin operator keys: %v", err)
+	}
+	adminServer := admin.NewServer(cfg.AdminHost, cfg.AdminPort, cfg.AdminAPIKey, operatorKeys, log)
+	adminRouter := adminServer.Router()
+	adminRouter.HandleFunc("/health/deep", gw.Health.DeepHandler).Methods("GET")
+	adminRouter.HandleFunc("/admin/config", admin.ConfigHandler(cfg)).Methods("GET")
+	adminRouter.HandleFunc("/admin/reload", admin.ReloadHandler(restarter, auditRecorder)).Methods("POST")
+	adminRouter.HandleFunc("/admin/flags", gw.Flags.Handler).Methods("GET")
+	adminRouter.HandleFunc("/admin/routes", admin.RoutesHandler(gw.Routes, gw.Upstreams)).Methods("GET")
+	adminRouter.HandleFunc("/admin/audit", auditRecorder.ListHandler).Methods("GET")
+	adminRouter.HandleFunc("/admin/ratelimits/{client}", admin.RateLimitResetHandler(gw.RateLimiter, auditRecorder)).Methods("DELETE")
+	adminRouter.HandleFunc("/admin/captures", gw.Capturer.ListHandler).Methods("GET")
+	adminRouter.HandleFunc("/admin/captures/recordings", admin.RecordingsHandler(gw.Recordings)).Methods("GET")
+	adminRouter.HandleFunc("/admin/captures/recordings/{route}", admin.StartRecordingHandler(gw.Recordings, gw.Upstreams, auditRecorder)).Methods("PUT")
+	adminRouter.HandleFunc("/admin/captures/recordings/{route}", admin.StopRecordingHandler(gw.Recordings, auditRecorder)).Methods("DELETE")
+	adminRouter.HandleFunc("/admin/captures/recordings/{route}/requests", admin.RecordedRequestsHandler(gw.Recordings)).Methods("GET")
+	adminRouter.HandleFunc("/admin/captures/recordings/{route}/requests", admin.ClearRecordedRequestsHandler(gw.Recordings, auditRecorder)).Methods("DELETE")
+	adminRouter.HandleFunc("/admin/captures/recordings/{route}/replay", admin.ReplayHandler(gw.Recordings, gw.Upstreams, cfg.CaptureReplayTargets, auditRecorder)).Methods("POST")
+	adminRouter.HandleFunc("/admin/captures/{id}", gw.Capturer.GetHandler).Methods("GET")
+	if gw.Webhooks != nil {
+		adminRouter.HandleFunc("/admin/webhooks/{id}", gw.Webhooks.StatusHandler).Methods("GET")
+	}
+	connTracker := &admin.ConnTracker{}
+	adminRouter.HandleFunc("/admin/stats", admin.StatsHandler(connTracker, gw.Upstreams)).Methods("GET")
+	if cfg.AdminAPIKey != "" || len(operatorKeys) > 0 {
+		adminListener, err := restarter.Listen("tcp", adminServer.Addr())
+		if err != nil {
+			log.Fatal("Failed to listen on admin port: %v", err)
+		}
+		adminServer.Start(adminListener)
 	} else {
-		log.Info("Connected to Redis")
-	}
-	
-	// Initialize JWT validator
-	jwtValidator := auth.NewJWTValidator(config.JWTSecretKey, config.JWTAlgorithm)
-	
-	// Initialize middleware
-	authMiddleware := middleware.NewAuthMiddleware(jwtValidator, log)
-	rateLimiter := middleware.NewRateLimiter(redisClient, config.RateLimitPerMinute, config.RateLimitEnabled)
-	
-	// Initialize proxy
-	serviceProxy := proxy.NewServiceProxy(log)
-	
-	// Create router
-	router := mux.NewRouter()
-	
-	// Health check endpoint (no auth required)
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"healthy","service":"api-gateway"}`))
-	}).Methods("GET")
-	
-	// Metrics endpoint for Prometheus (no auth required)
-	router.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("# HELP api_gateway_up API Gateway status\n"))
-		w.Write([]byte("# TYPE api_gateway_up gauge\n"))
-		w.Write([]byte("api_gateway_up 1\n"))
-	}).Methods("GET")
-	
-	// Auth service routes (no auth required for login/register)
-	// Handle all HTTP methods including OPTIONS for CORS preflight
-	authRouter := router.PathPrefix("/api/v1/auth").Subrouter()
-	authRouter.PathPrefix("").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		serviceProxy.ProxyRequest(w, r, config.AuthServiceURL)
-	}).Methods("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
-	
-	// User service routes (require authentication)
-	// Handle all HTTP methods including OPTIONS for CORS preflight
-	userRouter := router.PathPrefix("/api/v1/users").Subrouter()
-	userRouter.Use(authMiddleware.Require())
-	userRouter.PathPrefix("").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		serviceProxy.ProxyRequest(w, r, config.UserServiceURL)
-	}).Methods("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
-	
-	// Content service routes (require authentication)
-	// Handle all HTTP methods including OPTIONS for CORS preflight
-	contentRouter := router.PathPrefix("/api/v1/content").Subrouter()
-	contentRouter.Use(authMiddleware.Require())
-	contentRouter.PathPrefix("").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		serviceProxy.ProxyRequest(w, r, config.ContentServiceURL)
-	}).Methods("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
-	
-	// Apply global middleware
-	handler := middleware.RequestID(router)
-	handler = middleware.Logging(log)(handler)
-	handler = rateLimiter.Middleware()(handler)
-	
-	// Apply CORS
-	corsHandler := cors.New(cors.Options{
-		AllowedOrigins:   config.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		AllowCredentials: true,
-		MaxAge:           300, // Cache preflight requests for 5 minutes
-	}).Handler(handler)
+		log.Warn("Neither ADMIN_API_KEY nor ADMIN_OPERATOR_KEYS set, admin server disabled")
+	}
+	
+	// Kubernetes preStop hook, on its own port so it is never reachable through the public listener
+	var preStopServer *http.Server
+	if cfg.PreStopPort != "" {
+		preStopRouter := http.NewServeMux()
+		preStopRouter.HandleFunc("/prestop", gw.Health.PreStopHandler(cfg.ShutdownDelay))
+		preStopServer = &http.Server{
+			Addr:              ":" + cfg.PreStopPort,
+			Handler:           preStopRouter,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		preStopListener, err := restarter.Listen("tcp", preStopServer.Addr)
+		if err != nil {
+			log.Fatal("Failed to listen on preStop port %s: %v", cfg.PreStopPort, err)
+		}
+		go func() {
+			log.Info("PreStop hook listening on port %s", cfg.PreStopPort)
+			if err := preStopServer.Serve(preStopListener); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Failed to start preStop server: %v", err)
+			}
+		}()
+	}
 	
 	// Create HTTP server
 	server := &http.Server{
-		Addr:         ":" + config.Port,
-		Handler:      corsHandler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              ":" + cfg.Port,
+		Handler:           gw.Handler,
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		MaxHeaderBytes:    cfg.ServerMaxHeaderBytes,
+		ConnState:         connTracker.ConnState,
+	}
+	
+	// Serve HTTPS as well when certificates are configured
+	var httpsServer *http.Server
+	if cfg.TLSEnabled() {
+		certCtx, stopCertWatch := context.WithCancel(context.Background())
+		defer stopCertWatch()
+		tlsConfig, acmeChallenges, err := certs.NewTLSConfig(certCtx, certs.Config{
+			CertFile:         cfg.TLSCertFile,
+			KeyFile:          cfg.TLSKeyFile,
+			ReloadInterval:   cfg.TLSReloadInterval,
+			ACMEDomains:      cfg.TLSACMEDomains,
+			ACMEEmail:        cfg.TLSACMEEmail,
+			ACMECacheDir:     cfg.TLSACMECacheDir,
+			ACMEDirectoryURL: cfg.TLSACMEDirectoryURL,
+		}, log)
+		if err != nil {
+			log.Fatal("Failed to configure TLS: %v", err)
+		}
+		
+		// ACME HTTP-01 challenges arrive on the plain HTTP listener
+		server.Handler = acmeChallenges(server.Handler)
+		
+		httpsServer = &http.Server{
+			Addr:              ":" + cfg.TLSPort,
+			Handler:           gw.Handler,
+			TLSConfig:         tlsConfig,
+			ReadTimeout:       server.ReadTimeout,
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+			WriteTimeout:      server.WriteTimeout,
+			IdleTimeout:       server.IdleTimeout,
+			MaxHeaderBytes:    server.MaxHeaderBytes,
+			ConnState:         connTracker.ConnState,
+		}
+		tlsListener, err := restarter.Listen("tcp", httpsServer.Addr)
+		if err != nil {
+			log.Fatal("Failed to listen on TLS port %s: %v", cfg.TLSPort, err)
+		}
+		go func() {
+			log.Info("API Gateway listening for HTTPS on port %s", cfg.TLSPort)
+			if err := httpsServer.ServeTLS(tlsListener, "", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Failed to start HTTPS server: %v", err)
+			}
+		}()
+	}
+	
+	// Internal listener for service-to-service calls (service tokens, no CORS or rate limiting)
+	var internalServer *http.Server
+	if gw.InternalHandler != nil {
+		internalServer = &http.Server{
+			Addr:              net.JoinHostPort(cfg.InternalHost, cfg.InternalPort),
+			Handler:           gw.InternalHandler,
+			ReadTimeout:       server.ReadTimeout,
+			ReadHeaderTimeout: server.ReadHeaderTimeout,
+			WriteTimeout:      server.WriteTimeout,
+			IdleTimeout:       server.IdleTimeout,
+			MaxHeaderBytes:    server.MaxHeaderBytes,
+			ConnState:         connTracker.ConnState,
+		}
+		internalListener, err := restarter.Listen("tcp", internalServer.Addr)
+		if err != nil {
+			log.Fatal("Failed to listen on internal port %s: %v", cfg.InternalPort, err)
+		}
+		go func() {
+			log.Info("Internal listener on %s (%d service tokens)", internalServer.Addr, len(cfg.ServiceTokens))
+			if err := internalServer.Serve(internalListener); err != nil && err != http.ErrServerClosed {
+				log.Fatal("Failed to start internal server: %v", err)
+			}
+		}()
 	}
 	
 	// Start server in a goroutine
+	listener, err := restarter.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal("Failed to listen on port %s: %v", cfg.Port, err)
+	}
 	go func() {
-		log.Info("API Gateway listening on port %s", config.Port)
-		log.Info("Auth Service: %s", config.AuthServiceURL)
-		log.Info("User Service: %s", config.UserServiceURL)
-		log.Info("Content Service: %s", config.ContentServiceURL)
+		log.Info("API Gateway listening on port %s", cfg.Port)
+		for _, route := range gw.Routes {
+			log.Info("Route %s: %s -> %s", route.Name, route.PathPrefix, route.Backend)
+		}
 		
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Fatal("Failed to start server: %v", err)
 		}
 	}()
 	
-	// Wait for interrupt signal to gracefully shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	// Let a previous process (if any) drain now that this one is serving
+	if err := restarter.Ready(); err != nil {
+		log.Fatal("%v", err)
+	}
+	
+	// Wait for interrupt signal, or for a new process to take over, to gracefully shutdown
+	select {
+	case <-shutdown.Notify():
+	case <-restarter.Exit():
+		log.Info("New gateway process took over the listeners")
+	}
 	
-	log.Info("Shutting down server...")
+	// Fail readiness and keep serving until load balancers have stopped routing here
+	// (a preStop hook may already have waited out the delay)
+	if cfg.ShutdownDelay > 0 {
+		log.Info("Failing readiness for %s before closing listeners...", cfg.ShutdownDelay)
+	}
+	gw.Health.Drain(cfg.ShutdownDelay)
 	
-	// Graceful shutdown with 5 second timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Stop accepting connections and wait for in-flight requests up to the deadline
+	log.Info("Shutting down server, draining %d in-flight requests (timeout %s)...", connTracker.Active(), cfg.ShutdownTimeout)
+	
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 	
-	if err := server.Shutdown(ctx); err != nil {
-		log.Error("Server forced to shutdown: %v", err)
+	// Notification streams never finish on their own; end them so clients reconnect to another replica
+	if gw.Notifications != nil {
+		gw.Notifications.CloseStreams()
 	}
 	
-	// Close Redis connection
-	redisClient.Close()
+	shutdown.Servers(ctx, log, server, httpsServer, internalServer)
 	
-	log.Info("Server stopped")
-}
-
-// loadConfig loads configuration from environment variables
-func loadConfig() *Config {
-	return &Config{
-		Port:               getEnv("PORT", "8080"),
-		Environment:        getEnv("ENVIRONMENT", "development"),
-		Debug:              getEnvBool("DEBUG", true),
-		JWTSecretKey:       getEnv("JWT_SECRET_KEY", "dev-secret-key-change-this-in-production"),
-		JWTAlgorithm:       getEnv("JWT_ALGORITHM", "HS256"),
-		AuthServiceURL:     getEnv("AUTH_SERVICE_URL", "http://localhost:8000"),
-		UserServiceURL:     getEnv("USER_SERVICE_URL", "http://localhost:8001"),
-		ContentServiceURL:   getEnv("CONTENT_SERVICE_URL", "http://localhost:8002"),
-		RedisURL:           getEnv("REDIS_URL", "redis://localhost:6379/0"),
-		RateLimitEnabled:   getEnvBool("RATE_LIMIT_ENABLED", true),
-		RateLimitPerMinute: getEnvInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 60),
-		AllowedOrigins:     getEnvSlice("ALLOWED_ORIGINS", []string{"http://localhost:3000"}),
-	}
-}
-
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+	if err := adminServer.Shutdown(ctx); err != nil {
+		log.Error("Admin server forced to shutdown: %v", err)
 	}
-	return value
-}
-
-// getEnvBool gets a boolean environment variable or returns a default value
-func getEnvBool(key string, defaultValue bool) bool {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+	if preStopServer != nil {
+		preStopServer.Close()
 	}
 	
-	boolValue, err := strconv.ParseBool(value)
-	if err != nil {
-		return defaultValue
-	}
+	// Stop background work and flush pending access log and request events
+	gw.Close()
 	
-	return boolValue
-}
-
-// getEnvInt gets an integer environment variable or returns a default value
-func getEnvInt(key string, defaultValue int) int {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
+	// Deliver pending error reports
+	reporting.Flush(2 * time.Second)
 	
-	intValue, err := strconv.Atoi(value)
-	if err != nil {
-		return defaultValue
-	}
+	// Close Redis connection
+	redisClient.Close()
 	
-	return intValue
+	log.Info("Server stopped")
+	log.Close()
 }
 
-// getEnvSlice gets a comma-separated environment variable as a slice
-func getEnvSlice(key string, defaultValue []string) []string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// addLogOutputs attaches the log shipping outputs listed in LOG_OUTPUTS (syslog, gelf)
+// Logs are always written to stdout as well
+func addLogOutputs(log *logger.Logger, cfg *config.Config) error {
+	for _, name := range cfg.LogOutputs {
+		name = strings.TrimSpace(name)
+		remote := logger.RemoteConfig{App: "api-gateway"}
+		var newOutput func(logger.RemoteConfig) (logger.Output, error)
+		switch name {
+		case "syslog":
+			remote.Network, remote.Addr = cfg.SyslogNetwork, cfg.SyslogAddr
+			newOutput = logger.NewSyslogOutput
+		case "gelf":
+			remote.Network, remote.Addr = cfg.GELFNetwork, cfg.GELFAddr
+			newOutput = logger.NewGELFOutput
+		default:
+			return fmt.Errorf("unknown log output %q (want syslog or gelf)", name)
+		}
+		
+		if remote.Network == "tls" {
+			tlsConfig, err := logger.NewTLSConfig(cfg.LogTLSCAFile)
+			if err != nil {
+				return err
+			}
+			remote.TLS = tlsConfig
+		}
+		
+		output, err := newOutput(remote)
+		if err != nil {
+			return err
+		}
+		log.AddOutput(output)
+		log.Info("Shipping logs to %s over %s at %s", name, remote.Network, remote.Addr)
 	}
-	
-	return strings.Split(value, ",")
+	return nil
 }
 
@@ -0,0 +1,6 @@
+package main
+
+// Plugins are compiled in by importing their packages here for their init side effects,
+// then enabled at runtime by listing their names in PLUGINS, e.g.
+//
+//	import _ "example.com/company/gateway-plugins/billing"
@@ -0,0 +1,222 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"nexus-api-gateway/internal/audit"
+	"nexus-api-gateway/internal/capture"
+	"nexus-api-gateway/internal/proxy"
+)
+
+// Recordings last 15 minutes unless the admin asks for longer, up to a day
+const (
+	defaultRecordingDuration = 15 * time.Minute
+	maxRecordingDuration     = 24 * time.Hour
+)
+
+// RecordingsHandler returns a handler listing the routes being recorded
+func RecordingsHandler(recorder *capture.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"recordings": recorder.Recordings(),
+		})
+	}
+}
+
+// StartRecordingHandler returns a handler that starts recording the {route} path variable's requests
+// The optional body {"duration": "1h"} sets how long to record
+func StartRecordingHandler(recorder *capture.Recorder, upstreams *proxy.Registry, auditRecorder *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.Vars(r)["route"]
+		if upstreams.Get(route) == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown route " + route})
+			return
+		}
+
+		var body struct {
+			Duration string `json:"duration"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+				return
+			}
+		}
+		duration := defaultRecordingDuration
+		if body.Duration != "" {
+			d, err := time.ParseDuration(body.Duration)
+			if err != nil || d <= 0 || d > maxRecordingDuration {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("duration must be positive and at most %s", maxRecordingDuration)})
+				return
+			}
+			duration = d
+		}
+
+		recording, err := recorder.Begin(r.Context(), route, duration)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := auditRecorder.Record(r, "capture.start", route, nil, recording); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "recording started but audit entry could not be recorded"})
+			return
+		}
+		writeJSON(w, http.StatusOK, recording)
+	}
+}
+
+// StopRecordingHandler returns a handler that stops recording the {route} path variable's requests
+func StopRecordingHandler(recorder *capture.Recorder, auditRecorder *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.Vars(r)["route"]
+		stopped, err := recorder.End(r.Context(), route)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		if !stopped {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "route " + route + " is not being recorded"})
+			return
+		}
+		if err := auditRecorder.Record(r, "capture.stop", route, nil, nil); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "recording stopped but audit entry could not be recorded"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RecordedRequestsHandler returns a handler serving the {route} path variable's stored requests, newest first
+// ?limit= caps how many are returned (default 100)
+func RecordedRequestsHandler(recorder *capture.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.Vars(r)["route"]
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+				return
+			}
+			limit = n
+		}
+
+		entries, err := recorder.Requests(r.Context(), route, limit)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		stored, err := recorder.Stored(r.Context(), route)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"route":    route,
+			"stored":   stored,
+			"requests": entries,
+		})
+	}
+}
+
+// ClearRecordedRequestsHandler returns a handler deleting the {route} path variable's stored requests
+func ClearRecordedRequestsHandler(recorder *capture.Recorder, auditRecorder *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.Vars(r)["route"]
+		stored, err := recorder.Stored(r.Context(), route)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := recorder.Clear(r.Context(), route); err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := auditRecorder.Record(r, "capture.clear", route, map[string]int64{"stored": stored}, nil); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "requests cleared but audit entry could not be recorded"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ReplayHandler returns a handler that re-sends the {route} path variable's stored requests and compares the responses
+// The body {"target", "ids", "headers"} picks a base URL from targets (the route's backend by default),
+// the stored requests to send (all by default) and headers to set on each
+func ReplayHandler(recorder *capture.Recorder, upstreams *proxy.Registry, targets []string, auditRecorder *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := mux.Vars(r)["route"]
+		upstream := upstreams.Get(route)
+		if upstream == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown route " + route})
+			return
+		}
+
+		var body struct {
+			Target  string            `json:"target"`
+			IDs     []string          `json:"ids"`
+			Headers map[string]string `json:"headers"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+				return
+			}
+		}
+		target := body.Target
+		switch {
+		case target == "":
+			target = upstream.Target()
+			if target == "" {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "route " + route + " has no backend to replay to"})
+				return
+			}
+		case !allowedTarget(targets, target):
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "target is not listed in CAPTURE_REPLAY_TARGETS"})
+			return
+		}
+
+		// Replaying sends requests on the admin's behalf, so it is audited before anything is sent
+		if err := auditRecorder.Record(r, "capture.replay", route, nil, map[string]interface{}{"target": target, "ids": body.IDs}); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "audit entry could not be recorded, nothing replayed"})
+			return
+		}
+
+		results, err := recorder.Replay(r.Context(), route, target, body.IDs, body.Headers)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		matched := 0
+		for _, result := range results {
+			if result.Error == "" && result.StatusMatched && (result.BodyMatched == nil || *result.BodyMatched) {
+				matched++
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"route":    route,
+			"target":   target,
+			"replayed": len(results),
+			"matched":  matched,
+			"results":  results,
+		})
+	}
+}
+
+// allowedTarget reports whether target is one of targets, ignoring a trailing slash
+func allowedTarget(targets []string, target string) bool {
+	target = strings.TrimSuffix(target, "/")
+	for _, t := range targets {
+		if strings.TrimSuffix(t, "/") == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+
+	"nexus-api-gateway/internal/audit"
+	"nexus-api-gateway/internal/config"
+	"nexus-api-gateway/internal/restart"
+)
+
+// ConfigHandler returns a handler serving the effective configuration, with secrets redacted
+// Each line is NAME=value followed by where the value came from
+func ConfigHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		cfg.Print(w)
+	}
+}
+
+// ReloadHandler returns a handler that reloads the configuration by starting a new gateway process
+// The new process takes over the listeners once ready, exactly like SIGHUP
+func ReloadHandler(restarter *restart.Restarter, recorder *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !restarter.Enabled() {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "reload requires GRACEFUL_RESTART_ENABLED=true"})
+			return
+		}
+
+		if err := recorder.Record(r, "config.reload", "gateway", nil, nil); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "audit entry could not be recorded, reload not started"})
+			return
+		}
+
+		if err := restarter.Upgrade(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "reloaded"})
+	}
+}
@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"nexus-api-gateway/internal/audit"
+	"nexus-api-gateway/internal/middleware"
+)
+
+// RateLimitResetHandler returns a handler that clears a client's rate limit counter
+// The client is the {client} path variable, matching the key the limiter uses
+func RateLimitResetHandler(limiter *middleware.RateLimiter, recorder *audit.Recorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := mux.Vars(r)["client"]
+
+		count, err := limiter.Count(r.Context(), client)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+
+		if err := limiter.Reset(r.Context(), client); err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+
+		before := map[string]int{"count": count}
+		after := map[string]int{"count": 0}
+		if err := recorder.Record(r, "ratelimit.reset", client, before, after); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "rate limit reset but audit entry could not be recorded"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"client": client,
+			"before": before,
+			"after":  after,
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"net/http"
+
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/routes"
+)
+
+// RouteStatus describes a configured route and the state of its backend
+type RouteStatus struct {
+	routes.Route
+	Upstream *UpstreamStats `json:"upstream,omitempty"`
+}
+
+// RoutesHandler returns a handler listing the active route table with each backend's state
+func RoutesHandler(table routes.Table, upstreams *proxy.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		statuses := make([]RouteStatus, 0, len(table))
+		for _, route := range table {
+			status := RouteStatus{Route: route}
+			if u := upstreams.Get(route.Name); u != nil {
+				stats := upstreamStats(u)
+				status.Upstream = &stats
+			}
+			statuses = append(statuses, status)
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"routes": statuses,
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Package admin provides the gateway's internal admin/ops HTTP server
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"nexus-api-gateway/internal/middleware"
+
+	"nexus-common/logger"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+}
+
+// Server is the admin HTTP server
+// Every route on it requires the admin API key or an operator's key
+type Server struct {
+	router *mux.Router
+	server *http.Server
+	logger *logger.Logger
+}
+
+// NewServer creates an admin server listening on host:port, for apiKey and operatorKeys by operator
+// An empty host listens on all interfaces; pprof and expvar endpoints are registered under /debug
+func NewServer(host, port, apiKey string, operatorKeys map[string]string, log *logger.Logger) *Server {
+	router := mux.NewRouter()
+	// Request IDs tag admin logs and audit entries, as on the public listener
+	router.Use(middleware.RequestID)
+	router.Use(middleware.AdminAuth(apiKey, operatorKeys, log))
+
+	// Profiling endpoints
+	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	router.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	router.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	router.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	router.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+
+	// Runtime variables (memstats, cmdline, goroutines)
+	router.Handle("/debug/vars", expvar.Handler())
+
+	return &Server{
+		router: router,
+		server: &http.Server{
+			Addr:        net.JoinHostPort(host, port),
+			Handler:     router,
+			ReadTimeout: 15 * time.Second,
+			// CPU profiles and traces stream for up to their requested duration
+			WriteTimeout: 5 * time.Minute,
+			IdleTimeout:  60 * time.Second,
+		},
+		logger: log,
+	}
+}
+
+// Router returns the admin router for registering additional admin endpoints
+func (s *Server) Router() *mux.Router {
+	return s.router
+}
+
+// Addr returns the address the admin server should listen on
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
+
+// Start serves on ln in a goroutine
+func (s *Server) Start(ln net.Listener) {
+	go func() {
+		s.logger.Info("Admin server listening on %s", ln.Addr())
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.logger.Fatal("Failed to start admin server: %v", err)
+		}
+	}()
+}
+
+// Shutdown gracefully stops the admin server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
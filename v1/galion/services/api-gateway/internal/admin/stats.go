@@ -0,0 +1,155 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-api-gateway/internal/proxy"
+)
+
+// startTime is used to report process uptime
+var startTime = time.Now()
+
+// ConnTracker counts open client connections and those with a request in progress
+// Install its ConnState method as http.Server.ConnState
+type ConnTracker struct {
+	open   atomic.Int64
+	active atomic.Int64
+	states sync.Map // net.Conn -> http.ConnState
+}
+
+// ConnState updates the connection counts as connections change state
+func (t *ConnTracker) ConnState(conn net.Conn, state http.ConnState) {
+	previous, _ := t.states.Load(conn)
+	if previous == http.StateActive && state != http.StateActive {
+		t.active.Add(-1)
+	}
+
+	switch state {
+	case http.StateNew:
+		t.open.Add(1)
+	case http.StateActive:
+		t.active.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		t.open.Add(-1)
+		t.states.Delete(conn)
+		return
+	}
+	t.states.Store(conn, state)
+}
+
+// Open returns the number of open client connections
+func (t *ConnTracker) Open() int64 {
+	return t.open.Load()
+}
+
+// Active returns the number of connections currently serving a request
+func (t *ConnTracker) Active() int64 {
+	return t.active.Load()
+}
+
+// Stats is a snapshot of runtime statistics
+type Stats struct {
+	UptimeSeconds   int64           `json:"uptime_seconds"`
+	Goroutines      int             `json:"goroutines"`
+	Heap            HeapStats       `json:"heap"`
+	GC              GCStats         `json:"gc"`
+	OpenConnections int64           `json:"open_connections"`
+	ActiveRequests  int64           `json:"active_requests"`
+	Upstreams       []UpstreamStats `json:"upstreams"`
+}
+
+// HeapStats describes heap usage
+type HeapStats struct {
+	AllocBytes    uint64 `json:"alloc_bytes"`
+	InuseBytes    uint64 `json:"inuse_bytes"`
+	IdleBytes     uint64 `json:"idle_bytes"`
+	SysBytes      uint64 `json:"sys_bytes"`
+	Objects       uint64 `json:"objects"`
+	TotalAllocMiB uint64 `json:"total_alloc_mib"`
+}
+
+// GCStats describes garbage collector activity
+type GCStats struct {
+	NumGC        uint32     `json:"num_gc"`
+	LastGC       *time.Time `json:"last_gc"` // nil until the first collection
+	LastPauseMs  float64    `json:"last_pause_ms"`
+	PauseTotalMs float64    `json:"pause_total_ms"`
+	CPUFraction  float64    `json:"cpu_fraction"`
+	NextGCBytes  uint64     `json:"next_gc_bytes"`
+}
+
+// UpstreamStats describes a backend's current load and state
+type UpstreamStats struct {
+	Name         string `json:"name"`
+	InFlight     int64  `json:"in_flight"`
+	Healthy      bool   `json:"healthy"`
+	CircuitState string `json:"circuit_state"`
+	// Targets lists instances found by service discovery, if the upstream uses it
+	Targets []string `json:"targets,omitempty"`
+}
+
+// upstreamStats snapshots an upstream's current state
+func upstreamStats(u *proxy.Upstream) UpstreamStats {
+	return UpstreamStats{
+		Name:         u.Name,
+		InFlight:     u.InFlight(),
+		Healthy:      u.Healthy(),
+		CircuitState: u.CircuitState().String(),
+		Targets:      u.Targets(),
+	}
+}
+
+// StatsHandler returns a handler serving runtime stats as JSON
+// Reading memstats briefly stops the world, so this is meant for ad-hoc triage, not scraping
+func StatsHandler(conns *ConnTracker, upstreams *proxy.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		var lastGC *time.Time
+		var lastPause float64
+		if mem.NumGC > 0 {
+			t := time.Unix(0, int64(mem.LastGC))
+			lastGC = &t
+			lastPause = float64(mem.PauseNs[(mem.NumGC+255)%256]) / 1e6
+		}
+
+		stats := Stats{
+			UptimeSeconds: int64(time.Since(startTime).Seconds()),
+			Goroutines:    runtime.NumGoroutine(),
+			Heap: HeapStats{
+				AllocBytes:    mem.HeapAlloc,
+				InuseBytes:    mem.HeapInuse,
+				IdleBytes:     mem.HeapIdle,
+				SysBytes:      mem.HeapSys,
+				Objects:       mem.HeapObjects,
+				TotalAllocMiB: mem.TotalAlloc / 1024 / 1024,
+			},
+			GC: GCStats{
+				NumGC:        mem.NumGC,
+				LastGC:       lastGC,
+				LastPauseMs:  lastPause,
+				PauseTotalMs: float64(mem.PauseTotalNs) / 1e6,
+				CPUFraction:  mem.GCCPUFraction,
+				NextGCBytes:  mem.NextGC,
+			},
+			OpenConnections: conns.Open(),
+			ActiveRequests:  conns.Active(),
+			Upstreams:       []UpstreamStats{},
+		}
+
+		for _, u := range upstreams.All() {
+			stats.Upstreams = append(stats.Upstreams, upstreamStats(u))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(stats)
+	}
+}
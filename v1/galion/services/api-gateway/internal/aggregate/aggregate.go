@@ -0,0 +1,167 @@
+// Package aggregate serves documents composed from several backend GETs made concurrently
+// Each section of a document comes from one backend; a section that fails is null and described
+// under "errors", so one slow or failing backend degrades the document instead of failing it
+package aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/routes"
+
+	"nexus-common/logger"
+)
+
+// maxSectionBody caps how much of a backend response is decoded
+const maxSectionBody = 10 << 20
+
+// Section error codes
+const (
+	CodeUnavailable     = "unavailable"      // the backend couldn't be reached or its circuit is open
+	CodeTimeout         = "timeout"          // the backend didn't answer within the section timeout
+	CodeNotFound        = "not_found"        // the backend answered 404
+	CodeForbidden       = "forbidden"        // the backend answered 401 or 403
+	CodeBackendError    = "backend_error"    // the backend answered another non-2xx status
+	CodeInvalidResponse = "invalid_response" // the backend's body wasn't JSON
+	CodeNotConfigured   = "not_configured"   // the section's route isn't in the route table
+)
+
+// Section is one part of a document: the JSON at Path under Route's prefix, optionally reshaped
+type Section struct {
+	Name      string
+	Route     string
+	Path      func(r *http.Request) string
+	Transform func(value interface{}) interface{}
+}
+
+// SectionError marks a section that couldn't be filled
+type SectionError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status,omitempty"` // the backend's status, for not_found, forbidden and backend_error
+}
+
+// Config sets how long sections may take
+type Config struct {
+	Timeout time.Duration // per section
+}
+
+// Handler serves one composed document
+type Handler struct {
+	config   Config
+	sections []Section
+	routes   routes.Table
+	fetch    proxy.FetchFunc
+	logger   *logger.Logger
+}
+
+// New returns a handler composing sections fetched through fetch
+func New(config Config, sections []Section, table routes.Table, fetch proxy.FetchFunc, log *logger.Logger) *Handler {
+	return &Handler{config: config, sections: sections, routes: table, fetch: fetch, logger: log}
+}
+
+// ServeHTTP fetches every section concurrently and answers with one object keyed by section name,
+// plus "errors" for the sections that failed; it answers 502 only when every section failed
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := backendHeader(r.Header)
+	values := make([]interface{}, len(h.sections))
+	errs := make([]*SectionError, len(h.sections))
+	var wg sync.WaitGroup
+	for i, section := range h.sections {
+		wg.Add(1)
+		go func(i int, section Section) {
+			defer wg.Done()
+			values[i], errs[i] = h.section(r, section, header)
+		}(i, section)
+	}
+	wg.Wait()
+
+	document := make(map[string]interface{}, len(h.sections)+1)
+	failed := make(map[string]*SectionError)
+	for i, section := range h.sections {
+		document[section.Name] = values[i]
+		if errs[i] != nil {
+			failed[section.Name] = errs[i]
+			h.logger.WithContext(r.Context()).Warn("Section %s of %s failed: %s", section.Name, r.URL.Path, errs[i].Message)
+		}
+	}
+	status := http.StatusOK
+	if len(failed) > 0 {
+		document["errors"] = failed
+		if len(failed) == len(h.sections) {
+			status = http.StatusBadGateway
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(document)
+}
+
+// section fetches and decodes one section within the section timeout
+func (h *Handler) section(r *http.Request, section Section, header http.Header) (interface{}, *SectionError) {
+	route := h.route(section.Route)
+	if route == nil {
+		return nil, &SectionError{Code: CodeNotConfigured, Message: fmt.Sprintf("route %s is not configured", section.Route)}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.Timeout)
+	defer cancel()
+	resp, err := h.fetch(r.WithContext(ctx), route.Name, route.PathPrefix+section.Path(r), header)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, &SectionError{Code: CodeTimeout, Message: fmt.Sprintf("%s service did not answer within %s", route.Name, h.config.Timeout)}
+		}
+		return nil, &SectionError{Code: CodeUnavailable, Message: fmt.Sprintf("%s service unavailable", route.Name)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, &SectionError{Code: CodeNotFound, Message: "not found", Status: resp.StatusCode}
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, &SectionError{Code: CodeForbidden, Message: "not authorized", Status: resp.StatusCode}
+	case resp.StatusCode >= 300:
+		return nil, &SectionError{Code: CodeBackendError, Message: fmt.Sprintf("%s service returned %d", route.Name, resp.StatusCode), Status: resp.StatusCode}
+	}
+
+	var value interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSectionBody)).Decode(&value); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, &SectionError{Code: CodeTimeout, Message: fmt.Sprintf("%s service did not answer within %s", route.Name, h.config.Timeout)}
+		}
+		return nil, &SectionError{Code: CodeInvalidResponse, Message: fmt.Sprintf("%s service returned an invalid response", route.Name)}
+	}
+	if section.Transform != nil {
+		value = section.Transform(value)
+	}
+	return value, nil
+}
+
+func (h *Handler) route(name string) *routes.Route {
+	for i := range h.routes {
+		if h.routes[i].Name == name {
+			return &h.routes[i]
+		}
+	}
+	return nil
+}
+
+// backendHeader is the caller's headers minus those describing the caller's own body and encoding
+// X-User-Email is kept: aggregate handlers run behind authentication, which sets it
+func backendHeader(header http.Header) http.Header {
+	forward := header.Clone()
+	for _, name := range []string{"Content-Type", "Content-Length", "Accept-Encoding"} {
+		forward.Del(name)
+	}
+	forward.Set("Accept", "application/json")
+	return forward
+}
@@ -0,0 +1,41 @@
+package aggregate
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// OverviewPath serves the dashboard overview of the authenticated user
+const OverviewPath = "/api/v1/me/overview"
+
+// Overview is the dashboard overview: the user's profile from the user service and the
+// newest feedLimit published items from the content service
+func Overview(feedLimit int) []Section {
+	return []Section{
+		{
+			Name:  "profile",
+			Route: "users",
+			Path:  func(*http.Request) string { return "/me" },
+		},
+		{
+			Name:  "feed",
+			Route: "content",
+			Path: func(*http.Request) string {
+				return "/?" + url.Values{"limit": {strconv.Itoa(feedLimit)}}.Encode()
+			},
+			Transform: listItems,
+		},
+	}
+}
+
+// listItems returns the items of a list response: a JSON array, or an object with an "items" array
+func listItems(body interface{}) interface{} {
+	if object, ok := body.(map[string]interface{}); ok {
+		body = object["items"]
+	}
+	if items, ok := body.([]interface{}); ok {
+		return items
+	}
+	return []interface{}{}
+}
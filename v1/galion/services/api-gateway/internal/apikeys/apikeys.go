@@ -0,0 +1,310 @@
+// Package apikeys lets users create, list, rename and revoke their own API keys
+// Only a SHA-256 hash of each secret is stored; the secret itself is shown once, when the key is created
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+
+	"nexus-common/logger"
+)
+
+// Path is where keys are listed and created; a key is managed at Path/{id}
+const Path = "/api/v1/api-keys"
+
+// secretPrefix marks API keys so they are recognisable, e.g. by secret scanners
+const secretPrefix = "nxk_"
+
+// displayPrefixLength is how much of a secret is kept to tell keys apart
+const displayPrefixLength = len(secretPrefix) + 8
+
+// maxNameLength caps key names
+const maxNameLength = 100
+
+// Redis keys: a hash per key, a set of key IDs per user, and the key ID by secret hash
+const (
+	keyPrefix  = "gateway:apikeys:"
+	userPrefix = "gateway:apikeys:user:"
+	hashPrefix = "gateway:apikeys:hash:"
+)
+
+// createScript stores a key unless the user already has the maximum
+// KEYS: key hash, user set, secret hash index; ARGV: id, max, then the key's fields
+var createScript = redis.NewScript(`
+if redis.call("SCARD", KEYS[2]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("HSET", KEYS[1], unpack(ARGV, 3))
+redis.call("SADD", KEYS[2], ARGV[1])
+redis.call("SET", KEYS[3], ARGV[1])
+return 1
+`)
+
+// Config limits keys
+type Config struct {
+	MaxPerUser int
+}
+
+// Key is an API key as shown to its owner
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreatedKey is a new key with its secret, which is never shown again
+type CreatedKey struct {
+	Key
+	Secret string `json:"key"`
+}
+
+var (
+	errNotFound = errors.New("api key not found")
+	errTooMany  = errors.New("too many api keys")
+)
+
+// Store keeps keys in Redis
+type Store struct {
+	config Config
+	client redis.UniversalClient
+	logger *logger.Logger
+}
+
+// New returns a key store
+func New(config Config, client redis.UniversalClient, log *logger.Logger) *Store {
+	return &Store{config: config, client: client, logger: log}
+}
+
+// Create makes a key for user
+func (s *Store) Create(ctx context.Context, user, name string) (*CreatedKey, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	secret := secretPrefix + base64.RawURLEncoding.EncodeToString(random)
+	key := Key{
+		ID:        "key_" + hex.EncodeToString(id),
+		Name:      name,
+		Prefix:    secret[:displayPrefixLength],
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	hash := hashSecret(secret)
+
+	created, err := createScript.Run(ctx, s.client,
+		[]string{keyPrefix + key.ID, userPrefix + user, hashPrefix + hash},
+		key.ID, s.config.MaxPerUser,
+		"user", user, "name", key.Name, "prefix", key.Prefix, "hash", hash, "created_at", key.CreatedAt.Format(time.RFC3339),
+	).Int()
+	if err != nil {
+		return nil, err
+	}
+	if created == 0 {
+		return nil, errTooMany
+	}
+	return &CreatedKey{Key: key, Secret: secret}, nil
+}
+
+// List returns user's keys, oldest first
+func (s *Store) List(ctx context.Context, user string) ([]Key, error) {
+	ids, err := s.client.SMembers(ctx, userPrefix+user).Result()
+	if err != nil {
+		return nil, err
+	}
+	pipe := s.client.Pipeline()
+	results := make([]*redis.MapStringStringCmd, len(ids))
+	for i, id := range ids {
+		results[i] = pipe.HGetAll(ctx, keyPrefix+id)
+	}
+	if len(ids) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]Key, 0, len(ids))
+	for i, id := range ids {
+		fields := results[i].Val()
+		if len(fields) == 0 {
+			continue
+		}
+		keys = append(keys, toKey(id, fields))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Rename changes the name of one of user's keys
+func (s *Store) Rename(ctx context.Context, user, id, name string) (*Key, error) {
+	fields, err := s.owned(ctx, user, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.client.HSet(ctx, keyPrefix+id, "name", name).Err(); err != nil {
+		return nil, err
+	}
+	fields["name"] = name
+	key := toKey(id, fields)
+	return &key, nil
+}
+
+// Revoke deletes one of user's keys; its secret stops matching immediately
+func (s *Store) Revoke(ctx context.Context, user, id string) error {
+	fields, err := s.owned(ctx, user, id)
+	if err != nil {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, keyPrefix+id, hashPrefix+fields["hash"])
+	pipe.SRem(ctx, userPrefix+user, id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// owned loads a key, treating other users' keys as missing
+func (s *Store) owned(ctx context.Context, user, id string) (map[string]string, error) {
+	fields, err := s.client.HGetAll(ctx, keyPrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 || fields["user"] != user {
+		return nil, errNotFound
+	}
+	return fields, nil
+}
+
+func toKey(id string, fields map[string]string) Key {
+	created, _ := time.Parse(time.RFC3339, fields["created_at"])
+	return Key{ID: id, Name: fields["name"], Prefix: fields["prefix"], CreatedAt: created}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListHandler serves the caller's keys
+// The handlers must be behind auth middleware, which sets X-User-Email
+func (s *Store) ListHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := s.List(r.Context(), r.Header.Get("X-User-Email"))
+	if err != nil {
+		s.unavailable(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": keys})
+}
+
+// CreateHandler creates a key for the caller and returns its secret, once
+func (s *Store) CreateHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := readName(w, r, "default")
+	if !ok {
+		return
+	}
+	user := r.Header.Get("X-User-Email")
+	key, err := s.Create(r.Context(), user, name)
+	if errors.Is(err, errTooMany) {
+		writeError(w, http.StatusConflict, "conflict", "api key limit reached; revoke a key first")
+		return
+	}
+	if err != nil {
+		s.unavailable(w, r, err)
+		return
+	}
+	s.logger.WithContext(r.Context()).Info("Created API key %s (%s) for %s", key.ID, key.Prefix, user)
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// RenameHandler renames one of the caller's keys
+func (s *Store) RenameHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := readName(w, r, "")
+	if !ok {
+		return
+	}
+	key, err := s.Rename(r.Context(), r.Header.Get("X-User-Email"), mux.Vars(r)["id"], name)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "not found", err.Error())
+		return
+	}
+	if err != nil {
+		s.unavailable(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, key)
+}
+
+// RevokeHandler revokes one of the caller's keys
+func (s *Store) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	user, id := r.Header.Get("X-User-Email"), mux.Vars(r)["id"]
+	err := s.Revoke(r.Context(), user, id)
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, "not found", err.Error())
+		return
+	}
+	if err != nil {
+		s.unavailable(w, r, err)
+		return
+	}
+	s.logger.WithContext(r.Context()).Info("Revoked API key %s of %s", id, user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readName reads {"name": ...} from the body; an empty body or name gives fallback, if there is one
+func readName(w http.ResponseWriter, r *http.Request, fallback string) (string, bool) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil && !(err == io.EOF && fallback != "") {
+		writeError(w, http.StatusBadRequest, "bad request", `body must be a JSON object with a "name"`)
+		return "", false
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		name = fallback
+	}
+	switch {
+	case name == "":
+		writeError(w, http.StatusBadRequest, "bad request", "name is required")
+	case utf8.RuneCountInString(name) > maxNameLength:
+		writeError(w, http.StatusBadRequest, "bad request", "name must be at most 100 characters")
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		writeError(w, http.StatusBadRequest, "bad request", "name must not contain control characters")
+	default:
+		return name, true
+	}
+	return "", false
+}
+
+func (s *Store) unavailable(w http.ResponseWriter, r *http.Request, err error) {
+	s.logger.WithContext(r.Context()).Error("API key store failed: %v", err)
+	writeError(w, http.StatusServiceUnavailable, "service unavailable", "api keys are unavailable")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{"error": code, "message": message})
+}
@@ -0,0 +1,160 @@
+// Package audit records an append-only trail of admin actions
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"nexus-common/logger"
+	"nexus-common/requestid"
+)
+
+// ErrNoActor is returned for admin actions whose request wasn't authenticated as an operator
+var ErrNoActor = errors.New("admin action has no operator to record as its actor")
+
+// actorKey is the context key of the operator an admin request was authenticated as
+type actorKey struct{}
+
+// WithActor returns ctx for a request authenticated with the admin key of operator
+func WithActor(ctx context.Context, operator string) context.Context {
+	return context.WithValue(ctx, actorKey{}, operator)
+}
+
+// Actor returns the operator a request was authenticated as, or "" if none
+func Actor(ctx context.Context) string {
+	operator, _ := ctx.Value(actorKey{}).(string)
+	return operator
+}
+
+// Entry is a single audited admin action
+type Entry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	RemoteAddr string          `json:"remote_addr,omitempty"`
+}
+
+// Filter narrows an audit query
+type Filter struct {
+	Actor  string
+	Action string
+	Since  time.Time
+	Limit  int
+}
+
+// Store persists audit entries
+// Implementations must never modify or delete existing entries
+type Store interface {
+	Append(ctx context.Context, entry Entry) error
+	List(ctx context.Context, filter Filter) ([]Entry, error)
+}
+
+// Recorder records admin actions to a store
+type Recorder struct {
+	store  Store
+	logger *logger.Logger
+}
+
+// NewRecorder creates an audit recorder
+func NewRecorder(store Store, log *logger.Logger) *Recorder {
+	return &Recorder{
+		store:  store,
+		logger: log,
+	}
+}
+
+// Record appends an admin action performed by the operator the request was authenticated as
+// before and after describe the affected state and may be nil
+// Actions without an operator are refused with ErrNoActor rather than recorded anonymously
+func (rec *Recorder) Record(r *http.Request, action, target string, before, after interface{}) error {
+	operator := Actor(r.Context())
+	if operator == "" {
+		rec.logger.WithContext(r.Context()).Error("Refused to record audit entry %s on %s without an operator", action, target)
+		return ErrNoActor
+	}
+	entry := Entry{
+		Time:       time.Now().UTC(),
+		Actor:      operator,
+		Action:     action,
+		Target:     target,
+		Before:     marshal(before),
+		After:      marshal(after),
+		RequestID:  requestid.FromContext(r.Context()),
+		RemoteAddr: r.RemoteAddr,
+	}
+
+	if err := rec.store.Append(r.Context(), entry); err != nil {
+		rec.logger.WithContext(r.Context()).Error("Failed to record audit entry %s on %s by %s: %v", action, target, entry.Actor, err)
+		return err
+	}
+
+	rec.logger.WithContext(r.Context()).Info("Audit: %s performed %s on %s", entry.Actor, action, target)
+	return nil
+}
+
+// ListHandler serves audit entries as JSON
+// Supports actor, action, since (RFC3339) and limit query parameters
+func (rec *Recorder) ListHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  100,
+	}
+
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be an RFC3339 timestamp"})
+			return
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := rec.store.List(r.Context(), filter)
+	if err != nil {
+		rec.logger.WithContext(r.Context()).Error("Failed to query audit log: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to query audit log"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"entries": entries,
+	})
+}
+
+// marshal encodes audit state, returning nil for nil values
+func marshal(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
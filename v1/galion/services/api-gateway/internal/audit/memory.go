@@ -0,0 +1,50 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore keeps audit entries in process memory
+// Entries are lost on restart, so it is only meant for local development
+type MemoryStore struct {
+	mu      sync.RWMutex
+	entries []Entry
+}
+
+// NewMemoryStore creates an in-memory audit store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Append adds an entry
+func (s *MemoryStore) Append(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = int64(len(s.entries) + 1)
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+// List returns matching entries, newest first
+func (s *MemoryStore) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []Entry{}
+	for i := len(s.entries) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		e := s.entries[i]
+		if filter.Actor != "" && e.Actor != filter.Actor {
+			continue
+		}
+		if filter.Action != "" && e.Action != filter.Action {
+			continue
+		}
+		if !filter.Since.IsZero() && e.Time.Before(filter.Since) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
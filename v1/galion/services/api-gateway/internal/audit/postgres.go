@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore stores audit entries in an append-only PostgreSQL table
+// A trigger rejects UPDATE and DELETE so entries cannot be altered once written
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore connects to PostgreSQL and ensures the audit table exists
+func NewPostgresStore(databaseURL string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping audit database: %w", err)
+	}
+
+	statements := []string{
+		`CREATE SCHEMA IF NOT EXISTS gateway`,
+		`CREATE TABLE IF NOT EXISTS gateway.admin_audit (
+			id BIGSERIAL PRIMARY KEY,
+			time TIMESTAMPTZ NOT NULL,
+			actor VARCHAR(255) NOT NULL,
+			action VARCHAR(100) NOT NULL,
+			target VARCHAR(255) NOT NULL,
+			before JSONB,
+			after JSONB,
+			request_id VARCHAR(128),
+			remote_addr VARCHAR(255)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_audit_time ON gateway.admin_audit(time)`,
+		`CREATE INDEX IF NOT EXISTS idx_admin_audit_actor ON gateway.admin_audit(actor)`,
+		`CREATE OR REPLACE FUNCTION gateway.admin_audit_immutable() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'admin_audit is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS admin_audit_immutable ON gateway.admin_audit`,
+		`CREATE TRIGGER admin_audit_immutable BEFORE UPDATE OR DELETE ON gateway.admin_audit
+			FOR EACH ROW EXECUTE FUNCTION gateway.admin_audit_immutable()`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to prepare audit table: %w", err)
+		}
+	}
+
+	return &PostgresStore{db: db}, nil
+}
+
+// Append inserts an audit entry
+func (s *PostgresStore) Append(ctx context.Context, entry Entry) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO gateway.admin_audit (time, actor, action, target, before, after, request_id, remote_addr)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, entry.Time, entry.Actor, entry.Action, entry.Target,
+		nullJSON(entry.Before), nullJSON(entry.After), entry.RequestID, entry.RemoteAddr)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns matching entries, newest first
+func (s *PostgresStore) List(ctx context.Context, filter Filter) ([]Entry, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Actor != "" {
+		args = append(args, filter.Actor)
+		conditions = append(conditions, fmt.Sprintf("actor = $%d", len(args)))
+	}
+	if filter.Action != "" {
+		args = append(args, filter.Action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		conditions = append(conditions, fmt.Sprintf("time >= $%d", len(args)))
+	}
+
+	query := `SELECT id, time, actor, action, target, before, after, request_id, remote_addr FROM gateway.admin_audit`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var before, after []byte
+		var requestID, remoteAddr sql.NullString
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Action, &e.Target, &before, &after, &requestID, &remoteAddr); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.Before = before
+		e.After = after
+		e.RequestID = requestID.String
+		e.RemoteAddr = remoteAddr.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// Close closes the database connection
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// nullJSON converts empty JSON to a SQL NULL
+func nullJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
@@ -0,0 +1,195 @@
+// Package batch serves POST /api/v1/batch, running several API requests in one round trip
+// Sub-requests run concurrently through the same pipeline as ordinary requests (rate limiting,
+// logging, route middleware, auth and proxy), and their responses are returned in request order
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+
+	"nexus-common/logger"
+)
+
+// Path is where the batch endpoint is served
+const Path = "/api/v1/batch"
+
+// inheritedHeaders are copied from the batch request to every sub-request that doesn't set them
+var inheritedHeaders = []string{"Authorization", "Accept-Language", "User-Agent", "X-Request-Id", "Traceparent", "Tracestate"}
+
+// droppedHeaders may not be set on sub-requests
+var droppedHeaders = []string{"Content-Length", "Connection", "Transfer-Encoding", "Host", "X-Service-Name", "X-Service-Token", "X-User-Email"}
+
+// maxBodyBytes caps the size of a batch request body
+const maxBodyBytes = 1 << 20
+
+// Config limits batch requests
+type Config struct {
+	MaxRequests int // sub-requests per batch
+	Concurrency int // sub-requests of a batch running at once
+}
+
+// Handler runs batches through Pipeline, which must be set before serving
+type Handler struct {
+	Pipeline http.Handler
+
+	config Config
+	logger *logger.Logger
+}
+
+// New returns a batch handler; set Pipeline once the middleware chain is assembled
+func New(config Config, log *logger.Logger) *Handler {
+	return &Handler{config: config, logger: log}
+}
+
+// Request is one sub-request of a batch
+type Request struct {
+	ID      string            `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// Response is the response to one sub-request; JSON bodies are embedded as JSON, others as strings
+type Response struct {
+	ID      string            `json:"id,omitempty"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+// ServeHTTP runs the batch and answers 200 with every sub-response, whatever their statuses
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch struct {
+		Requests []Request `json:"requests"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&batch); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be a JSON object with a requests array")
+		return
+	}
+	if len(batch.Requests) == 0 {
+		writeError(w, http.StatusBadRequest, "requests must not be empty")
+		return
+	}
+	if len(batch.Requests) > h.config.MaxRequests {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d requests may be batched", h.config.MaxRequests))
+		return
+	}
+	// Sub-requests are checked as they will be routed, after their paths are decoded
+	requests := make([]*http.Request, len(batch.Requests))
+	for i, sub := range batch.Requests {
+		req, err := newRequest(r, sub)
+		if err == nil {
+			err = validate(req)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("request %d: %v", i, err))
+			return
+		}
+		requests[i] = req
+	}
+
+	h.logger.WithContext(r.Context()).Debug("Running batch of %d requests", len(batch.Requests))
+	responses := make([]Response, len(batch.Requests))
+	slots := make(chan struct{}, h.config.Concurrency)
+	var wg sync.WaitGroup
+	for i, sub := range batch.Requests {
+		wg.Add(1)
+		go func(i int, sub Request) {
+			defer wg.Done()
+			slots <- struct{}{}
+			responses[i] = h.run(requests[i], sub.ID)
+			<-slots
+		}(i, sub)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"responses": responses})
+}
+
+// newRequest builds a sub-request of the batch request r
+func newRequest(r *http.Request, sub Request) (*http.Request, error) {
+	switch strings.ToUpper(sub.Method) {
+	case "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return nil, fmt.Errorf("method must be GET, POST, PUT, PATCH or DELETE")
+	}
+	var body []byte
+	if len(sub.Body) > 0 && string(sub.Body) != "null" {
+		body = sub.Body
+	}
+	req, err := http.NewRequestWithContext(r.Context(), strings.ToUpper(sub.Method), sub.Path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid path: %v", err)
+	}
+	req.RemoteAddr = r.RemoteAddr
+	req.Host = r.Host
+	for _, name := range inheritedHeaders {
+		if value := r.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	for name, value := range sub.Headers {
+		req.Header.Set(name, value)
+	}
+	for _, name := range droppedHeaders {
+		req.Header.Del(name)
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// run sends one sub-request through the pipeline and records its response
+func (h *Handler) run(req *http.Request, id string) Response {
+	recorder := httptest.NewRecorder()
+	h.Pipeline.ServeHTTP(recorder, req)
+	resp := recorder.Result()
+
+	headers := make(map[string]string, len(resp.Header))
+	for name := range resp.Header {
+		headers[name] = resp.Header.Get(name)
+	}
+	out := recorder.Body.Bytes()
+	if len(out) == 0 {
+		return Response{ID: id, Status: resp.StatusCode, Headers: headers}
+	}
+	if !json.Valid(out) {
+		out, _ = json.Marshal(string(out))
+	}
+	return Response{ID: id, Status: resp.StatusCode, Headers: headers, Body: out}
+}
+
+// validate checks the path a sub-request is routed by; escaped and unclean paths are refused, so
+// none can be decoded or cleaned into the batch endpoint after it is checked
+func validate(req *http.Request) error {
+	if req.URL.Scheme != "" || req.URL.Host != "" || !strings.HasPrefix(req.URL.Path, "/api/") {
+		return fmt.Errorf("path must be an API path starting with /api/")
+	}
+	if req.URL.RawPath != "" || path.Clean(req.URL.Path) != req.URL.Path {
+		return fmt.Errorf("path must be clean and unescaped")
+	}
+	if req.URL.Path == Path || strings.HasPrefix(req.URL.Path, Path+"/") {
+		return fmt.Errorf("batches may not be nested")
+	}
+	return nil
+}
+
+func errorBody(message string) json.RawMessage {
+	body, _ := json.Marshal(map[string]string{"error": "bad request", "message": message})
+	return body
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(errorBody(message))
+}
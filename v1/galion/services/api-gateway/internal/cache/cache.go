@@ -0,0 +1,339 @@
+// Package cache caches backend responses to GET requests in Redis, per route
+// Entries are fresh for the route's TTL. After that they may still be served for
+// stale_while_revalidate while one request refreshes them in the background, and
+// for stale_if_error while the backend is failing, so read paths ride out backend blips
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// DefaultMaxBodyBytes is the largest response cached when a route doesn't set max_body_bytes
+const DefaultMaxBodyBytes = 1 << 20
+
+// StatusHeader tells clients how a response was served: HIT, STALE, STALE-IF-ERROR or MISS
+const StatusHeader = "X-Cache"
+
+// refreshLockTTL bounds how long one gateway holds the right to refresh an entry
+const refreshLockTTL = 30 * time.Second
+
+// Results recorded in gateway_cache_requests_total
+const (
+	ResultHit          = "hit"
+	ResultStale        = "stale"
+	ResultStaleIfError = "stale_if_error"
+	ResultMiss         = "miss"
+)
+
+// cacheableStatus are the statuses worth caching (RFC 9110 heuristically cacheable ones the backends use)
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// errorStatus are backend statuses that let a stale entry be served instead
+var errorStatus = map[int]bool{
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// unstoredHeaders are response headers that describe one exchange rather than the resource
+var unstoredHeaders = []string{"X-Request-Id", "Server-Timing", "Connection", "Keep-Alive", "Transfer-Encoding", "Age", StatusHeader}
+
+// Cache stores responses in Redis
+type Cache struct {
+	client redis.UniversalClient
+	prefix string
+	logger *logger.Logger
+}
+
+// New returns a cache storing entries under gateway:cache: in client
+func New(client redis.UniversalClient, log *logger.Logger) *Cache {
+	return &Cache{
+		client: client,
+		prefix: "gateway:cache:",
+		logger: log,
+	}
+}
+
+// entry is a stored response and the freshness it was stored with
+type entry struct {
+	Status               int           `json:"status"`
+	Header               http.Header   `json:"header"`
+	Body                 []byte        `json:"body"`
+	Stored               time.Time     `json:"stored"`
+	TTL                  time.Duration `json:"ttl"`
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate"`
+	StaleIfError         time.Duration `json:"stale_if_error"`
+}
+
+func (e *entry) age() time.Duration {
+	return time.Since(e.Stored)
+}
+
+// Middleware returns middleware caching a route's GET responses
+// Entries are per user on routes that require auth, and per value of the route's vary headers
+func (c *Cache) Middleware(route routes.Route) func(http.Handler) http.Handler {
+	config := *route.Cache
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := c.key(route, config, r)
+			cached, err := c.load(r.Context(), key)
+			if err != nil {
+				c.logger.WithContext(r.Context()).Warn("Response cache lookup failed for %s: %v", route.Name, err)
+			}
+
+			if cached != nil {
+				age := cached.age()
+				switch {
+				case age < cached.TTL:
+					metrics.RecordCacheRequest(route.Name, ResultHit)
+					serve(w, cached, "HIT")
+					return
+				case age < cached.TTL+cached.StaleWhileRevalidate:
+					metrics.RecordCacheRequest(route.Name, ResultStale)
+					serve(w, cached, "STALE")
+					c.revalidate(route, config, key, next, r)
+					return
+				}
+			}
+
+			// Fetch from the backend; if it fails and a stale entry is still usable, serve that instead
+			var fallback *entry
+			if cached != nil && cached.age() < cached.TTL+cached.StaleIfError {
+				fallback = cached
+			}
+			cw := &cacheWriter{ResponseWriter: w, header: make(http.Header), fallback: fallback, limit: config.MaxBodyBytes}
+			next.ServeHTTP(cw, r)
+			if cw.usedFallback {
+				c.logger.WithContext(r.Context()).Info("Serving stale %s response for %s after backend returned %d", route.Name, r.URL.Path, cw.status)
+				metrics.RecordCacheRequest(route.Name, ResultStaleIfError)
+				serve(w, fallback, "STALE-IF-ERROR")
+				return
+			}
+			metrics.RecordCacheRequest(route.Name, ResultMiss)
+			c.store(r.Context(), route, config, key, cw)
+		})
+	}
+}
+
+// revalidate refreshes an entry in the background; a Redis lock makes one gateway replica do it
+func (c *Cache) revalidate(route routes.Route, config routes.Cache, key string, next http.Handler, r *http.Request) {
+	ctx := context.WithoutCancel(r.Context())
+	locked, err := c.client.SetNX(ctx, key+":refresh", 1, refreshLockTTL).Result()
+	if err != nil || !locked {
+		return
+	}
+
+	refresh := r.Clone(ctx)
+	go func() {
+		defer c.client.Del(ctx, key+":refresh")
+		cw := &cacheWriter{ResponseWriter: discardWriter{}, header: make(http.Header), limit: config.MaxBodyBytes}
+		next.ServeHTTP(cw, refresh)
+		if !c.store(ctx, route, config, key, cw) {
+			c.logger.WithContext(ctx).Debug("Background refresh of %s %s not cached (status %d)", route.Name, r.URL.Path, cw.status)
+		}
+	}()
+}
+
+// store saves a captured response if it is cacheable, reporting whether it was stored
+func (c *Cache) store(ctx context.Context, route routes.Route, config routes.Cache, key string, cw *cacheWriter) bool {
+	if cw.usedFallback || cw.overflow || !cacheableStatus[cw.status] || cw.header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	e := &entry{
+		Status:               cw.status,
+		Header:               cw.header.Clone(),
+		Body:                 cw.body.Bytes(),
+		Stored:               time.Now(),
+		TTL:                  config.TTL.Duration,
+		StaleWhileRevalidate: config.StaleWhileRevalidate.Duration,
+		StaleIfError:         config.StaleIfError.Duration,
+	}
+
+	// The backend's Cache-Control refines the route's settings (RFC 9111 and RFC 5861)
+	directives := parseCacheControl(cw.header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	if _, ok := directives["private"]; ok && !route.RequireAuth {
+		return false
+	}
+	for name, target := range map[string]*time.Duration{
+		"max-age":                &e.TTL,
+		"stale-while-revalidate": &e.StaleWhileRevalidate,
+		"stale-if-error":         &e.StaleIfError,
+	} {
+		if seconds, ok := directives[name]; ok {
+			if n, err := strconv.Atoi(seconds); err == nil && n >= 0 {
+				*target = time.Duration(n) * time.Second
+			}
+		}
+	}
+	if seconds, ok := directives["s-maxage"]; ok {
+		if n, err := strconv.Atoi(seconds); err == nil && n >= 0 {
+			e.TTL = time.Duration(n) * time.Second
+		}
+	}
+	if e.TTL <= 0 {
+		return false
+	}
+
+	for _, name := range unstoredHeaders {
+		e.Header.Del(name)
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return false
+	}
+	expiry := e.TTL + max(e.StaleWhileRevalidate, e.StaleIfError)
+	if err := c.client.Set(ctx, key, data, expiry).Err(); err != nil {
+		c.logger.WithContext(ctx).Warn("Failed to store %s response in cache: %v", route.Name, err)
+		return false
+	}
+	return true
+}
+
+func (c *Cache) load(ctx context.Context, key string) (*entry, error) {
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("corrupt entry: %w", err)
+	}
+	return &e, nil
+}
+
+// key identifies a cached response by route, URL, user (on routes requiring auth) and vary headers
+func (c *Cache) key(route routes.Route, config routes.Cache, r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.RequestURI()))
+	if route.RequireAuth {
+		h.Write([]byte("\x00user=" + r.Header.Get("X-User-Email")))
+	}
+	for _, name := range config.Vary {
+		h.Write([]byte("\x00" + strings.ToLower(name) + "=" + r.Header.Get(name)))
+	}
+	return c.prefix + route.Name + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// serve writes a cached response with its age
+func serve(w http.ResponseWriter, e *entry, status string) {
+	for name, values := range e.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Age", strconv.Itoa(int(e.age().Seconds())))
+	w.Header().Set(StatusHeader, status)
+	w.WriteHeader(e.Status)
+	w.Write(e.Body)
+}
+
+// parseCacheControl returns Cache-Control directives, lower-cased, with their values
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// cacheWriter passes a response through while capturing it for the cache
+// If the backend answers with an error and a fallback entry is usable, nothing is written
+// so the fallback can be served instead
+type cacheWriter struct {
+	http.ResponseWriter
+	header       http.Header
+	status       int
+	body         bytes.Buffer
+	limit        int
+	overflow     bool
+	fallback     *entry
+	usedFallback bool
+	wroteHeader  bool
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	return cw.header
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+	if cw.fallback != nil && errorStatus[status] {
+		cw.usedFallback = true
+		return
+	}
+	for name, values := range cw.header {
+		cw.ResponseWriter.Header()[name] = values
+	}
+	cw.ResponseWriter.Header().Set(StatusHeader, "MISS")
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.usedFallback {
+		return len(b), nil
+	}
+	if !cw.overflow {
+		if cw.body.Len()+len(b) > cw.limit {
+			cw.overflow = true
+			cw.body.Reset()
+		} else {
+			cw.body.Write(b)
+		}
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// discardWriter is the client side of a background refresh
+type discardWriter struct{}
+
+func (discardWriter) Header() http.Header         { return make(http.Header) }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
@@ -0,0 +1,273 @@
+// Package capture records sanitized request/response exchanges for debugging
+package capture
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"nexus-common/requestid"
+)
+
+// HeaderName is the request header that asks for an exchange to be captured
+const HeaderName = "X-Debug-Capture"
+
+// bodyTooLarge stands in for bodies over the capture size limit
+const bodyTooLarge = "[body exceeds capture size limit]"
+
+// maxEntries bounds how many captures are held in memory at once
+const maxEntries = 500
+
+// Entry is a single captured request/response exchange
+type Entry struct {
+	ID              string              `json:"id"`
+	RequestID       string              `json:"request_id,omitempty"`
+	CapturedAt      time.Time           `json:"captured_at"`
+	DurationMs      int64               `json:"duration_ms"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"`
+	Status          int                 `json:"status"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	RequestBody     string              `json:"request_body,omitempty"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	ResponseBody    string              `json:"response_body,omitempty"`
+}
+
+// Config controls which requests are captured and how much is kept
+type Config struct {
+	Enabled      bool
+	Routes       []string      // path prefixes captured on every request
+	AllowHeader  bool          // honor the X-Debug-Capture request header
+	MaxBodyBytes int           // bodies larger than this are omitted
+	TTL          time.Duration // how long captures remain retrievable
+}
+
+// Capturer captures matching exchanges into an in-memory store
+type Capturer struct {
+	config   Config
+	redactor *Redactor
+	mu       sync.Mutex
+	entries  []Entry
+}
+
+// NewCapturer creates a new capturer
+func NewCapturer(config Config, redactor *Redactor) *Capturer {
+	return &Capturer{
+		config:   config,
+		redactor: redactor,
+	}
+}
+
+// Middleware returns middleware that captures matching exchanges
+func (c *Capturer) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.shouldCapture(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			c.add(exchange(w, r, next, c.redactor, c.config.MaxBodyBytes))
+		})
+	}
+}
+
+// exchange serves r with next and returns the sanitized exchange, with bodies over maxBodyBytes omitted
+func exchange(w http.ResponseWriter, r *http.Request, next http.Handler, redactor *Redactor, maxBodyBytes int) Entry {
+	start := time.Now()
+
+	// Tee the request body so the proxy still streams it upstream
+	reqBody := &cappedBuffer{limit: maxBodyBytes}
+	if r.Body != nil {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, reqBody), r.Body}
+	}
+
+	wrapped := &captureWriter{
+		ResponseWriter: w,
+		statusCode:     http.StatusOK,
+		body:           &cappedBuffer{limit: maxBodyBytes},
+	}
+
+	next.ServeHTTP(wrapped, r)
+
+	return Entry{
+		ID:              newID(),
+		RequestID:       requestid.FromContext(r.Context()),
+		CapturedAt:      start,
+		DurationMs:      time.Since(start).Milliseconds(),
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		Query:           redactor.Query(r.URL.RawQuery),
+		Status:          wrapped.statusCode,
+		RequestHeaders:  redactor.Headers(r.Header),
+		RequestBody:     redactedBody(redactor, r.Header.Get("Content-Type"), reqBody),
+		ResponseHeaders: redactor.Headers(w.Header()),
+		ResponseBody:    redactedBody(redactor, w.Header().Get("Content-Type"), wrapped.body),
+	}
+}
+
+// shouldCapture reports whether a request matches a captured route or carries the capture header
+func (c *Capturer) shouldCapture(r *http.Request) bool {
+	if !c.config.Enabled {
+		return false
+	}
+	if c.config.AllowHeader && r.Header.Get(HeaderName) != "" {
+		return true
+	}
+	for _, prefix := range c.config.Routes {
+		if prefix != "" && strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedBody renders a captured body, omitting it entirely if it exceeded the size cap
+func redactedBody(redactor *Redactor, contentType string, buf *cappedBuffer) string {
+	if buf.overflow {
+		return bodyTooLarge
+	}
+	return redactor.Body(contentType, buf.data)
+}
+
+// add stores an entry, evicting expired and excess entries
+func (c *Capturer) add(entry Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pruneLocked()
+	c.entries = append(c.entries, entry)
+	if len(c.entries) > maxEntries {
+		c.entries = c.entries[len(c.entries)-maxEntries:]
+	}
+}
+
+// List returns all unexpired captures, newest first
+func (c *Capturer) List() []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pruneLocked()
+	result := make([]Entry, 0, len(c.entries))
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		result = append(result, c.entries[i])
+	}
+	return result
+}
+
+// Get returns a single unexpired capture by ID
+func (c *Capturer) Get(id string) (Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pruneLocked()
+	for _, entry := range c.entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// pruneLocked drops entries older than the TTL; callers must hold c.mu
+// Entries are added when their response completes but stamped when the request started, so a slow
+// request can follow newer ones and every entry is checked
+func (c *Capturer) pruneLocked() {
+	cutoff := time.Now().Add(-c.config.TTL)
+	kept := c.entries[:0]
+	for _, entry := range c.entries {
+		if !entry.CapturedAt.Before(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	// Clear the dropped tail so expired bodies can be freed
+	clear(c.entries[len(kept):])
+	c.entries = kept
+}
+
+// ListHandler serves all unexpired captures as JSON
+func (c *Capturer) ListHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"captures": c.List(),
+	})
+}
+
+// GetHandler serves a single capture by ID as JSON
+func (c *Capturer) GetHandler(w http.ResponseWriter, r *http.Request) {
+	entry, ok := c.Get(mux.Vars(r)["id"])
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "capture not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// newID generates a random capture ID
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// cappedBuffer keeps at most limit bytes and records whether more were written
+type cappedBuffer struct {
+	limit    int
+	data     []byte
+	overflow bool
+}
+
+// Write buffers up to the limit and always reports success so streaming isn't interrupted
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.overflow {
+		return len(p), nil
+	}
+	if len(b.data)+len(p) > b.limit {
+		b.overflow = true
+		b.data = nil
+		return len(p), nil
+	}
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+// captureWriter records the status code and body written to the client
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       *cappedBuffer
+}
+
+// WriteHeader captures the status code
+func (cw *captureWriter) WriteHeader(code int) {
+	cw.statusCode = code
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write captures the response body
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	cw.body.Write(p)
+	return cw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush streamed responses
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
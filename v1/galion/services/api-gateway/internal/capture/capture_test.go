@@ -0,0 +1,35 @@
+package capture
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestExchangeRedactsQuery(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/users?token=s3cret&email=a%40example.com&page=2", nil)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	entry := exchange(httptest.NewRecorder(), r, next, NewRedactor(nil), 1024)
+
+	query, err := url.ParseQuery(entry.Query)
+	if err != nil {
+		t.Fatalf("parse captured query %q: %v", entry.Query, err)
+	}
+	for _, key := range []string{"token", "email"} {
+		if got := query.Get(key); got != redactedValue {
+			t.Errorf("got %s=%q in the captured query, want it redacted", key, got)
+		}
+	}
+	if got := query.Get("page"); got != "2" {
+		t.Errorf("got page=%q in the captured query, want 2", got)
+	}
+}
+
+func TestReplayQueryDropsRedacted(t *testing.T) {
+	stored := NewRedactor(nil).Query("api_key=k3y&page=2")
+	if got := replayQuery(stored); got != "page=2" {
+		t.Errorf("got replayed query %q, want page=2", got)
+	}
+}
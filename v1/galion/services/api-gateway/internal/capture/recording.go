@@ -0,0 +1,207 @@
+package capture
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-common/logger"
+)
+
+// Redis keys: the active recordings by route, and a list of stored requests per route, newest first
+const (
+	recordingsKey  = "gateway:captures:recordings"
+	requestsPrefix = "gateway:captures:requests:"
+)
+
+// recordingsRefresh is how often a replica picks up recordings started or stopped on another
+const recordingsRefresh = 5 * time.Second
+
+// Recording is an admin-started capture of every request to a route, until it expires or is stopped
+type Recording struct {
+	Route     string    `json:"route"`
+	StartedAt time.Time `json:"started_at"`
+	Until     time.Time `json:"until"`
+}
+
+// RecorderConfig controls how much recordings keep
+type RecorderConfig struct {
+	MaxBodyBytes  int           // bodies larger than this are omitted
+	MaxRequests   int           // stored requests kept per route, newest first
+	Retention     time.Duration // how long stored requests are kept after the last one
+	ReplayTimeout time.Duration // per replayed request
+}
+
+// Recorder stores sanitized exchanges of recorded routes in Redis, where every replica
+// adds to them and they outlive restarts, so they can be replayed against another backend
+type Recorder struct {
+	config   RecorderConfig
+	client   redis.UniversalClient
+	redactor *Redactor
+	http     *http.Client
+	logger   *logger.Logger
+	active   atomic.Pointer[map[string]Recording]
+}
+
+// NewRecorder creates a recorder; call Start to follow recordings started on other replicas
+func NewRecorder(config RecorderConfig, redactor *Redactor, client redis.UniversalClient, log *logger.Logger) *Recorder {
+	rec := &Recorder{
+		config:   config,
+		client:   client,
+		redactor: redactor,
+		http: &http.Client{
+			Timeout: config.ReplayTimeout,
+			// Replays show what the backend answered, not where it redirects to
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		logger: log,
+	}
+	rec.active.Store(&map[string]Recording{})
+	return rec
+}
+
+// Start reloads the active recordings from Redis until ctx is done
+func (rec *Recorder) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(recordingsRefresh)
+		defer ticker.Stop()
+		for {
+			if err := rec.refresh(ctx); err != nil && ctx.Err() == nil {
+				rec.logger.Error("Failed to load capture recordings: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refresh loads the active recordings, dropping expired ones
+func (rec *Recorder) refresh(ctx context.Context) error {
+	stored, err := rec.client.HGetAll(ctx, recordingsKey).Result()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	active := make(map[string]Recording, len(stored))
+	for route, value := range stored {
+		var recording Recording
+		if json.Unmarshal([]byte(value), &recording) != nil || !recording.Until.After(now) {
+			rec.client.HDel(ctx, recordingsKey, route)
+			continue
+		}
+		active[route] = recording
+	}
+	rec.active.Store(&active)
+	return nil
+}
+
+// Recordings returns the active recordings, sorted by route
+func (rec *Recorder) Recordings() []Recording {
+	now := time.Now()
+	recordings := []Recording{}
+	for _, recording := range *rec.active.Load() {
+		if recording.Until.After(now) {
+			recordings = append(recordings, recording)
+		}
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].Route < recordings[j].Route })
+	return recordings
+}
+
+// Begin records route's requests for duration, replacing any recording of it in progress
+func (rec *Recorder) Begin(ctx context.Context, route string, duration time.Duration) (Recording, error) {
+	now := time.Now().UTC()
+	recording := Recording{Route: route, StartedAt: now, Until: now.Add(duration)}
+	value, _ := json.Marshal(recording)
+	if err := rec.client.HSet(ctx, recordingsKey, route, value).Err(); err != nil {
+		return Recording{}, err
+	}
+	return recording, rec.refresh(ctx)
+}
+
+// End stops recording route; its stored requests are kept
+// It reports whether a recording was in progress
+func (rec *Recorder) End(ctx context.Context, route string) (bool, error) {
+	_, recording := (*rec.active.Load())[route]
+	if err := rec.client.HDel(ctx, recordingsKey, route).Err(); err != nil {
+		return false, err
+	}
+	return recording, rec.refresh(ctx)
+}
+
+// recording reports whether route is being recorded
+func (rec *Recorder) recording(route string) bool {
+	recording, ok := (*rec.active.Load())[route]
+	return ok && recording.Until.After(time.Now())
+}
+
+// Middleware stores the exchanges of route while it is being recorded
+func (rec *Recorder) Middleware(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !rec.recording(route) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			entry := exchange(w, r, next, rec.redactor, rec.config.MaxBodyBytes)
+
+			// The response is sent; storing it must not fail or hold up the request
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), time.Second)
+			defer cancel()
+			if err := rec.store(ctx, route, entry); err != nil {
+				rec.logger.WithContext(r.Context()).Error("Failed to store recorded request for %s: %v", route, err)
+			}
+		})
+	}
+}
+
+// store adds an entry to route's stored requests, dropping the oldest beyond MaxRequests
+func (rec *Recorder) store(ctx context.Context, route string, entry Entry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	key := requestsPrefix + route
+	pipe := rec.client.Pipeline()
+	pipe.LPush(ctx, key, value)
+	pipe.LTrim(ctx, key, 0, int64(rec.config.MaxRequests)-1)
+	pipe.Expire(ctx, key, rec.config.Retention)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Requests returns up to limit of route's stored requests, newest first
+func (rec *Recorder) Requests(ctx context.Context, route string, limit int) ([]Entry, error) {
+	values, err := rec.client.LRange(ctx, requestsPrefix+route, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(values))
+	for _, value := range values {
+		var entry Entry
+		if json.Unmarshal([]byte(value), &entry) == nil {
+			// Requests stored before queries were sanitized stay in Redis until they expire
+			entry.Query = rec.redactor.Query(entry.Query)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Stored returns how many requests are stored for route
+func (rec *Recorder) Stored(ctx context.Context, route string) (int64, error) {
+	return rec.client.LLen(ctx, requestsPrefix+route).Result()
+}
+
+// Clear deletes route's stored requests
+func (rec *Recorder) Clear(ctx context.Context, route string) error {
+	return rec.client.Del(ctx, requestsPrefix+route).Err()
+}
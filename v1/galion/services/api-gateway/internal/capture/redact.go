@@ -0,0 +1,162 @@
+package capture
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redactedValue replaces any sensitive value in a captured exchange
+const redactedValue = "[REDACTED]"
+
+// Placeholders for bodies that are not kept
+const (
+	unparseableJSON  = "[unparseable JSON body omitted]"
+	unparseableForm  = "[unparseable form body omitted]"
+	unparseableQuery = "[unparseable query omitted]"
+	nonJSONBody      = "[non-JSON body omitted]"
+)
+
+// sensitiveHeaders are always redacted from captured requests and responses
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Admin-Key",
+	"X-User-Email",
+}
+
+// defaultSensitiveFields are body fields treated as credentials or PII
+var defaultSensitiveFields = []string{
+	"password",
+	"new_password",
+	"old_password",
+	"token",
+	"access_token",
+	"refresh_token",
+	"secret",
+	"api_key",
+	"email",
+	"phone",
+	"phone_number",
+	"address",
+	"ssn",
+	"credit_card",
+	"card_number",
+	"cvv",
+	"ip",
+	"ip_address",
+}
+
+// Redactor removes credentials and PII from captured headers and bodies
+type Redactor struct {
+	fields map[string]bool
+}
+
+// NewRedactor creates a redactor for the default sensitive fields plus any extra field names
+func NewRedactor(extraFields []string) *Redactor {
+	fields := make(map[string]bool)
+	for _, f := range defaultSensitiveFields {
+		fields[f] = true
+	}
+	for _, f := range extraFields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != "" {
+			fields[f] = true
+		}
+	}
+	return &Redactor{fields: fields}
+}
+
+// Headers returns a copy of the headers with sensitive values redacted
+func (rd *Redactor) Headers(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for key, values := range h {
+		out[key] = append([]string(nil), values...)
+	}
+	for _, key := range sensitiveHeaders {
+		canonical := http.CanonicalHeaderKey(key)
+		if _, ok := out[canonical]; ok {
+			out[canonical] = []string{redactedValue}
+		}
+	}
+	return out
+}
+
+// Body returns a redacted representation of a body
+// JSON and form bodies have sensitive fields masked; anything else is omitted
+// because free-form content can't be scanned for PII reliably
+func (rd *Redactor) Body(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return unparseableJSON
+		}
+		redacted, err := json.Marshal(rd.value(v))
+		if err != nil {
+			return unparseableJSON
+		}
+		return string(redacted)
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return unparseableForm
+		}
+		return rd.values(values)
+	default:
+		return nonJSONBody
+	}
+}
+
+// Query returns a raw query string with the values of sensitive parameters masked
+func (rd *Redactor) Query(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return unparseableQuery
+	}
+	return rd.values(values)
+}
+
+// values masks sensitive keys of a form or query and encodes it
+func (rd *Redactor) values(values url.Values) string {
+	for key := range values {
+		if rd.fields[strings.ToLower(key)] {
+			values[key] = []string{redactedValue}
+		}
+	}
+	return values.Encode()
+}
+
+// value walks a decoded JSON value and masks sensitive object keys
+func (rd *Redactor) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for key, child := range t {
+			if rd.fields[strings.ToLower(key)] {
+				t[key] = redactedValue
+				continue
+			}
+			t[key] = rd.value(child)
+		}
+		return t
+	case []interface{}:
+		for i, child := range t {
+			t[i] = rd.value(child)
+		}
+		return t
+	default:
+		return v
+	}
+}
@@ -0,0 +1,151 @@
+package capture
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ReplayHeader marks replayed requests with the ID of the stored request they repeat
+const ReplayHeader = "X-Replay-Of"
+
+// replayDroppedHeaders are recorded headers not sent again: hop-by-hop headers, headers the
+// client recomputes, and capture headers
+var replayDroppedHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+	"Accept-Encoding",
+	"Host",
+	HeaderName,
+}
+
+// ReplayResult compares a replayed request's response with the recorded one
+type ReplayResult struct {
+	ID             string `json:"id"`
+	Method         string `json:"method"`
+	Path           string `json:"path"`
+	OriginalStatus int    `json:"original_status"`
+	Status         int    `json:"status,omitempty"`
+	DurationMs     int64  `json:"duration_ms"`
+	StatusMatched  bool   `json:"status_matched"`
+	// BodyMatched compares the sanitized bodies; it is left out when either body wasn't kept
+	BodyMatched *bool  `json:"body_matched,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Replay sends route's stored requests, oldest first, to target (a base URL such as a staging backend)
+// Only the stored requests with the given IDs are sent, or all of them if there are none
+// headers are set on every request, e.g. credentials in place of the redacted ones
+func (rec *Recorder) Replay(ctx context.Context, route, target string, ids []string, headers map[string]string) ([]ReplayResult, error) {
+	entries, err := rec.Requests(ctx, route, rec.config.MaxRequests)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	results := []ReplayResult{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if len(wanted) > 0 && !wanted[entries[i].ID] {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		results = append(results, rec.replay(ctx, entries[i], strings.TrimSuffix(target, "/"), headers))
+	}
+	return results, nil
+}
+
+// replay sends one stored request and compares the response
+func (rec *Recorder) replay(ctx context.Context, entry Entry, target string, headers map[string]string) ReplayResult {
+	result := ReplayResult{ID: entry.ID, Method: entry.Method, Path: entry.Path, OriginalStatus: entry.Status}
+	if omittedBody(entry.RequestBody) {
+		result.Error = "request body was not recorded"
+		return result
+	}
+
+	address := target + entry.Path
+	if query := replayQuery(entry.Query); query != "" {
+		address += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, entry.Method, address, strings.NewReader(entry.RequestBody))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for name, values := range entry.RequestHeaders {
+		if len(values) == 1 && values[0] == redactedValue {
+			continue
+		}
+		req.Header[name] = values
+	}
+	for _, name := range replayDroppedHeaders {
+		req.Header.Del(name)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set(ReplayHeader, entry.ID)
+
+	start := time.Now()
+	resp, err := rec.http.Do(req)
+	if err != nil {
+		result.DurationMs = time.Since(start).Milliseconds()
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	body := &cappedBuffer{limit: rec.config.MaxBodyBytes}
+	_, err = io.Copy(body, resp.Body)
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.Status = resp.StatusCode
+	result.StatusMatched = resp.StatusCode == entry.Status
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	replayed := redactedBody(rec.redactor, resp.Header.Get("Content-Type"), body)
+	if !omittedBody(entry.ResponseBody) && !omittedBody(replayed) {
+		// Sanitized JSON is re-encoded with sorted keys, so equal values compare equal
+		matched := entry.ResponseBody == replayed
+		result.BodyMatched = &matched
+	}
+	return result
+}
+
+// omittedBody reports whether a recorded body is a placeholder for one that wasn't kept
+func omittedBody(body string) bool {
+	switch body {
+	case bodyTooLarge, unparseableJSON, unparseableForm, nonJSONBody:
+		return true
+	}
+	return false
+}
+
+// replayQuery is a stored query without its redacted parameters, which aren't sent again, like
+// redacted headers; credentials the target needs are set through the replay's headers
+func replayQuery(stored string) string {
+	values, err := url.ParseQuery(stored)
+	if err != nil {
+		return ""
+	}
+	for key, vals := range values {
+		if len(vals) == 1 && vals[0] == redactedValue {
+			delete(values, key)
+		}
+	}
+	return values.Encode()
+}
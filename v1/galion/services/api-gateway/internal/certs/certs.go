@@ -0,0 +1,165 @@
+// Package certs provides TLS certificates for the gateway's HTTPS listener
+// from cert/key files (reloaded when they change) or from ACME (Let's Encrypt)
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"nexus-common/logger"
+)
+
+// Config configures certificate sources
+// Set either CertFile and KeyFile, or ACMEDomains
+type Config struct {
+	CertFile         string
+	KeyFile          string
+	ReloadInterval   time.Duration
+	ACMEDomains      []string
+	ACMEEmail        string
+	ACMECacheDir     string
+	ACMEDirectoryURL string // defaults to Let's Encrypt production
+}
+
+// NewTLSConfig returns the TLS config for the HTTPS listener
+// With ACME it also returns a handler wrapper that answers HTTP-01 challenges on the plain HTTP listener;
+// otherwise the wrapper returns its handler unchanged
+func NewTLSConfig(ctx context.Context, config Config, log *logger.Logger) (*tls.Config, func(http.Handler) http.Handler, error) {
+	if len(config.ACMEDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.ACMEDomains...),
+			Cache:      autocert.DirCache(config.ACMECacheDir),
+			Email:      config.ACMEEmail,
+		}
+		if config.ACMEDirectoryURL != "" {
+			manager.Client = &acme.Client{DirectoryURL: config.ACMEDirectoryURL}
+		}
+		log.Info("Obtaining TLS certificates via ACME for %v", config.ACMEDomains)
+
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler, nil
+	}
+
+	reloader, err := NewReloader(config.CertFile, config.KeyFile, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	go reloader.Watch(ctx, config.ReloadInterval)
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	passthrough := func(h http.Handler) http.Handler { return h }
+	return tlsConfig, passthrough, nil
+}
+
+// Reloader serves a certificate from files and reloads it when the files change
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *logger.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the certificate and key, failing if they are invalid
+func NewReloader(certFile, keyFile string, log *logger.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   log,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate (for tls.Config.GetCertificate)
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files for changes on each interval until ctx is done
+// A certificate that fails to load is logged and the previous one kept
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.latestModTime()
+		if err != nil {
+			r.logger.Error("Failed to check TLS certificate files: %v", err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := modTime.After(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			r.logger.Error("Failed to reload TLS certificate, keeping the current one: %v", err)
+			continue
+		}
+		r.logger.Info("Reloaded TLS certificate from %s", r.certFile)
+	}
+}
+
+// load reads the certificate and key pair
+func (r *Reloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("failed to stat TLS certificate files: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// latestModTime returns the newer modification time of the cert and key files
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
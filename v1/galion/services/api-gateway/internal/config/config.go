@@ -0,0 +1,349 @@
+// Package config defines the gateway settings, loaded from flags, environment variables, a config file and defaults
+package config
+
+import (
+	"time"
+
+	"nexus-common/settings"
+	"nexus-common/version"
+)
+
+// Config holds the gateway configuration
+type Config struct {
+	Port                      string
+	ServerReadTimeout         time.Duration
+	ServerReadHeaderTimeout   time.Duration
+	ServerWriteTimeout        time.Duration
+	ServerIdleTimeout         time.Duration
+	ServerMaxHeaderBytes      int
+	Environment               string
+	ConfigStrict              bool
+	Debug                     bool
+	JWTSecretKey              string
+	JWTAlgorithm              string
+	AuthServiceURL            string
+	UserServiceURL            string
+	ContentServiceURL         string
+	RoutesFile                string
+	MockBackends              bool
+	MockFixturesDir           string
+	HealthCheckInterval       time.Duration
+	HealthCheckTimeout        time.Duration
+	CircuitBreakerThreshold   int
+	CircuitBreakerOpenTimeout time.Duration
+	DiscoveryConsulAddr       string
+	DiscoveryConsulToken      string
+	DiscoveryKubernetesAPI    string
+	DiscoveryRetryInterval    time.Duration
+	ReadinessCheckRedis       bool
+	HealthDeepTimeout         time.Duration
+	ReadinessMinUpstreams     int
+	RedisURL                  string
+	RedisMode                 string
+	RedisAddrs                []string
+	RedisSentinelMaster       string
+	RedisSentinelPassword     string
+	RedisUsername             string
+	RedisPassword             string
+	RedisTLSCAFile            string
+	RedisPoolSize             int
+	RedisMinIdleConns         int
+	RedisPoolTimeout          time.Duration
+	RateLimitEnabled          bool
+	RateLimitPerMinute        int
+	AllowedOrigins            []string
+	AdminHost                 string
+	AdminPort                 string
+	AdminAPIKey               string
+	AdminOperatorKeys         []string
+	InternalHost              string
+	InternalPort              string
+	ServiceTokens             []string
+	AuditDatabaseURL          string
+	CaptureEnabled            bool
+	CaptureRoutes             []string
+	CaptureAllowHeader        bool
+	CaptureMaxBodyBytes       int
+	CaptureTTL                time.Duration
+	CaptureRedactFields       []string
+	CaptureRecordingMax       int
+	CaptureRecordingRetention time.Duration
+	CaptureReplayTargets      []string
+	CaptureReplayTimeout      time.Duration
+	KafkaBrokers              []string
+	AccessLogKafkaEnabled     bool
+	AccessLogTopic            string
+	RequestEventsEnabled      bool
+	RequestEventsTopic        string
+	EventsUserHashKey         string
+	GraphQLEnabled            bool
+	GraphQLMaxDepth           int
+	GraphQLMaxFields          int
+	GraphQLConcurrency        int
+	BatchEnabled              bool
+	BatchMaxRequests          int
+	BatchConcurrency          int
+	NotificationsEnabled      bool
+	NotificationsHeartbeat    time.Duration
+	NotificationsMaxPerUser   int
+	UploadsEnabled            bool
+	UploadsS3Bucket           string
+	UploadsS3Region           string
+	UploadsS3Endpoint         string
+	UploadsS3AccessKeyID      string
+	UploadsS3SecretKey        string
+	UploadsS3SessionToken     string
+	UploadsKeyPrefix          string
+	UploadsURLTTL             time.Duration
+	UploadsMaxBytes           int
+	UploadsContentTypes       []string
+	WebhooksEnabled           bool
+	WebhookSigningSecret      string
+	WebhookKafkaTopic         string
+	WebhookWorkers            int
+	WebhookMaxAttempts        int
+	WebhookRetryBase          time.Duration
+	WebhookRetryMax           time.Duration
+	WebhookTimeout            time.Duration
+	WebhookRetention          time.Duration
+	WebhookAllowPrivate       bool
+	UsageBillingDay           int
+	StatusEnabled             bool
+	APIKeysEnabled            bool
+	APIKeysMaxPerUser         int
+	OverviewEnabled           bool
+	OverviewFeedLimit         int
+	AggregateSectionTimeout   time.Duration
+	StatusCacheTTL            time.Duration
+	StatusDegradedErrorRate   float64
+	StatusMinRequests         int
+	UsageRetention            time.Duration
+	DocsEnabled               bool
+	DocsBasicAuth             []string
+	DocsRefreshInterval       time.Duration
+	DocsSwaggerUIURL          string
+	SentryDSN                 string
+	SentryEnvironment         string
+	SentryRelease             string
+	SentrySampleRate          float64
+	TracingEnabled            bool
+	ServerTimingEnabled       bool
+	MetricsLatencyBuckets     []float64
+	MetricsMaxPathsPerRoute   int
+	LogOutputs                []string
+	SyslogAddr                string
+	SyslogNetwork             string
+	GELFAddr                  string
+	GELFNetwork               string
+	LogTLSCAFile              string
+	GracefulRestartEnabled    bool
+	PIDFile                   string
+	RestartUpgradeTimeout     time.Duration
+	ShutdownTimeout           time.Duration
+	ShutdownDelay             time.Duration
+	PreStopPort               string
+	Plugins                   []string
+	FeatureFlagsSource        string
+	FeatureFlags              string
+	FeatureFlagsFile          string
+	FeatureFlagsRedisKey      string
+	FeatureFlagsRefresh       time.Duration
+	TLSPort                   string
+	TLSCertFile               string
+	TLSKeyFile                string
+	TLSReloadInterval         time.Duration
+	TLSACMEDomains            []string
+	TLSACMEEmail              string
+	TLSACMECacheDir           string
+	TLSACMEDirectoryURL       string
+
+	// Set records where each value came from and prints the configuration
+	*settings.Set
+}
+
+// fieldTable lists every setting with its default
+func (c *Config) fieldTable() []settings.Field {
+	return []settings.Field{
+		{Name: "PORT", Default: "8080", Usage: "HTTP listen port", Value: settings.String(&c.Port)},
+		{Name: "SERVER_READ_TIMEOUT", Default: "15s", Usage: "Time to read a whole request, body included", Value: settings.Duration(&c.ServerReadTimeout)},
+		{Name: "SERVER_READ_HEADER_TIMEOUT", Default: "5s", Usage: "Time to read request headers (slowloris protection)", Value: settings.Duration(&c.ServerReadHeaderTimeout)},
+		{Name: "SERVER_WRITE_TIMEOUT", Default: "15s", Usage: "Time to write a response, from the end of the request headers", Value: settings.Duration(&c.ServerWriteTimeout)},
+		{Name: "SERVER_IDLE_TIMEOUT", Default: "60s", Usage: "Time an idle keep-alive connection stays open", Value: settings.Duration(&c.ServerIdleTimeout)},
+		{Name: "SERVER_MAX_HEADER_BYTES", Default: "1048576", Usage: "Largest request header block, in bytes", Value: settings.Int(&c.ServerMaxHeaderBytes)},
+		{Name: "ENVIRONMENT", Default: "development", Usage: "Deployment environment", Value: settings.String(&c.Environment)},
+		{Name: "CONFIG_STRICT", Default: "false", Usage: "Fail on unknown NEXUS_ environment variables instead of warning", Value: settings.Bool(&c.ConfigStrict)},
+		{Name: "DEBUG", Default: "true", Usage: "Enable debug logging", Value: settings.Bool(&c.Debug)},
+		{Name: "JWT_SECRET_KEY", Default: "dev-secret-key-change-this-in-production", Usage: "JWT signing secret", Value: settings.String(&c.JWTSecretKey), Redact: settings.RedactSecret},
+		{Name: "JWT_ALGORITHM", Default: "HS256", Usage: "JWT signing algorithm", Value: settings.String(&c.JWTAlgorithm)},
+		{Name: "AUTH_SERVICE_URL", Default: "http://localhost:8000", Usage: "Auth service URL", Value: settings.String(&c.AuthServiceURL)},
+		{Name: "USER_SERVICE_URL", Default: "http://localhost:8001", Usage: "User service URL", Value: settings.String(&c.UserServiceURL)},
+		{Name: "CONTENT_SERVICE_URL", Default: "http://localhost:8002", Usage: "Content service URL", Value: settings.String(&c.ContentServiceURL)},
+		{Name: "ROUTES_FILE", Usage: "JSON route file (built-in routes if empty)", Value: settings.String(&c.RoutesFile)},
+		{Name: "MOCK_BACKENDS", Default: "false", Usage: "Serve canned fixtures instead of proxying to backends (local development)", Value: settings.Bool(&c.MockBackends)},
+		{Name: "MOCK_FIXTURES_DIR", Default: "mocks", Usage: "Directory of <route>.json mock fixture files", Value: settings.String(&c.MockFixturesDir)},
+		{Name: "UPSTREAM_HEALTH_CHECK_INTERVAL", Default: "10s", Usage: "Backend health check interval", Value: settings.Duration(&c.HealthCheckInterval)},
+		{Name: "UPSTREAM_HEALTH_CHECK_TIMEOUT", Default: "2s", Usage: "Backend health check timeout", Value: settings.Duration(&c.HealthCheckTimeout)},
+		{Name: "CIRCUIT_BREAKER_THRESHOLD", Default: "5", Usage: "Consecutive failures that open a backend's circuit", Value: settings.Int(&c.CircuitBreakerThreshold)},
+		{Name: "CIRCUIT_BREAKER_OPEN_TIMEOUT", Default: "30s", Usage: "Time a circuit stays open before a trial request", Value: settings.Duration(&c.CircuitBreakerOpenTimeout)},
+		{Name: "DISCOVERY_CONSUL_ADDR", Default: "http://localhost:8500", Usage: "Consul HTTP API for routes using consul discovery", Value: settings.String(&c.DiscoveryConsulAddr)},
+		{Name: "DISCOVERY_CONSUL_TOKEN", Usage: "Consul ACL token", Value: settings.String(&c.DiscoveryConsulToken), Redact: settings.RedactSecret},
+		{Name: "DISCOVERY_KUBERNETES_API", Usage: "Kubernetes API server for routes using kubernetes discovery (in-cluster if empty)", Value: settings.String(&c.DiscoveryKubernetesAPI)},
+		{Name: "DISCOVERY_RETRY_INTERVAL", Default: "5s", Usage: "Time between attempts to re-establish a failed discovery watch", Value: settings.Duration(&c.DiscoveryRetryInterval)},
+		{Name: "READINESS_CHECK_REDIS", Default: "true", Usage: "Require Redis for readiness", Value: settings.Bool(&c.ReadinessCheckRedis)},
+		{Name: "HEALTH_DEEP_TIMEOUT", Default: "2s", Usage: "Time limit for /health/deep backend probes", Value: settings.Duration(&c.HealthDeepTimeout)},
+		{Name: "READINESS_MIN_HEALTHY_UPSTREAMS", Default: "1", Usage: "Healthy backends required for readiness", Value: settings.Int(&c.ReadinessMinUpstreams)},
+		{Name: "REDIS_URL", Default: "redis://localhost:6379/0", Usage: "Redis connection URL", Value: settings.String(&c.RedisURL), Redact: settings.RedactURL},
+		{Name: "REDIS_MODE", Default: "standalone", Usage: "Redis deployment: standalone, sentinel or cluster", Value: settings.String(&c.RedisMode)},
+		{Name: "REDIS_ADDRS", Usage: "Sentinel addresses, or Cluster seed nodes (host:port, comma-separated)", Value: settings.Slice(&c.RedisAddrs)},
+		{Name: "REDIS_SENTINEL_MASTER", Usage: "Master name to discover through Sentinel", Value: settings.String(&c.RedisSentinelMaster)},
+		{Name: "REDIS_SENTINEL_PASSWORD", Usage: "Password for the Sentinels themselves", Value: settings.String(&c.RedisSentinelPassword), Redact: settings.RedactSecret},
+		{Name: "REDIS_USERNAME", Usage: "Redis ACL username (overrides the one in REDIS_URL)", Value: settings.String(&c.RedisUsername)},
+		{Name: "REDIS_PASSWORD", Usage: "Redis password (overrides the one in REDIS_URL)", Value: settings.String(&c.RedisPassword), Redact: settings.RedactSecret},
+		{Name: "REDIS_TLS_CA_FILE", Usage: "CA bundle for rediss:// connections (system roots if empty)", Value: settings.String(&c.RedisTLSCAFile)},
+		{Name: "REDIS_POOL_SIZE", Default: "0", Usage: "Connections per Redis node (0 for 10 per CPU)", Value: settings.Int(&c.RedisPoolSize)},
+		{Name: "REDIS_MIN_IDLE_CONNS", Default: "0", Usage: "Idle connections kept open per Redis node", Value: settings.Int(&c.RedisMinIdleConns)},
+		{Name: "REDIS_POOL_TIMEOUT", Default: "4s", Usage: "Time a command waits for a free connection when the pool is exhausted", Value: settings.Duration(&c.RedisPoolTimeout)},
+		{Name: "RATE_LIMIT_ENABLED", Default: "true", Usage: "Enable rate limiting", Value: settings.Bool(&c.RateLimitEnabled)},
+		{Name: "RATE_LIMIT_REQUESTS_PER_MINUTE", Default: "60", Usage: "Requests per minute per client", Value: settings.Int(&c.RateLimitPerMinute)},
+		{Name: "ALLOWED_ORIGINS", Default: "http://localhost:3000", Usage: "CORS allowed origins (comma-separated)", Value: settings.Slice(&c.AllowedOrigins)},
+		{Name: "ADMIN_HOST", Default: "127.0.0.1", Usage: "Interface the admin server binds to (empty for all interfaces)", Value: settings.String(&c.AdminHost)},
+		{Name: "ADMIN_PORT", Default: "9091", Usage: "Admin server port", Value: settings.String(&c.AdminPort)},
+		{Name: "ADMIN_API_KEY", Usage: "Shared admin API key, for read-only admin requests (admin server disabled if empty without ADMIN_OPERATOR_KEYS)", Value: settings.String(&c.AdminAPIKey), Redact: settings.RedactSecret},
+		{Name: "ADMIN_OPERATOR_KEYS", Usage: "Admin keys of individual operators, as operator=key (comma-separated); admin actions need one and are audited as its operator", Value: settings.Slice(&c.AdminOperatorKeys), Redact: settings.RedactSecret},
+		{Name: "INTERNAL_HOST", Usage: "Interface the internal listener binds to (empty for all interfaces)", Value: settings.String(&c.InternalHost)},
+		{Name: "INTERNAL_PORT", Usage: "Port of the internal listener for service-to-service calls (disabled if empty)", Value: settings.String(&c.InternalPort)},
+		{Name: "SERVICE_TOKENS", Usage: "Tokens accepted on the internal listener, as service=token (comma-separated)", Value: settings.Slice(&c.ServiceTokens), Redact: settings.RedactSecret},
+		{Name: "AUDIT_DATABASE_URL", Usage: "PostgreSQL URL for the admin audit trail", Value: settings.String(&c.AuditDatabaseURL), Redact: settings.RedactURL},
+		{Name: "CAPTURE_ENABLED", Default: "false", Usage: "Enable debug capture", Value: settings.Bool(&c.CaptureEnabled)},
+		{Name: "CAPTURE_ROUTES", Usage: "Path prefixes to capture (comma-separated)", Value: settings.Slice(&c.CaptureRoutes)},
+		{Name: "CAPTURE_ALLOW_HEADER", Default: "false", Usage: "Capture requests sent with X-Debug-Capture: 1", Value: settings.Bool(&c.CaptureAllowHeader)},
+		{Name: "CAPTURE_MAX_BODY_BYTES", Default: "16384", Usage: "Largest captured body", Value: settings.Int(&c.CaptureMaxBodyBytes)},
+		{Name: "CAPTURE_TTL", Default: "15m", Usage: "How long captures are kept", Value: settings.Duration(&c.CaptureTTL)},
+		{Name: "CAPTURE_REDACT_FIELDS", Usage: "Extra body fields to redact (comma-separated)", Value: settings.Slice(&c.CaptureRedactFields)},
+		{Name: "CAPTURE_RECORDING_MAX_REQUESTS", Default: "1000", Usage: "Requests kept per recorded route", Value: settings.Int(&c.CaptureRecordingMax)},
+		{Name: "CAPTURE_RECORDING_RETENTION", Default: "168h", Usage: "How long recorded requests are kept", Value: settings.Duration(&c.CaptureRecordingRetention)},
+		{Name: "CAPTURE_REPLAY_TARGETS", Usage: "Base URLs recorded requests may be replayed to (comma-separated)", Value: settings.Slice(&c.CaptureReplayTargets)},
+		{Name: "CAPTURE_REPLAY_TIMEOUT", Default: "10s", Usage: "Timeout per replayed request", Value: settings.Duration(&c.CaptureReplayTimeout)},
+		{Name: "KAFKA_BROKERS", Default: "localhost:9092", Usage: "Kafka brokers (comma-separated)", Value: settings.Slice(&c.KafkaBrokers)},
+		{Name: "ACCESS_LOG_KAFKA_ENABLED", Default: "false", Usage: "Publish access log events to Kafka", Value: settings.Bool(&c.AccessLogKafkaEnabled)},
+		{Name: "ACCESS_LOG_TOPIC", Default: "gateway-access", Usage: "Kafka topic for access log events", Value: settings.String(&c.AccessLogTopic)},
+		{Name: "REQUEST_EVENTS_ENABLED", Default: "false", Usage: "Publish a compact usage event per request to Kafka", Value: settings.Bool(&c.RequestEventsEnabled)},
+		{Name: "REQUEST_EVENTS_TOPIC", Default: "gateway-requests", Usage: "Kafka topic for request events", Value: settings.String(&c.RequestEventsTopic)},
+		{Name: "EVENTS_USER_HASH_KEY", Usage: "HMAC key hashing user emails into the user_id of published events, at least 32 characters", Value: settings.String(&c.EventsUserHashKey), Redact: settings.RedactSecret},
+		{Name: "GRAPHQL_ENABLED", Default: "true", Usage: "Serve the /graphql endpoint over the users and content routes", Value: settings.Bool(&c.GraphQLEnabled)},
+		{Name: "GRAPHQL_MAX_DEPTH", Default: "8", Usage: "Deepest selection nesting a GraphQL query may use", Value: settings.Int(&c.GraphQLMaxDepth)},
+		{Name: "GRAPHQL_MAX_FIELDS", Default: "100", Usage: "Fields a GraphQL query may select, counting each alias and nested field", Value: settings.Int(&c.GraphQLMaxFields)},
+		{Name: "GRAPHQL_CONCURRENCY", Default: "8", Usage: "Backend requests a GraphQL query may have in flight at once", Value: settings.Int(&c.GraphQLConcurrency)},
+		{Name: "BATCH_ENABLED", Default: "true", Usage: "Serve POST /api/v1/batch, running several API requests in one round trip", Value: settings.Bool(&c.BatchEnabled)},
+		{Name: "BATCH_MAX_REQUESTS", Default: "20", Usage: "Most sub-requests a batch may contain", Value: settings.Int(&c.BatchMaxRequests)},
+		{Name: "BATCH_CONCURRENCY", Default: "8", Usage: "Sub-requests of a batch run at once", Value: settings.Int(&c.BatchConcurrency)},
+		{Name: "NOTIFICATIONS_ENABLED", Default: "true", Usage: "Serve GET /api/v1/notifications/stream, relaying messages backends publish to Redis", Value: settings.Bool(&c.NotificationsEnabled)},
+		{Name: "NOTIFICATIONS_HEARTBEAT", Default: "25s", Usage: "Interval of keep-alive comments on idle notification streams", Value: settings.Duration(&c.NotificationsHeartbeat)},
+		{Name: "NOTIFICATIONS_MAX_STREAMS_PER_USER", Default: "5", Usage: "Notification streams a user may hold open on each replica", Value: settings.Int(&c.NotificationsMaxPerUser)},
+		{Name: "UPLOADS_ENABLED", Default: "false", Usage: "Serve POST /api/v1/uploads, issuing pre-signed S3 upload URLs", Value: settings.Bool(&c.UploadsEnabled)},
+		{Name: "UPLOADS_S3_BUCKET", Usage: "Bucket uploads are stored in", Value: settings.String(&c.UploadsS3Bucket)},
+		{Name: "UPLOADS_S3_REGION", Default: "us-east-1", Usage: "Region of the upload bucket", Value: settings.String(&c.UploadsS3Region)},
+		{Name: "UPLOADS_S3_ENDPOINT", Usage: "S3-compatible endpoint such as MinIO, addressed path-style (AWS if empty)", Value: settings.String(&c.UploadsS3Endpoint)},
+		{Name: "UPLOADS_S3_ACCESS_KEY_ID", Usage: "Access key upload URLs are signed with", Value: settings.String(&c.UploadsS3AccessKeyID)},
+		{Name: "UPLOADS_S3_SECRET_ACCESS_KEY", Usage: "Secret key upload URLs are signed with", Value: settings.String(&c.UploadsS3SecretKey), Redact: settings.RedactSecret},
+		{Name: "UPLOADS_S3_SESSION_TOKEN", Usage: "Session token of temporary S3 credentials", Value: settings.String(&c.UploadsS3SessionToken), Redact: settings.RedactSecret},
+		{Name: "UPLOADS_KEY_PREFIX", Default: "uploads/", Usage: "Prefix of uploaded object keys", Value: settings.String(&c.UploadsKeyPrefix)},
+		{Name: "UPLOADS_URL_TTL", Default: "15m", Usage: "How long an upload URL is valid", Value: settings.Duration(&c.UploadsURLTTL)},
+		{Name: "UPLOADS_MAX_BYTES", Default: "1073741824", Usage: "Largest upload in bytes", Value: settings.Int(&c.UploadsMaxBytes)},
+		{Name: "UPLOADS_CONTENT_TYPES", Usage: "Allowed upload content types, e.g. image/*,video/mp4 (comma-separated; any if empty)", Value: settings.Slice(&c.UploadsContentTypes)},
+		{Name: "WEBHOOKS_ENABLED", Default: "false", Usage: "Deliver webhooks enqueued by backends on the internal listener or WEBHOOK_KAFKA_TOPIC", Value: settings.Bool(&c.WebhooksEnabled)},
+		{Name: "WEBHOOK_SIGNING_SECRET", Usage: "HMAC secret signing webhook deliveries that don't carry their own", Value: settings.String(&c.WebhookSigningSecret), Redact: settings.RedactSecret},
+		{Name: "WEBHOOK_KAFKA_TOPIC", Usage: "Kafka topic of webhook requests to deliver (not consumed if empty)", Value: settings.String(&c.WebhookKafkaTopic)},
+		{Name: "WEBHOOK_WORKERS", Default: "4", Usage: "Webhook deliveries each replica sends at once", Value: settings.Int(&c.WebhookWorkers)},
+		{Name: "WEBHOOK_MAX_ATTEMPTS", Default: "8", Usage: "Attempts before a webhook delivery fails", Value: settings.Int(&c.WebhookMaxAttempts)},
+		{Name: "WEBHOOK_RETRY_BASE", Default: "10s", Usage: "Delay before the first webhook retry, doubled for each later one", Value: settings.Duration(&c.WebhookRetryBase)},
+		{Name: "WEBHOOK_RETRY_MAX", Default: "1h", Usage: "Longest delay between webhook attempts", Value: settings.Duration(&c.WebhookRetryMax)},
+		{Name: "WEBHOOK_TIMEOUT", Default: "10s", Usage: "Timeout of each webhook delivery attempt", Value: settings.Duration(&c.WebhookTimeout)},
+		{Name: "WEBHOOK_RETENTION", Default: "168h", Usage: "How long webhook delivery records are kept", Value: settings.Duration(&c.WebhookRetention)},
+		{Name: "WEBHOOK_ALLOW_PRIVATE_TARGETS", Default: "false", Usage: "Allow webhook URLs resolving to loopback, private or link-local addresses", Value: settings.Bool(&c.WebhookAllowPrivate)},
+		{Name: "USAGE_BILLING_DAY", Default: "1", Usage: "Day of the month billing periods of the usage report start on", Value: settings.Int(&c.UsageBillingDay)},
+		{Name: "USAGE_RETENTION", Default: "2160h", Usage: "How long per-user daily request counts are kept", Value: settings.Duration(&c.UsageRetention)},
+		{Name: "API_KEYS_ENABLED", Default: "true", Usage: "Serve /api/v1/api-keys, where users manage their own API keys", Value: settings.Bool(&c.APIKeysEnabled)},
+		{Name: "API_KEYS_MAX_PER_USER", Default: "10", Usage: "API keys a user may hold at once", Value: settings.Int(&c.APIKeysMaxPerUser)},
+		{Name: "OVERVIEW_ENABLED", Default: "true", Usage: "Serve /api/v1/me/overview, the user's profile and feed in one response", Value: settings.Bool(&c.OverviewEnabled)},
+		{Name: "OVERVIEW_FEED_LIMIT", Default: "10", Usage: "Content items in the overview feed", Value: settings.Int(&c.OverviewFeedLimit)},
+		{Name: "AGGREGATE_SECTION_TIMEOUT", Default: "3s", Usage: "How long each backend of a composed response may take", Value: settings.Duration(&c.AggregateSectionTimeout)},
+		{Name: "STATUS_ENABLED", Default: "true", Usage: "Serve GET /status, a public summary of backend health for status pages", Value: settings.Bool(&c.StatusEnabled)},
+		{Name: "STATUS_CACHE_TTL", Default: "30s", Usage: "How long the /status summary is reused", Value: settings.Duration(&c.StatusCacheTTL)},
+		{Name: "STATUS_DEGRADED_ERROR_RATE", Default: "0.05", Usage: "Fraction of failed requests in the last 5 minutes at which a backend is degraded", Value: settings.Float(&c.StatusDegradedErrorRate)},
+		{Name: "STATUS_MIN_REQUESTS", Default: "20", Usage: "Requests in the last 5 minutes needed before a backend's error rate counts", Value: settings.Int(&c.StatusMinRequests)},
+		{Name: "DOCS_ENABLED", Default: "true", Usage: "Serve the combined OpenAPI document at /openapi.json and Swagger UI at /docs", Value: settings.Bool(&c.DocsEnabled)},
+		{Name: "DOCS_BASIC_AUTH", Usage: "Credentials required for /openapi.json and /docs, as user:password (comma-separated; public if empty)", Value: settings.Slice(&c.DocsBasicAuth), Redact: settings.RedactSecret},
+		{Name: "DOCS_REFRESH_INTERVAL", Default: "5m", Usage: "How long the combined OpenAPI document is reused before backends' specs are fetched again", Value: settings.Duration(&c.DocsRefreshInterval)},
+		{Name: "DOCS_SWAGGER_UI_URL", Default: "https://unpkg.com/swagger-ui-dist@5", Usage: "Base URL of the swagger-ui-dist assets loaded by /docs", Value: settings.String(&c.DocsSwaggerUIURL)},
+		{Name: "SENTRY_DSN", Usage: "Sentry DSN (error reporting disabled if empty)", Value: settings.String(&c.SentryDSN), Redact: settings.RedactSecret},
+		{Name: "SENTRY_ENVIRONMENT", Usage: "Sentry environment (defaults to ENVIRONMENT)", Value: settings.String(&c.SentryEnvironment)},
+		{Name: "SENTRY_RELEASE", Usage: "Sentry release (defaults to the build version)", Value: settings.String(&c.SentryRelease)},
+		{Name: "SENTRY_SAMPLE_RATE", Default: "1.0", Usage: "Fraction of errors reported to Sentry", Value: settings.Float(&c.SentrySampleRate)},
+		{Name: "TRACING_ENABLED", Default: "false", Usage: "Propagate W3C traceparent and attach trace exemplars", Value: settings.Bool(&c.TracingEnabled)},
+		{Name: "SERVER_TIMING_ENABLED", Default: "true", Usage: "Add a Server-Timing header to responses", Value: settings.Bool(&c.ServerTimingEnabled)},
+		{Name: "METRICS_LATENCY_BUCKETS", Default: "0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5,10", Usage: "Latency histogram buckets in seconds", Value: bucketsValue{&c.MetricsLatencyBuckets}},
+		{Name: "METRICS_MAX_PATHS_PER_ROUTE", Default: "50", Usage: "Distinct path labels per route", Value: settings.Int(&c.MetricsMaxPathsPerRoute)},
+		{Name: "LOG_OUTPUTS", Usage: "Extra log outputs: syslog, gelf (comma-separated)", Value: settings.Slice(&c.LogOutputs)},
+		{Name: "SYSLOG_ADDR", Usage: "Syslog server host:port", Value: settings.String(&c.SyslogAddr)},
+		{Name: "SYSLOG_NETWORK", Default: "udp", Usage: "Syslog transport: udp, tcp or tls", Value: settings.String(&c.SyslogNetwork)},
+		{Name: "GELF_ADDR", Usage: "Graylog GELF input host:port", Value: settings.String(&c.GELFAddr)},
+		{Name: "GELF_NETWORK", Default: "tcp", Usage: "GELF transport: udp, tcp or tls", Value: settings.String(&c.GELFNetwork)},
+		{Name: "LOG_TLS_CA_FILE", Usage: "CA bundle for syslog/GELF over TLS", Value: settings.String(&c.LogTLSCAFile)},
+		{Name: "GRACEFUL_RESTART_ENABLED", Default: "false", Usage: "Hand listeners to a new process on SIGHUP", Value: settings.Bool(&c.GracefulRestartEnabled)},
+		{Name: "PID_FILE", Usage: "File holding the PID of the serving process", Value: settings.String(&c.PIDFile)},
+		{Name: "RESTART_UPGRADE_TIMEOUT", Default: "1m", Usage: "Time a new process has to become ready", Value: settings.Duration(&c.RestartUpgradeTimeout)},
+		{Name: "SHUTDOWN_TIMEOUT", Default: "25s", Usage: "Time in-flight requests get to finish on shutdown", Value: settings.Duration(&c.ShutdownTimeout)},
+		{Name: "SHUTDOWN_DELAY", Default: "0s", Usage: "Time readiness fails before listeners close on shutdown, for load balancers to stop routing", Value: settings.Duration(&c.ShutdownDelay)},
+		{Name: "PRESTOP_PORT", Usage: "Port serving the Kubernetes preStop hook at /prestop (disabled if empty)", Value: settings.String(&c.PreStopPort)},
+		{Name: "PLUGINS", Usage: "Compiled-in plugins to enable, in order (comma-separated)", Value: settings.Slice(&c.Plugins)},
+		{Name: "FEATURE_FLAGS_SOURCE", Default: "env", Usage: "Where feature flag overrides come from: env, file or redis", Value: settings.String(&c.FeatureFlagsSource)},
+		{Name: "FEATURE_FLAGS", Usage: "Feature flag overrides for the env source, e.g. tracing=true,rate_limit@users=false", Value: settings.String(&c.FeatureFlags)},
+		{Name: "FEATURE_FLAGS_FILE", Usage: "JSON file of feature flag overrides for the file source", Value: settings.String(&c.FeatureFlagsFile)},
+		{Name: "FEATURE_FLAGS_REDIS_KEY", Usage: "Redis hash of feature flag overrides (defaults to gateway:flags:<ENVIRONMENT>)", Value: settings.String(&c.FeatureFlagsRedisKey)},
+		{Name: "FEATURE_FLAGS_REFRESH_INTERVAL", Default: "15s", Usage: "How often file and redis feature flags are reloaded", Value: settings.Duration(&c.FeatureFlagsRefresh)},
+		{Name: "TLS_PORT", Default: "8443", Usage: "HTTPS listen port (used when TLS is configured)", Value: settings.String(&c.TLSPort)},
+		{Name: "TLS_CERT_FILE", Usage: "TLS certificate file (PEM)", Value: settings.String(&c.TLSCertFile)},
+		{Name: "TLS_KEY_FILE", Usage: "TLS private key file (PEM)", Value: settings.String(&c.TLSKeyFile)},
+		{Name: "TLS_RELOAD_INTERVAL", Default: "30s", Usage: "How often certificate files are checked for changes", Value: settings.Duration(&c.TLSReloadInterval)},
+		{Name: "TLS_ACME_DOMAINS", Usage: "Domains to obtain ACME certificates for (comma-separated)", Value: settings.Slice(&c.TLSACMEDomains)},
+		{Name: "TLS_ACME_EMAIL", Usage: "ACME account contact email", Value: settings.String(&c.TLSACMEEmail)},
+		{Name: "TLS_ACME_CACHE_DIR", Default: "acme-cache", Usage: "Directory for ACME account keys and certificates", Value: settings.String(&c.TLSACMECacheDir)},
+		{Name: "TLS_ACME_DIRECTORY_URL", Usage: "ACME directory URL (Let's Encrypt production if empty)", Value: settings.String(&c.TLSACMEDirectoryURL)},
+	}
+}
+
+// Load builds the configuration from command-line args, the environment and an optional config file
+// Precedence: flags > environment variables (NEXUS_ prefixed first) > config file (--config or CONFIG_FILE) > ENVIRONMENT profile > defaults
+// Returns flag.ErrHelp if -h or --help was given
+func Load(args []string) (*Config, error) {
+	c := &Config{}
+	set, problems, err := settings.Load(settings.Options{
+		Program:  "gateway",
+		Fields:   c.fieldTable(),
+		Profiles: profiles,
+		Aliases:  environmentAliases,
+		Strict:   &c.ConfigStrict,
+	}, args)
+	if err != nil {
+		return nil, err
+	}
+	c.Set = set
+
+	// Settings whose defaults derive from other settings
+	if c.SentryEnvironment == "" {
+		c.SentryEnvironment = c.Environment
+	}
+	if c.SentryRelease == "" {
+		c.SentryRelease = version.Version
+	}
+	if c.FeatureFlagsRedisKey == "" {
+		c.FeatureFlagsRedisKey = "gateway:flags:" + c.Environment
+	}
+
+	problems = append(problems, c.validate()...)
+	if len(problems) > 0 {
+		return nil, &settings.ValidationError{Problems: problems}
+	}
+	return c, nil
+}
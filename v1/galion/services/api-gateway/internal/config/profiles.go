@@ -0,0 +1,47 @@
+package config
+
+import "sort"
+
+// profiles override setting defaults per ENVIRONMENT
+// development uses the defaults in fieldTable as they are; anything set
+// explicitly (config file, environment variable or flag) still wins
+var profiles = map[string]map[string]string{
+	"development": {},
+	"staging": {
+		"DEBUG":                         "false",
+		"RATE_LIMIT_ENABLED":            "true",
+		"ALLOWED_ORIGINS":               "",
+		"UPSTREAM_HEALTH_CHECK_TIMEOUT": "3s",
+		"HEALTH_DEEP_TIMEOUT":           "5s",
+		"RESTART_UPGRADE_TIMEOUT":       "2m",
+		"SHUTDOWN_DELAY":                "5s",
+	},
+	"production": {
+		"DEBUG":                         "false",
+		"RATE_LIMIT_ENABLED":            "true",
+		"ALLOWED_ORIGINS":               "",
+		"UPSTREAM_HEALTH_CHECK_TIMEOUT": "3s",
+		"HEALTH_DEEP_TIMEOUT":           "5s",
+		"RESTART_UPGRADE_TIMEOUT":       "2m",
+		"SHUTDOWN_DELAY":                "5s",
+		"SERVER_TIMING_ENABLED":         "false",
+		"DOCS_ENABLED":                  "false",
+	},
+}
+
+// environmentAliases are accepted short names for ENVIRONMENT
+var environmentAliases = map[string]string{
+	"dev":   "development",
+	"stage": "staging",
+	"prod":  "production",
+}
+
+// profileNames lists the known profiles, sorted
+func profileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,446 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-api-gateway/internal/flags"
+	"nexus-api-gateway/internal/middleware"
+	"nexus-api-gateway/pkg/plugin"
+)
+
+// defaultJWTSecret is the development secret that must not be used in production
+const defaultJWTSecret = "dev-secret-key-change-this-in-production"
+
+// validate checks values that parse but make no sense, returning every problem found
+func (c *Config) validate() []string {
+	var problems []string
+	bad := func(name, format string, args ...interface{}) {
+		problems = append(problems, c.Problem(name, format, args...))
+	}
+
+	if _, ok := profiles[c.Environment]; !ok {
+		bad("ENVIRONMENT", "must be one of %s (or dev, stage, prod)", strings.Join(profileNames(), ", "))
+	}
+
+	for name, port := range map[string]string{"PORT": c.Port, "ADMIN_PORT": c.AdminPort, "TLS_PORT": c.TLSPort} {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			bad(name, "must be a port number between 1 and 65535")
+		}
+	}
+	if c.AdminHost != "" && strings.ContainsAny(c.AdminHost, ":/ ") && net.ParseIP(c.AdminHost) == nil {
+		bad("ADMIN_HOST", "must be an IP address or host name without a port")
+	}
+	if c.Port == c.AdminPort {
+		bad("ADMIN_PORT", "must differ from PORT")
+	}
+	if c.TLSEnabled() && (c.TLSPort == c.Port || c.TLSPort == c.AdminPort) {
+		bad("TLS_PORT", "must differ from PORT and ADMIN_PORT")
+	}
+	if c.PreStopPort != "" {
+		if n, err := strconv.Atoi(c.PreStopPort); err != nil || n < 1 || n > 65535 {
+			bad("PRESTOP_PORT", "must be a port number between 1 and 65535")
+		} else if c.PreStopPort == c.Port || c.PreStopPort == c.AdminPort || (c.TLSEnabled() && c.PreStopPort == c.TLSPort) {
+			bad("PRESTOP_PORT", "must differ from PORT, ADMIN_PORT and TLS_PORT")
+		}
+	}
+
+	if c.InternalPort != "" {
+		if n, err := strconv.Atoi(c.InternalPort); err != nil || n < 1 || n > 65535 {
+			bad("INTERNAL_PORT", "must be a port number between 1 and 65535")
+		} else if c.InternalPort == c.Port || c.InternalPort == c.AdminPort || c.InternalPort == c.PreStopPort || (c.TLSEnabled() && c.InternalPort == c.TLSPort) {
+			bad("INTERNAL_PORT", "must differ from PORT, ADMIN_PORT, PRESTOP_PORT and TLS_PORT")
+		}
+		if len(c.ServiceTokens) == 0 {
+			bad("SERVICE_TOKENS", "required when INTERNAL_PORT is set")
+		}
+	}
+	if c.InternalHost != "" && strings.ContainsAny(c.InternalHost, ":/ ") && net.ParseIP(c.InternalHost) == nil {
+		bad("INTERNAL_HOST", "must be an IP address or host name without a port")
+	}
+	if _, err := middleware.ParseServiceTokens(c.ServiceTokens); err != nil {
+		bad("SERVICE_TOKENS", "%v", err)
+	}
+	if operatorKeys, err := middleware.ParseAdminOperatorKeys(c.AdminOperatorKeys); err != nil {
+		bad("ADMIN_OPERATOR_KEYS", "%v", err)
+	} else {
+		// A key shared with anyone else couldn't tell the audit trail who acted
+		operators := make([]string, 0, len(operatorKeys))
+		for operator := range operatorKeys {
+			operators = append(operators, operator)
+		}
+		sort.Strings(operators)
+		owners := make(map[string]string, len(operators))
+		for _, operator := range operators {
+			key := operatorKeys[operator]
+			switch other, ok := owners[key]; {
+			case key == c.AdminAPIKey:
+				bad("ADMIN_OPERATOR_KEYS", "the key of operator %q is also ADMIN_API_KEY", operator)
+			case ok:
+				bad("ADMIN_OPERATOR_KEYS", "operators %q and %q have the same key", other, operator)
+			}
+			owners[key] = operator
+		}
+	}
+
+	if _, err := middleware.ParseBasicAuth(c.DocsBasicAuth); err != nil {
+		bad("DOCS_BASIC_AUTH", "%v", err)
+	}
+	if c.DocsEnabled && c.DocsSwaggerUIURL == "" {
+		bad("DOCS_SWAGGER_UI_URL", "required when DOCS_ENABLED is true")
+	}
+
+	if c.UploadsEnabled {
+		for name, value := range map[string]string{
+			"UPLOADS_S3_BUCKET":            c.UploadsS3Bucket,
+			"UPLOADS_S3_REGION":            c.UploadsS3Region,
+			"UPLOADS_S3_ACCESS_KEY_ID":     c.UploadsS3AccessKeyID,
+			"UPLOADS_S3_SECRET_ACCESS_KEY": c.UploadsS3SecretKey,
+		} {
+			if value == "" {
+				bad(name, "required when UPLOADS_ENABLED is true")
+			}
+		}
+		if c.UploadsS3Endpoint != "" {
+			if err := checkURL(c.UploadsS3Endpoint, "http", "https"); err != nil {
+				bad("UPLOADS_S3_ENDPOINT", "%v", err)
+			}
+		}
+		// S3 accepts single PUTs up to 5 GiB and pre-signed URLs valid for up to 7 days
+		if c.UploadsMaxBytes < 1 || c.UploadsMaxBytes > 5<<30 {
+			bad("UPLOADS_MAX_BYTES", "must be between 1 and 5368709120 (5 GiB)")
+		}
+		if c.UploadsURLTTL < time.Second || c.UploadsURLTTL > 7*24*time.Hour {
+			bad("UPLOADS_URL_TTL", "must be between 1s and 168h")
+		}
+		for _, t := range c.UploadsContentTypes {
+			if !strings.Contains(t, "/") {
+				bad("UPLOADS_CONTENT_TYPES", "%q is not a media type or type/* wildcard", t)
+			}
+		}
+	}
+
+	if c.WebhooksEnabled {
+		if c.WebhookSigningSecret == "" {
+			bad("WEBHOOK_SIGNING_SECRET", "required when WEBHOOKS_ENABLED is true")
+		}
+		if c.InternalPort == "" && c.WebhookKafkaTopic == "" {
+			bad("WEBHOOKS_ENABLED", "requires INTERNAL_PORT or WEBHOOK_KAFKA_TOPIC to accept webhook requests")
+		}
+		if c.WebhookRetryBase > c.WebhookRetryMax {
+			bad("WEBHOOK_RETRY_BASE", "must not exceed WEBHOOK_RETRY_MAX (%s)", c.WebhookRetryMax)
+		}
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		bad("TLS_KEY_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSCertFile != "" && len(c.TLSACMEDomains) > 0 {
+		bad("TLS_ACME_DOMAINS", "cannot be combined with TLS_CERT_FILE")
+	}
+	if c.TLSACMEDirectoryURL != "" {
+		if err := checkURL(c.TLSACMEDirectoryURL, "https"); err != nil {
+			bad("TLS_ACME_DIRECTORY_URL", "%v", err)
+		}
+	}
+
+	for name, u := range map[string]string{
+		"AUTH_SERVICE_URL":    c.AuthServiceURL,
+		"USER_SERVICE_URL":    c.UserServiceURL,
+		"CONTENT_SERVICE_URL": c.ContentServiceURL,
+	} {
+		if err := checkURL(u, "http", "https"); err != nil {
+			bad(name, "%v", err)
+		}
+	}
+	if err := checkURL(c.DiscoveryConsulAddr, "http", "https"); err != nil {
+		bad("DISCOVERY_CONSUL_ADDR", "%v", err)
+	}
+	if c.DiscoveryKubernetesAPI != "" {
+		if err := checkURL(c.DiscoveryKubernetesAPI, "http", "https"); err != nil {
+			bad("DISCOVERY_KUBERNETES_API", "%v", err)
+		}
+	}
+	if err := checkURL(c.RedisURL, "redis", "rediss"); err != nil {
+		bad("REDIS_URL", "%v", err)
+	} else {
+		c.validateRedis(bad)
+	}
+	for _, u := range c.CaptureReplayTargets {
+		if err := checkURL(u, "http", "https"); err != nil {
+			bad("CAPTURE_REPLAY_TARGETS", "%q: %v", u, err)
+		}
+	}
+	if c.AuditDatabaseURL != "" {
+		if err := checkURL(c.AuditDatabaseURL, "postgres", "postgresql"); err != nil {
+			bad("AUDIT_DATABASE_URL", "%v", err)
+		}
+	}
+
+	for name, d := range map[string]time.Duration{
+		"UPSTREAM_HEALTH_CHECK_INTERVAL": c.HealthCheckInterval,
+		"UPSTREAM_HEALTH_CHECK_TIMEOUT":  c.HealthCheckTimeout,
+		"CIRCUIT_BREAKER_OPEN_TIMEOUT":   c.CircuitBreakerOpenTimeout,
+		"HEALTH_DEEP_TIMEOUT":            c.HealthDeepTimeout,
+		"CAPTURE_TTL":                    c.CaptureTTL,
+		"CAPTURE_RECORDING_RETENTION":    c.CaptureRecordingRetention,
+		"CAPTURE_REPLAY_TIMEOUT":         c.CaptureReplayTimeout,
+		"AGGREGATE_SECTION_TIMEOUT":      c.AggregateSectionTimeout,
+		"RESTART_UPGRADE_TIMEOUT":        c.RestartUpgradeTimeout,
+		"TLS_RELOAD_INTERVAL":            c.TLSReloadInterval,
+		"SHUTDOWN_TIMEOUT":               c.ShutdownTimeout,
+		"FEATURE_FLAGS_REFRESH_INTERVAL": c.FeatureFlagsRefresh,
+		"REDIS_POOL_TIMEOUT":             c.RedisPoolTimeout,
+		"DISCOVERY_RETRY_INTERVAL":       c.DiscoveryRetryInterval,
+		"DOCS_REFRESH_INTERVAL":          c.DocsRefreshInterval,
+		"NOTIFICATIONS_HEARTBEAT":        c.NotificationsHeartbeat,
+		"WEBHOOK_RETRY_BASE":             c.WebhookRetryBase,
+		"WEBHOOK_RETRY_MAX":              c.WebhookRetryMax,
+		"WEBHOOK_TIMEOUT":                c.WebhookTimeout,
+		"WEBHOOK_RETENTION":              c.WebhookRetention,
+		"USAGE_RETENTION":                c.UsageRetention,
+		"STATUS_CACHE_TTL":               c.StatusCacheTTL,
+	} {
+		if d <= 0 {
+			bad(name, "must be a positive duration")
+		}
+	}
+	// Billing days beyond the 28th don't occur every month
+	if c.UsageBillingDay < 1 || c.UsageBillingDay > 28 {
+		bad("USAGE_BILLING_DAY", "must be between 1 and 28")
+	}
+	if c.OverviewFeedLimit < 1 || c.OverviewFeedLimit > 100 {
+		bad("OVERVIEW_FEED_LIMIT", "must be between 1 and 100")
+	}
+	// The report reads every day of the current period
+	if c.UsageRetention < 31*24*time.Hour {
+		bad("USAGE_RETENTION", "must be at least 744h (31 days)")
+	}
+	if c.ShutdownDelay < 0 {
+		bad("SHUTDOWN_DELAY", "must not be negative")
+	}
+	for name, d := range map[string]time.Duration{
+		"SERVER_READ_TIMEOUT":        c.ServerReadTimeout,
+		"SERVER_READ_HEADER_TIMEOUT": c.ServerReadHeaderTimeout,
+		"SERVER_WRITE_TIMEOUT":       c.ServerWriteTimeout,
+		"SERVER_IDLE_TIMEOUT":        c.ServerIdleTimeout,
+	} {
+		if d < time.Second || d > 10*time.Minute {
+			bad(name, "must be between 1s and 10m")
+		}
+	}
+	if c.ServerReadHeaderTimeout > c.ServerReadTimeout {
+		bad("SERVER_READ_HEADER_TIMEOUT", "must not exceed SERVER_READ_TIMEOUT (%s)", c.ServerReadTimeout)
+	}
+	if c.ServerMaxHeaderBytes < 4<<10 || c.ServerMaxHeaderBytes > 16<<20 {
+		bad("SERVER_MAX_HEADER_BYTES", "must be between 4096 (4 KiB) and 16777216 (16 MiB)")
+	}
+	if c.HealthCheckTimeout >= c.HealthCheckInterval {
+		bad("UPSTREAM_HEALTH_CHECK_TIMEOUT", "must be shorter than UPSTREAM_HEALTH_CHECK_INTERVAL (%s)", c.HealthCheckInterval)
+	}
+
+	for name, limit := range map[string]struct{ value, min int }{
+		"CIRCUIT_BREAKER_THRESHOLD":          {c.CircuitBreakerThreshold, 1},
+		"RATE_LIMIT_REQUESTS_PER_MINUTE":     {c.RateLimitPerMinute, 1},
+		"READINESS_MIN_HEALTHY_UPSTREAMS":    {c.ReadinessMinUpstreams, 0},
+		"CAPTURE_MAX_BODY_BYTES":             {c.CaptureMaxBodyBytes, 0},
+		"CAPTURE_RECORDING_MAX_REQUESTS":     {c.CaptureRecordingMax, 1},
+		"METRICS_MAX_PATHS_PER_ROUTE":        {c.MetricsMaxPathsPerRoute, 1},
+		"REDIS_POOL_SIZE":                    {c.RedisPoolSize, 0},
+		"REDIS_MIN_IDLE_CONNS":               {c.RedisMinIdleConns, 0},
+		"GRAPHQL_MAX_DEPTH":                  {c.GraphQLMaxDepth, 1},
+		"GRAPHQL_MAX_FIELDS":                 {c.GraphQLMaxFields, 1},
+		"GRAPHQL_CONCURRENCY":                {c.GraphQLConcurrency, 1},
+		"BATCH_MAX_REQUESTS":                 {c.BatchMaxRequests, 1},
+		"BATCH_CONCURRENCY":                  {c.BatchConcurrency, 1},
+		"NOTIFICATIONS_MAX_STREAMS_PER_USER": {c.NotificationsMaxPerUser, 1},
+		"WEBHOOK_WORKERS":                    {c.WebhookWorkers, 1},
+		"WEBHOOK_MAX_ATTEMPTS":               {c.WebhookMaxAttempts, 1},
+		"STATUS_MIN_REQUESTS":                {c.StatusMinRequests, 1},
+		"API_KEYS_MAX_PER_USER":              {c.APIKeysMaxPerUser, 1},
+	} {
+		if limit.value < limit.min {
+			bad(name, "must be at least %d", limit.min)
+		}
+	}
+
+	if c.StatusDegradedErrorRate <= 0 || c.StatusDegradedErrorRate > 1 {
+		bad("STATUS_DEGRADED_ERROR_RATE", "must be greater than 0 and at most 1")
+	}
+	if c.SentrySampleRate < 0 || c.SentrySampleRate > 1 {
+		bad("SENTRY_SAMPLE_RATE", "must be between 0 and 1")
+	}
+
+	switch c.JWTAlgorithm {
+	case "HS256", "HS384", "HS512":
+	default:
+		bad("JWT_ALGORITHM", "must be HS256, HS384 or HS512")
+	}
+	if c.Environment == "production" && c.JWTSecretKey == defaultJWTSecret {
+		bad("JWT_SECRET_KEY", "the development default must not be used in production")
+	}
+
+	if len(c.AllowedOrigins) == 0 {
+		bad("ALLOWED_ORIGINS", "at least one origin is required")
+	}
+	for _, origin := range c.AllowedOrigins {
+		if err := checkOrigin(origin); err != nil {
+			bad("ALLOWED_ORIGINS", "%q: %v", origin, err)
+		}
+	}
+
+	for _, output := range c.LogOutputs {
+		switch output {
+		case "syslog":
+			if c.SyslogAddr == "" {
+				bad("SYSLOG_ADDR", "required when LOG_OUTPUTS includes syslog")
+			}
+		case "gelf":
+			if c.GELFAddr == "" {
+				bad("GELF_ADDR", "required when LOG_OUTPUTS includes gelf")
+			}
+		default:
+			bad("LOG_OUTPUTS", "unknown output %q (want syslog or gelf)", output)
+		}
+	}
+	for name, network := range map[string]string{"SYSLOG_NETWORK": c.SyslogNetwork, "GELF_NETWORK": c.GELFNetwork} {
+		switch network {
+		case "udp", "tcp", "tls":
+		default:
+			bad(name, "must be udp, tcp or tls")
+		}
+	}
+
+	if c.AccessLogKafkaEnabled && len(c.KafkaBrokers) == 0 {
+		bad("KAFKA_BROKERS", "required when ACCESS_LOG_KAFKA_ENABLED is true")
+	}
+	if (c.AccessLogKafkaEnabled || c.RequestEventsEnabled) && len(c.EventsUserHashKey) < 32 {
+		bad("EVENTS_USER_HASH_KEY", "must be at least 32 characters when ACCESS_LOG_KAFKA_ENABLED or REQUEST_EVENTS_ENABLED is true")
+	}
+	if c.RequestEventsEnabled && len(c.KafkaBrokers) == 0 {
+		bad("KAFKA_BROKERS", "required when REQUEST_EVENTS_ENABLED is true")
+	}
+	if c.RequestEventsEnabled && c.RequestEventsTopic == "" {
+		bad("REQUEST_EVENTS_TOPIC", "required when REQUEST_EVENTS_ENABLED is true")
+	}
+
+	switch c.FeatureFlagsSource {
+	case "env", "redis":
+	case "file":
+		if c.FeatureFlagsFile == "" {
+			bad("FEATURE_FLAGS_FILE", "required when FEATURE_FLAGS_SOURCE is file")
+		}
+	default:
+		bad("FEATURE_FLAGS_SOURCE", "must be env, file or redis")
+	}
+	if _, err := flags.ParseOverrides(c.FeatureFlags); err != nil {
+		bad("FEATURE_FLAGS", "%v", err)
+	}
+
+	if c.MockBackends {
+		if c.Environment == "production" {
+			bad("MOCK_BACKENDS", "must not be enabled in production")
+		}
+		if info, err := os.Stat(c.MockFixturesDir); err != nil || !info.IsDir() {
+			bad("MOCK_FIXTURES_DIR", "must be an existing directory when MOCK_BACKENDS is enabled")
+		}
+	}
+
+	registered := plugin.Registered()
+	for _, name := range c.Plugins {
+		if !slices.Contains(registered, name) {
+			bad("PLUGINS", "unknown plugin %q (compiled-in plugins: %v)", name, registered)
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// validateRedis checks the Redis mode and the settings it needs
+func (c *Config) validateRedis(bad func(name, format string, args ...interface{})) {
+	u, _ := url.Parse(c.RedisURL)
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if n, err := strconv.Atoi(db); err != nil || n < 0 {
+			bad("REDIS_URL", "database must be a number, e.g. redis://host:6379/0")
+		} else if n != 0 && c.RedisMode == "cluster" {
+			bad("REDIS_URL", "Redis Cluster only has database 0")
+		}
+	}
+	if c.RedisTLSCAFile != "" && u.Scheme != "rediss" {
+		bad("REDIS_TLS_CA_FILE", "requires a rediss:// REDIS_URL")
+	}
+
+	switch c.RedisMode {
+	case "standalone":
+		if len(c.RedisAddrs) > 0 {
+			bad("REDIS_ADDRS", "only used in sentinel and cluster mode")
+		}
+	case "sentinel":
+		if c.RedisSentinelMaster == "" {
+			bad("REDIS_SENTINEL_MASTER", "required when REDIS_MODE is sentinel")
+		}
+		if len(c.RedisAddrs) == 0 {
+			bad("REDIS_ADDRS", "Sentinel addresses are required when REDIS_MODE is sentinel")
+		}
+	case "cluster":
+	default:
+		bad("REDIS_MODE", "must be standalone, sentinel or cluster")
+	}
+	if c.RedisMode != "sentinel" && (c.RedisSentinelMaster != "" || c.RedisSentinelPassword != "") {
+		bad("REDIS_SENTINEL_MASTER", "REDIS_SENTINEL_MASTER and REDIS_SENTINEL_PASSWORD are only used in sentinel mode")
+	}
+	for _, addr := range c.RedisAddrs {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			bad("REDIS_ADDRS", "%q must be host:port", addr)
+		}
+	}
+}
+
+// TLSEnabled reports whether the HTTPS listener is configured
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.TLSACMEDomains) > 0
+}
+
+// checkURL checks that u is an absolute URL with a host and one of the given schemes
+func checkURL(u string, schemes ...string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return fmt.Errorf("not a valid URL")
+	}
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme {
+			if parsed.Host == "" {
+				return fmt.Errorf("URL has no host")
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("URL scheme must be one of %v", schemes)
+}
+
+// checkOrigin checks that a CORS origin is "*" or scheme://host[:port] with no path
+func checkOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	if err := checkURL(origin, "http", "https"); err != nil {
+		return err
+	}
+	parsed, _ := url.Parse(origin)
+	if (parsed.Path != "" && parsed.Path != "/") || parsed.RawQuery != "" {
+		return fmt.Errorf("origin must not include a path or query")
+	}
+	if parsed.Path == "/" {
+		return fmt.Errorf("origin must not end with a slash")
+	}
+	return nil
+}
+
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bucketsValue is a comma-separated, strictly increasing list of histogram buckets
+type bucketsValue struct{ p *[]float64 }
+
+func (v bucketsValue) Set(s string) error {
+	var buckets []float64
+	for _, part := range strings.Split(s, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return fmt.Errorf("must be comma-separated numbers")
+		}
+		if len(buckets) > 0 && bucket <= buckets[len(buckets)-1] {
+			return fmt.Errorf("buckets must be strictly increasing")
+		}
+		buckets = append(buckets, bucket)
+	}
+	*v.p = buckets
+	return nil
+}
+func (v bucketsValue) String() string {
+	parts := make([]string, len(*v.p))
+	for i, b := range *v.p {
+		parts[i] = strconv.FormatFloat(b, 'g', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"nexus-api-gateway/internal/routes"
+)
+
+// consulWait is how long a Consul blocking query waits for a change before returning unchanged
+const consulWait = "5m"
+
+// consul watches passing service instances with Consul blocking queries
+type consul struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func newConsul(addr, token string) *consul {
+	return &consul{addr: addr, token: token, client: &http.Client{}}
+}
+
+// consulEntry is the part of a /v1/health/service entry the gateway needs
+type consulEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (c *consul) watch(ctx context.Context, spec routes.Discovery, update func([]string)) error {
+	var index uint64
+	for {
+		query := url.Values{"passing": {"true"}, "wait": {consulWait}}
+		if index > 0 {
+			query.Set("index", strconv.FormatUint(index, 10))
+		}
+		if spec.Tag != "" {
+			query.Set("tag", spec.Tag)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			c.addr+"/v1/health/service/"+url.PathEscape(spec.Service)+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		if c.token != "" {
+			req.Header.Set("X-Consul-Token", c.token)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		var entries []consulEntry
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("consul returned %s", resp.Status)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to decode consul response: %w", err)
+		}
+
+		// Without an index every query would return immediately, so give up and retry later
+		next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if err != nil || next == 0 {
+			return fmt.Errorf("consul response has no X-Consul-Index")
+		}
+		// A blocking query also returns when its wait expires; a different index
+		// (higher, or lower after a Consul restart) means the result may have changed
+		changed := next != index
+		index = next
+		if !changed {
+			continue
+		}
+
+		targets := make([]string, 0, len(entries))
+		for _, e := range entries {
+			host := e.Service.Address
+			if host == "" {
+				host = e.Node.Address
+			}
+			targets = append(targets, targetURL(spec.Scheme, host, e.Service.Port))
+		}
+		sort.Strings(targets)
+		update(targets)
+	}
+}
@@ -0,0 +1,89 @@
+// Package discovery keeps upstream targets in sync with Kubernetes Endpoints or the Consul catalog
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/routes"
+
+	"nexus-common/logger"
+)
+
+// Config configures the discovery providers
+type Config struct {
+	ConsulAddr    string // Consul HTTP API, e.g. http://localhost:8500
+	ConsulToken   string
+	KubernetesAPI string // Kubernetes API server; in-cluster service account if empty
+	RetryInterval time.Duration
+}
+
+// watcher follows one service, calling update with the full target list whenever it changes
+// It returns when ctx is done or the watch fails; Start restarts it after RetryInterval
+type watcher interface {
+	watch(ctx context.Context, spec routes.Discovery, update func(targets []string)) error
+}
+
+// Start watches every route that uses discovery, updating its upstream's targets until ctx is done
+// It fails only if a provider can't be set up (e.g. Kubernetes discovery outside a cluster)
+func Start(ctx context.Context, config Config, table routes.Table, upstreams *proxy.Registry, log *logger.Logger) error {
+	watchers := make(map[string]watcher)
+	for _, route := range table {
+		if route.Discovery == nil {
+			continue
+		}
+		w, ok := watchers[route.Discovery.Provider]
+		if !ok {
+			var err error
+			switch route.Discovery.Provider {
+			case routes.DiscoveryKubernetes:
+				w, err = newKubernetes(config.KubernetesAPI)
+			case routes.DiscoveryConsul:
+				w = newConsul(config.ConsulAddr, config.ConsulToken)
+			default:
+				err = fmt.Errorf("unknown discovery provider %q", route.Discovery.Provider)
+			}
+			if err != nil {
+				return fmt.Errorf("route %s: %w", route.Name, err)
+			}
+			watchers[route.Discovery.Provider] = w
+		}
+
+		u := upstreams.Get(route.Name)
+		spec := *route.Discovery
+		log.Info("Discovering targets for route %s from %s service %s", route.Name, spec.Provider, spec.Service)
+		go run(ctx, w, spec, func(targets []string) { upstreams.SetTargets(u, targets) }, config.RetryInterval, log)
+	}
+	return nil
+}
+
+// run keeps a watch going, retrying after failures until ctx is done
+// Targets are kept as they were while the provider is unreachable
+func run(ctx context.Context, w watcher, spec routes.Discovery, update func([]string), retry time.Duration, log *logger.Logger) {
+	for {
+		err := w.watch(ctx, spec, update)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Warn("Discovery of %s service %s failed, retrying in %s: %v", spec.Provider, spec.Service, retry, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+// targetURL builds a target base URL from an instance address and port
+func targetURL(scheme, host string, port int) string {
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
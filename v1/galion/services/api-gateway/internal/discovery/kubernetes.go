@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"nexus-api-gateway/internal/routes"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetes watches the Endpoints of a Service through the Kubernetes API
+type kubernetes struct {
+	api       string
+	tokenFile string // re-read on every request; projected tokens are rotated
+	namespace string // default namespace, the gateway's own
+	client    *http.Client
+}
+
+// newKubernetes connects to api, or to the in-cluster API server with the pod's service account if api is empty
+func newKubernetes(api string) (*kubernetes, error) {
+	k := &kubernetes{api: strings.TrimSuffix(api, "/"), namespace: "default", client: &http.Client{}}
+	if ns, err := os.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+		k.namespace = strings.TrimSpace(string(ns))
+	}
+	if k.api != "" {
+		return k, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("kubernetes discovery needs DISCOVERY_KUBERNETES_API when not running in a cluster")
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in service account CA")
+	}
+	k.api = "https://" + net.JoinHostPort(host, port)
+	k.tokenFile = serviceAccountDir + "/token"
+	k.client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+	}
+	return k, nil
+}
+
+// endpoints is the part of a v1 Endpoints object the gateway needs
+type endpoints struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+// watchEvent is one line of a Kubernetes watch stream
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// watch lists the Endpoints, then follows changes until the API server ends the watch
+// Only ready addresses are targets; Kubernetes lists unready ones separately
+func (k *kubernetes) watch(ctx context.Context, spec routes.Discovery, update func([]string)) error {
+	namespace := spec.Namespace
+	if namespace == "" {
+		namespace = k.namespace
+	}
+	base := k.api + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/endpoints"
+
+	resp, err := k.get(ctx, base+"/"+url.PathEscape(spec.Service))
+	if err != nil {
+		return err
+	}
+	var current endpoints
+	err = json.NewDecoder(resp.Body).Decode(&current)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode endpoints: %w", err)
+	}
+	update(endpointTargets(current, spec))
+
+	query := url.Values{
+		"watch":           {"true"},
+		"fieldSelector":   {"metadata.name=" + spec.Service},
+		"resourceVersion": {current.Metadata.ResourceVersion},
+		"timeoutSeconds":  {"300"},
+	}
+	resp, err = k.get(ctx, base+"?"+query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event watchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if errors.Is(err, io.EOF) {
+				// The server ended the watch (timeoutSeconds); list and watch again
+				return nil
+			}
+			return fmt.Errorf("watch stream: %w", err)
+		}
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			var changed endpoints
+			if err := json.Unmarshal(event.Object, &changed); err != nil {
+				return fmt.Errorf("failed to decode endpoints: %w", err)
+			}
+			update(endpointTargets(changed, spec))
+		case "DELETED":
+			update(nil)
+		case "ERROR":
+			// Usually 410 Gone: the resource version is too old, so list again
+			return fmt.Errorf("watch error: %s", event.Object)
+		}
+	}
+}
+
+// get sends an authenticated GET request, failing on non-2xx responses
+func (k *kubernetes) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if k.tokenFile != "" {
+		token, err := os.ReadFile(k.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// endpointTargets builds target URLs for the ready addresses on the selected port
+func endpointTargets(ep endpoints, spec routes.Discovery) []string {
+	var targets []string
+	for _, subset := range ep.Subsets {
+		port := 0
+		for _, p := range subset.Ports {
+			if spec.Port == "" && len(subset.Ports) == 1 || p.Name == spec.Port || strconv.Itoa(p.Port) == spec.Port {
+				port = p.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			targets = append(targets, targetURL(spec.Scheme, addr.IP, port))
+		}
+	}
+	sort.Strings(targets)
+	return targets
+}
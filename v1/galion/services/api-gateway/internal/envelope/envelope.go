@@ -0,0 +1,236 @@
+// Package envelope rewrites backend error responses into one JSON shape, per route
+// Backends answer errors as FastAPI details, {"error", "message"} pairs, Google-style error objects
+// or plain text; clients of an opted-in route see {"code", "message", "request_id", "details"} instead
+package envelope
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"nexus-common/requestid"
+)
+
+// maxBodyBytes is the largest error body rewritten; larger ones pass through untouched
+const maxBodyBytes = 64 << 10
+
+// maxTextMessage is the longest plain-text body used as a message
+const maxTextMessage = 200
+
+var (
+	identifier = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+	nonCode    = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Envelope is the error body clients receive
+type Envelope struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"request_id,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// Middleware rewrites 4xx and 5xx responses into an Envelope, keeping their status and other headers
+// Compressed bodies, bodies over 64 KiB and responses to HEAD requests are left as they are
+func Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ew := &envelopeWriter{ResponseWriter: w, header: make(http.Header)}
+			next.ServeHTTP(ew, r)
+			if ew.buffering {
+				ew.writeEnvelope(requestid.FromContext(r.Context()))
+			}
+		})
+	}
+}
+
+// envelopeWriter passes successful responses through and holds back error responses to rewrite them
+type envelopeWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	status      int
+	body        bytes.Buffer
+	buffering   bool
+	wroteHeader bool
+}
+
+func (ew *envelopeWriter) Header() http.Header {
+	return ew.header
+}
+
+func (ew *envelopeWriter) WriteHeader(status int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	ew.status = status
+	encoding := ew.header.Get("Content-Encoding")
+	if status >= http.StatusBadRequest && (encoding == "" || encoding == "identity") {
+		ew.buffering = true
+		return
+	}
+	ew.passThrough()
+}
+
+func (ew *envelopeWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.buffering {
+		if ew.body.Len()+len(b) <= maxBodyBytes {
+			return ew.body.Write(b)
+		}
+		// Too large to rewrite: send what was held back and continue unchanged
+		ew.buffering = false
+		ew.passThrough()
+		if _, err := ew.ResponseWriter.Write(ew.body.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (ew *envelopeWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+func (ew *envelopeWriter) passThrough() {
+	for name, values := range ew.header {
+		ew.ResponseWriter.Header()[name] = values
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+}
+
+func (ew *envelopeWriter) writeEnvelope(requestID string) {
+	env := Normalize(ew.status, ew.header.Get("Content-Type"), ew.body.Bytes())
+	env.RequestID = requestID
+	body, _ := json.Marshal(env)
+
+	for name, values := range ew.header {
+		ew.ResponseWriter.Header()[name] = values
+	}
+	h := ew.ResponseWriter.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	ew.ResponseWriter.WriteHeader(ew.status)
+	ew.ResponseWriter.Write(append(body, '\n'))
+}
+
+// Normalize builds the envelope for an error response from its status, content type and body
+// The code defaults to the status in snake case (not_found) and the message to the status text
+func Normalize(status int, contentType string, body []byte) Envelope {
+	env := Envelope{Code: statusCode(status), Message: http.StatusText(status)}
+	if env.Message == "" {
+		env.Message = "error"
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	trimmed := bytes.TrimSpace(body)
+	var parsed interface{}
+	switch {
+	case len(trimmed) == 0:
+	case strings.HasSuffix(mediaType, "json") || trimmed[0] == '{' || trimmed[0] == '[':
+		if json.Unmarshal(trimmed, &parsed) != nil {
+			break
+		}
+		if fields, ok := parsed.(map[string]interface{}); ok {
+			fromObject(&env, fields)
+		} else {
+			env.Details = parsed
+		}
+	case mediaType == "" || mediaType == "text/plain":
+		if text := string(trimmed); len(text) <= maxTextMessage && !strings.Contains(text, "\n") {
+			env.Message = text
+		}
+	}
+	return env
+}
+
+// fromObject fills env from the error shapes the backends use
+func fromObject(env *Envelope, fields map[string]interface{}) {
+	// Google style: {"error": {"code": 404, "status": "NOT_FOUND", "message": "...", "details": [...]}}
+	if nested, ok := fields["error"].(map[string]interface{}); ok && len(fields) == 1 {
+		if status, ok := nested["status"].(string); ok {
+			nested["code"] = status
+		}
+		fromObject(env, nested)
+		return
+	}
+
+	rest := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		rest[name] = value
+	}
+	take := func(name string) (string, bool) {
+		s, ok := rest[name].(string)
+		if ok && s != "" {
+			delete(rest, name)
+			return s, true
+		}
+		return "", false
+	}
+
+	if code, ok := take("code"); ok {
+		env.Code = normalizeCode(code)
+	}
+	// "error" is a code when it reads like one ("unauthorized"), otherwise a message
+	if e, ok := take("error"); ok {
+		if identifier.MatchString(e) {
+			env.Code = normalizeCode(e)
+		} else {
+			env.Message = e
+		}
+	}
+	for _, name := range []string{"message", "msg", "error_description", "title"} {
+		if message, ok := take(name); ok {
+			env.Message = message
+			break
+		}
+	}
+	// FastAPI: {"detail": "message"} or {"detail": [validation errors]}
+	if detail, ok := take("detail"); ok {
+		env.Message = detail
+	} else if detail, ok := rest["detail"]; ok {
+		delete(rest, "detail")
+		env.Details = detail
+	}
+	if details, ok := rest["details"]; ok {
+		delete(rest, "details")
+		env.Details = details
+	}
+	if errs, ok := rest["errors"]; ok && env.Details == nil {
+		delete(rest, "errors")
+		env.Details = errs
+	}
+	// Numeric codes and statuses repeat the response status, and the gateway sets the request ID
+	for _, name := range []string{"code", "status", "request_id"} {
+		delete(rest, name)
+	}
+	if len(rest) > 0 && env.Details == nil {
+		env.Details = rest
+	}
+}
+
+// statusCode turns a status into a code such as not_found or too_many_requests
+func statusCode(status int) string {
+	if text := http.StatusText(status); text != "" {
+		return normalizeCode(text)
+	}
+	if status >= http.StatusInternalServerError {
+		return "server_error"
+	}
+	return "client_error"
+}
+
+func normalizeCode(s string) string {
+	s = strings.ReplaceAll(strings.ToLower(s), "'", "")
+	return strings.Trim(nonCode.ReplaceAllString(s, "_"), "_")
+}
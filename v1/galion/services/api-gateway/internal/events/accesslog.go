@@ -0,0 +1,107 @@
+package events
+
+import (
+	"net/http"
+	"time"
+
+	"nexus-api-gateway/internal/capture"
+
+	"nexus-common/requestid"
+)
+
+// AccessLogEventType is the event_type of access log events
+const AccessLogEventType = "gateway.access"
+
+// AccessLogEvent is one structured access log entry
+// It uses the same envelope as other platform events so the analytics service can consume it unchanged
+type AccessLogEvent struct {
+	EventType string        `json:"event_type"`
+	UserID    string        `json:"user_id"`
+	Timestamp string        `json:"timestamp"`
+	Service   string        `json:"service"`
+	Data      AccessLogData `json:"data"`
+}
+
+// AccessLogData holds the request details of an access log event
+type AccessLogData struct {
+	RequestID  string  `json:"request_id"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Query      string  `json:"query,omitempty"`
+	Status     int     `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	BytesIn    int64   `json:"bytes_in"`
+	BytesOut   int64   `json:"bytes_out"`
+	ClientIP   string  `json:"client_ip"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	Referer    string  `json:"referer,omitempty"`
+}
+
+// AccessLog returns middleware that publishes one access log event per request
+// Users are identified by the HMAC of their email under userKey, and sensitive query parameters
+// are masked by redactor
+func AccessLog(p *Publisher, userKey []byte, redactor *capture.Redactor) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			wrapped := &countingWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r)
+
+			bytesIn := r.ContentLength
+			if bytesIn < 0 {
+				bytesIn = 0
+			}
+
+			requestID := requestid.FromContext(r.Context())
+			p.Publish(requestID, AccessLogEvent{
+				EventType: AccessLogEventType,
+				UserID:    userID(userKey, r),
+				Timestamp: start.UTC().Format(time.RFC3339),
+				Service:   "api-gateway",
+				Data: AccessLogData{
+					RequestID:  requestID,
+					Method:     r.Method,
+					Path:       r.URL.Path,
+					Query:      redactor.Query(r.URL.RawQuery),
+					Status:     wrapped.statusCode,
+					DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+					BytesIn:    bytesIn,
+					BytesOut:   wrapped.bytes,
+					ClientIP:   r.RemoteAddr,
+					UserAgent:  r.UserAgent(),
+					Referer:    r.Referer(),
+				},
+			})
+		})
+	}
+}
+
+// countingWriter captures the status code and number of bytes written
+type countingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	bytes      int64
+}
+
+// WriteHeader captures the status code
+func (cw *countingWriter) WriteHeader(code int) {
+	cw.statusCode = code
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write counts the bytes written
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(p)
+	cw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush streamed responses
+func (cw *countingWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,164 @@
+// Package events publishes gateway traffic events to Kafka
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"nexus-common/logger"
+)
+
+const (
+	// bufferSize is how many events can be queued before new ones are dropped
+	bufferSize = 10000
+
+	// batchSize is the maximum number of events written to Kafka at once
+	batchSize = 500
+
+	// flushInterval is how often a partial batch is written
+	flushInterval = time.Second
+
+	// writeBatchTimeout is how long the writer waits to fill each partition's batch; a batch is
+	// spread over partitions by key, so every write leaves some partial, and the writer's default
+	// of 1s would cap publishing at a batch a second
+	writeBatchTimeout = 10 * time.Millisecond
+)
+
+// Publisher asynchronously publishes JSON events to a Kafka topic
+// Publish never blocks the request path; events are dropped when the buffer is full
+type Publisher struct {
+	writer  *kafka.Writer
+	logger  *logger.Logger
+	queue   chan kafka.Message
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+
+	// closed is set by Close, under mu, so no Publish is sending once it is
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewPublisher creates a publisher for the given brokers and topic and starts its flush loop
+func NewPublisher(brokers []string, topic string, log *logger.Logger) *Publisher {
+	p := &Publisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireOne,
+			BatchSize:    batchSize,
+			BatchTimeout: writeBatchTimeout,
+			WriteTimeout: 10 * time.Second,
+		},
+		logger: log,
+		queue:  make(chan kafka.Message, bufferSize),
+		done:   make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p
+}
+
+// Publish queues an event keyed by key
+// Returns false if the event was dropped because the buffer is full or the publisher is closed
+func (p *Publisher) Publish(key string, event interface{}) bool {
+	value, err := json.Marshal(event)
+	if err != nil {
+		p.logger.Error("Failed to marshal event for %s: %v", p.writer.Topic, err)
+		return false
+	}
+
+	// Requests still running after shutdown timed out can publish after Close
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		p.dropped.Add(1)
+		return false
+	}
+	select {
+	case p.queue <- kafka.Message{Key: []byte(key), Value: value}:
+		return true
+	default:
+		p.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns the number of events dropped since startup
+func (p *Publisher) Dropped() int64 {
+	return p.dropped.Load()
+}
+
+// run drains the queue into Kafka in batches until the publisher is closed
+func (p *Publisher) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]kafka.Message, 0, batchSize)
+	var reportedDrops int64
+
+	flush := func() {
+		if len(batch) > 0 {
+			if err := p.writer.WriteMessages(context.Background(), batch...); err != nil {
+				p.logger.Error("Failed to publish %d events to %s: %v", len(batch), p.writer.Topic, err)
+			}
+			batch = batch[:0]
+		}
+
+		if dropped := p.dropped.Load(); dropped > reportedDrops {
+			p.logger.Warn("Dropped %d events for %s (buffer full)", dropped-reportedDrops, p.writer.Topic)
+			reportedDrops = dropped
+		}
+	}
+
+	for {
+		select {
+		case msg := <-p.queue:
+			batch = append(batch, msg)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-p.done:
+			// Nothing is sent once done is closed, so what is queued is all there is
+			for {
+				select {
+				case msg := <-p.queue:
+					batch = append(batch, msg)
+					if len(batch) >= batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Close flushes queued events and closes the Kafka writer; events published after it are dropped
+func (p *Publisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.done)
+	p.wg.Wait()
+	return p.writer.Close()
+}
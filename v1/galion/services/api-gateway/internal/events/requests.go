@@ -0,0 +1,82 @@
+package events
+
+import (
+	"net/http"
+	"time"
+
+	"nexus-api-gateway/internal/routes"
+
+	"nexus-common/requestid"
+)
+
+// RequestEventType is the event_type of request events
+const RequestEventType = "gateway.request"
+
+// RequestEvent is a compact record of one completed request for usage analytics
+// Unlike access log events it carries no client details, query strings or raw paths
+type RequestEvent struct {
+	EventType string      `json:"event_type"`
+	UserID    string      `json:"user_id"`
+	Timestamp string      `json:"timestamp"`
+	Service   string      `json:"service"`
+	Data      RequestData `json:"data"`
+}
+
+// RequestData holds the measurements of a request event
+type RequestData struct {
+	RequestID string  `json:"request_id"`
+	Route     string  `json:"route"`
+	Method    string  `json:"method"`
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+	// Caller is the service that made an internal listener call
+	Caller string `json:"caller,omitempty"`
+}
+
+// Requests returns middleware that publishes one request event per completed request
+// Requests outside the route table (probes, metrics) are labelled route "none"; users are
+// identified by the HMAC of their email under userKey
+func Requests(p *Publisher, table routes.Table, userKey []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			wrapped := &countingWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r)
+
+			route := "none"
+			if matched := table.Match(r.URL.Path); matched != nil {
+				route = matched.Name
+			}
+			bytesIn := r.ContentLength
+			if bytesIn < 0 {
+				bytesIn = 0
+			}
+
+			requestID := requestid.FromContext(r.Context())
+			p.Publish(requestID, RequestEvent{
+				EventType: RequestEventType,
+				UserID:    userID(userKey, r),
+				Timestamp: start.UTC().Format(time.RFC3339),
+				Service:   "api-gateway",
+				Data: RequestData{
+					RequestID: requestID,
+					Route:     route,
+					Method:    r.Method,
+					Status:    wrapped.statusCode,
+					LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+					BytesIn:   bytesIn,
+					BytesOut:  wrapped.bytes,
+					// Set by service token authentication on the internal listener
+					Caller: r.Header.Get("X-Service-Name"),
+				},
+			})
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// userID is the user authenticated for r, as a keyed hash of their email, or "" if there is none
+// The email is the X-User-Email set by the auth middleware; inbound ones are removed at the edge
+func userID(key []byte, r *http.Request) string {
+	email := r.Header.Get("X-User-Email")
+	if email == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(email))
+	return hex.EncodeToString(mac.Sum(nil))
+}
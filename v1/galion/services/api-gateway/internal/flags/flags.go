@@ -0,0 +1,173 @@
+// Package flags toggles gateway middleware at runtime, globally or per route
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Flags controlling optional middleware
+const (
+	RateLimit     = "rate_limit"
+	Tracing       = "tracing"
+	ServerTiming  = "server_timing"
+	ResponseCache = "response_cache"
+	UsageTracking = "usage_tracking"
+	ErrorEnvelope = "error_envelope"
+)
+
+// Provider loads flag overrides
+// Keys are a flag name ("rate_limit") or a flag scoped to a route ("rate_limit@users")
+type Provider interface {
+	Name() string
+	Load(ctx context.Context) (map[string]bool, error)
+}
+
+// Flags resolves flag states from defaults and the provider's latest overrides
+// A route override wins over a global override, which wins over the default
+type Flags struct {
+	defaults  map[string]bool
+	provider  Provider
+	overrides atomic.Pointer[map[string]bool]
+	logger    *logger.Logger
+
+	mu     sync.Mutex
+	loaded map[string]bool // last provider result, to log only changes
+
+}
+
+// New creates flags with the given defaults; only flags present in defaults are known
+func New(defaults map[string]bool, provider Provider, log *logger.Logger) *Flags {
+	f := &Flags{
+		defaults: defaults,
+		provider: provider,
+		logger:   log,
+	}
+	f.overrides.Store(&map[string]bool{})
+	f.publish()
+	return f
+}
+
+// Enabled reports whether flag is on for route ("" for requests matching no route)
+func (f *Flags) Enabled(flag, route string) bool {
+	overrides := *f.overrides.Load()
+	if route != "" {
+		if on, ok := overrides[flag+"@"+route]; ok {
+			return on
+		}
+	}
+	if on, ok := overrides[flag]; ok {
+		return on
+	}
+	return f.defaults[flag]
+}
+
+// Refresh reloads overrides from the provider
+// On error the previous overrides stay in effect
+func (f *Flags) Refresh(ctx context.Context) error {
+	loaded, err := f.provider.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.loaded != nil && maps.Equal(loaded, f.loaded) {
+		return nil
+	}
+	f.loaded = loaded
+
+	overrides := make(map[string]bool, len(loaded))
+	for key, on := range loaded {
+		name, _, _ := strings.Cut(key, "@")
+		if _, known := f.defaults[name]; !known {
+			f.logger.Warn("Ignoring unknown feature flag %q from %s", key, f.provider.Name())
+			continue
+		}
+		overrides[key] = on
+	}
+
+	f.overrides.Store(&overrides)
+	f.logger.Info("Feature flag overrides from %s: %v", f.provider.Name(), overrides)
+	f.publish()
+	return nil
+}
+
+// Start refreshes overrides every interval until ctx is cancelled
+func (f *Flags) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f.Refresh(ctx); err != nil {
+					f.logger.Error("Failed to refresh feature flags from %s: %v", f.provider.Name(), err)
+				}
+			}
+		}
+	}()
+}
+
+// Gate returns middleware that applies mw only to requests whose route has flag enabled
+func (f *Flags) Gate(flag string, table routes.Table, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		gated := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := ""
+			if match := table.Match(r.URL.Path); match != nil {
+				route = match.Name
+			}
+			if f.Enabled(flag, route) {
+				gated.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Handler serves the defaults and current overrides as JSON
+func (f *Flags) Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"source":    f.provider.Name(),
+		"defaults":  f.defaults,
+		"overrides": *f.overrides.Load(),
+	})
+}
+
+// publish exports the global state of every flag and each route override as metrics
+func (f *Flags) publish() {
+	metrics.FeatureFlagEnabled.Reset()
+	for name := range f.defaults {
+		metrics.SetFeatureFlag(name, "all", f.Enabled(name, ""))
+	}
+
+	overrides := *f.overrides.Load()
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if name, route, scoped := strings.Cut(key, "@"); scoped {
+			metrics.SetFeatureFlag(name, route, overrides[key])
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package flags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ParseOverrides parses a comma-separated list of key=bool pairs, e.g. "tracing=true,rate_limit@users=false"
+func ParseOverrides(spec string) (map[string]bool, error) {
+	overrides := make(map[string]bool)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("%q must be flag=true|false or flag@route=true|false", pair)
+		}
+		on, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%q: value must be true or false", pair)
+		}
+		overrides[strings.TrimSpace(key)] = on
+	}
+	return overrides, nil
+}
+
+// EnvProvider serves overrides fixed at startup from the FEATURE_FLAGS setting
+type EnvProvider struct {
+	Spec string
+}
+
+// Name identifies the provider in logs
+func (p EnvProvider) Name() string {
+	return "env"
+}
+
+// Load parses the overrides
+func (p EnvProvider) Load(ctx context.Context) (map[string]bool, error) {
+	return ParseOverrides(p.Spec)
+}
+
+// FileProvider reads overrides from a JSON object of key to bool, re-read on every refresh
+type FileProvider struct {
+	Path string
+}
+
+// Name identifies the provider in logs
+func (p FileProvider) Name() string {
+	return "file " + p.Path
+}
+
+// Load reads the file
+func (p FileProvider) Load(ctx context.Context) (map[string]bool, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flags file: %w", err)
+	}
+	var overrides map[string]bool
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse flags file %s: %w", p.Path, err)
+	}
+	return overrides, nil
+}
+
+// RedisProvider reads overrides from a Redis hash of key to "true"/"false"
+// Shared by every gateway instance, so one HSET flips a flag everywhere
+type RedisProvider struct {
+	Client redis.UniversalClient
+	Key    string
+}
+
+// Name identifies the provider in logs
+func (p RedisProvider) Name() string {
+	return "redis " + p.Key
+}
+
+// Load reads the hash
+func (p RedisProvider) Load(ctx context.Context) (map[string]bool, error) {
+	fields, err := p.Client.HGetAll(ctx, p.Key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read flags hash: %w", err)
+	}
+	overrides := make(map[string]bool, len(fields))
+	for key, value := range fields {
+		on, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("flag %q: value %q must be true or false", key, value)
+		}
+		overrides[key] = on
+	}
+	return overrides, nil
+}
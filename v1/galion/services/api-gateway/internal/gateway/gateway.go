@@ -0,0 +1,594 @@
+// Package gateway assembles the public router and middleware chain from configuration
+// It is shared by the gateway binary and the gatewaytest harness so both run the same chain
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/cors"
+
+	"nexus-api-gateway/internal/aggregate"
+	"nexus-api-gateway/internal/apikeys"
+	"nexus-api-gateway/internal/auth"
+	"nexus-api-gateway/internal/batch"
+	"nexus-api-gateway/internal/cache"
+	"nexus-api-gateway/internal/capture"
+	"nexus-api-gateway/internal/config"
+	"nexus-api-gateway/internal/discovery"
+	"nexus-api-gateway/internal/envelope"
+	"nexus-api-gateway/internal/events"
+	"nexus-api-gateway/internal/flags"
+	"nexus-api-gateway/internal/graphql"
+	"nexus-api-gateway/internal/health"
+	"nexus-api-gateway/internal/middleware"
+	"nexus-api-gateway/internal/mock"
+	"nexus-api-gateway/internal/notifications"
+	"nexus-api-gateway/internal/openapi"
+	"nexus-api-gateway/internal/policy"
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/redisclient"
+	"nexus-api-gateway/internal/reporting"
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/internal/status"
+	"nexus-api-gateway/internal/timing"
+	"nexus-api-gateway/internal/tracing"
+	"nexus-api-gateway/internal/uploads"
+	"nexus-api-gateway/internal/usage"
+	"nexus-api-gateway/internal/webhooks"
+	"nexus-api-gateway/pkg/plugin"
+
+	"nexus-common/logger"
+	"nexus-common/metrics"
+	"nexus-common/version"
+)
+
+// Gateway is the assembled public handler and the components admin endpoints and shutdown need
+type Gateway struct {
+	Handler http.Handler
+	// InternalHandler serves service-to-service calls; nil unless INTERNAL_PORT is set
+	InternalHandler http.Handler
+	Routes          routes.Table
+	Upstreams       *proxy.Registry
+	RateLimiter     *middleware.RateLimiter
+	Flags           *flags.Flags
+	Capturer        *capture.Capturer
+	Health          *health.Checker
+	// Notifications relays backend messages to users' SSE streams; nil unless NOTIFICATIONS_ENABLED is set
+	Notifications *notifications.Relay
+	// Webhooks delivers backend events to customer URLs; nil unless WEBHOOKS_ENABLED is set
+	Webhooks *webhooks.Dispatcher
+	// Recordings stores the requests of routes an admin is recording, for replay
+	Recordings *capture.Recorder
+
+	accessLog     *events.Publisher // nil unless access log events are enabled
+	requestEvents *events.Publisher // nil unless request events are enabled
+	plugins       *plugin.Chain
+	stop          context.CancelFunc
+	logger        *logger.Logger
+}
+
+// New builds the gateway described by cfg and starts its background work (health checks, flag refresh)
+// redisClient backs rate limiting, readiness and Redis feature flags; Close releases what New started
+func New(cfg *config.Config, redisClient redis.UniversalClient, log *logger.Logger) (*Gateway, error) {
+	routeTable, err := LoadRoutes(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	g := &Gateway{
+		Routes: routeTable,
+		stop:   stop,
+		logger: log,
+	}
+
+	jwtValidator := auth.NewJWTValidator(cfg.JWTSecretKey, cfg.JWTAlgorithm)
+	authMiddleware := middleware.NewAuthMiddleware(jwtValidator, log)
+	// Rate limiting is switched on and off by the rate_limit feature flag
+	g.RateLimiter = middleware.NewRateLimiter(redisClient, cfg.RateLimitPerMinute, true)
+	serviceProxy := proxy.NewServiceProxy(log)
+
+	// Register upstreams
+	g.Upstreams = proxy.NewRegistry(proxy.UpstreamConfig{
+		HealthCheckInterval: cfg.HealthCheckInterval,
+		HealthCheckTimeout:  cfg.HealthCheckTimeout,
+		BreakerThreshold:    cfg.CircuitBreakerThreshold,
+		BreakerOpenTimeout:  cfg.CircuitBreakerOpenTimeout,
+	}, log)
+	for _, route := range routeTable {
+		grpc := route.Protocol == routes.ProtocolGRPC
+		healthPath := route.HealthPath
+		switch {
+		case grpc:
+			healthPath = proxy.GRPCHealthPath
+		case healthPath == "":
+			healthPath = "/health"
+		}
+		g.Upstreams.Add(route.Name, route.Backend, healthPath).GRPC = grpc
+	}
+
+	// Mock mode answers from fixtures, so there are no backends to check
+	readinessMinUpstreams := cfg.ReadinessMinUpstreams
+	var mockBackends *mock.Backends
+	if cfg.MockBackends {
+		mockBackends, err = mock.Load(cfg.MockFixturesDir, routeTable, log)
+		if err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to load mock fixtures: %w", err)
+		}
+		readinessMinUpstreams = 0
+		log.Warn("Mock backend mode: serving fixtures from %s instead of proxying", cfg.MockFixturesDir)
+	} else {
+		// Routes using service discovery get their targets from Kubernetes or Consul
+		if err := discovery.Start(ctx, discovery.Config{
+			ConsulAddr:    cfg.DiscoveryConsulAddr,
+			ConsulToken:   cfg.DiscoveryConsulToken,
+			KubernetesAPI: cfg.DiscoveryKubernetesAPI,
+			RetryInterval: cfg.DiscoveryRetryInterval,
+		}, routeTable, g.Upstreams, log); err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to start service discovery: %w", err)
+		}
+		g.Upstreams.StartHealthChecks(ctx)
+	}
+
+	// Feature flags toggle optional middleware at runtime, globally or per route
+	g.Flags = flags.New(map[string]bool{
+		flags.RateLimit:     cfg.RateLimitEnabled,
+		flags.Tracing:       cfg.TracingEnabled,
+		flags.ServerTiming:  cfg.ServerTimingEnabled,
+		flags.ResponseCache: true, // only routes with a cache block are affected
+		flags.UsageTracking: true,
+		flags.ErrorEnvelope: true, // only routes with error_envelope set are affected
+	}, flagProvider(cfg, redisClient), log)
+	if err := g.Flags.Refresh(ctx); err != nil {
+		log.Error("Failed to load feature flags, using defaults: %v", err)
+	}
+	if cfg.FeatureFlagsSource != "env" {
+		g.Flags.Start(ctx, cfg.FeatureFlagsRefresh)
+	}
+
+	// Initialize debug capture (sanitized request/response recording)
+	g.Capturer = capture.NewCapturer(capture.Config{
+		Enabled:      cfg.CaptureEnabled,
+		Routes:       cfg.CaptureRoutes,
+		AllowHeader:  cfg.CaptureAllowHeader,
+		MaxBodyBytes: cfg.CaptureMaxBodyBytes,
+		TTL:          cfg.CaptureTTL,
+	}, capture.NewRedactor(cfg.CaptureRedactFields))
+	if cfg.CaptureEnabled {
+		log.Warn("Debug capture enabled for routes %v (header flag: %t)", cfg.CaptureRoutes, cfg.CaptureAllowHeader)
+	}
+
+	// Admins record a route's requests to Redis and replay them against another backend
+	g.Recordings = capture.NewRecorder(capture.RecorderConfig{
+		MaxBodyBytes:  cfg.CaptureMaxBodyBytes,
+		MaxRequests:   cfg.CaptureRecordingMax,
+		Retention:     cfg.CaptureRecordingRetention,
+		ReplayTimeout: cfg.CaptureReplayTimeout,
+	}, capture.NewRedactor(cfg.CaptureRedactFields), redisClient, log)
+	g.Recordings.Start(ctx)
+
+	// Load compiled-in plugins enabled by PLUGINS
+	g.plugins, err = plugin.Load(cfg.Plugins, log)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+
+	// Routes with a cache block serve GET responses from Redis, stale while refreshing or while the backend fails
+	responseCache := cache.New(redisClient, log)
+
+	router := mux.NewRouter()
+
+	// The internal listener serves the same routes to other services,
+	// authenticated by service token instead of user JWT
+	var internalRouter *mux.Router
+	var serviceAuth func(http.Handler) http.Handler
+	if cfg.InternalPort != "" {
+		tokens, err := middleware.ParseServiceTokens(cfg.ServiceTokens)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("invalid service tokens: %w", err)
+		}
+		internalRouter = mux.NewRouter()
+		serviceAuth = middleware.ServiceAuth(tokens, log)
+	}
+
+	// Outbound webhooks, enqueued by backends on the internal listener or from Kafka
+	if cfg.WebhooksEnabled {
+		g.Webhooks = webhooks.New(webhooks.Config{
+			SigningSecret:       cfg.WebhookSigningSecret,
+			Workers:             cfg.WebhookWorkers,
+			MaxAttempts:         cfg.WebhookMaxAttempts,
+			RetryBase:           cfg.WebhookRetryBase,
+			RetryMax:            cfg.WebhookRetryMax,
+			Timeout:             cfg.WebhookTimeout,
+			Retention:           cfg.WebhookRetention,
+			AllowPrivateTargets: cfg.WebhookAllowPrivate,
+		}, redisClient, log)
+		g.Webhooks.Start(ctx)
+		if cfg.WebhookKafkaTopic != "" {
+			g.Webhooks.Consume(ctx, cfg.KafkaBrokers, cfg.WebhookKafkaTopic)
+			log.Info("Consuming webhook requests from Kafka topic %s", cfg.WebhookKafkaTopic)
+		}
+		if internalRouter != nil {
+			internalRouter.Handle("/internal/webhooks", serviceAuth(http.HandlerFunc(g.Webhooks.EnqueueHandler))).Methods("POST")
+			internalRouter.Handle("/internal/webhooks/{id}", serviceAuth(http.HandlerFunc(g.Webhooks.StatusHandler))).Methods("GET")
+		}
+	}
+
+	// Health, liveness and readiness probes (no auth required)
+	var readinessRedis redis.UniversalClient
+	if cfg.ReadinessCheckRedis {
+		readinessRedis = redisClient
+	}
+	g.Health = health.NewChecker(readinessRedis, g.Upstreams, readinessMinUpstreams, cfg.HealthDeepTimeout)
+	for _, r := range []*mux.Router{router, internalRouter} {
+		if r == nil {
+			continue
+		}
+		r.HandleFunc("/health", g.Health.HealthHandler).Methods("GET")
+		r.HandleFunc("/livez", g.Health.LiveHandler).Methods("GET")
+		r.HandleFunc("/readyz", g.Health.ReadyHandler).Methods("GET")
+
+		// Build information (no auth required)
+		r.HandleFunc("/version", version.Handler).Methods("GET")
+
+		// Metrics endpoint for Prometheus (no auth required)
+		r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	}
+
+	// Users manage their own API keys; the secret is only shown when a key is created
+	if cfg.APIKeysEnabled {
+		keys := apikeys.New(apikeys.Config{MaxPerUser: cfg.APIKeysMaxPerUser}, redisClient, log)
+		requireAuth := authMiddleware.Require()
+		router.Handle(apikeys.Path, requireAuth(http.HandlerFunc(keys.ListHandler))).Methods("GET")
+		router.Handle(apikeys.Path, requireAuth(http.HandlerFunc(keys.CreateHandler))).Methods("POST")
+		router.Handle(apikeys.Path+"/{id}", requireAuth(http.HandlerFunc(keys.RenameHandler))).Methods("PATCH")
+		router.Handle(apikeys.Path+"/{id}", requireAuth(http.HandlerFunc(keys.RevokeHandler))).Methods("DELETE")
+	}
+
+	// Public backend summary for status pages; fixtures have no backend health to report
+	if cfg.StatusEnabled && mockBackends == nil {
+		page := status.New(status.Config{
+			CacheTTL:          cfg.StatusCacheTTL,
+			DegradedErrorRate: cfg.StatusDegradedErrorRate,
+			MinRequests:       int64(cfg.StatusMinRequests),
+		}, g.Upstreams)
+		router.HandleFunc(status.Path, page.Handler).Methods("GET")
+	}
+
+	// The gateway's own backend requests (GraphQL fields, OpenAPI documents) go through the proxy or the fixtures
+	var fetch proxy.FetchFunc
+	if mockBackends != nil {
+		fetch = mockFetch(mockBackends)
+	} else {
+		fetch = proxyFetch(serviceProxy, g.Upstreams)
+	}
+
+	// GraphQL over the user and content services, for pages that would otherwise make many REST calls
+	if cfg.GraphQLEnabled {
+		gql := graphql.New(graphql.Config{
+			MaxDepth:    cfg.GraphQLMaxDepth,
+			MaxFields:   cfg.GraphQLMaxFields,
+			Concurrency: cfg.GraphQLConcurrency,
+		}, routeTable, fetch, jwtValidator, log)
+		router.Handle("/graphql", gql).Methods("GET", "POST")
+		router.HandleFunc("/graphql/schema", gql.SchemaHandler).Methods("GET")
+	}
+
+	// Dashboard overview composed from the user and content services, degrading per section
+	if cfg.OverviewEnabled {
+		overview := aggregate.New(aggregate.Config{Timeout: cfg.AggregateSectionTimeout}, aggregate.Overview(cfg.OverviewFeedLimit), routeTable, fetch, log)
+		router.Handle(aggregate.OverviewPath, authMiddleware.Require()(overview)).Methods("GET")
+	}
+
+	// Several API requests in one round trip; the handler dispatches them into the chain assembled below
+	var batchHandler *batch.Handler
+	if cfg.BatchEnabled {
+		batchHandler = batch.New(batch.Config{
+			MaxRequests: cfg.BatchMaxRequests,
+			Concurrency: cfg.BatchConcurrency,
+		}, log)
+		router.Handle(batch.Path, batchHandler).Methods("POST")
+	}
+
+	// Real-time notifications: backends publish to Redis, users hold an SSE stream open here
+	if cfg.NotificationsEnabled {
+		g.Notifications = notifications.New(ctx, notifications.Config{
+			Heartbeat:  cfg.NotificationsHeartbeat,
+			MaxPerUser: cfg.NotificationsMaxPerUser,
+		}, redisClient, log)
+		router.Handle(notifications.Path, authMiddleware.Require()(g.Notifications)).Methods("GET")
+	}
+
+	// Large uploads go straight to object storage with a pre-signed URL the gateway issues
+	if cfg.UploadsEnabled {
+		uploader := uploads.New(uploads.Config{
+			S3: uploads.S3Config{
+				Bucket:          cfg.UploadsS3Bucket,
+				Region:          cfg.UploadsS3Region,
+				Endpoint:        cfg.UploadsS3Endpoint,
+				AccessKeyID:     cfg.UploadsS3AccessKeyID,
+				SecretAccessKey: cfg.UploadsS3SecretKey,
+				SessionToken:    cfg.UploadsS3SessionToken,
+			},
+			KeyPrefix:    cfg.UploadsKeyPrefix,
+			URLTTL:       cfg.UploadsURLTTL,
+			MaxBytes:     int64(cfg.UploadsMaxBytes),
+			ContentTypes: cfg.UploadsContentTypes,
+		}, log)
+		router.Handle(uploads.Path, authMiddleware.Require()(uploader)).Methods("POST")
+	}
+
+	// Per-user request counts by route and day, reported to the user for the billing period
+	meter := usage.New(usage.Config{
+		BillingDay: cfg.UsageBillingDay,
+		Retention:  cfg.UsageRetention,
+	}, redisClient, log)
+	meter.Start(ctx)
+	router.Handle(usage.Path, authMiddleware.Require()(http.HandlerFunc(meter.ReportHandler))).Methods("GET")
+
+	// Combined OpenAPI document of every backend, and Swagger UI over it
+	if cfg.DocsEnabled {
+		docs := openapi.New(openapi.Config{
+			RefreshInterval: cfg.DocsRefreshInterval,
+			SwaggerUIURL:    cfg.DocsSwaggerUIURL,
+		}, routeTable, fetch, log)
+		docsRouter := router.NewRoute().Subrouter()
+		if len(cfg.DocsBasicAuth) > 0 {
+			users, err := middleware.ParseBasicAuth(cfg.DocsBasicAuth)
+			if err != nil {
+				g.Close()
+				return nil, fmt.Errorf("invalid docs credentials: %w", err)
+			}
+			docsRouter.Use(middleware.BasicAuth(users, "Nexus API docs", log))
+		}
+		docsRouter.HandleFunc("/openapi.json", docs.SpecHandler).Methods("GET")
+		docsRouter.HandleFunc("/docs", docs.DocsHandler).Methods("GET")
+	}
+
+	// Backend service routes
+	// Handle all HTTP methods including OPTIONS for CORS preflight
+	for _, route := range routeTable {
+		policies, err := policy.Compile(route, log)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("invalid policy: %w", err)
+		}
+		subrouter := router.PathPrefix(route.PathPrefix).Subrouter()
+		subrouter.Use(middleware.Metrics(route))
+		subrouter.Use(g.Recordings.Middleware(route.Name))
+		subrouter.Use(g.plugins.AfterResponse(route.Name))
+		subrouter.Use(policies.Middleware())
+		subrouter.Use(g.plugins.BeforeAuth(route.Name))
+		if route.RequireAuth {
+			subrouter.Use(authMiddleware.Require())
+			// Only authenticated users are counted; other routes have no X-User-Email
+			subrouter.Use(g.Flags.Gate(flags.UsageTracking, routeTable, meter.Middleware(route.Name)))
+		}
+		subrouter.Use(g.plugins.BeforeProxy(route.Name))
+
+		var backend http.Handler
+		if mockBackends != nil {
+			backend = mockHandler(mockBackends, route.Name)
+		} else {
+			backend = proxyHandler(serviceProxy, g.Upstreams, route.Name)
+		}
+		if route.Cache != nil {
+			backend = g.Flags.Gate(flags.ResponseCache, routeTable, responseCache.Middleware(route))(backend)
+		}
+		// Outside the cache, so cached error responses are rewritten too
+		if route.ErrorEnvelope {
+			backend = g.Flags.Gate(flags.ErrorEnvelope, routeTable, envelope.Middleware())(backend)
+		}
+		subrouter.PathPrefix("").Handler(backend).Methods("GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS")
+
+		// Every internal route requires a service token, whether or not the route requires a user
+		if internalRouter != nil {
+			internal := internalRouter.PathPrefix(route.PathPrefix).Subrouter()
+			internal.Use(middleware.Metrics(route))
+			internal.Use(g.plugins.AfterResponse(route.Name))
+			internal.Use(policies.Middleware())
+			internal.Use(g.plugins.BeforeAuth(route.Name))
+			internal.Use(serviceAuth)
+			internal.Use(g.plugins.BeforeProxy(route.Name))
+			internal.PathPrefix("").Handler(backend).Methods("GET", "POST", "PUT", "PATCH", "DELETE")
+		}
+	}
+
+	// Apply global middleware (outermost first: request ID, server timing, tracing, access log, logging, client errors, rate limiting, capture, error reporting)
+	handler := reporting.Middleware(log)(router)
+	handler = g.Capturer.Middleware()(handler)
+	handler = g.Flags.Gate(flags.RateLimit, routeTable, g.RateLimiter.Middleware())(handler)
+	handler = middleware.ClientErrors(routeTable)(handler)
+	handler = middleware.Logging(log, routeTable)(handler)
+
+	// Publish access logs to Kafka for usage analytics
+	if cfg.AccessLogKafkaEnabled {
+		g.accessLog = events.NewPublisher(cfg.KafkaBrokers, cfg.AccessLogTopic, log)
+		handler = events.AccessLog(g.accessLog, []byte(cfg.EventsUserHashKey), capture.NewRedactor(cfg.CaptureRedactFields))(handler)
+		log.Info("Publishing access logs to Kafka topic %s", cfg.AccessLogTopic)
+	}
+
+	// Publish compact per-request usage events for the analytics service
+	if cfg.RequestEventsEnabled {
+		g.requestEvents = events.NewPublisher(cfg.KafkaBrokers, cfg.RequestEventsTopic, log)
+		handler = events.Requests(g.requestEvents, routeTable, []byte(cfg.EventsUserHashKey))(handler)
+		log.Info("Publishing request events to Kafka topic %s", cfg.RequestEventsTopic)
+	}
+
+	// Batched sub-requests are rate limited, logged and published like any other request,
+	// and share the batch's request ID and trace
+	if batchHandler != nil {
+		batchHandler.Pipeline = handler
+	}
+
+	// Continue or start W3C traces so latency metrics carry trace exemplars
+	handler = g.Flags.Gate(flags.Tracing, routeTable, tracing.Middleware())(handler)
+
+	// Report auth, upstream and gateway time to clients via Server-Timing
+	handler = g.Flags.Gate(flags.ServerTiming, routeTable, timing.Middleware(cfg.AllowedOrigins))(handler)
+
+	handler = middleware.RequestID(handler)
+
+	// Only the internal listener may assert a service identity, and only authentication a user
+	handler = middleware.StripServiceIdentity(handler)
+	handler = middleware.StripUserIdentity(handler)
+
+	// Apply CORS
+	g.Handler = cors.New(cors.Options{
+		AllowedOrigins:   cfg.AllowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{"Server-Timing", "Grpc-Status", "Grpc-Message"},
+		AllowCredentials: true,
+		MaxAge:           300, // Cache preflight requests for 5 minutes
+	}).Handler(handler)
+
+	// The internal chain skips CORS, rate limiting and Server-Timing; callers are trusted services
+	if internalRouter != nil {
+		internal := reporting.Middleware(log)(internalRouter)
+		internal = g.Capturer.Middleware()(internal)
+		internal = middleware.ClientErrors(routeTable)(internal)
+		internal = middleware.Logging(log, routeTable)(internal)
+		if g.accessLog != nil {
+			internal = events.AccessLog(g.accessLog, []byte(cfg.EventsUserHashKey), capture.NewRedactor(cfg.CaptureRedactFields))(internal)
+		}
+		if g.requestEvents != nil {
+			internal = events.Requests(g.requestEvents, routeTable, []byte(cfg.EventsUserHashKey))(internal)
+		}
+		internal = g.Flags.Gate(flags.Tracing, routeTable, tracing.Middleware())(internal)
+		g.InternalHandler = middleware.RequestID(internal)
+	}
+
+	return g, nil
+}
+
+// Close stops background work and flushes pending access log and request events and plugins
+func (g *Gateway) Close() {
+	g.stop()
+	if g.accessLog != nil {
+		if err := g.accessLog.Close(); err != nil {
+			g.logger.Error("Failed to close access log publisher: %v", err)
+		}
+	}
+	if g.requestEvents != nil {
+		if err := g.requestEvents.Close(); err != nil {
+			g.logger.Error("Failed to close request event publisher: %v", err)
+		}
+	}
+	if g.Webhooks != nil {
+		g.Webhooks.Close()
+	}
+	if g.plugins != nil {
+		g.plugins.Close()
+	}
+}
+
+// RedisConfig returns the Redis connection settings from cfg
+func RedisConfig(cfg *config.Config) redisclient.Config {
+	return redisclient.Config{
+		URL:              cfg.RedisURL,
+		Mode:             cfg.RedisMode,
+		Addrs:            cfg.RedisAddrs,
+		SentinelMaster:   cfg.RedisSentinelMaster,
+		SentinelPassword: cfg.RedisSentinelPassword,
+		Username:         cfg.RedisUsername,
+		Password:         cfg.RedisPassword,
+		TLSCAFile:        cfg.RedisTLSCAFile,
+		PoolSize:         cfg.RedisPoolSize,
+		MinIdleConns:     cfg.RedisMinIdleConns,
+		PoolTimeout:      cfg.RedisPoolTimeout,
+	}
+}
+
+// LoadRoutes returns the route table from ROUTES_FILE, or the built-in routes if it isn't set
+func LoadRoutes(cfg *config.Config) (routes.Table, error) {
+	if cfg.RoutesFile == "" {
+		return DefaultRoutes(cfg), nil
+	}
+	table, err := routes.Load(cfg.RoutesFile, map[string]string{
+		"AUTH_SERVICE_URL":    cfg.AuthServiceURL,
+		"USER_SERVICE_URL":    cfg.UserServiceURL,
+		"CONTENT_SERVICE_URL": cfg.ContentServiceURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load routes: %w", err)
+	}
+	return table, nil
+}
+
+// DefaultRoutes returns the built-in route table used when no route file is configured
+func DefaultRoutes(cfg *config.Config) routes.Table {
+	return routes.Table{
+		// Auth service routes (no auth required for login/register)
+		{Name: "auth", PathPrefix: "/api/v1/auth", Backend: cfg.AuthServiceURL},
+		// User service routes (require authentication)
+		{Name: "users", PathPrefix: "/api/v1/users", Backend: cfg.UserServiceURL, RequireAuth: true},
+		// Content service routes (require authentication)
+		{Name: "content", PathPrefix: "/api/v1/content", Backend: cfg.ContentServiceURL, RequireAuth: true},
+	}
+}
+
+// proxyHandler forwards a route's requests to its upstream, or to the route a policy chose
+func proxyHandler(serviceProxy *proxy.ServiceProxy, upstreams *proxy.Registry, route string) http.HandlerFunc {
+	upstream := upstreams.Get(route)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if target := policy.TargetRoute(r.Context()); target != "" {
+			serviceProxy.ProxyRequest(w, r, upstreams.Get(target))
+			return
+		}
+		serviceProxy.ProxyRequest(w, r, upstream)
+	}
+}
+
+// mockHandler answers a route's requests from its fixtures, or from the fixtures of the route a policy chose
+func mockHandler(backends *mock.Backends, route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := route
+		if target := policy.TargetRoute(r.Context()); target != "" {
+			name = target
+		}
+		backends.Handler(name)(w, r)
+	}
+}
+
+// proxyFetch makes the gateway's own backend requests through the service proxy
+func proxyFetch(serviceProxy *proxy.ServiceProxy, upstreams *proxy.Registry) proxy.FetchFunc {
+	return func(r *http.Request, route, path string, header http.Header) (*http.Response, error) {
+		return serviceProxy.Fetch(r, upstreams.Get(route), path, header)
+	}
+}
+
+// mockFetch answers the gateway's own backend requests from a route's fixtures
+func mockFetch(backends *mock.Backends) proxy.FetchFunc {
+	return func(r *http.Request, route, path string, header http.Header) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = header
+		recorder := httptest.NewRecorder()
+		backends.Handler(route)(recorder, req)
+		return recorder.Result(), nil
+	}
+}
+
+// flagProvider returns the configured source of feature flag overrides
+func flagProvider(cfg *config.Config, redisClient redis.UniversalClient) flags.Provider {
+	switch cfg.FeatureFlagsSource {
+	case "file":
+		return flags.FileProvider{Path: cfg.FeatureFlagsFile}
+	case "redis":
+		return flags.RedisProvider{Client: redisClient, Key: cfg.FeatureFlagsRedisKey}
+	default:
+		return flags.EnvProvider{Spec: cfg.FeatureFlags}
+	}
+}
@@ -0,0 +1,216 @@
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/routes"
+)
+
+// Error is a GraphQL error as returned in the "errors" list of a response
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Location is a line and column in the query document
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func locatedError(sel *selection, format string, args ...interface{}) *Error {
+	return &Error{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []Location{{Line: sel.line, Column: sel.column}},
+	}
+}
+
+// object is a response object that keeps its fields in query order
+type object struct {
+	keys   []string
+	values []interface{}
+}
+
+// MarshalJSON encodes the object with fields in query order, as GraphQL requires
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		b.Write(name)
+		b.WriteByte(':')
+		value, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// request is the state of one GraphQL request: its caller, variables, backends and errors
+type request struct {
+	http          *http.Request
+	header        http.Header // forwarded to backends
+	authenticated bool
+	vars          map[string]interface{}
+	routes        routes.Table
+	backend       proxy.FetchFunc
+	loader        *loader
+
+	mu     sync.Mutex
+	errors []*Error
+}
+
+func (req *request) addError(sel *selection, path []interface{}, err error) {
+	gqlErr := &Error{
+		Message:   err.Error(),
+		Locations: []Location{{Line: sel.line, Column: sel.column}},
+		Path:      path,
+	}
+	req.mu.Lock()
+	req.errors = append(req.errors, gqlErr)
+	req.mu.Unlock()
+}
+
+// execute resolves a selection set for a batch of parents of the same type
+// Each field is resolved once for the whole batch, so a list of N items costs one
+// resolver call per field rather than N; fields of a selection set resolve concurrently
+func (s *schema) execute(req *request, typeName string, selections []*selection, parents []map[string]interface{}, path []interface{}) []*object {
+	results := make([]*object, len(parents))
+	for i := range parents {
+		results[i] = &object{
+			keys:   make([]string, len(selections)),
+			values: make([]interface{}, len(selections)),
+		}
+		for j, sel := range selections {
+			results[i].keys[j] = sel.key()
+		}
+	}
+
+	t := s.types[typeName]
+	var wg sync.WaitGroup
+	for j, sel := range selections {
+		if sel.name == "__typename" {
+			for i := range parents {
+				results[i].values[j] = typeName
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(j int, sel *selection) {
+			defer wg.Done()
+			values := s.resolveField(req, t.field(sel.name), sel, parents, append(path[:len(path):len(path)], sel.key()))
+			for i := range parents {
+				results[i].values[j] = values[i]
+			}
+		}(j, sel)
+	}
+	wg.Wait()
+	return results
+}
+
+// resolveField resolves one field for every parent, then its selection set for every resulting object
+func (s *schema) resolveField(req *request, f *field, sel *selection, parents []map[string]interface{}, path []interface{}) []interface{} {
+	values := make([]interface{}, len(parents))
+
+	if f.route != "" {
+		route := req.route(f.route)
+		if route == nil {
+			req.addError(sel, path, fmt.Errorf("route %q is not configured", f.route))
+			return values
+		}
+		if route.RequireAuth && !req.authenticated {
+			req.addError(sel, path, fmt.Errorf("authentication required"))
+			return values
+		}
+	}
+
+	args, err := f.coerceArgs(sel, req.vars)
+	if err != nil {
+		req.addError(sel, path, err)
+		return values
+	}
+
+	if f.resolve == nil {
+		for i, parent := range parents {
+			values[i] = parent[f.name]
+		}
+	} else {
+		resolved, err := f.resolve(req, args, parents)
+		if err != nil {
+			req.addError(sel, path, err)
+			return values
+		}
+		copy(values, resolved)
+	}
+
+	elem, _ := namedType(f.typ)
+	if scalars[elem] {
+		for i := range values {
+			values[i] = coerceOutput(f.typ, values[i])
+		}
+		return values
+	}
+
+	// Gather the objects of every parent (lists are flattened) and resolve their selections as one batch
+	var children []map[string]interface{}
+	for _, value := range values {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			children = append(children, v)
+		case []interface{}:
+			for _, item := range v {
+				if child, ok := item.(map[string]interface{}); ok {
+					children = append(children, child)
+				}
+			}
+		}
+	}
+	resolved := s.execute(req, elem, sel.selections, children, path)
+
+	next := 0
+	for i, value := range values {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			values[i] = resolved[next]
+			next++
+		case []interface{}:
+			list := make([]interface{}, len(v))
+			for k, item := range v {
+				if _, ok := item.(map[string]interface{}); ok {
+					list[k] = resolved[next]
+					next++
+				}
+			}
+			values[i] = list
+		default:
+			values[i] = nil
+		}
+	}
+	return values
+}
+
+func (req *request) route(name string) *routes.Route {
+	for i := range req.routes {
+		if req.routes[i].Name == name {
+			return &req.routes[i]
+		}
+	}
+	return nil
+}
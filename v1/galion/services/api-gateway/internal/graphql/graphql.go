@@ -0,0 +1,257 @@
+// Package graphql serves a GraphQL endpoint stitching the user and content services behind one typed schema
+// Fields are resolved by GETs to the routes' backends: per request, repeated lookups are made once,
+// lists resolve each field for all items together, and fields backed by routes requiring auth need a valid token
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"nexus-api-gateway/internal/auth"
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/routes"
+
+	"nexus-common/logger"
+)
+
+// maxQueryBytes caps the size of a request body
+const maxQueryBytes = 1 << 20
+
+// Config configures the GraphQL endpoint
+type Config struct {
+	MaxDepth    int // deepest selection nesting a query may use
+	MaxFields   int // fields a query may select, counting each alias and nested field
+	Concurrency int // backend requests a query may have in flight at once
+}
+
+// Handler serves GraphQL queries
+type Handler struct {
+	config    Config
+	schema    *schema
+	routes    routes.Table
+	backend   proxy.FetchFunc
+	validator *auth.JWTValidator
+	logger    *logger.Logger
+}
+
+// New returns a handler resolving fields through backend, validating tokens with validator
+func New(config Config, table routes.Table, backend proxy.FetchFunc, validator *auth.JWTValidator, log *logger.Logger) *Handler {
+	return &Handler{
+		config:    config,
+		schema:    newGatewaySchema(),
+		routes:    table,
+		backend:   backend,
+		validator: validator,
+		logger:    log,
+	}
+}
+
+// params is a GraphQL request, from a JSON body or from query parameters
+type params struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// response is a GraphQL response
+type response struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// ServeHTTP executes a query sent as a JSON POST body or as GET query parameters
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var p params
+	switch r.Method {
+	case http.MethodGet:
+		p.Query = r.URL.Query().Get("query")
+		p.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &p.Variables); err != nil {
+				writeErrors(w, http.StatusBadRequest, &Error{Message: "variables must be a JSON object"})
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBytes)).Decode(&p); err != nil {
+			writeErrors(w, http.StatusBadRequest, &Error{Message: "request body must be a JSON object with a query"})
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeErrors(w, http.StatusMethodNotAllowed, &Error{Message: "use GET or POST"})
+		return
+	}
+
+	req := &request{
+		http:    r,
+		header:  backendHeader(r.Header),
+		vars:    p.Variables,
+		routes:  h.routes,
+		backend: h.backend,
+		loader:  newLoader(h.config.Concurrency),
+	}
+
+	// A missing token leaves the caller anonymous; a bad one is rejected outright
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		email, err := h.authenticate(authHeader)
+		if err != nil {
+			h.logger.WithContext(r.Context()).Debug("GraphQL authentication failed: %v", err)
+			writeErrors(w, http.StatusUnauthorized, &Error{Message: "invalid or expired token"})
+			return
+		}
+		req.header.Set("X-User-Email", email)
+		req.authenticated = true
+	}
+
+	op, err := h.prepare(p, req)
+	if err != nil {
+		if gqlErr, ok := err.(*Error); ok {
+			writeErrors(w, http.StatusBadRequest, gqlErr)
+		} else {
+			writeErrors(w, http.StatusBadRequest, &Error{Message: err.Error()})
+		}
+		return
+	}
+
+	data := h.schema.execute(req, "Query", op.selections, []map[string]interface{}{{}}, nil)[0]
+
+	// Fields resolve concurrently; report their errors in query order
+	sort.SliceStable(req.errors, func(i, j int) bool {
+		a, b := req.errors[i].Locations[0], req.errors[j].Locations[0]
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response{Data: data, Errors: req.errors})
+}
+
+// SchemaHandler serves the schema in GraphQL schema definition language
+func (h *Handler) SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(h.schema.SDL()))
+}
+
+// prepare parses and validates the query, picks the operation to run and binds its variables
+func (h *Handler) prepare(p params, req *request) (*operation, error) {
+	if strings.TrimSpace(p.Query) == "" {
+		return nil, fmt.Errorf("query is required")
+	}
+	ops, err := parse(p.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	var op *operation
+	switch {
+	case p.OperationName != "":
+		for _, candidate := range ops {
+			if candidate.name == p.OperationName {
+				op = candidate
+			}
+		}
+		if op == nil {
+			return nil, fmt.Errorf("unknown operation %q", p.OperationName)
+		}
+	case len(ops) == 1:
+		op = ops[0]
+	default:
+		return nil, fmt.Errorf("operationName is required when the document has several operations")
+	}
+
+	vars := make(map[string]interface{}, len(op.variables))
+	for _, def := range op.variables {
+		value, ok := req.vars[def.name]
+		if !ok && def.hasDefault {
+			value = def.value
+		}
+		coerced, err := coerceInput(def.typ, value, nil)
+		if err != nil {
+			return nil, fmt.Errorf("variable $%s: %v", def.name, err)
+		}
+		vars[def.name] = coerced
+	}
+	if err := checkVariables(op.selections, vars); err != nil {
+		return nil, err
+	}
+	req.vars = vars
+
+	if err := h.schema.validate("Query", op.selections, 1, h.config.MaxDepth); err != nil {
+		return nil, err
+	}
+	// Aliases let one query repeat a field, and its backend requests, any number of times
+	if n := countFields(op.selections); n > h.config.MaxFields {
+		return nil, locatedError(op.selections[0], "query selects %d fields, more than %d", n, h.config.MaxFields)
+	}
+	return op, nil
+}
+
+// countFields counts the fields of a selection set and of every one nested in it
+func countFields(selections []*selection) int {
+	n := len(selections)
+	for _, sel := range selections {
+		n += countFields(sel.selections)
+	}
+	return n
+}
+
+// checkVariables reports variables the query uses without declaring them
+func checkVariables(selections []*selection, vars map[string]interface{}) error {
+	for _, sel := range selections {
+		for _, value := range sel.args {
+			if err := checkValue(sel, value, vars); err != nil {
+				return err
+			}
+		}
+		if err := checkVariables(sel.selections, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkValue(sel *selection, value interface{}, vars map[string]interface{}) error {
+	switch v := value.(type) {
+	case variable:
+		if _, ok := vars[string(v)]; !ok {
+			return locatedError(sel, "variable $%s is not declared", v)
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := checkValue(sel, item, vars); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Handler) authenticate(authHeader string) (string, error) {
+	token, err := auth.ExtractToken(authHeader)
+	if err != nil {
+		return "", err
+	}
+	claims, err := h.validator.ValidateToken(token)
+	if err != nil {
+		return "", err
+	}
+	return auth.GetUserEmail(claims)
+}
+
+// backendHeader returns the caller's headers to forward to backends, minus those describing the GraphQL request itself
+func backendHeader(header http.Header) http.Header {
+	forward := header.Clone()
+	for _, name := range []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-User-Email"} {
+		forward.Del(name)
+	}
+	forward.Set("Accept", "application/json")
+	return forward
+}
+
+func writeErrors(w http.ResponseWriter, status int, errs ...*Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response{Errors: errs})
+}
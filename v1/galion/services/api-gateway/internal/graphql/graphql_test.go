@@ -0,0 +1,217 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestHandler() *Handler {
+	return &Handler{
+		config: Config{MaxDepth: 3, MaxFields: 10, Concurrency: 1},
+		schema: newGatewaySchema(),
+	}
+}
+
+func TestPrepareAccepts(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     params
+		vars  map[string]interface{}
+		field string
+	}{
+		{
+			name:  "shorthand",
+			p:     params{Query: `{ me { id name } }`},
+			field: "me",
+		},
+		{
+			name:  "nested to the limit",
+			p:     params{Query: `{ me { contents { id } } }`},
+			field: "me",
+		},
+		{
+			name:  "aliases of one field",
+			p:     params{Query: `{ a: user(id: "1") { id } b: user(id: "2") { id } }`},
+			field: "user",
+		},
+		{
+			name:  "same field twice",
+			p:     params{Query: `{ me { id } me { name } }`},
+			field: "me",
+		},
+		{
+			name:  "variables",
+			p:     params{Query: `query ($id: ID!) { user(id: $id) { id } }`},
+			vars:  map[string]interface{}{"id": "42"},
+			field: "user",
+		},
+		{
+			name:  "variable default",
+			p:     params{Query: `query ($limit: Int = 5) { contents(limit: $limit) { id } }`},
+			field: "contents",
+		},
+		{
+			name:  "named operation",
+			p:     params{Query: `query A { me { id } } query B { contents { id } }`, OperationName: "B"},
+			field: "contents",
+		},
+		{
+			name:  "typename",
+			p:     params{Query: `{ me { __typename id } }`},
+			field: "me",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := newTestHandler().prepare(tt.p, &request{vars: tt.vars})
+			if err != nil {
+				t.Fatalf("prepare: %v", err)
+			}
+			if got := op.selections[0].name; got != tt.field {
+				t.Errorf("got field %q, want %q", got, tt.field)
+			}
+		})
+	}
+}
+
+func TestPrepareVariables(t *testing.T) {
+	req := &request{vars: map[string]interface{}{"ids": []interface{}{"1", "2"}}}
+	_, err := newTestHandler().prepare(params{
+		Query: `query ($ids: [ID!]!, $limit: Int = 5) { users(ids: $ids) { contents(limit: $limit) { id } } }`,
+	}, req)
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	want := map[string]interface{}{"ids": []interface{}{"1", "2"}, "limit": 5}
+	if !reflect.DeepEqual(req.vars, want) {
+		t.Errorf("got variables %#v, want %#v", req.vars, want)
+	}
+}
+
+func TestPrepareRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		p    params
+		vars map[string]interface{}
+		err  string
+	}{
+		{
+			name: "too deep",
+			p:    params{Query: `{ me { contents { author { id } } } }`},
+			err:  "query is nested more than 3 levels deep",
+		},
+		{
+			name: "too many fields",
+			p:    params{Query: `{ a: me { id } b: me { id } c: me { id } d: me { id } e: me { id } f: me { id } }`},
+			err:  "query selects 12 fields, more than 10",
+		},
+		{
+			name: "alias conflict",
+			p:    params{Query: `{ x: me { id } x: contents { id } }`},
+			err:  `fields "me" and "contents" both answer as "x"; use different aliases`,
+		},
+		{
+			name: "alias shadowing a field",
+			p:    params{Query: `{ me { id } me: contents { id } }`},
+			err:  `fields "me" and "contents" both answer as "me"`,
+		},
+		{
+			name: "undeclared variable",
+			p:    params{Query: `{ user(id: $id) { id } }`},
+			err:  "variable $id is not declared",
+		},
+		{
+			name: "undeclared variable in a list",
+			p:    params{Query: `{ users(ids: ["1", $id]) { id } }`},
+			err:  "variable $id is not declared",
+		},
+		{
+			name: "undeclared nested variable",
+			p:    params{Query: `{ me { contents(limit: $limit) { id } } }`},
+			err:  "variable $limit is not declared",
+		},
+		{
+			name: "missing required variable",
+			p:    params{Query: `query ($id: ID!) { user(id: $id) { id } }`},
+			err:  "variable $id",
+		},
+		{
+			name: "variable of the wrong type",
+			p:    params{Query: `query ($limit: Int) { contents(limit: $limit) { id } }`},
+			vars: map[string]interface{}{"limit": "ten"},
+			err:  "variable $limit",
+		},
+		{
+			name: "fragment",
+			p:    params{Query: `{ me { ...F } } fragment F on User { id }`},
+			err:  "fragments are not supported",
+		},
+		{
+			name: "unknown field",
+			p:    params{Query: `{ me { password } }`},
+			err:  `type User has no field "password"`,
+		},
+		{
+			name: "unknown argument",
+			p:    params{Query: `{ me(id: "1") { id } }`},
+			err:  `field "me" has no argument "id"`,
+		},
+		{
+			name: "missing required argument",
+			p:    params{Query: `{ user { id } }`},
+			err:  `field "user" requires argument "id"`,
+		},
+		{
+			name: "selection set on a scalar",
+			p:    params{Query: `{ me { id { value } } }`},
+			err:  `field "id" is a scalar and takes no selection set`,
+		},
+		{
+			name: "object without a selection set",
+			p:    params{Query: `{ me }`},
+			err:  `field "me" of type User needs a selection set`,
+		},
+		{
+			name: "introspection",
+			p:    params{Query: `{ __schema { types { name } } }`},
+			err:  "introspection is not supported",
+		},
+		{
+			name: "unknown operation",
+			p:    params{Query: `query A { me { id } }`, OperationName: "B"},
+			err:  `unknown operation "B"`,
+		},
+		{
+			name: "several operations without a name",
+			p:    params{Query: `query A { me { id } } query B { me { id } }`},
+			err:  "operationName is required",
+		},
+		{
+			name: "empty query",
+			p:    params{Query: "  "},
+			err:  "query is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestHandler().prepare(tt.p, &request{vars: tt.vars})
+			if err == nil {
+				t.Fatalf("prepare(%q) succeeded, want %q", tt.p.Query, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCountFields(t *testing.T) {
+	ops, err := parse(`{ a: me { id name } b: me { contents { id title } } }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got := countFields(ops[0].selections); got != 7 {
+		t.Errorf("got %d fields, want 7", got)
+	}
+}
@@ -0,0 +1,574 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// operation is a parsed query operation
+type operation struct {
+	name       string
+	variables  []variableDefinition
+	selections []*selection
+}
+
+// variableDefinition declares an operation variable, e.g. ($id: ID!, $limit: Int = 10)
+type variableDefinition struct {
+	name       string
+	typ        string
+	value      interface{}
+	hasDefault bool
+}
+
+// selection is a field in a selection set
+type selection struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	selections []*selection
+	line       int
+	column     int
+}
+
+// key is the response key of the field: its alias, or its name
+func (s *selection) key() string {
+	if s.alias != "" {
+		return s.alias
+	}
+	return s.name
+}
+
+// variable is a reference to an operation variable in an argument value
+type variable string
+
+// enumValue is an unquoted enum literal in an argument value
+type enumValue string
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	line   int
+	column int
+}
+
+// parser is a recursive-descent parser for the executable subset of GraphQL the gateway supports
+type parser struct {
+	src    string
+	pos    int
+	line   int
+	column int
+	tok    token
+}
+
+// parse parses a query document into its operations
+func parse(src string) ([]*operation, error) {
+	p := &parser{src: src, line: 1, column: 1}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	var ops []*operation
+	for p.tok.kind != tokenEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, p.errorf("document contains no operations")
+	}
+	return ops, nil
+}
+
+func (p *parser) parseOperation() (*operation, error) {
+	op := &operation{}
+
+	// Shorthand query: { ... }
+	if p.is(tokenPunct, "{") {
+		var err error
+		op.selections, err = p.parseSelectionSet()
+		return op, err
+	}
+
+	if p.tok.kind != tokenName {
+		return nil, p.errorf("expected an operation, found %q", p.tok.value)
+	}
+	switch p.tok.value {
+	case "query":
+	case "mutation", "subscription":
+		return nil, p.errorf("%s operations are not supported", p.tok.value)
+	case "fragment":
+		return nil, p.errorf("fragments are not supported")
+	default:
+		return nil, p.errorf("unknown operation type %q", p.tok.value)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is(tokenPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for !p.is(tokenPunct, ")") {
+			def, err := p.parseVariableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, def)
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is(tokenPunct, "@") {
+		return nil, p.errorf("directives are not supported")
+	}
+
+	var err error
+	op.selections, err = p.parseSelectionSet()
+	return op, err
+}
+
+func (p *parser) parseVariableDefinition() (variableDefinition, error) {
+	var def variableDefinition
+	if err := p.expect(tokenPunct, "$"); err != nil {
+		return def, err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return def, err
+	}
+	def.name = name
+	if err := p.expect(tokenPunct, ":"); err != nil {
+		return def, err
+	}
+	def.typ, err = p.parseType()
+	if err != nil {
+		return def, err
+	}
+	if p.is(tokenPunct, "=") {
+		if err := p.next(); err != nil {
+			return def, err
+		}
+		def.value, err = p.parseValue(true)
+		if err != nil {
+			return def, err
+		}
+		def.hasDefault = true
+	}
+	return def, nil
+}
+
+// parseType parses a type reference such as ID!, [Int] or [ID!]!
+func (p *parser) parseType() (string, error) {
+	var typ string
+	if p.is(tokenPunct, "[") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		elem, err := p.parseType()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(tokenPunct, "]"); err != nil {
+			return "", err
+		}
+		typ = "[" + elem + "]"
+	} else {
+		name, err := p.expectName()
+		if err != nil {
+			return "", err
+		}
+		typ = name
+	}
+	if p.is(tokenPunct, "!") {
+		if err := p.next(); err != nil {
+			return "", err
+		}
+		typ += "!"
+	}
+	return typ, nil
+}
+
+func (p *parser) parseSelectionSet() ([]*selection, error) {
+	if err := p.expect(tokenPunct, "{"); err != nil {
+		return nil, err
+	}
+
+	var selections []*selection
+	for !p.is(tokenPunct, "}") {
+		if p.tok.kind == tokenEOF {
+			return nil, p.errorf("unterminated selection set")
+		}
+		if p.is(tokenPunct, "...") {
+			return nil, p.errorf("fragments are not supported")
+		}
+		sel, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+	if len(selections) == 0 {
+		return nil, p.errorf("selection set is empty")
+	}
+	return selections, p.next()
+}
+
+func (p *parser) parseField() (*selection, error) {
+	sel := &selection{line: p.tok.line, column: p.tok.column}
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	sel.name = name
+
+	if p.is(tokenPunct, ":") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		sel.alias = name
+		sel.name, err = p.expectName()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is(tokenPunct, "(") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		sel.args = make(map[string]interface{})
+		for !p.is(tokenPunct, ")") {
+			argName, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := sel.args[argName]; ok {
+				return nil, p.errorf("duplicate argument %q", argName)
+			}
+			if err := p.expect(tokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			sel.args[argName], err = p.parseValue(false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.is(tokenPunct, "@") {
+		return nil, p.errorf("directives are not supported")
+	}
+
+	if p.is(tokenPunct, "{") {
+		sel.selections, err = p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sel, nil
+}
+
+// parseValue parses an argument or default value; constant values may not reference variables
+func (p *parser) parseValue(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokenPunct && tok.value == "$":
+		if constant {
+			return nil, p.errorf("variables are not allowed in default values")
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		return variable(name), err
+
+	case tok.kind == tokenPunct && tok.value == "[":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.is(tokenPunct, "]") {
+			if p.tok.kind == tokenEOF {
+				return nil, p.errorf("unterminated list")
+			}
+			value, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, p.next()
+
+	case tok.kind == tokenPunct && tok.value == "{":
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		object := map[string]interface{}{}
+		for !p.is(tokenPunct, "}") {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			object[name], err = p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, p.next()
+
+	case tok.kind == tokenInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer %s", tok.value)
+		}
+		return n, p.next()
+
+	case tok.kind == tokenFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", tok.value)
+		}
+		return f, p.next()
+
+	case tok.kind == tokenString:
+		return tok.value, p.next()
+
+	case tok.kind == tokenName:
+		var value interface{}
+		switch tok.value {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = enumValue(tok.value)
+		}
+		return value, p.next()
+	}
+	return nil, p.errorf("unexpected %q in value", tok.value)
+}
+
+func (p *parser) is(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) expect(kind tokenKind, value string) error {
+	if !p.is(kind, value) {
+		return p.errorf("expected %q, found %q", value, p.tok.value)
+	}
+	return p.next()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.errorf("expected a name, found %q", p.tok.value)
+	}
+	name := p.tok.value
+	return name, p.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{
+		Message:   fmt.Sprintf(format, args...),
+		Locations: []Location{{Line: p.tok.line, Column: p.tok.column}},
+	}
+}
+
+// next reads the next token, skipping whitespace, commas and comments
+func (p *parser) next() error {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '#' {
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.advance(1)
+			}
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			p.advance(1)
+			continue
+		}
+		if strings.HasPrefix(p.src[p.pos:], "\ufeff") {
+			p.advance(len("\ufeff"))
+			continue
+		}
+		break
+	}
+
+	p.tok = token{line: p.line, column: p.column}
+	if p.pos >= len(p.src) {
+		p.tok.kind = tokenEOF
+		p.tok.value = "<EOF>"
+		return nil
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case strings.HasPrefix(p.src[p.pos:], "..."):
+		p.tok.kind, p.tok.value = tokenPunct, "..."
+		p.advance(3)
+	case strings.IndexByte("{}()[]:!$=@", c) >= 0:
+		p.tok.kind, p.tok.value = tokenPunct, string(c)
+		p.advance(1)
+	case c == '_' || isLetter(c):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.advance(1)
+		}
+		p.tok.kind, p.tok.value = tokenName, p.src[start:p.pos]
+	case c == '-' || isDigit(c):
+		return p.readNumber()
+	case c == '"':
+		return p.readString()
+	default:
+		r, _ := utf8.DecodeRuneInString(p.src[p.pos:])
+		p.tok.value = string(r)
+		return p.errorf("unexpected character %q", r)
+	}
+	return nil
+}
+
+func (p *parser) readNumber() error {
+	start := p.pos
+	float := false
+	if p.src[p.pos] == '-' {
+		p.advance(1)
+	}
+scan:
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case isDigit(c):
+		case c == '.' || c == 'e' || c == 'E':
+			float = true
+		case (c == '+' || c == '-') && float:
+		default:
+			break scan
+		}
+		p.advance(1)
+	}
+	p.tok.value = p.src[start:p.pos]
+	p.tok.kind = tokenInt
+	if float {
+		p.tok.kind = tokenFloat
+	}
+	return nil
+}
+
+func (p *parser) readString() error {
+	if strings.HasPrefix(p.src[p.pos:], `"""`) {
+		p.advance(3)
+		end := strings.Index(p.src[p.pos:], `"""`)
+		if end < 0 {
+			return p.errorf("unterminated block string")
+		}
+		p.tok.kind, p.tok.value = tokenString, strings.TrimSpace(p.src[p.pos:p.pos+end])
+		p.advance(end + 3)
+		return nil
+	}
+
+	p.advance(1)
+	var b strings.Builder
+	for {
+		if p.pos >= len(p.src) || p.src[p.pos] == '\n' {
+			return p.errorf("unterminated string")
+		}
+		c := p.src[p.pos]
+		if c == '"' {
+			p.advance(1)
+			break
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			p.advance(1)
+			continue
+		}
+		if p.pos+1 >= len(p.src) {
+			return p.errorf("unterminated string")
+		}
+		escape := p.src[p.pos+1]
+		p.advance(2)
+		switch escape {
+		case '"', '\\', '/':
+			b.WriteByte(escape)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if p.pos+4 > len(p.src) {
+				return p.errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(p.src[p.pos:p.pos+4], 16, 32)
+			if err != nil {
+				return p.errorf("invalid unicode escape")
+			}
+			b.WriteRune(rune(code))
+			p.advance(4)
+		default:
+			return p.errorf("invalid escape \\%c", escape)
+		}
+	}
+	p.tok.kind, p.tok.value = tokenString, b.String()
+	return nil
+}
+
+// advance moves past n bytes, tracking line and column for error locations
+func (p *parser) advance(n int) {
+	for i := 0; i < n && p.pos < len(p.src); i++ {
+		if p.src[p.pos] == '\n' {
+			p.line++
+			p.column = 1
+		} else {
+			p.column++
+		}
+		p.pos++
+	}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,146 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseNesting(t *testing.T) {
+	ops, err := parse(`{ me { id contents(limit: 5) { title author { name } } } }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("got %d operations, want 1", len(ops))
+	}
+
+	var path []string
+	for sels := ops[0].selections; len(sels) > 0; sels = sels[len(sels)-1].selections {
+		path = append(path, sels[len(sels)-1].name)
+	}
+	if want := []string{"me", "contents", "author", "name"}; !reflect.DeepEqual(path, want) {
+		t.Errorf("got path %v, want %v", path, want)
+	}
+
+	contents := ops[0].selections[0].selections[1]
+	if got := contents.args["limit"]; got != int64(5) {
+		t.Errorf("got limit %#v, want 5", got)
+	}
+	if contents.line != 1 || contents.column != 11 {
+		t.Errorf("got location %d:%d, want 1:11", contents.line, contents.column)
+	}
+}
+
+func TestParseAliases(t *testing.T) {
+	ops, err := parse(`{ first: user(id: "1") { id } second: user(id: "2") { id } me { id } }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	tests := []struct {
+		alias, name, key string
+	}{
+		{"first", "user", "first"},
+		{"second", "user", "second"},
+		{"", "me", "me"},
+	}
+	sels := ops[0].selections
+	if len(sels) != len(tests) {
+		t.Fatalf("got %d selections, want %d", len(sels), len(tests))
+	}
+	for i, tt := range tests {
+		if sels[i].alias != tt.alias || sels[i].name != tt.name || sels[i].key() != tt.key {
+			t.Errorf("selection %d: got %q: %q (key %q), want %q: %q (key %q)",
+				i, sels[i].alias, sels[i].name, sels[i].key(), tt.alias, tt.name, tt.key)
+		}
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	ops, err := parse(`query Users($ids: [ID!]!, $limit: Int = 10, $active: Boolean) {
+		users(ids: $ids) { contents(limit: $limit) { id } }
+	}`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	op := ops[0]
+	if op.name != "Users" {
+		t.Errorf("got name %q, want Users", op.name)
+	}
+	want := []variableDefinition{
+		{name: "ids", typ: "[ID!]!"},
+		{name: "limit", typ: "Int", value: int64(10), hasDefault: true},
+		{name: "active", typ: "Boolean"},
+	}
+	if !reflect.DeepEqual(op.variables, want) {
+		t.Errorf("got variables %+v, want %+v", op.variables, want)
+	}
+	users := op.selections[0]
+	if got := users.args["ids"]; got != variable("ids") {
+		t.Errorf("got ids %#v, want $ids", got)
+	}
+	if got := users.selections[0].args["limit"]; got != variable("limit") {
+		t.Errorf("got limit %#v, want $limit", got)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	ops, err := parse(`{ f(a: 1, b: 1.5, c: "s", d: true, e: null, g: ASC, h: [1, $v], i: {x: 2}) }`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := map[string]interface{}{
+		"a": int64(1),
+		"b": 1.5,
+		"c": "s",
+		"d": true,
+		"e": nil,
+		"g": enumValue("ASC"),
+		"h": []interface{}{int64(1), variable("v")},
+		"i": map[string]interface{}{"x": int64(2)},
+	}
+	if got := ops[0].selections[0].args; !reflect.DeepEqual(got, want) {
+		t.Errorf("got args %#v, want %#v", got, want)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name, query, err string
+	}{
+		{"fragment definition", `fragment F on User { id }`, "fragments are not supported"},
+		{"fragment after query", `{ me { ...F } } fragment F on User { id }`, "fragments are not supported"},
+		{"fragment spread", `{ me { ...F } }`, "fragments are not supported"},
+		{"inline fragment", `{ me { ... on User { id } } }`, "fragments are not supported"},
+		{"mutation", `mutation { me { id } }`, "mutation operations are not supported"},
+		{"subscription", `subscription { me { id } }`, "subscription operations are not supported"},
+		{"directive", `{ me @include(if: true) { id } }`, "directives are not supported"},
+		{"variable in default", `query ($a: Int = $b) { me { id } }`, "variables are not allowed in default values"},
+		{"duplicate argument", `{ user(id: "1", id: "2") { id } }`, `duplicate argument "id"`},
+		{"empty selection set", `{ }`, "selection set is empty"},
+		{"unterminated selection set", `{ me { id }`, "unterminated selection set"},
+		{"empty document", ``, "document contains no operations"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.query)
+			if err == nil {
+				t.Fatalf("parse(%q) succeeded, want %q", tt.query, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %q, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseErrorLocation(t *testing.T) {
+	_, err := parse("{\n  me {\n    ...F\n  }\n}")
+	gqlErr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("got %T %v, want *Error", err, err)
+	}
+	if want := []Location{{Line: 3, Column: 5}}; !reflect.DeepEqual(gqlErr.Locations, want) {
+		t.Errorf("got locations %v, want %v", gqlErr.Locations, want)
+	}
+}
@@ -0,0 +1,243 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// maxBackendBody caps how much of a backend response is decoded
+const maxBackendBody = 10 << 20
+
+// maxBatch caps the IDs a single field may look up
+const maxBatch = 100
+
+// newGatewaySchema returns the schema stitching the user and content services
+func newGatewaySchema() *schema {
+	return newSchema(
+		&objectType{
+			name: "Query",
+			fields: []*field{
+				{name: "me", typ: "User", route: "users", description: "The authenticated user", resolve: resolveMe},
+				{name: "user", typ: "User", route: "users", args: []argument{{name: "id", typ: "ID!"}}, resolve: resolveUser},
+				{name: "users", typ: "[User]", route: "users", args: []argument{{name: "ids", typ: "[ID!]!"}}, description: "Users by ID, in the order given", resolve: resolveUsers},
+				{name: "content", typ: "Content", route: "content", args: []argument{{name: "id", typ: "ID!"}}, resolve: resolveContent},
+				{name: "contents", typ: "[Content]", route: "content", args: []argument{{name: "limit", typ: "Int", value: 20}, {name: "offset", typ: "Int", value: 0}}, description: "Published content, newest first", resolve: resolveContents},
+			},
+		},
+		&objectType{
+			name:        "User",
+			description: "A user profile from the user service",
+			fields: []*field{
+				{name: "id", typ: "ID!"},
+				{name: "email", typ: "String"},
+				{name: "name", typ: "String"},
+				{name: "bio", typ: "String"},
+				{name: "avatar_url", typ: "String"},
+				{name: "role", typ: "String"},
+				{name: "email_verified", typ: "Boolean"},
+				{name: "created_at", typ: "String"},
+				{name: "contents", typ: "[Content]", route: "content", args: []argument{{name: "limit", typ: "Int", value: 20}}, description: "Content authored by the user", resolve: resolveUserContents},
+			},
+		},
+		&objectType{
+			name:        "Content",
+			description: "A content item from the content service",
+			fields: []*field{
+				{name: "id", typ: "ID!"},
+				{name: "title", typ: "String"},
+				{name: "slug", typ: "String"},
+				{name: "body", typ: "String"},
+				{name: "excerpt", typ: "String"},
+				{name: "status", typ: "String"},
+				{name: "content_type", typ: "String"},
+				{name: "views", typ: "Int"},
+				{name: "author_id", typ: "ID"},
+				{name: "created_at", typ: "String"},
+				{name: "published_at", typ: "String"},
+				{name: "author", typ: "User", route: "users", description: "The author's user profile", resolve: resolveAuthor},
+			},
+		},
+	)
+}
+
+func resolveMe(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	me, err := req.get("users", "/me")
+	return []interface{}{me}, err
+}
+
+func resolveUser(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	user, err := req.get("users", "/"+url.PathEscape(args["id"].(string)))
+	return []interface{}{user}, err
+}
+
+func resolveUsers(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	ids := args["ids"].([]interface{})
+	if len(ids) > maxBatch {
+		return nil, fmt.Errorf("at most %d ids may be requested", maxBatch)
+	}
+	paths := make([]string, len(ids))
+	for i, id := range ids {
+		paths[i] = "/" + url.PathEscape(id.(string))
+	}
+	users, err := req.getAll("users", paths)
+	return []interface{}{users}, err
+}
+
+func resolveContent(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	content, err := req.get("content", "/"+url.PathEscape(args["id"].(string)))
+	return []interface{}{content}, err
+}
+
+func resolveContents(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	query := url.Values{}
+	query.Set("limit", fmt.Sprint(clampLimit(args["limit"])))
+	query.Set("skip", fmt.Sprint(args["offset"]))
+	body, err := req.get("content", "/?"+query.Encode())
+	return []interface{}{listItems(body)}, err
+}
+
+// resolveUserContents lists each user's content; users appearing more than once are fetched once
+func resolveUserContents(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	paths := make([]string, len(parents))
+	for i, user := range parents {
+		query := url.Values{}
+		query.Set("author_id", coerceOutput("ID", user["id"]).(string))
+		query.Set("limit", fmt.Sprint(clampLimit(args["limit"])))
+		paths[i] = "/?" + query.Encode()
+	}
+	bodies, err := req.getAll("content", paths)
+	for i := range bodies {
+		bodies[i] = listItems(bodies[i])
+	}
+	return bodies, err
+}
+
+// resolveAuthor looks up the authors of a batch of content items, each distinct author once
+func resolveAuthor(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error) {
+	paths := make([]string, len(parents))
+	for i, content := range parents {
+		if id := content["author_id"]; id != nil {
+			paths[i] = "/" + url.PathEscape(coerceOutput("ID", id).(string))
+		}
+	}
+	return req.getAll("users", paths)
+}
+
+// clampLimit keeps page sizes between 1 and maxBatch
+func clampLimit(limit interface{}) int {
+	n, _ := limit.(int)
+	if n < 1 {
+		return 1
+	}
+	if n > maxBatch {
+		return maxBatch
+	}
+	return n
+}
+
+// listItems returns the items of a list response: a JSON array, or an object with an "items" array
+func listItems(body interface{}) interface{} {
+	if object, ok := body.(map[string]interface{}); ok {
+		body = object["items"]
+	}
+	if items, ok := body.([]interface{}); ok {
+		return items
+	}
+	return nil
+}
+
+// loader deduplicates backend GETs within a request and bounds how many run at once
+type loader struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	slots   chan struct{}
+}
+
+type entry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func newLoader(concurrency int) *loader {
+	return &loader{
+		entries: make(map[string]*entry),
+		slots:   make(chan struct{}, concurrency),
+	}
+}
+
+// get returns the decoded JSON at path under a route's prefix; not found is nil, not an error
+func (req *request) get(route, path string) (interface{}, error) {
+	key := route + " " + path
+	req.loader.mu.Lock()
+	e, ok := req.loader.entries[key]
+	if !ok {
+		e = &entry{done: make(chan struct{})}
+		req.loader.entries[key] = e
+	}
+	req.loader.mu.Unlock()
+
+	if ok {
+		<-e.done
+		return e.value, e.err
+	}
+
+	req.loader.slots <- struct{}{}
+	e.value, e.err = req.fetch(route, path)
+	<-req.loader.slots
+	close(e.done)
+	return e.value, e.err
+}
+
+// getAll fetches paths concurrently, returning values in the same order; empty paths resolve to nil
+func (req *request) getAll(route string, paths []string) ([]interface{}, error) {
+	values := make([]interface{}, len(paths))
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		if path == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			values[i], errs[i] = req.get(route, path)
+		}(i, path)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return values, err
+		}
+	}
+	return values, nil
+}
+
+func (req *request) fetch(route, path string) (interface{}, error) {
+	r := req.route(route)
+	resp, err := req.backend(req.http, route, r.PathPrefix+path, req.header)
+	if err != nil {
+		return nil, fmt.Errorf("%s service unavailable", route)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("not authorized")
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("%s service returned %d", route, resp.StatusCode)
+	}
+
+	var value interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBackendBody)).Decode(&value); err != nil {
+		return nil, fmt.Errorf("%s service returned an invalid response", route)
+	}
+	return value, nil
+}
@@ -0,0 +1,313 @@
+package graphql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// resolveFunc resolves a field for a batch of parent objects, returning one value per parent
+// Values are objects (map[string]interface{}), lists of objects, scalars or nil
+type resolveFunc func(req *request, args map[string]interface{}, parents []map[string]interface{}) ([]interface{}, error)
+
+// objectType is an object type of the schema
+type objectType struct {
+	name        string
+	description string
+	fields      []*field
+}
+
+func (t *objectType) field(name string) *field {
+	for _, f := range t.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// field is a field of an object type
+// Fields backed by a route require a user whenever the route does; fields without
+// a resolver read the parent object's property of the same name
+type field struct {
+	name        string
+	typ         string
+	args        []argument
+	route       string
+	description string
+	resolve     resolveFunc
+}
+
+// argument is a field argument; a default applies when the argument is omitted or null
+type argument struct {
+	name  string
+	typ   string
+	value interface{}
+}
+
+// schema is the set of object types, rooted at Query
+type schema struct {
+	types map[string]*objectType
+	order []string
+}
+
+func newSchema(types ...*objectType) *schema {
+	s := &schema{types: make(map[string]*objectType)}
+	for _, t := range types {
+		s.types[t.name] = t
+		s.order = append(s.order, t.name)
+	}
+	return s
+}
+
+// SDL renders the schema in GraphQL schema definition language
+func (s *schema) SDL() string {
+	var b strings.Builder
+	for i, name := range s.order {
+		t := s.types[name]
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if t.description != "" {
+			fmt.Fprintf(&b, "\"%s\"\n", t.description)
+		}
+		fmt.Fprintf(&b, "type %s {\n", t.name)
+		for _, f := range t.fields {
+			if f.description != "" {
+				fmt.Fprintf(&b, "  \"%s\"\n", f.description)
+			}
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := make([]string, len(f.args))
+				for j, arg := range f.args {
+					args[j] = arg.name + ": " + arg.typ
+					if arg.value != nil {
+						args[j] += fmt.Sprintf(" = %v", arg.value)
+					}
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typ + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// scalars are the built-in scalar types
+var scalars = map[string]bool{"ID": true, "String": true, "Int": true, "Float": true, "Boolean": true}
+
+// namedType strips list and non-null wrappers: [User!]! is User, and a list
+func namedType(typ string) (name string, list bool) {
+	typ = strings.TrimSuffix(typ, "!")
+	if strings.HasPrefix(typ, "[") {
+		return strings.TrimSuffix(strings.TrimSuffix(typ[1:], "]"), "!"), true
+	}
+	return typ, false
+}
+
+// validate checks a query against the schema before anything is fetched
+func (s *schema) validate(typeName string, selections []*selection, depth, maxDepth int) error {
+	if depth > maxDepth {
+		return locatedError(selections[0], "query is nested more than %d levels deep", maxDepth)
+	}
+
+	t := s.types[typeName]
+	seen := make(map[string]*selection)
+	for _, sel := range selections {
+		if previous, ok := seen[sel.key()]; ok && previous.name != sel.name {
+			return locatedError(sel, "fields %q and %q both answer as %q; use different aliases", previous.name, sel.name, sel.key())
+		}
+		seen[sel.key()] = sel
+
+		if sel.name == "__typename" {
+			if sel.selections != nil {
+				return locatedError(sel, "field \"__typename\" is a scalar and takes no selection set")
+			}
+			continue
+		}
+		if strings.HasPrefix(sel.name, "__") {
+			return locatedError(sel, "introspection is not supported; GET /graphql/schema returns the schema")
+		}
+
+		f := t.field(sel.name)
+		if f == nil {
+			return locatedError(sel, "type %s has no field %q", t.name, sel.name)
+		}
+		for name := range sel.args {
+			if f.argument(name) == nil {
+				return locatedError(sel, "field %q has no argument %q", f.name, name)
+			}
+		}
+		for _, arg := range f.args {
+			if _, ok := sel.args[arg.name]; !ok && strings.HasSuffix(arg.typ, "!") && arg.value == nil {
+				return locatedError(sel, "field %q requires argument %q", f.name, arg.name)
+			}
+		}
+
+		elem, _ := namedType(f.typ)
+		switch {
+		case scalars[elem] && sel.selections != nil:
+			return locatedError(sel, "field %q is a scalar and takes no selection set", f.name)
+		case !scalars[elem] && sel.selections == nil:
+			return locatedError(sel, "field %q of type %s needs a selection set", f.name, f.typ)
+		case !scalars[elem]:
+			if err := s.validate(elem, sel.selections, depth+1, maxDepth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *field) argument(name string) *argument {
+	for i := range f.args {
+		if f.args[i].name == name {
+			return &f.args[i]
+		}
+	}
+	return nil
+}
+
+// coerceArgs resolves variables and defaults and converts argument values to their declared types
+func (f *field) coerceArgs(sel *selection, vars map[string]interface{}) (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(f.args))
+	for _, arg := range f.args {
+		value, given := sel.args[arg.name]
+		if name, ok := value.(variable); ok {
+			value, given = vars[string(name)]
+		}
+		if !given || value == nil {
+			value = arg.value
+		}
+		if value == nil {
+			if strings.HasSuffix(arg.typ, "!") {
+				return nil, locatedError(sel, "argument %q of field %q must not be null", arg.name, f.name)
+			}
+			continue
+		}
+		coerced, err := coerceInput(arg.typ, value, vars)
+		if err != nil {
+			return nil, locatedError(sel, "argument %q of field %q: %v", arg.name, f.name, err)
+		}
+		args[arg.name] = coerced
+	}
+	return args, nil
+}
+
+// coerceInput converts a literal or variable value to typ; IDs become strings and Ints become ints
+func coerceInput(typ string, value interface{}, vars map[string]interface{}) (interface{}, error) {
+	if name, ok := value.(variable); ok {
+		value = vars[string(name)]
+	}
+	nonNull := strings.HasSuffix(typ, "!")
+	typ = strings.TrimSuffix(typ, "!")
+	if value == nil {
+		if nonNull {
+			return nil, fmt.Errorf("expected %s!, found null", typ)
+		}
+		return nil, nil
+	}
+
+	if strings.HasPrefix(typ, "[") {
+		elemType := typ[1 : len(typ)-1]
+		items, ok := value.([]interface{})
+		if !ok {
+			// A single value is accepted where a list is expected
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			coerced, err := coerceInput(elemType, item, vars)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	}
+
+	switch typ {
+	case "ID":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+		}
+	case "String":
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case "Int":
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			if v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		case float64:
+			if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+				return int(v), nil
+			}
+		}
+	case "Float":
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case "Boolean":
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, found %v", typ, value)
+}
+
+// coerceOutput converts a backend JSON value to a scalar type; numeric IDs become strings
+func coerceOutput(typ string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	elem, list := namedType(typ)
+	if list {
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		out := make([]interface{}, len(items))
+		for i, item := range items {
+			out[i] = coerceOutput(elem, item)
+		}
+		return out
+	}
+
+	switch elem {
+	case "ID":
+		switch v := value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		return fmt.Sprint(value)
+	case "String":
+		if v, ok := value.(string); ok {
+			return v
+		}
+		return fmt.Sprint(value)
+	case "Int":
+		if v, ok := value.(float64); ok {
+			return int64(v)
+		}
+	}
+	return value
+}
@@ -0,0 +1,120 @@
+// Package health provides the gateway's liveness, readiness and deep health endpoints
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-api-gateway/internal/proxy"
+
+	base "nexus-common/health"
+)
+
+// BackendCheck is the result of probing one backend's health endpoint
+type BackendCheck struct {
+	Status     string  `json:"status"`
+	URL        string  `json:"url"`
+	StatusCode int     `json:"status_code,omitempty"`
+	LatencyMs  float64 `json:"latency_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Checker reports whether the gateway is alive and ready to serve traffic
+// Liveness, readiness and draining come from the shared checker
+type Checker struct {
+	*base.Checker
+	upstreams   *proxy.Registry
+	deepTimeout time.Duration
+}
+
+// NewChecker creates a readiness checker
+// If redisClient is nil, Redis connectivity is not part of readiness
+// deepTimeout bounds each backend probe made by DeepHandler
+func NewChecker(redisClient redis.UniversalClient, upstreams *proxy.Registry, minHealthyUpstreams int, deepTimeout time.Duration) *Checker {
+	c := &Checker{
+		Checker:     base.NewChecker("api-gateway"),
+		upstreams:   upstreams,
+		deepTimeout: deepTimeout,
+	}
+	if redisClient != nil {
+		c.AddCheck("redis", base.Ping(func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}))
+	}
+	c.AddCheck("upstreams", func(ctx context.Context) base.Check {
+		healthy := 0
+		for _, u := range upstreams.All() {
+			if u.Healthy() {
+				healthy++
+			}
+		}
+		check := base.Check{Status: "ok", Message: fmt.Sprintf("%d healthy, %d required", healthy, minHealthyUpstreams)}
+		if healthy < minHealthyUpstreams {
+			check.Status = "failing"
+		}
+		return check
+	})
+	return c
+}
+
+// DeepHandler probes every backend's health endpoint concurrently and reports the combined result
+// Returns 200 only if every backend is healthy, 503 otherwise
+func (c *Checker) DeepHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), c.deepTimeout)
+	defer cancel()
+
+	upstreams := c.upstreams.All()
+	results := make([]BackendCheck, len(upstreams))
+
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Add(1)
+		go func(i int, u *proxy.Upstream) {
+			defer wg.Done()
+
+			probe := c.upstreams.Probe(ctx, u)
+			check := BackendCheck{
+				Status:     "ok",
+				URL:        probe.URL,
+				StatusCode: probe.StatusCode,
+				LatencyMs:  float64(probe.Latency.Microseconds()) / 1000,
+			}
+			if !probe.Healthy {
+				check.Status = "failing"
+			}
+			if probe.Err != nil {
+				check.Error = probe.Err.Error()
+			}
+			results[i] = check
+		}(i, u)
+	}
+	wg.Wait()
+
+	backends := make(map[string]BackendCheck, len(upstreams))
+	healthy := 0
+	for i, u := range upstreams {
+		backends[u.Name] = results[i]
+		if results[i].Status == "ok" {
+			healthy++
+		}
+	}
+
+	status := http.StatusOK
+	body := map[string]interface{}{
+		"status":   "healthy",
+		"service":  "api-gateway",
+		"healthy":  healthy,
+		"total":    len(upstreams),
+		"backends": backends,
+	}
+	if healthy < len(upstreams) {
+		status = http.StatusServiceUnavailable
+		body["status"] = "unhealthy"
+	}
+	base.WriteJSON(w, status, body)
+}
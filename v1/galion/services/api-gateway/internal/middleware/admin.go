@@ -0,0 +1,86 @@
+// Package middleware provides admin API authentication
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"nexus-api-gateway/internal/audit"
+
+	"nexus-common/logger"
+)
+
+// AdminKeyHeader is the header carrying the admin API key
+const AdminKeyHeader = "X-Admin-Key"
+
+// ParseAdminOperatorKeys parses ADMIN_OPERATOR_KEYS entries of the form operator=key
+func ParseAdminOperatorKeys(entries []string) (map[string]string, error) {
+	keys := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		operator, key, ok := strings.Cut(entry, "=")
+		operator, key = strings.TrimSpace(operator), strings.TrimSpace(key)
+		if !ok || operator == "" || key == "" {
+			return nil, fmt.Errorf("entries must be operator=key")
+		}
+		if _, dup := keys[operator]; dup {
+			return nil, fmt.Errorf("operator %q is listed twice", operator)
+		}
+		keys[operator] = key
+	}
+	return keys, nil
+}
+
+// AdminAuth returns middleware that requires the shared admin API key or an operator's key
+// Requests with an operator's key are audited as that operator; the shared key, which names no
+// one, may only read. If no key is configured the admin API is disabled entirely
+func AdminAuth(apiKey string, operatorKeys map[string]string, log *logger.Logger) func(http.Handler) http.Handler {
+	// Compare against every key, in a fixed order, so timing reveals nothing about which one matched
+	operators := make([]string, 0, len(operatorKeys))
+	for operator := range operatorKeys {
+		operators = append(operators, operator)
+	}
+	sort.Strings(operators)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey == "" && len(operatorKeys) == 0 {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"not found"}`))
+				return
+			}
+
+			provided := r.Header.Get(AdminKeyHeader)
+			operator := ""
+			for _, name := range operators {
+				if subtle.ConstantTimeCompare([]byte(provided), []byte(operatorKeys[name])) == 1 {
+					operator = name
+				}
+			}
+			shared := apiKey != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) == 1
+			if operator == "" && !shared {
+				log.WithContext(r.Context()).Warn("Rejected admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"unauthorized","message":"missing or invalid admin key"}`))
+				return
+			}
+
+			if operator == "" && r.Method != http.MethodGet && r.Method != http.MethodHead {
+				log.WithContext(r.Context()).Warn("Rejected admin request %s %s from %s with the shared admin key", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":"forbidden","message":"admin actions need an operator's key from ADMIN_OPERATOR_KEYS"}`))
+				return
+			}
+
+			if operator != "" {
+				r = r.WithContext(audit.WithActor(r.Context(), operator))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
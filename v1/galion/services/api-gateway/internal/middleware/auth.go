@@ -2,10 +2,14 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"nexus-api-gateway/internal/auth"
-	"nexus-api-gateway/pkg/logger"
+	"nexus-api-gateway/internal/timing"
+
+	"nexus-common/logger"
 )
 
 // AuthMiddleware provides JWT authentication middleware
@@ -26,12 +30,19 @@ func NewAuthMiddleware(validator *auth.JWTValidator, log *logger.Logger) *AuthMi
 func (am *AuthMiddleware) Require() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			
 			// Extract token from Authorization header
 			authHeader := r.Header.Get("Authorization")
 			token, err := auth.ExtractToken(authHeader)
 			
 			if err != nil {
-				am.logger.Debug("Authentication failed: %v", err)
+				am.logger.WithContext(r.Context()).Debug("Authentication failed: %v", err)
+				if errors.Is(err, auth.ErrMissingToken) {
+					SetClientErrorReason(r.Context(), ReasonMissingToken)
+				} else {
+					SetClientErrorReason(r.Context(), ReasonBadToken)
+				}
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte(`{"error":"unauthorized","message":"missing or invalid token"}`))
 				return
@@ -39,8 +50,14 @@ func (am *AuthMiddleware) Require() func(http.Handler) http.Handler {
 			
 			// Validate token
 			claims, err := am.validator.ValidateToken(token)
+			timing.Record(r.Context(), "auth", time.Since(start))
 			if err != nil {
-				am.logger.Debug("Token validation failed: %v", err)
+				am.logger.WithContext(r.Context()).Debug("Token validation failed: %v", err)
+				if errors.Is(err, auth.ErrExpiredToken) {
+					SetClientErrorReason(r.Context(), ReasonExpiredToken)
+				} else {
+					SetClientErrorReason(r.Context(), ReasonBadToken)
+				}
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte(`{"error":"unauthorized","message":"invalid or expired token"}`))
 				return
@@ -49,7 +66,8 @@ func (am *AuthMiddleware) Require() func(http.Handler) http.Handler {
 			// Extract user email from claims
 			email, err := auth.GetUserEmail(claims)
 			if err != nil {
-				am.logger.Error("Failed to extract email from token: %v", err)
+				am.logger.WithContext(r.Context()).Error("Failed to extract email from token: %v", err)
+				SetClientErrorReason(r.Context(), ReasonInvalidClaims)
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte(`{"error":"unauthorized","message":"invalid token claims"}`))
 				return
@@ -93,3 +111,12 @@ func (am *AuthMiddleware) Optional() func(http.Handler) http.Handler {
 	}
 }
 
+
+// StripUserIdentity removes X-User-Email from external requests, so it is only ever the one set
+// by authentication
+func StripUserIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("X-User-Email")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+// Package middleware provides HTTP basic authentication for the documentation endpoints
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"nexus-common/logger"
+)
+
+// ParseBasicAuth parses DOCS_BASIC_AUTH entries of the form user:password
+func ParseBasicAuth(entries []string) (map[string]string, error) {
+	users := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		user, password, ok := strings.Cut(entry, ":")
+		user = strings.TrimSpace(user)
+		if !ok || user == "" || password == "" {
+			return nil, fmt.Errorf("entries must be user:password")
+		}
+		if _, dup := users[user]; dup {
+			return nil, fmt.Errorf("user %q is listed twice", user)
+		}
+		users[user] = password
+	}
+	return users, nil
+}
+
+// BasicAuth returns middleware that requires one of users' credentials, prompting browsers for them
+func BasicAuth(users map[string]string, realm string, log *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			expected, known := users[user]
+			// Compare digests so the comparison takes the same time whatever the lengths
+			given, want := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(expected))
+			if !ok || subtle.ConstantTimeCompare(given[:], want[:]) != 1 || !known {
+				if !ok {
+					SetClientErrorReason(r.Context(), ReasonMissingToken)
+				} else {
+					SetClientErrorReason(r.Context(), ReasonBadToken)
+				}
+				log.WithContext(r.Context()).Debug("Rejected %s %s: missing or invalid credentials", r.Method, r.URL.Path)
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"unauthorized","message":"missing or invalid credentials"}`))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/pkg/metrics"
+)
+
+// Client error reasons recorded in gateway_client_errors_total
+const (
+	ReasonMissingToken  = "missing_token"
+	ReasonBadToken      = "bad_token"
+	ReasonExpiredToken  = "expired_token"
+	ReasonInvalidClaims = "invalid_claims"
+	ReasonRateLimited   = "rate_limited"
+	ReasonValidation    = "validation"
+	ReasonForbidden     = "forbidden"
+	ReasonNotFound      = "not_found"
+	ReasonPolicy        = "policy"
+	ReasonOther         = "other"
+)
+
+// unmatchedRoute labels requests that match no configured route
+const unmatchedRoute = "unmatched"
+
+// reasonKey is the context key holding a request's client error reason
+type reasonKey struct{}
+
+// SetClientErrorReason records why the gateway is rejecting a request
+// It is a no-op if the ClientErrors middleware is not installed
+func SetClientErrorReason(ctx context.Context, reason string) {
+	if slot, ok := ctx.Value(reasonKey{}).(*string); ok {
+		*slot = reason
+	}
+}
+
+// ClientErrors returns middleware that counts 4xx responses by route and reason
+// Gateway-generated rejections set a specific reason; 4xx responses from backends
+// are classified by status code
+func ClientErrors(table routes.Table) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var reason string
+			wrapped := &responseWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), reasonKey{}, &reason)))
+
+			status := wrapped.statusCode
+			if status < http.StatusBadRequest || status >= http.StatusInternalServerError {
+				return
+			}
+
+			route := unmatchedRoute
+			if match := table.Match(r.URL.Path); match != nil {
+				route = match.Name
+			}
+			if reason == "" {
+				reason = reasonForStatus(status)
+			}
+			metrics.RecordClientError(route, status, reason)
+		})
+	}
+}
+
+// reasonForStatus classifies a 4xx response with no reason set by the gateway
+func reasonForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ReasonValidation
+	case http.StatusUnauthorized:
+		return ReasonBadToken
+	case http.StatusForbidden:
+		return ReasonForbidden
+	case http.StatusNotFound:
+		return ReasonNotFound
+	case http.StatusTooManyRequests:
+		return ReasonRateLimited
+	default:
+		return ReasonOther
+	}
+}
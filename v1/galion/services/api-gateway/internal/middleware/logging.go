@@ -2,10 +2,15 @@
 package middleware
 
 import (
+	"math/rand"
 	"net/http"
 	"time"
 
-	"nexus-api-gateway/pkg/logger"
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+	"nexus-common/requestid"
 )
 
 // responseWriter is a wrapper around http.ResponseWriter to capture status code
@@ -20,8 +25,14 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer to flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Logging middleware logs all HTTP requests with timing information
-func Logging(log *logger.Logger) func(http.Handler) http.Handler {
+// Routes with log sampling configured only log a fraction of requests; the rest are counted in metrics
+func Logging(log *logger.Logger, table routes.Table) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			start := time.Now()
@@ -35,9 +46,17 @@ func Logging(log *logger.Logger) func(http.Handler) http.Handler {
 			// Process request
 			next.ServeHTTP(wrapped, r)
 			
+			// Apply the route's log sampling
+			if route := table.Match(r.URL.Path); route != nil && route.LogSampling != nil {
+				if rate := route.LogSampling.Rate(wrapped.statusCode); rate < 1 && rand.Float64() >= rate {
+					metrics.RecordLogSampledOut(route.Name)
+					return
+				}
+			}
+			
 			// Log request details
 			duration := time.Since(start)
-			log.Info(
+			log.WithContext(r.Context()).Info(
 				"%s %s - %d - %s - %s",
 				r.Method,
 				r.RequestURI,
@@ -50,28 +69,24 @@ func Logging(log *logger.Logger) func(http.Handler) http.Handler {
 }
 
 // RequestID middleware adds a unique request ID to each request
+// The ID is stored in the request context, returned to the client and forwarded to backends
 func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Get request ID from header or generate new one
-		requestID := r.Header.Get("X-Request-ID")
-		if requestID == "" {
-			requestID = generateRequestID()
+		// Reuse a well-formed request ID from the caller or generate a new one
+		requestID := r.Header.Get(requestid.Header)
+		if !requestid.Valid(requestID) {
+			requestID = requestid.New()
 		}
 		
 		// Add request ID to response headers
-		w.Header().Set("X-Request-ID", requestID)
+		w.Header().Set(requestid.Header, requestID)
+		
+		// Forward to backends so their logs correlate with the gateway's
+		r.Header.Set(requestid.Header, requestID)
 		
 		// Add to request context for use in handlers
-		ctx := r.Context()
-		// You can use context.WithValue to store the requestID
+		ctx := requestid.NewContext(r.Context(), requestID)
 		
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
-
-// generateRequestID generates a unique request ID
-func generateRequestID() string {
-	// Simple implementation - in production use UUID or similar
-	return time.Now().Format("20060102150405")
-}
-
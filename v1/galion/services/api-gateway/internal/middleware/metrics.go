@@ -0,0 +1,40 @@
+// Package middleware provides per-route metrics
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/internal/tracing"
+	"nexus-api-gateway/pkg/metrics"
+)
+
+// Metrics returns middleware that records request metrics for a route
+// If the route declares an SLO, each request is also counted as good or bad against it
+func Metrics(route routes.Route) func(http.Handler) http.Handler {
+	if route.SLO != nil {
+		metrics.RegisterSLO(route.Name, route.SLO.Objective, route.SLO.Latency.Duration)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			wrapped := &responseWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			next.ServeHTTP(wrapped, r)
+
+			duration := time.Since(start)
+			metrics.RecordRequest(route.Name, r.URL.Path, r.Method, wrapped.statusCode, duration, tracing.TraceIDFromContext(r.Context()))
+
+			if route.SLO != nil {
+				good := wrapped.statusCode < http.StatusInternalServerError && duration <= route.SLO.Latency.Duration
+				metrics.RecordSLO(route.Name, good)
+			}
+		})
+	}
+}
@@ -12,14 +12,14 @@ import (
 
 // RateLimiter provides rate limiting using Redis
 type RateLimiter struct {
-	client       *redis.Client
+	client       redis.UniversalClient
 	limit        int           // requests per window
 	window       time.Duration // time window
 	enabled      bool
 }
 
 // NewRateLimiter creates a new rate limiter
-func NewRateLimiter(redisClient *redis.Client, requestsPerMinute int, enabled bool) *RateLimiter {
+func NewRateLimiter(redisClient redis.UniversalClient, requestsPerMinute int, enabled bool) *RateLimiter {
 	return &RateLimiter{
 		client:  redisClient,
 		limit:   requestsPerMinute,
@@ -41,7 +41,7 @@ func (rl *RateLimiter) Middleware() func(http.Handler) http.Handler {
 			// Use IP address as the rate limit key
 			// In production, you might want to use user ID for authenticated requests
 			clientIP := getClientIP(r)
-			key := fmt.Sprintf("ratelimit:%s", clientIP)
+			key := rateLimitKey(clientIP)
 			
 			ctx := context.Background()
 			
@@ -55,6 +55,7 @@ func (rl *RateLimiter) Middleware() func(http.Handler) http.Handler {
 			
 			// Check if limit exceeded
 			if count >= rl.limit {
+				SetClientErrorReason(r.Context(), ReasonRateLimited)
 				w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", rl.limit))
 				w.Header().Set("X-RateLimit-Remaining", "0")
 				w.WriteHeader(http.StatusTooManyRequests)
@@ -90,6 +91,31 @@ func (rl *RateLimiter) Middleware() func(http.Handler) http.Handler {
 	}
 }
 
+// Count returns the number of requests a client has made in the current window
+func (rl *RateLimiter) Count(ctx context.Context, client string) (int, error) {
+	count, err := rl.client.Get(ctx, rateLimitKey(client)).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read rate limit counter: %w", err)
+	}
+	return count, nil
+}
+
+// Reset clears a client's rate limit counter
+func (rl *RateLimiter) Reset(ctx context.Context, client string) error {
+	if err := rl.client.Del(ctx, rateLimitKey(client)).Err(); err != nil {
+		return fmt.Errorf("failed to reset rate limit counter: %w", err)
+	}
+	return nil
+}
+
+// rateLimitKey returns the Redis key holding a client's request count
+func rateLimitKey(client string) string {
+	return fmt.Sprintf("ratelimit:%s", client)
+}
+
 // getClientIP extracts the client IP address from the request
 func getClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header first (for requests behind proxy)
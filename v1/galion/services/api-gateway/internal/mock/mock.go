@@ -0,0 +1,120 @@
+// Package mock serves canned JSON fixtures in place of backend services for local development
+package mock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nexus-api-gateway/internal/routes"
+
+	"nexus-common/logger"
+)
+
+// Fixture is a canned response for requests matching Method and Path
+// Path matches exactly, or as a prefix when it ends in "*"; an empty Method matches any method
+type Fixture struct {
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path"`
+	Status  int               `json:"status,omitempty"` // defaults to 200
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+	Delay   routes.Duration   `json:"delay,omitempty"` // simulated backend latency
+}
+
+// matches reports whether the fixture applies to r
+func (f Fixture) matches(r *http.Request) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, r.Method) {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(f.Path, "*"); ok {
+		return strings.HasPrefix(r.URL.Path, prefix)
+	}
+	return r.URL.Path == f.Path
+}
+
+// Backends serves fixtures for every route
+type Backends struct {
+	fixtures map[string][]Fixture
+	logger   *logger.Logger
+}
+
+// Load reads <dir>/<route name>.json for each route, each holding a JSON array of fixtures
+// Routes without a fixture file answer every request with 404
+func Load(dir string, table routes.Table, log *logger.Logger) (*Backends, error) {
+	b := &Backends{
+		fixtures: make(map[string][]Fixture),
+		logger:   log,
+	}
+
+	for _, route := range table {
+		path := filepath.Join(dir, route.Name+".json")
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			log.Warn("No mock fixtures for route %s (%s not found)", route.Name, path)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mock fixtures: %w", err)
+		}
+
+		var fixtures []Fixture
+		if err := json.Unmarshal(data, &fixtures); err != nil {
+			return nil, fmt.Errorf("failed to parse mock fixtures %s: %w", path, err)
+		}
+		for i, f := range fixtures {
+			if f.Path == "" {
+				return nil, fmt.Errorf("mock fixture %d in %s: path is required", i, path)
+			}
+		}
+		b.fixtures[route.Name] = fixtures
+		log.Info("Loaded %d mock fixtures for route %s", len(fixtures), route.Name)
+	}
+
+	return b, nil
+}
+
+// Handler returns a handler answering a route's requests from its first matching fixture
+func (b *Backends) Handler(route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, f := range b.fixtures[route] {
+			if !f.matches(r) {
+				continue
+			}
+
+			if f.Delay.Duration > 0 {
+				select {
+				case <-time.After(f.Delay.Duration):
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			for name, value := range f.Headers {
+				w.Header().Set(name, value)
+			}
+			if w.Header().Get("Content-Type") == "" {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			status := f.Status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			w.WriteHeader(status)
+			w.Write(f.Body)
+			return
+		}
+
+		b.logger.WithContext(r.Context()).Warn("No mock fixture for %s %s on route %s", r.Method, r.URL.Path, route)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "not found",
+			"message": fmt.Sprintf("no mock fixture for %s %s", r.Method, r.URL.Path),
+		})
+	}
+}
@@ -0,0 +1,277 @@
+// Package notifications relays messages that backends publish to Redis to users over Server-Sent Events
+// Each replica holds one Redis subscription, adding a user's channel while that user has a stream
+// open on it, and fans messages out to the user's streams; backends never hold client sockets
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-common/logger"
+)
+
+// Path is where the stream is served
+const Path = "/api/v1/notifications/stream"
+
+// Redis channels backends publish to
+const (
+	UserChannelPrefix = "gateway:notifications:user:" // followed by the user's email
+	BroadcastChannel  = "gateway:notifications:broadcast"
+)
+
+// streamBuffer is how many messages may wait for a slow client before its stream is closed
+const streamBuffer = 64
+
+// writeTimeout bounds each write to a client
+const writeTimeout = 30 * time.Second
+
+// Config configures the relay
+type Config struct {
+	Heartbeat  time.Duration // interval of keep-alive comments on idle streams
+	MaxPerUser int           // streams a user may hold open on each replica
+}
+
+// Message is a notification as published by a backend
+// A payload that isn't a JSON object with a data field is relayed whole as the data of a "message" event
+type Message struct {
+	ID    string          `json:"id,omitempty"`
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// Relay subscribes to notification channels and serves user streams
+type Relay struct {
+	config Config
+	pubsub *redis.PubSub
+	logger *logger.Logger
+
+	mu      sync.Mutex
+	streams map[string]map[*stream]struct{} // by user
+	closed  bool
+}
+
+// stream is one open SSE connection
+type stream struct {
+	messages chan []byte
+	done     chan struct{} // closed when the relay drops the stream
+	once     sync.Once
+}
+
+func (s *stream) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// New subscribes to the broadcast channel and relays messages until ctx is cancelled
+func New(ctx context.Context, config Config, client redis.UniversalClient, log *logger.Logger) *Relay {
+	r := &Relay{
+		config:  config,
+		pubsub:  client.Subscribe(ctx, BroadcastChannel),
+		logger:  log,
+		streams: make(map[string]map[*stream]struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+func (r *Relay) run(ctx context.Context) {
+	messages := r.pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			r.pubsub.Close()
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			event := format(msg.Payload)
+			if msg.Channel == BroadcastChannel {
+				r.deliver("", event)
+			} else if user, ok := strings.CutPrefix(msg.Channel, UserChannelPrefix); ok {
+				r.deliver(user, event)
+			}
+		}
+	}
+}
+
+// deliver queues an event on a user's streams, or on every stream if user is empty
+// A stream whose buffer is full is closed rather than blocking the others; its client reconnects
+func (r *Relay) deliver(user string, event []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for u, streams := range r.streams {
+		if user != "" && u != user {
+			continue
+		}
+		for s := range streams {
+			select {
+			case s.messages <- event:
+			default:
+				r.logger.Warn("Closing slow notification stream of %s", u)
+				s.close()
+			}
+		}
+	}
+}
+
+// CloseStreams ends every open stream, so shutdown isn't held up by them; clients reconnect elsewhere
+func (r *Relay) CloseStreams() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, streams := range r.streams {
+		for s := range streams {
+			s.close()
+		}
+	}
+}
+
+// open registers a stream for user, subscribing to the user's channel if it is the first
+func (r *Relay) open(ctx context.Context, user string) (*stream, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, errShuttingDown
+	}
+	streams := r.streams[user]
+	if len(streams) >= r.config.MaxPerUser {
+		return nil, errTooManyStreams
+	}
+	if len(streams) == 0 {
+		if err := r.pubsub.Subscribe(ctx, UserChannelPrefix+user); err != nil {
+			return nil, err
+		}
+		streams = make(map[*stream]struct{})
+		r.streams[user] = streams
+	}
+	s := &stream{messages: make(chan []byte, streamBuffer), done: make(chan struct{})}
+	streams[s] = struct{}{}
+	return s, nil
+}
+
+// release unregisters a stream, unsubscribing from the user's channel if it was the last
+func (r *Relay) release(user string, s *stream) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.streams[user], s)
+	if len(r.streams[user]) == 0 {
+		delete(r.streams, user)
+		if err := r.pubsub.Unsubscribe(context.Background(), UserChannelPrefix+user); err != nil {
+			r.logger.Warn("Failed to unsubscribe from notifications of %s: %v", user, err)
+		}
+	}
+}
+
+// format renders a published payload as an SSE event
+func format(payload string) []byte {
+	msg := Message{Event: "message", Data: json.RawMessage(payload)}
+	var published Message
+	if json.Unmarshal([]byte(payload), &published) == nil && len(published.Data) > 0 {
+		msg.ID = published.ID
+		if published.Event != "" {
+			msg.Event = published.Event
+		}
+		var compact bytes.Buffer
+		if json.Compact(&compact, published.Data) == nil {
+			msg.Data = compact.Bytes()
+		}
+	}
+
+	var b bytes.Buffer
+	if msg.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", singleLine(msg.ID))
+	}
+	fmt.Fprintf(&b, "event: %s\n", singleLine(msg.Event))
+	for _, line := range strings.Split(string(msg.Data), "\n") {
+		fmt.Fprintf(&b, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", " ").Replace(s)
+}
+
+// ServeHTTP streams the authenticated user's notifications and broadcasts
+// It must be behind auth middleware, which sets X-User-Email
+func (r *Relay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	user := req.Header.Get("X-User-Email")
+	log := r.logger.WithContext(req.Context())
+
+	s, err := r.open(req.Context(), user)
+	switch err {
+	case nil:
+	case errTooManyStreams:
+		writeError(w, http.StatusTooManyRequests, "too many open notification streams")
+		return
+	case errShuttingDown:
+		writeError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	default:
+		log.Error("Failed to subscribe to notifications of %s: %v", user, err)
+		writeError(w, http.StatusServiceUnavailable, "notifications unavailable")
+		return
+	}
+	defer r.release(user, s)
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // stop nginx-style proxies buffering the stream
+	w.WriteHeader(http.StatusOK)
+	if err := r.write(rc, w, []byte("retry: 5000\n: connected\n\n")); err != nil {
+		log.Warn("Notification streaming unsupported: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(r.config.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var event []byte
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.done:
+			return
+		case event = <-s.messages:
+		case <-heartbeat.C:
+			event = []byte(": ping\n\n")
+		}
+		if err := r.write(rc, w, event); err != nil {
+			log.Debug("Notification stream of %s ended: %v", user, err)
+			return
+		}
+	}
+}
+
+// write sends and flushes data, with a write deadline replacing the server's overall write timeout
+func (r *Relay) write(rc *http.ResponseController, w http.ResponseWriter, data []byte) error {
+	if err := rc.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
+
+var (
+	errTooManyStreams = errors.New("too many streams")
+	errShuttingDown   = errors.New("shutting down")
+)
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"error":%q,"message":%q}`, strings.ToLower(http.StatusText(status)), message)
+}
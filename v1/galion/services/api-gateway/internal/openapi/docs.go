@@ -0,0 +1,22 @@
+package openapi
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+// DocsHandler serves Swagger UI at /docs, loading its assets from the configured URL and the spec from /openapi.json
+func (a *Aggregator) DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	docsTemplate.Execute(w, map[string]string{
+		"AssetsURL": strings.TrimSuffix(a.config.SwaggerUIURL, "/"),
+		"SpecURL":   "/openapi.json",
+	})
+}
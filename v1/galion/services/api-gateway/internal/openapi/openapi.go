@@ -0,0 +1,307 @@
+// Package openapi serves one OpenAPI document combining the backends' specs, and Swagger UI over it
+// Each route's backend spec is fetched through the service proxy, limited to the paths under the
+// route's prefix and merged; the combined document is rebuilt at most once per refresh interval
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"nexus-api-gateway/internal/proxy"
+	"nexus-api-gateway/internal/routes"
+
+	"nexus-common/logger"
+	"nexus-common/version"
+)
+
+// DefaultSpecPath is where backends serve their OpenAPI document unless a route sets openapi_path
+const DefaultSpecPath = "/openapi.json"
+
+// maxSpecBytes caps the size of a backend's document
+const maxSpecBytes = 10 << 20
+
+// Config configures the aggregated documentation
+type Config struct {
+	RefreshInterval time.Duration // how long the combined document is reused
+	SwaggerUIURL    string        // base URL of the swagger-ui-dist assets
+}
+
+// Aggregator builds and caches the combined OpenAPI document
+type Aggregator struct {
+	config Config
+	routes routes.Table
+	fetch  proxy.FetchFunc
+	logger *logger.Logger
+
+	mu       sync.Mutex
+	document []byte
+	built    time.Time
+	specs    map[string]map[string]interface{} // last good spec per route
+}
+
+// New returns an aggregator fetching the specs of table's routes with fetch
+func New(config Config, table routes.Table, fetch proxy.FetchFunc, log *logger.Logger) *Aggregator {
+	return &Aggregator{
+		config: config,
+		routes: table,
+		fetch:  fetch,
+		logger: log,
+		specs:  make(map[string]map[string]interface{}),
+	}
+}
+
+// SpecHandler serves the combined document at /openapi.json
+func (a *Aggregator) SpecHandler(w http.ResponseWriter, r *http.Request) {
+	document := a.Document(r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(document)
+}
+
+// Document returns the combined document, rebuilding it if it is older than the refresh interval
+// Backends that fail keep their last good spec; concurrent callers share one rebuild
+func (a *Aggregator) Document(r *http.Request) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.document != nil && time.Since(a.built) < a.config.RefreshInterval {
+		return a.document
+	}
+
+	type result struct {
+		spec map[string]interface{}
+		err  error
+	}
+	results := make([]result, len(a.routes))
+	var wg sync.WaitGroup
+	for i, route := range a.routes {
+		if !route.Documented() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, route routes.Route) {
+			defer wg.Done()
+			results[i].spec, results[i].err = a.fetchSpec(r, route)
+		}(i, route)
+	}
+	wg.Wait()
+
+	sources := make([]source, 0, len(a.routes))
+	for i, route := range a.routes {
+		if !route.Documented() {
+			continue
+		}
+		src := source{route: route, spec: results[i].spec}
+		if results[i].err != nil {
+			a.logger.WithContext(r.Context()).Warn("Failed to fetch OpenAPI document for route %s: %v", route.Name, results[i].err)
+			src.spec = a.specs[route.Name]
+			src.err = results[i].err
+		} else {
+			a.specs[route.Name] = results[i].spec
+		}
+		sources = append(sources, src)
+	}
+
+	document, err := json.Marshal(merge(sources))
+	if err != nil {
+		a.logger.Error("Failed to encode OpenAPI document: %v", err)
+		return a.document
+	}
+	a.document = document
+	a.built = time.Now()
+	return document
+}
+
+func (a *Aggregator) fetchSpec(r *http.Request, route routes.Route) (map[string]interface{}, error) {
+	path := route.OpenAPIPath
+	if path == "" {
+		path = DefaultSpecPath
+	}
+	resp, err := a.fetch(r, route.Name, path, http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", path, resp.StatusCode)
+	}
+
+	var spec map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSpecBytes)).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON document: %w", path, err)
+	}
+	if _, ok := spec["paths"].(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%s has no paths", path)
+	}
+	return spec, nil
+}
+
+// source is one route's spec going into the merge; err is set if the latest fetch failed
+type source struct {
+	route routes.Route
+	spec  map[string]interface{}
+	err   error
+}
+
+// merge combines the routes' specs into one document
+// Only paths under a route's prefix are kept, since the gateway forwards nothing else to it.
+// Components whose names clash with a different definition are renamed <route>_<name>,
+// operations are tagged with their route if untagged, and operations on routes that
+// require auth get the gateway's bearer security scheme
+func merge(sources []source) map[string]interface{} {
+	paths := make(map[string]interface{})
+	components := map[string]interface{}{
+		"securitySchemes": map[string]interface{}{
+			"gatewayJWT": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		},
+	}
+	openapiVersion := "3.0.3"
+	var tags []interface{}
+
+	for _, src := range sources {
+		tag := map[string]interface{}{"name": src.route.Name}
+		if src.err != nil {
+			if src.spec == nil {
+				// The error names internal addresses, so it is only logged
+				tag["description"] = "Documentation unavailable: the backend's spec could not be fetched"
+				tags = append(tags, tag)
+				continue
+			}
+			tag["description"] = "Documentation may be out of date: the backend's spec could not be refreshed"
+		} else if info, ok := src.spec["info"].(map[string]interface{}); ok {
+			if title, ok := info["title"].(string); ok {
+				tag["description"] = title
+			}
+		}
+		tags = append(tags, tag)
+
+		if v, ok := src.spec["openapi"].(string); ok && v > openapiVersion {
+			openapiVersion = v
+		}
+
+		// Rename clashing components, then rewrite references to them throughout this spec
+		renames := make(map[string]string)
+		specComponents, _ := src.spec["components"].(map[string]interface{})
+		for _, section := range sortedKeys(specComponents) {
+			entries, ok := specComponents[section].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			merged, _ := components[section].(map[string]interface{})
+			for _, name := range sortedKeys(entries) {
+				existing, clash := merged[name]
+				if clash && !jsonEqual(existing, entries[name]) {
+					renames["#/components/"+section+"/"+name] = "#/components/" + section + "/" + src.route.Name + "_" + name
+				}
+			}
+		}
+		spec := rewriteRefs(src.spec, renames).(map[string]interface{})
+		specComponents, _ = spec["components"].(map[string]interface{})
+		for section, value := range specComponents {
+			entries, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			merged, ok := components[section].(map[string]interface{})
+			if !ok {
+				merged = make(map[string]interface{})
+				components[section] = merged
+			}
+			for name, entry := range entries {
+				if renamed, ok := renames["#/components/"+section+"/"+name]; ok {
+					name = strings.TrimPrefix(renamed, "#/components/"+section+"/")
+				}
+				merged[name] = entry
+			}
+		}
+
+		specPaths, _ := spec["paths"].(map[string]interface{})
+		for path, item := range specPaths {
+			if !strings.HasPrefix(path, src.route.PathPrefix) {
+				continue
+			}
+			operations, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for method, op := range operations {
+				operation, ok := op.(map[string]interface{})
+				if !ok || !isMethod(method) {
+					continue
+				}
+				if _, ok := operation["tags"]; !ok {
+					operation["tags"] = []interface{}{src.route.Name}
+				}
+				if _, ok := operation["security"]; !ok && src.route.RequireAuth {
+					operation["security"] = []interface{}{map[string]interface{}{"gatewayJWT": []interface{}{}}}
+				}
+			}
+			paths[path] = operations
+		}
+	}
+
+	return map[string]interface{}{
+		"openapi": openapiVersion,
+		"info": map[string]interface{}{
+			"title":       "Nexus API",
+			"version":     version.Get().Version,
+			"description": "Combined API of the services behind the Nexus API gateway",
+		},
+		"servers":    []interface{}{map[string]interface{}{"url": "/"}},
+		"tags":       tags,
+		"paths":      paths,
+		"components": components,
+	}
+}
+
+// rewriteRefs returns a copy of value with $ref strings replaced per renames
+func rewriteRefs(value interface{}, renames map[string]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if ref, ok := item.(string); ok && key == "$ref" {
+				if renamed, ok := renames[ref]; ok {
+					item = renamed
+				}
+			}
+			out[key] = rewriteRefs(item, renames)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = rewriteRefs(item, renames)
+		}
+		return out
+	}
+	return value
+}
+
+func isMethod(name string) bool {
+	switch name {
+	case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+		return true
+	}
+	return false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
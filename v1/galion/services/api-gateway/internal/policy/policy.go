@@ -0,0 +1,159 @@
+// Package policy evaluates per-route request policies written as expr expressions
+// (https://expr-lang.org), e.g. `request.header["X-Client"] == "legacy" && time.Hour < 6`
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"nexus-api-gateway/internal/middleware"
+	"nexus-api-gateway/internal/routes"
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Env is what policy expressions can see
+type Env struct {
+	Request Request `expr:"request"`
+	Time    Clock   `expr:"time"`
+}
+
+// Request describes the incoming request
+// Header and query maps hold the first value of each (canonical header) name
+type Request struct {
+	Method     string            `expr:"method"`
+	Path       string            `expr:"path"`
+	Host       string            `expr:"host"`
+	Header     map[string]string `expr:"header"`
+	Query      map[string]string `expr:"query"`
+	RemoteAddr string            `expr:"remote_addr"`
+}
+
+// Clock is the current UTC time
+type Clock struct {
+	Hour    int
+	Minute  int
+	Weekday string // "Monday", "Tuesday", ...
+}
+
+type contextKey struct{}
+
+// compiled is a policy with its compiled expression
+type compiled struct {
+	routes.Policy
+	program *vm.Program
+}
+
+// Set holds a route's compiled policies
+type Set struct {
+	route    string
+	policies []compiled
+	logger   *logger.Logger
+}
+
+// Compile compiles a route's policies, failing on syntax or type errors
+func Compile(route routes.Route, log *logger.Logger) (*Set, error) {
+	set := &Set{route: route.Name, logger: log}
+	for _, p := range route.Policies {
+		program, err := expr.Compile(p.When, expr.Env(Env{}), expr.AsBool())
+		if err != nil {
+			return nil, fmt.Errorf("route %s policy %s: %w", route.Name, p.Name, err)
+		}
+		set.policies = append(set.policies, compiled{Policy: p, program: program})
+	}
+	return set, nil
+}
+
+// Middleware returns middleware applying the policies in order
+// set_header policies all apply, the first matching route policy picks the backend,
+// and a matching block policy rejects the request immediately
+func (s *Set) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(s.policies) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			env := newEnv(r, time.Now().UTC())
+			target := ""
+
+			for _, p := range s.policies {
+				matched, err := expr.Run(p.program, env)
+				if err != nil {
+					// Fail open: a broken policy must not take the route down
+					s.logger.WithContext(r.Context()).Error("Policy %s on route %s failed: %v", p.Name, s.route, err)
+					continue
+				}
+				if matched != true {
+					continue
+				}
+				metrics.RecordPolicyMatch(s.route, p.Name, p.Action)
+
+				switch p.Action {
+				case routes.PolicyBlock:
+					status := p.Status
+					if status == 0 {
+						status = http.StatusForbidden
+					}
+					middleware.SetClientErrorReason(r.Context(), middleware.ReasonPolicy)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(status)
+					w.Write([]byte(`{"error":"blocked","message":"request blocked by policy"}`))
+					return
+				case routes.PolicySetHeader:
+					r.Header.Set(p.Header, p.Value)
+				case routes.PolicyRoute:
+					if target == "" {
+						target = p.Route
+					}
+				}
+			}
+
+			if target != "" {
+				r = r.WithContext(context.WithValue(r.Context(), contextKey{}, target))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TargetRoute returns the route whose backend a policy chose for the request, or ""
+func TargetRoute(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// newEnv builds the expression environment for a request
+func newEnv(r *http.Request, now time.Time) Env {
+	header := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		if len(values) > 0 {
+			header[name] = values[0]
+		}
+	}
+	query := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		query[name] = values[0]
+	}
+
+	return Env{
+		Request: Request{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Host:       r.Host,
+			Header:     header,
+			Query:      query,
+			RemoteAddr: r.RemoteAddr,
+		},
+		Time: Clock{
+			Hour:    now.Hour(),
+			Minute:  now.Minute(),
+			Weekday: now.Weekday().String(),
+		},
+	}
+}
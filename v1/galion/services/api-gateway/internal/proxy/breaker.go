@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single trial request through after the open timeout
+	CircuitHalfOpen
+	// CircuitOpen rejects requests until the open timeout elapses
+	CircuitOpen
+)
+
+// String returns the state name
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half_open"
+	case CircuitOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreaker opens after a number of consecutive failures and
+// probes the backend with a single request once the open timeout has passed
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	state       CircuitState
+	failures    int
+	threshold   int
+	openTimeout time.Duration
+	openedAt    time.Time
+	trialActive bool
+	onChange    func(CircuitState)
+}
+
+// NewCircuitBreaker creates a circuit breaker
+// A threshold of 0 disables the breaker (it never opens)
+func NewCircuitBreaker(threshold int, openTimeout time.Duration, onChange func(CircuitState)) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		onChange:    onChange,
+	}
+}
+
+// Allow reports whether a request may be sent to the backend
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < cb.openTimeout {
+			return false
+		}
+		cb.setState(CircuitHalfOpen)
+		cb.trialActive = true
+		return true
+	case CircuitHalfOpen:
+		if cb.trialActive {
+			return false
+		}
+		cb.trialActive = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Success records a successful request
+func (cb *CircuitBreaker) Success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures = 0
+	cb.trialActive = false
+	if cb.state != CircuitClosed {
+		cb.setState(CircuitClosed)
+	}
+}
+
+// Failure records a failed request
+func (cb *CircuitBreaker) Failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failures++
+	cb.trialActive = false
+	if cb.threshold <= 0 {
+		return
+	}
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.openedAt = time.Now()
+		cb.setState(CircuitOpen)
+	}
+}
+
+// State returns the current state
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// setState changes state and notifies the listener; callers must hold cb.mu
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	if cb.onChange != nil {
+		cb.onChange(state)
+	}
+}
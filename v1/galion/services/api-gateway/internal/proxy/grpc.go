@@ -0,0 +1,343 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"nexus-api-gateway/internal/reporting"
+
+	"nexus-common/requestid"
+)
+
+// GRPCHealthPath is the standard gRPC health check, used to check gRPC upstreams
+const GRPCHealthPath = "/grpc.health.v1.Health/Check"
+
+// gRPC-Web media types; the -text variant carries base64 bodies for clients that can't read binary streams
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+)
+
+// maxGRPCWebRequestBytes caps request bodies; gRPC-Web has no client streaming, so a request is one message
+const maxGRPCWebRequestBytes = 4 << 20
+
+// grpcWebWriteTimeout bounds each write of a response, replacing the server's overall write timeout
+// so server streams can outlive it
+const grpcWebWriteTimeout = 30 * time.Second
+
+// gRPC status codes the gateway answers with itself
+const (
+	grpcUnknown          = 2
+	grpcInvalidArgument  = 3
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// grpcServing is a health check response reporting SERVING, in gRPC framing
+var grpcServing = []byte{0, 0, 0, 0, 2, 0x08, 0x01}
+
+// grpcTransport speaks HTTP/2 to gRPC upstreams: cleartext (h2c) to http:// targets, TLS to https:// ones
+type grpcTransport struct {
+	h2c *http2.Transport
+	tls *http2.Transport
+}
+
+func newGRPCTransport() *grpcTransport {
+	var dialer net.Dialer
+	return &grpcTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			DisableCompression: true,
+			ReadIdleTimeout:    30 * time.Second,
+		},
+		tls: &http2.Transport{
+			DisableCompression: true,
+			ReadIdleTimeout:    30 * time.Second,
+		},
+	}
+}
+
+// RoundTrip sends req over the transport matching its scheme
+func (t *grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.tls.RoundTrip(req)
+}
+
+// proxyGRPCWeb translates a gRPC-Web request into a gRPC call on upstream and the call's response,
+// trailers included, back into gRPC-Web
+// Request headers, among them Authorization and the X-User-Email set by auth, become call metadata
+func (sp *ServiceProxy) proxyGRPCWeb(w http.ResponseWriter, r *http.Request, upstream *Upstream) {
+	log := sp.logger.WithContext(r.Context())
+
+	contentType, text, ok := grpcWebMediaType(r.Header.Get("Content-Type"))
+	if r.Method != http.MethodPost || !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte(`{"error":"unsupported media type","message":"this route only accepts gRPC-Web requests"}`))
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxGRPCWebRequestBytes))
+	if err == nil && text {
+		body, err = decodeGRPCWebText(body)
+	}
+	if err != nil {
+		writeGRPCStatus(w, contentType, grpcInvalidArgument, "malformed gRPC-Web request body")
+		return
+	}
+
+	target := upstream.Target()
+	if target == "" {
+		log.Warn("No discovered targets for %s, rejecting %s", upstream.Name, r.URL.Path)
+		writeGRPCStatus(w, contentType, grpcUnavailable, "service unavailable")
+		return
+	}
+	if !upstream.breaker.Allow() {
+		log.Debug("Circuit open for %s, rejecting %s", upstream.Name, r.URL.Path)
+		writeGRPCStatus(w, contentType, grpcUnavailable, "service unavailable")
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, target+r.URL.Path, bytes.NewReader(body))
+	if err != nil {
+		log.Error("Failed to create gRPC request: %v", err)
+		writeGRPCStatus(w, contentType, grpcInternal, "internal error")
+		return
+	}
+	copyHeaders(r.Header, req.Header)
+	// The browser's framing and encoding headers concern the gRPC-Web hop only
+	for _, name := range []string{"Content-Length", "Accept", "Accept-Encoding", "X-Grpc-Web"} {
+		req.Header.Del(name)
+	}
+	req.Header.Set("Content-Type", "application/grpc"+strings.TrimPrefix(strings.TrimPrefix(contentType, grpcWebTextContentType), grpcWebContentType))
+	req.Header.Set("Te", "trailers")
+	requestid.Inject(r.Context(), req.Header)
+
+	resp, err := sp.send(r, upstream, req)
+	if err != nil {
+		log.Error("gRPC request to %s failed: %v", upstream.Name, err)
+		reporting.CaptureError(r.Context(), fmt.Errorf("grpc request to %s%s: %w", target, r.URL.Path, err))
+		writeGRPCStatus(w, contentType, grpcUnavailable, "service unavailable")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		writeGRPCStatus(w, contentType, grpcCodeForHTTPStatus(resp.StatusCode), fmt.Sprintf("upstream answered HTTP %d", resp.StatusCode))
+		return
+	}
+
+	for name, values := range resp.Header {
+		if isHopByHopHeader(name) || name == "Content-Type" || name == "Content-Length" || name == "Trailer" {
+			continue
+		}
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	out := &grpcWebWriter{w: w, rc: http.NewResponseController(w), text: text}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		// The call's own status is lost with its trailers; tell the client it broke off
+		log.Warn("gRPC response from %s ended early: %v", upstream.Name, err)
+		if !errors.Is(err, errClientGone) {
+			out.Write(grpcWebTrailerFrame(http.Header{
+				"Grpc-Status":  {strconv.Itoa(grpcUnavailable)},
+				"Grpc-Message": {"upstream stream interrupted"},
+			}))
+			out.Close()
+		}
+		return
+	}
+	// A trailers-only response already carried its status in the headers
+	if len(resp.Trailer) > 0 {
+		out.Write(grpcWebTrailerFrame(resp.Trailer))
+	}
+	out.Close()
+}
+
+// grpcWebMediaType returns the request's gRPC-Web media type without parameters, and whether it is
+// the base64 text variant; ok is false for anything else
+func grpcWebMediaType(header string) (mediaType string, text, ok bool) {
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", false, false
+	}
+	for _, base := range []string{grpcWebTextContentType, grpcWebContentType} {
+		if mediaType == base || strings.HasPrefix(mediaType, base+"+") {
+			return mediaType, base == grpcWebTextContentType, true
+		}
+	}
+	return "", false, false
+}
+
+// decodeGRPCWebText decodes a text request body, which may be several separately padded base64 chunks
+func decodeGRPCWebText(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if len(data)%4 != 0 {
+		return nil, base64.CorruptInputError(len(data))
+	}
+	out := make([]byte, 0, len(data)/4*3)
+	var quantum [3]byte
+	for i := 0; i < len(data); i += 4 {
+		n, err := base64.StdEncoding.Decode(quantum[:], data[i:i+4])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, quantum[:n]...)
+	}
+	return out, nil
+}
+
+// grpcWebTrailerFrame encodes trailers as the final frame of a gRPC-Web response body
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var block bytes.Buffer
+	for _, name := range names {
+		for _, value := range trailer[name] {
+			block.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = 0x80
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	return append(frame, block.Bytes()...)
+}
+
+// writeGRPCStatus answers with a trailers-only response carrying a gRPC status
+func writeGRPCStatus(w http.ResponseWriter, contentType string, code int, message string) {
+	if contentType == "" {
+		contentType = grpcWebContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcCodeForHTTPStatus maps an upstream's non-200 HTTP status to a gRPC code, as gRPC clients do
+func grpcCodeForHTTPStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	default:
+		return grpcUnknown
+	}
+}
+
+// errClientGone reports a failed write to the gRPC-Web client
+var errClientGone = errors.New("client write failed")
+
+// grpcWebWriter writes a response body to the client as it arrives, base64-encoding it for text clients
+// Text is encoded in multiples of 3 bytes so the stream has no padding until Close
+type grpcWebWriter struct {
+	w       http.ResponseWriter
+	rc      *http.ResponseController
+	text    bool
+	pending []byte // text bytes not yet encoded
+}
+
+// Write sends p and flushes it
+func (gw *grpcWebWriter) Write(p []byte) (int, error) {
+	data := p
+	if gw.text {
+		gw.pending = append(gw.pending, p...)
+		n := len(gw.pending) / 3 * 3
+		data = []byte(base64.StdEncoding.EncodeToString(gw.pending[:n]))
+		gw.pending = append(gw.pending[:0], gw.pending[n:]...)
+	}
+	if err := gw.send(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends the last, padded text bytes
+func (gw *grpcWebWriter) Close() error {
+	if !gw.text || len(gw.pending) == 0 {
+		return nil
+	}
+	data := []byte(base64.StdEncoding.EncodeToString(gw.pending))
+	gw.pending = nil
+	return gw.send(data)
+}
+
+func (gw *grpcWebWriter) send(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	gw.rc.SetWriteDeadline(time.Now().Add(grpcWebWriteTimeout))
+	if _, err := gw.w.Write(data); err != nil {
+		return fmt.Errorf("%w: %v", errClientGone, err)
+	}
+	gw.rc.Flush()
+	return nil
+}
+
+// probeGRPC checks a gRPC upstream with the standard health service; an empty request asks about the
+// server as a whole, which is healthy if it answers SERVING
+func (reg *Registry) probeGRPC(ctx context.Context, u *Upstream, target string, start time.Time) ProbeResult {
+	probeURL := target + u.HealthPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, probeURL, bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return ProbeResult{URL: probeURL, Err: err}
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	resp, err := reg.grpcClient.Do(req)
+	if err != nil {
+		return ProbeResult{URL: probeURL, Latency: time.Since(start), Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	result := ProbeResult{URL: probeURL, StatusCode: resp.StatusCode, Latency: time.Since(start), Err: err}
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return result
+	}
+	status, message := resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = resp.Header.Get("Grpc-Status"), resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		result.Err = fmt.Errorf("grpc-status %s: %s", status, message)
+		return result
+	}
+	result.Healthy = bytes.Equal(body, grpcServing)
+	return result
+}
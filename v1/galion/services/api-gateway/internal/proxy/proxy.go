@@ -2,18 +2,38 @@
 package proxy
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
-	"nexus-api-gateway/pkg/logger"
+	"nexus-api-gateway/internal/reporting"
+	"nexus-api-gateway/internal/timing"
+	"nexus-api-gateway/internal/tracing"
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+	"nexus-common/requestid"
 )
 
+var (
+	// ErrNoTargets is returned by Fetch when service discovery found no instances
+	ErrNoTargets = errors.New("no discovered targets")
+	
+	// ErrCircuitOpen is returned by Fetch while the upstream's circuit is open
+	ErrCircuitOpen = errors.New("circuit open")
+)
+
+// FetchFunc performs a GET for path on a route's backend; the caller closes the response body
+type FetchFunc func(r *http.Request, route, path string, header http.Header) (*http.Response, error)
+
 // ServiceProxy handles proxying requests to backend services
 type ServiceProxy struct {
-	client *http.Client
-	logger *logger.Logger
+	client     *http.Client
+	grpcClient *http.Client // no overall timeout, so server streams can run; calls end with the client's request
+	logger     *logger.Logger
 }
 
 // NewServiceProxy creates a new service proxy
@@ -22,26 +42,44 @@ func NewServiceProxy(log *logger.Logger) *ServiceProxy {
 		client: &http.Client{
 			Timeout: 30 * time.Second, // 30 second timeout
 		},
-		logger: log,
+		grpcClient: &http.Client{Transport: newGRPCTransport()},
+		logger:     log,
 	}
 }
 
 // ProxyRequest forwards a request to a backend service
-func (sp *ServiceProxy) ProxyRequest(w http.ResponseWriter, r *http.Request, targetURL string) {
+func (sp *ServiceProxy) ProxyRequest(w http.ResponseWriter, r *http.Request, upstream *Upstream) {
+	// gRPC upstreams get their requests translated from gRPC-Web
+	if upstream.GRPC {
+		sp.proxyGRPCWeb(w, r, upstream)
+		return
+	}
+	
+	log := sp.logger.WithContext(r.Context())
+	
+	// Pick a target (the configured URL, or the next discovered instance)
+	target := upstream.Target()
+	if target == "" {
+		log.Warn("No discovered targets for %s, rejecting %s %s", upstream.Name, r.Method, r.URL.Path)
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	
 	// Build the target URL
 	// Remove the route prefix and append the rest of the path
 	targetPath := r.URL.Path
-	fullURL := targetURL + targetPath
+	fullURL := target + targetPath
 	if r.URL.RawQuery != "" {
 		fullURL += "?" + r.URL.RawQuery
 	}
 	
-	sp.logger.Debug("Proxying %s %s to %s", r.Method, r.URL.Path, fullURL)
+	log.Debug("Proxying %s %s to %s", r.Method, r.URL.Path, fullURL)
 	
 	// Create new request
 	proxyReq, err := http.NewRequest(r.Method, fullURL, r.Body)
 	if err != nil {
-		sp.logger.Error("Failed to create proxy request: %v", err)
+		log.Error("Failed to create proxy request: %v", err)
+		reporting.CaptureError(r.Context(), fmt.Errorf("create proxy request for %s: %w", fullURL, err))
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
@@ -49,10 +87,21 @@ func (sp *ServiceProxy) ProxyRequest(w http.ResponseWriter, r *http.Request, tar
 	// Copy headers from original request
 	copyHeaders(r.Header, proxyReq.Header)
 	
+	// Forward the request ID from context, even if a plugin or policy rewrote the header
+	requestid.Inject(r.Context(), proxyReq.Header)
+	
+	// Fail fast while the upstream's circuit is open
+	if !upstream.breaker.Allow() {
+		log.Debug("Circuit open for %s, rejecting %s %s", upstream.Name, r.Method, r.URL.Path)
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	
 	// Send request to backend service
-	resp, err := sp.client.Do(proxyReq)
+	resp, err := sp.send(r, upstream, proxyReq)
 	if err != nil {
-		sp.logger.Error("Backend request failed: %v", err)
+		log.Error("Backend request failed: %v", err)
+		reporting.CaptureError(r.Context(), fmt.Errorf("backend request to %s: %w", fullURL, err))
 		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
 		return
 	}
@@ -67,8 +116,62 @@ func (sp *ServiceProxy) ProxyRequest(w http.ResponseWriter, r *http.Request, tar
 	// Copy response body
 	_, err = io.Copy(w, resp.Body)
 	if err != nil {
-		sp.logger.Error("Failed to copy response body: %v", err)
+		log.Error("Failed to copy response body: %v", err)
+	}
+}
+
+// Fetch sends a GET for path to an upstream on behalf of r, with the same target selection,
+// circuit breaking and metrics as proxied requests; it is canceled with r's context
+// The caller closes the response body
+func (sp *ServiceProxy) Fetch(r *http.Request, upstream *Upstream, path string, header http.Header) (*http.Response, error) {
+	target := upstream.Target()
+	if target == "" {
+		return nil, ErrNoTargets
+	}
+	if !upstream.breaker.Allow() {
+		return nil, ErrCircuitOpen
+	}
+	
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	copyHeaders(header, req.Header)
+	requestid.Inject(r.Context(), req.Header)
+	
+	return sp.send(r, upstream, req)
+}
+
+// send performs req against upstream, tracking in-flight requests, metrics and the circuit breaker
+func (sp *ServiceProxy) send(r *http.Request, upstream *Upstream, req *http.Request) (*http.Response, error) {
+	traceID := tracing.TraceIDFromContext(r.Context())
+	upstream.inFlight.Add(1)
+	metrics.UpstreamRequestStarted(upstream.Name)
+	start := time.Now()
+	client := sp.client
+	if upstream.GRPC {
+		client = sp.grpcClient
+	}
+	resp, err := client.Do(req)
+	upstream.inFlight.Add(-1)
+	timing.Record(r.Context(), "upstream", time.Since(start))
+	upstream.recent.record(time.Now(), err != nil || resp.StatusCode >= http.StatusInternalServerError)
+	if err != nil {
+		metrics.UpstreamRequestFinished(upstream.Name, "failure", time.Since(start), traceID)
+		upstream.breaker.Failure()
+		return nil, err
+	}
+	
+	// Gateway-class errors from the backend count against its circuit
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		metrics.UpstreamRequestFinished(upstream.Name, "error", time.Since(start), traceID)
+		upstream.breaker.Failure()
+	default:
+		metrics.UpstreamRequestFinished(upstream.Name, "success", time.Since(start), traceID)
+		upstream.breaker.Success()
 	}
+	return resp, nil
 }
 
 // copyHeaders copies HTTP headers from source to destination
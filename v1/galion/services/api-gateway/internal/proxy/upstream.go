@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// errNoTargets is returned when service discovery has found no instances of an upstream
+var errNoTargets = errors.New("no upstream targets discovered")
+
+// Upstream is a backend service the gateway proxies to
+// URL is the configured backend; once service discovery reports targets they are used instead
+type Upstream struct {
+	Name       string
+	URL        string
+	HealthPath string
+	GRPC       bool // speaks gRPC over HTTP/2; proxied requests are gRPC-Web and health uses grpc.health.v1
+	breaker    *CircuitBreaker
+	healthy    atomic.Bool
+	inFlight   atomic.Int64
+	recent     errorWindow
+	targets    atomic.Pointer[[]string] // nil until discovery reports
+	next       atomic.Uint64
+}
+
+// Target returns the base URL to send the next request to, rotating through discovered targets
+// Returns "" if discovery is in use and has found no instances
+func (u *Upstream) Target() string {
+	targets := u.targets.Load()
+	if targets == nil {
+		return u.URL
+	}
+	if len(*targets) == 0 {
+		return ""
+	}
+	return (*targets)[(u.next.Add(1)-1)%uint64(len(*targets))]
+}
+
+// Targets returns the discovered targets, or nil if the upstream uses its configured URL
+func (u *Upstream) Targets() []string {
+	if targets := u.targets.Load(); targets != nil {
+		return *targets
+	}
+	return nil
+}
+
+// Healthy reports whether the last health check succeeded
+func (u *Upstream) Healthy() bool {
+	return u.healthy.Load()
+}
+
+// CircuitState returns the upstream's circuit breaker state
+func (u *Upstream) CircuitState() CircuitState {
+	return u.breaker.State()
+}
+
+// InFlight returns the number of requests currently proxied to the upstream
+func (u *Upstream) InFlight() int64 {
+	return u.inFlight.Load()
+}
+
+// RecentErrors returns the requests sent to the upstream in the last five minutes and how many of
+// them failed, either without a response or with a 5xx status
+func (u *Upstream) RecentErrors() (requests, errors int64) {
+	return u.recent.totals(time.Now())
+}
+
+// UpstreamConfig controls health checking and circuit breaking
+type UpstreamConfig struct {
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	BreakerThreshold    int           // consecutive failures before the circuit opens
+	BreakerOpenTimeout  time.Duration // how long the circuit stays open before a trial request
+}
+
+// Registry holds the gateway's upstreams and runs their health checks
+type Registry struct {
+	config     UpstreamConfig
+	client     *http.Client
+	grpcClient *http.Client
+	logger     *logger.Logger
+	mu         sync.RWMutex
+	upstreams  []*Upstream
+}
+
+// NewRegistry creates an empty upstream registry
+func NewRegistry(config UpstreamConfig, log *logger.Logger) *Registry {
+	return &Registry{
+		config: config,
+		client: &http.Client{Timeout: config.HealthCheckTimeout},
+		grpcClient: &http.Client{
+			Timeout:   config.HealthCheckTimeout,
+			Transport: newGRPCTransport(),
+		},
+		logger: log,
+	}
+}
+
+// Add registers an upstream, returning the existing one if the name is already registered
+func (reg *Registry) Add(name, url, healthPath string) *Upstream {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, u := range reg.upstreams {
+		if u.Name == name {
+			return u
+		}
+	}
+
+	u := &Upstream{
+		Name:       name,
+		URL:        url,
+		HealthPath: healthPath,
+	}
+	u.breaker = NewCircuitBreaker(reg.config.BreakerThreshold, reg.config.BreakerOpenTimeout, func(state CircuitState) {
+		reg.logger.Warn("Circuit for upstream %s is now %s", name, state)
+		metrics.SetUpstreamCircuitState(name, int(state))
+	})
+	metrics.SetUpstreamCircuitState(name, int(CircuitClosed))
+	metrics.SetUpstreamHealthy(name, false)
+
+	reg.upstreams = append(reg.upstreams, u)
+	return u
+}
+
+// SetTargets replaces an upstream's discovered targets
+func (reg *Registry) SetTargets(u *Upstream, targets []string) {
+	targets = append([]string{}, targets...)
+	previous := u.targets.Swap(&targets)
+	if previous == nil || len(*previous) != len(targets) {
+		reg.logger.Info("Upstream %s has %d discovered targets", u.Name, len(targets))
+	}
+	metrics.SetUpstreamTargets(u.Name, len(targets))
+}
+
+// Get returns an upstream by name, or nil if it isn't registered
+func (reg *Registry) Get(name string) *Upstream {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	for _, u := range reg.upstreams {
+		if u.Name == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// All returns every registered upstream
+func (reg *Registry) All() []*Upstream {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return append([]*Upstream(nil), reg.upstreams...)
+}
+
+// StartHealthChecks checks every upstream immediately and then on each interval until ctx is done
+func (reg *Registry) StartHealthChecks(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(reg.config.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			reg.checkAll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkAll probes every upstream concurrently
+func (reg *Registry) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range reg.All() {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			reg.check(ctx, u)
+		}(u)
+	}
+	wg.Wait()
+}
+
+// ProbeResult is the outcome of a single upstream health probe
+type ProbeResult struct {
+	URL        string
+	Healthy    bool
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// Probe requests an upstream's health endpoint once, on its next target
+// A 2xx response is healthy; the upstream's recorded health is not changed
+func (reg *Registry) Probe(ctx context.Context, u *Upstream) ProbeResult {
+	start := time.Now()
+
+	target := u.Target()
+	if target == "" {
+		return ProbeResult{Err: errNoTargets}
+	}
+	if u.GRPC {
+		return reg.probeGRPC(ctx, u, target, start)
+	}
+	probeURL := target + u.HealthPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return ProbeResult{URL: probeURL, Err: err}
+	}
+
+	resp, err := reg.client.Do(req)
+	if err != nil {
+		return ProbeResult{URL: probeURL, Latency: time.Since(start), Err: err}
+	}
+	resp.Body.Close()
+
+	return ProbeResult{
+		URL:        probeURL,
+		Healthy:    resp.StatusCode >= 200 && resp.StatusCode < 300,
+		StatusCode: resp.StatusCode,
+		Latency:    time.Since(start),
+	}
+}
+
+// check probes a single upstream's health endpoint and records the result
+func (reg *Registry) check(ctx context.Context, u *Upstream) {
+	healthy := reg.Probe(ctx, u).Healthy
+
+	if previous := u.healthy.Swap(healthy); previous != healthy {
+		if healthy {
+			reg.logger.Info("Upstream %s is healthy", u.Name)
+		} else {
+			reg.logger.Warn("Upstream %s is unhealthy", u.Name)
+		}
+	}
+	metrics.SetUpstreamHealthy(u.Name, healthy)
+}
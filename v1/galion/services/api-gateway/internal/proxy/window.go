@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// errorWindowMinutes is how far back RecentErrors looks
+const errorWindowMinutes = 5
+
+// errorWindow counts requests and errors in one-minute buckets covering the last few minutes
+type errorWindow struct {
+	mu      sync.Mutex
+	buckets [errorWindowMinutes]struct {
+		minute   int64
+		requests int64
+		errors   int64
+	}
+}
+
+func (w *errorWindow) record(now time.Time, failed bool) {
+	minute := now.Unix() / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[minute%errorWindowMinutes]
+	if b.minute != minute {
+		b.minute, b.requests, b.errors = minute, 0, 0
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+}
+
+func (w *errorWindow) totals(now time.Time) (requests, errors int64) {
+	minute := now.Unix() / 60
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, b := range w.buckets {
+		if minute-b.minute < errorWindowMinutes {
+			requests += b.requests
+			errors += b.errors
+		}
+	}
+	return requests, errors
+}
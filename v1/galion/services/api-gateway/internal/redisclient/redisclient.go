@@ -0,0 +1,109 @@
+// Package redisclient connects to Redis as a single server, through Sentinel, or as a Cluster
+package redisclient
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-common/logger"
+)
+
+// Config configures the Redis connection
+type Config struct {
+	// URL is redis:// or rediss:// (TLS); it also carries credentials and the database
+	URL string
+	// Mode is standalone, sentinel or cluster
+	Mode string
+	// Addrs are the Sentinel addresses, or the Cluster seed nodes (defaulting to the URL host)
+	Addrs            []string
+	SentinelMaster   string
+	SentinelPassword string
+	// Username and Password override the credentials in URL when set
+	Username     string
+	Password     string
+	TLSCAFile    string // CA bundle for rediss://; system roots if empty
+	PoolSize     int    // connections per node; 0 uses the go-redis default (10 per CPU)
+	MinIdleConns int
+	PoolTimeout  time.Duration
+}
+
+// New creates a client for the configured mode
+// It does not connect; the first command (or a Ping) does
+func New(config Config) (redis.UniversalClient, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	opts := &redis.UniversalOptions{
+		Addrs:            []string{u.Host},
+		Username:         u.User.Username(),
+		SentinelPassword: config.SentinelPassword,
+		MasterName:       config.SentinelMaster,
+		PoolSize:         config.PoolSize,
+		MinIdleConns:     config.MinIdleConns,
+		PoolTimeout:      config.PoolTimeout,
+	}
+	opts.Password, _ = u.User.Password()
+	if config.Username != "" {
+		opts.Username = config.Username
+	}
+	if config.Password != "" {
+		opts.Password = config.Password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		// ServerName is left empty so each node is verified against the address dialled
+		if opts.TLSConfig, err = logger.NewTLSConfig(config.TLSCAFile); err != nil {
+			return nil, fmt.Errorf("redis TLS: %w", err)
+		}
+	}
+
+	switch config.Mode {
+	case "", "standalone":
+		return redis.NewClient(opts.Simple()), nil
+	case "sentinel":
+		opts.Addrs = config.Addrs
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case "cluster":
+		if len(config.Addrs) > 0 {
+			opts.Addrs = config.Addrs
+		}
+		return redis.NewClusterClient(opts.Cluster()), nil
+	default:
+		return nil, fmt.Errorf("unknown Redis mode %q", config.Mode)
+	}
+}
+
+// Describe summarises the connection for startup logs, without credentials
+func Describe(config Config) string {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return config.Mode
+	}
+	tls := ""
+	if u.Scheme == "rediss" {
+		tls = " over TLS"
+	}
+	switch config.Mode {
+	case "sentinel":
+		return fmt.Sprintf("sentinel master %q via %s%s", config.SentinelMaster, strings.Join(config.Addrs, ","), tls)
+	case "cluster":
+		addrs := config.Addrs
+		if len(addrs) == 0 {
+			addrs = []string{u.Host}
+		}
+		return fmt.Sprintf("cluster %s%s", strings.Join(addrs, ","), tls)
+	default:
+		return fmt.Sprintf("%s%s%s", u.Host, u.Path, tls)
+	}
+}
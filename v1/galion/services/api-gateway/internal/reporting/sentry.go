@@ -0,0 +1,149 @@
+// Package reporting ships panics and server errors to Sentry (or a Sentry-compatible service)
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+
+	"nexus-common/logger"
+	"nexus-common/requestid"
+)
+
+// Config holds error reporting configuration
+type Config struct {
+	DSN         string
+	Environment string
+	Release     string
+	SampleRate  float64 // fraction of error events sent, 0.0-1.0
+}
+
+// enabled is set once Sentry has been initialized successfully
+var enabled bool
+
+// Init initializes the Sentry client
+// Reporting stays disabled (all calls become no-ops) when no DSN is configured
+func Init(config Config) error {
+	if config.DSN == "" {
+		return nil
+	}
+
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:              config.DSN,
+		Environment:      config.Environment,
+		Release:          config.Release,
+		SampleRate:       config.SampleRate,
+		AttachStacktrace: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize sentry: %w", err)
+	}
+
+	enabled = true
+	return nil
+}
+
+// Flush waits up to timeout for buffered events to be delivered
+func Flush(timeout time.Duration) {
+	if enabled {
+		sentry.Flush(timeout)
+	}
+}
+
+// requestState tracks whether an error was already reported for a request
+type requestState struct {
+	hub      *sentry.Hub
+	reported bool
+}
+
+type contextKey struct{}
+
+// CaptureError reports an error with the request context attached to ctx by Middleware
+// Requests whose error was captured are not reported a second time for their 5xx status
+func CaptureError(ctx context.Context, err error) {
+	state, ok := ctx.Value(contextKey{}).(*requestState)
+	if !ok {
+		return
+	}
+	state.hub.CaptureException(err)
+	state.reported = true
+}
+
+// Middleware returns middleware that recovers panics and reports them, along with
+// any 5xx response that wasn't already reported via CaptureError
+func Middleware(log *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var hub *sentry.Hub
+			if enabled {
+				hub = sentry.CurrentHub().Clone()
+				hub.Scope().SetRequest(r)
+				hub.Scope().SetTag("request_id", requestid.FromContext(r.Context()))
+			}
+
+			state := &requestState{hub: hub}
+			if enabled {
+				r = r.WithContext(context.WithValue(r.Context(), contextKey{}, state))
+			}
+
+			wrapped := &statusWriter{
+				ResponseWriter: w,
+				statusCode:     http.StatusOK,
+			}
+
+			defer func() {
+				if err := recover(); err != nil {
+					// An aborted handler isn't a failure; net/http ends the response quietly
+					if err == http.ErrAbortHandler {
+						panic(err)
+					}
+					log.WithContext(r.Context()).Error("Panic serving %s %s: %v", r.Method, r.URL.Path, err)
+					if hub != nil {
+						hub.Recover(err)
+					}
+					if !wrapped.wroteHeader {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusInternalServerError)
+						w.Write([]byte(`{"error":"internal server error"}`))
+					}
+					return
+				}
+
+				if hub != nil && wrapped.statusCode >= http.StatusInternalServerError && !state.reported {
+					hub.Scope().SetTag("status_code", fmt.Sprintf("%d", wrapped.statusCode))
+					hub.CaptureMessage(fmt.Sprintf("%s %s returned %d", r.Method, r.URL.Path, wrapped.statusCode))
+				}
+			}()
+
+			next.ServeHTTP(wrapped, r)
+		})
+	}
+}
+
+// statusWriter captures the status code and whether headers were sent
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+}
+
+// WriteHeader captures the status code
+func (sw *statusWriter) WriteHeader(code int) {
+	sw.statusCode = code
+	sw.wroteHeader = true
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write marks headers as sent
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush streamed responses
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,103 @@
+// Package restart provides zero-downtime binary upgrades by handing listening sockets to a new process
+package restart
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cloudflare/tableflip"
+
+	"nexus-common/logger"
+)
+
+// Restarter hands the gateway's listeners to a new process on SIGHUP
+// When disabled it listens normally and never exits on its own
+type Restarter struct {
+	upg    *tableflip.Upgrader
+	logger *logger.Logger
+}
+
+// New creates a restarter
+// pidFile, if set, is rewritten with the PID of the process currently serving,
+// so process supervisors can follow upgrades
+func New(enabled bool, pidFile string, upgradeTimeout time.Duration, log *logger.Logger) (*Restarter, error) {
+	r := &Restarter{logger: log}
+	if !enabled {
+		return r, nil
+	}
+
+	upg, err := tableflip.New(tableflip.Options{
+		PIDFile:        pidFile,
+		UpgradeTimeout: upgradeTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize restarts: %w", err)
+	}
+	r.upg = upg
+	return r, nil
+}
+
+// Listen returns a listener for addr, inherited from the previous process if it had one
+func (r *Restarter) Listen(network, addr string) (net.Listener, error) {
+	if r.upg == nil {
+		return net.Listen(network, addr)
+	}
+	return r.upg.Listen(network, addr)
+}
+
+// Ready signals that all listeners are serving, letting the previous process drain and exit,
+// and starts accepting SIGHUP to upgrade to the binary on disk
+func (r *Restarter) Ready() error {
+	if r.upg == nil {
+		return nil
+	}
+	if err := r.upg.Ready(); err != nil {
+		return fmt.Errorf("failed to signal readiness to parent process: %w", err)
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		for range sig {
+			r.logger.Info("Received SIGHUP, starting new gateway process")
+			if err := r.Upgrade(); err != nil {
+				r.logger.Error("Upgrade failed, continuing with current process: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Enabled reports whether restarts are possible
+func (r *Restarter) Enabled() bool {
+	return r.upg != nil
+}
+
+// Upgrade starts the binary on disk as a new process and waits for it to become ready
+// The new process reloads its configuration; on failure the current process keeps serving
+func (r *Restarter) Upgrade() error {
+	if r.upg == nil {
+		return fmt.Errorf("restarts are disabled (set GRACEFUL_RESTART_ENABLED=true)")
+	}
+	return r.upg.Upgrade()
+}
+
+// Exit is closed once a new process has taken over the listeners
+// It is nil (never ready) when restarts are disabled
+func (r *Restarter) Exit() <-chan struct{} {
+	if r.upg == nil {
+		return nil
+	}
+	return r.upg.Exit()
+}
+
+// Stop releases the restarter's resources; call it before exiting
+func (r *Restarter) Stop() {
+	if r.upg != nil {
+		r.upg.Stop()
+	}
+}
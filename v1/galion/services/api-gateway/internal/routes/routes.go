@@ -0,0 +1,304 @@
+// Package routes defines the gateway's route table
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Table is an ordered list of routes
+type Table []Route
+
+// Match returns the route with the longest path prefix matching path, or nil
+func (t Table) Match(path string) *Route {
+	var best *Route
+	for i := range t {
+		if strings.HasPrefix(path, t[i].PathPrefix) && (best == nil || len(t[i].PathPrefix) > len(best.PathPrefix)) {
+			best = &t[i]
+		}
+	}
+	return best
+}
+
+// Route maps a path prefix to a backend service
+type Route struct {
+	Name          string       `json:"name"`
+	PathPrefix    string       `json:"path_prefix"`
+	Backend       string       `json:"backend"`
+	Protocol      string       `json:"protocol,omitempty"` // http (default) or grpc
+	Discovery     *Discovery   `json:"discovery,omitempty"`
+	RequireAuth   bool         `json:"require_auth"`
+	HealthPath    string       `json:"health_path,omitempty"`  // backend health endpoint, defaults to /health
+	OpenAPIPath   string       `json:"openapi_path,omitempty"` // backend OpenAPI document, defaults to /openapi.json; "-" leaves the route out of /openapi.json
+	SLO           *SLO         `json:"slo,omitempty"`
+	LogSampling   *LogSampling `json:"log_sampling,omitempty"`
+	Policies      []Policy     `json:"policies,omitempty"`
+	Cache         *Cache       `json:"cache,omitempty"`
+	ErrorEnvelope bool         `json:"error_envelope,omitempty"` // rewrite 4xx and 5xx responses into the gateway's error envelope (see internal/envelope)
+}
+
+// Route protocols
+const (
+	ProtocolHTTP = "http" // requests are proxied as they are
+	ProtocolGRPC = "grpc" // gRPC-Web requests are translated to gRPC over HTTP/2 (see internal/proxy)
+)
+
+// Documented reports whether the route's backend serves an OpenAPI document for /openapi.json
+func (r Route) Documented() bool {
+	return r.OpenAPIPath != "-" && r.Protocol != ProtocolGRPC
+}
+
+// Discovery providers
+const (
+	DiscoveryKubernetes = "kubernetes" // Kubernetes Endpoints of a Service
+	DiscoveryConsul     = "consul"     // passing instances in the Consul catalog
+)
+
+// Discovery resolves a route's backend instances at runtime instead of using a fixed URL
+// The backend URL, if set, is used until discovery first reports
+type Discovery struct {
+	Provider  string `json:"provider"`
+	Service   string `json:"service"`
+	Namespace string `json:"namespace,omitempty"` // kubernetes, defaults to the gateway's namespace
+	Port      string `json:"port,omitempty"`      // kubernetes port name or number, required if the Service has several
+	Tag       string `json:"tag,omitempty"`       // consul, only instances with this tag
+	Scheme    string `json:"scheme,omitempty"`    // http (default) or https
+}
+
+// SLO declares a latency/availability objective for a route
+// A request is "good" if it completes without a 5xx status within Latency
+type SLO struct {
+	Objective float64  `json:"objective"` // target fraction of good requests, e.g. 0.99
+	Latency   Duration `json:"latency"`   // latency threshold, e.g. "300ms"
+}
+
+// LogSampling controls what fraction of a route's requests are logged
+// Unset rates default to 1 (log everything), so errors stay fully logged unless configured otherwise
+type LogSampling struct {
+	SuccessRate *float64 `json:"success_rate,omitempty"` // status < 400
+	ErrorRate   *float64 `json:"error_rate,omitempty"`   // status >= 400
+}
+
+// Rate returns the fraction of requests with the given status that should be logged
+func (ls *LogSampling) Rate(status int) float64 {
+	rate := ls.SuccessRate
+	if status >= 400 {
+		rate = ls.ErrorRate
+	}
+	if rate == nil {
+		return 1
+	}
+	return *rate
+}
+
+// Cache enables response caching for a route's GET requests (see internal/cache)
+// The backend's Cache-Control max-age, stale-while-revalidate and stale-if-error override these
+type Cache struct {
+	TTL                  Duration `json:"ttl"`                              // how long a response is fresh
+	StaleWhileRevalidate Duration `json:"stale_while_revalidate,omitempty"` // how long past TTL it is served while refreshed in the background
+	StaleIfError         Duration `json:"stale_if_error,omitempty"`         // how long past TTL it is served while the backend is failing
+	MaxBodyBytes         int      `json:"max_body_bytes,omitempty"`         // larger responses aren't cached, defaults to 1 MiB
+	Vary                 []string `json:"vary,omitempty"`                   // request headers that select separate entries
+}
+
+// Policy actions
+const (
+	PolicyBlock     = "block"      // reject the request with Status
+	PolicySetHeader = "set_header" // set Header to Value on the request sent to the backend
+	PolicyRoute     = "route"      // send the request to the backend of the route named Route
+)
+
+// Policy applies an action to requests matching an expression (see internal/policy)
+type Policy struct {
+	Name   string `json:"name"`
+	When   string `json:"when"`
+	Action string `json:"action"`
+	Status int    `json:"status,omitempty"` // block, defaults to 403
+	Header string `json:"header,omitempty"` // set_header
+	Value  string `json:"value,omitempty"`  // set_header
+	Route  string `json:"route,omitempty"`  // route
+}
+
+// Duration is a time.Duration that unmarshals from strings like "300ms"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"300ms\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON formats the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// Load reads a route table from a JSON file
+// Variables in backend URLs (e.g. "${USER_SERVICE_URL}") are expanded from vars,
+// falling back to the environment
+func Load(path string, vars map[string]string) (Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read route file: %w", err)
+	}
+
+	var file struct {
+		Routes Table `json:"routes"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse route file: %w", err)
+	}
+
+	for i := range file.Routes {
+		file.Routes[i].Backend = os.Expand(file.Routes[i].Backend, func(name string) string {
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			return os.Getenv(name)
+		})
+		if err := file.Routes[i].validate(); err != nil {
+			return nil, fmt.Errorf("route %d (%s): %w", i, file.Routes[i].Name, err)
+		}
+	}
+
+	// Policies may only route to routes defined in the same file
+	names := make(map[string]bool, len(file.Routes))
+	for _, route := range file.Routes {
+		names[route.Name] = true
+	}
+	for _, route := range file.Routes {
+		for _, p := range route.Policies {
+			if p.Action == PolicyRoute && !names[p.Route] {
+				return nil, fmt.Errorf("route %s: policy %s routes to unknown route %q", route.Name, p.Name, p.Route)
+			}
+		}
+	}
+
+	return file.Routes, nil
+}
+
+// validate checks that a route is usable
+func (r Route) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.PathPrefix == "" {
+		return fmt.Errorf("path_prefix is required")
+	}
+	if r.Backend == "" && r.Discovery == nil {
+		return fmt.Errorf("backend or discovery is required")
+	}
+	switch r.Protocol {
+	case "", ProtocolHTTP:
+	case ProtocolGRPC:
+		if r.HealthPath != "" {
+			return fmt.Errorf("health_path doesn't apply to grpc routes, which are checked with grpc.health.v1")
+		}
+		if r.Cache != nil {
+			return fmt.Errorf("cache doesn't apply to grpc routes")
+		}
+		if r.ErrorEnvelope {
+			return fmt.Errorf("error_envelope doesn't apply to grpc routes, whose errors are gRPC statuses")
+		}
+	default:
+		return fmt.Errorf("protocol must be %s or %s", ProtocolHTTP, ProtocolGRPC)
+	}
+	if r.Discovery != nil {
+		if err := r.Discovery.validate(); err != nil {
+			return fmt.Errorf("discovery: %w", err)
+		}
+	}
+	if r.OpenAPIPath != "" && r.OpenAPIPath != "-" && !strings.HasPrefix(r.OpenAPIPath, "/") {
+		return fmt.Errorf("openapi_path must start with / (or be - to leave the route out)")
+	}
+	if r.SLO != nil {
+		if r.SLO.Objective <= 0 || r.SLO.Objective >= 1 {
+			return fmt.Errorf("slo objective must be between 0 and 1 (exclusive)")
+		}
+		if r.SLO.Latency.Duration <= 0 {
+			return fmt.Errorf("slo latency must be positive")
+		}
+	}
+	if r.LogSampling != nil {
+		for _, rate := range []*float64{r.LogSampling.SuccessRate, r.LogSampling.ErrorRate} {
+			if rate != nil && (*rate < 0 || *rate > 1) {
+				return fmt.Errorf("log sampling rates must be between 0 and 1")
+			}
+		}
+	}
+	if r.Cache != nil {
+		if r.Cache.TTL.Duration <= 0 {
+			return fmt.Errorf("cache ttl must be positive")
+		}
+		if r.Cache.StaleWhileRevalidate.Duration < 0 || r.Cache.StaleIfError.Duration < 0 {
+			return fmt.Errorf("cache stale_while_revalidate and stale_if_error must not be negative")
+		}
+		if r.Cache.MaxBodyBytes < 0 {
+			return fmt.Errorf("cache max_body_bytes must not be negative")
+		}
+	}
+	for i, p := range r.Policies {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("policy %d (%s): %w", i, p.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the provider is known and the service is named
+func (d Discovery) validate() error {
+	switch d.Provider {
+	case DiscoveryKubernetes, DiscoveryConsul:
+	default:
+		return fmt.Errorf("provider must be %s or %s", DiscoveryKubernetes, DiscoveryConsul)
+	}
+	if d.Service == "" {
+		return fmt.Errorf("service is required")
+	}
+	switch d.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("scheme must be http or https")
+	}
+	return nil
+}
+
+// validate checks that a policy's action is complete; expressions are checked when compiled
+func (p Policy) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if p.When == "" {
+		return fmt.Errorf("when is required")
+	}
+	switch p.Action {
+	case PolicyBlock:
+		if p.Status != 0 && (p.Status < 400 || p.Status > 599) {
+			return fmt.Errorf("block status must be a 4xx or 5xx code")
+		}
+	case PolicySetHeader:
+		if p.Header == "" {
+			return fmt.Errorf("set_header requires header")
+		}
+	case PolicyRoute:
+		if p.Route == "" {
+			return fmt.Errorf("route requires route")
+		}
+	default:
+		return fmt.Errorf("action must be %s, %s or %s", PolicyBlock, PolicySetHeader, PolicyRoute)
+	}
+	return nil
+}
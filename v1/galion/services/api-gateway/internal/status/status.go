@@ -0,0 +1,116 @@
+// Package status serves a public summary of backend health for status pages
+// Each backend is operational, degraded or down according to its health checks, circuit breaker
+// and recent error rate; internal details such as URLs and errors are left out
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"nexus-api-gateway/internal/proxy"
+)
+
+// Path is where the summary is served
+const Path = "/status"
+
+// Service and overall states
+const (
+	Operational = "operational"
+	Degraded    = "degraded"
+	Down        = "down"
+)
+
+// Config sets how the summary is built and cached
+type Config struct {
+	CacheTTL          time.Duration // how long a summary is reused
+	DegradedErrorRate float64       // recent error rate at which a healthy backend is degraded
+	MinRequests       int64         // recent requests needed before the error rate counts
+}
+
+// Service is one backend's state
+type Service struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// Summary is the platform's state: down if every backend is down, degraded if any isn't operational
+type Summary struct {
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Services  []Service `json:"services"`
+}
+
+// Page builds and caches the summary
+type Page struct {
+	config    Config
+	upstreams *proxy.Registry
+
+	mu    sync.Mutex
+	body  []byte
+	built time.Time
+}
+
+// New returns a status page over the registry's upstreams
+func New(config Config, upstreams *proxy.Registry) *Page {
+	return &Page{config: config, upstreams: upstreams}
+}
+
+// Handler serves the cached summary
+func (p *Page) Handler(w http.ResponseWriter, r *http.Request) {
+	body, age := p.cached()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int((p.config.CacheTTL-age).Round(time.Second).Seconds())))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// cached returns the current summary and its age, rebuilding it once it is older than CacheTTL
+func (p *Page) cached() ([]byte, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.body == nil || time.Since(p.built) >= p.config.CacheTTL {
+		p.built = time.Now()
+		p.body, _ = json.Marshal(p.Summarize(p.built))
+		p.body = append(p.body, '\n')
+	}
+	return p.body, time.Since(p.built)
+}
+
+// Summarize reports the state of every backend
+func (p *Page) Summarize(now time.Time) Summary {
+	summary := Summary{Status: Operational, UpdatedAt: now.UTC()}
+	down := 0
+	for _, u := range p.upstreams.All() {
+		state := p.serviceStatus(u)
+		summary.Services = append(summary.Services, Service{Name: u.Name, Status: state})
+		if state == Down {
+			down++
+		}
+		if state != Operational {
+			summary.Status = Degraded
+		}
+	}
+	if down > 0 && down == len(summary.Services) {
+		summary.Status = Down
+	}
+	return summary
+}
+
+// serviceStatus is down while health checks fail or the circuit is open, and degraded while
+// the circuit is testing recovery or recent requests fail too often
+func (p *Page) serviceStatus(u *proxy.Upstream) string {
+	if !u.Healthy() || u.CircuitState() == proxy.CircuitOpen {
+		return Down
+	}
+	if u.CircuitState() == proxy.CircuitHalfOpen {
+		return Degraded
+	}
+	requests, errors := u.RecentErrors()
+	if requests >= p.config.MinRequests && float64(errors)/float64(requests) >= p.config.DegradedErrorRate {
+		return Degraded
+	}
+	return Operational
+}
@@ -0,0 +1,114 @@
+// Package timing reports where request latency is spent via the Server-Timing header
+package timing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// contextKey is the context key type for this package
+type contextKey struct{}
+
+// phase is a named, timed part of request handling
+type phase struct {
+	name     string
+	duration time.Duration
+}
+
+// Timings collects phase durations for a single request
+type Timings struct {
+	mu     sync.Mutex
+	start  time.Time
+	phases []phase
+}
+
+// Record adds a phase duration to the request's timings
+// It is a no-op if the timing middleware is not installed
+func Record(ctx context.Context, name string, d time.Duration) {
+	t, ok := ctx.Value(contextKey{}).(*Timings)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	t.phases = append(t.phases, phase{name: name, duration: d})
+	t.mu.Unlock()
+}
+
+// Middleware returns middleware that adds a Server-Timing header to responses
+// Recorded phases (auth, upstream) are reported alongside gateway, the remaining
+// time spent in the gateway itself, and total
+// Browsers only expose the timings to pages on allowedOrigins (via Timing-Allow-Origin)
+func Middleware(allowedOrigins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if origin := r.Header.Get("Origin"); origin != "" && (allowed[origin] || allowed["*"]) {
+				w.Header().Set("Timing-Allow-Origin", origin)
+			}
+
+			t := &Timings{start: time.Now()}
+			tw := &timingWriter{ResponseWriter: w, timings: t}
+			next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), contextKey{}, t)))
+		})
+	}
+}
+
+// header formats the timings measured so far
+func (t *Timings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := time.Since(t.start)
+	overhead := total
+	entries := make([]string, 0, len(t.phases)+2)
+	for _, p := range t.phases {
+		overhead -= p.duration
+		entries = append(entries, formatEntry(p.name, p.duration))
+	}
+	if overhead < 0 {
+		overhead = 0
+	}
+	entries = append(entries, formatEntry("gateway", overhead), formatEntry("total", total))
+	return strings.Join(entries, ", ")
+}
+
+// formatEntry formats a duration as a Server-Timing metric in milliseconds
+func formatEntry(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.1f", name, float64(d.Microseconds())/1000)
+}
+
+// timingWriter adds the Server-Timing header just before headers are sent
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *Timings
+	wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(code int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+		// Add rather than Set so an upstream's own Server-Timing entries are kept
+		tw.Header().Add("Server-Timing", tw.timings.header())
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timingWriter) Write(p []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush streamed responses
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
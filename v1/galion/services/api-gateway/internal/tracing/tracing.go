@@ -0,0 +1,79 @@
+// Package tracing propagates W3C trace context through the gateway
+// The gateway doesn't export spans itself; it continues (or starts) the caller's trace
+// and forwards it so backend spans and gateway metrics share a trace ID
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Header is the W3C trace context header
+const Header = "traceparent"
+
+type contextKey struct{}
+
+// Middleware returns middleware that continues or starts a trace for every request
+func Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceID, flags, ok := parse(r.Header.Get(Header))
+			if !ok {
+				traceID = randomHex(16)
+				flags = "01" // sampled
+			}
+
+			// The gateway is a new hop in the trace, so it gets its own span ID
+			r.Header.Set(Header, "00-"+traceID+"-"+randomHex(8)+"-"+flags)
+
+			ctx := context.WithValue(r.Context(), contextKey{}, traceID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// TraceIDFromContext returns the trace ID stored in ctx, or "" if tracing is disabled
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// parse extracts the trace ID and flags from a version 00 traceparent header
+func parse(header string) (traceID, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", false
+	}
+	// All-zero IDs are invalid per the spec
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[3], true
+}
+
+// isHex reports whether s is n lowercase hex characters
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns n random bytes hex-encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,143 @@
+package uploads
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config locates the bucket and the credentials URLs are signed with
+type S3Config struct {
+	Bucket          string
+	Region          string
+	Endpoint        string // S3-compatible endpoint such as MinIO, addressed path-style; AWS if empty
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // for temporary credentials
+}
+
+// presigner signs S3 request URLs with AWS Signature Version 4 query parameters
+type presigner struct {
+	config S3Config
+}
+
+// objectURL returns the URL of an object: virtual-hosted on AWS, path-style on custom endpoints
+func (p *presigner) objectURL(key string) *url.URL {
+	if p.config.Endpoint == "" {
+		return &url.URL{
+			Scheme: "https",
+			Host:   p.config.Bucket + ".s3." + p.config.Region + ".amazonaws.com",
+			Path:   "/" + key,
+		}
+	}
+	u, _ := url.Parse(strings.TrimSuffix(p.config.Endpoint, "/"))
+	u.Path += "/" + p.config.Bucket + "/" + key
+	return u
+}
+
+// presign returns a URL allowing method on key until now+expires, for requests carrying exactly
+// the given headers (e.g. Content-Length and Content-Type, so the upload's size and type are fixed)
+func (p *presigner) presign(method, key string, header http.Header, now time.Time, expires time.Duration) string {
+	u := p.objectURL(key)
+	now = now.UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + p.config.Region + "/s3/aws4_request"
+
+	signed := map[string]string{"host": u.Host}
+	for name := range header {
+		signed[strings.ToLower(name)] = strings.TrimSpace(header.Get(name))
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    p.config.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       strconv.Itoa(int(expires.Seconds())),
+		"X-Amz-SignedHeaders": signedHeaders,
+	}
+	if p.config.SessionToken != "" {
+		query["X-Amz-Security-Token"] = p.config.SessionToken
+	}
+	canonicalQuery := encodeQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		encodePath(u.Path),
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+p.config.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, p.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	u.RawPath = encodePath(u.Path)
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// encodeQuery returns the sorted, SigV4-encoded query string
+func encodeQuery(query map[string]string) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = uriEncode(name, true) + "=" + uriEncode(query[name], true)
+	}
+	return strings.Join(parts, "&")
+}
+
+func encodePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode percent-encodes everything but unreserved characters, as SigV4 requires;
+// slashes are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
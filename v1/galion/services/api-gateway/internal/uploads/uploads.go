@@ -0,0 +1,170 @@
+// Package uploads issues pre-signed S3 upload URLs to authenticated users
+// Large files go straight from the client to object storage, so they never pass through the
+// gateway; the gateway still decides who may upload, how much, of what type and where
+package uploads
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"nexus-common/logger"
+)
+
+// Path is where upload URLs are requested
+const Path = "/api/v1/uploads"
+
+// maxFilenameLength caps the file name kept in an object key
+const maxFilenameLength = 100
+
+var (
+	contentTypePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9!#$&^_.+-]*/[a-z0-9][a-z0-9!#$&^_.+-]*$`)
+	filenameUnsafe     = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// Config limits what may be uploaded
+type Config struct {
+	S3           S3Config
+	KeyPrefix    string        // prefix of every object key
+	URLTTL       time.Duration // how long an upload URL is valid
+	MaxBytes     int64         // largest upload
+	ContentTypes []string      // allowed content types, with type/* wildcards; any if empty
+}
+
+// Handler answers upload requests with pre-signed URLs
+type Handler struct {
+	config    Config
+	presigner *presigner
+	logger    *logger.Logger
+}
+
+// New returns an upload handler
+func New(config Config, log *logger.Logger) *Handler {
+	return &Handler{
+		config:    config,
+		presigner: &presigner{config: config.S3},
+		logger:    log,
+	}
+}
+
+// Request describes the file a client wants to upload
+type Request struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// Response tells the client how to upload the file
+// The upload must be a PUT to URL with exactly the given headers
+type Response struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"`
+	Key       string            `json:"key"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// ServeHTTP issues an upload URL for the authenticated user
+// It must be behind auth middleware, which sets X-User-Email
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "body must be a JSON object with filename, content_type and size")
+		return
+	}
+	req.ContentType = strings.ToLower(strings.TrimSpace(req.ContentType))
+	if err := h.validate(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user := r.Header.Get("X-User-Email")
+	key := h.objectKey(user, req.Filename)
+	header := http.Header{
+		"Content-Length": {strconv.FormatInt(req.Size, 10)},
+		"Content-Type":   {req.ContentType},
+	}
+	now := time.Now()
+	resp := Response{
+		Method: http.MethodPut,
+		URL:    h.presigner.presign(http.MethodPut, key, header, now, h.config.URLTTL),
+		Headers: map[string]string{
+			"Content-Length": header.Get("Content-Length"),
+			"Content-Type":   req.ContentType,
+		},
+		Key:       key,
+		ExpiresAt: now.Add(h.config.URLTTL).UTC(),
+	}
+
+	h.logger.WithContext(r.Context()).Info("Issued upload URL for %s (%s, %d bytes)", key, req.ContentType, req.Size)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false) // keep the URL's query separators readable
+	enc.Encode(resp)
+}
+
+func (h *Handler) validate(req Request) error {
+	if req.Size <= 0 {
+		return fmt.Errorf("size must be a positive number of bytes")
+	}
+	if req.Size > h.config.MaxBytes {
+		return fmt.Errorf("size must not exceed %d bytes", h.config.MaxBytes)
+	}
+	if !contentTypePattern.MatchString(req.ContentType) {
+		return fmt.Errorf("content_type must be a media type such as video/mp4")
+	}
+	if !h.allowedType(req.ContentType) {
+		return fmt.Errorf("content_type %s is not allowed", req.ContentType)
+	}
+	return nil
+}
+
+func (h *Handler) allowedType(contentType string) bool {
+	if len(h.config.ContentTypes) == 0 {
+		return true
+	}
+	major, _, _ := strings.Cut(contentType, "/")
+	for _, allowed := range h.config.ContentTypes {
+		if strings.EqualFold(allowed, contentType) || strings.EqualFold(allowed, major+"/*") {
+			return true
+		}
+	}
+	return false
+}
+
+// objectKey places an upload under the user's prefix with a random component, so users can
+// neither overwrite each other's objects nor guess their keys
+// The user is identified by a hash, keeping email addresses out of object keys
+func (h *Handler) objectKey(user, filename string) string {
+	userHash := sha256.Sum256([]byte(user))
+	random := make([]byte, 16)
+	rand.Read(random)
+	return h.config.KeyPrefix + hex.EncodeToString(userHash[:8]) + "/" + hex.EncodeToString(random) + "/" + safeFilename(filename)
+}
+
+func safeFilename(name string) string {
+	name = filenameUnsafe.ReplaceAllString(path.Base(strings.ReplaceAll(name, "\\", "/")), "_")
+	name = strings.TrimLeft(name, ".")
+	if len(name) > maxFilenameLength {
+		name = name[len(name)-maxFilenameLength:]
+	}
+	if name == "" || name == "_" {
+		return "file"
+	}
+	return name
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": "bad request", "message": message})
+}
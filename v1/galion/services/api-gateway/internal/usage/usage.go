@@ -0,0 +1,207 @@
+// Package usage counts each user's requests by route and day and reports them for the billing period
+// Counts live in Redis, one hash per user and day keyed by route name, so every replica adds to
+// the same totals and old days expire on their own
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-common/logger"
+)
+
+// Path is where the report is served
+const Path = "/api/v1/usage/report"
+
+// keyPrefix is followed by the user's email and the day, e.g. gateway:usage:a@example.com:2026-10-15
+const keyPrefix = "gateway:usage:"
+
+// dateLayout formats days in keys and reports
+const dateLayout = "2006-01-02"
+
+// recordTimeout bounds the Redis write of each counted request
+const recordTimeout = time.Second
+
+// queueSize is how many requests can wait to be counted before new ones are dropped
+const queueSize = 10000
+
+// Config configures counting and reporting
+type Config struct {
+	BillingDay int           // day of the month (1-28) billing periods start on, in UTC
+	Retention  time.Duration // how long daily counts are kept
+}
+
+// Meter records and reports per-user usage
+type Meter struct {
+	config  Config
+	client  redis.UniversalClient
+	logger  *logger.Logger
+	queue   chan hit
+	dropped atomic.Int64
+}
+
+// hit is a request waiting to be counted
+type hit struct {
+	user, route string
+	at          time.Time
+}
+
+// New returns a meter storing counts in client; call Start to count requests
+func New(config Config, client redis.UniversalClient, log *logger.Logger) *Meter {
+	return &Meter{config: config, client: client, logger: log, queue: make(chan hit, queueSize)}
+}
+
+// Start counts queued requests until ctx is done
+func (m *Meter) Start(ctx context.Context) {
+	go func() {
+		var reportedDrops int64
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case h := <-m.queue:
+				recordCtx, cancel := context.WithTimeout(ctx, recordTimeout)
+				if err := m.record(recordCtx, h.user, h.route, h.at); err != nil {
+					m.logger.Warn("Failed to record usage of %s by %s: %v", h.route, h.user, err)
+				}
+				cancel()
+			}
+			if dropped := m.dropped.Load(); dropped > reportedDrops {
+				m.logger.Warn("Dropped %d usage counts (queue full)", dropped-reportedDrops)
+				reportedDrops = dropped
+			}
+		}
+	}()
+}
+
+// Middleware counts requests to a route by the authenticated user
+// It must run after auth middleware, which sets X-User-Email; anonymous requests aren't counted
+func (m *Meter) Middleware(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+
+			user := r.Header.Get("X-User-Email")
+			if user == "" {
+				return
+			}
+			// Counted off the request path: a response isn't flushed until the handler returns, so
+			// writing to Redis here would hold it up; requests are dropped when the queue is full
+			select {
+			case m.queue <- hit{user: user, route: route, at: time.Now()}:
+			default:
+				m.dropped.Add(1)
+			}
+		})
+	}
+}
+
+func (m *Meter) record(ctx context.Context, user, route string, now time.Time) error {
+	key := dayKey(user, now)
+	pipe := m.client.Pipeline()
+	pipe.HIncrBy(ctx, key, route, 1)
+	pipe.Expire(ctx, key, m.config.Retention)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func dayKey(user string, day time.Time) string {
+	return keyPrefix + user + ":" + day.UTC().Format(dateLayout)
+}
+
+// Period is a billing period; both dates are inclusive
+type Period struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// Day is one day's request counts
+type Day struct {
+	Date   string           `json:"date"`
+	Total  int64            `json:"total"`
+	Routes map[string]int64 `json:"routes"`
+}
+
+// Report is a user's usage in the current billing period, up to today
+type Report struct {
+	Period Period           `json:"period"`
+	Total  int64            `json:"total"`
+	Routes map[string]int64 `json:"routes"`
+	Days   []Day            `json:"days"`
+}
+
+// period returns the first and last day of the billing period containing now
+func (m *Meter) period(now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	start := time.Date(now.Year(), now.Month(), m.config.BillingDay, 0, 0, 0, 0, time.UTC)
+	if now.Day() < m.config.BillingDay {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, -1)
+}
+
+// Report reads a user's counts for each day of the current billing period so far
+func (m *Meter) Report(ctx context.Context, user string, now time.Time) (*Report, error) {
+	now = now.UTC()
+	start, end := m.period(now)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	pipe := m.client.Pipeline()
+	var days []time.Time
+	var results []*redis.MapStringStringCmd
+	for day := start; !day.After(today); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+		results = append(results, pipe.HGetAll(ctx, dayKey(user, day)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Period: Period{Start: start.Format(dateLayout), End: end.Format(dateLayout)},
+		Routes: make(map[string]int64),
+		Days:   make([]Day, 0, len(days)),
+	}
+	for i, day := range days {
+		d := Day{Date: day.Format(dateLayout), Routes: make(map[string]int64)}
+		counts, err := results[i].Result()
+		if err != nil {
+			return nil, err
+		}
+		for route, value := range counts {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			d.Routes[route] = n
+			d.Total += n
+			report.Routes[route] += n
+		}
+		report.Total += d.Total
+		report.Days = append(report.Days, d)
+	}
+	return report, nil
+}
+
+// ReportHandler serves the authenticated user's report
+// It must be behind auth middleware, which sets X-User-Email
+func (m *Meter) ReportHandler(w http.ResponseWriter, r *http.Request) {
+	user := r.Header.Get("X-User-Email")
+	report, err := m.Report(r.Context(), user, time.Now())
+	if err != nil {
+		m.logger.WithContext(r.Context()).Error("Failed to read usage of %s: %v", user, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "service unavailable", "message": "usage could not be loaded"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// serviceNameHeader identifies the caller on the internal listener (set by service auth)
+const serviceNameHeader = "X-Service-Name"
+
+// EnqueueHandler accepts a delivery request from a backend
+// It answers 202 with the new delivery, or 200 with the existing one if the ID was already used
+func (d *Dispatcher) EnqueueHandler(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPayloadBytes+4096)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad request", "message": "body must be a JSON webhook request"})
+		return
+	}
+
+	delivery, created, err := d.Enqueue(r.Context(), req, r.Header.Get(serviceNameHeader))
+	if errors.Is(err, ErrInvalid) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad request", "message": err.Error()})
+		return
+	}
+	if err != nil {
+		d.logger.WithContext(r.Context()).Error("Failed to enqueue webhook delivery: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service unavailable", "message": "webhook delivery could not be queued"})
+		return
+	}
+	if !created {
+		writeJSON(w, http.StatusOK, delivery)
+		return
+	}
+	d.logger.WithContext(r.Context()).Info("Queued webhook delivery %s (%s) from %s", delivery.ID, delivery.Event, delivery.Source)
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+// StatusHandler serves a delivery and its attempts by ID
+func (d *Dispatcher) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	delivery, err := d.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		d.logger.WithContext(r.Context()).Error("Failed to load webhook delivery: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "service unavailable", "message": "webhook delivery could not be loaded"})
+		return
+	}
+	if delivery == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found", "message": "webhook delivery not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,75 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// consumerGroup is shared by every gateway replica, so each message is enqueued once
+const consumerGroup = "nexus-api-gateway-webhooks"
+
+// Consume enqueues the delivery requests published to topic until ctx is cancelled
+// Messages are JSON webhook requests; invalid ones are logged and skipped. Producers
+// should set an id so a message redelivered after a rebalance doesn't send twice
+func (d *Dispatcher) Consume(ctx context.Context, brokers []string, topic string) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: brokers,
+		Topic:   topic,
+		GroupID: consumerGroup,
+	})
+	d.closer = reader.Close
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			msg, err := reader.FetchMessage(ctx)
+			if err != nil {
+				if ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+					d.logger.Error("Failed to read webhook requests from %s: %v", topic, err)
+				}
+				return
+			}
+
+			var req Request
+			if err := json.Unmarshal(msg.Value, &req); err != nil {
+				d.logger.Warn("Skipping malformed webhook request at %s/%d offset %d: %v", topic, msg.Partition, msg.Offset, err)
+			} else if !d.enqueueMessage(ctx, topic, req) {
+				return
+			}
+
+			if err := reader.CommitMessages(ctx, msg); err != nil && ctx.Err() == nil {
+				d.logger.Error("Failed to commit webhook request offset on %s: %v", topic, err)
+			}
+		}
+	}()
+}
+
+// enqueueMessage enqueues a request read from Kafka, retrying while Redis is unavailable so the
+// message isn't committed before it is stored; it returns false if ctx was cancelled first
+func (d *Dispatcher) enqueueMessage(ctx context.Context, topic string, req Request) bool {
+	for {
+		delivery, created, err := d.Enqueue(ctx, req, "kafka")
+		switch {
+		case errors.Is(err, ErrInvalid):
+			d.logger.Warn("Skipping invalid webhook request from %s: %v", topic, err)
+			return true
+		case err == nil:
+			if created {
+				d.logger.Info("Queued webhook delivery %s (%s) from Kafka", delivery.ID, delivery.Event)
+			}
+			return true
+		}
+
+		d.logger.Error("Failed to enqueue webhook request from %s, retrying: %v", topic, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"nexus-common/version"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Nexus-Signature"
+	IDHeader        = "X-Nexus-Webhook-Id"
+	EventHeader     = "X-Nexus-Webhook-Event"
+	AttemptHeader   = "X-Nexus-Webhook-Attempt"
+)
+
+// maxPayloadBytes caps the size of a delivery's payload
+const maxPayloadBytes = 256 << 10
+
+var (
+	idPattern    = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+	eventPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+)
+
+// errPrivateTarget is returned when a customer URL resolves to an address the gateway may not call
+var errPrivateTarget = errors.New("target address is not public")
+
+func (req Request) validate() error {
+	if req.ID != "" && !idPattern.MatchString(req.ID) {
+		return fmt.Errorf("id must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if !eventPattern.MatchString(req.Event) {
+		return fmt.Errorf("event must be 1-128 letters, digits, '.', ':', '_' or '-'")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if u.User != nil {
+		return fmt.Errorf("url must not contain credentials")
+	}
+	if len(req.Payload) == 0 {
+		return fmt.Errorf("payload is required")
+	}
+	if len(req.Payload) > maxPayloadBytes {
+		return fmt.Errorf("payload must not exceed %d bytes", maxPayloadBytes)
+	}
+	return nil
+}
+
+// Sign returns the signature header value for a payload sent at t:
+// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<payload>">
+// Receivers recompute it with the shared secret and should reject old timestamps to stop replays
+func Sign(secret string, t time.Time, payload []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sender makes delivery requests
+type sender struct {
+	client *http.Client
+}
+
+// newSender returns a sender whose requests time out after timeout
+// Unless private targets are allowed, connections to loopback, private, link-local and other
+// non-public addresses are refused after DNS resolution, so customer URLs can't reach the cluster
+func newSender(timeout time.Duration, allowPrivate bool) *sender {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublic(ip) {
+				return errPrivateTarget
+			}
+			return nil
+		}
+	}
+	return &sender{
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+			// A redirect is answered as a failure; following it would bypass the URL the backend chose
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// result is the outcome of one attempt
+type result struct {
+	attempt    Attempt
+	delivered  bool
+	retryable  bool
+	retryAfter time.Duration
+}
+
+// send posts a delivery's payload to its URL
+// 2xx is delivered; network errors, timeouts, 408, 429 and 5xx are retried; anything else fails
+func (s *sender) send(ctx context.Context, d *Delivery, attempt int, secret string) result {
+	start := time.Now()
+	res := result{attempt: Attempt{At: start.UTC()}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		res.attempt.Error = err.Error()
+		return res
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Nexus-Webhooks/"+version.Get().Version)
+	req.Header.Set(IDHeader, d.ID)
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(AttemptHeader, strconv.Itoa(attempt))
+	req.Header.Set(SignatureHeader, Sign(secret, start, d.Payload))
+
+	resp, err := s.client.Do(req)
+	res.attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		if errors.Is(err, errPrivateTarget) {
+			res.attempt.Error = "target address is not public"
+			return res
+		}
+		res.attempt.Error = "request failed: " + describeError(err)
+		res.retryable = true
+		return res
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // let the connection be reused
+	res.attempt.StatusCode = resp.StatusCode
+	res.attempt.Response = string(body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		res.delivered = true
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		res.retryable = true
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			res.retryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return res
+}
+
+// describeError shortens transport errors, which repeat the method and URL
+func describeError(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, context.DeadlineExceeded) {
+		return "timed out"
+	}
+	return err.Error()
+}
@@ -0,0 +1,330 @@
+// Package webhooks delivers backend events to customer URLs
+// Backends enqueue deliveries on the internal listener or a Kafka topic. Deliveries are kept in
+// Redis, so any gateway replica can send them; each is signed with HMAC-SHA256 and retried with
+// exponential backoff until the customer answers 2xx or the attempts run out
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"nexus-api-gateway/pkg/metrics"
+
+	"nexus-common/logger"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// pollInterval is how often an idle worker looks for due deliveries
+const pollInterval = time.Second
+
+// maxResponseBytes caps how much of a customer's response is kept with an attempt
+const maxResponseBytes = 512
+
+// Config configures delivery
+type Config struct {
+	SigningSecret       string        // signs deliveries that don't carry their own secret
+	Workers             int           // deliveries sent at once by this replica
+	MaxAttempts         int           // attempts before a delivery fails
+	RetryBase           time.Duration // delay before the first retry, doubled for each later one
+	RetryMax            time.Duration // longest delay between attempts
+	Timeout             time.Duration // per-attempt request timeout
+	Retention           time.Duration // how long delivery records are kept
+	AllowPrivateTargets bool          // allow URLs resolving to loopback, private and link-local addresses
+}
+
+// Delivery is one event to be sent to one URL, and the outcome of each attempt
+type Delivery struct {
+	ID            string          `json:"id"`
+	Event         string          `json:"event"`
+	URL           string          `json:"url"`
+	Payload       json.RawMessage `json:"payload"`
+	Source        string          `json:"source,omitempty"` // enqueuing service, or "kafka"
+	Status        string          `json:"status"`
+	CreatedAt     time.Time       `json:"created_at"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at,omitempty"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+	Attempts      []Attempt       `json:"attempts"`
+}
+
+// Attempt is the outcome of one delivery attempt
+type Attempt struct {
+	At         time.Time `json:"at"`
+	DurationMs int64     `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Response   string    `json:"response,omitempty"` // start of the customer's response body
+	Error      string    `json:"error,omitempty"`
+}
+
+// record is a delivery as stored, with the secret it is signed with
+type record struct {
+	Delivery
+	Secret string `json:"secret,omitempty"`
+}
+
+// Dispatcher stores and sends deliveries
+type Dispatcher struct {
+	config Config
+	client redis.UniversalClient
+	sender *sender
+	logger *logger.Logger
+	prefix string
+
+	wg     sync.WaitGroup
+	closer func() error // stops the Kafka consumer, if any
+}
+
+// New returns a dispatcher storing deliveries in client; Start begins sending them
+func New(config Config, client redis.UniversalClient, log *logger.Logger) *Dispatcher {
+	return &Dispatcher{
+		config: config,
+		client: client,
+		sender: newSender(config.Timeout, config.AllowPrivateTargets),
+		logger: log,
+		prefix: "gateway:webhooks:",
+	}
+}
+
+// Start runs the delivery workers until ctx is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go d.work(ctx)
+	}
+}
+
+// Close waits for in-flight deliveries once the Start context is cancelled, then closes the Kafka consumer
+func (d *Dispatcher) Close() {
+	d.wg.Wait()
+	if d.closer != nil {
+		if err := d.closer(); err != nil {
+			d.logger.Error("Failed to close webhook consumer: %v", err)
+		}
+	}
+}
+
+// Request asks for an event to be delivered
+// A request repeating the ID of an existing delivery returns that delivery instead,
+// so producers can retry enqueueing safely
+type Request struct {
+	ID      string          `json:"id,omitempty"`
+	Event   string          `json:"event"`
+	URL     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+	Secret  string          `json:"secret,omitempty"` // signs this delivery instead of the gateway's secret
+}
+
+// ErrInvalid wraps problems with an enqueue request
+var ErrInvalid = errors.New("invalid webhook request")
+
+// Enqueue stores a delivery and schedules its first attempt, reporting whether it was new
+func (d *Dispatcher) Enqueue(ctx context.Context, req Request, source string) (*Delivery, bool, error) {
+	if err := req.validate(); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if req.ID == "" {
+		req.ID = newID()
+	}
+
+	now := time.Now().UTC()
+	rec := record{
+		Delivery: Delivery{
+			ID:            req.ID,
+			Event:         req.Event,
+			URL:           req.URL,
+			Payload:       req.Payload,
+			Source:        source,
+			Status:        StatusPending,
+			CreatedAt:     now,
+			NextAttemptAt: &now,
+			Attempts:      []Attempt{},
+		},
+		Secret: req.Secret,
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, false, err
+	}
+	created, err := d.client.SetNX(ctx, d.key(rec.ID), data, d.config.Retention).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if !created {
+		existing, err := d.Get(ctx, rec.ID)
+		if err != nil || existing == nil || existing.Status != StatusPending || len(existing.Attempts) > 0 {
+			return existing, false, err
+		}
+		// A retried request may follow one that was stored but never scheduled
+		err = d.client.ZAddNX(ctx, d.prefix+"due", redis.Z{Score: float64(now.UnixMilli()), Member: rec.ID}).Err()
+		return existing, false, err
+	}
+	if err := d.client.ZAdd(ctx, d.prefix+"due", redis.Z{Score: float64(now.UnixMilli()), Member: rec.ID}).Err(); err != nil {
+		return nil, false, err
+	}
+	return &rec.Delivery, true, nil
+}
+
+// Get returns a delivery by ID, or nil if there is none
+func (d *Dispatcher) Get(ctx context.Context, id string) (*Delivery, error) {
+	rec, err := d.load(ctx, id)
+	if rec == nil {
+		return nil, err
+	}
+	return &rec.Delivery, nil
+}
+
+func (d *Dispatcher) load(ctx context.Context, id string) (*record, error) {
+	data, err := d.client.Get(ctx, d.key(id)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("corrupt delivery %s: %w", id, err)
+	}
+	return &rec, nil
+}
+
+func (d *Dispatcher) save(ctx context.Context, rec *record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return d.client.Set(ctx, d.key(rec.ID), data, d.config.Retention).Err()
+}
+
+func (d *Dispatcher) key(id string) string {
+	return d.prefix + "delivery:" + id
+}
+
+// claimScript takes the next due delivery and pushes its due time out by a lease, so other
+// replicas skip it while it is being sent; if this replica dies the delivery comes due again
+var claimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #ids == 0 then return false end
+redis.call('ZADD', KEYS[1], ARGV[2], ids[1])
+return ids[1]
+`)
+
+func (d *Dispatcher) work(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		now := time.Now()
+		lease := now.Add(d.config.Timeout + 30*time.Second)
+		id, err := claimScript.Run(ctx, d.client, []string{d.prefix + "due"}, now.UnixMilli(), lease.UnixMilli()).Text()
+		if err != nil {
+			if err != redis.Nil && ctx.Err() == nil {
+				d.logger.Warn("Failed to claim webhook delivery: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		// In-flight deliveries finish even when the gateway is stopping
+		d.attempt(context.WithoutCancel(ctx), id)
+	}
+}
+
+// attempt sends a claimed delivery once and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, id string) {
+	log := d.logger.WithContext(ctx)
+	rec, err := d.load(ctx, id)
+	if err != nil {
+		log.Error("Failed to load webhook delivery %s: %v", id, err)
+		return
+	}
+	if rec == nil || rec.Status != StatusPending {
+		// Expired or already finished
+		d.client.ZRem(ctx, d.prefix+"due", id)
+		return
+	}
+
+	secret := rec.Secret
+	if secret == "" {
+		secret = d.config.SigningSecret
+	}
+	result := d.sender.send(ctx, &rec.Delivery, len(rec.Attempts)+1, secret)
+	rec.Attempts = append(rec.Attempts, result.attempt)
+
+	var next time.Time
+	switch {
+	case result.delivered:
+		now := time.Now().UTC()
+		rec.Status = StatusDelivered
+		rec.DeliveredAt = &now
+		rec.NextAttemptAt = nil
+		metrics.RecordWebhookAttempt(StatusDelivered)
+	case !result.retryable || len(rec.Attempts) >= d.config.MaxAttempts:
+		rec.Status = StatusFailed
+		rec.NextAttemptAt = nil
+		metrics.RecordWebhookAttempt(StatusFailed)
+		log.Warn("Webhook delivery %s (%s) failed after %d attempts: %s", rec.ID, rec.Event, len(rec.Attempts), describe(result.attempt))
+	default:
+		next = time.Now().Add(d.backoff(len(rec.Attempts), result.retryAfter)).UTC()
+		rec.NextAttemptAt = &next
+		metrics.RecordWebhookAttempt("retry")
+		log.Info("Webhook delivery %s (%s) attempt %d failed, retrying at %s: %s", rec.ID, rec.Event, len(rec.Attempts), next.Format(time.RFC3339), describe(result.attempt))
+	}
+
+	if err := d.save(ctx, rec); err != nil {
+		// Leave it due after the lease, so the attempt is repeated rather than lost
+		log.Error("Failed to save webhook delivery %s: %v", rec.ID, err)
+		return
+	}
+	if rec.Status == StatusPending {
+		d.client.ZAdd(ctx, d.prefix+"due", redis.Z{Score: float64(next.UnixMilli()), Member: rec.ID})
+	} else {
+		d.client.ZRem(ctx, d.prefix+"due", rec.ID)
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts: RetryBase doubled per
+// attempt, capped at RetryMax, with jitter so retries of a failing customer don't arrive together
+// A Retry-After from the customer is honored up to RetryMax
+func (d *Dispatcher) backoff(attempts int, retryAfter time.Duration) time.Duration {
+	delay := d.config.RetryBase
+	for i := 1; i < attempts && delay < d.config.RetryMax; i++ {
+		delay *= 2
+	}
+	delay = delay/2 + time.Duration(mathrand.Int63n(int64(delay/2)+1))
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return min(delay, d.config.RetryMax)
+}
+
+func describe(a Attempt) string {
+	if a.Error != "" {
+		return a.Error
+	}
+	return fmt.Sprintf("status %d", a.StatusCode)
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "whd_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// OverflowPath labels requests once a route has reached its path label limit
+const OverflowPath = "other"
+
+// idPlaceholder replaces path segments that look like identifiers
+const idPlaceholder = "{id}"
+
+var (
+	pathsMu          sync.Mutex
+	maxPathsPerRoute = 50
+	seenPaths        = make(map[string]map[string]struct{})
+)
+
+// SetMaxPathsPerRoute limits how many distinct path labels a route may produce
+func SetMaxPathsPerRoute(n int) {
+	pathsMu.Lock()
+	defer pathsMu.Unlock()
+	maxPathsPerRoute = n
+}
+
+// PathLabel returns the metric label for a request path
+// The path is normalized to a template, and once a route has produced
+// the maximum number of distinct templates further ones are labelled "other"
+func PathLabel(route, path string) string {
+	template := NormalizePath(path)
+
+	pathsMu.Lock()
+	defer pathsMu.Unlock()
+
+	paths, ok := seenPaths[route]
+	if !ok {
+		paths = make(map[string]struct{})
+		seenPaths[route] = paths
+	}
+	if _, ok := paths[template]; ok {
+		return template
+	}
+	if len(paths) >= maxPathsPerRoute {
+		PathLabelOverflow.WithLabelValues(route).Inc()
+		return OverflowPath
+	}
+	paths[template] = struct{}{}
+	return template
+}
+
+// NormalizePath replaces identifier-like segments with {id}
+//
+//	/api/v1/users/42/posts/3f2a...  ->  /api/v1/users/{id}/posts/{id}
+func NormalizePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = idPlaceholder
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isIdentifier reports whether a path segment looks like an ID rather than a fixed name
+// Matches numbers, UUIDs, hex digests, email addresses and long tokens containing digits
+func isIdentifier(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if strings.Contains(segment, "@") {
+		return true
+	}
+
+	digits := 0
+	for _, c := range segment {
+		if c >= '0' && c <= '9' {
+			digits++
+		}
+	}
+	if digits == len(segment) {
+		return true
+	}
+	return digits > 0 && len(segment) >= 16
+}
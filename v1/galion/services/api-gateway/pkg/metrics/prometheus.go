@@ -0,0 +1,298 @@
+// Package metrics provides Prometheus metrics
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Up reports that the gateway is running
+	Up = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "api_gateway_up",
+			Help: "API Gateway status",
+		},
+	)
+
+	// RequestsTotal counts proxied requests by route, path template, method and status
+	RequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_http_requests_total",
+			Help: "Total number of requests handled per route",
+		},
+		[]string{"route", "path", "method", "status"},
+	)
+
+	// RequestDuration measures request latency by route and path template
+	// Created by SetLatencyBuckets
+	RequestDuration *prometheus.HistogramVec
+
+	// SLORequests counts requests against each route's SLO
+	// result is "good" (no 5xx, within latency threshold) or "bad"
+	SLORequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_slo_requests_total",
+			Help: "Requests evaluated against the route SLO, by result (good/bad)",
+		},
+		[]string{"route", "result"},
+	)
+
+	// SLOObjective exposes each route's SLO target ratio for burn-rate alerts
+	SLOObjective = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_slo_objective_ratio",
+			Help: "Target fraction of good requests for the route SLO",
+		},
+		[]string{"route"},
+	)
+
+	// SLOLatencyThreshold exposes each route's SLO latency threshold
+	SLOLatencyThreshold = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_slo_latency_threshold_seconds",
+			Help: "Latency threshold for a request to count as good",
+		},
+		[]string{"route"},
+	)
+
+	// ClientErrors counts 4xx responses by route, status and failure reason
+	ClientErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_client_errors_total",
+			Help: "Client error (4xx) responses by route, status and reason",
+		},
+		[]string{"route", "status", "reason"},
+	)
+
+	// PathLabelOverflow counts requests whose path was labelled "other" by the cardinality guard
+	PathLabelOverflow = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_metrics_path_overflow_total",
+			Help: "Requests recorded with path=\"other\" because the route reached its path label limit",
+		},
+		[]string{"route"},
+	)
+
+	// LogLinesSampledOut counts request log lines skipped by route log sampling
+	LogLinesSampledOut = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_log_lines_sampled_out_total",
+			Help: "Request log lines not written because of route log sampling",
+		},
+		[]string{"route"},
+	)
+
+	// PolicyMatches counts requests matched by route policies, by policy and action
+	PolicyMatches = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_policy_matches_total",
+			Help: "Requests matched by a route policy",
+		},
+		[]string{"route", "policy", "action"},
+	)
+
+	// FeatureFlagEnabled reports feature flag states (1 on, 0 off), with route "all" for the global state
+	FeatureFlagEnabled = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_feature_flag_enabled",
+			Help: "Whether the feature flag is enabled, globally (route=\"all\") or for a route override",
+		},
+		[]string{"flag", "route"},
+	)
+
+	// UpstreamHealthy reports the last health check result per upstream (1 healthy, 0 unhealthy)
+	UpstreamHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_upstream_healthy",
+			Help: "Whether the upstream passed its last health check",
+		},
+		[]string{"upstream"},
+	)
+
+	// UpstreamCircuitState reports circuit breaker state per upstream (0 closed, 1 half-open, 2 open)
+	UpstreamCircuitState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_upstream_circuit_state",
+			Help: "Circuit breaker state per upstream (0 closed, 1 half-open, 2 open)",
+		},
+		[]string{"upstream"},
+	)
+
+	// UpstreamActiveRequests tracks requests currently proxied to each upstream
+	UpstreamActiveRequests = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_upstream_active_requests",
+			Help: "Number of in-flight requests to the upstream",
+		},
+		[]string{"upstream"},
+	)
+
+	// UpstreamTargets reports how many instances service discovery found per upstream
+	UpstreamTargets = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_upstream_targets",
+			Help: "Instances of the upstream found by service discovery",
+		},
+		[]string{"upstream"},
+	)
+
+	// CacheRequests counts cacheable requests by route and how they were served
+	CacheRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_cache_requests_total",
+			Help: "Cacheable requests by route and result (hit, stale, stale_if_error, miss)",
+		},
+		[]string{"route", "result"},
+	)
+
+	// WebhookAttempts counts webhook delivery attempts by outcome
+	WebhookAttempts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_webhook_attempts_total",
+			Help: "Webhook delivery attempts by result (delivered, retry, failed)",
+		},
+		[]string{"result"},
+	)
+
+	// UpstreamRequestDuration measures upstream response time
+	// Created by SetLatencyBuckets
+	UpstreamRequestDuration *prometheus.HistogramVec
+)
+
+func init() {
+	Up.Set(1)
+	SetLatencyBuckets(prometheus.DefBuckets)
+}
+
+// SetLatencyBuckets (re)creates the latency histograms with the given buckets in seconds
+// Call it at startup, before any requests are recorded
+func SetLatencyBuckets(buckets []float64) {
+	if RequestDuration != nil {
+		prometheus.Unregister(RequestDuration)
+	}
+	if UpstreamRequestDuration != nil {
+		prometheus.Unregister(UpstreamRequestDuration)
+	}
+
+	RequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_http_request_duration_seconds",
+			Help:    "Request duration in seconds per route",
+			Buckets: buckets,
+		},
+		[]string{"route", "path", "method"},
+	)
+	UpstreamRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gateway_upstream_request_duration_seconds",
+			Help:    "Time spent waiting for the upstream response in seconds",
+			Buckets: buckets,
+		},
+		[]string{"upstream", "outcome"},
+	)
+}
+
+// observe records a histogram observation, attaching a trace ID exemplar when one is available
+func observe(o prometheus.Observer, value float64, traceID string) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && traceID != "" {
+		eo.ObserveWithExemplar(value, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	o.Observe(value)
+}
+
+// RecordRequest records a completed request
+// path is normalized to a template and capped per route (see PathLabel)
+// traceID, if set, is attached to the latency observation as an exemplar
+func RecordRequest(route, path, method string, status int, duration time.Duration, traceID string) {
+	path = PathLabel(route, path)
+	RequestsTotal.WithLabelValues(route, path, method, strconv.Itoa(status)).Inc()
+	observe(RequestDuration.WithLabelValues(route, path, method), duration.Seconds(), traceID)
+}
+
+// RegisterSLO publishes a route's SLO target
+func RegisterSLO(route string, objective float64, latency time.Duration) {
+	SLOObjective.WithLabelValues(route).Set(objective)
+	SLOLatencyThreshold.WithLabelValues(route).Set(latency.Seconds())
+	// Initialize both series so rate() works before the first bad request
+	SLORequests.WithLabelValues(route, "good")
+	SLORequests.WithLabelValues(route, "bad")
+}
+
+// RecordSLO records a request as good or bad against its route SLO
+func RecordSLO(route string, good bool) {
+	result := "bad"
+	if good {
+		result = "good"
+	}
+	SLORequests.WithLabelValues(route, result).Inc()
+}
+
+// RecordClientError records a 4xx response and why the request was rejected
+func RecordClientError(route string, status int, reason string) {
+	ClientErrors.WithLabelValues(route, strconv.Itoa(status), reason).Inc()
+}
+
+// RecordLogSampledOut records a request log line skipped by sampling
+func RecordLogSampledOut(route string) {
+	LogLinesSampledOut.WithLabelValues(route).Inc()
+}
+
+// SetUpstreamHealthy records an upstream health check result
+func SetUpstreamHealthy(upstream string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	UpstreamHealthy.WithLabelValues(upstream).Set(value)
+}
+
+// RecordPolicyMatch records a request matched by a route policy
+func RecordPolicyMatch(route, policy, action string) {
+	PolicyMatches.WithLabelValues(route, policy, action).Inc()
+}
+
+// SetFeatureFlag records a feature flag state
+func SetFeatureFlag(flag, route string, enabled bool) {
+	value := 0.0
+	if enabled {
+		value = 1
+	}
+	FeatureFlagEnabled.WithLabelValues(flag, route).Set(value)
+}
+
+// SetUpstreamCircuitState records an upstream's circuit breaker state
+func SetUpstreamCircuitState(upstream string, state int) {
+	UpstreamCircuitState.WithLabelValues(upstream).Set(float64(state))
+}
+
+// SetUpstreamTargets records the number of discovered instances of an upstream
+func SetUpstreamTargets(upstream string, count int) {
+	UpstreamTargets.WithLabelValues(upstream).Set(float64(count))
+}
+
+// UpstreamRequestStarted increments the upstream's in-flight gauge
+func UpstreamRequestStarted(upstream string) {
+	UpstreamActiveRequests.WithLabelValues(upstream).Inc()
+}
+
+// UpstreamRequestFinished decrements the upstream's in-flight gauge and records its latency
+// outcome is "success", "error" (5xx gateway errors) or "failure" (connection failure)
+func UpstreamRequestFinished(upstream, outcome string, duration time.Duration, traceID string) {
+	UpstreamActiveRequests.WithLabelValues(upstream).Dec()
+	observe(UpstreamRequestDuration.WithLabelValues(upstream, outcome), duration.Seconds(), traceID)
+}
+
+// RecordCacheRequest records how a cacheable request was served
+func RecordCacheRequest(route, result string) {
+	CacheRequests.WithLabelValues(route, result).Inc()
+}
+
+// RecordWebhookAttempt records the outcome of a webhook delivery attempt
+func RecordWebhookAttempt(result string) {
+	WebhookAttempts.WithLabelValues(result).Inc()
+}
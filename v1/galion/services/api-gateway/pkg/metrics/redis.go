@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	redisPoolHits = prometheus.NewDesc(
+		"gateway_redis_pool_hits_total",
+		"Times a free Redis connection was found in the pool",
+		nil, nil,
+	)
+	redisPoolMisses = prometheus.NewDesc(
+		"gateway_redis_pool_misses_total",
+		"Times no free Redis connection was found in the pool and a new one was dialled",
+		nil, nil,
+	)
+	redisPoolTimeouts = prometheus.NewDesc(
+		"gateway_redis_pool_timeouts_total",
+		"Times waiting for a Redis connection exceeded REDIS_POOL_TIMEOUT",
+		nil, nil,
+	)
+	redisPoolStale = prometheus.NewDesc(
+		"gateway_redis_pool_stale_connections_total",
+		"Stale Redis connections removed from the pool",
+		nil, nil,
+	)
+	redisPoolConnections = prometheus.NewDesc(
+		"gateway_redis_pool_connections",
+		"Redis connections in the pool, by state (total, idle)",
+		[]string{"state"}, nil,
+	)
+)
+
+// redisPoolCollector reports connection pool statistics when scraped
+// For Sentinel and Cluster clients the statistics cover every node
+type redisPoolCollector struct {
+	client interface{ PoolStats() *redis.PoolStats }
+}
+
+// RegisterRedisPool exports the client's connection pool statistics
+// Call it once, at startup
+func RegisterRedisPool(client interface{ PoolStats() *redis.PoolStats }) {
+	prometheus.MustRegister(redisPoolCollector{client})
+}
+
+func (c redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- redisPoolHits
+	ch <- redisPoolMisses
+	ch <- redisPoolTimeouts
+	ch <- redisPoolStale
+	ch <- redisPoolConnections
+}
+
+func (c redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.client.PoolStats()
+	ch <- prometheus.MustNewConstMetric(redisPoolHits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(redisPoolMisses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(redisPoolTimeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(redisPoolStale, prometheus.CounterValue, float64(stats.StaleConns))
+	ch <- prometheus.MustNewConstMetric(redisPoolConnections, prometheus.GaugeValue, float64(stats.TotalConns), "total")
+	ch <- prometheus.MustNewConstMetric(redisPoolConnections, prometheus.GaugeValue, float64(stats.IdleConns), "idle")
+}
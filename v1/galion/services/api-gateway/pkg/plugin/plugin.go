@@ -0,0 +1,212 @@
+// Package plugin lets custom middleware be compiled into the gateway without forking it
+//
+// A plugin registers a factory from an init function, and is enabled by listing its name in PLUGINS:
+//
+//	func init() {
+//		plugin.Register("billing", func(log *logger.Logger) (plugin.Plugin, error) {
+//			return &billing{account: os.Getenv("BILLING_ACCOUNT")}, nil
+//		})
+//	}
+//
+// A plugin implements any of BeforeAuthHook, BeforeProxyHook and AfterResponseHook,
+// and io.Closer if it holds resources that must be released on shutdown
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"nexus-common/logger"
+)
+
+// Plugin is a gateway extension
+type Plugin interface {
+	Name() string
+}
+
+// Factory creates a plugin when the gateway starts
+type Factory func(log *logger.Logger) (Plugin, error)
+
+// BeforeAuthHook runs on routed requests before authentication
+// Return the request to continue with (r, or a copy with a new context), or nil after writing a response
+type BeforeAuthHook interface {
+	BeforeAuth(w http.ResponseWriter, r *http.Request, route string) *http.Request
+}
+
+// BeforeProxyHook runs after authentication, just before the request is proxied
+// Return the request to continue with (r, or a copy with a new context), or nil after writing a response
+type BeforeProxyHook interface {
+	BeforeProxy(w http.ResponseWriter, r *http.Request, route string) *http.Request
+}
+
+// AfterResponseHook runs once the response status is known, before headers are sent to the client
+// header may be modified
+type AfterResponseHook interface {
+	AfterResponse(r *http.Request, route string, status int, header http.Header)
+}
+
+var (
+	mu        sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a plugin available under name
+// It panics if name is registered twice, like database/sql drivers
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("plugin: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic("plugin: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// Registered returns the names of all compiled-in plugins, sorted
+func Registered() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain is the ordered list of enabled plugins
+type Chain struct {
+	plugins []Plugin
+	logger  *logger.Logger
+}
+
+// Load creates the named plugins in order
+func Load(names []string, log *logger.Logger) (*Chain, error) {
+	chain := &Chain{logger: log}
+	for _, name := range names {
+		mu.Lock()
+		factory, ok := factories[name]
+		mu.Unlock()
+		if !ok {
+			chain.Close()
+			return nil, fmt.Errorf("unknown plugin %q (compiled-in plugins: %v)", name, Registered())
+		}
+
+		p, err := factory(log)
+		if err != nil {
+			chain.Close()
+			return nil, fmt.Errorf("failed to initialize plugin %s: %w", name, err)
+		}
+		chain.plugins = append(chain.plugins, p)
+		log.Info("Loaded plugin %s", name)
+	}
+	return chain, nil
+}
+
+// BeforeAuth returns middleware running each plugin's BeforeAuth hook for route
+func (c *Chain) BeforeAuth(route string) func(http.Handler) http.Handler {
+	var hooks []BeforeAuthHook
+	for _, p := range c.plugins {
+		if h, ok := p.(BeforeAuthHook); ok {
+			hooks = append(hooks, h)
+		}
+	}
+	return requestHooks(len(hooks), func(i int, w http.ResponseWriter, r *http.Request) *http.Request {
+		return hooks[i].BeforeAuth(w, r, route)
+	})
+}
+
+// BeforeProxy returns middleware running each plugin's BeforeProxy hook for route
+func (c *Chain) BeforeProxy(route string) func(http.Handler) http.Handler {
+	var hooks []BeforeProxyHook
+	for _, p := range c.plugins {
+		if h, ok := p.(BeforeProxyHook); ok {
+			hooks = append(hooks, h)
+		}
+	}
+	return requestHooks(len(hooks), func(i int, w http.ResponseWriter, r *http.Request) *http.Request {
+		return hooks[i].BeforeProxy(w, r, route)
+	})
+}
+
+// AfterResponse returns middleware running each plugin's AfterResponse hook for route
+func (c *Chain) AfterResponse(route string) func(http.Handler) http.Handler {
+	var hooks []AfterResponseHook
+	for _, p := range c.plugins {
+		if h, ok := p.(AfterResponseHook); ok {
+			hooks = append(hooks, h)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		if len(hooks) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(&hookWriter{ResponseWriter: w, request: r, route: route, hooks: hooks}, r)
+		})
+	}
+}
+
+// Close releases plugins that implement io.Closer, in reverse load order
+func (c *Chain) Close() {
+	for i := len(c.plugins) - 1; i >= 0; i-- {
+		if closer, ok := c.plugins[i].(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				c.logger.Error("Failed to close plugin %s: %v", c.plugins[i].Name(), err)
+			}
+		}
+	}
+}
+
+// requestHooks returns middleware calling n request hooks in order, stopping at the first that returns nil
+func requestHooks(n int, call func(i int, w http.ResponseWriter, r *http.Request) *http.Request) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if n == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < n; i++ {
+				if r = call(i, w, r); r == nil {
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hookWriter runs AfterResponse hooks just before headers are sent
+type hookWriter struct {
+	http.ResponseWriter
+	request     *http.Request
+	route       string
+	hooks       []AfterResponseHook
+	wroteHeader bool
+}
+
+func (hw *hookWriter) WriteHeader(code int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		for _, h := range hw.hooks {
+			h.AfterResponse(hw.request, hw.route, code, hw.Header())
+		}
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *hookWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush streamed responses
+func (hw *hookWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
@@ -0,0 +1,171 @@
+// Package health provides the liveness, readiness and shutdown-drain endpoints shared by the services
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds each dependency check performed by a readiness request
+const checkTimeout = time.Second
+
+// Check is the result of a single readiness check
+type Check struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// CheckFunc reports whether one dependency is ready
+type CheckFunc func(ctx context.Context) Check
+
+// Ping adapts a connectivity test (Redis or database ping) to a CheckFunc
+func Ping(ping func(ctx context.Context) error) CheckFunc {
+	return func(ctx context.Context) Check {
+		if err := ping(ctx); err != nil {
+			return Check{Status: "failing", Message: err.Error()}
+		}
+		return Check{Status: "ok"}
+	}
+}
+
+// Checker reports whether a service is alive and ready to serve traffic
+type Checker struct {
+	service    string
+	mu         sync.RWMutex
+	checks     map[string]CheckFunc
+	draining   atomic.Bool
+	drainOnce  sync.Once
+	drainStart time.Time
+}
+
+// NewChecker creates a checker for the named service with no readiness checks
+func NewChecker(service string) *Checker {
+	return &Checker{service: service, checks: make(map[string]CheckFunc)}
+}
+
+// AddCheck makes readiness depend on check, reported under name
+func (c *Checker) AddCheck(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Service returns the name reported by the health endpoints
+func (c *Checker) Service() string {
+	return c.service
+}
+
+// HealthHandler reports that the service is up, for load balancers and compose health checks
+func (c *Checker) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, map[string]string{
+		"status":  "healthy",
+		"service": c.service,
+	})
+}
+
+// LiveHandler reports that the process is running
+// It has no dependencies so a slow dependency never gets the service restarted
+func (c *Checker) LiveHandler(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, map[string]string{
+		"status":  "alive",
+		"service": c.service,
+	})
+}
+
+// SetDraining marks the service as shutting down so readiness fails from now on
+func (c *Checker) SetDraining() {
+	c.drainOnce.Do(func() {
+		c.drainStart = time.Now()
+		c.draining.Store(true)
+	})
+}
+
+// Draining reports whether the service is shutting down
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Drain fails readiness and waits until it has been failing for delay,
+// giving load balancers (e.g. Kubernetes endpoints) time to stop routing here
+// Calls after the first only wait for what is left of the delay
+func (c *Checker) Drain(delay time.Duration) {
+	c.SetDraining()
+	if wait := delay - time.Since(c.drainStart); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// PreStopHandler serves the Kubernetes preStop hook: it drains for delay before responding,
+// so by the time SIGTERM arrives no new requests are being routed to the service
+func (c *Checker) PreStopHandler(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.Drain(delay)
+		WriteJSON(w, http.StatusOK, map[string]string{"status": "shutting_down"})
+	}
+}
+
+// ReadyHandler reports whether the service can serve traffic
+// Returns 503 while shutting down or if any check fails; checks run concurrently
+func (c *Checker) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if c.Draining() {
+		WriteJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+			"status": "shutting_down",
+		})
+		return
+	}
+
+	checks := c.Run(r.Context())
+	ready := true
+	for _, check := range checks {
+		if check.Status != "ok" {
+			ready = false
+		}
+	}
+
+	status := http.StatusOK
+	body := map[string]interface{}{
+		"status": "ready",
+		"checks": checks,
+	}
+	if !ready {
+		status = http.StatusServiceUnavailable
+		body["status"] = "not_ready"
+	}
+	WriteJSON(w, status, body)
+}
+
+// Run performs every readiness check concurrently, each bounded by a short timeout
+func (c *Checker) Run(ctx context.Context) map[string]Check {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]Check, len(c.checks))
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			result := check(checkCtx)
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// WriteJSON writes an uncacheable JSON response with the given status code
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"encoding/json"
+)
+
+const (
+	// gelfMaxUDPSize is the largest GELF message sent over UDP without chunking
+	gelfMaxUDPSize = 8192
+	// gelfTruncatedSuffix marks a message shortened to fit a datagram
+	gelfTruncatedSuffix = "...[truncated]"
+)
+
+// gelfMessage is a GELF 1.1 payload
+type gelfMessage struct {
+	Version      string  `json:"version"`
+	Host         string  `json:"host"`
+	ShortMessage string  `json:"short_message"`
+	Timestamp    float64 `json:"timestamp"`
+	Level        int     `json:"level"`
+	Service      string  `json:"_service"`
+	LevelName    string  `json:"_level_name"`
+	PID          int     `json:"_pid"`
+	RequestID    string  `json:"_request_id,omitempty"`
+}
+
+// NewGELFOutput creates an output that ships log lines to Graylog as GELF
+// Over tcp and tls messages are null-byte delimited; over udp they are sent
+// uncompressed and truncated to fit a single datagram
+func NewGELFOutput(config RemoteConfig) (Output, error) {
+	return newRemoteOutput("gelf", config, encodeGELF)
+}
+
+// encodeGELF formats an entry as a GELF message
+func encodeGELF(o *remoteOutput, e Entry) []byte {
+	msg := gelfMessage{
+		Version:      "1.1",
+		Host:         o.hostname,
+		ShortMessage: e.Message,
+		Timestamp:    float64(e.Time.UnixMicro()) / 1e6,
+		Level:        severity(e.Level),
+		Service:      o.config.App,
+		LevelName:    e.Level,
+		PID:          pid,
+		RequestID:    e.RequestID,
+	}
+
+	b, _ := json.Marshal(msg)
+	if o.config.Network == "udp" {
+		short := e.Message
+		for len(b) > gelfMaxUDPSize && short != "" {
+			cut := len(b) - gelfMaxUDPSize + len(gelfTruncatedSuffix)
+			if cut > len(short) {
+				cut = len(short)
+			}
+			short = short[:len(short)-cut]
+			msg.ShortMessage = short + gelfTruncatedSuffix
+			b, _ = json.Marshal(msg)
+		}
+		return b
+	}
+	return append(b, 0)
+}
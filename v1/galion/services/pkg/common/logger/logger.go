@@ -0,0 +1,123 @@
+// Package logger provides structured logging utilities
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"nexus-common/requestid"
+)
+
+// Logger represents a structured logger
+type Logger struct {
+	debug     bool
+	requestID string
+	outputs   *outputs
+}
+
+// Entry is a single log line as passed to additional outputs
+type Entry struct {
+	Time      time.Time
+	Level     string
+	Message   string
+	RequestID string
+}
+
+// Output receives every log line in addition to stdout
+// Write must not block the caller
+type Output interface {
+	Write(entry Entry)
+	Close() error
+}
+
+// outputs is the set of additional outputs shared by a logger and its derived loggers
+type outputs struct {
+	mu   sync.RWMutex
+	list []Output
+}
+
+// New creates a new logger instance
+func New(debug bool) *Logger {
+	return &Logger{debug: debug, outputs: &outputs{}}
+}
+
+// AddOutput sends every subsequent log line to o as well as stdout
+func (l *Logger) AddOutput(o Output) {
+	l.outputs.mu.Lock()
+	defer l.outputs.mu.Unlock()
+	l.outputs.list = append(l.outputs.list, o)
+}
+
+// Close flushes and closes all additional outputs
+func (l *Logger) Close() {
+	l.outputs.mu.Lock()
+	list := l.outputs.list
+	l.outputs.list = nil
+	l.outputs.mu.Unlock()
+
+	for _, o := range list {
+		if err := o.Close(); err != nil {
+			log.Printf("failed to close log output: %v", err)
+		}
+	}
+}
+
+// WithContext returns a logger that tags every line with the request ID in ctx
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return l
+	}
+	return &Logger{debug: l.debug, requestID: id, outputs: l.outputs}
+}
+
+// Info logs an informational message
+func (l *Logger) Info(format string, v ...interface{}) {
+	l.log("INFO", format, v...)
+}
+
+// Error logs an error message
+func (l *Logger) Error(format string, v ...interface{}) {
+	l.log("ERROR", format, v...)
+}
+
+// Debug logs a debug message (only if debug mode is enabled)
+func (l *Logger) Debug(format string, v ...interface{}) {
+	if l.debug {
+		l.log("DEBUG", format, v...)
+	}
+}
+
+// Warn logs a warning message
+func (l *Logger) Warn(format string, v ...interface{}) {
+	l.log("WARN", format, v...)
+}
+
+// log is the internal logging function
+func (l *Logger) log(level string, format string, v ...interface{}) {
+	now := time.Now()
+	message := fmt.Sprintf(format, v...)
+	
+	l.outputs.mu.RLock()
+	for _, o := range l.outputs.list {
+		o.Write(Entry{Time: now, Level: level, Message: message, RequestID: l.requestID})
+	}
+	l.outputs.mu.RUnlock()
+	
+	if l.requestID != "" {
+		message = "request_id=" + l.requestID + " " + message
+	}
+	log.Printf("[%s] %s: %s", now.Format("2006-01-02 15:04:05"), level, message)
+}
+
+// Fatal logs a fatal error and exits the program
+func (l *Logger) Fatal(format string, v ...interface{}) {
+	l.log("FATAL", format, v...)
+	l.Close()
+	os.Exit(1)
+}
+
@@ -0,0 +1,201 @@
+package logger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// remoteQueueSize is the number of log lines buffered per remote output before dropping
+	remoteQueueSize = 10000
+	// remoteDialTimeout bounds connecting to a log server
+	remoteDialTimeout = 5 * time.Second
+	// remoteWriteTimeout bounds writing a single log line
+	remoteWriteTimeout = 5 * time.Second
+	// remoteCloseTimeout bounds flushing queued lines on Close
+	remoteCloseTimeout = 5 * time.Second
+)
+
+// pid is reported to log servers with every line
+var pid = os.Getpid()
+
+// RemoteConfig configures a network log output
+type RemoteConfig struct {
+	Network string      // "udp", "tcp" or "tls"
+	Addr    string      // host:port of the log server
+	TLS     *tls.Config // used when Network is "tls"
+	App     string      // application name reported to the log server
+}
+
+// remoteOutput ships encoded log lines to a log server from a background goroutine
+// Lines are dropped when the queue is full or the server is unreachable, so logging never blocks requests
+type remoteOutput struct {
+	name     string
+	config   RemoteConfig
+	hostname string
+	encode   func(Entry) []byte
+
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan Entry
+	done    chan struct{}
+	conn    net.Conn
+	failing bool
+}
+
+// newRemoteOutput validates the config and starts the output's sender
+func newRemoteOutput(name string, config RemoteConfig, encode func(o *remoteOutput, e Entry) []byte) (*remoteOutput, error) {
+	switch config.Network {
+	case "udp", "tcp", "tls":
+	default:
+		return nil, fmt.Errorf("unsupported %s network %q (want udp, tcp or tls)", name, config.Network)
+	}
+	if config.Addr == "" {
+		return nil, fmt.Errorf("%s address is required", name)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	o := &remoteOutput{
+		name:     name,
+		config:   config,
+		hostname: hostname,
+		queue:    make(chan Entry, remoteQueueSize),
+		done:     make(chan struct{}),
+	}
+	o.encode = func(e Entry) []byte { return encode(o, e) }
+
+	go o.run()
+	return o, nil
+}
+
+// Write queues a log line, dropping it if the queue is full
+func (o *remoteOutput) Write(entry Entry) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.closed {
+		return
+	}
+	select {
+	case o.queue <- entry:
+	default:
+	}
+}
+
+// Close flushes queued lines and closes the connection
+func (o *remoteOutput) Close() error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	close(o.queue)
+	o.mu.Unlock()
+
+	select {
+	case <-o.done:
+		return nil
+	case <-time.After(remoteCloseTimeout):
+		return fmt.Errorf("timed out flushing %s output", o.name)
+	}
+}
+
+// run sends queued lines until the queue is closed
+func (o *remoteOutput) run() {
+	defer close(o.done)
+
+	for entry := range o.queue {
+		o.send(o.encode(entry))
+	}
+	if o.conn != nil {
+		o.conn.Close()
+	}
+}
+
+// send writes one encoded line, reconnecting if needed
+func (o *remoteOutput) send(b []byte) {
+	if o.conn == nil {
+		conn, err := o.dial()
+		if err != nil {
+			o.setFailing(err)
+			return
+		}
+		o.conn = conn
+	}
+
+	o.conn.SetWriteDeadline(time.Now().Add(remoteWriteTimeout))
+	if _, err := o.conn.Write(b); err != nil {
+		o.conn.Close()
+		o.conn = nil
+		o.setFailing(err)
+		return
+	}
+	o.setFailing(nil)
+}
+
+// dial connects to the log server
+func (o *remoteOutput) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: remoteDialTimeout}
+	if o.config.Network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", o.config.Addr, o.config.TLS)
+	}
+	return dialer.Dial(o.config.Network, o.config.Addr)
+}
+
+// setFailing reports transitions between delivering and failing to stderr
+// The gateway logger itself can't be used here without recursing into this output
+func (o *remoteOutput) setFailing(err error) {
+	if err != nil && !o.failing {
+		log.Printf("%s output %s unavailable, dropping log lines: %v", o.name, o.config.Addr, err)
+	} else if err == nil && o.failing {
+		log.Printf("%s output %s recovered", o.name, o.config.Addr)
+	}
+	o.failing = err != nil
+}
+
+// NewTLSConfig returns a TLS config for log outputs
+// If caFile is set, the server certificate is verified against it instead of the system roots
+func NewTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// severity maps a log level to its syslog/GELF severity
+func severity(level string) int {
+	switch level {
+	case "FATAL":
+		return 2
+	case "ERROR":
+		return 3
+	case "WARN":
+		return 4
+	case "DEBUG":
+		return 7
+	default:
+		return 6
+	}
+}
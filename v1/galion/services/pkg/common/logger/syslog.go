@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// syslogFacility is local0
+const syslogFacility = 16
+
+// NewSyslogOutput creates an output that ships log lines to a syslog server
+// Lines are formatted per RFC 5424; over tcp and tls they are framed with octet counting (RFC 6587)
+func NewSyslogOutput(config RemoteConfig) (Output, error) {
+	return newRemoteOutput("syslog", config, encodeSyslog)
+}
+
+// encodeSyslog formats an entry as an RFC 5424 message
+func encodeSyslog(o *remoteOutput, e Entry) []byte {
+	structured := "-"
+	if e.RequestID != "" {
+		structured = fmt.Sprintf(`[meta request_id="%s"]`, escapeSDParam(e.RequestID))
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - %s %s",
+		syslogFacility*8+severity(e.Level),
+		e.Time.UTC().Format(time.RFC3339Nano),
+		o.hostname,
+		o.config.App,
+		pid,
+		structured,
+		e.Message,
+	)
+
+	if o.config.Network == "udp" {
+		return []byte(msg)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+// escapeSDParam escapes a structured data parameter value
+func escapeSDParam(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
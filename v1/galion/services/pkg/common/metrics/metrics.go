@@ -0,0 +1,35 @@
+// Package metrics bootstraps the Prometheus metrics every service exports
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"nexus-common/version"
+)
+
+// RegisterBuildInfo exports <namespace>_build_info, a constant 1 labelled with the running build
+func RegisterBuildInfo(namespace string) {
+	info := version.Get()
+	promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "build_info",
+			Help:      "Build information of the running service",
+		},
+		[]string{"version", "git_sha", "build_time", "go_version"},
+	).WithLabelValues(info.Version, info.GitSHA, info.BuildTime, info.GoVersion).Set(1)
+}
+
+// Handler serves the default registry, in OpenMetrics format when asked so exemplars are included
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: true,
+		}),
+	)
+}
@@ -0,0 +1,60 @@
+// Package requestid generates request IDs and carries them in request contexts
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header carrying the request ID
+const Header = "X-Request-ID"
+
+// maxLength bounds client-supplied request IDs so they can't bloat logs
+const maxLength = 128
+
+type contextKey struct{}
+
+// New generates a new request ID
+// UUIDv7 IDs are time-ordered, so they sort by creation time in logs and storage
+func New() string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// Valid reports whether a client-supplied request ID is safe to reuse
+// Only printable ASCII without spaces is accepted, to keep log lines unambiguous
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID stored in ctx, or "" if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Inject sets the request ID from ctx on an outgoing request's headers, so the
+// downstream service logs it too; headers are left alone if ctx has no ID
+func Inject(ctx context.Context, h http.Header) {
+	if id := FromContext(ctx); id != "" {
+		h.Set(Header, id)
+	}
+}
@@ -0,0 +1,114 @@
+package settings
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// envPrefix namespaces the gateway's environment variables, e.g. NEXUS_RATE_LIMIT_ENABLED
+// Unprefixed names are still read; the prefixed one wins when both are set
+const envPrefix = "NEXUS_"
+
+// readEnv collects settings from environ (KEY=value pairs, as from os.Environ)
+// Empty variables count as unset. Also returns a problem for every NEXUS_ variable
+// that matches no setting, so typos don't silently fall back to defaults
+func readEnv(fields []Field, environ []string) (map[string]string, []string) {
+	known := make(map[string]bool, len(fields)+1)
+	for _, f := range fields {
+		known[f.Name] = true
+	}
+	known["CONFIG_FILE"] = true
+
+	plain := make(map[string]string)
+	prefixed := make(map[string]string)
+	var unknown []string
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		if name, ok := strings.CutPrefix(key, envPrefix); ok {
+			if !known[name] {
+				unknown = append(unknown, unknownEnvProblem(key, fields))
+				continue
+			}
+			if value != "" {
+				prefixed[name] = value
+			}
+			continue
+		}
+		if known[key] && value != "" {
+			plain[key] = value
+		}
+	}
+
+	for name, value := range prefixed {
+		plain[name] = value
+	}
+	sort.Strings(unknown)
+	return plain, unknown
+}
+
+// unknownEnvProblem describes an unrecognized NEXUS_ variable, suggesting the closest setting
+func unknownEnvProblem(key string, fields []Field) string {
+	problem := fmt.Sprintf("%s (from env): unknown setting", key)
+	if suggestion := closestSetting(strings.TrimPrefix(key, envPrefix), fields); suggestion != "" {
+		problem += fmt.Sprintf(", did you mean %s%s?", envPrefix, suggestion)
+	}
+	return problem
+}
+
+// closestSetting returns the setting sharing the most (similar) words with name
+// (RATE_LIMIT_PER_MIN -> RATE_LIMIT_REQUESTS_PER_MINUTE), or "" if none shares at least half of them
+// Ties go to the setting with the fewest words
+func closestSetting(name string, fields []Field) string {
+	words := strings.Split(name, "_")
+	best, bestShared, bestLen := "", 0, 0
+	for _, f := range fields {
+		candidate := strings.Split(f.Name, "_")
+		shared := 0
+		for _, w := range words {
+			for _, c := range candidate {
+				if w != "" && similarWord(w, c) {
+					shared++
+					break
+				}
+			}
+		}
+		if shared > bestShared || (shared == bestShared && shared > 0 && len(candidate) < bestLen) {
+			best, bestShared, bestLen = f.Name, shared, len(candidate)
+		}
+	}
+	if bestShared*2 < len(words) {
+		return ""
+	}
+	return best
+}
+
+// similarWord reports whether word w of a typed name plausibly means word c of a setting:
+// equal, an abbreviation of it (MIN, MINUTE) or one edit away (SECRT, SECRET)
+func similarWord(w, c string) bool {
+	if w == c || (len(w) >= 3 && strings.HasPrefix(c, w)) {
+		return true
+	}
+	return len(w) >= 4 && editDistance(w, c) <= 1
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
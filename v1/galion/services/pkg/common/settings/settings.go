@@ -0,0 +1,282 @@
+// Package settings loads service configuration from flags, environment variables, a config file,
+// per-environment profiles and defaults, tracking where every value came from
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Field describes one setting
+// Name is its environment variable; the flag (--rate-limit-enabled) and
+// config file key (rate_limit_enabled) are derived from it
+type Field struct {
+	Name    string
+	Default string
+	Usage   string
+	Value   flag.Value
+	Redact  func(string) string
+}
+
+// Options describes a service's settings
+type Options struct {
+	// Program names the flag set in usage output
+	Program string
+	Fields  []Field
+	// Profiles override defaults per ENVIRONMENT value; unset means no profiles
+	Profiles map[string]map[string]string
+	// Aliases maps short ENVIRONMENT names (prod) to profile names (production)
+	Aliases map[string]string
+	// Strict, if it ends up true, makes unknown NEXUS_ variables problems rather than warnings
+	Strict *bool
+}
+
+// Set is a loaded configuration: where each value came from, and how to print it
+type Set struct {
+	// ConfigFile is the JSON config file that was loaded, if any
+	ConfigFile string
+	// ValidateOnly is set by --validate-config
+	ValidateOnly bool
+	// Warnings lists problems that don't stop the service (unknown NEXUS_ variables unless strict)
+	Warnings []string
+
+	fields  []Field
+	sources map[string]string
+}
+
+// Load sets every field from command-line args, the environment and an optional config file
+// Precedence: flags > environment variables (NEXUS_ prefixed first) > config file (--config or CONFIG_FILE) > ENVIRONMENT profile > defaults
+// Values that can't be parsed are returned as problems; an error means loading couldn't continue
+// Returns flag.ErrHelp if -h or --help was given
+func Load(opts Options, args []string) (*Set, []string, error) {
+	s := &Set{fields: opts.Fields, sources: make(map[string]string)}
+
+	// Collect flags first; they are applied last so they override everything else
+	fs := flag.NewFlagSet(opts.Program, flag.ContinueOnError)
+	configFile := os.Getenv(envPrefix + "CONFIG_FILE")
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	fs.StringVar(&s.ConfigFile, "config", configFile, "JSON config file")
+	fs.BoolVar(&s.ValidateOnly, "validate-config", false, "Print the effective configuration and exit")
+	flags := make(map[string]string)
+	for _, f := range s.fields {
+		name := f.Name
+		set := func(v string) error {
+			flags[name] = v
+			return nil
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			fs.BoolFunc(flagName(name), f.Usage, set)
+		} else {
+			fs.Func(flagName(name), f.Usage, set)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var problems []string
+	var file map[string]string
+	if s.ConfigFile != "" {
+		values, unknown, err := readFile(s.ConfigFile, s.fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		problems = append(problems, unknown...)
+		file = values
+	}
+
+	env, unknownEnv := readEnv(s.fields, os.Environ())
+
+	for _, f := range s.fields {
+		if f.Default != "" {
+			if err := f.Value.Set(f.Default); err != nil {
+				return nil, nil, fmt.Errorf("invalid default for %s: %w", f.Name, err)
+			}
+		}
+		s.sources[f.Name] = "default"
+	}
+
+	// The ENVIRONMENT picks the profile, so resolve it before applying anything else
+	if opts.Profiles != nil {
+		environment := s.Raw("ENVIRONMENT")
+		for _, values := range []map[string]string{flags, env, file} {
+			if v, ok := values["ENVIRONMENT"]; ok {
+				environment = v
+				break
+			}
+		}
+		problems = append(problems, s.apply(opts.Profiles[canonical(environment, opts.Aliases)], "profile")...)
+	}
+
+	problems = append(problems, s.apply(file, "file")...)
+	problems = append(problems, s.apply(env, "env")...)
+	problems = append(problems, s.apply(flags, "flag")...)
+
+	if opts.Aliases != nil {
+		if f, ok := s.field("ENVIRONMENT"); ok {
+			f.Value.Set(canonical(f.Value.String(), opts.Aliases))
+		}
+	}
+	if opts.Strict != nil && *opts.Strict {
+		problems = append(problems, unknownEnv...)
+	} else {
+		s.Warnings = append(s.Warnings, unknownEnv...)
+	}
+	return s, problems, nil
+}
+
+// ValidationError lists every invalid setting found while loading
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d invalid setting(s):\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Problem describes an invalid setting, quoting its (redacted) value and where it came from
+func (s *Set) Problem(name, format string, args ...interface{}) string {
+	return fmt.Sprintf("%s=%q (from %s): %s", name, s.Raw(name), s.sources[name], fmt.Sprintf(format, args...))
+}
+
+// Source returns where a setting's value came from: default, profile, file, env or flag
+func (s *Set) Source(name string) string {
+	return s.sources[name]
+}
+
+// Raw returns a setting's value as a string, with secrets redacted
+func (s *Set) Raw(name string) string {
+	f, ok := s.field(name)
+	if !ok {
+		return ""
+	}
+	v := f.Value.String()
+	if f.Redact != nil && v != "" {
+		v = f.Redact(v)
+	}
+	return v
+}
+
+// Print writes the effective configuration with the source of each value
+// Secrets are redacted
+func (s *Set) Print(w io.Writer) {
+	if s.ConfigFile != "" {
+		fmt.Fprintf(w, "# config file: %s\n", s.ConfigFile)
+	}
+	for _, f := range s.fields {
+		fmt.Fprintf(w, "%s=%s  # %s\n", f.Name, s.Raw(f.Name), s.sources[f.Name])
+	}
+}
+
+// field looks up a field by setting name
+func (s *Set) field(name string) (Field, bool) {
+	for _, f := range s.fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// apply sets fields from values keyed by setting name, recording where each came from
+// Returns a problem for every value that can't be parsed
+func (s *Set) apply(values map[string]string, source string) []string {
+	var problems []string
+	for _, f := range s.fields {
+		v, ok := values[f.Name]
+		if !ok {
+			continue
+		}
+		if err := f.Value.Set(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s=%q (from %s): %v", f.Name, v, source, err))
+			continue
+		}
+		s.sources[f.Name] = source
+	}
+	return problems
+}
+
+// canonical resolves an ENVIRONMENT alias to its profile name
+func canonical(environment string, aliases map[string]string) string {
+	environment = strings.ToLower(strings.TrimSpace(environment))
+	if name, ok := aliases[environment]; ok {
+		return name
+	}
+	return environment
+}
+
+// readFile reads a JSON config file keyed by lower-case setting name (e.g. "rate_limit_enabled")
+// Unknown keys and values of the wrong JSON type are returned as problems
+func readFile(path string, fields []Field) (map[string]string, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, f := range fields {
+		known[f.Name] = true
+	}
+
+	values := make(map[string]string, len(raw))
+	var problems []string
+	for key, v := range raw {
+		name := strings.ToUpper(key)
+		if !known[name] {
+			problems = append(problems, fmt.Sprintf("%q (from file): unknown setting", key))
+			continue
+		}
+		s, err := fileValue(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (from file): %v", name, err))
+			continue
+		}
+		values[name] = s
+	}
+	sort.Strings(problems)
+	return values, problems, nil
+}
+
+// fileValue converts a JSON value to the string form used by flags and environment variables
+// Arrays become comma-separated lists
+func fileValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return "", errors.New("must be a string, number, boolean or array")
+	}
+}
+
+// flagName converts a setting name to its flag name (RATE_LIMIT_ENABLED -> rate-limit-enabled)
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
@@ -0,0 +1,113 @@
+package settings
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each value type implements flag.Value so the same field can be set from a flag,
+// an environment variable or the config file
+
+// String returns a value that stores into p
+func String(p *string) flag.Value { return stringValue{p} }
+
+// Bool returns a value that stores into p; its flag may be given without a value
+func Bool(p *bool) flag.Value { return boolValue{p} }
+
+// Int returns a value that stores into p
+func Int(p *int) flag.Value { return intValue{p} }
+
+// Float returns a value that stores into p
+func Float(p *float64) flag.Value { return floatValue{p} }
+
+// Duration returns a value that stores into p
+func Duration(p *time.Duration) flag.Value { return durationValue{p} }
+
+// Slice returns a comma-separated list value that stores into p
+func Slice(p *[]string) flag.Value { return sliceValue{p} }
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string     { return *v.p }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	*v.p = b
+	return nil
+}
+func (v boolValue) String() string   { return strconv.FormatBool(*v.p) }
+func (v boolValue) IsBoolFlag() bool { return true }
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("must be an integer")
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+type floatValue struct{ p *float64 }
+
+func (v floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("must be a number")
+	}
+	*v.p = f
+	return nil
+}
+func (v floatValue) String() string { return strconv.FormatFloat(*v.p, 'g', -1, 64) }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("must be a duration such as 500ms, 30s or 5m")
+	}
+	*v.p = d
+	return nil
+}
+func (v durationValue) String() string { return v.p.String() }
+
+// sliceValue is a comma-separated list
+type sliceValue struct{ p *[]string }
+
+func (v sliceValue) Set(s string) error {
+	*v.p = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v.p = append(*v.p, item)
+		}
+	}
+	return nil
+}
+func (v sliceValue) String() string { return strings.Join(*v.p, ",") }
+
+// RedactSecret hides a secret entirely
+func RedactSecret(string) string {
+	return "[REDACTED]"
+}
+
+// RedactURL hides the password in a connection URL
+func RedactURL(v string) string {
+	u, err := url.Parse(v)
+	if err != nil {
+		return "[REDACTED]"
+	}
+	return u.Redacted()
+}
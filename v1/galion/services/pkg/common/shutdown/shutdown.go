@@ -0,0 +1,40 @@
+// Package shutdown stops a service's HTTP servers gracefully
+package shutdown
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"nexus-common/logger"
+)
+
+// Notify returns a channel receiving SIGINT and SIGTERM
+func Notify() <-chan os.Signal {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	return quit
+}
+
+// Servers stops accepting connections on every server and waits, concurrently, for in-flight requests until ctx is done
+// Connections still open at the deadline are closed
+func Servers(ctx context.Context, log *logger.Logger, servers ...*http.Server) {
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		if srv == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Error("Shutdown timeout reached with requests in flight on %s, closing connections: %v", srv.Addr, err)
+				srv.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,44 @@
+// Package version holds build information injected at link time
+//
+//	go build -ldflags "-X nexus-common/version.Version=v1.2.3 \
+//	  -X nexus-common/version.GitSHA=$(git rev-parse HEAD) \
+//	  -X nexus-common/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// Set via -ldflags at build time
+var (
+	Version   = "dev"
+	GitSHA    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	GitSHA    string `json:"git_sha"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the running build's information
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitSHA:    GitSHA,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// Handler serves the build information as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(Get())
+}